@@ -2,196 +2,227 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
-type outStruct struct {
-	Zone           string `json:"zone"`
-	Path           string `json:"path"`
-	Md5            string `json:"md5"`
-	DetectionName  string `json:"detection_name"`
-	LastDetectDate string `json:"last_detect_date"`
-	Error          string `json:"error,omitempty"`
-}
+// Verdicts reported by threat-intelligence providers, ordered by severity in verdictSeverity.
+const (
+	VERDICT_MALICIOUS  = "malicious"
+	VERDICT_SUSPICIOUS = "suspicious"
+	VERDICT_CLEAN      = "clean"
+	VERDICT_UNKNOWN    = "unknown"
+)
 
-// Client wraps an HTTP client and API key for OpenTIP.
-type Client struct {
-	httpClient *http.Client
-	apiKey     string
+var verdictSeverity = map[string]int{
+	VERDICT_UNKNOWN:    0,
+	VERDICT_CLEAN:      1,
+	VERDICT_SUSPICIOUS: 2,
+	VERDICT_MALICIOUS:  3,
 }
 
-// HashResponse represents the fields returned by OpenTIP for a hash lookup.
-type HashResponse struct {
-	Zone           string `json:"Zone"`
-	DetectionName  string `json:"DetectionName"`
-	LastDetectDate string `json:"LastDetectDate"`
+// outStruct is one line of analysis.jsonl: the file, the merged verdict and the result of every provider.
+// Zone, DetectionName and LastDetectDate keep the OpenTIP-shaped fields of earlier versions.
+type outStruct struct {
+	Zone           string            `json:"zone"`
+	Path           string            `json:"path"`
+	Md5            string            `json:"md5"`
+	Sha1           string            `json:"sha1,omitempty"`
+	Sha256         string            `json:"sha256,omitempty"`
+	Verdict        string            `json:"verdict,omitempty"`
+	DetectionName  string            `json:"detection_name"`
+	LastDetectDate string            `json:"last_detect_date"`
+	Providers      []*ProviderResult `json:"providers,omitempty"`
+	Error          string            `json:"error,omitempty"`
 }
 
-// NewClient creates a new OpenTIP client, reading the API key from the environment.
-func NewClient(apiKey string) (*Client, error) {
-	if apiKey == "" {
-		return nil, fmt.Errorf("KASPERSKY_API_KEY not set")
+// flagged reports whether the merged result deserves an analyst's attention.
+func (r *outStruct) flagged() bool {
+	if r.Verdict == VERDICT_MALICIOUS || r.Verdict == VERDICT_SUSPICIOUS {
+		return true
 	}
-	return &Client{
-		httpClient: &http.Client{Timeout: 30 * time.Second},
-		apiKey:     apiKey,
-	}, nil
+	return r.Zone != "" && !strings.EqualFold(r.Zone, "Green") && !strings.EqualFold(r.Zone, "Grey")
 }
 
-// LookupHash queries OpenTIP for the given MD5 hash and returns the parsed response.
-func (c *Client) LookupHash(hash string) (*HashResponse, error) {
-	url := fmt.Sprintf("https://opentip.kaspersky.com/api/v1/search/hash?request=%s", hash)
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Add("X-API-KEY", c.apiKey)
-	// if dump, derr := httputil.DumpRequestOut(req, false); derr == nil {
-	// 	logger.Log(LevelDebug, fmt.Sprintf("HTTP Request:\n%s", dump))
-	// }
-
-	var resp *http.Response
-	var attemptErr error
-	for i := 1; i <= 3; i++ {
-		resp, attemptErr = c.httpClient.Do(req)
-		if attemptErr != nil {
-			var netErr net.Error
-			if errors.As(attemptErr, &netErr) && netErr.Timeout() {
-				logger.Log(LevelWarning, fmt.Sprintf("Timeout on attempt %d for %s, retrying...", i, hash))
-				time.Sleep(time.Duration(i) * time.Second)
-				continue
-			}
-			return nil, attemptErr
-		}
-		break
-	}
-	if attemptErr != nil {
-		return nil, fmt.Errorf("after retries: %w", attemptErr)
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	logger.Log(LevelDebug, fmt.Sprintf("OpenTIP Response for %s:\n%s", hash, body))
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %s", resp.Status)
-	}
+// ProviderResult is the answer of a single provider for one file.
+type ProviderResult struct {
+	Provider       string `json:"provider"`
+	Verdict        string `json:"verdict,omitempty"`
+	Zone           string `json:"zone,omitempty"`
+	DetectionName  string `json:"detection_name,omitempty"`
+	LastDetectDate string `json:"last_detect_date,omitempty"`
+	Detections     int    `json:"detections,omitempty"`
+	Engines        int    `json:"engines,omitempty"`
+	Link           string `json:"link,omitempty"`
+	Cached         bool   `json:"cached,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
 
-	var hr HashResponse
-	if err := json.Unmarshal(body, &hr); err != nil {
-		return nil, err
-	}
-	return &hr, nil
+// Provider looks a file up in a threat-intelligence source by its hashes.
+// hashes holds the "file.hash" map of a FILE_INFO record (md5, sha1, sha256, ...);
+// a provider picks the strongest hash it supports.
+type Provider interface {
+	Name() string
+	Lookup(hashes map[string]string) (*ProviderResult, error)
 }
 
-// AnalysisQueue manages a buffered queue of artifacts to analyze.
-type AnalysisQueue struct {
-	client      *Client
-	queue       chan map[string]interface{}
-	resultsFile *os.File
+// Client wraps an HTTP client shared by a provider: it waits for the provider's rate limit,
+// retries timeouts and server errors with exponential backoff, and honours Retry-After on
+// 429 Too Many Requests and 503 Service Unavailable.
+type Client struct {
+	httpClient *http.Client
+	limiter    *tokenBucket
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	sleep      func(time.Duration)
 }
 
-// NewQueue creates a new AnalysisQueue with the given buffer size, number of workers, and output file.
-func NewQueue(client *Client, bufferSize, workers int, resultsPath string) (*AnalysisQueue, error) {
-	f, err := os.OpenFile(resultsPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
+// NewClient creates an HTTP client with the given timeout; insecure disables TLS certificate checks
+// (self-hosted MISP instances commonly use self-signed certificates).
+func NewClient(timeout time.Duration, insecure bool) *Client {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
 	}
-	q := &AnalysisQueue{
-		client:      client,
-		queue:       make(chan map[string]interface{}, bufferSize),
-		resultsFile: f,
+	httpClient := &http.Client{Timeout: timeout}
+	if insecure {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		httpClient.Transport = transport
 	}
-	for i := 0; i < workers; i++ {
-		go q.worker()
+	return &Client{
+		httpClient: httpClient,
+		maxRetries: 5,
+		backoff:    time.Second,
+		maxBackoff: 5 * time.Minute,
+		sleep:      time.Sleep,
 	}
-	return q, nil
 }
 
-// Enqueue adds a new artifact info to the analysis queue without blocking.
-func (q *AnalysisQueue) Enqueue(info map[string]interface{}) {
-	select {
-	case q.queue <- info:
-	default:
-		logger.Log(LevelWarning, fmt.Sprintf("Analysis queue full, dropping artifact with hash %v", info))
+// SetRateLimit limits requests to rate per second with the given burst; rate <= 0 removes the limit.
+func (c *Client) SetRateLimit(rate float64, burst int) {
+	if rate <= 0 {
+		c.limiter = nil
+		return
 	}
+	c.limiter = newTokenBucket(rate, burst)
 }
 
-// Close shuts down the queue and closes the results file.
-func (q *AnalysisQueue) Close() error {
-	close(q.queue)
-	return q.resultsFile.Close()
+// retryDelay returns the pause before the next attempt: Retry-After when the server sent it,
+// exponential backoff otherwise, never longer than maxBackoff.
+func (c *Client) retryDelay(attempt int, retryAfter string) time.Duration {
+	delay := c.backoff << (attempt - 1)
+	if retryAfter != "" {
+		if secs, err := strconv.Atoi(strings.TrimSpace(retryAfter)); err == nil && secs >= 0 {
+			delay = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(retryAfter); err == nil {
+			delay = time.Until(t)
+		}
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > c.maxBackoff {
+		delay = c.maxBackoff
+	}
+	return delay
 }
 
-// worker consumes items from the queue, looks them up in OpenTIP, and writes ordered JSON results.
-func (q *AnalysisQueue) worker() {
-	for info := range q.queue {
-		rawFile, ok := info["file"]
-		if !ok {
-			continue
+// Do sends the request and returns the status code and body of the final attempt.
+func (c *Client) Do(req *http.Request) (int, []byte, error) {
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return 0, nil, err
+			}
+			req.Body = body
+		}
+		if c.limiter != nil {
+			c.limiter.Wait()
 		}
 
-		// Normalize to map[string]interface{}
-		var fileMap map[string]interface{}
-		switch f := rawFile.(type) {
-		case map[string]interface{}:
-			fileMap = f
-		case map[string]string:
-			fileMap = make(map[string]interface{}, len(f))
-			for k, v := range f {
-				fileMap[k] = v
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && attempt < c.maxRetries {
+				delay := c.retryDelay(attempt, "")
+				logger.Log(LevelWarning, fmt.Sprintf("Timeout on attempt %d for %s, retrying in %s...", attempt, req.URL.Host, delay))
+				c.sleep(delay)
+				continue
 			}
-		default:
-			continue
+			if attempt > 1 {
+				return 0, nil, fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return 0, nil, err
 		}
 
-		// Extract MD5
-		var md5 string
-		if h, ok := fileMap["hash"]; ok {
-			switch hm := h.(type) {
-			case map[string]interface{}:
-				md5, _ = hm["md5"].(string)
-			case map[string]string:
-				md5 = hm["md5"]
-			}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return 0, nil, err
 		}
+		logger.Log(LevelDebug, fmt.Sprintf("Response from %s (%s):\n%s", req.URL.Host, resp.Status, body))
 
-		if md5 == "" {
+		retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		if retryable && attempt < c.maxRetries {
+			delay := c.retryDelay(attempt, resp.Header.Get("Retry-After"))
+			logger.Log(LevelWarning, fmt.Sprintf("%s from %s on attempt %d, retrying in %s...", resp.Status, req.URL.Host, attempt, delay))
+			c.sleep(delay)
 			continue
 		}
-
-		hr, err := q.client.LookupHash(md5)
-		// Prepare result structure, including error if occurred
-
-		res := outStruct{
-			Path: fmt.Sprintf("%v", fileMap["path"]),
-			Md5:  md5,
+		if resp.StatusCode == http.StatusTooManyRequests {
+			return resp.StatusCode, body, fmt.Errorf("rate limited by %s: quota exceeded after %d attempts", req.URL.Host, attempt)
 		}
-		if err != nil {
-			logger.Log(LevelError, fmt.Sprintf("Lookup error for %s: %v", md5, err))
-			res.Error = err.Error()
-		} else {
-			res.Zone = hr.Zone
-			res.DetectionName = hr.DetectionName
-			res.LastDetectDate = hr.LastDetectDate
+		return resp.StatusCode, body, nil
+	}
+}
+
+// preferredHash returns the strongest hash present in hashes out of the supported kinds.
+func preferredHash(hashes map[string]string, kinds ...string) (string, string) {
+	if len(kinds) == 0 {
+		kinds = []string{"sha256", "sha1", "md5"}
+	}
+	for _, kind := range kinds {
+		if h := hashes[kind]; h != "" {
+			return kind, h
 		}
+	}
+	return "", ""
+}
 
-		b, merr := json.Marshal(res)
-		if merr != nil {
-			logger.Log(LevelError, fmt.Sprintf("Failed to marshal result: %v", merr))
+// mergeResults combines the results of all providers into one analysis.jsonl record:
+// the verdict is the most severe one, detection details come from the provider that reported it.
+func mergeResults(res *outStruct, results []*ProviderResult) {
+	res.Providers = results
+	res.Verdict = VERDICT_UNKNOWN
+	var errs []string
+	best := -1
+	for _, r := range results {
+		if r.Error != "" {
+			errs = append(errs, r.Provider+": "+r.Error)
 			continue
 		}
-		if _, werr := q.resultsFile.Write(append(b, '\n')); werr != nil {
-			logger.Log(LevelError, fmt.Sprintf("Failed to write result: %v", werr))
+		if r.Zone != "" && res.Zone == "" {
+			res.Zone = r.Zone
+		}
+		severity := verdictSeverity[r.Verdict]
+		if severity > best || (severity == best && res.DetectionName == "" && r.DetectionName != "") {
+			best = severity
+			res.Verdict = r.Verdict
+			res.DetectionName = r.DetectionName
+			res.LastDetectDate = r.LastDetectDate
 		}
 	}
+	if best < 0 && len(errs) > 0 {
+		res.Verdict = ""
+		res.Error = strings.Join(errs, "; ")
+	}
 }
@@ -0,0 +1,460 @@
+// File: analysis_queue.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Backpressure modes of the analysis queue when its in-memory buffer is full.
+const (
+	QUEUE_MODE_SPILL = "spill" // overflow is appended to a spool file on disk and fed back later
+	QUEUE_MODE_BLOCK = "block" // Enqueue blocks the collector until a worker frees a slot
+
+	DEFAULT_DRAIN_TIMEOUT = 5 * time.Minute
+
+	// Suffixes appended to the results file name (without .jsonl) for the queue's own files.
+	ANALYSIS_SPOOL_SUFFIX   = "_spool.jsonl"
+	ANALYSIS_PENDING_SUFFIX = "_pending.jsonl"
+)
+
+// AnalysisQueue manages a buffered queue of artifacts to analyze.
+//
+// Items that do not fit in memory are spilled to a spool file next to the results (spill mode)
+// or make Enqueue wait (block mode). Close waits for all items to be analyzed until the drain
+// timeout expires; whatever is left is written to the pending file, which has the file_info.jsonl
+// record format and can be analyzed later.
+type AnalysisQueue struct {
+	providers []Provider
+	queue     chan map[string]interface{}
+	mode      string
+	timeout   time.Duration
+	grace     time.Duration
+
+	resultsMu   sync.Mutex
+	resultsFile *os.File
+
+	spoolPath string
+	spoolMu   sync.Mutex
+	spool     *os.File
+	spooled   int64 // items written to the spool and not yet fed to the workers
+	spoolKick chan struct{}
+
+	pendingPath  string
+	pendingMu    sync.Mutex
+	pendingCount int64
+	unfinished   int64
+
+	// Items being looked up by the workers, saved to the pending file if Close gives up on them.
+	inFlightMu sync.Mutex
+	inFlight   map[int64]map[string]interface{}
+	nextID     int64
+
+	closing  chan struct{}
+	stop     chan struct{}
+	closed   atomic.Bool
+	stopped  atomic.Bool
+	feeder   sync.WaitGroup
+	workers  sync.WaitGroup
+	enqueued atomic.Int64
+	finished atomic.Int64
+}
+
+// NewQueue creates a new AnalysisQueue with the given providers, buffer size, number of workers, and output file.
+// Providers not wrapped by NewCachedProvider are wrapped so that every hash is looked up once per run.
+func NewQueue(providers []Provider, bufferSize, workers int, resultsPath string) (*AnalysisQueue, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("no analysis providers configured")
+	}
+	wrapped := make([]Provider, 0, len(providers))
+	for _, p := range providers {
+		if _, ok := p.(*cachedProvider); !ok {
+			p = NewCachedProvider(p, nil)
+		}
+		wrapped = append(wrapped, p)
+	}
+	f, err := os.OpenFile(resultsPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.TrimSuffix(resultsPath, ".jsonl")
+	q := &AnalysisQueue{
+		providers:   wrapped,
+		queue:       make(chan map[string]interface{}, bufferSize),
+		mode:        QUEUE_MODE_SPILL,
+		timeout:     DEFAULT_DRAIN_TIMEOUT,
+		grace:       10 * time.Second,
+		resultsFile: f,
+		spoolPath:   base + ANALYSIS_SPOOL_SUFFIX,
+		spoolKick:   make(chan struct{}, 1),
+		pendingPath: base + ANALYSIS_PENDING_SUFFIX,
+		inFlight:    make(map[int64]map[string]interface{}),
+		closing:     make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	q.feeder.Add(1)
+	go q.feed()
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+		go q.worker()
+	}
+	return q, nil
+}
+
+// SetMode selects the backpressure mode (QUEUE_MODE_SPILL or QUEUE_MODE_BLOCK).
+func (q *AnalysisQueue) SetMode(mode string) error {
+	switch mode {
+	case QUEUE_MODE_SPILL, QUEUE_MODE_BLOCK:
+		q.mode = mode
+		return nil
+	}
+	return fmt.Errorf("unsupported analysis queue mode: %s", mode)
+}
+
+// SetDrainTimeout sets how long Close waits for outstanding lookups.
+func (q *AnalysisQueue) SetDrainTimeout(timeout time.Duration) {
+	q.timeout = timeout
+}
+
+// Pending returns the number of enqueued items whose analysis has not finished.
+func (q *AnalysisQueue) Pending() int64 {
+	return q.enqueued.Load() - q.finished.Load()
+}
+
+// Enqueue adds a new artifact info to the analysis queue. Nothing is dropped: when the buffer is full
+// the item is spilled to disk or, in block mode, Enqueue waits for a free slot.
+// Enqueue must not run concurrently with Close.
+func (q *AnalysisQueue) Enqueue(info map[string]interface{}) {
+	q.enqueued.Add(1)
+	if q.closed.Load() {
+		q.savePending(info)
+		q.finished.Add(1)
+		return
+	}
+	if q.mode == QUEUE_MODE_BLOCK {
+		q.queue <- info
+		return
+	}
+	// While the spool holds items, new ones go after them to keep the feeder in order.
+	if atomic.LoadInt64(&q.spooled) == 0 {
+		select {
+		case q.queue <- info:
+			return
+		default:
+		}
+	}
+	if err := q.spill(info); err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Analysis spool unavailable (%v), waiting for a free slot", err))
+		q.queue <- info
+	}
+}
+
+// spill appends an item to the spool file and wakes the feeder.
+func (q *AnalysisQueue) spill(info map[string]interface{}) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	q.spoolMu.Lock()
+	defer q.spoolMu.Unlock()
+	if q.spool == nil {
+		f, err := os.OpenFile(q.spoolPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		q.spool = f
+	}
+	if _, err := q.spool.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	atomic.AddInt64(&q.spooled, 1)
+	select {
+	case q.spoolKick <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// feed moves spilled items from the spool file into the in-memory queue as workers free slots,
+// and closes the queue once the collection is over and the spool is empty.
+func (q *AnalysisQueue) feed() {
+	defer q.feeder.Done()
+	defer close(q.queue)
+
+	var reader *bufio.Reader
+	var spoolFile *os.File
+	defer func() {
+		if spoolFile != nil {
+			spoolFile.Close()
+		}
+	}()
+	for {
+		if atomic.LoadInt64(&q.spooled) == 0 {
+			select {
+			case <-q.spoolKick:
+				continue
+			case <-q.closing:
+				if atomic.LoadInt64(&q.spooled) == 0 {
+					return
+				}
+				continue
+			}
+		}
+		if reader == nil {
+			f, err := os.Open(q.spoolPath)
+			if err != nil {
+				logger.Log(LevelError, fmt.Sprintf("Failed to open analysis spool: %v", err))
+				return
+			}
+			spoolFile = f
+			reader = bufio.NewReaderSize(f, 256*1024)
+		}
+		// The counter is incremented only after a whole line was written, so a line is available.
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			logger.Log(LevelError, fmt.Sprintf("Failed to read analysis spool: %v", err))
+			return
+		}
+		atomic.AddInt64(&q.spooled, -1)
+		var info map[string]interface{}
+		if err := json.Unmarshal(line, &info); err != nil {
+			logger.Log(LevelError, fmt.Sprintf("Corrupted analysis spool entry: %v", err))
+			q.finished.Add(1)
+			continue
+		}
+		select {
+		case q.queue <- info:
+		case <-q.stop:
+			q.savePending(info)
+			q.finished.Add(1)
+		}
+	}
+}
+
+// savePending stores an item that could not be analyzed before the deadline.
+func (q *AnalysisQueue) savePending(info map[string]interface{}) {
+	b, err := json.Marshal(info)
+	if err == nil {
+		q.pendingMu.Lock()
+		var f *os.File
+		f, err = os.OpenFile(q.pendingPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			_, err = f.Write(append(b, '\n'))
+			f.Close()
+			q.pendingCount++
+		}
+		q.pendingMu.Unlock()
+	}
+	if err != nil {
+		logger.Log(LevelError, fmt.Sprintf("Failed to save pending analysis item: %v", err))
+	}
+}
+
+// Close stops accepting items, waits up to the drain timeout for outstanding lookups, saves the rest
+// to the pending file and closes the results file. The outcome is written to the log.
+func (q *AnalysisQueue) Close() error {
+	if q.closed.Swap(true) {
+		return nil
+	}
+	close(q.closing)
+
+	done := make(chan struct{})
+	go func() {
+		q.feeder.Wait()
+		q.workers.Wait()
+		close(done)
+	}()
+
+	pending := q.Pending()
+	if pending > 0 {
+		logger.Log(LevelProgress, fmt.Sprintf("Waiting up to %s for %d pending analysis lookups ...", q.timeout, pending))
+	}
+	var inFlight int64
+	select {
+	case <-done:
+	case <-time.After(q.timeout):
+		// Queued and spooled items go to the pending file instead of the workers;
+		// lookups already running are given a short grace period.
+		q.stopped.Store(true)
+		close(q.stop)
+		for info := range q.queue {
+			q.savePending(info)
+			q.finished.Add(1)
+		}
+		select {
+		case <-done:
+		case <-time.After(q.grace):
+			inFlight = q.saveInFlight()
+		}
+	}
+
+	q.spoolMu.Lock()
+	if q.spool != nil {
+		q.spool.Close()
+		q.spool = nil
+		os.Remove(q.spoolPath)
+	}
+	q.spoolMu.Unlock()
+
+	q.pendingMu.Lock()
+	saved := q.pendingCount
+	q.pendingMu.Unlock()
+	completed := q.finished.Load() - saved + inFlight
+	if saved > 0 {
+		logger.Log(LevelWarning, fmt.Sprintf("Analysis finished with %d lookups completed, %d pending saved to %s (%d still running)",
+			completed, saved, q.pendingPath, inFlight))
+	} else if completed > 0 {
+		logger.Log(LevelInfo, fmt.Sprintf("Analysis finished: %d lookups completed", completed))
+	}
+
+	q.resultsMu.Lock()
+	defer q.resultsMu.Unlock()
+	err := q.resultsFile.Close()
+	q.resultsFile = nil
+	q.unfinished = saved
+	return err
+}
+
+// PendingPath returns the path of the file with items left unanalyzed.
+func (q *AnalysisQueue) PendingPath() string {
+	return q.pendingPath
+}
+
+// Unfinished returns the number of items left unanalyzed by Close.
+func (q *AnalysisQueue) Unfinished() int64 {
+	q.resultsMu.Lock()
+	defer q.resultsMu.Unlock()
+	return q.unfinished
+}
+
+// worker consumes items from the queue, looks them up in every provider, and writes merged JSON results.
+func (q *AnalysisQueue) worker() {
+	defer q.workers.Done()
+	for info := range q.queue {
+		if q.stopped.Load() {
+			q.savePending(info)
+		} else {
+			id := q.begin(info)
+			q.process(info)
+			q.end(id)
+		}
+		q.finished.Add(1)
+	}
+}
+
+// begin registers an item whose lookup is starting.
+func (q *AnalysisQueue) begin(info map[string]interface{}) int64 {
+	q.inFlightMu.Lock()
+	defer q.inFlightMu.Unlock()
+	q.nextID++
+	q.inFlight[q.nextID] = info
+	return q.nextID
+}
+
+// end removes an item whose lookup is over.
+func (q *AnalysisQueue) end(id int64) {
+	q.inFlightMu.Lock()
+	delete(q.inFlight, id)
+	q.inFlightMu.Unlock()
+}
+
+// saveInFlight writes the items still being looked up to the pending file and returns their number.
+// Their results, if they arrive later, are discarded since the results file is already closed.
+func (q *AnalysisQueue) saveInFlight() int64 {
+	q.inFlightMu.Lock()
+	defer q.inFlightMu.Unlock()
+	n := int64(len(q.inFlight))
+	for id, info := range q.inFlight {
+		q.savePending(info)
+		delete(q.inFlight, id)
+	}
+	return n
+}
+
+// process analyzes one FILE_INFO record and appends the merged result to the results file.
+func (q *AnalysisQueue) process(info map[string]interface{}) {
+	rawFile, ok := info["file"]
+	if !ok {
+		return
+	}
+
+	// Normalize to map[string]interface{}
+	var fileMap map[string]interface{}
+	switch f := rawFile.(type) {
+	case map[string]interface{}:
+		fileMap = f
+	case map[string]string:
+		fileMap = make(map[string]interface{}, len(f))
+		for k, v := range f {
+			fileMap[k] = v
+		}
+	default:
+		return
+	}
+
+	// Extract hashes
+	hashes := make(map[string]string)
+	if h, ok := fileMap["hash"]; ok {
+		switch hm := h.(type) {
+		case map[string]interface{}:
+			for k, v := range hm {
+				if s, ok := v.(string); ok {
+					hashes[k] = s
+				}
+			}
+		case map[string]string:
+			for k, v := range hm {
+				hashes[k] = v
+			}
+		}
+	}
+
+	if hashes["md5"] == "" && hashes["sha1"] == "" && hashes["sha256"] == "" {
+		return
+	}
+
+	res := q.analyze(fmt.Sprintf("%v", fileMap["path"]), hashes)
+
+	b, merr := json.Marshal(res)
+	if merr != nil {
+		logger.Log(LevelError, fmt.Sprintf("Failed to marshal result: %v", merr))
+		return
+	}
+	q.resultsMu.Lock()
+	defer q.resultsMu.Unlock()
+	if q.resultsFile == nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Result for %s arrived after shutdown", res.Path))
+		return
+	}
+	if _, werr := q.resultsFile.Write(append(b, '\n')); werr != nil {
+		logger.Log(LevelError, fmt.Sprintf("Failed to write result: %v", werr))
+	}
+}
+
+// analyze runs every provider for one file and merges their answers.
+func (q *AnalysisQueue) analyze(path string, hashes map[string]string) *outStruct {
+	res := &outStruct{
+		Path:   path,
+		Md5:    hashes["md5"],
+		Sha1:   hashes["sha1"],
+		Sha256: hashes["sha256"],
+	}
+	results := make([]*ProviderResult, 0, len(q.providers))
+	for _, p := range q.providers {
+		r, err := p.Lookup(hashes)
+		if err != nil {
+			logger.Log(LevelError, fmt.Sprintf("%s lookup error for %s: %v", p.Name(), path, err))
+			r = &ProviderResult{Error: err.Error()}
+		}
+		r.Provider = p.Name()
+		results = append(results, r)
+	}
+	mergeResults(res, results)
+	return res
+}
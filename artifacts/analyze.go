@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+// ANALYZE_COMMAND — подкоманда повторного анализа результатов ранее выполненного сбора.
+const ANALYZE_COMMAND = "analyze"
+
+// analyzeOptions — параметры повторного анализа каталога результатов.
+type analyzeOptions struct {
+	// All отправляет на анализ все файлы, а не только исполняемые.
+	All bool
+	// Timeout — время ожидания незавершённых проверок.
+	Timeout time.Duration
+	// Rules — правила проверки содержимого хранилища; при nil matches.jsonl не меняется.
+	Rules *RuleSet
+}
+
+// analyzeSummary — итог повторного анализа.
+type analyzeSummary struct {
+	Hostname    string
+	Records     int   // записей в file_info.jsonl
+	Analyzed    int   // файлов, отправленных на анализ
+	FromArchive int   // файлов хранилища без записи в file_info.jsonl
+	Hashed      int   // записей, хеши которых вычислены по содержимому хранилища
+	Mismatched  int   // записей, хеши которых не совпали с содержимым хранилища
+	Unfinished  int64 // проверок, не завершённых до истечения времени ожидания
+	RuleMatches int   // срабатываний правил на содержимом хранилища
+	Results     string
+}
+
+// runAnalyze выполняет подкоманду analyze: fast_dfar analyze [флаги] <каталог результатов>.
+// Источники анализа и наборы хешей задаются так же, как при сборе, включая секции
+// [provider.<имя>] файла artifacts.ini из текущего каталога. Возвращает код завершения.
+func runAnalyze(args []string) int {
+	cfg := ini.Empty()
+	if workDir, err := os.Getwd(); err == nil {
+		if loaded, err := loadConfig(filepath.Join(workDir, "artifacts.ini")); err != nil {
+			log.Printf("Ошибка конфигурации: %v", err)
+		} else {
+			cfg = loaded
+		}
+	}
+	section := cfg.Section("")
+
+	fs := flag.NewFlagSet(ANALYZE_COMMAND, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Использование: %s %s [флаги] <каталог результатов>\n", filepath.Base(os.Args[0]), ANALYZE_COMMAND)
+		fs.PrintDefaults()
+	}
+	providerNames := fs.String("providers", section.Key("providers").MustString(""),
+		"Источники анализа через запятую (секции [provider.<имя>] в artifacts.ini)")
+	apiKey := fs.String("apikey", section.Key("apikey").MustString(""),
+		"ApiKey платфомы opentip")
+	cachePath := fs.String("cache", section.Key("cache").MustString(""),
+		"Файл кэша вердиктов анализа, общего для запусков")
+	cacheTTL := fs.Duration("cache-ttl", section.Key("cache-ttl").MustDuration(24*time.Hour),
+		"Срок хранения вердиктов в кэше; 0 отключает кэш")
+	knownGood := fs.String("known-good", section.Key("known-good").MustString(""),
+		"Файлы наборов известных хороших хешей через запятую")
+	knownBad := fs.String("known-bad", section.Key("known-bad").MustString(""),
+		"Файлы наборов известных плохих хешей через запятую")
+	timeout := fs.Duration("analysis-timeout", section.Key("analysis-timeout").MustDuration(DEFAULT_DRAIN_TIMEOUT),
+		"Время ожидания незавершённых проверок")
+	all := fs.Bool("all", false,
+		"Анализировать все собранные файлы, а не только исполняемые")
+	rulePaths := fs.String("rules", section.Key("rules").MustString(""),
+		"Файлы или каталоги правил в синтаксисе YARA для проверки хранилища собранных файлов")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	dir := fs.Arg(0)
+
+	hostname, err := findOutputHostname(dir)
+	if err != nil {
+		logger.Log(LevelCritical, err.Error())
+		return 1
+	}
+	// Журнал повторного анализа дописывается к журналу сбора.
+	if f, err := os.OpenFile(filepath.Join(dir, fmt.Sprintf("%s-logs.txt", hostname)), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+		defer f.Close()
+		logger.SetOutput(io.MultiWriter(os.Stdout, f))
+	}
+
+	providers, cache, err := newAnalysisProviders(loadProviderConfigs(cfg, splitArgs(*providerNames), *apiKey), *cachePath, *cacheTTL)
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Не удалось инициализировать источники анализа: %v", err))
+		return 1
+	}
+	if cache != nil {
+		defer cache.Close()
+	}
+	hashSet, err := loadHashSet(splitArgs(*knownGood), splitArgs(*knownBad))
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить наборы хешей: %v", err))
+		return 1
+	}
+	if hashSet != nil {
+		providers = append(providers, NewHashSetProvider(hashSet))
+	}
+	opts := analyzeOptions{All: *all, Timeout: *timeout}
+	if paths := splitArgs(*rulePaths); len(paths) > 0 {
+		if opts.Rules, err = LoadRules(paths); err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить правила: %v", err))
+			return 1
+		}
+	}
+	if len(providers) == 0 && opts.Rules == nil {
+		logger.Log(LevelCritical, "Не заданы источники анализа: укажите -providers, -apikey, наборы хешей или -rules")
+		return 1
+	}
+
+	summary, err := analyzeOutput(dir, providers, opts)
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Повторный анализ %s не выполнен: %v", dir, err))
+		return 1
+	}
+	logger.Log(LevelInfo, fmt.Sprintf("Повторный анализ завершён: %d записей, на анализ отправлено %d файлов (%d только из хранилища), хеши вычислены для %d, расхождений с хранилищем %d, не завершено %d, срабатываний правил %d; результаты в %s",
+		summary.Records, summary.Analyzed, summary.FromArchive, summary.Hashed, summary.Mismatched, summary.Unfinished, summary.RuleMatches, summary.Results))
+	return 0
+}
+
+// findOutputHostname определяет имя хоста по файлу <hostname>-file_info.jsonl в каталоге результатов.
+func findOutputHostname(dir string) (string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*-file_info.jsonl"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%s: file_info.jsonl not found", dir)
+	}
+	if len(matches) > 1 {
+		return "", fmt.Errorf("%s: several file_info.jsonl files found", dir)
+	}
+	return strings.TrimSuffix(filepath.Base(matches[0]), "-file_info.jsonl"), nil
+}
+
+// analyzeOutput заново анализирует файлы из каталога результатов сбора и перезаписывает
+// <hostname>-analyse.jsonl. Хеши файлов берутся из file_info.jsonl; если хранилище собранных
+// файлов доступно, хеши вычисляются по его содержимому: недостающие дополняются, расхождения
+// с file_info.jsonl фиксируются в журнале, а файлы хранилища без записи в file_info.jsonl
+// также отправляются на анализ. Прежний список незавершённых проверок заменяется новым.
+// С правилами содержимое хранилища проверяется ими и matches.jsonl перезаписывается.
+func analyzeOutput(dir string, providers []Provider, opts analyzeOptions) (*analyzeSummary, error) {
+	hostname, err := findOutputHostname(dir)
+	if err != nil {
+		return nil, err
+	}
+	summary := &analyzeSummary{
+		Hostname: hostname,
+		Results:  filepath.Join(dir, fmt.Sprintf("%s-analyse.jsonl", hostname)),
+	}
+
+	content, err := scanArchiveContent(dir, hostname, opts.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	var q *AnalysisQueue
+	if len(providers) > 0 {
+		base := strings.TrimSuffix(summary.Results, ".jsonl")
+		for _, path := range []string{summary.Results, base + ANALYSIS_PENDING_SUFFIX, base + ANALYSIS_SPOOL_SUFFIX} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+		}
+		if q, err = NewQueue(providers, 100, 5, summary.Results); err != nil {
+			return nil, err
+		}
+		if opts.Timeout > 0 {
+			q.SetDrainTimeout(opts.Timeout)
+		}
+	}
+	enqueue := func(record map[string]interface{}) bool {
+		if q == nil || !opts.All && !isAnalysisCandidate(record) {
+			return false
+		}
+		q.Enqueue(record)
+		summary.Analyzed++
+		return true
+	}
+
+	var matches *jsonlWriter
+	if opts.Rules != nil {
+		matchesPath := filepath.Join(dir, fmt.Sprintf("%s-matches.jsonl", hostname))
+		if err := os.Remove(matchesPath); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		matches = newJSONLWriter(matchesPath)
+		defer matches.Close()
+	}
+	writeMatches := func(path, artifact string, file *archiveFile) {
+		for _, m := range file.matches {
+			logger.Log(LevelWarning, fmt.Sprintf("Rule %s matched %s", m.Rule, path))
+			if err := matches.Write(newRuleMatchRecord(path, file.hashes, artifact, m)); err != nil {
+				logger.Log(LevelError, fmt.Sprintf("Failed to write rule match for '%s': %v", path, err))
+			}
+			summary.RuleMatches++
+		}
+	}
+
+	seen := make(map[string]bool)
+	err = readJSONL(filepath.Join(dir, fmt.Sprintf("%s-file_info.jsonl", hostname)), func(line []byte) error {
+		var record map[string]interface{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Skipping corrupted file_info record: %v", err))
+			return nil
+		}
+		fileMap, ok := record["file"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		summary.Records++
+		path, _ := fileMap["path"].(string)
+		key := archiveKey(path)
+
+		hashes := recordHashes(fileMap)
+		if file, ok := content[key]; ok && !seen[key] {
+			if hashes["md5"] == "" && hashes["sha1"] == "" && hashes["sha256"] == "" {
+				hashes = file.hashes
+				summary.Hashed++
+			} else if !sameHashes(hashes, file.hashes) {
+				summary.Mismatched++
+				logger.Log(LevelWarning, fmt.Sprintf("Archived content of %s does not match file_info hashes", path))
+			}
+			fileMap["hash"] = hashes
+			labels, _ := record["labels"].(map[string]interface{})
+			artifact, _ := labels["artifact"].(string)
+			writeMatches(path, artifact, file)
+		}
+		seen[key] = true
+		enqueue(record)
+		return nil
+	})
+	if err != nil {
+		if q != nil {
+			q.Close()
+		}
+		return nil, err
+	}
+
+	for key, file := range content {
+		if seen[key] {
+			continue
+		}
+		writeMatches(key, "", file)
+		record := map[string]interface{}{
+			"file":   map[string]interface{}{"path": key, "hash": file.hashes},
+			"labels": map[string]string{"source": "archive"},
+		}
+		if enqueue(record) {
+			summary.FromArchive++
+		}
+	}
+
+	if q != nil {
+		if err := q.Close(); err != nil {
+			return nil, err
+		}
+		summary.Unfinished = q.Unfinished()
+	}
+	return summary, nil
+}
+
+// archiveFile — результат проверки файла из хранилища: хеши и сработавшие правила.
+type archiveFile struct {
+	hashes  map[string]string
+	matches []*RuleMatch
+}
+
+// scanArchiveContent вычисляет md5, sha1 и sha256 каждого файла хранилища предыдущего сбора
+// и проверяет его правилами, если они заданы. Ключ — archiveKey имени файла;
+// без хранилища возвращается пустой набор.
+func scanArchiveContent(dir, hostname string, rules *RuleSet) (map[string]*archiveFile, error) {
+	res := make(map[string]*archiveFile)
+	archive, err := openArchiveReader(dir, hostname)
+	if err != nil || archive == nil {
+		return res, err
+	}
+	defer archive.Close()
+	err = archive.Walk(func(name string, size int64, r io.Reader) error {
+		file := &archiveFile{}
+		var data []byte
+		var readErr error
+		if rules != nil && size <= MAX_RULES_SCAN_SIZE {
+			if data, readErr = io.ReadAll(r); readErr == nil {
+				r = bytes.NewReader(data)
+			}
+		}
+		if readErr == nil {
+			file.hashes, readErr = hashReader(r)
+		}
+		if readErr != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Failed to read %s from archive: %v", name, readErr))
+			return nil
+		}
+		if data != nil {
+			file.matches = rules.Scan(data)
+		}
+		res[archiveKey(name)] = file
+		return nil
+	})
+	return res, err
+}
+
+// hashReader вычисляет md5, sha1 и sha256 содержимого r.
+func hashReader(r io.Reader) (map[string]string, error) {
+	md5Hash, sha1Hash, sha256Hash := md5.New(), sha1.New(), sha256.New()
+	if _, err := io.Copy(io.MultiWriter(md5Hash, sha1Hash, sha256Hash), r); err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"md5":    hex.EncodeToString(md5Hash.Sum(nil)),
+		"sha1":   hex.EncodeToString(sha1Hash.Sum(nil)),
+		"sha256": hex.EncodeToString(sha256Hash.Sum(nil)),
+	}, nil
+}
+
+// recordHashes извлекает поле file.hash записи file_info, прочитанной из JSON.
+func recordHashes(fileMap map[string]interface{}) map[string]string {
+	hashes := make(map[string]string)
+	if hm, ok := fileMap["hash"].(map[string]interface{}); ok {
+		for k, v := range hm {
+			if s, ok := v.(string); ok {
+				hashes[k] = s
+			}
+		}
+	}
+	return hashes
+}
+
+// sameHashes сравнивает хеши, присутствующие в обоих наборах.
+func sameHashes(a, b map[string]string) bool {
+	for kind, h := range a {
+		if other, ok := b[kind]; ok && h != "" && !strings.EqualFold(h, other) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzeOutput(t *testing.T) {
+	dir := t.TempDir()
+	malware := []byte("MZ evil payload")
+	sum := md5.Sum(malware)
+	badMD5 := hex.EncodeToString(sum[:])
+
+	// file_info: исполняемый файл без хешей (вычисляются по архиву), текстовый файл и файл с хешами
+	records := []map[string]interface{}{
+		{"file": map[string]interface{}{"path": "/tmp/evil.exe", "mime_type": "application/octet-stream"}},
+		{"file": map[string]interface{}{"path": "/tmp/notes.txt", "mime_type": "text/plain",
+			"hash": map[string]string{"md5": "00000000000000000000000000000001"}}},
+		{"file": map[string]interface{}{"path": "/tmp/tool.sh", "mime_type": "text/x-shellscript",
+			"hash": map[string]string{"md5": "00000000000000000000000000000002"}}},
+	}
+	f, err := os.Create(filepath.Join(dir, "host-file_info.jsonl"))
+	assert.NoError(t, err)
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		assert.NoError(t, enc.Encode(r))
+	}
+	f.Close()
+
+	// Архив: evil.exe из file_info и dropper.dll, для которого записи нет
+	zf, err := os.Create(filepath.Join(dir, "host-files.zip"))
+	assert.NoError(t, err)
+	zw := zip.NewWriter(zf)
+	for name, content := range map[string][]byte{"/tmp/evil.exe": malware, "/tmp/dropper.dll": malware} {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		w.Write(content)
+	}
+	assert.NoError(t, zw.Close())
+	zf.Close()
+
+	// Незавершённые проверки прошлого запуска заменяются результатами нового
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "host-analyse_pending.jsonl"), []byte("{}\n"), 0644))
+
+	hs := NewHashSet()
+	_, err = hs.LoadFile(writeHashSetFile(t, "bad.csv", "md5,label\n"+badMD5+",Trojan.Test\n"), HASHSET_KNOWN_BAD)
+	assert.NoError(t, err)
+
+	summary, err := analyzeOutput(dir, []Provider{NewHashSetProvider(hs)}, analyzeOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "host", summary.Hostname)
+	assert.Equal(t, 3, summary.Records)
+	assert.Equal(t, 3, summary.Analyzed)
+	assert.Equal(t, 1, summary.FromArchive)
+	assert.Equal(t, 1, summary.Hashed)
+	assert.Equal(t, int64(0), summary.Unfinished)
+
+	results := make(map[string]outStruct)
+	assert.NoError(t, readJSONL(summary.Results, func(line []byte) error {
+		var res outStruct
+		assert.NoError(t, json.Unmarshal(line, &res))
+		results[res.Path] = res
+		return nil
+	}))
+	assert.Len(t, results, 3)
+	assert.Equal(t, VERDICT_MALICIOUS, results["/tmp/evil.exe"].Verdict)
+	assert.Equal(t, "Trojan.Test", results["/tmp/evil.exe"].DetectionName)
+	assert.Equal(t, badMD5, results["/tmp/evil.exe"].Md5)
+	assert.Equal(t, VERDICT_MALICIOUS, results["tmp/dropper.dll"].Verdict)
+	assert.Equal(t, VERDICT_UNKNOWN, results["/tmp/tool.sh"].Verdict)
+	assert.NoFileExists(t, filepath.Join(dir, "host-analyse_pending.jsonl"))
+
+	// С -all анализируются и неисполняемые файлы; результаты перезаписываются.
+	// Правила проверяют содержимое хранилища и перезаписывают matches.jsonl.
+	rules, err := ParseRules(`rule EvilPayload { strings: $a = "evil payload" condition: $a at 3 }`)
+	assert.NoError(t, err)
+	summary, err = analyzeOutput(dir, []Provider{NewHashSetProvider(hs)}, analyzeOptions{All: true, Rules: rules})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, summary.Analyzed)
+	assert.Equal(t, 4, countLines(t, summary.Results))
+	assert.Equal(t, 2, summary.RuleMatches)
+	assert.Equal(t, 2, countLines(t, filepath.Join(dir, "host-matches.jsonl")))
+}
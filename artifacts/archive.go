@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Поддерживаемые форматы хранения собранных файлов.
+const (
+	ARCHIVE_FORMAT_ZIP = "zip"
+	ARCHIVE_FORMAT_TAR = "tar"
+	ARCHIVE_FORMAT_DIR = "dir"
+)
+
+// Идентификаторы дополнительных полей zip (APPNOTE 4.5, Info-ZIP extra field list).
+const (
+	zipExtraNTFS          = 0x000a
+	zipExtraExtTimestamp  = 0x5455
+	zipExtraInfoZipUnixV3 = 0x7875
+)
+
+// archiveWriter сохраняет содержимое собранных файлов вместе с их метаданными.
+type archiveWriter interface {
+	WriteFile(name string, meta *FileMetadata, chunks [][]byte) error
+	Close() error
+}
+
+// newArchiveWriter создаёт хранилище собранных файлов в указанном формате.
+func newArchiveWriter(format, dirpath, hostname string) (archiveWriter, error) {
+	switch format {
+	case "", ARCHIVE_FORMAT_ZIP:
+		return newZipArchive(filepath.Join(dirpath, fmt.Sprintf("%s-files.zip", hostname)))
+	case ARCHIVE_FORMAT_TAR:
+		return newTarArchive(filepath.Join(dirpath, fmt.Sprintf("%s-files.tar", hostname)))
+	case ARCHIVE_FORMAT_DIR:
+		return newDirArchive(
+			filepath.Join(dirpath, fmt.Sprintf("%s-files", hostname)),
+			filepath.Join(dirpath, fmt.Sprintf("%s-files_metadata.jsonl", hostname)))
+	}
+	return nil, fmt.Errorf("unsupported archive format: %s", format)
+}
+
+// validArchiveFormat проверяет, что формат архива поддерживается.
+func validArchiveFormat(format string) bool {
+	switch format {
+	case "", ARCHIVE_FORMAT_ZIP, ARCHIVE_FORMAT_TAR, ARCHIVE_FORMAT_DIR:
+		return true
+	}
+	return false
+}
+
+// contentMode возвращает права файла без признака символической ссылки:
+// в архив записывается содержимое, а не сама ссылка.
+func contentMode(meta *FileMetadata) os.FileMode {
+	if meta == nil || meta.Mode == 0 {
+		return 0644
+	}
+	return meta.Mode &^ os.ModeSymlink
+}
+
+// ------------------- zip -------------------
+
+type zipArchive struct {
+	file   *os.File
+	writer *zip.Writer
+}
+
+func newZipArchive(path string) (*zipArchive, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zip: %v", err)
+	}
+	return &zipArchive{file: f, writer: zip.NewWriter(f)}, nil
+}
+
+func (z *zipArchive) WriteFile(name string, meta *FileMetadata, chunks [][]byte) error {
+	header := &zip.FileHeader{
+		Name:   name,
+		Method: zip.Deflate,
+	}
+	if meta != nil {
+		header.SetMode(contentMode(meta))
+		// Modified не заполняем: иначе archive/zip добавит собственное поле 0x5455 только с mtime.
+		header.ModifiedDate, header.ModifiedTime = msDosTime(meta.ModTime)
+		header.Extra = zipExtraFields(meta)
+		header.Comment = extendedMetadataComment(meta)
+	}
+	writer, err := z.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err := writer.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *zipArchive) Close() error {
+	err := z.writer.Close()
+	if e := z.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// extendedMetadataComment возвращает JSON с атрибутами, для которых в zip нет стандартных полей:
+// цель ссылки, флаги файла и расширенные атрибуты. Записывается в комментарий элемента архива.
+func extendedMetadataComment(meta *FileMetadata) string {
+	ext := make(map[string]interface{})
+	for k, v := range meta.AsDict() {
+		switch k {
+		case "mode", "mtime", "accessed", "ctime", "created", "uid", "gid":
+			// Уже сохранены в заголовке и дополнительных полях zip.
+		default:
+			ext[k] = v
+		}
+	}
+	if len(ext) == 0 {
+		return ""
+	}
+	b, err := json.Marshal(ext)
+	if err != nil || len(b) > 0xFFFF {
+		logger.Log(LevelWarning, "Extended metadata does not fit into zip entry comment, skipping")
+		return ""
+	}
+	return string(b)
+}
+
+// msDosTime переводит время в формат MS-DOS, используемый в основном заголовке zip.
+func msDosTime(t time.Time) (uint16, uint16) {
+	if t.IsZero() || t.Year() < 1980 {
+		return 0x21, 0 // 1980-01-01 00:00:00
+	}
+	t = t.UTC()
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	tm := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, tm
+}
+
+// zipExtraFields формирует дополнительные поля zip с исходными временными метками и владельцем:
+// 0x5455 (mtime/atime/ctime), 0x000a (NTFS: mtime/atime/время создания) и 0x7875 (uid/gid).
+func zipExtraFields(meta *FileMetadata) []byte {
+	var extra []byte
+
+	// Extended timestamp: флаги и 32-битные Unix-времена.
+	var flags byte
+	var times []byte
+	for i, t := range []time.Time{meta.ModTime, meta.AccessTime, meta.ChangeTime} {
+		if t.IsZero() {
+			continue
+		}
+		flags |= 1 << uint(i)
+		times = binary.LittleEndian.AppendUint32(times, uint32(t.Unix()))
+	}
+	if flags != 0 {
+		extra = binary.LittleEndian.AppendUint16(extra, zipExtraExtTimestamp)
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(1+len(times)))
+		extra = append(extra, flags)
+		extra = append(extra, times...)
+	}
+
+	// NTFS: единственное место в zip для времени создания файла.
+	if !meta.BirthTime.IsZero() {
+		extra = binary.LittleEndian.AppendUint16(extra, zipExtraNTFS)
+		extra = binary.LittleEndian.AppendUint16(extra, 32)
+		extra = binary.LittleEndian.AppendUint32(extra, 0) // reserved
+		extra = binary.LittleEndian.AppendUint16(extra, 0x0001)
+		extra = binary.LittleEndian.AppendUint16(extra, 24)
+		for _, t := range []time.Time{meta.ModTime, meta.AccessTime, meta.BirthTime} {
+			extra = binary.LittleEndian.AppendUint64(extra, timeToFiletime(t))
+		}
+	}
+
+	// Info-ZIP Unix (версия 1): uid/gid по 4 байта.
+	if meta.Uid >= 0 && meta.Gid >= 0 {
+		extra = binary.LittleEndian.AppendUint16(extra, zipExtraInfoZipUnixV3)
+		extra = binary.LittleEndian.AppendUint16(extra, 11)
+		extra = append(extra, 1, 4)
+		extra = binary.LittleEndian.AppendUint32(extra, uint32(meta.Uid))
+		extra = append(extra, 4)
+		extra = binary.LittleEndian.AppendUint32(extra, uint32(meta.Gid))
+	}
+	return extra
+}
+
+// timeToFiletime переводит время в Windows FILETIME (100-нс интервалы с 1601-01-01).
+func timeToFiletime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano()/100 + 116444736000000000)
+}
+
+// ------------------- tar -------------------
+
+type tarArchive struct {
+	file   *os.File
+	writer *tar.Writer
+}
+
+func newTarArchive(path string) (*tarArchive, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create tar: %v", err)
+	}
+	return &tarArchive{file: f, writer: tar.NewWriter(f)}, nil
+}
+
+func (ta *tarArchive) WriteFile(name string, meta *FileMetadata, chunks [][]byte) error {
+	var size int64
+	for _, chunk := range chunks {
+		size += int64(len(chunk))
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Size:     size,
+		Mode:     int64(contentMode(meta).Perm()),
+		Format:   tar.FormatPAX,
+	}
+	if meta != nil {
+		header.ModTime = meta.ModTime
+		header.AccessTime = meta.AccessTime
+		header.ChangeTime = meta.ChangeTime
+		if meta.Uid >= 0 {
+			header.Uid = meta.Uid
+		}
+		if meta.Gid >= 0 {
+			header.Gid = meta.Gid
+		}
+		header.PAXRecords = map[string]string{}
+		if !meta.BirthTime.IsZero() {
+			// Ключ, используемый libarchive/bsdtar для времени создания.
+			header.PAXRecords["LIBARCHIVE.creationtime"] = paxTime(meta.BirthTime)
+		}
+		if meta.LinkTarget != "" {
+			header.PAXRecords["FASTDFAR.link_target"] = meta.LinkTarget
+		}
+		if len(meta.Flags) > 0 {
+			header.PAXRecords["FASTDFAR.attributes"] = strings.Join(meta.Flags, ",")
+		}
+		// Расширенные атрибуты — в формате star/GNU tar, понятном tar --xattrs.
+		for name, value := range meta.Xattrs {
+			header.PAXRecords["SCHILY.xattr."+name] = string(value)
+		}
+	}
+	if err := ta.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err := ta.writer.Write(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ta *tarArchive) Close() error {
+	err := ta.writer.Close()
+	if e := ta.file.Close(); e != nil && err == nil {
+		err = e
+	}
+	return err
+}
+
+// paxTime форматирует время в виде "секунды.наносекунды", как принято в PAX-записях.
+func paxTime(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10) + "." + fmt.Sprintf("%09d", t.Nanosecond())
+}
+
+// ------------------- каталог -------------------
+
+// dirArchive раскладывает файлы в каталог, восстанавливая права, владельца и времена
+// доступа/изменения, а полные метаданные (включая ctime и время создания,
+// которые нельзя выставить) пишет в сопроводительный JSONL-манифест.
+type dirArchive struct {
+	root     string
+	manifest *os.File
+}
+
+func newDirArchive(root, manifestPath string) (*dirArchive, error) {
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(manifestPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &dirArchive{root: root, manifest: f}, nil
+}
+
+func (d *dirArchive) WriteFile(name string, meta *FileMetadata, chunks [][]byte) error {
+	rel := strings.TrimLeft(filepath.FromSlash(name), `\/`)
+	target := filepath.Join(d.root, rel)
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	for _, chunk := range chunks {
+		if _, err := f.Write(chunk); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	record := map[string]interface{}{"path": name, "archive_path": filepath.ToSlash(rel)}
+	if meta != nil {
+		// Восстановление атрибутов — по возможности: отказ не должен прерывать сбор.
+		if err := os.Chmod(target, contentMode(meta).Perm()); err != nil {
+			logger.Log(LevelDebug, fmt.Sprintf("chmod %s: %v", target, err))
+		}
+		if meta.Uid >= 0 && meta.Gid >= 0 && os.Geteuid() == 0 {
+			if err := os.Lchown(target, meta.Uid, meta.Gid); err != nil {
+				logger.Log(LevelDebug, fmt.Sprintf("chown %s: %v", target, err))
+			}
+		}
+		if !meta.ModTime.IsZero() {
+			atime := meta.AccessTime
+			if atime.IsZero() {
+				atime = meta.ModTime
+			}
+			if err := os.Chtimes(target, atime, meta.ModTime); err != nil {
+				logger.Log(LevelDebug, fmt.Sprintf("chtimes %s: %v", target, err))
+			}
+		}
+		for k, v := range meta.AsDict() {
+			record[k] = v
+		}
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = d.manifest.Write(append(b, '\n'))
+	return err
+}
+
+func (d *dirArchive) Close() error {
+	return d.manifest.Close()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveReader последовательно перебирает файлы хранилища предыдущего сбора (zip, tar или dir).
+// name — путь файла в том виде, в каком он был записан archiveWriter.
+type archiveReader interface {
+	Walk(fn func(name string, size int64, r io.Reader) error) error
+	Close() error
+}
+
+// openArchiveReader находит в каталоге результатов хранилище собранных файлов хоста
+// в любом из поддерживаемых форматов. Если файлы не собирались, возвращает nil без ошибки.
+func openArchiveReader(dirpath, hostname string) (archiveReader, error) {
+	zipPath := filepath.Join(dirpath, fmt.Sprintf("%s-files.zip", hostname))
+	if _, err := os.Stat(zipPath); err == nil {
+		r, err := zip.OpenReader(zipPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip: %v", err)
+		}
+		return &zipArchiveReader{reader: r}, nil
+	}
+	tarPath := filepath.Join(dirpath, fmt.Sprintf("%s-files.tar", hostname))
+	if _, err := os.Stat(tarPath); err == nil {
+		f, err := os.Open(tarPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tar: %v", err)
+		}
+		return &tarArchiveReader{file: f}, nil
+	}
+	root := filepath.Join(dirpath, fmt.Sprintf("%s-files", hostname))
+	if st, err := os.Stat(root); err == nil && st.IsDir() {
+		return &dirArchiveReader{root: root}, nil
+	}
+	return nil, nil
+}
+
+// archiveKey приводит путь из file_info.jsonl или имя элемента хранилища к общему виду:
+// прямые разделители, без ведущего разделителя и без двоеточия после буквы диска
+// (как это делает normalizeFilepath при архивировании на Windows).
+func archiveKey(name string) string {
+	name = strings.TrimLeft(strings.ReplaceAll(name, `\`, "/"), "/")
+	if len(name) >= 2 && name[1] == ':' {
+		name = name[:1] + name[2:]
+	}
+	return name
+}
+
+// ------------------- zip -------------------
+
+type zipArchiveReader struct {
+	reader *zip.ReadCloser
+}
+
+func (z *zipArchiveReader) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	for _, f := range z.reader.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		err = fn(f.Name, int64(f.UncompressedSize64), rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (z *zipArchiveReader) Close() error {
+	return z.reader.Close()
+}
+
+// ------------------- tar -------------------
+
+type tarArchiveReader struct {
+	file *os.File
+}
+
+func (ta *tarArchiveReader) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	if _, err := ta.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	tr := tar.NewReader(ta.file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, header.Size, tr); err != nil {
+			return err
+		}
+	}
+}
+
+func (ta *tarArchiveReader) Close() error {
+	return ta.file.Close()
+}
+
+// ------------------- dir -------------------
+
+type dirArchiveReader struct {
+	root string
+}
+
+func (d *dirArchiveReader) Walk(fn func(name string, size int64, r io.Reader) error) error {
+	return filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(d.root, path)
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return fn(filepath.ToSlash(rel), info.Size(), f)
+	})
+}
+
+func (d *dirArchiveReader) Close() error {
+	return nil
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+// Результаты проверки подписи Authenticode в file.pe.signature.status.
+const (
+	SIGNATURE_VALID    = "signed/valid"
+	SIGNATURE_INVALID  = "signed/invalid"
+	SIGNATURE_UNSIGNED = "unsigned"
+
+	IMAGE_DIRECTORY_SECURITY = 4
+	WIN_CERT_TYPE_PKCS       = 0x0002
+)
+
+var (
+	oidRFC3161Timestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+	oidCounterSignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	// digestOIDs — алгоритмы хеширования, встречающиеся в подписях Authenticode.
+	digestOIDs = map[string]crypto.Hash{
+		"1.2.840.113549.2.5":     crypto.MD5,
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+)
+
+// spcDigestInfo — поле messageDigest структуры SpcIndirectDataContent.
+type spcDigestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+// tstInfo — метка времени RFC 3161; остальные поля TSTInfo не используются.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint spcDigestInfo
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// counterSignerInfo — SignerInfo устаревшей контрподписи PKCS #9.
+type counterSignerInfo struct {
+	Version                   int
+	IssuerAndSerial           issuerSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type issuerSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type signerAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+// LoadTrustedRoots читает доверенные корневые сертификаты из файлов PEM/DER
+// или каталогов с файлами *.pem, *.crt, *.cer, *.der.
+func LoadTrustedRoots(paths []string) (*x509.CertPool, int, error) {
+	pool := x509.NewCertPool()
+	count := 0
+	load := func(path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		certs, err := parseCertificates(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, c := range certs {
+			pool.AddCert(c)
+		}
+		count += len(certs)
+		return nil
+	}
+	for _, p := range paths {
+		st, err := os.Stat(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !st.IsDir() {
+			if err := load(p); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".pem", ".crt", ".cer", ".der":
+				if err := load(filepath.Join(p, e.Name())); err != nil {
+					return nil, 0, err
+				}
+			}
+		}
+	}
+	return pool, count, nil
+}
+
+// parseCertificates разбирает сертификаты в PEM (один или несколько блоков) или DER.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+	return x509.ParseCertificates(data)
+}
+
+// verifyAuthenticode проверяет подпись Authenticode PE-файла: разбирает
+// PKCS #7 из таблицы сертификатов, сверяет хеш образа с подписанным,
+// проверяет подпись, метку времени и, если заданы доверенные корни, цепочку.
+func (img *peImage) verifyAuthenticode(roots *x509.CertPool) map[string]interface{} {
+	ret := map[string]interface{}{}
+	invalid := func(format string, args ...interface{}) map[string]interface{} {
+		ret["status"] = SIGNATURE_INVALID
+		ret["error"] = fmt.Sprintf(format, args...)
+		return ret
+	}
+
+	secOff, secSize := img.dataDirectory(IMAGE_DIRECTORY_SECURITY)
+	if secOff == 0 || secSize == 0 {
+		ret["status"] = SIGNATURE_UNSIGNED
+		return ret
+	}
+	// Для каталога безопасности VirtualAddress — смещение в файле, а не RVA
+	if uint64(secOff)+uint64(secSize) > uint64(len(img.data)) || secSize < 8 {
+		return invalid("certificate table is outside of the file")
+	}
+	table := img.data[secOff : secOff+secSize]
+	length := binary.LittleEndian.Uint32(table)
+	if length < 8 || length > secSize {
+		return invalid("malformed WIN_CERTIFICATE length %d", length)
+	}
+	if typ := binary.LittleEndian.Uint16(table[6:]); typ != WIN_CERT_TYPE_PKCS {
+		return invalid("unsupported certificate type 0x%04x", typ)
+	}
+	blob := table[8:length]
+	// Запись дополняется нулями до границы 8 байт — отрезаем всё после DER-структуры
+	var raw asn1.RawValue
+	if _, err := asn1.Unmarshal(blob, &raw); err == nil {
+		blob = raw.FullBytes
+	}
+	p7, err := pkcs7.Parse(blob)
+	if err != nil {
+		return invalid("pkcs7: %v", err)
+	}
+
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return invalid("signature must have exactly one signer")
+	}
+	ret["signer"] = certificateInfo(signer)
+	certs := make([]string, 0, len(p7.Certificates))
+	for _, c := range p7.Certificates {
+		certs = append(certs, c.Subject.String())
+	}
+	ret["certificates"] = certs
+
+	// SpcIndirectDataContent: SpcAttributeTypeAndOptionalValue, DigestInfo
+	var spcData asn1.RawValue
+	var digest spcDigestInfo
+	rest, err := asn1.Unmarshal(p7.Content, &spcData)
+	if err == nil {
+		_, err = asn1.Unmarshal(rest, &digest)
+	}
+	if err != nil {
+		return invalid("malformed SpcIndirectDataContent: %v", err)
+	}
+	hash, ok := digestOIDs[digest.Algorithm.Algorithm.String()]
+	if !ok || !hash.Available() {
+		return invalid("unsupported digest algorithm %s", digest.Algorithm.Algorithm)
+	}
+	ret["digest_algorithm"] = strings.ToLower(strings.ReplaceAll(hash.String(), "-", ""))
+	computed := img.authenticodeDigest(hash, secOff, secSize)
+	ret["digest"] = hex.EncodeToString(computed)
+	if !bytes.Equal(computed, digest.Digest) {
+		return invalid("file digest %x does not match signed digest %x", computed, digest.Digest)
+	}
+
+	// Метка времени определяет момент, на который проверяется цепочка
+	verifyTime := time.Now()
+	if ts := timestampInfo(p7, roots); ts != nil {
+		ret["timestamp"] = ts
+		if t, ok := ts["time"].(time.Time); ok && ts["valid"] == true {
+			verifyTime = t
+		}
+	}
+
+	if err := p7.Verify(); err != nil {
+		return invalid("%v", err)
+	}
+	if roots != nil {
+		chain, err := verifyChain(signer, p7.Certificates, roots, verifyTime, x509.ExtKeyUsageCodeSigning)
+		ret["trusted"] = err == nil
+		if err != nil {
+			return invalid("untrusted certificate chain: %v", err)
+		}
+		ret["chain"] = chain
+	}
+	ret["status"] = SIGNATURE_VALID
+	return ret
+}
+
+// authenticodeDigest хеширует образ так, как это делает Authenticode: без поля
+// CheckSum, записи каталога безопасности и самой таблицы сертификатов.
+// Возвращает nil, если таблица сертификатов пересекается с заголовками.
+func (img *peImage) authenticodeDigest(hash crypto.Hash, secOff, secSize uint32) []byte {
+	lfanew := int(binary.LittleEndian.Uint32(img.data[0x3c:]))
+	optOff := lfanew + 4 + 20
+	checksumOff := optOff + 64
+	dirOff := optOff + 96
+	if _, ok := img.pf.OptionalHeader.(*pe.OptionalHeader64); ok {
+		dirOff = optOff + 112
+	}
+	secDirOff := dirOff + IMAGE_DIRECTORY_SECURITY*8
+	if secDirOff+8 > int(secOff) {
+		return nil
+	}
+
+	h := hash.New()
+	h.Write(img.data[:checksumOff])
+	h.Write(img.data[checksumOff+4 : secDirOff])
+	h.Write(img.data[secDirOff+8 : secOff])
+	h.Write(img.data[secOff+secSize:])
+	return h.Sum(nil)
+}
+
+// timestampInfo разбирает метку времени подписи: RFC 3161 (атрибут Microsoft)
+// или контрподпись PKCS #9. Возвращает nil, если метки нет.
+func timestampInfo(p7 *pkcs7.PKCS7, roots *x509.CertPool) map[string]interface{} {
+	if len(p7.Signers) != 1 {
+		return nil
+	}
+	signer := p7.Signers[0]
+	for _, attr := range signer.UnauthenticatedAttributes {
+		var ts map[string]interface{}
+		var err error
+		switch {
+		case attr.Type.Equal(oidRFC3161Timestamp):
+			ts, err = rfc3161Timestamp(attr.Value.Bytes, signer.EncryptedDigest, roots)
+		case attr.Type.Equal(oidCounterSignature):
+			ts, err = pkcs9Timestamp(attr.Value.Bytes, signer.EncryptedDigest, p7.Certificates, roots)
+		default:
+			continue
+		}
+		if ts == nil {
+			ts = map[string]interface{}{}
+		}
+		ts["valid"] = err == nil
+		if err != nil {
+			ts["error"] = err.Error()
+		}
+		return ts
+	}
+	return nil
+}
+
+// rfc3161Timestamp проверяет токен RFC 3161: подпись TSA и то, что метка
+// выдана на подпись файла (messageImprint — хеш EncryptedDigest).
+func rfc3161Timestamp(token, signature []byte, roots *x509.CertPool) (map[string]interface{}, error) {
+	p7, err := pkcs7.Parse(token)
+	if err != nil {
+		return nil, fmt.Errorf("timestamp pkcs7: %w", err)
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(p7.Content, &info); err != nil {
+		return nil, fmt.Errorf("malformed TSTInfo: %w", err)
+	}
+	ts := map[string]interface{}{"type": "rfc3161", "time": info.GenTime.UTC()}
+	tsa := p7.GetOnlySigner()
+	if tsa != nil {
+		ts["signer"] = tsa.Subject.String()
+	}
+	if err := checkImprint(info.MessageImprint, signature); err != nil {
+		return ts, err
+	}
+	if err := p7.Verify(); err != nil {
+		return ts, err
+	}
+	if roots != nil && tsa != nil {
+		if _, err := verifyChain(tsa, p7.Certificates, roots, info.GenTime, x509.ExtKeyUsageTimeStamping); err != nil {
+			return ts, fmt.Errorf("untrusted timestamp chain: %w", err)
+		}
+	}
+	return ts, nil
+}
+
+// pkcs9Timestamp проверяет устаревшую контрподпись: дайджест EncryptedDigest
+// подписи файла в атрибуте messageDigest и подпись атрибутов сертификатом TSA.
+func pkcs9Timestamp(value, signature []byte, certs []*x509.Certificate, roots *x509.CertPool) (map[string]interface{}, error) {
+	var si counterSignerInfo
+	if _, err := asn1.Unmarshal(value, &si); err != nil {
+		return nil, fmt.Errorf("malformed countersignature: %w", err)
+	}
+	ts := map[string]interface{}{"type": "pkcs9"}
+	if len(si.AuthenticatedAttributes.FullBytes) == 0 {
+		return ts, errors.New("countersignature has no authenticated attributes")
+	}
+	// Подписывается DER-кодировка атрибутов как SET, а не с неявным тегом [0]
+	signed := append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	var attrs []signerAttribute
+	if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+		return ts, fmt.Errorf("malformed countersignature attributes: %w", err)
+	}
+	var digest []byte
+	for _, a := range attrs {
+		switch {
+		case a.Type.Equal(oidSigningTime):
+			var t time.Time
+			if _, err := asn1.Unmarshal(a.Value.Bytes, &t); err == nil {
+				ts["time"] = t.UTC()
+			}
+		case a.Type.Equal(oidMessageDigest):
+			asn1.Unmarshal(a.Value.Bytes, &digest)
+		}
+	}
+	var tsa *x509.Certificate
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(si.IssuerAndSerial.Serial) == 0 {
+			tsa = c
+			break
+		}
+	}
+	if tsa == nil {
+		return ts, errors.New("no certificate for countersigner")
+	}
+	ts["signer"] = tsa.Subject.String()
+	if err := checkImprint(spcDigestInfo{Algorithm: si.DigestAlgorithm, Digest: digest}, signature); err != nil {
+		return ts, err
+	}
+	algo, err := signatureAlgorithm(si.DigestAlgorithm, tsa)
+	if err != nil {
+		return ts, err
+	}
+	if err := tsa.CheckSignature(algo, signed, si.EncryptedDigest); err != nil {
+		return ts, err
+	}
+	if roots != nil {
+		t, _ := ts["time"].(time.Time)
+		if _, err := verifyChain(tsa, certs, roots, t, x509.ExtKeyUsageTimeStamping); err != nil {
+			return ts, fmt.Errorf("untrusted timestamp chain: %w", err)
+		}
+	}
+	return ts, nil
+}
+
+// checkImprint сверяет хеш подписи файла с хешем, заверенным меткой времени.
+func checkImprint(imprint spcDigestInfo, signature []byte) error {
+	hash, ok := digestOIDs[imprint.Algorithm.Algorithm.String()]
+	if !ok || !hash.Available() {
+		return fmt.Errorf("unsupported timestamp digest algorithm %s", imprint.Algorithm.Algorithm)
+	}
+	h := hash.New()
+	h.Write(signature)
+	if !bytes.Equal(h.Sum(nil), imprint.Digest) {
+		return errors.New("timestamp does not match the signature")
+	}
+	return nil
+}
+
+// signatureAlgorithm подбирает x509.SignatureAlgorithm по алгоритму хеширования и ключу сертификата.
+func signatureAlgorithm(digest pkix.AlgorithmIdentifier, cert *x509.Certificate) (x509.SignatureAlgorithm, error) {
+	hash := digestOIDs[digest.Algorithm.String()]
+	switch cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		switch hash {
+		case crypto.MD5:
+			return x509.MD5WithRSA, nil
+		case crypto.SHA1:
+			return x509.SHA1WithRSA, nil
+		case crypto.SHA256:
+			return x509.SHA256WithRSA, nil
+		case crypto.SHA384:
+			return x509.SHA384WithRSA, nil
+		case crypto.SHA512:
+			return x509.SHA512WithRSA, nil
+		}
+	case *ecdsa.PublicKey:
+		switch hash {
+		case crypto.SHA1:
+			return x509.ECDSAWithSHA1, nil
+		case crypto.SHA256:
+			return x509.ECDSAWithSHA256, nil
+		case crypto.SHA384:
+			return x509.ECDSAWithSHA384, nil
+		case crypto.SHA512:
+			return x509.ECDSAWithSHA512, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported countersignature algorithm %s", digest.Algorithm)
+}
+
+// verifyChain строит цепочку от сертификата до доверенного корня на момент t
+// и возвращает субъекты сертификатов цепочки.
+func verifyChain(cert *x509.Certificate, certs []*x509.Certificate, roots *x509.CertPool, t time.Time, usage x509.ExtKeyUsage) ([]string, error) {
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return nil, err
+	}
+	subjects := make([]string, 0, len(chains[0]))
+	for _, c := range chains[0] {
+		subjects = append(subjects, c.Subject.String())
+	}
+	return subjects, nil
+}
+
+// certificateInfo описывает сертификат подписанта.
+func certificateInfo(c *x509.Certificate) map[string]interface{} {
+	return map[string]interface{}{
+		"subject":    c.Subject.String(),
+		"issuer":     c.Issuer.String(),
+		"serial":     fmt.Sprintf("%x", c.SerialNumber),
+		"not_before": c.NotBefore.UTC(),
+		"not_after":  c.NotAfter.UTC(),
+	}
+}
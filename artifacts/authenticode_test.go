@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mozilla.org/pkcs7"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert выпускает сертификат, подписанный parent (или самоподписанный).
+func newTestCert(t *testing.T, cn string, parent *testCA, usage ...x509.ExtKeyUsage) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-24 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtKeyUsage:  usage,
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// signTestPE подписывает PE-образ: SpcIndirectDataContent с хешем образа,
+// подпись издателя и метка времени RFC 3161 от TSA.
+func signTestPE(t *testing.T, data []byte, root, leaf, tsa *testCA, genTime time.Time) []byte {
+	pf, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &peImage{pf: pf, data: data}
+	digest := img.authenticodeDigest(crypto.SHA256, uint32(len(data)), 0)
+
+	spcAttr, _ := asn1.Marshal(struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}})
+	digestInfo, _ := asn1.Marshal(spcDigestInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: pkcs7.OIDDigestAlgorithmSHA256},
+		Digest:    digest,
+	})
+	sd, err := pkcs7.NewSignedData(append(spcAttr, digestInfo...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.GetSignedData().ContentInfo.ContentType = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := sd.AddSignerChain(leaf.cert, leaf.key, []*x509.Certificate{root.cert}, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	signer := &sd.GetSignedData().SignerInfos[0]
+	imprint := sha256.Sum256(signer.EncryptedDigest)
+	tst, _ := asn1.Marshal(tstInfo{
+		Version: 1,
+		Policy:  asn1.ObjectIdentifier{1, 2, 3, 4},
+		MessageImprint: spcDigestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: pkcs7.OIDDigestAlgorithmSHA256},
+			Digest:    imprint[:],
+		},
+		SerialNumber: big.NewInt(1),
+		GenTime:      genTime,
+	})
+	tsd, err := pkcs7.NewSignedData(tst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tsd.GetSignedData().ContentInfo.ContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	tsd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	if err := tsd.AddSignerChain(tsa.cert, tsa.key, []*x509.Certificate{root.cert}, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := tsd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := signer.SetUnauthenticatedAttributes([]pkcs7.Attribute{
+		{Type: oidRFC3161Timestamp, Value: asn1.RawValue{FullBytes: token}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := sd.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// WIN_CERTIFICATE, дополненный до 8 байт, в конце файла
+	cert := make([]byte, 8, 8+len(sig)+8)
+	cert = append(cert, sig...)
+	for len(cert)%8 != 0 {
+		cert = append(cert, 0)
+	}
+	binary.LittleEndian.PutUint32(cert, uint32(len(cert)))
+	binary.LittleEndian.PutUint16(cert[4:], 0x0200)
+	binary.LittleEndian.PutUint16(cert[6:], WIN_CERT_TYPE_PKCS)
+
+	signed := append([]byte{}, data...)
+	dirOff := 0xa8 + 4 + 20 + 96 + IMAGE_DIRECTORY_SECURITY*8
+	binary.LittleEndian.PutUint32(signed[dirOff:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(signed[dirOff+4:], uint32(len(cert)))
+	return append(signed, cert...)
+}
+
+func verifyTestPE(t *testing.T, data []byte, roots *x509.CertPool) map[string]interface{} {
+	pf, err := pe.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return (&peImage{pf: pf, data: data}).verifyAuthenticode(roots)
+}
+
+func TestAuthenticode(t *testing.T) {
+	root := newTestCert(t, "Test Root", nil)
+	leaf := newTestCert(t, "Test Publisher", root, x509.ExtKeyUsageCodeSigning)
+	tsa := newTestCert(t, "Test TSA", root, x509.ExtKeyUsageTimeStamping)
+	genTime := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	unsigned := buildTestPE(t)
+	assert.Equal(t, SIGNATURE_UNSIGNED, verifyTestPE(t, unsigned, nil)["status"])
+
+	signed := signTestPE(t, unsigned, root, leaf, tsa, genTime)
+
+	// Без доверенных корней проверяется только целостность
+	sig := verifyTestPE(t, signed, nil)
+	assert.Equal(t, SIGNATURE_VALID, sig["status"], sig["error"])
+	assert.Equal(t, "sha256", sig["digest_algorithm"])
+	assert.Equal(t, "CN=Test Publisher", sig["signer"].(map[string]interface{})["subject"])
+	assert.Equal(t, "CN=Test Root", sig["signer"].(map[string]interface{})["issuer"])
+	assert.Nil(t, sig["trusted"])
+	ts := sig["timestamp"].(map[string]interface{})
+	assert.Equal(t, true, ts["valid"], ts["error"])
+	assert.Equal(t, "rfc3161", ts["type"])
+	assert.Equal(t, genTime, ts["time"])
+	assert.Equal(t, "CN=Test TSA", ts["signer"])
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root.cert)
+	sig = verifyTestPE(t, signed, pool)
+	assert.Equal(t, SIGNATURE_VALID, sig["status"], sig["error"])
+	assert.Equal(t, true, sig["trusted"])
+	assert.Equal(t, []string{"CN=Test Publisher", "CN=Test Root"}, sig["chain"])
+
+	// Цепочка не строится до чужого корня
+	other := x509.NewCertPool()
+	other.AddCert(newTestCert(t, "Other Root", nil).cert)
+	sig = verifyTestPE(t, signed, other)
+	assert.Equal(t, SIGNATURE_INVALID, sig["status"])
+	assert.Equal(t, false, sig["trusted"])
+
+	// Изменение кода после подписи
+	tampered := append([]byte{}, signed...)
+	tampered[0x250] ^= 0xff
+	sig = verifyTestPE(t, tampered, pool)
+	assert.Equal(t, SIGNATURE_INVALID, sig["status"])
+	assert.Contains(t, sig["error"], "does not match signed digest")
+
+	// Повреждённая таблица сертификатов
+	broken := append([]byte{}, signed...)
+	broken[len(unsigned)+20] ^= 0xff
+	assert.Equal(t, SIGNATURE_INVALID, verifyTestPE(t, broken, nil)["status"])
+}
+
+func TestLoadTrustedRoots(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestCert(t, "Root A", nil)
+	b := newTestCert(t, "Root B", nil)
+	c := newTestCert(t, "Root C", nil)
+	pemData := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: a.cert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: b.cert.Raw})...)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "roots.pem"), pemData, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "root.cer"), c.cert.Raw, 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("ignored"), 0644))
+
+	pool, n, err := LoadTrustedRoots([]string{dir})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	_, err = c.cert.Verify(x509.VerifyOptions{Roots: pool})
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "bad.pem"), []byte("garbage"), 0644))
+	_, _, err = LoadTrustedRoots([]string{filepath.Join(dir, "bad.pem")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,568 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// История браузеров хранится в базах SQLite профиля:
+//   - Chromium (Chrome, Edge, Brave, Opera и др.): History — таблицы urls, visits,
+//     downloads, downloads_url_chains и keyword_search_terms; время — WebKit
+//     (микросекунды с 1601-01-01);
+//   - Firefox: places.sqlite (moz_places, moz_historyvisits, загрузки в moz_annos) и
+//     formhistory.sqlite (строка поиска); время — PRTime (микросекунды с 1970-01-01);
+//   - Safari: History.db (history_items, history_visits); время — Cocoa (секунды
+//     с 2001-01-01). Загрузки и поиски Safari хранятся в plist и здесь не разбираются.
+//
+// Тип базы определяется по набору таблиц, а не по имени файла. Журнал -wal базы в режиме
+// WAL (Firefox, Safari) накладывается на базу, разобранную перед ним.
+const (
+	BROWSER_HISTORY_MAX_SIZE = 1 << 30
+	BROWSER_COCOA_EPOCH      = 978307200
+	BROWSER_WAL_SUFFIX       = "-wal"
+
+	// Предел длины цепочки перенаправлений загрузки: номера звеньев берутся из базы
+	BROWSER_MAX_URL_CHAIN = 1024
+
+	BROWSER_FAMILY_CHROMIUM = "chromium"
+	BROWSER_FAMILY_FIREFOX  = "firefox"
+	BROWSER_FAMILY_SAFARI   = "safari"
+
+	BROWSER_VISIT    = "visit"
+	BROWSER_DOWNLOAD = "download"
+	BROWSER_SEARCH   = "search"
+
+	FIREFOX_DOWNLOAD_TARGET   = "downloads/destinationFileURI"
+	FIREFOX_DOWNLOAD_METADATA = "downloads/metaData"
+	FIREFOX_SEARCH_FIELD      = "searchbar-history"
+)
+
+// browserHistoryFiles — имена файлов баз истории.
+var browserHistoryFiles = []string{"history", "archived history", "places.sqlite", "formhistory.sqlite", "history.db"}
+
+// browserNames — браузеры на основе Chromium по фрагменту пути профиля.
+var browserNames = []struct{ fragment, name string }{
+	{"/microsoft/edge/", "edge"},
+	{"/google/chrome/", "chrome"},
+	{"/google-chrome/", "chrome"},
+	{"/bravesoftware/", "brave"},
+	{"/opera software/", "opera"},
+	{"/com.operasoftware.", "opera"},
+	{"/yandex/yandexbrowser/", "yandex"},
+	{"/vivaldi/", "vivaldi"},
+	{"/chromium/", "chromium"},
+}
+
+// chromiumTransitions — основные типы переходов Chromium (младший байт transition).
+var chromiumTransitions = []string{
+	"link", "typed", "auto_bookmark", "auto_subframe", "manual_subframe", "generated",
+	"auto_toplevel", "form_submit", "reload", "keyword", "keyword_generated",
+}
+
+var chromiumDownloadStates = map[int64]string{0: "in_progress", 1: "complete", 2: "cancelled", 4: "interrupted"}
+
+// firefoxVisitTypes — типы посещений moz_historyvisits.visit_type.
+var firefoxVisitTypes = map[int64]string{
+	1: "link", 2: "typed", 3: "bookmark", 4: "embed", 5: "redirect_permanent",
+	6: "redirect_temporary", 7: "download", 8: "framed_link", 9: "reload",
+}
+
+var firefoxDownloadStates = map[int64]string{0: "in_progress", 1: "complete", 2: "failed", 3: "cancelled", 4: "paused"}
+
+// webkitTime переводит время WebKit/Chromium (микросекунды с 1601-01-01) в UTC.
+func webkitTime(v int64) time.Time {
+	if v <= 0 {
+		return time.Time{}
+	}
+	return filetimeToTime(uint64(v) * 10)
+}
+
+// prTime переводит PRTime Firefox (микросекунды с 1970-01-01) в UTC.
+func prTime(v int64) time.Time {
+	if v <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMicro(v).UTC()
+}
+
+// cocoaTime переводит время Cocoa (секунды с 2001-01-01) в UTC.
+func cocoaTime(v float64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(BROWSER_COCOA_EPOCH, 0).Add(time.Duration(v * float64(time.Second))).UTC()
+}
+
+// sqliteInt возвращает числовое значение столбца строки SQLite.
+func sqliteInt(row map[string]interface{}, name string) int64 {
+	switch v := row[name].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	}
+	return 0
+}
+
+// sqliteFloat возвращает значение столбца как число с плавающей точкой.
+func sqliteFloat(row map[string]interface{}, name string) float64 {
+	switch v := row[name].(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// sqliteText возвращает текстовое значение столбца строки SQLite.
+func sqliteText(row map[string]interface{}, name string) string {
+	switch v := row[name].(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	}
+	return ""
+}
+
+// fileURIPath переводит file:/// URI в путь; прочие значения возвращаются как есть.
+func fileURIPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	p := u.Path
+	// file:///C:/Users/... → C:\Users\...
+	if len(p) >= 3 && p[0] == '/' && p[2] == ':' {
+		return strings.ReplaceAll(p[1:], "/", `\`)
+	}
+	return p
+}
+
+// browserHistory — источник записей: браузер, профиль и исходный файл.
+type browserHistory struct {
+	family  string
+	name    string
+	profile string
+	path    string
+	emit    func(record map[string]interface{}) error
+}
+
+func newBrowserHistory(family, path string, emit func(record map[string]interface{}) error) *browserHistory {
+	p := strings.ReplaceAll(path, `\`, "/")
+	b := &browserHistory{family: family, name: family, profile: filepath.Base(filepath.Dir(p)), path: path, emit: emit}
+	if family == BROWSER_FAMILY_CHROMIUM {
+		lower := strings.ToLower(p)
+		for _, n := range browserNames {
+			if strings.Contains(lower, n.fragment) {
+				b.name = n.name
+				break
+			}
+		}
+	}
+	return b
+}
+
+// record формирует запись JSONL: время в @timestamp, адрес в url, сведения о браузере
+// и событии в browser.
+func (b *browserHistory) record(kind string, ts time.Time, rawURL string, details map[string]interface{}) error {
+	browser := map[string]interface{}{
+		"name":    b.name,
+		"family":  b.family,
+		"profile": b.profile,
+		"type":    kind,
+	}
+	for key, value := range details {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				continue
+			}
+		case nil:
+			continue
+		}
+		browser[key] = value
+	}
+	record := map[string]interface{}{
+		"event":   map[string]interface{}{"kind": "event", "category": "web", "action": "browser-" + kind},
+		"browser": browser,
+		"log":     map[string]interface{}{"file": map[string]interface{}{"path": b.path}},
+	}
+	if rawURL != "" {
+		u := map[string]interface{}{"full": rawURL}
+		if parsed, err := url.Parse(rawURL); err == nil && parsed.Hostname() != "" {
+			u["domain"] = parsed.Hostname()
+		}
+		record["url"] = u
+	}
+	if !ts.IsZero() {
+		record["@timestamp"] = formatTime(ts)
+	}
+	return b.emit(record)
+}
+
+// tableRows читает строки таблицы в словарь по rowid; отсутствующая таблица даёт nil.
+func tableRows(db *SQLiteDatabase, name string) (map[int64]map[string]interface{}, error) {
+	t := db.Table(name)
+	if t == nil {
+		return nil, nil
+	}
+	rows := make(map[int64]map[string]interface{})
+	err := t.Rows(func(rowid int64, row map[string]interface{}) error {
+		rows[rowid] = row
+		return nil
+	})
+	return rows, err
+}
+
+// sortedRowIDs возвращает rowid строк по возрастанию.
+func sortedRowIDs(rows map[int64]map[string]interface{}) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// eachRow обходит строки таблицы, если она есть в базе.
+func eachRow(db *SQLiteDatabase, name string, fn func(row map[string]interface{}) error) error {
+	t := db.Table(name)
+	if t == nil {
+		return nil
+	}
+	return t.Rows(func(rowid int64, row map[string]interface{}) error { return fn(row) })
+}
+
+// readChromiumHistory выгружает посещения, загрузки и поисковые запросы из History.
+func (b *browserHistory) readChromiumHistory(db *SQLiteDatabase) error {
+	urls, err := tableRows(db, "urls")
+	if err != nil {
+		return fmt.Errorf("urls: %w", err)
+	}
+	visits, err := tableRows(db, "visits")
+	if err != nil {
+		return fmt.Errorf("visits: %w", err)
+	}
+	for _, id := range sortedRowIDs(visits) {
+		v := visits[id]
+		u := urls[sqliteInt(v, "url")]
+		details := map[string]interface{}{
+			"title":       sqliteText(u, "title"),
+			"visit_count": sqliteInt(u, "visit_count"),
+			"typed_count": sqliteInt(u, "typed_count"),
+		}
+		if core := int(sqliteInt(v, "transition") & 0xFF); core < len(chromiumTransitions) {
+			details["transition"] = chromiumTransitions[core]
+		}
+		if d := sqliteInt(v, "visit_duration"); d > 0 {
+			details["visit_duration"] = time.Duration(d * int64(time.Microsecond)).Seconds()
+		}
+		if from := visits[sqliteInt(v, "from_visit")]; from != nil {
+			details["from_url"] = sqliteText(urls[sqliteInt(from, "url")], "url")
+		}
+		if err := b.record(BROWSER_VISIT, webkitTime(sqliteInt(v, "visit_time")), sqliteText(u, "url"), details); err != nil {
+			return err
+		}
+	}
+
+	// Цепочка адресов загрузки с учётом перенаправлений: первый — исходный, последний — итоговый
+	chains := make(map[int64][]string)
+	err = eachRow(db, "downloads_url_chains", func(row map[string]interface{}) error {
+		id, index := sqliteInt(row, "id"), sqliteInt(row, "chain_index")
+		if index < 0 || index >= BROWSER_MAX_URL_CHAIN {
+			return nil
+		}
+		for int64(len(chains[id])) <= index {
+			chains[id] = append(chains[id], "")
+		}
+		chains[id][index] = sqliteText(row, "url")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("downloads_url_chains: %w", err)
+	}
+	err = eachRow(db, "downloads", func(row map[string]interface{}) error {
+		chain := chains[sqliteInt(row, "id")]
+		rawURL := sqliteText(row, "tab_url")
+		if len(chain) > 0 {
+			rawURL = chain[len(chain)-1]
+		}
+		target := sqliteText(row, "target_path")
+		if target == "" {
+			target = sqliteText(row, "current_path")
+		}
+		details := map[string]interface{}{
+			"target_path":    target,
+			"received_bytes": sqliteInt(row, "received_bytes"),
+			"total_bytes":    sqliteInt(row, "total_bytes"),
+			"danger_type":    sqliteInt(row, "danger_type"),
+			"mime_type":      sqliteText(row, "mime_type"),
+			"referrer":       sqliteText(row, "referrer"),
+			"tab_url":        sqliteText(row, "tab_url"),
+			"opened":         sqliteInt(row, "opened") != 0,
+		}
+		if state, ok := chromiumDownloadStates[sqliteInt(row, "state")]; ok {
+			details["state"] = state
+		}
+		if end := webkitTime(sqliteInt(row, "end_time")); !end.IsZero() {
+			details["end_time"] = formatTime(end)
+		}
+		if len(chain) > 1 {
+			details["url_chain"] = chain
+		}
+		return b.record(BROWSER_DOWNLOAD, webkitTime(sqliteInt(row, "start_time")), rawURL, details)
+	})
+	if err != nil {
+		return fmt.Errorf("downloads: %w", err)
+	}
+
+	// Поисковые запросы привязаны к адресу страницы результатов; время — последнее посещение
+	err = eachRow(db, "keyword_search_terms", func(row map[string]interface{}) error {
+		u := urls[sqliteInt(row, "url_id")]
+		return b.record(BROWSER_SEARCH, webkitTime(sqliteInt(u, "last_visit_time")), sqliteText(u, "url"),
+			map[string]interface{}{"search_term": sqliteText(row, "term"), "title": sqliteText(u, "title")})
+	})
+	if err != nil {
+		return fmt.Errorf("keyword_search_terms: %w", err)
+	}
+	return nil
+}
+
+// readFirefoxPlaces выгружает посещения и загрузки из places.sqlite.
+func (b *browserHistory) readFirefoxPlaces(db *SQLiteDatabase) error {
+	places, err := tableRows(db, "moz_places")
+	if err != nil {
+		return fmt.Errorf("moz_places: %w", err)
+	}
+	visits, err := tableRows(db, "moz_historyvisits")
+	if err != nil {
+		return fmt.Errorf("moz_historyvisits: %w", err)
+	}
+	for _, id := range sortedRowIDs(visits) {
+		v := visits[id]
+		p := places[sqliteInt(v, "place_id")]
+		details := map[string]interface{}{
+			"title":       sqliteText(p, "title"),
+			"visit_count": sqliteInt(p, "visit_count"),
+			"typed_count": sqliteInt(p, "typed"),
+		}
+		if typ, ok := firefoxVisitTypes[sqliteInt(v, "visit_type")]; ok {
+			details["transition"] = typ
+		}
+		if from := visits[sqliteInt(v, "from_visit")]; from != nil {
+			details["from_url"] = sqliteText(places[sqliteInt(from, "place_id")], "url")
+		}
+		if err := b.record(BROWSER_VISIT, prTime(sqliteInt(v, "visit_date")), sqliteText(p, "url"), details); err != nil {
+			return err
+		}
+	}
+
+	// Firefox 26+ хранит загрузки как аннотации адреса: путь файла и метаданные JSON
+	attributes := make(map[int64]string)
+	err = eachRow(db, "moz_anno_attributes", func(row map[string]interface{}) error {
+		attributes[sqliteInt(row, "id")] = sqliteText(row, "name")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("moz_anno_attributes: %w", err)
+	}
+	type download struct {
+		place   int64
+		added   int64
+		target  string
+		details map[string]interface{}
+	}
+	var downloads []*download
+	byPlace := make(map[int64]*download)
+	meta := make(map[int64]string)
+	err = eachRow(db, "moz_annos", func(row map[string]interface{}) error {
+		place := sqliteInt(row, "place_id")
+		switch attributes[sqliteInt(row, "anno_attribute_id")] {
+		case FIREFOX_DOWNLOAD_TARGET:
+			d := &download{place: place, added: sqliteInt(row, "dateAdded"), target: sqliteText(row, "content")}
+			downloads = append(downloads, d)
+			byPlace[place] = d
+		case FIREFOX_DOWNLOAD_METADATA:
+			meta[place] = sqliteText(row, "content")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("moz_annos: %w", err)
+	}
+	for _, d := range downloads {
+		details := map[string]interface{}{"target_path": fileURIPath(d.target)}
+		var m struct {
+			State    *int64 `json:"state"`
+			EndTime  int64  `json:"endTime"`
+			FileSize *int64 `json:"fileSize"`
+		}
+		if json.Unmarshal([]byte(meta[d.place]), &m) == nil {
+			if m.State != nil {
+				details["state"] = firefoxDownloadStates[*m.State]
+			}
+			if m.FileSize != nil {
+				details["total_bytes"] = *m.FileSize
+			}
+			if m.EndTime > 0 {
+				details["end_time"] = formatTime(time.UnixMilli(m.EndTime).UTC())
+			}
+		}
+		if err := b.record(BROWSER_DOWNLOAD, prTime(d.added), sqliteText(places[d.place], "url"), details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFirefoxFormHistory выгружает строки поиска из formhistory.sqlite.
+func (b *browserHistory) readFirefoxFormHistory(db *SQLiteDatabase) error {
+	return eachRow(db, "moz_formhistory", func(row map[string]interface{}) error {
+		if sqliteText(row, "fieldname") != FIREFOX_SEARCH_FIELD {
+			return nil
+		}
+		return b.record(BROWSER_SEARCH, prTime(sqliteInt(row, "lastUsed")), "", map[string]interface{}{
+			"search_term": sqliteText(row, "value"),
+			"times_used":  sqliteInt(row, "timesUsed"),
+			"first_used":  formatTime(prTime(sqliteInt(row, "firstUsed"))),
+		})
+	})
+}
+
+// readSafariHistory выгружает посещения из History.db.
+func (b *browserHistory) readSafariHistory(db *SQLiteDatabase) error {
+	items, err := tableRows(db, "history_items")
+	if err != nil {
+		return fmt.Errorf("history_items: %w", err)
+	}
+	visits, err := tableRows(db, "history_visits")
+	if err != nil {
+		return fmt.Errorf("history_visits: %w", err)
+	}
+	for _, id := range sortedRowIDs(visits) {
+		v := visits[id]
+		item := items[sqliteInt(v, "history_item")]
+		details := map[string]interface{}{
+			"title":       sqliteText(v, "title"),
+			"visit_count": sqliteInt(item, "visit_count"),
+		}
+		if v["load_successful"] != nil {
+			details["load_successful"] = sqliteInt(v, "load_successful") != 0
+		}
+		if from := visits[sqliteInt(v, "redirect_source")]; from != nil {
+			details["from_url"] = sqliteText(items[sqliteInt(from, "history_item")], "url")
+		}
+		if err := b.record(BROWSER_VISIT, cocoaTime(sqliteFloat(v, "visit_time")), sqliteText(item, "url"), details); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadBrowserHistory определяет браузер по таблицам базы и передаёт записи истории,
+// загрузок и поисковых запросов в emit.
+func ReadBrowserHistory(data []byte, path string, emit func(record map[string]interface{}) error) error {
+	db, err := OpenSQLite(data)
+	if err != nil {
+		return err
+	}
+	switch {
+	case db.Table("urls") != nil && db.Table("visits") != nil:
+		return newBrowserHistory(BROWSER_FAMILY_CHROMIUM, path, emit).readChromiumHistory(db)
+	case db.Table("moz_places") != nil:
+		return newBrowserHistory(BROWSER_FAMILY_FIREFOX, path, emit).readFirefoxPlaces(db)
+	case db.Table("moz_formhistory") != nil:
+		return newBrowserHistory(BROWSER_FAMILY_FIREFOX, path, emit).readFirefoxFormHistory(db)
+	case db.Table("history_items") != nil && db.Table("history_visits") != nil:
+		return newBrowserHistory(BROWSER_FAMILY_SAFARI, path, emit).readSafariHistory(db)
+	}
+	return fmt.Errorf("unrecognized browser history database")
+}
+
+// ----------------------------------------------------------------------
+// browserHistoryParser – разбор баз истории браузеров как ArtifactParser
+// ----------------------------------------------------------------------
+
+// browserHistoryParser запоминает последнюю разобранную базу в режиме WAL: журнал
+// <база>-wal собирается и разбирается сразу после неё, и при его разборе выгружаются
+// записи, появившиеся после наложения зафиксированных кадров.
+type browserHistoryParser struct {
+	walPath string
+	walData []byte
+	walSeen map[string]bool
+}
+
+func newBrowserHistoryParser(opts parserOptions) (ArtifactParser, error) {
+	return &browserHistoryParser{}, nil
+}
+
+func (p *browserHistoryParser) Name() string { return PARSER_BROWSER_HISTORY }
+
+func (p *browserHistoryParser) Match(path string) bool {
+	name := strings.ToLower(filepath.Base(strings.ReplaceAll(path, `\`, "/")))
+	return containsString(browserHistoryFiles, strings.TrimSuffix(name, BROWSER_WAL_SUFFIX))
+}
+
+func (p *browserHistoryParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, BROWSER_HISTORY_MAX_SIZE+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > BROWSER_HISTORY_MAX_SIZE {
+		return fmt.Errorf("browser history database larger than %d bytes", BROWSER_HISTORY_MAX_SIZE)
+	}
+	if strings.HasSuffix(strings.ToLower(path), BROWSER_WAL_SUFFIX) {
+		return p.parseWAL(path, data, emit)
+	}
+
+	p.walPath, p.walData, p.walSeen = "", nil, nil
+	if !SQLiteWALMode(data) {
+		return ReadBrowserHistory(data, path, emit)
+	}
+	seen := make(map[string]bool)
+	err = ReadBrowserHistory(data, path, func(record map[string]interface{}) error {
+		if key, err := json.Marshal(record); err == nil {
+			seen[string(key)] = true
+		}
+		return emit(record)
+	})
+	if err == nil {
+		p.walPath, p.walData, p.walSeen = path, data, seen
+	}
+	return err
+}
+
+// parseWAL накладывает журнал на базу, разобранную перед ним, и выгружает только
+// записи, которых не было в базе без журнала.
+func (p *browserHistoryParser) parseWAL(path string, wal []byte, emit func(record map[string]interface{}) error) error {
+	dbPath := path[:len(path)-len(BROWSER_WAL_SUFFIX)]
+	data, seen := p.walData, p.walSeen
+	matched := p.walData != nil && p.walPath == dbPath
+	p.walPath, p.walData, p.walSeen = "", nil, nil
+	if len(wal) == 0 {
+		return nil
+	}
+	if !matched {
+		logger.Log(LevelWarning, fmt.Sprintf("WAL journal %s not applied: no WAL-mode database %s was parsed before it", path, dbPath))
+		return nil
+	}
+	applied, frames, err := ApplySQLiteWAL(data, wal)
+	if err != nil || frames == 0 {
+		return err
+	}
+	logger.Log(LevelDebug, fmt.Sprintf("Applied %d WAL frames from %s", frames, path))
+	return ReadBrowserHistory(applied, dbPath, func(record map[string]interface{}) error {
+		if key, err := json.Marshal(record); err == nil && seen[string(key)] {
+			return nil
+		}
+		return emit(record)
+	})
+}
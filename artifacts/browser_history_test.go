@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// collectBrowserHistory разбирает базу разборщиком browser_history.
+func collectBrowserHistory(t *testing.T, path string, data []byte) []map[string]interface{} {
+	p, err := newBrowserHistoryParser(parserOptions{})
+	assert.NoError(t, err)
+	assert.True(t, p.Match(path))
+	var records []map[string]interface{}
+	assert.NoError(t, p.Parse(path, bytes.NewReader(data), func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	}))
+	return records
+}
+
+func TestBrowserTimestamps(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	assert.Equal(t, ts, webkitTime(13359038400123456))
+	assert.Equal(t, ts, prTime(1714564800123456))
+	assert.Equal(t, time.Date(2024, 5, 1, 12, 0, 0, 500000000, time.UTC), cocoaTime(736257600.5))
+	assert.True(t, webkitTime(0).IsZero())
+	assert.True(t, prTime(-1).IsZero())
+	assert.True(t, cocoaTime(0).IsZero())
+	assert.Equal(t, `C:\Users\alice\Downloads\tool x.zip`, fileURIPath("file:///C:/Users/alice/Downloads/tool%20x.zip"))
+	assert.Equal(t, "/home/bob/a.sh", fileURIPath("file:///home/bob/a.sh"))
+}
+
+func TestChromiumHistory(t *testing.T) {
+	data := buildTestSQLiteDB(
+		testSQLiteTable{name: "urls",
+			sql: "CREATE TABLE urls(id INTEGER PRIMARY KEY AUTOINCREMENT,url LONGVARCHAR,title LONGVARCHAR,visit_count INTEGER DEFAULT 0 NOT NULL,typed_count INTEGER DEFAULT 0 NOT NULL,last_visit_time INTEGER NOT NULL,hidden INTEGER DEFAULT 0 NOT NULL)",
+			rows: [][]interface{}{
+				{nil, "https://www.google.com/search?q=mimikatz", "mimikatz - Google Search", 1, 0, 13359038400000000, 0},
+				{nil, "https://github.com/gentilkiwi/mimikatz", "mimikatz", 3, 1, 13359038460000000, 0},
+			}},
+		testSQLiteTable{name: "visits",
+			sql: "CREATE TABLE visits(id INTEGER PRIMARY KEY,url INTEGER NOT NULL,visit_time INTEGER NOT NULL,from_visit INTEGER,transition INTEGER DEFAULT 0 NOT NULL,segment_id INTEGER,visit_duration INTEGER DEFAULT 0 NOT NULL)",
+			rows: [][]interface{}{
+				{nil, 1, 13359038400000000, 0, 0x30000001, 0, 0},
+				{nil, 2, 13359038460000000, 1, 0x30000000, 0, 2500000},
+			}},
+		testSQLiteTable{name: "downloads",
+			sql: "CREATE TABLE downloads (id INTEGER PRIMARY KEY,guid VARCHAR NOT NULL,current_path LONGVARCHAR NOT NULL,target_path LONGVARCHAR NOT NULL,start_time INTEGER NOT NULL,received_bytes INTEGER NOT NULL,total_bytes INTEGER NOT NULL,state INTEGER NOT NULL,danger_type INTEGER NOT NULL,interrupt_reason INTEGER NOT NULL,hash BLOB NOT NULL,end_time INTEGER NOT NULL,opened INTEGER NOT NULL,last_access_time INTEGER NOT NULL,transient INTEGER NOT NULL,referrer VARCHAR NOT NULL,site_url VARCHAR NOT NULL,tab_url VARCHAR NOT NULL,tab_referrer_url VARCHAR NOT NULL,http_method VARCHAR NOT NULL,by_ext_id VARCHAR NOT NULL,by_ext_name VARCHAR NOT NULL,etag VARCHAR NOT NULL,last_modified VARCHAR NOT NULL,mime_type VARCHAR(255) NOT NULL,original_mime_type VARCHAR(255) NOT NULL)",
+			rows: [][]interface{}{
+				{nil, "guid", `C:\Users\alice\Downloads\mimikatz.zip`, `C:\Users\alice\Downloads\mimikatz.zip`,
+					13359038500000000, 1024, 1024, 1, 0, 0, []byte{}, 13359038510000000, 1, 0, 0,
+					"https://github.com/", "", "https://github.com/gentilkiwi/mimikatz", "", "", "", "", "", "",
+					"application/zip", "application/zip"},
+			}},
+		testSQLiteTable{name: "downloads_url_chains",
+			sql: "CREATE TABLE downloads_url_chains (id INTEGER NOT NULL,chain_index INTEGER NOT NULL,url LONGVARCHAR NOT NULL, PRIMARY KEY (id, chain_index) )",
+			rows: [][]interface{}{
+				{1, 1, "https://objects.githubusercontent.com/mimikatz.zip"},
+				{1, 0, "https://github.com/gentilkiwi/mimikatz/releases/download/mimikatz.zip"},
+				// Номера звеньев вне допустимого диапазона пропускаются
+				{1, 1 << 40, "https://evil.example/huge"},
+				{1, -1, "https://evil.example/negative"},
+			}},
+		testSQLiteTable{name: "keyword_search_terms",
+			sql:  "CREATE TABLE keyword_search_terms (keyword_id INTEGER NOT NULL,url_id INTEGER NOT NULL,term LONGVARCHAR NOT NULL,normalized_term LONGVARCHAR NOT NULL)",
+			rows: [][]interface{}{{2, 1, "Mimikatz", "mimikatz"}}},
+	)
+
+	records := collectBrowserHistory(t, `C:\Users\alice\AppData\Local\Microsoft\Edge\User Data\Profile 1\History`, data)
+	if !assert.Len(t, records, 4) {
+		return
+	}
+	visit := records[1]
+	assert.Equal(t, "2024-05-01T12:01:00Z", visit["@timestamp"])
+	assert.Equal(t, map[string]interface{}{"full": "https://github.com/gentilkiwi/mimikatz", "domain": "github.com"}, visit["url"])
+	assert.Equal(t, map[string]interface{}{
+		"name": "edge", "family": "chromium", "profile": "Profile 1", "type": "visit",
+		"title": "mimikatz", "visit_count": int64(3), "typed_count": int64(1), "transition": "link",
+		"visit_duration": 2.5, "from_url": "https://www.google.com/search?q=mimikatz",
+	}, visit["browser"])
+	assert.Equal(t, "typed", records[0]["browser"].(map[string]interface{})["transition"])
+
+	download := records[2]
+	assert.Equal(t, "browser-download", download["event"].(map[string]interface{})["action"])
+	assert.Equal(t, "2024-05-01T12:01:40Z", download["@timestamp"])
+	assert.Equal(t, "https://objects.githubusercontent.com/mimikatz.zip", download["url"].(map[string]interface{})["full"])
+	d := download["browser"].(map[string]interface{})
+	assert.Equal(t, `C:\Users\alice\Downloads\mimikatz.zip`, d["target_path"])
+	assert.Equal(t, "complete", d["state"])
+	assert.Equal(t, "2024-05-01T12:01:50Z", d["end_time"])
+	assert.Equal(t, true, d["opened"])
+	assert.Len(t, d["url_chain"], 2)
+
+	search := records[3]
+	assert.Equal(t, "Mimikatz", search["browser"].(map[string]interface{})["search_term"])
+	assert.Equal(t, "2024-05-01T12:00:00Z", search["@timestamp"])
+}
+
+func TestFirefoxHistory(t *testing.T) {
+	places := buildTestSQLiteDB(
+		testSQLiteTable{name: "moz_places",
+			sql: "CREATE TABLE moz_places (id INTEGER PRIMARY KEY, url LONGVARCHAR, title LONGVARCHAR, rev_host LONGVARCHAR, visit_count INTEGER DEFAULT 0, hidden INTEGER DEFAULT 0 NOT NULL, typed INTEGER DEFAULT 0 NOT NULL, frecency INTEGER DEFAULT -1 NOT NULL, last_visit_date INTEGER , guid TEXT)",
+			rows: [][]interface{}{
+				{nil, "https://www.mozilla.org/", "Mozilla", "gro.allizom.www.", 2, 0, 1, 100, 1714564800000000, "a"},
+				{nil, "https://dl.example/tool.zip", nil, "elpmaxe.ld.", 1, 0, 0, 100, 1714564801000000, "b"},
+			}},
+		testSQLiteTable{name: "moz_historyvisits",
+			sql: "CREATE TABLE moz_historyvisits (id INTEGER PRIMARY KEY, from_visit INTEGER, place_id INTEGER, visit_date INTEGER, visit_type INTEGER, session INTEGER)",
+			rows: [][]interface{}{
+				{nil, 0, 1, 1714564800000000, 2, 0},
+				{nil, 1, 2, 1714564801000000, 7, 0},
+			}},
+		testSQLiteTable{name: "moz_anno_attributes",
+			sql:  "CREATE TABLE moz_anno_attributes (id INTEGER PRIMARY KEY,name VARCHAR(32) UNIQUE NOT NULL)",
+			rows: [][]interface{}{{nil, FIREFOX_DOWNLOAD_TARGET}, {nil, FIREFOX_DOWNLOAD_METADATA}}},
+		testSQLiteTable{name: "moz_annos",
+			sql: "CREATE TABLE moz_annos (id INTEGER PRIMARY KEY,place_id INTEGER NOT NULL,anno_attribute_id INTEGER,content LONGVARCHAR, flags INTEGER DEFAULT 0,expiration INTEGER DEFAULT 0,type INTEGER DEFAULT 0,dateAdded INTEGER DEFAULT 0,lastModified INTEGER DEFAULT 0)",
+			rows: [][]interface{}{
+				{nil, 2, 1, "file:///C:/Users/alice/Downloads/tool%20x.zip", 0, 4, 3, 1714564802000000, 0},
+				{nil, 2, 2, `{"state":1,"endTime":1714564803000,"fileSize":2048}`, 0, 4, 3, 1714564802000000, 0},
+			}},
+	)
+	records := collectBrowserHistory(t, `C:\Users\alice\AppData\Roaming\Mozilla\Firefox\Profiles\x1y2.default-release\places.sqlite`, places)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, "2024-05-01T12:00:00Z", records[0]["@timestamp"])
+		b := records[0]["browser"].(map[string]interface{})
+		assert.Equal(t, "firefox", b["name"])
+		assert.Equal(t, "x1y2.default-release", b["profile"])
+		assert.Equal(t, "typed", b["transition"])
+		assert.Equal(t, "https://www.mozilla.org/", records[1]["browser"].(map[string]interface{})["from_url"])
+		assert.NotContains(t, records[1]["browser"], "title")
+		assert.Equal(t, map[string]interface{}{
+			"name": "firefox", "family": "firefox", "profile": "x1y2.default-release", "type": "download",
+			"target_path": `C:\Users\alice\Downloads\tool x.zip`, "state": "complete", "total_bytes": int64(2048),
+			"end_time": "2024-05-01T12:00:03Z",
+		}, records[2]["browser"])
+		assert.Equal(t, "2024-05-01T12:00:02Z", records[2]["@timestamp"])
+	}
+
+	form := buildTestSQLiteDB(testSQLiteTable{name: "moz_formhistory",
+		sql: "CREATE TABLE moz_formhistory (id INTEGER PRIMARY KEY, fieldname TEXT NOT NULL, value TEXT NOT NULL, timesUsed INTEGER, firstUsed INTEGER, lastUsed INTEGER, guid TEXT)",
+		rows: [][]interface{}{
+			{nil, "searchbar-history", "psexec download", 2, 1714564800000000, 1714564900000000, "g1"},
+			{nil, "email", "alice@example.com", 1, 1714564800000000, 1714564800000000, "g2"},
+		}})
+	records = collectBrowserHistory(t, `/home/alice/.mozilla/firefox/abc.default/formhistory.sqlite`, form)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "2024-05-01T12:01:40Z", records[0]["@timestamp"])
+		assert.NotContains(t, records[0], "url")
+		b := records[0]["browser"].(map[string]interface{})
+		assert.Equal(t, "psexec download", b["search_term"])
+		assert.Equal(t, "2024-05-01T12:00:00Z", b["first_used"])
+	}
+}
+
+func TestBrowserHistoryWAL(t *testing.T) {
+	form := func(rows ...[]interface{}) []byte {
+		data := buildTestSQLiteDB(testSQLiteTable{name: "moz_formhistory",
+			sql:  "CREATE TABLE moz_formhistory (id INTEGER PRIMARY KEY, fieldname TEXT NOT NULL, value TEXT NOT NULL, timesUsed INTEGER, firstUsed INTEGER, lastUsed INTEGER, guid TEXT)",
+			rows: rows})
+		data[18], data[19] = SQLITE_WAL_MODE, SQLITE_WAL_MODE
+		return data
+	}
+	first := []interface{}{nil, "searchbar-history", "psexec download", 1, 1714564800000000, 1714564800000000, "g1"}
+	second := []interface{}{nil, "searchbar-history", "procdump lsass", 1, 1714564900000000, 1714564900000000, "g2"}
+	old, updated := form(first), form(first, second)
+	wal := testSQLiteWAL(4096, testSQLiteWALFrame{page: 2, commit: 2, data: updated[4096:]})
+
+	p, err := newBrowserHistoryParser(parserOptions{})
+	assert.NoError(t, err)
+	path := `/home/alice/.mozilla/firefox/abc.default/formhistory.sqlite`
+	assert.True(t, p.Match(path+"-wal"))
+	assert.False(t, p.Match(path+"-shm"))
+	parse := func(path string, data []byte) []string {
+		var terms []string
+		assert.NoError(t, p.Parse(path, bytes.NewReader(data), func(record map[string]interface{}) error {
+			terms = append(terms, record["browser"].(map[string]interface{})["search_term"].(string))
+			return nil
+		}))
+		return terms
+	}
+	// Из журнала выгружаются только записи, которых нет в основном файле
+	assert.Equal(t, []string{"psexec download"}, parse(path, old))
+	assert.Equal(t, []string{"procdump lsass"}, parse(path+"-wal", wal))
+	// Журнал без разобранной перед ним базы пропускается
+	assert.Empty(t, parse(path+"-wal", wal))
+	assert.Empty(t, parse(`/home/alice/.mozilla/firefox/other/formhistory.sqlite-wal`, wal))
+}
+
+func TestSafariHistory(t *testing.T) {
+	data := buildTestSQLiteDB(
+		testSQLiteTable{name: "history_items",
+			sql:  "CREATE TABLE history_items (id INTEGER PRIMARY KEY AUTOINCREMENT,url TEXT NOT NULL UNIQUE,domain_expansion TEXT NULL,visit_count INTEGER NOT NULL)",
+			rows: [][]interface{}{{nil, "http://apple.com/", "apple", 3}, {nil, "https://www.apple.com/", "apple", 3}}},
+		testSQLiteTable{name: "history_visits",
+			sql: "CREATE TABLE history_visits (id INTEGER PRIMARY KEY AUTOINCREMENT,history_item INTEGER NOT NULL,visit_time REAL NOT NULL,title TEXT NULL,load_successful BOOLEAN NOT NULL DEFAULT 1,http_non_get BOOLEAN NOT NULL DEFAULT 0,synthesized BOOLEAN NOT NULL DEFAULT 0,redirect_source INTEGER NULL UNIQUE,redirect_destination INTEGER NULL UNIQUE)",
+			rows: [][]interface{}{
+				{nil, 1, 736257600.5, nil, 1, 0, 0, nil, 2},
+				{nil, 2, 736257601.0, "Apple", 1, 0, 0, 1, nil},
+			}},
+	)
+	records := collectBrowserHistory(t, `/Users/alice/Library/Safari/History.db`, data)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "2024-05-01T12:00:00.5Z", records[0]["@timestamp"])
+		b := records[1]["browser"].(map[string]interface{})
+		assert.Equal(t, "safari", b["name"])
+		assert.Equal(t, "Apple", b["title"])
+		assert.Equal(t, "http://apple.com/", b["from_url"])
+		assert.Equal(t, true, b["load_successful"])
+	}
+
+	p, _ := newBrowserHistoryParser(parserOptions{})
+	assert.False(t, p.Match(`C:\Users\alice\AppData\Local\Google\Chrome\User Data\Default\History-journal`))
+	assert.True(t, p.Match(`/Users/alice/Library/Application Support/Google/Chrome/Default/Archived History`))
+	other := buildTestSQLiteDB(testSQLiteTable{name: "t", sql: "CREATE TABLE t(a)"})
+	assert.Error(t, p.Parse("History", bytes.NewReader(other), func(map[string]interface{}) error { return nil }))
+}
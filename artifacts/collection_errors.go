@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os/exec"
+	"time"
+)
+
+// Коды причин, по которым элемент не был собран.
+const (
+	REASON_NOT_FOUND         = "not_found"
+	REASON_ACCESS_DENIED     = "access_denied"
+	REASON_TOO_LARGE         = "too_large"
+	REASON_READ_ERROR        = "read_error"
+	REASON_WRITE_ERROR       = "write_error"
+	REASON_PARSE_ERROR       = "parse_error"
+	REASON_FILESYSTEM_ERROR  = "filesystem_error"
+	REASON_UNRESOLVED_PATH   = "unresolved_path"
+	REASON_COMMAND_NOT_FOUND = "command_not_found"
+	REASON_COMMAND_FAILED    = "command_failed"
+	REASON_QUERY_FAILED      = "query_failed"
+	REASON_INVALID_SOURCE    = "invalid_source"
+	REASON_UNSUPPORTED       = "unsupported"
+	REASON_KNOWN_GOOD        = "known_good"
+)
+
+// CollectionErrorRecord — запись errors.jsonl о пропущенном, недоступном или не собранном элементе.
+type CollectionErrorRecord struct {
+	Timestamp string `json:"@timestamp"`
+	Artifact  string `json:"artifact"`
+	Source    string `json:"source"`
+	Path      string `json:"path"`
+	Reason    string `json:"reason"`
+	Error     string `json:"error,omitempty"`
+}
+
+// reasonForError определяет код причины по ошибке файловой системы или запуска команды.
+func reasonForError(err error) string {
+	var execErr *exec.Error
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, fs.ErrNotExist):
+		return REASON_NOT_FOUND
+	case errors.Is(err, fs.ErrPermission):
+		return REASON_ACCESS_DENIED
+	case errors.As(err, &execErr) && errors.Is(execErr.Err, exec.ErrNotFound):
+		return REASON_COMMAND_NOT_FOUND
+	case errors.As(err, &exitErr):
+		return REASON_COMMAND_FAILED
+	}
+	return REASON_READ_ERROR
+}
+
+// newCollectionErrorRecord формирует запись об ошибке сбора с текущим временем.
+func newCollectionErrorRecord(artifact, source, path, reason string, err error) *CollectionErrorRecord {
+	rec := &CollectionErrorRecord{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Artifact:  artifact,
+		Source:    source,
+		Path:      path,
+		Reason:    reason,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	return rec
+}
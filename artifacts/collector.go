@@ -95,6 +95,17 @@ func (c *Collector) RegisterSource(artifactDefinition *ArtifactDefinition, artif
 	}
 }
 
+// AddOfflineCollector добавляет сборщик, которому источники передаются напрямую, без проверки
+// поддержки текущей ОС: так кусты реестра образа Windows разбираются и на Linux.
+func (c *Collector) AddOfflineCollector(collector AbstractCollector, pairs []ArtifactSourcePair) {
+	for _, pair := range pairs {
+		if collector.RegisterSource(pair.definition, pair.source, c.variables) {
+			c.sources++
+		}
+	}
+	c.collectors = append(c.collectors, collector)
+}
+
 // Collect выполняет сбор артефактов со всех источников и закрывает output.
 func (c *Collector) Collect(output *Outputs) {
 	for _, collector := range c.collectors {
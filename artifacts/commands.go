@@ -46,6 +46,8 @@ func (c *CommandExecutor) Collect(output *Outputs) {
 		if runtime.GOOS == "windows" && strings.Contains(cm.Cmd, "/") {
 			logger.Log(LevelDebug,
 				fmt.Sprintf("Skipping Unix-style command on Windows: %s", fullCmdStr))
+			output.AddCollectionError(cm.Artifact, TYPE_INDICATOR_COMMAND, fullCmdStr, REASON_UNSUPPORTED,
+				fmt.Errorf("unix-style command on windows"))
 			continue
 		}
 
@@ -61,6 +63,7 @@ func (c *CommandExecutor) Collect(output *Outputs) {
 		}
 
 		if err != nil {
+			output.AddCollectionError(cm.Artifact, TYPE_INDICATOR_COMMAND, fullCmdStr, reasonForError(err), err)
 			var exitErr *exec.ExitError
 			var execErr *exec.Error
 			if errors.As(err, &exitErr) {
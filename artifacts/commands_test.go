@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"os"
 	"runtime"
 	"strings"
@@ -31,7 +32,7 @@ func TestCommandExecution(t *testing.T) {
 	defer os.RemoveAll(dir)
 
 	// Инициализируем outputs
-	outputs, err := NewOutputs(dir, "0", false, false, "")
+	outputs, err := NewOutputs(dir, "0", false, false)
 	assert.NoError(t, err)
 	defer outputs.Close()
 
@@ -72,11 +73,46 @@ func TestCommandExecution(t *testing.T) {
 		"Command output should contain expected text")
 }
 
-// Дополнительные функции для совместимости с тестами
+// Дополнительные функции для совместимости с тестами: результаты читаются из потоковых JSONL-файлов.
 func (o *Outputs) GetCommands() map[string]map[string]string {
-	return o.commands
+	commands := make(map[string]map[string]string)
+	readJSONL(o.commands.path, func(line []byte) error {
+		var rec streamRecord
+		var output string
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(rec.Payload, &output); err != nil {
+			return err
+		}
+		if commands[rec.Artifact] == nil {
+			commands[rec.Artifact] = make(map[string]string)
+		}
+		commands[rec.Artifact][rec.Source] = output
+		return nil
+	})
+	return commands
 }
 
 func (o *Outputs) GetRegistry() map[string]map[string]map[string]interface{} {
-	return o.registry
+	registry := make(map[string]map[string]map[string]interface{})
+	readJSONL(o.registry.path, func(line []byte) error {
+		var rec streamRecord
+		var value map[string]interface{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(rec.Payload, &value); err != nil {
+			return err
+		}
+		if registry[rec.Artifact] == nil {
+			registry[rec.Artifact] = make(map[string]map[string]interface{})
+		}
+		name, _ := value["name"].(string)
+		registry[rec.Artifact][rec.Source] = map[string]interface{}{
+			name: map[string]interface{}{"value": value["value"], "type": value["type"]},
+		}
+		return nil
+	})
+	return registry
 }
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Составной файл OLE ([MS-CFB]): сектора, связанные в цепочки таблицей FAT, каталог
+// записей потоков и мини-поток для потоков меньше порога (обычно 4096 байт), разбитый
+// на мини-сектора по 64 байта с собственной таблицей MiniFAT.
+const (
+	CFB_HEADER_SIZE    = 512
+	CFB_DIR_ENTRY_SIZE = 128
+	CFB_HEADER_DIFAT   = 109
+
+	CFB_END_OF_CHAIN = 0xFFFFFFFE
+
+	CFB_TYPE_STREAM = 2
+	CFB_TYPE_ROOT   = 5
+)
+
+var cfbSignature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// CompoundFile — составной файл, прочитанный в память.
+type CompoundFile struct {
+	data        []byte
+	sectorSize  int
+	miniSize    int
+	miniCutoff  uint64
+	fat         []uint32
+	miniFAT     []uint32
+	miniStream  []byte
+	streams     map[string]cfbEntry
+	streamOrder []string
+}
+
+type cfbEntry struct {
+	start uint32
+	size  uint64
+}
+
+// ParseCompoundFile разбирает заголовок, таблицы FAT и MiniFAT и каталог составного файла.
+// Иерархия хранилищ не учитывается: потоки доступны по имени.
+func ParseCompoundFile(data []byte) (*CompoundFile, error) {
+	if len(data) < CFB_HEADER_SIZE || !bytes.Equal(data[:8], cfbSignature) {
+		return nil, fmt.Errorf("not a compound file: bad signature")
+	}
+	le := binary.LittleEndian
+	shift, miniShift := le.Uint16(data[30:]), le.Uint16(data[32:])
+	if shift != 9 && shift != 12 || miniShift != 6 {
+		return nil, fmt.Errorf("compound file: unsupported sector shift %d/%d", shift, miniShift)
+	}
+	cf := &CompoundFile{
+		data:       data,
+		sectorSize: 1 << shift,
+		miniSize:   1 << miniShift,
+		miniCutoff: uint64(le.Uint32(data[56:])),
+		streams:    make(map[string]cfbEntry),
+	}
+
+	// Сектора FAT перечислены в DIFAT: 109 записей заголовка и цепочка секторов DIFAT.
+	// Каждый сектор учитывается один раз, иначе зацикленный DIFAT раздувает FAT.
+	var fatSectors []uint32
+	seen := make(map[uint32]bool)
+	addFATSector := func(s uint32) {
+		if s < CFB_END_OF_CHAIN && !seen[s] && len(fatSectors) < cf.sectors() {
+			seen[s] = true
+			fatSectors = append(fatSectors, s)
+		}
+	}
+	for i := 0; i < CFB_HEADER_DIFAT; i++ {
+		addFATSector(le.Uint32(data[76+i*4:]))
+	}
+	difat, numDIFAT := le.Uint32(data[68:]), min(int(le.Uint32(data[72:])), cf.sectors())
+	perSector := cf.sectorSize/4 - 1
+	visited := make(map[uint32]bool)
+	for i := 0; i < numDIFAT && difat < CFB_END_OF_CHAIN && !visited[difat]; i++ {
+		visited[difat] = true
+		sector, err := cf.sector(difat)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < perSector; j++ {
+			addFATSector(le.Uint32(sector[j*4:]))
+		}
+		difat = le.Uint32(sector[perSector*4:])
+	}
+	for _, s := range fatSectors {
+		sector, err := cf.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for j := 0; j < cf.sectorSize/4; j++ {
+			cf.fat = append(cf.fat, le.Uint32(sector[j*4:]))
+		}
+	}
+
+	miniFAT, err := cf.chain(le.Uint32(data[60:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("compound file MiniFAT: %w", err)
+	}
+	for j := 0; j+4 <= len(miniFAT); j += 4 {
+		cf.miniFAT = append(cf.miniFAT, le.Uint32(miniFAT[j:]))
+	}
+
+	dir, err := cf.chain(le.Uint32(data[48:]), 0)
+	if err != nil {
+		return nil, fmt.Errorf("compound file directory: %w", err)
+	}
+	for off := 0; off+CFB_DIR_ENTRY_SIZE <= len(dir); off += CFB_DIR_ENTRY_SIZE {
+		e := dir[off : off+CFB_DIR_ENTRY_SIZE]
+		nameLen := int(le.Uint16(e[64:]))
+		if nameLen < 2 || nameLen > 64 {
+			continue
+		}
+		entry := cfbEntry{start: le.Uint32(e[116:]), size: le.Uint64(e[120:])}
+		if cf.sectorSize == 512 {
+			// В версии 3 старшие 32 бита размера могут быть не заполнены
+			entry.size &= 0xFFFFFFFF
+		}
+		switch e[66] {
+		case CFB_TYPE_ROOT:
+			if cf.miniStream, err = cf.chain(entry.start, entry.size); err != nil {
+				return nil, fmt.Errorf("compound file mini stream: %w", err)
+			}
+		case CFB_TYPE_STREAM:
+			name := decodeUTF16(e[:nameLen])
+			if _, dup := cf.streams[name]; !dup {
+				cf.streamOrder = append(cf.streamOrder, name)
+			}
+			cf.streams[name] = entry
+		}
+	}
+	return cf, nil
+}
+
+// sectors возвращает число секторов файла после заголовка — предел длины любой цепочки.
+func (cf *CompoundFile) sectors() int {
+	return len(cf.data)/cf.sectorSize - 1
+}
+
+// sector возвращает содержимое сектора по номеру.
+func (cf *CompoundFile) sector(n uint32) ([]byte, error) {
+	off := (int64(n) + 1) * int64(cf.sectorSize)
+	if off+int64(cf.sectorSize) > int64(len(cf.data)) {
+		return nil, fmt.Errorf("sector %d out of file", n)
+	}
+	return cf.data[off : off+int64(cf.sectorSize)], nil
+}
+
+// chain собирает цепочку секторов FAT начиная с start; size > 0 обрезает результат.
+func (cf *CompoundFile) chain(start uint32, size uint64) ([]byte, error) {
+	var out []byte
+	for s, n := start, 0; s < CFB_END_OF_CHAIN; n++ {
+		if n > len(cf.fat) || n > cf.sectors() || int(s) >= len(cf.fat) {
+			return nil, fmt.Errorf("broken sector chain at %d", s)
+		}
+		sector, err := cf.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, sector...)
+		if size > 0 && uint64(len(out)) >= size {
+			break
+		}
+		s = cf.fat[s]
+	}
+	if size > 0 && uint64(len(out)) > size {
+		out = out[:size]
+	}
+	return out, nil
+}
+
+// miniChain собирает цепочку мини-секторов из мини-потока.
+func (cf *CompoundFile) miniChain(start uint32, size uint64) ([]byte, error) {
+	var out []byte
+	for s, n := start, 0; s < CFB_END_OF_CHAIN && uint64(len(out)) < size; n++ {
+		off := int(s) * cf.miniSize
+		if n > len(cf.miniFAT) || n*cf.miniSize >= len(cf.miniStream) || int(s) >= len(cf.miniFAT) || off+cf.miniSize > len(cf.miniStream) {
+			return nil, fmt.Errorf("broken mini sector chain at %d", s)
+		}
+		out = append(out, cf.miniStream[off:off+cf.miniSize]...)
+		s = cf.miniFAT[s]
+	}
+	if uint64(len(out)) < size {
+		return nil, fmt.Errorf("mini stream chain shorter than %d bytes", size)
+	}
+	return out[:size], nil
+}
+
+// Streams возвращает имена потоков в порядке каталога.
+func (cf *CompoundFile) Streams() []string {
+	return cf.streamOrder
+}
+
+// Stream возвращает содержимое потока по имени.
+func (cf *CompoundFile) Stream(name string) ([]byte, error) {
+	entry, ok := cf.streams[name]
+	if !ok {
+		return nil, fmt.Errorf("stream %q not found", name)
+	}
+	if entry.size == 0 {
+		return []byte{}, nil
+	}
+	if entry.size < cf.miniCutoff {
+		return cf.miniChain(entry.start, entry.size)
+	}
+	data, err := cf.chain(entry.start, entry.size)
+	if err == nil && uint64(len(data)) < entry.size {
+		err = fmt.Errorf("stream %q chain shorter than %d bytes", name, entry.size)
+	}
+	return data, err
+}
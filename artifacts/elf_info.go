@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ELF_MIME_TYPE — MIME-тип, присваиваемый файлам с сигнатурой ELF.
+const ELF_MIME_TYPE = "application/x-executable"
+
+// NT_GNU_BUILD_ID — тип заметки GNU с идентификатором сборки.
+const NT_GNU_BUILD_ID = 3
+
+// Наибольший читаемый размер сегментов PT_INTERP и PT_NOTE: их размеры из заголовков
+// программы не проверены и могут превышать размер файла.
+const (
+	MAX_ELF_INTERP_SIZE = 4 * 1024
+	MAX_ELF_NOTE_SIZE   = 16 * 1024
+)
+
+var elfMagic = []byte(elf.ELFMAG)
+
+// isELF проверяет сигнатуру ELF в начале данных.
+func isELF(data []byte) bool {
+	return bytes.HasPrefix(data, elfMagic)
+}
+
+// parseELF разбирает заголовки ELF-файла через debug/elf и записывает
+// сведения о нём в file.elf.
+func (f *FileInfo) parseELF() error {
+	ef, err := elf.NewFile(bytes.NewReader(f.content))
+	if err != nil {
+		return fmt.Errorf("debug/elf NewFile: %w", err)
+	}
+	defer ef.Close()
+
+	for k, v := range elfInfo(ef) {
+		f.addProp("elf", k, v)
+	}
+	return nil
+}
+
+// elfInfo собирает архитектуру, тип, интерпретатор, build-id, зависимости,
+// статистику символов и секции ELF-файла.
+func elfInfo(ef *elf.File) map[string]interface{} {
+	info := map[string]interface{}{
+		"architecture": strings.TrimPrefix(ef.Machine.String(), "EM_"),
+		"class":        strings.TrimPrefix(ef.Class.String(), "ELFCLASS"),
+		"byte_order":   strings.ToLower(strings.TrimSuffix(ef.ByteOrder.String(), "Endian")),
+		"os_abi":       strings.TrimPrefix(ef.OSABI.String(), "ELFOSABI_"),
+		"type":         strings.ToLower(strings.TrimPrefix(ef.Type.String(), "ET_")),
+		"entry_point":  fmt.Sprintf("0x%x", ef.Entry),
+	}
+
+	interp := ""
+	dynamic := false
+	for _, p := range ef.Progs {
+		switch p.Type {
+		case elf.PT_INTERP:
+			if data, err := readELFSegment(p, MAX_ELF_INTERP_SIZE); err == nil {
+				interp = strings.TrimRight(string(data), "\x00")
+			}
+		case elf.PT_DYNAMIC:
+			dynamic = true
+		}
+	}
+	if interp != "" {
+		info["interpreter"] = interp
+	}
+	// Позиционно-независимый исполняемый файл — ET_DYN с интерпретатором
+	info["pie"] = ef.Type == elf.ET_DYN && interp != ""
+	info["static"] = interp == "" && !dynamic
+	info["stripped"] = ef.Section(".symtab") == nil
+
+	if id := elfBuildID(ef); id != "" {
+		info["build_id"] = id
+	}
+	if libs, err := ef.ImportedLibraries(); err == nil && len(libs) > 0 {
+		info["needed"] = libs
+	}
+
+	imports, exports := elfDynamicSymbols(ef)
+	info["imported_symbols"] = len(imports)
+	info["exported_symbols"] = exports
+	if len(imports) > 0 {
+		info["imphash"] = elfImpHash(imports)
+	}
+
+	sections := make([]map[string]interface{}, 0, len(ef.Sections))
+	for _, s := range ef.Sections {
+		if s.Type == elf.SHT_NULL {
+			continue
+		}
+		sec := map[string]interface{}{
+			"name": s.Name,
+			"type": strings.TrimPrefix(s.Type.String(), "SHT_"),
+			"size": s.Size,
+		}
+		if s.Type != elf.SHT_NOBITS {
+			if data, err := s.Data(); err == nil {
+				sec["entropy"] = shannonEntropy(data)
+			}
+		}
+		sections = append(sections, sec)
+	}
+	info["sections"] = sections
+	return info
+}
+
+// elfBuildID ищет заметку GNU build-id сначала в секциях, затем — для файлов
+// без таблицы секций — в сегментах PT_NOTE.
+func elfBuildID(ef *elf.File) string {
+	for _, s := range ef.Sections {
+		if s.Type != elf.SHT_NOTE {
+			continue
+		}
+		if data, err := s.Data(); err == nil {
+			if id := findBuildIDNote(data, ef.ByteOrder); id != "" {
+				return id
+			}
+		}
+	}
+	for _, p := range ef.Progs {
+		if p.Type != elf.PT_NOTE {
+			continue
+		}
+		if data, err := readELFSegment(p, MAX_ELF_NOTE_SIZE); err == nil {
+			if id := findBuildIDNote(data, ef.ByteOrder); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// readELFSegment читает не более limit байт сегмента; сегмент, выходящий за конец файла,
+// читается до конца файла.
+func readELFSegment(p *elf.Prog, limit int64) ([]byte, error) {
+	return io.ReadAll(io.LimitReader(p.Open(), limit))
+}
+
+// findBuildIDNote перебирает заметки ELF (namesz, descsz, type, имя и описание
+// с выравниванием на 4 байта) и возвращает build-id в шестнадцатеричном виде.
+func findBuildIDNote(data []byte, order binary.ByteOrder) string {
+	align := func(n uint32) int { return int((n + 3) &^ 3) }
+	for len(data) >= 12 {
+		namesz := order.Uint32(data[0:4])
+		descsz := order.Uint32(data[4:8])
+		typ := order.Uint32(data[8:12])
+		data = data[12:]
+		if align(namesz) > len(data) {
+			return ""
+		}
+		name := strings.TrimRight(string(data[:namesz]), "\x00")
+		data = data[align(namesz):]
+		if int(descsz) > len(data) {
+			return ""
+		}
+		desc := data[:descsz]
+		if align(descsz) > len(data) {
+			data = nil
+		} else {
+			data = data[align(descsz):]
+		}
+		if name == "GNU" && typ == NT_GNU_BUILD_ID {
+			return hex.EncodeToString(desc)
+		}
+	}
+	return ""
+}
+
+// elfDynamicSymbols возвращает имена импортируемых (неопределённых) символов и
+// число экспортируемых глобальных функций и объектов динамической таблицы символов.
+func elfDynamicSymbols(ef *elf.File) ([]string, int) {
+	syms, err := ef.DynamicSymbols()
+	if err != nil {
+		if !errors.Is(err, elf.ErrNoSymbols) {
+			logger.Log(LevelDebug, fmt.Sprintf("ELF dynamic symbols: %v", err))
+		}
+		return nil, 0
+	}
+	var imports []string
+	exports := 0
+	for _, s := range syms {
+		if s.Name == "" {
+			continue
+		}
+		bind := elf.ST_BIND(s.Info)
+		if bind != elf.STB_GLOBAL && bind != elf.STB_WEAK {
+			continue
+		}
+		if s.Section == elf.SHN_UNDEF {
+			imports = append(imports, s.Name)
+			continue
+		}
+		switch elf.ST_TYPE(s.Info) {
+		case elf.STT_FUNC, elf.STT_OBJECT:
+			exports++
+		}
+	}
+	return imports, exports
+}
+
+// elfImpHash вычисляет MD5 от отсортированного списка импортируемых символов
+// в нижнем регистре (без версий), разделённых запятыми. Сортировка делает хеш
+// независимым от порядка символов, который выбирает компоновщик.
+func elfImpHash(imports []string) string {
+	names := make([]string, 0, len(imports))
+	for _, name := range imports {
+		if i := strings.IndexByte(name, '@'); i >= 0 {
+			name = name[:i]
+		}
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	sum := md5.Sum([]byte(strings.Join(names, ",")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,730 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// База данных ESE (Extensible Storage Engine, JET Blue): SRUM, Windows Search, WebCache и
+// т. п. Файл разбит на страницы; первые две — заголовок и его копия, страница N лежит по
+// смещению (N+1)*размер. Таблицы хранятся в B+-деревьях: записи на странице адресуются
+// массивом тегов в её конце. Каталог MSysObjects (корень на странице 4) описывает таблицы,
+// их столбцы и деревья длинных значений.
+const (
+	ESE_SIGNATURE          = 0x89ABCDEF
+	ESE_CATALOG_PAGE       = 4
+	ESE_PAGE_HEADER        = 40
+	ESE_LARGE_PAGE_HEADER  = 80
+	ESE_REVISION_EXTENDED  = 0x11
+	ESE_MAX_TREE_DEPTH     = 64
+	ESE_CATALOG_TABLE      = 1
+	ESE_CATALOG_COLUMN     = 2
+	ESE_CATALOG_LONG_VALUE = 4
+
+	ESE_PAGE_LEAF   = 0x0002
+	ESE_PAGE_PARENT = 0x0004
+	ESE_PAGE_EMPTY  = 0x0008
+	ESE_PAGE_SPACE  = 0x0020
+	ESE_PAGE_INDEX  = 0x0040
+	ESE_PAGE_LONG   = 0x0080
+
+	ESE_TAG_DELETED    = 0x2
+	ESE_TAG_COMMON_KEY = 0x4
+
+	// Флаги тегированного значения
+	ESE_TAGGED_COMPRESSED  = 0x02
+	ESE_TAGGED_LONG_VALUE  = 0x04
+	ESE_TAGGED_MULTI_VALUE = 0x08
+
+	// Схемы сжатия значений (старшие 5 бит первого байта)
+	ESE_COMPRESS_7BIT_ASCII   = 1
+	ESE_COMPRESS_7BIT_UNICODE = 2
+	ESE_COMPRESS_XPRESS       = 3
+
+	ESE_CODEPAGE_UNICODE = 1200
+)
+
+// Типы столбцов JET_coltyp.
+const (
+	ESE_COLTYP_BIT            = 1
+	ESE_COLTYP_UNSIGNED_BYTE  = 2
+	ESE_COLTYP_SHORT          = 3
+	ESE_COLTYP_LONG           = 4
+	ESE_COLTYP_CURRENCY       = 5
+	ESE_COLTYP_IEEE_SINGLE    = 6
+	ESE_COLTYP_IEEE_DOUBLE    = 7
+	ESE_COLTYP_DATE_TIME      = 8
+	ESE_COLTYP_BINARY         = 9
+	ESE_COLTYP_TEXT           = 10
+	ESE_COLTYP_LONG_BINARY    = 11
+	ESE_COLTYP_LONG_TEXT      = 12
+	ESE_COLTYP_UNSIGNED_LONG  = 14
+	ESE_COLTYP_LONG_LONG      = 15
+	ESE_COLTYP_GUID           = 16
+	ESE_COLTYP_UNSIGNED_SHORT = 17
+)
+
+// eseFixedSizes — размеры значений типов фиксированной длины.
+var eseFixedSizes = map[uint32]int{
+	ESE_COLTYP_BIT:            1,
+	ESE_COLTYP_UNSIGNED_BYTE:  1,
+	ESE_COLTYP_SHORT:          2,
+	ESE_COLTYP_LONG:           4,
+	ESE_COLTYP_CURRENCY:       8,
+	ESE_COLTYP_IEEE_SINGLE:    4,
+	ESE_COLTYP_IEEE_DOUBLE:    8,
+	ESE_COLTYP_DATE_TIME:      8,
+	ESE_COLTYP_UNSIGNED_LONG:  4,
+	ESE_COLTYP_LONG_LONG:      8,
+	ESE_COLTYP_GUID:           16,
+	ESE_COLTYP_UNSIGNED_SHORT: 2,
+}
+
+// ESEColumn — столбец таблицы из каталога.
+type ESEColumn struct {
+	ID       uint32
+	Name     string
+	Type     uint32
+	Size     int
+	Codepage uint32
+}
+
+// ESETable — таблица базы: корень дерева записей, столбцы и дерево длинных значений.
+type ESETable struct {
+	db       *ESEDatabase
+	Name     string
+	ID       uint32
+	root     uint32
+	lvRoot   uint32
+	Columns  []ESEColumn
+	fixed    map[uint32]int // смещение фиксированного столбца от начала записи
+	long     map[uint32][]byte
+	longErr  error
+	longRead bool
+}
+
+// ESEDatabase — база ESE, прочитанная в память.
+type ESEDatabase struct {
+	data      []byte
+	pageSize  int
+	revision  uint32
+	version   uint32
+	large     bool
+	tables    map[string]*ESETable
+	tableList []string
+}
+
+// eseEntry — запись страницы B+-дерева: полный ключ и данные.
+type eseEntry struct {
+	key  []byte
+	data []byte
+}
+
+// OpenESE разбирает заголовок базы и каталог MSysObjects.
+func OpenESE(data []byte) (*ESEDatabase, error) {
+	if len(data) < 240 || binary.LittleEndian.Uint32(data[4:]) != ESE_SIGNATURE {
+		return nil, fmt.Errorf("not an ESE database: bad signature")
+	}
+	le := binary.LittleEndian
+	db := &ESEDatabase{
+		data:     data,
+		version:  le.Uint32(data[8:]),
+		revision: le.Uint32(data[232:]),
+		pageSize: int(le.Uint32(data[236:])),
+		tables:   make(map[string]*ESETable),
+	}
+	switch db.pageSize {
+	case 2048, 4096, 8192, 16384, 32768:
+	default:
+		return nil, fmt.Errorf("ESE: unsupported page size %d", db.pageSize)
+	}
+	db.large = db.pageSize >= 16384 && db.revision >= ESE_REVISION_EXTENDED
+	if err := db.readCatalog(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// page возвращает содержимое страницы по номеру.
+func (db *ESEDatabase) page(n uint32) ([]byte, error) {
+	off := (int64(n) + 1) * int64(db.pageSize)
+	if n == 0 || off+int64(db.pageSize) > int64(len(db.data)) {
+		return nil, fmt.Errorf("ESE page %d out of file", n)
+	}
+	return db.data[off : off+int64(db.pageSize)], nil
+}
+
+// pageTags возвращает флаги страницы и содержимое её тегов с флагами тегов.
+func (db *ESEDatabase) pageTags(page []byte) (uint32, [][]byte, []byte) {
+	le := binary.LittleEndian
+	flags := le.Uint32(page[36:])
+	header := ESE_PAGE_HEADER
+	sizeMask, offMask := uint16(0x1FFF), uint16(0x1FFF)
+	if db.large {
+		header = ESE_LARGE_PAGE_HEADER
+		sizeMask, offMask = 0x7FFF, 0x7FFF
+	}
+	count := int(le.Uint16(page[34:]))
+	tags := make([][]byte, 0, count)
+	tagFlags := make([]byte, 0, count)
+	for i := 0; i < count; i++ {
+		pos := len(page) - 4*(i+1)
+		if pos < header {
+			break
+		}
+		size, rawOff := le.Uint16(page[pos:])&sizeMask, le.Uint16(page[pos+2:])
+		start := header + int(rawOff&offMask)
+		end := start + int(size)
+		if end > len(page) {
+			tags = append(tags, nil)
+			tagFlags = append(tagFlags, ESE_TAG_DELETED)
+			continue
+		}
+		value := page[start:end]
+		var f byte
+		if db.large {
+			// Флаги тега хранятся в старших битах первых двух байтов данных
+			if len(value) >= 2 {
+				f = value[1] >> 5
+				value = append([]byte{value[0], value[1] & 0x1F}, value[2:]...)
+			}
+		} else {
+			f = byte(rawOff >> 13)
+		}
+		tags = append(tags, value)
+		tagFlags = append(tagFlags, f)
+	}
+	return flags, tags, tagFlags
+}
+
+// walkTree обходит B+-дерево с корнем root и передаёт записи листовых страниц в fn
+// по порядку ключей. Ключ записи восстанавливается из общего префикса страницы (тег 0).
+func (db *ESEDatabase) walkTree(root uint32, fn func(e eseEntry) error) error {
+	visited := make(map[uint32]bool)
+	var walk func(n uint32, depth int) error
+	walk = func(n uint32, depth int) error {
+		if depth > ESE_MAX_TREE_DEPTH || visited[n] {
+			return fmt.Errorf("ESE: page loop at %d", n)
+		}
+		visited[n] = true
+		page, err := db.page(n)
+		if err != nil {
+			return err
+		}
+		flags, tags, tagFlags := db.pageTags(page)
+		if flags&ESE_PAGE_EMPTY != 0 || len(tags) == 0 {
+			return nil
+		}
+		prefix := tags[0]
+		le := binary.LittleEndian
+		for i := 1; i < len(tags); i++ {
+			v := tags[i]
+			if v == nil || tagFlags[i]&ESE_TAG_DELETED != 0 {
+				continue
+			}
+			var common int
+			if tagFlags[i]&ESE_TAG_COMMON_KEY != 0 {
+				if len(v) < 2 {
+					continue
+				}
+				common, v = int(le.Uint16(v)), v[2:]
+			}
+			if len(v) < 2 {
+				continue
+			}
+			local := int(le.Uint16(v))
+			if 2+local > len(v) || common > len(prefix) {
+				continue
+			}
+			key := append(append([]byte(nil), prefix[:common]...), v[2:2+local]...)
+			v = v[2+local:]
+			if flags&ESE_PAGE_PARENT != 0 {
+				if len(v) < 4 {
+					continue
+				}
+				if err := walk(le.Uint32(v[len(v)-4:]), depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if flags&ESE_PAGE_LEAF != 0 {
+				if err := fn(eseEntry{key: key, data: v}); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	return walk(root, 0)
+}
+
+// eseCatalogColumns — фиксированные столбцы каталога MSysObjects до PagesOrLocale.
+var eseCatalogColumns = []ESEColumn{
+	{ID: 1, Name: "ObjidTable", Type: ESE_COLTYP_LONG, Size: 4},
+	{ID: 2, Name: "Type", Type: ESE_COLTYP_SHORT, Size: 2},
+	{ID: 3, Name: "Id", Type: ESE_COLTYP_LONG, Size: 4},
+	{ID: 4, Name: "ColtypOrPgnoFDP", Type: ESE_COLTYP_LONG, Size: 4},
+	{ID: 5, Name: "SpaceUsage", Type: ESE_COLTYP_LONG, Size: 4},
+	{ID: 6, Name: "Flags", Type: ESE_COLTYP_LONG, Size: 4},
+	{ID: 7, Name: "PagesOrLocale", Type: ESE_COLTYP_LONG, Size: 4},
+	{ID: 128, Name: "Name", Type: ESE_COLTYP_TEXT},
+}
+
+// readCatalog читает описания таблиц, столбцов и деревьев длинных значений.
+func (db *ESEDatabase) readCatalog() error {
+	catalog := &ESETable{db: db, Name: "MSysObjects", Columns: eseCatalogColumns}
+	catalog.layout()
+	byID := make(map[uint32]*ESETable)
+	type pending struct {
+		table  uint32
+		column ESEColumn
+		lvRoot uint32
+	}
+	var rest []pending
+	err := db.walkTree(ESE_CATALOG_PAGE, func(e eseEntry) error {
+		rec := catalog.parseRecord(e.data)
+		objid, _ := rec["ObjidTable"].(int32)
+		typ, _ := rec["Type"].(int16)
+		id, _ := rec["Id"].(int32)
+		coltyp, _ := rec["ColtypOrPgnoFDP"].(int32)
+		space, _ := rec["SpaceUsage"].(int32)
+		locale, _ := rec["PagesOrLocale"].(int32)
+		name, _ := rec["Name"].(string)
+		switch typ {
+		case ESE_CATALOG_TABLE:
+			t := &ESETable{db: db, Name: name, ID: uint32(objid), root: uint32(coltyp)}
+			byID[t.ID] = t
+			db.tables[strings.ToLower(name)] = t
+			db.tableList = append(db.tableList, name)
+		case ESE_CATALOG_COLUMN:
+			rest = append(rest, pending{table: uint32(objid), column: ESEColumn{
+				ID: uint32(id), Name: name, Type: uint32(coltyp), Size: int(space), Codepage: uint32(locale),
+			}})
+		case ESE_CATALOG_LONG_VALUE:
+			rest = append(rest, pending{table: uint32(objid), lvRoot: uint32(coltyp)})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ESE catalog: %w", err)
+	}
+	for _, p := range rest {
+		t := byID[p.table]
+		if t == nil {
+			continue
+		}
+		if p.lvRoot != 0 {
+			t.lvRoot = p.lvRoot
+		} else {
+			t.Columns = append(t.Columns, p.column)
+		}
+	}
+	for _, t := range byID {
+		sort.Slice(t.Columns, func(i, j int) bool { return t.Columns[i].ID < t.Columns[j].ID })
+		t.layout()
+	}
+	return nil
+}
+
+// Tables возвращает имена таблиц в порядке каталога.
+func (db *ESEDatabase) Tables() []string {
+	return db.tableList
+}
+
+// Table возвращает таблицу по имени без учёта регистра.
+func (db *ESEDatabase) Table(name string) *ESETable {
+	return db.tables[strings.ToLower(name)]
+}
+
+// layout вычисляет смещения фиксированных столбцов: они идут подряд с 4-го байта записи
+// в порядке идентификаторов.
+func (t *ESETable) layout() {
+	t.fixed = make(map[uint32]int)
+	off := 4
+	for id := uint32(1); id < 128; id++ {
+		col := t.column(id)
+		if col == nil {
+			break
+		}
+		size := col.Size
+		if s, ok := eseFixedSizes[col.Type]; ok {
+			size = s
+		}
+		// Размер из каталога (SpaceUsage) может быть нулевым или отрицательным: смещения
+		// этого и следующих столбцов тогда неизвестны
+		if size <= 0 {
+			break
+		}
+		t.fixed[id] = off
+		off += size
+	}
+}
+
+func (t *ESETable) column(id uint32) *ESEColumn {
+	for i := range t.Columns {
+		if t.Columns[i].ID == id {
+			return &t.Columns[i]
+		}
+	}
+	return nil
+}
+
+// Records передаёт в fn значения столбцов каждой записи таблицы по именам столбцов;
+// пустые (NULL) столбцы опускаются.
+func (t *ESETable) Records(fn func(rec map[string]interface{}) error) error {
+	return t.db.walkTree(t.root, func(e eseEntry) error {
+		return fn(t.parseRecord(e.data))
+	})
+}
+
+// parseRecord разбирает запись: заголовок (последний фиксированный и последний
+// переменный идентификаторы, смещение массива переменных столбцов), фиксированные
+// значения с битовой картой NULL, переменные значения и тегированные столбцы.
+func (t *ESETable) parseRecord(data []byte) map[string]interface{} {
+	rec := make(map[string]interface{})
+	if len(data) < 4 {
+		return rec
+	}
+	le := binary.LittleEndian
+	lastFixed, lastVar := uint32(data[0]), uint32(data[1])
+	varOff := int(le.Uint16(data[2:]))
+	if varOff > len(data) {
+		return rec
+	}
+	// Битовая карта NULL фиксированных столбцов предшествует массиву переменных столбцов
+	bitmapLen := int(lastFixed+7) / 8
+	bitmap := data[max(varOff-bitmapLen, 0):varOff]
+
+	varCount := 0
+	if lastVar >= 128 {
+		varCount = int(lastVar) - 127
+	}
+	varData := varOff + varCount*2
+	tagged := len(data)
+	if varData <= len(data) {
+		tagged = varData
+		if varCount > 0 {
+			tagged = varData + int(le.Uint16(data[varData-2:])&0x7FFF)
+		}
+	}
+
+	for _, col := range t.Columns {
+		var raw []byte
+		switch {
+		case col.ID < 128:
+			off, ok := t.fixed[col.ID]
+			if !ok || col.ID > lastFixed {
+				continue
+			}
+			i := int(col.ID - 1)
+			if i/8 < len(bitmap) && bitmap[i/8]&(1<<(i%8)) != 0 {
+				continue
+			}
+			size := col.Size
+			if s, ok := eseFixedSizes[col.Type]; ok {
+				size = s
+			}
+			if off < 4 || size <= 0 || off+size > varOff-bitmapLen {
+				continue
+			}
+			raw = data[off : off+size]
+		case col.ID < 256:
+			if col.ID > lastVar || varData > len(data) {
+				continue
+			}
+			i := int(col.ID - 128)
+			end := le.Uint16(data[varOff+i*2:])
+			if end&0x8000 != 0 {
+				continue
+			}
+			start := 0
+			if i > 0 {
+				start = int(le.Uint16(data[varOff+(i-1)*2:]) & 0x7FFF)
+			}
+			if varData+int(end) > len(data) || start > int(end) {
+				continue
+			}
+			raw = data[varData+start : varData+int(end)]
+		default:
+			raw = t.taggedValue(data, tagged, col.ID)
+		}
+		if raw == nil {
+			continue
+		}
+		if v := eseValue(col, raw); v != nil {
+			rec[col.Name] = v
+		}
+	}
+	return rec
+}
+
+// taggedValue находит значение тегированного столбца (идентификатор 256 и выше):
+// массив пар (идентификатор, смещение) и значения за ним. Первый байт значения может
+// содержать флаги: сжатие, вынос в дерево длинных значений, несколько значений.
+func (t *ESETable) taggedValue(data []byte, start int, id uint32) []byte {
+	le := binary.LittleEndian
+	if start+4 > len(data) {
+		return nil
+	}
+	area := data[start:]
+	offMask := uint16(0x3FFF)
+	if t.db.large {
+		offMask = 0x7FFF
+	}
+	count := int(le.Uint16(area[2:])&offMask) / 4
+	if count == 0 || count*4 > len(area) {
+		return nil
+	}
+	for i := 0; i < count; i++ {
+		if uint32(le.Uint16(area[i*4:])) != id {
+			continue
+		}
+		rawOff := le.Uint16(area[i*4+2:])
+		begin, end := int(rawOff&offMask), len(area)
+		if i+1 < count {
+			end = int(le.Uint16(area[(i+1)*4+2:]) & offMask)
+		}
+		if begin >= end || end > len(area) {
+			return nil
+		}
+		value := area[begin:end]
+		if !t.db.large && rawOff&0x4000 == 0 {
+			return value
+		}
+		flags, value := value[0], value[1:]
+		switch {
+		case flags&ESE_TAGGED_LONG_VALUE != 0:
+			return t.longValue(value)
+		case flags&ESE_TAGGED_MULTI_VALUE != 0:
+			// Несколько значений: массив смещений, берётся первое значение
+			if len(value) < 2 {
+				return nil
+			}
+			first := int(le.Uint16(value) & 0x7FFF)
+			last := len(value)
+			if first >= 4 && len(value) >= 4 {
+				last = int(le.Uint16(value[2:]) & 0x7FFF)
+			}
+			if first > last || last > len(value) {
+				return nil
+			}
+			return value[first:last]
+		case flags&ESE_TAGGED_COMPRESSED != 0:
+			out, err := eseDecompress(value)
+			if err != nil {
+				return nil
+			}
+			return out
+		}
+		return value
+	}
+	return nil
+}
+
+// longValue собирает длинное значение по ссылке из записи. Дерево длинных значений
+// таблицы читается один раз: ключ из 4 байт (идентификатор в big-endian) — корень
+// значения, из 8 байт (идентификатор и смещение) — очередной фрагмент.
+func (t *ESETable) longValue(ref []byte) []byte {
+	if len(ref) != 4 {
+		return nil
+	}
+	if !t.longRead {
+		t.longRead = true
+		t.long = make(map[uint32][]byte)
+		if t.lvRoot != 0 {
+			type segment struct {
+				offset uint32
+				data   []byte
+			}
+			segments := make(map[uint32][]segment)
+			t.longErr = t.db.walkTree(t.lvRoot, func(e eseEntry) error {
+				if len(e.key) == 8 {
+					lid := binary.BigEndian.Uint32(e.key)
+					segments[lid] = append(segments[lid], segment{binary.BigEndian.Uint32(e.key[4:]), e.data})
+				}
+				return nil
+			})
+			for lid, segs := range segments {
+				sort.Slice(segs, func(i, j int) bool { return segs[i].offset < segs[j].offset })
+				var buf []byte
+				for _, s := range segs {
+					buf = append(buf, s.data...)
+				}
+				t.long[lid] = buf
+			}
+		}
+	}
+	return t.long[binary.LittleEndian.Uint32(ref)]
+}
+
+// eseDecompress распаковывает значение, сжатое 7-битной схемой (ASCII или UTF-16) или
+// Xpress (LZ77 без Хаффмана).
+func eseDecompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty compressed value")
+	}
+	switch data[0] >> 3 {
+	case ESE_COMPRESS_7BIT_ASCII, ESE_COMPRESS_7BIT_UNICODE:
+		unicode := data[0]>>3 == ESE_COMPRESS_7BIT_UNICODE
+		bits := (len(data)-2)*8 + int(data[0]&7) + 1
+		var out []byte
+		var acc uint32
+		var n int
+		for i, read := 1, 0; i < len(data) && read+7 <= bits; {
+			for n < 7 && i < len(data) {
+				acc |= uint32(data[i]) << n
+				n += 8
+				i++
+			}
+			for n >= 7 && read+7 <= bits {
+				out = append(out, byte(acc&0x7F))
+				if unicode {
+					out = append(out, 0)
+				}
+				acc >>= 7
+				n -= 7
+				read += 7
+			}
+		}
+		return out, nil
+	case ESE_COMPRESS_XPRESS:
+		if len(data) < 3 {
+			return nil, fmt.Errorf("xpress value truncated")
+		}
+		return decompressXpressPlain(data[3:], int(binary.LittleEndian.Uint16(data[1:])))
+	}
+	return nil, fmt.Errorf("unsupported ESE compression %d", data[0]>>3)
+}
+
+// decompressXpressPlain распаковывает LZ77 Xpress без кодирования Хаффмана
+// ([MS-XCA] 2.4): 32-битные слова флагов, литералы и ссылки (смещение, длина) с
+// расширенной записью длины.
+func decompressXpressPlain(in []byte, size int) ([]byte, error) {
+	le := binary.LittleEndian
+	out := make([]byte, 0, size)
+	var flags uint32
+	var flagCount int
+	pos, halfByte := 0, -1
+	for len(out) < size {
+		if flagCount == 0 {
+			if pos+4 > len(in) {
+				break
+			}
+			flags, flagCount = le.Uint32(in[pos:]), 32
+			pos += 4
+		}
+		flagCount--
+		if flags&(1<<flagCount) == 0 {
+			if pos >= len(in) {
+				break
+			}
+			out = append(out, in[pos])
+			pos++
+			continue
+		}
+		if pos+2 > len(in) {
+			break
+		}
+		match := int(le.Uint16(in[pos:]))
+		pos += 2
+		length, offset := match&7, match>>3+1
+		if length == 7 {
+			if halfByte < 0 {
+				if pos >= len(in) {
+					return out, fmt.Errorf("xpress: truncated length")
+				}
+				length, halfByte = int(in[pos]&0x0F), pos
+				pos++
+			} else {
+				length, halfByte = int(in[halfByte]>>4), -1
+			}
+			if length == 15 {
+				if pos >= len(in) {
+					return out, fmt.Errorf("xpress: truncated length")
+				}
+				length = int(in[pos])
+				pos++
+				if length == 255 {
+					if pos+2 > len(in) {
+						return out, fmt.Errorf("xpress: truncated length")
+					}
+					length = int(le.Uint16(in[pos:]))
+					pos += 2
+					if length == 0 {
+						if pos+4 > len(in) {
+							return out, fmt.Errorf("xpress: truncated length")
+						}
+						length = int(le.Uint32(in[pos:]))
+						pos += 4
+					}
+					if length < 15+7 {
+						return out, fmt.Errorf("xpress: bad match length")
+					}
+					length -= 15 + 7
+				}
+				length += 15
+			}
+			length += 7
+		}
+		length += 3
+		if offset > len(out) {
+			return out, fmt.Errorf("xpress: match offset %d out of output", offset)
+		}
+		// Длина из 32-битного поля может далеко превышать остаток ожидаемых данных
+		length = min(length, size-len(out))
+		for i := 0; i < length; i++ {
+			out = append(out, out[len(out)-offset])
+		}
+	}
+	return out, nil
+}
+
+// oleTimeEpoch — начало отсчёта даты OLE Automation (дни с плавающей точкой).
+var oleTimeEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// eseValue преобразует значение столбца по его типу.
+func eseValue(col ESEColumn, raw []byte) interface{} {
+	le := binary.LittleEndian
+	if size, ok := eseFixedSizes[col.Type]; ok && len(raw) < size {
+		return nil
+	}
+	switch col.Type {
+	case ESE_COLTYP_BIT:
+		return raw[0] != 0
+	case ESE_COLTYP_UNSIGNED_BYTE:
+		return raw[0]
+	case ESE_COLTYP_SHORT:
+		return int16(le.Uint16(raw))
+	case ESE_COLTYP_LONG:
+		return int32(le.Uint32(raw))
+	case ESE_COLTYP_CURRENCY, ESE_COLTYP_LONG_LONG:
+		return int64(le.Uint64(raw))
+	case ESE_COLTYP_IEEE_SINGLE:
+		return math.Float32frombits(le.Uint32(raw))
+	case ESE_COLTYP_IEEE_DOUBLE:
+		return math.Float64frombits(le.Uint64(raw))
+	case ESE_COLTYP_DATE_TIME:
+		days := math.Float64frombits(le.Uint64(raw))
+		if days == 0 || math.IsNaN(days) || math.IsInf(days, 0) {
+			return nil
+		}
+		return oleTimeEpoch.Add(time.Duration(days * 24 * float64(time.Hour)))
+	case ESE_COLTYP_UNSIGNED_LONG:
+		return le.Uint32(raw)
+	case ESE_COLTYP_GUID:
+		return formatGUID(raw)
+	case ESE_COLTYP_UNSIGNED_SHORT:
+		return le.Uint16(raw)
+	case ESE_COLTYP_TEXT, ESE_COLTYP_LONG_TEXT:
+		if col.Codepage == ESE_CODEPAGE_UNICODE {
+			return strings.TrimRight(decodeUTF16Full(raw), "\x00")
+		}
+		return string(bytes.TrimRight(raw, "\x00"))
+	}
+	return append([]byte(nil), raw...)
+}
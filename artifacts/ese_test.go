@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testESEPageSize = 4096
+
+// testESETag — запись страницы: флаги тега, размер общей части ключа, локальный ключ и данные.
+type testESETag struct {
+	flags  uint16
+	common int
+	key    []byte
+	data   []byte
+}
+
+// buildTestESEPage собирает страницу старого формата (заголовок 40 байт, флаги тегов
+// в старших битах смещения); prefix — тег 0 с общим префиксом ключей.
+func buildTestESEPage(flags uint32, prefix []byte, tags []testESETag) []byte {
+	le := binary.LittleEndian
+	page := make([]byte, testESEPageSize)
+	le.PutUint32(page[36:], flags)
+	le.PutUint16(page[34:], uint16(len(tags)+1))
+	off := 0
+	put := func(i int, value []byte, tagFlags uint16) {
+		copy(page[ESE_PAGE_HEADER+off:], value)
+		pos := testESEPageSize - 4*(i+1)
+		le.PutUint16(page[pos:], uint16(len(value)))
+		le.PutUint16(page[pos+2:], uint16(off)|tagFlags<<13)
+		off += len(value)
+	}
+	put(0, prefix, 0)
+	for i, tag := range tags {
+		var value []byte
+		if tag.flags&ESE_TAG_COMMON_KEY != 0 {
+			value = le.AppendUint16(value, uint16(tag.common))
+		}
+		value = le.AppendUint16(value, uint16(len(tag.key)))
+		value = append(append(value, tag.key...), tag.data...)
+		put(i+1, value, tag.flags)
+	}
+	return page
+}
+
+// buildTestESE собирает файл базы из страниц с номерами от 1.
+func buildTestESE(pages map[uint32][]byte) []byte {
+	var last uint32
+	for n := range pages {
+		last = max(last, n)
+	}
+	data := make([]byte, int(last+2)*testESEPageSize)
+	le := binary.LittleEndian
+	le.PutUint32(data[4:], ESE_SIGNATURE)
+	le.PutUint32(data[8:], 0x620)
+	le.PutUint32(data[232:], 0x0c)
+	le.PutUint32(data[236:], testESEPageSize)
+	copy(data[testESEPageSize:], data[:testESEPageSize])
+	for n, page := range pages {
+		copy(data[int(n+1)*testESEPageSize:], page)
+	}
+	return data
+}
+
+// testTagged — значение тегированного столбца с байтом флагов (0 — без него).
+type testTagged struct {
+	id    uint16
+	flags byte
+	data  []byte
+}
+
+// buildTestESERecord собирает запись: фиксированные столбцы по порядку (nulls — номера
+// пустых, начиная с 1), переменные столбцы с 128 и тегированные значения.
+func buildTestESERecord(fixed [][]byte, nulls []int, vars [][]byte, tagged []testTagged) []byte {
+	le := binary.LittleEndian
+	rec := []byte{byte(len(fixed)), byte(127 + len(vars)), 0, 0}
+	for _, f := range fixed {
+		rec = append(rec, f...)
+	}
+	bitmap := make([]byte, (len(fixed)+7)/8)
+	for _, n := range nulls {
+		bitmap[(n-1)/8] |= 1 << ((n - 1) % 8)
+	}
+	rec = append(rec, bitmap...)
+	le.PutUint16(rec[2:], uint16(len(rec)))
+	end := 0
+	for _, v := range vars {
+		end += len(v)
+		rec = le.AppendUint16(rec, uint16(end))
+	}
+	for _, v := range vars {
+		rec = append(rec, v...)
+	}
+	var values []byte
+	header := 4 * len(tagged)
+	for _, t := range tagged {
+		off := uint16(header + len(values))
+		if t.flags != 0 {
+			off |= 0x4000
+			values = append(values, t.flags)
+		}
+		values = append(values, t.data...)
+		rec = le.AppendUint16(rec, t.id)
+		rec = le.AppendUint16(rec, off)
+	}
+	return append(rec, values...)
+}
+
+func testLE32(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+func testLE64(v uint64) []byte { return binary.LittleEndian.AppendUint64(nil, v) }
+
+// testCatalogRecord — запись MSysObjects: таблица, столбец или дерево длинных значений.
+func testCatalogRecord(objid uint32, typ uint16, id, coltyp, size, codepage uint32, name string) testESETag {
+	fixed := [][]byte{
+		testLE32(objid), binary.LittleEndian.AppendUint16(nil, typ), testLE32(id),
+		testLE32(coltyp), testLE32(size), testLE32(0), testLE32(codepage),
+	}
+	key := append([]byte{byte(typ)}, testLE32(id)...)
+	key = append(testLE32(objid), key...)
+	return testESETag{key: key, data: buildTestESERecord(fixed, nil, [][]byte{[]byte(name)}, nil)}
+}
+
+// encodeTest7Bit упаковывает ASCII-строку 7-битной схемой ESE.
+func encodeTest7Bit(s string, scheme byte) []byte {
+	var out []byte
+	var acc uint32
+	n := 0
+	for _, c := range []byte(s) {
+		acc |= uint32(c&0x7F) << n
+		n += 7
+		for n >= 8 {
+			out = append(out, byte(acc))
+			acc >>= 8
+			n -= 8
+		}
+	}
+	lastBits := 8
+	if n > 0 {
+		out = append(out, byte(acc))
+		lastBits = n
+	}
+	return append([]byte{scheme<<3 | byte(lastBits-1)}, out...)
+}
+
+func testOLEDate(t time.Time) []byte {
+	days := float64(t.Sub(oleTimeEpoch)) / float64(24*time.Hour)
+	return testLE64(math.Float64bits(days))
+}
+
+var (
+	testSRUMTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testSRUMSID  = []byte{1, 5, 0, 0, 0, 0, 0, 5, 21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 3, 0, 0, 0, 0xE9, 3, 0, 0}
+	testSRUMApp  = `\Device\HarddiskVolume3\Users\alice\AppData\Local\Temp\beacon.exe`
+)
+
+// buildTestSRUM собирает базу SRUM: каталог на странице 4, SruDbIdMapTable (страница 5)
+// с деревом длинных значений (страница 6) и таблицу сетевой активности из корневой
+// страницы-ветви 7 и двух листьев 8 и 9.
+func buildTestSRUM() []byte {
+	network := srumTables[0].guid
+	catalog := []testESETag{
+		testCatalogRecord(5, ESE_CATALOG_TABLE, 5, 5, 0, 0, SRUM_ID_MAP),
+		testCatalogRecord(5, ESE_CATALOG_COLUMN, 1, ESE_COLTYP_UNSIGNED_BYTE, 1, 0, "IdType"),
+		testCatalogRecord(5, ESE_CATALOG_COLUMN, 2, ESE_COLTYP_LONG, 4, 0, "IdIndex"),
+		testCatalogRecord(5, ESE_CATALOG_COLUMN, 256, ESE_COLTYP_LONG_BINARY, 0, 0, "IdBlob"),
+		testCatalogRecord(5, ESE_CATALOG_LONG_VALUE, 5, 6, 0, 0, "LV"),
+		testCatalogRecord(7, ESE_CATALOG_TABLE, 7, 7, 0, 0, network),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 1, ESE_COLTYP_LONG, 4, 0, "AutoIncId"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 2, ESE_COLTYP_DATE_TIME, 8, 0, "TimeStamp"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 3, ESE_COLTYP_LONG, 4, 0, "AppId"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 4, ESE_COLTYP_LONG, 4, 0, "UserId"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 5, ESE_COLTYP_LONG, 4, 0, "L2ProfileId"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 6, ESE_COLTYP_LONG_LONG, 8, 0, "BytesSent"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 7, ESE_COLTYP_LONG_LONG, 8, 0, "BytesRecvd"),
+		testCatalogRecord(7, ESE_CATALOG_COLUMN, 128, ESE_COLTYP_TEXT, 255, ESE_CODEPAGE_UNICODE, "InterfaceName"),
+	}
+	// Удалённая запись каталога не должна учитываться
+	deleted := testCatalogRecord(9, ESE_CATALOG_TABLE, 9, 9, 0, 0, "Deleted")
+	deleted.flags = ESE_TAG_DELETED
+	catalog = append(catalog, deleted)
+
+	// Путь приложения — в дереве длинных значений двумя фрагментами, имя службы сжато
+	app := utf16z(testSRUMApp)
+	idMap := []testESETag{
+		{key: []byte{1}, data: buildTestESERecord([][]byte{{0}, testLE32(101)}, nil, nil,
+			[]testTagged{{id: 256, flags: ESE_TAGGED_LONG_VALUE, data: testLE32(0x10)}})},
+		{key: []byte{2}, data: buildTestESERecord([][]byte{{3}, testLE32(202)}, nil, nil,
+			[]testTagged{{id: 256, data: testSRUMSID}})},
+		{key: []byte{3}, data: buildTestESERecord([][]byte{{1}, testLE32(303)}, nil, nil,
+			[]testTagged{{id: 256, flags: ESE_TAGGED_COMPRESSED, data: encodeTest7Bit("Dnscache", ESE_COMPRESS_7BIT_UNICODE)}})},
+	}
+	lid := []byte{0, 0, 0, 0x10}
+	longValues := []testESETag{
+		{flags: ESE_TAG_COMMON_KEY, common: 4, data: append(testLE32(1), testLE32(uint32(len(app)))...)},
+		{flags: ESE_TAG_COMMON_KEY, common: 4, key: []byte{0, 0, 0, 0}, data: app[:40]},
+		{flags: ESE_TAG_COMMON_KEY, common: 4, key: []byte{0, 0, 0, 40}, data: app[40:]},
+	}
+
+	row := func(id uint32, app, user int32, sent uint64, iface string) []byte {
+		fixed := [][]byte{testLE32(id), testOLEDate(testSRUMTime), testLE32(uint32(app)), testLE32(uint32(user)),
+			testLE32(0), testLE64(sent), testLE64(sent * 10)}
+		var vars [][]byte
+		if iface != "" {
+			vars = append(vars, testUTF16(iface))
+		}
+		// L2ProfileId пуст
+		return buildTestESERecord(fixed, []int{5}, vars, nil)
+	}
+	pages := map[uint32][]byte{
+		4: buildTestESEPage(0x3, nil, catalog),
+		5: buildTestESEPage(0x3, nil, idMap),
+		6: buildTestESEPage(ESE_PAGE_LEAF|ESE_PAGE_LONG, lid, longValues),
+		7: buildTestESEPage(ESE_PAGE_PARENT|0x1, nil, []testESETag{
+			{key: []byte{1}, data: testLE32(8)},
+			{data: testLE32(9)},
+		}),
+		8: buildTestESEPage(ESE_PAGE_LEAF, nil, []testESETag{{key: []byte{1}, data: row(1, 101, 202, 4096, "Wi-Fi")}}),
+		9: buildTestESEPage(ESE_PAGE_LEAF, nil, []testESETag{{key: []byte{2}, data: row(2, 303, 999, 512, "")}}),
+	}
+	return buildTestESE(pages)
+}
+
+func TestOpenESE(t *testing.T) {
+	db, err := OpenESE(buildTestSRUM())
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{SRUM_ID_MAP, srumTables[0].guid}, db.Tables())
+	assert.Nil(t, db.Table("Deleted"))
+
+	table := db.Table(srumTables[0].guid)
+	var rows []map[string]interface{}
+	assert.NoError(t, table.Records(func(rec map[string]interface{}) error {
+		rows = append(rows, rec)
+		return nil
+	}))
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, map[string]interface{}{
+			"AutoIncId": int32(1), "TimeStamp": testSRUMTime, "AppId": int32(101), "UserId": int32(202),
+			"BytesSent": int64(4096), "BytesRecvd": int64(40960), "InterfaceName": "Wi-Fi",
+		}, rows[0])
+		assert.NotContains(t, rows[1], "InterfaceName")
+		assert.Equal(t, int32(2), rows[1]["AutoIncId"])
+	}
+
+	ids, err := ReadSRUMIdMap(db)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int32]string{101: testSRUMApp, 202: "S-1-5-21-1-2-3-1001", 303: "Dnscache"}, ids)
+
+	_, err = OpenESE(make([]byte, 8192))
+	assert.Error(t, err)
+
+	// Ветвь, ссылающаяся сама на себя, не приводит к зацикливанию
+	data := buildTestSRUM()
+	copy(data[(7+1)*testESEPageSize:], buildTestESEPage(ESE_PAGE_PARENT|0x1, nil, []testESETag{{data: testLE32(7)}}))
+	db, err = OpenESE(data)
+	if assert.NoError(t, err) {
+		assert.Error(t, db.Table(srumTables[0].guid).Records(func(map[string]interface{}) error { return nil }))
+	}
+}
+
+func TestESEDecompress(t *testing.T) {
+	out, err := eseDecompress(encodeTest7Bit("SruDbIdMapTable", ESE_COMPRESS_7BIT_ASCII))
+	assert.NoError(t, err)
+	assert.Equal(t, "SruDbIdMapTable", string(out))
+	out, err = eseDecompress(encodeTest7Bit("Wi-Fi 2", ESE_COMPRESS_7BIT_UNICODE))
+	assert.NoError(t, err)
+	assert.Equal(t, testUTF16("Wi-Fi 2"), out)
+
+	// Литералы "abc" и ссылка назад на 3 байта длиной 9
+	plain := append(testLE32(0x10000000), 'a', 'b', 'c', 22, 0)
+	out, err = eseDecompress(append([]byte{ESE_COMPRESS_XPRESS << 3, 12, 0}, plain...))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcabcabcabc", string(out))
+
+	// Длина ссылки с продолжением в полубайте и отдельном байте
+	long := append(testLE32(0x40000000), 'a', 7, 0, 0x0F, 4)
+	out, err = decompressXpressPlain(long, 30)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("a"), 30), out)
+
+	_, err = decompressXpressPlain(append(testLE32(0x80000000), 0xF8, 0xFF), 10)
+	assert.Error(t, err)
+	_, err = eseDecompress([]byte{0x28})
+	assert.Error(t, err)
+
+	// 32-битная длина ссылки обрезается по ожидаемому размеру
+	huge := append(testLE32(0x40000000), 'a', 7, 0, 0x0F, 0xFF, 0, 0)
+	out, err = decompressXpressPlain(append(huge, testLE32(0xFFFFFFF0)...), 20)
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("a"), 20), out)
+}
+
+func TestESEMalformedRecord(t *testing.T) {
+	// Отрицательный размер фиксированного столбца из каталога и усечённый массив
+	// смещений нескольких значений тегированного столбца
+	table := &ESETable{db: &ESEDatabase{}, Columns: []ESEColumn{
+		{ID: 1, Name: "Broken", Type: ESE_COLTYP_BINARY, Size: -5},
+		{ID: 2, Name: "Count", Type: ESE_COLTYP_LONG},
+		{ID: 256, Name: "Multi", Type: ESE_COLTYP_BINARY},
+	}}
+	table.layout()
+	assert.Empty(t, table.fixed)
+
+	data := []byte{2, 127, 9, 0, 1, 2, 3, 4, 0}
+	data = append(data, 0, 1, 4, 0x40, ESE_TAGGED_MULTI_VALUE, 4, 0, 1)
+	assert.Empty(t, table.parseRecord(data))
+}
+
+func TestSRUMParser(t *testing.T) {
+	p, err := newSRUMParser(parserOptions{})
+	assert.NoError(t, err)
+	path := `C:\Windows\System32\sru\SRUDB.dat`
+	assert.True(t, p.Match(path))
+	assert.False(t, p.Match(`C:\Windows\System32\sru\SRU.log`))
+	assert.Equal(t, "l2_profile_id", snakeCase("L2ProfileId"))
+	assert.Equal(t, "foreground_cycle_time", snakeCase("ForegroundCycleTime"))
+	assert.Equal(t, "id_blob", snakeCase("IdBlob"))
+
+	var records []map[string]interface{}
+	assert.NoError(t, p.Parse(path, bytes.NewReader(buildTestSRUM()), func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	}))
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, "2024-05-01T12:00:00Z", records[0]["@timestamp"])
+		assert.Equal(t, map[string]interface{}{"kind": "event", "category": "network", "action": "srum-network-usage"}, records[0]["event"])
+		srum := records[0]["srum"].(map[string]interface{})
+		assert.Equal(t, testSRUMApp, srum["app"])
+		assert.Equal(t, "S-1-5-21-1-2-3-1001", srum["user"])
+		assert.Equal(t, int64(4096), srum["bytes_sent"])
+		assert.Equal(t, "network_usage", srum["table"])
+		assert.NotContains(t, srum, "l2_profile_id")
+		// Неизвестный пользователь остаётся только идентификатором
+		srum = records[1]["srum"].(map[string]interface{})
+		assert.Equal(t, "Dnscache", srum["app"])
+		assert.NotContains(t, srum, "user")
+		assert.Equal(t, int32(999), srum["user_id"])
+	}
+
+	ft := timeToFiletime(testSRUMTime)
+	rec := srumRecord(srumTables[2], map[string]interface{}{"ConnectStartTime": int64(ft), "ConnectedTime": int32(60)}, nil, path)
+	assert.Equal(t, "2024-05-01T12:00:00Z", rec["srum"].(map[string]interface{})["connect_start_time"])
+	assert.NotContains(t, rec, "@timestamp")
+}
@@ -0,0 +1,1168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Формат журнала событий Windows (EVTX): заголовок файла 4096 байт, затем блоки (chunk) по 64 КБ.
+// Каждый блок содержит заголовок 512 байт и записи событий в двоичном XML (BinXml), шаблоны
+// которого хранятся в том же блоке и переиспользуются записями через подстановки.
+const (
+	EVTX_FILE_HEADER_SIZE   = 4096
+	EVTX_CHUNK_SIZE         = 65536
+	EVTX_CHUNK_HEADER_SIZE  = 512
+	EVTX_RECORD_HEADER_SIZE = 24
+	EVTX_MAX_DEPTH          = 32
+	EVTX_MAX_VALUES         = 4096
+	// EVTX_MAX_RECORD_NODES ограничивает число узлов, создаваемых при подстановке значений
+	// в одну запись: повторные ссылки на вложенный BinXml иначе дают экспоненциальный рост.
+	EVTX_MAX_RECORD_NODES = 65536
+
+	EVTX_FILE_DIRTY = 0x1
+)
+
+// Токены двоичного XML; флаг EVTX_TOKEN_MORE означает наличие атрибутов или продолжения.
+const (
+	EVTX_TOKEN_EOS            = 0x00
+	EVTX_TOKEN_OPEN_ELEMENT   = 0x01
+	EVTX_TOKEN_CLOSE_START    = 0x02
+	EVTX_TOKEN_CLOSE_EMPTY    = 0x03
+	EVTX_TOKEN_END_ELEMENT    = 0x04
+	EVTX_TOKEN_VALUE          = 0x05
+	EVTX_TOKEN_ATTRIBUTE      = 0x06
+	EVTX_TOKEN_CDATA          = 0x07
+	EVTX_TOKEN_CHAR_REF       = 0x08
+	EVTX_TOKEN_ENTITY_REF     = 0x09
+	EVTX_TOKEN_PI_TARGET      = 0x0a
+	EVTX_TOKEN_PI_DATA        = 0x0b
+	EVTX_TOKEN_TEMPLATE       = 0x0c
+	EVTX_TOKEN_SUBSTITUTION   = 0x0d
+	EVTX_TOKEN_OPTIONAL_SUBST = 0x0e
+	EVTX_TOKEN_FRAGMENT       = 0x0f
+	EVTX_TOKEN_MORE           = 0x40
+)
+
+// Типы значений подстановок.
+const (
+	EVTX_TYPE_NULL       = 0x00
+	EVTX_TYPE_STRING     = 0x01
+	EVTX_TYPE_ANSI       = 0x02
+	EVTX_TYPE_INT8       = 0x03
+	EVTX_TYPE_UINT8      = 0x04
+	EVTX_TYPE_INT16      = 0x05
+	EVTX_TYPE_UINT16     = 0x06
+	EVTX_TYPE_INT32      = 0x07
+	EVTX_TYPE_UINT32     = 0x08
+	EVTX_TYPE_INT64      = 0x09
+	EVTX_TYPE_UINT64     = 0x0a
+	EVTX_TYPE_REAL32     = 0x0b
+	EVTX_TYPE_REAL64     = 0x0c
+	EVTX_TYPE_BOOL       = 0x0d
+	EVTX_TYPE_BINARY     = 0x0e
+	EVTX_TYPE_GUID       = 0x0f
+	EVTX_TYPE_SIZET      = 0x10
+	EVTX_TYPE_FILETIME   = 0x11
+	EVTX_TYPE_SYSTEMTIME = 0x12
+	EVTX_TYPE_SID        = 0x13
+	EVTX_TYPE_HEX32      = 0x14
+	EVTX_TYPE_HEX64      = 0x15
+	EVTX_TYPE_BINXML     = 0x21
+	EVTX_TYPE_ARRAY      = 0x80
+)
+
+// evtxFixedSizes — размеры элементов массивов фиксированной длины.
+var evtxFixedSizes = map[byte]int{
+	EVTX_TYPE_INT8: 1, EVTX_TYPE_UINT8: 1, EVTX_TYPE_INT16: 2, EVTX_TYPE_UINT16: 2,
+	EVTX_TYPE_INT32: 4, EVTX_TYPE_UINT32: 4, EVTX_TYPE_INT64: 8, EVTX_TYPE_UINT64: 8,
+	EVTX_TYPE_REAL32: 4, EVTX_TYPE_REAL64: 8, EVTX_TYPE_BOOL: 4, EVTX_TYPE_GUID: 16,
+	EVTX_TYPE_FILETIME: 8, EVTX_TYPE_SYSTEMTIME: 16, EVTX_TYPE_HEX32: 4, EVTX_TYPE_HEX64: 8,
+}
+
+var errEVTXCorrupt = errors.New("corrupt event record")
+
+// evtxNode — узел разобранного двоичного XML: элемент, текст, подстановка или экземпляр шаблона.
+type evtxNode struct {
+	name     string
+	attrs    []evtxNodeAttr
+	children []*evtxNode
+	text     *string
+	subst    int
+	optional bool
+	instance *evtxInstance
+}
+
+type evtxNodeAttr struct {
+	name  string
+	value []*evtxNode
+}
+
+// evtxInstance — экземпляр шаблона с массивом значений подстановок.
+type evtxInstance struct {
+	template []*evtxNode
+	values   []evtxValue
+}
+
+type evtxValue struct {
+	typ    byte
+	data   []byte
+	offset int // смещение данных от начала блока (для вложенного BinXml)
+}
+
+// EVTXElement — элемент события после подстановки значений.
+type EVTXElement struct {
+	Name     string
+	Attrs    map[string]interface{}
+	Children []*EVTXElement
+	Content  []interface{}
+}
+
+// Child возвращает первый дочерний элемент с указанным именем.
+func (e *EVTXElement) Child(name string) *EVTXElement {
+	if e == nil {
+		return nil
+	}
+	for _, c := range e.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// Value возвращает содержимое элемента: единственное типизированное значение или строку.
+func (e *EVTXElement) Value() interface{} {
+	if e == nil || len(e.Content) == 0 {
+		return nil
+	}
+	if len(e.Content) == 1 {
+		return e.Content[0]
+	}
+	var b strings.Builder
+	for _, c := range e.Content {
+		b.WriteString(evtxString(c))
+	}
+	return b.String()
+}
+
+// EVTXRecord — запись журнала событий.
+type EVTXRecord struct {
+	ID        uint64
+	Written   time.Time
+	Chunk     int
+	Recovered bool
+	Event     *EVTXElement
+}
+
+// evtxChunk — блок журнала и кэш его шаблонов.
+type evtxChunk struct {
+	data      []byte
+	templates map[uint32][]*evtxNode
+
+	// Состояние разбора текущей записи: разобранные значения BinXml по смещению в блоке
+	// и оставшееся число узлов.
+	binxml map[int][]*evtxNode
+	budget int
+}
+
+// evtxReader последовательно разбирает двоичный XML блока начиная с pos.
+type evtxReader struct {
+	c     *evtxChunk
+	pos   int
+	end   int
+	depth int
+}
+
+func (r *evtxReader) need(n int) error {
+	if n < 0 || r.pos+n > r.end {
+		return fmt.Errorf("%w: binxml out of bounds at 0x%x", errEVTXCorrupt, r.pos)
+	}
+	return nil
+}
+
+func (r *evtxReader) peek() (byte, error) {
+	if err := r.need(1); err != nil {
+		return 0, err
+	}
+	return r.c.data[r.pos], nil
+}
+
+func (r *evtxReader) u8() (byte, error) {
+	b, err := r.peek()
+	r.pos++
+	return b, err
+}
+
+func (r *evtxReader) u16() (uint16, error) {
+	if err := r.need(2); err != nil {
+		return 0, err
+	}
+	v := binary.LittleEndian.Uint16(r.c.data[r.pos:])
+	r.pos += 2
+	return v, nil
+}
+
+func (r *evtxReader) u32() (uint32, error) {
+	if err := r.need(4); err != nil {
+		return 0, err
+	}
+	v := binary.LittleEndian.Uint32(r.c.data[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+// utf16 читает строку с префиксом длины в символах.
+func (r *evtxReader) utf16() (string, error) {
+	n, err := r.u16()
+	if err != nil {
+		return "", err
+	}
+	if err := r.need(int(n) * 2); err != nil {
+		return "", err
+	}
+	s := decodeUTF16Full(r.c.data[r.pos : r.pos+int(n)*2])
+	r.pos += int(n) * 2
+	return s, nil
+}
+
+// name читает имя по смещению в блоке; если имя записано сразу за токеном, оно пропускается.
+func (r *evtxReader) name(off uint32) (string, error) {
+	data := r.c.data
+	o := int(off)
+	if o+8 > len(data) {
+		return "", fmt.Errorf("%w: name offset 0x%x out of chunk", errEVTXCorrupt, off)
+	}
+	n := int(binary.LittleEndian.Uint16(data[o+6:]))
+	if o+8+n*2 > len(data) {
+		return "", fmt.Errorf("%w: name at 0x%x out of chunk", errEVTXCorrupt, off)
+	}
+	s := decodeUTF16Full(data[o+8 : o+8+n*2])
+	if o == r.pos {
+		r.pos += 8 + n*2 + 2
+	}
+	return s, nil
+}
+
+// stream разбирает последовательность узлов до конца потока. Экземпляр шаблона завершает
+// поток: сразу за ним следуют значения подстановок.
+func (r *evtxReader) stream() ([]*evtxNode, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > EVTX_MAX_DEPTH {
+		return nil, fmt.Errorf("%w: binxml nesting too deep", errEVTXCorrupt)
+	}
+	var nodes []*evtxNode
+	for {
+		tok, err := r.peek()
+		if err != nil {
+			return nodes, err
+		}
+		switch tok &^ EVTX_TOKEN_MORE {
+		case EVTX_TOKEN_EOS:
+			r.pos++
+			return nodes, nil
+		case EVTX_TOKEN_FRAGMENT:
+			if err := r.need(4); err != nil {
+				return nodes, err
+			}
+			r.pos += 4
+		case EVTX_TOKEN_OPEN_ELEMENT:
+			el, err := r.element()
+			if err != nil {
+				return nodes, err
+			}
+			nodes = append(nodes, el)
+		case EVTX_TOKEN_TEMPLATE:
+			inst, err := r.templateInstance()
+			if err != nil {
+				return nodes, err
+			}
+			return append(nodes, &evtxNode{instance: inst}), nil
+		default:
+			n, err := r.content()
+			if err != nil {
+				return nodes, err
+			}
+			if n != nil {
+				nodes = append(nodes, n)
+			}
+		}
+	}
+}
+
+// element разбирает элемент с атрибутами и дочерними узлами.
+func (r *evtxReader) element() (*evtxNode, error) {
+	r.depth++
+	defer func() { r.depth-- }()
+	if r.depth > EVTX_MAX_DEPTH {
+		return nil, fmt.Errorf("%w: binxml nesting too deep", errEVTXCorrupt)
+	}
+	tok, _ := r.u8()
+	// Идентификатор зависимости и размер данных элемента
+	if err := r.need(6); err != nil {
+		return nil, err
+	}
+	r.pos += 6
+	nameOff, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	el := &evtxNode{}
+	if el.name, err = r.name(nameOff); err != nil {
+		return nil, err
+	}
+	if tok&EVTX_TOKEN_MORE != 0 {
+		// Размер списка атрибутов
+		if _, err := r.u32(); err != nil {
+			return nil, err
+		}
+		for {
+			t, err := r.peek()
+			if err != nil {
+				return nil, err
+			}
+			if t&^EVTX_TOKEN_MORE != EVTX_TOKEN_ATTRIBUTE {
+				break
+			}
+			r.pos++
+			off, err := r.u32()
+			if err != nil {
+				return nil, err
+			}
+			attr := evtxNodeAttr{}
+			if attr.name, err = r.name(off); err != nil {
+				return nil, err
+			}
+			for {
+				v, err := r.peek()
+				if err != nil {
+					return nil, err
+				}
+				if !isEVTXContentToken(v) {
+					break
+				}
+				n, err := r.content()
+				if err != nil {
+					return nil, err
+				}
+				if n != nil {
+					attr.value = append(attr.value, n)
+				}
+			}
+			el.attrs = append(el.attrs, attr)
+		}
+	}
+
+	closing, err := r.u8()
+	if err != nil {
+		return nil, err
+	}
+	switch closing {
+	case EVTX_TOKEN_CLOSE_EMPTY:
+		return el, nil
+	case EVTX_TOKEN_CLOSE_START:
+	default:
+		return nil, fmt.Errorf("%w: unexpected token 0x%02x in element %s", errEVTXCorrupt, closing, el.name)
+	}
+	for {
+		t, err := r.peek()
+		if err != nil {
+			return nil, err
+		}
+		switch t &^ EVTX_TOKEN_MORE {
+		case EVTX_TOKEN_END_ELEMENT:
+			r.pos++
+			return el, nil
+		case EVTX_TOKEN_OPEN_ELEMENT:
+			child, err := r.element()
+			if err != nil {
+				return nil, err
+			}
+			el.children = append(el.children, child)
+		case EVTX_TOKEN_EOS, EVTX_TOKEN_TEMPLATE, EVTX_TOKEN_FRAGMENT, EVTX_TOKEN_ATTRIBUTE:
+			return nil, fmt.Errorf("%w: unexpected token 0x%02x in element %s", errEVTXCorrupt, t, el.name)
+		default:
+			n, err := r.content()
+			if err != nil {
+				return nil, err
+			}
+			if n != nil {
+				el.children = append(el.children, n)
+			}
+		}
+	}
+}
+
+func isEVTXContentToken(t byte) bool {
+	switch t &^ EVTX_TOKEN_MORE {
+	case EVTX_TOKEN_VALUE, EVTX_TOKEN_SUBSTITUTION, EVTX_TOKEN_OPTIONAL_SUBST,
+		EVTX_TOKEN_CHAR_REF, EVTX_TOKEN_ENTITY_REF, EVTX_TOKEN_CDATA:
+		return true
+	}
+	return false
+}
+
+// content разбирает текстовый узел, ссылку или подстановку.
+func (r *evtxReader) content() (*evtxNode, error) {
+	tok, _ := r.u8()
+	text := func(s string) *evtxNode { return &evtxNode{text: &s} }
+	switch tok &^ EVTX_TOKEN_MORE {
+	case EVTX_TOKEN_VALUE:
+		if _, err := r.u8(); err != nil {
+			return nil, err
+		}
+		s, err := r.utf16()
+		return text(s), err
+	case EVTX_TOKEN_CDATA:
+		s, err := r.utf16()
+		return text(s), err
+	case EVTX_TOKEN_CHAR_REF:
+		c, err := r.u16()
+		return text(string(rune(c))), err
+	case EVTX_TOKEN_ENTITY_REF:
+		off, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		name, err := r.name(off)
+		entities := map[string]string{"amp": "&", "lt": "<", "gt": ">", "quot": `"`, "apos": "'"}
+		if s, ok := entities[name]; ok {
+			return text(s), err
+		}
+		return text("&" + name + ";"), err
+	case EVTX_TOKEN_PI_TARGET:
+		off, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.name(off)
+		return nil, err
+	case EVTX_TOKEN_PI_DATA:
+		_, err := r.utf16()
+		return nil, err
+	case EVTX_TOKEN_SUBSTITUTION, EVTX_TOKEN_OPTIONAL_SUBST:
+		id, err := r.u16()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.u8(); err != nil {
+			return nil, err
+		}
+		return &evtxNode{subst: int(id), optional: tok == EVTX_TOKEN_OPTIONAL_SUBST}, nil
+	}
+	return nil, fmt.Errorf("%w: unknown binxml token 0x%02x at 0x%x", errEVTXCorrupt, tok, r.pos-1)
+}
+
+// templateInstance разбирает экземпляр шаблона: ссылку на определение (или само определение,
+// если оно записано здесь же) и массив значений подстановок.
+func (r *evtxReader) templateInstance() (*evtxInstance, error) {
+	if err := r.need(10); err != nil {
+		return nil, err
+	}
+	r.pos += 6
+	defOff, _ := r.u32()
+	inst := &evtxInstance{}
+	if int(defOff) == r.pos {
+		if err := r.need(24); err != nil {
+			return nil, err
+		}
+		size := int(binary.LittleEndian.Uint32(r.c.data[r.pos+20:]))
+		start := r.pos + 24
+		if err := r.need(24 + size); err != nil {
+			return nil, err
+		}
+		tmpl, err := r.c.template(defOff, start, start+size, r.depth)
+		if err != nil {
+			return nil, err
+		}
+		inst.template = tmpl
+		r.pos = start + size
+	} else {
+		o := int(defOff)
+		if o+24 > len(r.c.data) {
+			return nil, fmt.Errorf("%w: template offset 0x%x out of chunk", errEVTXCorrupt, defOff)
+		}
+		size := int(binary.LittleEndian.Uint32(r.c.data[o+20:]))
+		tmpl, err := r.c.template(defOff, o+24, o+24+size, r.depth)
+		if err != nil {
+			return nil, err
+		}
+		inst.template = tmpl
+	}
+
+	count, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if count > EVTX_MAX_VALUES {
+		return nil, fmt.Errorf("%w: too many substitution values (%d)", errEVTXCorrupt, count)
+	}
+	if err := r.need(int(count) * 4); err != nil {
+		return nil, err
+	}
+	inst.values = make([]evtxValue, count)
+	descs := r.c.data[r.pos : r.pos+int(count)*4]
+	r.pos += int(count) * 4
+	for i := range inst.values {
+		size := int(binary.LittleEndian.Uint16(descs[i*4:]))
+		if err := r.need(size); err != nil {
+			return nil, err
+		}
+		inst.values[i] = evtxValue{typ: descs[i*4+2], data: r.c.data[r.pos : r.pos+size], offset: r.pos}
+		r.pos += size
+	}
+	return inst, nil
+}
+
+// template возвращает разобранное определение шаблона из кэша блока или разбирает его.
+func (c *evtxChunk) template(off uint32, start, end, depth int) ([]*evtxNode, error) {
+	if tmpl, ok := c.templates[off]; ok {
+		return tmpl, nil
+	}
+	if start < 0 || end > len(c.data) || start > end {
+		return nil, fmt.Errorf("%w: template at 0x%x out of chunk", errEVTXCorrupt, off)
+	}
+	r := &evtxReader{c: c, pos: start, end: end, depth: depth}
+	tmpl, err := r.stream()
+	if err != nil {
+		return nil, fmt.Errorf("template at 0x%x: %w", off, err)
+	}
+	c.templates[off] = tmpl
+	return tmpl, nil
+}
+
+// instantiate подставляет значения в узлы шаблона. Значения типа BinXml разбираются как
+// вложенные фрагменты (так записываются EventData и UserData).
+func (c *evtxChunk) instantiate(nodes []*evtxNode, values []evtxValue, depth int) ([]*EVTXElement, []interface{}, error) {
+	if depth > EVTX_MAX_DEPTH {
+		return nil, nil, fmt.Errorf("%w: substitution nesting too deep", errEVTXCorrupt)
+	}
+	var elements []*EVTXElement
+	var content []interface{}
+	for _, n := range nodes {
+		if c.budget--; c.budget < 0 {
+			return nil, nil, fmt.Errorf("%w: too many nodes in record", errEVTXCorrupt)
+		}
+		switch {
+		case n.instance != nil:
+			els, cont, err := c.instantiate(n.instance.template, n.instance.values, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			elements = append(elements, els...)
+			content = append(content, cont...)
+		case n.text != nil:
+			content = append(content, *n.text)
+		case n.name == "":
+			if n.subst >= len(values) {
+				continue
+			}
+			v := values[n.subst]
+			if v.typ == EVTX_TYPE_NULL || (n.optional && len(v.data) == 0) {
+				continue
+			}
+			if v.typ == EVTX_TYPE_BINXML {
+				// Значение разбирается один раз, повторные ссылки используют результат
+				inner, ok := c.binxml[v.offset]
+				if !ok {
+					r := &evtxReader{c: c, pos: v.offset, end: v.offset + len(v.data), depth: depth}
+					var err error
+					if inner, err = r.stream(); err != nil {
+						return nil, nil, err
+					}
+					c.binxml[v.offset] = inner
+				}
+				els, cont, err := c.instantiate(inner, nil, depth+1)
+				if err != nil {
+					return nil, nil, err
+				}
+				elements = append(elements, els...)
+				content = append(content, cont...)
+				continue
+			}
+			content = append(content, decodeEVTXValue(v.typ, v.data))
+		default:
+			el := &EVTXElement{Name: n.name}
+			for _, a := range n.attrs {
+				_, cont, err := c.instantiate(a.value, values, depth+1)
+				if err != nil {
+					return nil, nil, err
+				}
+				if len(cont) == 0 {
+					continue
+				}
+				if el.Attrs == nil {
+					el.Attrs = make(map[string]interface{})
+				}
+				tmp := &EVTXElement{Content: cont}
+				el.Attrs[a.name] = tmp.Value()
+			}
+			children, cont, err := c.instantiate(n.children, values, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			el.Children, el.Content = children, cont
+			elements = append(elements, el)
+		}
+	}
+	return elements, content, nil
+}
+
+// decodeEVTXValue преобразует значение подстановки в тип Go.
+func decodeEVTXValue(typ byte, data []byte) interface{} {
+	le := binary.LittleEndian
+	if typ&EVTX_TYPE_ARRAY != 0 {
+		base := typ &^ EVTX_TYPE_ARRAY
+		var items []interface{}
+		switch base {
+		case EVTX_TYPE_STRING:
+			for _, s := range strings.Split(strings.TrimRight(decodeUTF16Full(data), "\x00"), "\x00") {
+				items = append(items, s)
+			}
+		case EVTX_TYPE_ANSI:
+			for _, s := range strings.Split(strings.TrimRight(string(data), "\x00"), "\x00") {
+				items = append(items, s)
+			}
+		default:
+			size := evtxFixedSizes[base]
+			if size == 0 {
+				return strings.ToUpper(hex.EncodeToString(data))
+			}
+			for i := 0; i+size <= len(data); i += size {
+				items = append(items, decodeEVTXValue(base, data[i:i+size]))
+			}
+		}
+		return items
+	}
+	if size, ok := evtxFixedSizes[typ]; ok && len(data) < size {
+		return strings.ToUpper(hex.EncodeToString(data))
+	}
+	switch typ {
+	case EVTX_TYPE_NULL:
+		return nil
+	case EVTX_TYPE_STRING:
+		return strings.TrimRight(decodeUTF16Full(data), "\x00")
+	case EVTX_TYPE_ANSI:
+		return strings.TrimRight(string(data), "\x00")
+	case EVTX_TYPE_INT8:
+		return int64(int8(data[0]))
+	case EVTX_TYPE_UINT8:
+		return uint64(data[0])
+	case EVTX_TYPE_INT16:
+		return int64(int16(le.Uint16(data)))
+	case EVTX_TYPE_UINT16:
+		return uint64(le.Uint16(data))
+	case EVTX_TYPE_INT32:
+		return int64(int32(le.Uint32(data)))
+	case EVTX_TYPE_UINT32:
+		return uint64(le.Uint32(data))
+	case EVTX_TYPE_INT64:
+		return int64(le.Uint64(data))
+	case EVTX_TYPE_UINT64:
+		return le.Uint64(data)
+	case EVTX_TYPE_REAL32:
+		return float64(math.Float32frombits(le.Uint32(data)))
+	case EVTX_TYPE_REAL64:
+		return math.Float64frombits(le.Uint64(data))
+	case EVTX_TYPE_BOOL:
+		return le.Uint32(data) != 0
+	case EVTX_TYPE_GUID:
+		return formatGUID(data)
+	case EVTX_TYPE_SIZET, EVTX_TYPE_HEX32, EVTX_TYPE_HEX64:
+		if len(data) >= 8 {
+			return fmt.Sprintf("0x%x", le.Uint64(data))
+		}
+		if len(data) >= 4 {
+			return fmt.Sprintf("0x%x", le.Uint32(data))
+		}
+	case EVTX_TYPE_FILETIME:
+		return filetimeToTime(le.Uint64(data))
+	case EVTX_TYPE_SYSTEMTIME:
+		return time.Date(int(le.Uint16(data)), time.Month(le.Uint16(data[2:])), int(le.Uint16(data[6:])),
+			int(le.Uint16(data[8:])), int(le.Uint16(data[10:])), int(le.Uint16(data[12:])),
+			int(le.Uint16(data[14:]))*int(time.Millisecond), time.UTC)
+	case EVTX_TYPE_SID:
+		if s := formatSID(data); s != "" {
+			return s
+		}
+	}
+	return strings.ToUpper(hex.EncodeToString(data))
+}
+
+// decodeUTF16Full декодирует строку UTF-16LE целиком, включая нулевые символы.
+func decodeUTF16Full(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(u))
+}
+
+// formatGUID форматирует GUID в виде {XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX}.
+func formatGUID(b []byte) string {
+	if len(b) < 16 {
+		return ""
+	}
+	le := binary.LittleEndian
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", le.Uint32(b), le.Uint16(b[4:]), le.Uint16(b[6:]), b[8:10], b[10:16])
+}
+
+// formatSID форматирует двоичный SID в виде S-1-5-21-....
+func formatSID(b []byte) string {
+	if len(b) < 8 || b[0] != 1 || len(b) < 8+int(b[1])*4 {
+		return ""
+	}
+	var auth uint64
+	for _, c := range b[2:8] {
+		auth = auth<<8 | uint64(c)
+	}
+	s := fmt.Sprintf("S-%d-%d", b[0], auth)
+	for i := 0; i < int(b[1]); i++ {
+		s += fmt.Sprintf("-%d", binary.LittleEndian.Uint32(b[8+i*4:]))
+	}
+	return s
+}
+
+// evtxString приводит значение события к строке для склейки текста и фильтров.
+func evtxString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case time.Time:
+		return formatTime(x)
+	}
+	return fmt.Sprint(v)
+}
+
+// evtxJSONValue готовит значение события к записи в JSON: время — в RFC 3339.
+func evtxJSONValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case time.Time:
+		return formatTime(x)
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			out[i] = evtxJSONValue(item)
+		}
+		return out
+	}
+	return v
+}
+
+// parseEVTXRecord разбирает запись события по смещению pos блока.
+func (c *evtxChunk) parseRecord(pos int) (*EVTXRecord, int, error) {
+	data := c.data
+	if pos+EVTX_RECORD_HEADER_SIZE+4 > len(data) || !bytes.Equal(data[pos:pos+4], []byte{0x2a, 0x2a, 0, 0}) {
+		return nil, 0, fmt.Errorf("%w: missing record signature at 0x%x", errEVTXCorrupt, pos)
+	}
+	le := binary.LittleEndian
+	size := int(le.Uint32(data[pos+4:]))
+	if size < EVTX_RECORD_HEADER_SIZE+4 || pos+size > len(data) || int(le.Uint32(data[pos+size-4:])) != size {
+		return nil, 0, fmt.Errorf("%w: bad record size at 0x%x", errEVTXCorrupt, pos)
+	}
+	rec := &EVTXRecord{ID: le.Uint64(data[pos+8:]), Written: filetimeToTime(le.Uint64(data[pos+16:]))}
+	c.binxml, c.budget = make(map[int][]*evtxNode), EVTX_MAX_RECORD_NODES
+	r := &evtxReader{c: c, pos: pos + EVTX_RECORD_HEADER_SIZE, end: pos + size - 4}
+	nodes, err := r.stream()
+	if err != nil {
+		return nil, size, err
+	}
+	elements, _, err := c.instantiate(nodes, nil, 0)
+	if err != nil {
+		return nil, size, err
+	}
+	for _, el := range elements {
+		if el.Name == "Event" {
+			rec.Event = el
+		}
+	}
+	if rec.Event == nil {
+		return nil, size, fmt.Errorf("%w: record %d has no Event element", errEVTXCorrupt, rec.ID)
+	}
+	return rec, size, nil
+}
+
+// readEVTX последовательно читает блоки журнала из r и передаёт записи в fn. Записи
+// восстанавливаются и из «грязных» блоков: при неверных контрольных суммах или после
+// повреждённой записи блок просматривается дальше в поисках сигнатур записей, а область
+// за смещением свободного места проверяется на записи, не отражённые в заголовке блока.
+func readEVTX(r io.Reader, fn func(rec *EVTXRecord) error) error {
+	header := make([]byte, EVTX_FILE_HEADER_SIZE)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return fmt.Errorf("failed to read EVTX header: %w", err)
+	}
+	if string(header[:8]) != "ElfFile\x00" {
+		return fmt.Errorf("not an EVTX file: missing ElfFile signature")
+	}
+	if binary.LittleEndian.Uint32(header[120:])&EVTX_FILE_DIRTY != 0 {
+		logger.Log(LevelDebug, "EVTX file is marked dirty, scanning chunks for unflushed records")
+	}
+	seen := make(map[uint64]bool)
+	buf := make([]byte, EVTX_CHUNK_SIZE)
+	for index := 0; ; index++ {
+		n, err := io.ReadFull(r, buf)
+		if n == 0 || (err != nil && err != io.ErrUnexpectedEOF) {
+			if err == io.EOF || n == 0 {
+				return nil
+			}
+			return err
+		}
+		if err := readEVTXChunk(buf[:n], index, seen, fn); err != nil {
+			return err
+		}
+		if n < EVTX_CHUNK_SIZE {
+			return nil
+		}
+	}
+}
+
+// readEVTXChunk разбирает записи одного блока.
+func readEVTXChunk(data []byte, index int, seen map[uint64]bool, fn func(rec *EVTXRecord) error) error {
+	if len(data) < EVTX_CHUNK_HEADER_SIZE || string(data[:8]) != "ElfChnk\x00" {
+		return nil
+	}
+	le := binary.LittleEndian
+	free := int(le.Uint32(data[48:]))
+	if free < EVTX_CHUNK_HEADER_SIZE || free > len(data) {
+		free = len(data)
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(data[:120])
+	crc.Write(data[128:EVTX_CHUNK_HEADER_SIZE])
+	dirty := crc.Sum32() != le.Uint32(data[124:]) || crc32.ChecksumIEEE(data[EVTX_CHUNK_HEADER_SIZE:free]) != le.Uint32(data[52:])
+	if dirty {
+		logger.Log(LevelDebug, fmt.Sprintf("EVTX chunk %d checksum mismatch, recovering records", index))
+	}
+
+	c := &evtxChunk{data: data, templates: make(map[uint32][]*evtxNode)}
+	signature := []byte{0x2a, 0x2a, 0, 0}
+	for pos := EVTX_CHUNK_HEADER_SIZE; pos+EVTX_RECORD_HEADER_SIZE+4 <= len(data); {
+		rec, size, err := c.parseRecord(pos)
+		if err != nil {
+			if pos < free && size > 0 {
+				logger.Log(LevelDebug, fmt.Sprintf("Skipping damaged EVTX record in chunk %d at 0x%x: %v", index, pos, err))
+			}
+			// Ищем следующую сигнатуру записи, в том числе в области за свободным местом
+			next := bytes.Index(data[pos+1:], signature)
+			if next < 0 {
+				break
+			}
+			pos += 1 + next
+			continue
+		}
+		// Записи за смещением свободного места и из блоков с неверной контрольной суммой
+		// не подтверждены заголовком блока и помечаются как восстановленные
+		rec.Chunk, rec.Recovered = index, dirty || pos >= free
+		pos += size
+		if seen[rec.ID] && rec.Recovered {
+			continue
+		}
+		seen[rec.ID] = true
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EVTXFilter отбирает события по каналу, коду события и интервалу времени.
+type EVTXFilter struct {
+	channels map[string]bool
+	ids      [][2]int
+	since    time.Time
+	until    time.Time
+}
+
+// ParseEVTXFilter разбирает описание фильтра вида
+// "channel=Security,System;id=4624,4688-4690;since=2024-01-01;until=2024-02-01T12:00:00Z".
+// Пустая строка означает отсутствие фильтра.
+func ParseEVTXFilter(spec string) (*EVTXFilter, error) {
+	f := &EVTXFilter{}
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid filter %q: expected key=value", part)
+		}
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "channel":
+			if f.channels == nil {
+				f.channels = make(map[string]bool)
+			}
+			for _, ch := range splitArgs(value) {
+				f.channels[strings.ToLower(ch)] = true
+			}
+		case "id", "event_id":
+			for _, item := range splitArgs(value) {
+				lo, hi, isRange := strings.Cut(item, "-")
+				from, err := strconv.Atoi(strings.TrimSpace(lo))
+				if err != nil {
+					return nil, fmt.Errorf("invalid event id %q", item)
+				}
+				to := from
+				if isRange {
+					if to, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil || to < from {
+						return nil, fmt.Errorf("invalid event id range %q", item)
+					}
+				}
+				f.ids = append(f.ids, [2]int{from, to})
+			}
+		case "since", "until":
+			t, err := parseFilterTime(strings.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			if strings.EqualFold(strings.TrimSpace(key), "since") {
+				f.since = t
+			} else {
+				f.until = t
+			}
+		default:
+			return nil, fmt.Errorf("unknown filter key %q", key)
+		}
+	}
+	return f, nil
+}
+
+// parseFilterTime разбирает время фильтра: RFC 3339 или дату и время без смещения (UTC).
+func parseFilterTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
+
+// Match сообщает, проходит ли событие фильтр.
+func (f *EVTXFilter) Match(channel string, id int, t time.Time) bool {
+	if f == nil {
+		return true
+	}
+	if f.channels != nil && !f.channels[strings.ToLower(channel)] {
+		return false
+	}
+	if len(f.ids) > 0 {
+		found := false
+		for _, r := range f.ids {
+			if id >= r[0] && id <= r[1] {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !f.since.IsZero() && t.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && t.After(f.until) {
+		return false
+	}
+	return true
+}
+
+// evtxLevels — имена уровней событий для log.level.
+var evtxLevels = map[uint64]string{0: "information", 1: "critical", 2: "error", 3: "warning", 4: "information", 5: "verbose"}
+
+// evtxElementValue приводит элемент UserData к вложенной структуре: листья — значения,
+// элементы с дочерними — словари (повторяющиеся имена — списки).
+func evtxElementValue(e *EVTXElement) interface{} {
+	if len(e.Children) == 0 {
+		return evtxJSONValue(e.Value())
+	}
+	m := make(map[string]interface{})
+	for _, c := range e.Children {
+		v := evtxElementValue(c)
+		switch prev := m[c.Name].(type) {
+		case nil:
+			m[c.Name] = v
+		case []interface{}:
+			m[c.Name] = append(prev, v)
+		default:
+			m[c.Name] = []interface{}{prev, v}
+		}
+	}
+	return m
+}
+
+func evtxUint(v interface{}) (uint64, bool) {
+	switch x := v.(type) {
+	case uint64:
+		return x, true
+	case int64:
+		return uint64(x), x >= 0
+	case string:
+		n, err := strconv.ParseUint(strings.TrimSpace(x), 0, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// normalizeEVTXRecord приводит запись к виду, принятому в Winlogbeat: event.*, winlog.*, log.*.
+// Возвращает запись, канал, код события и время для фильтрации.
+func normalizeEVTXRecord(rec *EVTXRecord, path string) (map[string]interface{}, string, int, time.Time) {
+	winlog := map[string]interface{}{"record_id": rec.ID}
+	event := map[string]interface{}{"kind": "event"}
+	out := map[string]interface{}{
+		"event":  event,
+		"winlog": winlog,
+		"log":    map[string]interface{}{"file": map[string]interface{}{"path": path}},
+	}
+	created := rec.Written
+	var channel string
+	var id int
+
+	sys := rec.Event.Child("System")
+	if sys != nil {
+		for _, c := range sys.Children {
+			switch c.Name {
+			case "Provider":
+				if name, ok := c.Attrs["Name"].(string); ok {
+					winlog["provider_name"] = name
+					event["provider"] = name
+				}
+				if guid, ok := c.Attrs["Guid"].(string); ok {
+					winlog["provider_guid"] = guid
+				}
+			case "EventID":
+				if n, ok := evtxUint(c.Value()); ok {
+					id = int(n)
+					winlog["event_id"] = n
+					event["code"] = strconv.Itoa(id)
+				}
+			case "Version", "Task", "Opcode":
+				if n, ok := evtxUint(c.Value()); ok {
+					winlog[strings.ToLower(c.Name)] = n
+				}
+			case "Level":
+				if n, ok := evtxUint(c.Value()); ok {
+					out["log"].(map[string]interface{})["level"] = evtxLevels[n]
+					winlog["level"] = n
+				}
+			case "Keywords":
+				if v := c.Value(); v != nil {
+					winlog["keywords"] = evtxString(v)
+				}
+			case "TimeCreated":
+				switch t := c.Attrs["SystemTime"].(type) {
+				case time.Time:
+					created = t
+				case string:
+					if parsed, err := time.Parse(time.RFC3339Nano, t); err == nil {
+						created = parsed
+					}
+				}
+			case "Correlation":
+				if v, ok := c.Attrs["ActivityID"]; ok {
+					winlog["activity_id"] = evtxJSONValue(v)
+				}
+				if v, ok := c.Attrs["RelatedActivityID"]; ok {
+					winlog["related_activity_id"] = evtxJSONValue(v)
+				}
+			case "Execution":
+				process := map[string]interface{}{}
+				if n, ok := evtxUint(c.Attrs["ProcessID"]); ok {
+					process["pid"] = n
+				}
+				if n, ok := evtxUint(c.Attrs["ThreadID"]); ok {
+					process["thread"] = map[string]interface{}{"id": n}
+				}
+				if len(process) > 0 {
+					winlog["process"] = process
+				}
+			case "Channel":
+				channel = evtxString(c.Value())
+				winlog["channel"] = channel
+			case "Computer":
+				winlog["computer_name"] = evtxString(c.Value())
+			case "Security":
+				if v, ok := c.Attrs["UserID"]; ok {
+					winlog["user"] = map[string]interface{}{"identifier": evtxJSONValue(v)}
+				}
+			}
+		}
+	}
+	if data := rec.Event.Child("EventData"); data != nil {
+		fields := make(map[string]interface{})
+		param := 0
+		for _, c := range data.Children {
+			name, _ := c.Attrs["Name"].(string)
+			if name == "" {
+				if c.Name != "Data" {
+					name = c.Name
+				} else {
+					param++
+					name = fmt.Sprintf("param%d", param)
+				}
+			}
+			fields[name] = evtxJSONValue(c.Value())
+		}
+		if len(fields) > 0 {
+			winlog["event_data"] = fields
+		}
+	}
+	if data := rec.Event.Child("UserData"); data != nil && len(data.Children) > 0 {
+		user, ok := evtxElementValue(data.Children[0]).(map[string]interface{})
+		if !ok {
+			user = map[string]interface{}{"value": evtxElementValue(data.Children[0])}
+		}
+		user["xml_name"] = data.Children[0].Name
+		winlog["user_data"] = user
+	}
+	if rec.Recovered {
+		winlog["recovered"] = true
+	}
+	event["created"] = formatTime(rec.Written)
+	out["@timestamp"] = formatTime(created)
+	return out, channel, id, created
+}
+
+// ----------------------------------------------------------------------
+// evtxParser – разбор журналов событий как ArtifactParser
+// ----------------------------------------------------------------------
+
+type evtxParser struct {
+	filter *EVTXFilter
+}
+
+func newEVTXParser(opts parserOptions) (ArtifactParser, error) {
+	filter, err := ParseEVTXFilter(opts.EVTXFilter)
+	if err != nil {
+		return nil, err
+	}
+	return &evtxParser{filter: filter}, nil
+}
+
+func (p *evtxParser) Name() string { return PARSER_EVTX }
+
+func (p *evtxParser) Match(path string) bool {
+	return strings.EqualFold(filepath.Ext(strings.ReplaceAll(path, `\`, "/")), ".evtx")
+}
+
+func (p *evtxParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	return readEVTX(r, func(rec *EVTXRecord) error {
+		out, channel, id, created := normalizeEVTXRecord(rec, path)
+		if !p.filter.Match(channel, id, created) {
+			return nil
+		}
+		return emit(out)
+	})
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testChunk собирает блок EVTX: записи с двоичным XML, шаблонами и подстановками.
+type testChunk struct {
+	data      []byte
+	pos       int
+	templates map[string]uint32
+	first     uint64
+	last      uint64
+}
+
+func newTestChunk() *testChunk {
+	c := &testChunk{data: make([]byte, EVTX_CHUNK_SIZE), pos: EVTX_CHUNK_HEADER_SIZE, templates: make(map[string]uint32)}
+	copy(c.data, "ElfChnk\x00")
+	return c
+}
+
+func (c *testChunk) put(b ...byte) {
+	copy(c.data[c.pos:], b)
+	c.pos += len(b)
+}
+
+func (c *testChunk) u16(v uint16) { c.put(byte(v), byte(v>>8)) }
+
+func (c *testChunk) u32(v uint32) {
+	binary.LittleEndian.PutUint32(c.data[c.pos:], v)
+	c.pos += 4
+}
+
+func (c *testChunk) u64(v uint64) {
+	binary.LittleEndian.PutUint64(c.data[c.pos:], v)
+	c.pos += 8
+}
+
+func testUTF16(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(u), byte(u>>8))
+	}
+	return b
+}
+
+// name записывает ссылку на имя и само имя сразу за ней.
+func (c *testChunk) name(s string) {
+	c.u32(uint32(c.pos + 4))
+	c.u32(0)
+	c.u16(0)
+	c.u16(uint16(len([]rune(s))))
+	c.put(testUTF16(s)...)
+	c.u16(0)
+}
+
+type testAttr struct {
+	name  string
+	value func()
+}
+
+func (c *testChunk) elem(name string, attrs []testAttr, children func()) {
+	tok := byte(EVTX_TOKEN_OPEN_ELEMENT)
+	if len(attrs) > 0 {
+		tok |= EVTX_TOKEN_MORE
+	}
+	c.put(tok)
+	c.u16(0xffff)
+	c.u32(0)
+	c.name(name)
+	if len(attrs) > 0 {
+		c.u32(0)
+		for i, a := range attrs {
+			tok := byte(EVTX_TOKEN_ATTRIBUTE)
+			if i < len(attrs)-1 {
+				tok |= EVTX_TOKEN_MORE
+			}
+			c.put(tok)
+			c.name(a.name)
+			a.value()
+		}
+	}
+	if children == nil {
+		c.put(EVTX_TOKEN_CLOSE_EMPTY)
+		return
+	}
+	c.put(EVTX_TOKEN_CLOSE_START)
+	children()
+	c.put(EVTX_TOKEN_END_ELEMENT)
+}
+
+func (c *testChunk) text(s string) {
+	c.put(EVTX_TOKEN_VALUE, EVTX_TYPE_STRING)
+	c.u16(uint16(len([]rune(s))))
+	c.put(testUTF16(s)...)
+}
+
+func (c *testChunk) subst(id uint16, typ byte) func() {
+	return func() {
+		c.put(EVTX_TOKEN_SUBSTITUTION)
+		c.u16(id)
+		c.put(typ)
+	}
+}
+
+func (c *testChunk) optSubst(id uint16, typ byte) func() {
+	return func() {
+		c.put(EVTX_TOKEN_OPTIONAL_SUBST)
+		c.u16(id)
+		c.put(typ)
+	}
+}
+
+// testValue — значение подстановки; write записывает значение, размер которого заранее
+// неизвестен (вложенный BinXml), прямо в блок.
+type testValue struct {
+	typ   byte
+	data  []byte
+	write func()
+}
+
+// instance записывает экземпляр шаблона key: при первом использовании определение
+// записывается в блок, далее используется ссылка на него.
+func (c *testChunk) instance(key string, body func(), values []testValue) {
+	c.put(EVTX_TOKEN_TEMPLATE, 1)
+	c.u32(1)
+	if off, ok := c.templates[key]; ok {
+		c.u32(off)
+	} else {
+		off := uint32(c.pos + 4)
+		c.templates[key] = off
+		c.u32(off)
+		c.u32(0)
+		c.put(make([]byte, 16)...)
+		sizePos := c.pos
+		c.u32(0)
+		start := c.pos
+		c.put(EVTX_TOKEN_FRAGMENT, 1, 1, 0)
+		body()
+		c.put(EVTX_TOKEN_EOS)
+		binary.LittleEndian.PutUint32(c.data[sizePos:], uint32(c.pos-start))
+	}
+	c.u32(uint32(len(values)))
+	descs := c.pos
+	for _, v := range values {
+		c.u16(uint16(len(v.data)))
+		c.put(v.typ, 0)
+	}
+	for i, v := range values {
+		if v.write == nil {
+			c.put(v.data...)
+			continue
+		}
+		start := c.pos
+		v.write()
+		binary.LittleEndian.PutUint16(c.data[descs+i*4:], uint16(c.pos-start))
+	}
+}
+
+// record записывает запись события; возвращает её смещение в блоке.
+func (c *testChunk) record(id uint64, written time.Time, body func()) int {
+	start := c.pos
+	c.put(0x2a, 0x2a, 0, 0)
+	c.u32(0)
+	c.u64(id)
+	c.u64(timeToFiletime(written))
+	c.put(EVTX_TOKEN_FRAGMENT, 1, 1, 0)
+	body()
+	c.put(EVTX_TOKEN_EOS)
+	size := c.pos - start + 4
+	c.u32(uint32(size))
+	binary.LittleEndian.PutUint32(c.data[start+4:], uint32(size))
+	if c.first == 0 {
+		c.first = id
+	}
+	c.last = id
+	return start
+}
+
+// seal записывает заголовок блока со смещением свободного места free и контрольными суммами.
+func (c *testChunk) seal(free int) []byte {
+	le := binary.LittleEndian
+	le.PutUint64(c.data[8:], c.first)
+	le.PutUint64(c.data[16:], c.last)
+	le.PutUint64(c.data[24:], c.first)
+	le.PutUint64(c.data[32:], c.last)
+	le.PutUint32(c.data[40:], 128)
+	le.PutUint32(c.data[48:], uint32(free))
+	le.PutUint32(c.data[52:], crc32.ChecksumIEEE(c.data[EVTX_CHUNK_HEADER_SIZE:free]))
+	crc := crc32.NewIEEE()
+	crc.Write(c.data[:120])
+	crc.Write(c.data[128:EVTX_CHUNK_HEADER_SIZE])
+	le.PutUint32(c.data[124:], crc.Sum32())
+	return c.data
+}
+
+func testFiletime(t time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(nil, timeToFiletime(t))
+}
+
+func testEVTXFile(chunks ...[]byte) []byte {
+	header := make([]byte, EVTX_FILE_HEADER_SIZE)
+	copy(header, "ElfFile\x00")
+	binary.LittleEndian.PutUint32(header[120:], EVTX_FILE_DIRTY)
+	return bytes.Join(append([][]byte{header}, chunks...), nil)
+}
+
+// securityEvent записывает событие по шаблону с System и именованными EventData.
+func (c *testChunk) securityEvent(channel string, eventID uint16, created time.Time, user string, logonType uint32) {
+	body := func() {
+		c.elem("Event", []testAttr{{"xmlns", func() { c.text("http://schemas.microsoft.com/win/2004/08/events/event") }}}, func() {
+			c.elem("System", nil, func() {
+				c.elem("Provider", []testAttr{{"Name", c.subst(0, EVTX_TYPE_STRING)}, {"Guid", c.subst(1, EVTX_TYPE_GUID)}}, nil)
+				c.elem("EventID", nil, c.subst(2, EVTX_TYPE_UINT16))
+				c.elem("Level", nil, c.subst(3, EVTX_TYPE_UINT8))
+				c.elem("TimeCreated", []testAttr{{"SystemTime", c.subst(4, EVTX_TYPE_FILETIME)}}, nil)
+				c.elem("Execution", []testAttr{{"ProcessID", c.subst(5, EVTX_TYPE_UINT32)}, {"ThreadID", c.subst(6, EVTX_TYPE_UINT32)}}, nil)
+				c.elem("Channel", nil, c.subst(7, EVTX_TYPE_STRING))
+				c.elem("Computer", nil, c.subst(8, EVTX_TYPE_STRING))
+				c.elem("Security", []testAttr{{"UserID", c.optSubst(9, EVTX_TYPE_SID)}}, nil)
+			})
+			c.elem("EventData", nil, func() {
+				c.elem("Data", []testAttr{{"Name", func() { c.text("TargetUserName") }}}, c.subst(10, EVTX_TYPE_STRING))
+				c.elem("Data", []testAttr{{"Name", func() { c.text("LogonType") }}}, c.subst(11, EVTX_TYPE_UINT32))
+				c.elem("Data", nil, c.optSubst(12, EVTX_TYPE_STRING))
+			})
+		})
+	}
+	guid := []byte{0x78, 0x56, 0x34, 0x12, 0x34, 0x12, 0x78, 0x56, 1, 2, 3, 4, 5, 6, 7, 8}
+	sid := []byte{1, 1, 0, 0, 0, 0, 0, 5, 18, 0, 0, 0}
+	u32 := func(v uint32) []byte { return binary.LittleEndian.AppendUint32(nil, v) }
+	c.instance("security", body, []testValue{
+		{typ: EVTX_TYPE_STRING, data: testUTF16("Microsoft-Windows-Security-Auditing")},
+		{typ: EVTX_TYPE_GUID, data: guid},
+		{typ: EVTX_TYPE_UINT16, data: []byte{byte(eventID), byte(eventID >> 8)}},
+		{typ: EVTX_TYPE_UINT8, data: []byte{0}},
+		{typ: EVTX_TYPE_FILETIME, data: testFiletime(created)},
+		{typ: EVTX_TYPE_UINT32, data: u32(612)},
+		{typ: EVTX_TYPE_UINT32, data: u32(4100)},
+		{typ: EVTX_TYPE_STRING, data: testUTF16(channel)},
+		{typ: EVTX_TYPE_STRING, data: testUTF16("WS01.corp.local")},
+		{typ: EVTX_TYPE_SID, data: sid},
+		{typ: EVTX_TYPE_STRING, data: testUTF16(user)},
+		{typ: EVTX_TYPE_UINT32, data: u32(logonType)},
+		{typ: EVTX_TYPE_STRING, data: testUTF16("extra")},
+	})
+}
+
+// userDataEvent записывает событие, у которого UserData передаётся подстановкой BinXml
+// со вложенным экземпляром шаблона.
+func (c *testChunk) userDataEvent(created time.Time) {
+	body := func() {
+		c.elem("Event", nil, func() {
+			c.elem("System", nil, func() {
+				c.elem("Provider", []testAttr{{"Name", c.subst(0, EVTX_TYPE_STRING)}}, nil)
+				c.elem("EventID", nil, c.subst(1, EVTX_TYPE_UINT16))
+				c.elem("Level", nil, c.subst(2, EVTX_TYPE_UINT8))
+				c.elem("TimeCreated", []testAttr{{"SystemTime", c.subst(3, EVTX_TYPE_FILETIME)}}, nil)
+				c.elem("Channel", nil, c.subst(4, EVTX_TYPE_STRING))
+			})
+			c.subst(5, EVTX_TYPE_BINXML)()
+		})
+	}
+	userData := func() {
+		c.put(EVTX_TOKEN_FRAGMENT, 1, 1, 0)
+		c.instance("userdata", func() {
+			c.elem("UserData", nil, func() {
+				c.elem("LogFileCleared", nil, func() {
+					c.elem("SubjectUserName", nil, c.subst(0, EVTX_TYPE_STRING))
+					c.elem("SubjectDomainName", nil, c.subst(1, EVTX_TYPE_STRING))
+				})
+			})
+		}, []testValue{{typ: EVTX_TYPE_STRING, data: testUTF16("admin")}, {typ: EVTX_TYPE_STRING, data: testUTF16("CORP")}})
+		c.put(EVTX_TOKEN_EOS)
+	}
+	c.instance("eventlog", body, []testValue{
+		{typ: EVTX_TYPE_STRING, data: testUTF16("Microsoft-Windows-Eventlog")},
+		{typ: EVTX_TYPE_UINT16, data: []byte{104, 0}},
+		{typ: EVTX_TYPE_UINT8, data: []byte{4}},
+		{typ: EVTX_TYPE_FILETIME, data: testFiletime(created)},
+		{typ: EVTX_TYPE_STRING, data: testUTF16("System")},
+		{typ: EVTX_TYPE_BINXML, write: userData},
+	})
+}
+
+func collectEVTX(t *testing.T, data []byte) []*EVTXRecord {
+	var records []*EVTXRecord
+	err := readEVTX(bytes.NewReader(data), func(rec *EVTXRecord) error {
+		records = append(records, rec)
+		return nil
+	})
+	assert.NoError(t, err)
+	return records
+}
+
+func TestReadEVTX(t *testing.T) {
+	t0 := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	c := newTestChunk()
+	c.record(1, t0, func() { c.securityEvent("Security", 4624, t0, "alice", 3) })
+	c.record(2, t0.Add(time.Minute), func() { c.securityEvent("Security", 4625, t0.Add(time.Minute), "bob", 10) })
+	c.record(3, t0.Add(2*time.Minute), func() { c.userDataEvent(t0.Add(2 * time.Minute)) })
+	free := c.pos
+	// Запись за смещением свободного места (не учтена в заголовке блока)
+	c.record(4, t0.Add(3*time.Minute), func() { c.securityEvent("Security", 4634, t0.Add(3*time.Minute), "carol", 3) })
+	chunk0 := c.seal(free)
+
+	// «Грязный» блок: повреждённая первая запись, дубликат записи 2 и новая запись 5
+	c = newTestChunk()
+	damaged := c.record(9, t0, func() { c.securityEvent("Security", 4624, t0, "mallory", 3) })
+	c.record(2, t0.Add(time.Minute), func() { c.securityEvent("Security", 4625, t0.Add(time.Minute), "bob", 10) })
+	c.record(5, t0.Add(4*time.Minute), func() { c.securityEvent("Security", 4688, t0.Add(4*time.Minute), "dave", 2) })
+	chunk1 := c.seal(c.pos)
+	chunk1[damaged+28] = 0xee
+	chunk1[124] ^= 0xff
+
+	records := collectEVTX(t, testEVTXFile(chunk0, chunk1))
+	var ids []uint64
+	recovered := map[uint64]bool{}
+	for _, rec := range records {
+		ids = append(ids, rec.ID)
+		recovered[rec.ID] = rec.Recovered
+	}
+	assert.Equal(t, []uint64{1, 2, 3, 4, 5}, ids)
+	assert.Equal(t, map[uint64]bool{1: false, 2: false, 3: false, 4: true, 5: true}, recovered)
+
+	out, channel, id, created := normalizeEVTXRecord(records[0], `C:\Windows\System32\winevt\Logs\Security.evtx`)
+	assert.Equal(t, "Security", channel)
+	assert.Equal(t, 4624, id)
+	assert.Equal(t, t0, created)
+	assert.Equal(t, "2024-05-01T08:00:00Z", out["@timestamp"])
+	assert.Equal(t, "4624", out["event"].(map[string]interface{})["code"])
+	assert.Equal(t, "information", out["log"].(map[string]interface{})["level"])
+	winlog := out["winlog"].(map[string]interface{})
+	assert.Equal(t, "Microsoft-Windows-Security-Auditing", winlog["provider_name"])
+	assert.Equal(t, "{12345678-1234-5678-0102-030405060708}", winlog["provider_guid"])
+	assert.Equal(t, "WS01.corp.local", winlog["computer_name"])
+	assert.Equal(t, uint64(1), winlog["record_id"])
+	assert.Equal(t, map[string]interface{}{"identifier": "S-1-5-18"}, winlog["user"])
+	assert.Equal(t, map[string]interface{}{"pid": uint64(612), "thread": map[string]interface{}{"id": uint64(4100)}}, winlog["process"])
+	assert.Equal(t, map[string]interface{}{"TargetUserName": "alice", "LogonType": uint64(3), "param1": "extra"}, winlog["event_data"])
+	assert.Nil(t, winlog["recovered"])
+
+	out, channel, id, _ = normalizeEVTXRecord(records[2], "System.evtx")
+	assert.Equal(t, "System", channel)
+	assert.Equal(t, 104, id)
+	assert.Equal(t, map[string]interface{}{
+		"xml_name":          "LogFileCleared",
+		"SubjectUserName":   "admin",
+		"SubjectDomainName": "CORP",
+	}, out["winlog"].(map[string]interface{})["user_data"])
+
+	out, _, _, _ = normalizeEVTXRecord(records[3], "Security.evtx")
+	assert.Equal(t, true, out["winlog"].(map[string]interface{})["recovered"])
+
+	assert.Error(t, readEVTX(bytes.NewReader([]byte("not an event log")), func(*EVTXRecord) error { return nil }))
+}
+
+func TestEVTXSubstitutionAmplification(t *testing.T) {
+	// Каждый уровень ссылается на вложенный BinXml 16 раз: без ограничения запись
+	// раскрывается в 16^6 элементов
+	c := newTestChunk()
+	var level func(n int) func()
+	level = func(n int) func() {
+		return func() {
+			c.put(EVTX_TOKEN_FRAGMENT, 1, 1, 0)
+			if n == 0 {
+				c.elem("Leaf", nil, nil)
+			} else {
+				c.instance("amp", func() {
+					c.elem("E", nil, func() {
+						for i := 0; i < 16; i++ {
+							c.subst(0, EVTX_TYPE_BINXML)()
+						}
+					})
+				}, []testValue{{typ: EVTX_TYPE_BINXML, write: level(n - 1)}})
+			}
+			c.put(EVTX_TOKEN_EOS)
+		}
+	}
+	pos := c.record(1, time.Now(), func() {
+		c.instance("top", func() { c.elem("Event", nil, c.subst(0, EVTX_TYPE_BINXML)) },
+			[]testValue{{typ: EVTX_TYPE_BINXML, write: level(6)}})
+	})
+	chunk := &evtxChunk{data: c.seal(c.pos), templates: make(map[uint32][]*evtxNode)}
+	_, _, err := chunk.parseRecord(pos)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "too many nodes")
+	}
+}
+
+func TestEVTXFilter(t *testing.T) {
+	f, err := ParseEVTXFilter("channel=Security, System; id=4624,4688-4690; since=2024-01-01; until=2024-02-01T00:00:00Z")
+	assert.NoError(t, err)
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	assert.True(t, f.Match("security", 4624, day))
+	assert.True(t, f.Match("System", 4689, day))
+	assert.False(t, f.Match("Application", 4624, day))
+	assert.False(t, f.Match("Security", 4625, day))
+	assert.False(t, f.Match("Security", 4624, day.AddDate(0, -1, 0)))
+	assert.False(t, f.Match("Security", 4624, day.AddDate(0, 1, 0)))
+
+	empty, err := ParseEVTXFilter("")
+	assert.NoError(t, err)
+	assert.True(t, empty.Match("Anything", 1, time.Time{}))
+
+	for _, spec := range []string{"channel", "id=abc", "id=10-5", "since=yesterday", "level=2"} {
+		_, err := ParseEVTXFilter(spec)
+		assert.Error(t, err, spec)
+	}
+}
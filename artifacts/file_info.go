@@ -5,6 +5,7 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/hex"
 	"fmt"
 	"hash"
@@ -35,6 +36,21 @@ type FileInfo struct {
 	sha1Hash   hash.Hash
 	sha256Hash hash.Hash
 	mimeType   string
+	err        error
+
+	// Метаданные, снятые до чтения содержимого: чтение меняет время доступа
+	meta *FileMetadata
+
+	// Правила проверки содержимого и сработавшие правила
+	rules       *RuleSet
+	ruleMatches []*RuleMatch
+
+	// Доверенные корни для проверки цепочки подписи Authenticode
+	trustedRoots *x509.CertPool
+
+	// Нечёткие хеши (ssdeep, tlsh), вычисляемые вместе с криптографическими
+	fuzzyKinds   []string
+	fuzzyHashers map[string]hash.Hash
 }
 
 func NewFileInfo(po FilePathObject) *FileInfo {
@@ -50,10 +66,13 @@ func (f *FileInfo) Compute() map[string]interface{} {
 	f.md5Hash = md5.New()
 	f.sha1Hash = sha1.New()
 	f.sha256Hash = sha256.New()
+	f.fuzzyHashers = newFuzzyHashers(f.fuzzyKinds)
 
+	f.meta = getFileMetadata(f.po)
 	chunks, err := f.po.ReadChunks()
 	if err != nil {
 		//logger.Log(LevelError, "ReadChunks error: "+err.Error())
+		f.err = err
 		return nil
 	}
 
@@ -61,6 +80,9 @@ func (f *FileInfo) Compute() map[string]interface{} {
 		f.md5Hash.Write(c)
 		f.sha1Hash.Write(c)
 		f.sha256Hash.Write(c)
+		for _, h := range f.fuzzyHashers {
+			h.Write(c)
+		}
 		if i == 0 {
 			f.mimeType = http.DetectContentType(c)
 			//logger.Log(LevelDebug, "Detected MIME type: "+f.mimeType)
@@ -76,24 +98,92 @@ func (f *FileInfo) Compute() map[string]interface{} {
 		}
 	}
 
+	// ELF — буферизуем для разбора заголовков
+	if len(chunks) > 0 && isELF(chunks[0]) {
+		f.mimeType = ELF_MIME_TYPE
+		if f.size < MAX_PE_SIZE {
+			f.content = bytes.Join(chunks, nil)
+		}
+	}
+
+	// Проверка содержимого правилами
+	if f.rules != nil && f.rules.Len() > 0 && f.size <= MAX_RULES_SCAN_SIZE {
+		data := f.content
+		if data == nil {
+			data = bytes.Join(chunks, nil)
+		}
+		f.ruleMatches = f.rules.Scan(data)
+	}
+
 	return f.buildResult()
 }
 
+// SetRules задаёт правила, которыми Compute проверяет содержимое файла.
+func (f *FileInfo) SetRules(rules *RuleSet) {
+	f.rules = rules
+}
+
+// SetTrustedRoots задаёт доверенные корневые сертификаты для проверки подписей PE.
+// Без них проверяется только целостность подписи, но не доверие к издателю.
+func (f *FileInfo) SetTrustedRoots(roots *x509.CertPool) {
+	f.trustedRoots = roots
+}
+
+// SetFuzzyHashes задаёт нечёткие хеши (ssdeep, tlsh), добавляемые в file.hash.
+func (f *FileInfo) SetFuzzyHashes(kinds []string) {
+	f.fuzzyKinds = kinds
+}
+
+// RuleMatches возвращает правила, сработавшие при последнем вызове Compute.
+func (f *FileInfo) RuleMatches() []*RuleMatch {
+	return f.ruleMatches
+}
+
+// Err возвращает ошибку чтения файла, из-за которой Compute вернул nil.
+func (f *FileInfo) Err() error {
+	return f.err
+}
+
 func (f *FileInfo) buildResult() map[string]interface{} {
 	f.info["@timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	hashes := map[string]string{
+		"md5":    hex.EncodeToString(f.md5Hash.Sum(nil)),
+		"sha1":   hex.EncodeToString(f.sha1Hash.Sum(nil)),
+		"sha256": hex.EncodeToString(f.sha256Hash.Sum(nil)),
+	}
+	// Для слишком коротких файлов нечёткий хеш не определён и не записывается
+	for kind, h := range f.fuzzyHashers {
+		if sum := h.Sum(nil); len(sum) > 0 {
+			hashes[kind] = string(sum)
+		}
+	}
 	fileMap := map[string]interface{}{
 		"size":      f.size,
 		"path":      f.po.GetPath(),
 		"mime_type": f.mimeType,
-		"hash": map[string]string{
-			"md5":    hex.EncodeToString(f.md5Hash.Sum(nil)),
-			"sha1":   hex.EncodeToString(f.sha1Hash.Sum(nil)),
-			"sha256": hex.EncodeToString(f.sha256Hash.Sum(nil)),
-		},
+		"hash":      hashes,
+	}
+	// Временные метки, права и владелец — для построения временной шкалы
+	if f.meta != nil {
+		for k, v := range f.meta.AsDict() {
+			fileMap[k] = v
+		}
 	}
 	f.info["file"] = fileMap
 
-	if len(f.content) > 0 {
+	if len(f.ruleMatches) > 0 {
+		rules := make([]map[string]interface{}, 0, len(f.ruleMatches))
+		for _, m := range f.ruleMatches {
+			rules = append(rules, map[string]interface{}{"name": m.Rule, "tags": m.Tags})
+		}
+		f.info["rules"] = rules
+	}
+
+	if isELF(f.content) {
+		if err := f.parseELF(); err != nil {
+			logger.Log(LevelError, "ELF parse error: "+err.Error())
+		}
+	} else if len(f.content) > 0 {
 		if err := f.parsePE(); err != nil {
 			logger.Log(LevelError, "PE parse error: "+err.Error())
 		}
@@ -128,16 +218,15 @@ func (f *FileInfo) parsePE() error {
 		f.addProp("pe", "imphash", imp)
 	}
 
-	// version resource через абстрактную функцию
-	verInfo, err := readVersionInfo(f.po.GetPath())
-	if err != nil {
-		logger.Log(LevelWarning, "VersionInfo failed: "+err.Error())
-	} else {
-		for k, v := range verInfo {
-			f.addProp("pe", k, v)
-		}
+	// ресурс версии, rich-заголовок, секции, импорт/экспорт и оверлей — из байтов файла
+	for k, v := range peInfo(pf, f.content) {
+		f.addProp("pe", k, v)
 	}
 
+	// подпись Authenticode
+	img := &peImage{pf: pf, data: f.content}
+	f.addProp("pe", "signature", img.verifyAuthenticode(f.trustedRoots))
+
 	return nil
 }
 
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// FileMetadata описывает атрибуты файла, которые необходимо сохранить вместе с его содержимым:
+// временные метки MACB, права доступа, владельца, цель символической ссылки,
+// расширенные атрибуты (xattr) и флаги файла (immutable, hidden и т.п.).
+type FileMetadata struct {
+	Mode       os.FileMode
+	Uid        int
+	Gid        int
+	ModTime    time.Time
+	AccessTime time.Time
+	ChangeTime time.Time
+	BirthTime  time.Time
+	LinkTarget string
+	Xattrs     map[string][]byte
+	Flags      []string
+}
+
+// newFileMetadata создаёт метаданные с неизвестными владельцем и группой.
+func newFileMetadata() *FileMetadata {
+	return &FileMetadata{Uid: -1, Gid: -1}
+}
+
+// metadataFileSystem реализуется файловыми системами, умеющими возвращать метаданные файлов.
+type metadataFileSystem interface {
+	Metadata(p *PathObject) (*FileMetadata, error)
+}
+
+// metadataObject реализуется объектами путей, для которых доступны метаданные.
+type metadataObject interface {
+	GetMetadata() (*FileMetadata, error)
+}
+
+// GetMetadata возвращает метаданные файла, если их поддерживает файловая система объекта.
+// Результат первого вызова сохраняется в объекте и возвращается при следующих.
+func (p *PathObject) GetMetadata() (*FileMetadata, error) {
+	if !p.metaRead {
+		p.metaRead = true
+		if mfs, ok := p.filesystem.(metadataFileSystem); ok {
+			p.meta, p.metaErr = mfs.Metadata(p)
+		}
+	}
+	return p.meta, p.metaErr
+}
+
+// getFileMetadata возвращает метаданные для произвольного FilePathObject или nil,
+// если они недоступны.
+func getFileMetadata(po FilePathObject) *FileMetadata {
+	mo, ok := po.(metadataObject)
+	if !ok {
+		return nil
+	}
+	meta, err := mo.GetMetadata()
+	if err != nil {
+		logger.Log(LevelDebug, "Metadata unavailable for "+po.GetPath()+": "+err.Error())
+		return nil
+	}
+	return meta
+}
+
+// statMetadata собирает метаданные файла через os.Stat / os.Lstat и платформенные расширения.
+// Права и временные метки берутся у содержимого (с разыменованием ссылки), цель ссылки — у самого пути.
+func statMetadata(path string) (*FileMetadata, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	meta := newFileMetadata()
+	meta.Mode = info.Mode()
+	meta.ModTime = info.ModTime()
+	fillPlatformMetadata(meta, path, info)
+	meta.Xattrs = readXattrs(path)
+
+	if linfo, err := os.Lstat(path); err == nil && linfo.Mode()&os.ModeSymlink != 0 {
+		if target, err := os.Readlink(path); err == nil {
+			meta.LinkTarget = target
+		}
+	}
+	return meta, nil
+}
+
+// formatTime форматирует временную метку в RFC3339 с наносекундами; нулевое время даёт пустую строку.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// AsDict возвращает метаданные в виде полей, добавляемых в раздел "file" записей file_info.
+func (m *FileMetadata) AsDict() map[string]interface{} {
+	res := map[string]interface{}{
+		"mode": m.Mode.String(),
+	}
+	for field, t := range map[string]time.Time{
+		"mtime":    m.ModTime,
+		"accessed": m.AccessTime,
+		"ctime":    m.ChangeTime,
+		"created":  m.BirthTime,
+	} {
+		if s := formatTime(t); s != "" {
+			res[field] = s
+		}
+	}
+	if m.Uid >= 0 {
+		res["uid"] = m.Uid
+	}
+	if m.Gid >= 0 {
+		res["gid"] = m.Gid
+	}
+	if m.LinkTarget != "" {
+		res["target_path"] = m.LinkTarget
+	}
+	if len(m.Flags) > 0 {
+		res["attributes"] = m.Flags
+	}
+	if len(m.Xattrs) > 0 {
+		for k, v := range xattrsAsDict(m.Xattrs) {
+			res[k] = v
+		}
+	}
+	return res
+}
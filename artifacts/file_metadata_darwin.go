@@ -0,0 +1,46 @@
+//go:build darwin
+// +build darwin
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Флаги st_flags (sys/stat.h, см. chflags(1)).
+var darwinFileFlags = []struct {
+	bit  uint32
+	name string
+}{
+	{0x00000001, "nodump"},
+	{0x00000002, "user_immutable"},
+	{0x00000004, "user_append_only"},
+	{0x00000008, "opaque"},
+	{0x00000020, "compressed"},
+	{0x00008000, "hidden"},
+	{0x00010000, "archived"},
+	{0x00020000, "system_immutable"},
+	{0x00040000, "system_append_only"},
+	{0x00080000, "restricted"},
+	{0x00100000, "nounlink"},
+}
+
+// fillPlatformMetadata дополняет метаданные полями struct stat, включая время создания и st_flags.
+func fillPlatformMetadata(meta *FileMetadata, path string, info os.FileInfo) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	meta.Uid = int(st.Uid)
+	meta.Gid = int(st.Gid)
+	meta.AccessTime = time.Unix(st.Atimespec.Sec, st.Atimespec.Nsec)
+	meta.ChangeTime = time.Unix(st.Ctimespec.Sec, st.Ctimespec.Nsec)
+	meta.BirthTime = time.Unix(st.Birthtimespec.Sec, st.Birthtimespec.Nsec)
+	for _, f := range darwinFileFlags {
+		if st.Flags&f.bit != 0 {
+			meta.Flags = append(meta.Flags, f.name)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Флаги inode, возвращаемые FS_IOC_GETFLAGS (linux/fs.h, см. chattr(1)).
+var linuxInodeFlags = []struct {
+	bit  int
+	name string
+}{
+	{0x00000001, "secure_deletion"},
+	{0x00000002, "undeletable"},
+	{0x00000004, "compressed"},
+	{0x00000008, "sync"},
+	{0x00000010, "immutable"},
+	{0x00000020, "append_only"},
+	{0x00000040, "nodump"},
+	{0x00000080, "noatime"},
+	{0x00000800, "encrypted"},
+	{0x00004000, "journal_data"},
+	{0x00010000, "dirsync"},
+	{0x00800000, "nocow"},
+}
+
+// fillPlatformMetadata дополняет метаданные полями struct stat, временем создания из statx(2)
+// и флагами inode (chattr).
+func fillPlatformMetadata(meta *FileMetadata, path string, info os.FileInfo) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		meta.Uid = int(st.Uid)
+		meta.Gid = int(st.Gid)
+		meta.AccessTime = time.Unix(st.Atim.Sec, st.Atim.Nsec)
+		meta.ChangeTime = time.Unix(st.Ctim.Sec, st.Ctim.Nsec)
+	}
+	// Время создания доступно только через statx и не на всех файловых системах.
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_BTIME, &stx); err == nil && stx.Mask&unix.STATX_BTIME != 0 && stx.Btime.Sec != 0 {
+		meta.BirthTime = time.Unix(stx.Btime.Sec, int64(stx.Btime.Nsec))
+	}
+	if info.Mode().IsRegular() || info.IsDir() {
+		meta.Flags = readInodeFlags(path)
+	}
+}
+
+// readInodeFlags читает флаги inode через ioctl FS_IOC_GETFLAGS.
+func readInodeFlags(path string) []string {
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil
+	}
+	defer unix.Close(fd)
+	attr, err := unix.IoctlGetInt(fd, unix.FS_IOC_GETFLAGS)
+	if err != nil {
+		return nil
+	}
+	var flags []string
+	for _, f := range linuxInodeFlags {
+		if attr&f.bit != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	return flags
+}
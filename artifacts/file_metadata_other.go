@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import "os"
+
+// fillPlatformMetadata-заглушка: на прочих платформах доступны только поля os.FileInfo.
+func fillPlatformMetadata(meta *FileMetadata, path string, info os.FileInfo) {}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/xattr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatMetadata(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("content"), 0600))
+	mtime := time.Date(2019, 3, 4, 5, 6, 7, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(path, mtime, mtime))
+
+	meta, err := statMetadata(path)
+	assert.NoError(t, err)
+	assert.True(t, meta.ModTime.Equal(mtime))
+	assert.Empty(t, meta.LinkTarget)
+
+	dict := meta.AsDict()
+	assert.Equal(t, "2019-03-04T05:06:07Z", dict["mtime"])
+}
+
+func TestCollectKeepsAccessTime(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("content"), 0600))
+	atime := time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, os.Chtimes(path, atime, atime.Add(-time.Hour)))
+
+	// Файл сначала читается в архив, затем file_info: время доступа — из снимка до чтения
+	fs := NewOSFileSystem(dir)
+	fs.AddPattern("TestArtifact", path, FILE_INFO_TYPE)
+	out, err := NewOutputs(t.TempDir(), "", false, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fs.Collect(out)
+	assert.NoError(t, out.Close())
+
+	var accessed []string
+	assert.NoError(t, readJSONL(filepath.Join(out.dirpath, out.hostname+"-file_info.jsonl"), func(line []byte) error {
+		var rec struct {
+			File map[string]interface{} `json:"file"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		accessed = append(accessed, stringField(rec.File, "accessed"))
+		return nil
+	}))
+	assert.Equal(t, []string{"2020-01-02T00:00:00Z"}, accessed)
+}
+
+func TestStatMetadataSymlink(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	link := filepath.Join(dir, "link")
+	assert.NoError(t, os.WriteFile(path, []byte("content"), 0600))
+	if err := os.Symlink(path, link); err != nil {
+		t.Skip("symlinks are not supported: ", err)
+	}
+
+	meta, err := statMetadata(link)
+	assert.NoError(t, err)
+	assert.Equal(t, path, meta.LinkTarget)
+	assert.Zero(t, meta.Mode&os.ModeSymlink)
+}
+
+func TestStatMetadataXattrs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("content"), 0600))
+	if err := xattr.Set(path, "user.fast_dfar", []byte("test value")); err != nil {
+		t.Skip("user xattrs are not supported: ", err)
+	}
+
+	meta, err := statMetadata(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("test value"), meta.Xattrs["user.fast_dfar"])
+
+	dict := meta.AsDict()
+	raw, ok := dict["xattr"].(map[string]string)
+	assert.True(t, ok)
+	assert.Equal(t, "test value", raw["user.fast_dfar"])
+}
+
+func TestDecodePosixACL(t *testing.T) {
+	data := binary.LittleEndian.AppendUint32(nil, 2)
+	for _, e := range []struct {
+		tag, perm uint16
+		id        uint32
+	}{
+		{aclUserObj, 7, 0xFFFFFFFF},
+		{aclUser, 5, 1000},
+		{aclGroupObj, 4, 0xFFFFFFFF},
+		{aclMask, 5, 0xFFFFFFFF},
+		{aclOther, 0, 0xFFFFFFFF},
+	} {
+		data = binary.LittleEndian.AppendUint16(data, e.tag)
+		data = binary.LittleEndian.AppendUint16(data, e.perm)
+		data = binary.LittleEndian.AppendUint32(data, e.id)
+	}
+	assert.Equal(t,
+		[]string{"user::rwx", "user:1000:r-x", "group::r--", "mask::r-x", "other::---"},
+		decodePosixACL(data))
+}
+
+func TestDecodeCapabilities(t *testing.T) {
+	// vfs_cap_data версии 2 с эффективным битом и cap_net_raw (13) + cap_net_admin (12).
+	data := binary.LittleEndian.AppendUint32(nil, 0x02000001)
+	data = binary.LittleEndian.AppendUint32(data, 1<<12|1<<13)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+	data = binary.LittleEndian.AppendUint32(data, 0)
+
+	caps := decodeCapabilities(data)
+	assert.Equal(t, true, caps["effective"])
+	assert.Equal(t, []string{"cap_net_admin", "cap_net_raw"}, caps["permitted"])
+	assert.Equal(t, []string{}, caps["inheritable"])
+}
+
+func TestParseQuarantine(t *testing.T) {
+	q := parseQuarantine("0083;5f1a2b3c;Safari;0A1B2C3D-0000-0000-0000-000000000000")
+	assert.Equal(t, "0083", q["flags"])
+	assert.Equal(t, "Safari", q["agent"])
+	assert.Equal(t, "2020-07-24T00:28:44Z", q["timestamp"])
+}
@@ -0,0 +1,45 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// Атрибуты файла Windows (FILE_ATTRIBUTE_*).
+var windowsFileAttributes = []struct {
+	bit  uint32
+	name string
+}{
+	{syscall.FILE_ATTRIBUTE_READONLY, "readonly"},
+	{syscall.FILE_ATTRIBUTE_HIDDEN, "hidden"},
+	{syscall.FILE_ATTRIBUTE_SYSTEM, "system"},
+	{syscall.FILE_ATTRIBUTE_ARCHIVE, "archive"},
+	{0x00000100, "temporary"},
+	{0x00000200, "sparse"},
+	{syscall.FILE_ATTRIBUTE_REPARSE_POINT, "reparse_point"},
+	{0x00000800, "compressed"},
+	{0x00001000, "offline"},
+	{0x00002000, "not_content_indexed"},
+	{0x00004000, "encrypted"},
+}
+
+// fillPlatformMetadata дополняет метаданные временем доступа и создания, а также
+// атрибутами файла из WIN32_FILE_ATTRIBUTE_DATA.
+// Владелец и группа в терминах uid/gid на Windows отсутствуют.
+func fillPlatformMetadata(meta *FileMetadata, path string, info os.FileInfo) {
+	attr, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return
+	}
+	meta.AccessTime = time.Unix(0, attr.LastAccessTime.Nanoseconds())
+	meta.BirthTime = time.Unix(0, attr.CreationTime.Nanoseconds())
+	for _, f := range windowsFileAttributes {
+		if attr.FileAttributes&f.bit != 0 {
+			meta.Flags = append(meta.Flags, f.name)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/xattr"
+)
+
+// Имена расширенных атрибутов, которые разбираются отдельно.
+const (
+	XATTR_SELINUX     = "security.selinux"
+	XATTR_CAPABILITY  = "security.capability"
+	XATTR_ACL_ACCESS  = "system.posix_acl_access"
+	XATTR_ACL_DEFAULT = "system.posix_acl_default"
+	XATTR_QUARANTINE  = "com.apple.quarantine"
+)
+
+// readXattrs читает все расширенные атрибуты файла (с разыменованием ссылки).
+// На платформах без поддержки xattr и при отсутствии прав возвращает nil.
+func readXattrs(path string) map[string][]byte {
+	names, err := xattr.List(path)
+	if err != nil || len(names) == 0 {
+		return nil
+	}
+	attrs := make(map[string][]byte, len(names))
+	for _, name := range names {
+		value, err := xattr.Get(path, name)
+		if err != nil {
+			logger.Log(LevelDebug, fmt.Sprintf("xattr %s on %s: %v", name, path, err))
+			continue
+		}
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// xattrsAsDict возвращает расширенные атрибуты в пригодном для JSON виде и
+// дополнительно разбирает SELinux-метку, capabilities, POSIX ACL и карантин macOS.
+func xattrsAsDict(attrs map[string][]byte) map[string]interface{} {
+	res := make(map[string]interface{})
+	raw := make(map[string]string, len(attrs))
+	for name, value := range attrs {
+		raw[name] = xattrValueString(value)
+	}
+	res["xattr"] = raw
+
+	if v, ok := attrs[XATTR_SELINUX]; ok {
+		res["selinux"] = strings.TrimRight(string(v), "\x00")
+	}
+	if v, ok := attrs[XATTR_CAPABILITY]; ok {
+		if caps := decodeCapabilities(v); caps != nil {
+			res["capabilities"] = caps
+		}
+	}
+	acl := make(map[string][]string)
+	if v, ok := attrs[XATTR_ACL_ACCESS]; ok {
+		acl["access"] = decodePosixACL(v)
+	}
+	if v, ok := attrs[XATTR_ACL_DEFAULT]; ok {
+		acl["default"] = decodePosixACL(v)
+	}
+	if len(acl) > 0 {
+		res["acl"] = acl
+	}
+	if v, ok := attrs[XATTR_QUARANTINE]; ok {
+		res["quarantine"] = parseQuarantine(string(v))
+	}
+	return res
+}
+
+// xattrValueString возвращает значение атрибута как текст, если оно печатаемое,
+// иначе — в base64 с префиксом "base64:".
+func xattrValueString(value []byte) string {
+	text := strings.TrimRight(string(value), "\x00")
+	if utf8.ValidString(text) && !strings.ContainsFunc(text, func(r rune) bool {
+		return r < 0x20 && r != '\t' && r != '\n' && r != '\r'
+	}) {
+		return text
+	}
+	return "base64:" + base64.StdEncoding.EncodeToString(value)
+}
+
+// Теги записей POSIX ACL (linux/posix_acl_xattr.h).
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+)
+
+// decodePosixACL переводит двоичное представление POSIX ACL в текстовую форму getfacl.
+func decodePosixACL(data []byte) []string {
+	if len(data) < 4 || binary.LittleEndian.Uint32(data) != 2 {
+		return nil
+	}
+	var entries []string
+	for off := 4; off+8 <= len(data); off += 8 {
+		tag := binary.LittleEndian.Uint16(data[off:])
+		perm := binary.LittleEndian.Uint16(data[off+2:])
+		id := binary.LittleEndian.Uint32(data[off+4:])
+		perms := []byte("---")
+		if perm&4 != 0 {
+			perms[0] = 'r'
+		}
+		if perm&2 != 0 {
+			perms[1] = 'w'
+		}
+		if perm&1 != 0 {
+			perms[2] = 'x'
+		}
+		var entry string
+		switch tag {
+		case aclUserObj:
+			entry = "user::"
+		case aclUser:
+			entry = fmt.Sprintf("user:%d:", id)
+		case aclGroupObj:
+			entry = "group::"
+		case aclGroup:
+			entry = fmt.Sprintf("group:%d:", id)
+		case aclMask:
+			entry = "mask::"
+		case aclOther:
+			entry = "other::"
+		default:
+			entry = fmt.Sprintf("tag%#x:%d:", tag, id)
+		}
+		entries = append(entries, entry+string(perms))
+	}
+	return entries
+}
+
+// capabilityNames — имена capabilities Linux в порядке номеров битов (linux/capability.h).
+var capabilityNames = []string{
+	"cap_chown", "cap_dac_override", "cap_dac_read_search", "cap_fowner", "cap_fsetid",
+	"cap_kill", "cap_setgid", "cap_setuid", "cap_setpcap", "cap_linux_immutable",
+	"cap_net_bind_service", "cap_net_broadcast", "cap_net_admin", "cap_net_raw", "cap_ipc_lock",
+	"cap_ipc_owner", "cap_sys_module", "cap_sys_rawio", "cap_sys_chroot", "cap_sys_ptrace",
+	"cap_sys_pacct", "cap_sys_admin", "cap_sys_boot", "cap_sys_nice", "cap_sys_resource",
+	"cap_sys_time", "cap_sys_tty_config", "cap_mknod", "cap_lease", "cap_audit_write",
+	"cap_audit_control", "cap_setfcap", "cap_mac_override", "cap_mac_admin", "cap_syslog",
+	"cap_wake_alarm", "cap_block_suspend", "cap_audit_read", "cap_perfmon", "cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+// decodeCapabilities разбирает значение security.capability (vfs_cap_data версий 1–3).
+func decodeCapabilities(data []byte) map[string]interface{} {
+	if len(data) < 12 {
+		return nil
+	}
+	magic := binary.LittleEndian.Uint32(data)
+	permitted := uint64(binary.LittleEndian.Uint32(data[4:]))
+	inheritable := uint64(binary.LittleEndian.Uint32(data[8:]))
+	// Версии 2 и 3 содержат старшие 32 бита наборов.
+	if magic&0xFF000000 >= 0x02000000 && len(data) >= 20 {
+		permitted |= uint64(binary.LittleEndian.Uint32(data[12:])) << 32
+		inheritable |= uint64(binary.LittleEndian.Uint32(data[16:])) << 32
+	}
+	res := map[string]interface{}{
+		"effective":   magic&1 != 0,
+		"permitted":   capabilitySet(permitted),
+		"inheritable": capabilitySet(inheritable),
+	}
+	// Версия 3 хранит uid корня пространства имён.
+	if magic&0xFF000000 == 0x03000000 && len(data) >= 24 {
+		res["rootid"] = binary.LittleEndian.Uint32(data[20:])
+	}
+	return res
+}
+
+func capabilitySet(bits uint64) []string {
+	caps := []string{}
+	for i := 0; i < 64; i++ {
+		if bits&(1<<uint(i)) == 0 {
+			continue
+		}
+		if i < len(capabilityNames) {
+			caps = append(caps, capabilityNames[i])
+		} else {
+			caps = append(caps, fmt.Sprintf("cap_%d", i))
+		}
+	}
+	return caps
+}
+
+// parseQuarantine разбирает com.apple.quarantine вида "флаги;время(hex);агент;UUID".
+func parseQuarantine(value string) map[string]interface{} {
+	parts := strings.Split(strings.TrimRight(value, "\x00"), ";")
+	res := map[string]interface{}{"raw": value}
+	if len(parts) > 0 {
+		res["flags"] = parts[0]
+	}
+	if len(parts) > 1 {
+		if ts, err := strconv.ParseInt(parts[1], 16, 64); err == nil {
+			res["timestamp"] = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+		}
+	}
+	if len(parts) > 2 {
+		res["agent"] = parts[2]
+	}
+	if len(parts) > 3 {
+		res["event_id"] = parts[3]
+	}
+	return res
+}
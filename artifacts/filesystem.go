@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 	"io/fs"
@@ -13,6 +14,7 @@ import (
 
 	ntfsfs "github.com/forensicanalysis/fslib/ntfs"
 	"github.com/shirou/gopsutil/disk"
+	"www.velocidex.com/golang/go-ntfs/parser"
 )
 
 // Константы
@@ -59,6 +61,12 @@ type patternEntry struct {
 type ArtifactFileSystem struct {
 	patterns []patternEntry
 	fs       FileSystem
+
+	// output и current задают контекст текущего шаблона для записи ошибок обхода каталогов;
+	// failed отмечает, что при обходе текущего шаблона уже записана ошибка.
+	output  *Outputs
+	current patternEntry
+	failed  bool
 }
 
 func NewArtifactFileSystem(fs FileSystem) *ArtifactFileSystem {
@@ -80,8 +88,12 @@ func (afs *ArtifactFileSystem) AddPattern(artifact, pattern, sourceType string)
 }
 
 func (afs *ArtifactFileSystem) Collect(output *Outputs) {
+	afs.output = output
+	defer func() { afs.output = nil }()
+
 	for _, pat := range afs.patterns {
 		logger.Log(LevelDebug, fmt.Sprintf("Collecting pattern '%s' for artifact '%s'", pat.pattern, pat.artifact))
+		afs.current, afs.failed = pat, false
 
 		rel := afs.fs.relativePath(pat.pattern)
 		gen := afs.fs.baseGenerator()
@@ -89,17 +101,36 @@ func (afs *ArtifactFileSystem) Collect(output *Outputs) {
 			gen = gf(gen)
 		}
 
+		matched := 0
 		for po := range gen {
+			matched++
+			// Снимок метаданных до любого чтения: его используют и архив, и file_info
+			getFileMetadata(po)
+			if err := output.AddCollectedFile(pat.artifact, po); err != nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Failed to collect %s for artifact '%s': %v", po.GetPath(), pat.artifact, err))
+			}
 			if pat.sourceType == FILE_INFO_TYPE || output.sha256 {
-				output.AddCollectedFile(pat.artifact, po)
-				output.AddCollectedFileInfo(pat.artifact, po)
-			} else {
-				output.AddCollectedFile(pat.artifact, po)
+				if err := output.AddCollectedFileInfo(pat.artifact, po); err != nil {
+					logger.Log(LevelWarning, fmt.Sprintf("Failed to collect file info for %s (artifact '%s'): %v", po.GetPath(), pat.artifact, err))
+				}
 			}
 		}
+		// Шаблон не дал ни одного файла и ошибок обхода — артефакт на хосте отсутствует.
+		if matched == 0 && !afs.failed {
+			output.AddCollectionError(pat.artifact, pat.sourceType, pat.pattern, REASON_NOT_FOUND, nil)
+		}
 	}
 }
 
+// reportError записывает ошибку обхода файловой системы в контексте текущего шаблона.
+func (afs *ArtifactFileSystem) reportError(path, reason string, err error) {
+	afs.failed = true
+	if afs.output == nil {
+		return
+	}
+	afs.output.AddCollectionError(afs.current.artifact, afs.current.sourceType, path, reason, err)
+}
+
 // ------------------- OSFileSystem (доступ через os) ------------------- //
 
 type OSFileSystem struct {
@@ -238,12 +269,14 @@ func (fsys *OSFileSystem) ListDirectory(p *PathObject) []*PathObject {
 	// Лог ошибки os.ReadDir…
 	vol := filepath.VolumeName(p.path)
 	if vol == "" {
+		fsys.reportError(p.path, reasonForError(err), err)
 		return nil
 	}
 	device := `\\.\` + strings.TrimSuffix(vol, `\`)
 	ntfsFS, nerr := NewNTFSFileSystem(device)
 	if nerr != nil {
 		logger.Log(LevelError, fmt.Sprintf("NTFSFS init failed: %v", nerr))
+		fsys.reportError(p.path, REASON_FILESYSTEM_ERROR, fmt.Errorf("%v; NTFSFS init failed: %w", err, nerr))
 		return nil
 	}
 
@@ -251,6 +284,7 @@ func (fsys *OSFileSystem) ListDirectory(p *PathObject) []*PathObject {
 	ntfsEntries, rerr := fs.ReadDir(ntfsFS.fs, rel)
 	if rerr != nil {
 		logger.Log(LevelError, fmt.Sprintf("NTFSFS ReadDir error on %q: %v", rel, rerr))
+		fsys.reportError(p.path, reasonForError(rerr), rerr)
 		return nil
 	}
 	var objs []*PathObject
@@ -334,6 +368,11 @@ func (fs *OSFileSystem) GetSize(p *PathObject) int64 {
 	return info.Size()
 }
 
+// Metadata возвращает временные метки, права, владельца и цель ссылки файла.
+func (fs *OSFileSystem) Metadata(p *PathObject) (*FileMetadata, error) {
+	return statMetadata(p.path)
+}
+
 func isRegistryFile(name string) bool {
 	registryFiles := []string{"SYSTEM", "SOFTWARE", "SAM", "SECURITY", "DEFAULT", "NTUSER.DAT"}
 	for _, reg := range registryFiles {
@@ -352,6 +391,8 @@ type FileSystemManager struct {
 	filesystems map[string]FileSystem
 	variables   *HostVariables
 	mountPoints []disk.PartitionStat
+	// Ошибки регистрации источников; записываются в errors.jsonl при сборе.
+	registrationErrors []*CollectionErrorRecord
 }
 
 func NewFileSystemManager(variables *HostVariables) (*FileSystemManager, error) {
@@ -447,6 +488,9 @@ func (fsm *FileSystemManager) getFilesystemOrError(path string) FileSystem {
 
 // Collect вызывает сбор артефактов для каждой файловой системы.
 func (fsm *FileSystemManager) Collect(output *Outputs) {
+	for _, rec := range fsm.registrationErrors {
+		output.addCollectionErrorRecord(rec)
+	}
 	for mount, fs := range fsm.filesystems {
 		logger.Log(LevelDebug, fmt.Sprintf("Начало сбора для '%s'", mount))
 		fs.Collect(output)
@@ -542,11 +586,15 @@ func (fsm *FileSystemManager) RegisterSource(
 		pathsInterface, exists := artifactSource.Attributes["paths"]
 		if !exists {
 			logger.Log(LevelError, "Нет атрибута 'paths' у источника")
+			fsm.addRegistrationError(artifactDefinition.Name, artifactSource.TypeIndicator, "", REASON_INVALID_SOURCE,
+				fmt.Errorf("missing 'paths' attribute"))
 			return false
 		}
 		pathsSlice, ok := convertToStringSlice(pathsInterface)
 		if !ok || len(pathsSlice) == 0 {
 			logger.Log(LevelError, "Неверный или пустой список путей в источнике")
+			fsm.addRegistrationError(artifactDefinition.Name, artifactSource.TypeIndicator, "", REASON_INVALID_SOURCE,
+				fmt.Errorf("invalid or empty 'paths' attribute"))
 			return false
 		}
 
@@ -554,6 +602,10 @@ func (fsm *FileSystemManager) RegisterSource(
 			// нормализуем разделители
 			patternStr = strings.ReplaceAll(patternStr, "\\", "/")
 			substitutedMap := variables.Substitute(patternStr)
+			if len(substitutedMap) == 0 {
+				fsm.addRegistrationError(artifactDefinition.Name, artifactSource.TypeIndicator, patternStr, REASON_UNRESOLVED_PATH,
+					fmt.Errorf("path variables could not be resolved"))
+			}
 			for resPath := range substitutedMap {
 				resolvedPath := strings.ReplaceAll(resPath, "\\", "/")
 
@@ -563,7 +615,12 @@ func (fsm *FileSystemManager) RegisterSource(
 				}
 
 				// единоразово выбираем файловую систему по пути
-				fs := fsm.getFilesystemOrError(resolvedPath)
+				fs, err := fsm.getFilesystem(resolvedPath)
+				if err != nil {
+					logger.Log(LevelError, fmt.Sprintf("Ошибка получения файловой системы для шаблона %s: %v", resolvedPath, err))
+					fsm.addRegistrationError(artifactDefinition.Name, artifactSource.TypeIndicator, resolvedPath, REASON_FILESYSTEM_ERROR, err)
+					continue
+				}
 				if fs == nil {
 					continue
 				}
@@ -583,10 +640,17 @@ func (fsm *FileSystemManager) RegisterSource(
 	return supported
 }
 
+// addRegistrationError запоминает ошибку регистрации источника до начала сбора.
+func (fsm *FileSystemManager) addRegistrationError(artifact, source, path, reason string, err error) {
+	fsm.registrationErrors = append(fsm.registrationErrors, newCollectionErrorRecord(artifact, source, path, reason, err))
+}
+
 // NTFSFileSystem реализует FileSystem через forensicanalysis/fslib/ntfs
 type NTFSFileSystem struct {
 	volHandle *os.File
 	fs        *ntfsfs.FS
+	// mftRecordSize — размер записи $MFT тома, определяется при первом чтении
+	mftRecordSize int
 	*ArtifactFileSystem
 }
 
@@ -634,6 +698,7 @@ func (nts *NTFSFileSystem) ListDirectory(p *PathObject) []*PathObject {
 	// Перечисляем через io/fs ReadDir: поддержка любых директорий NTFS :contentReference[oaicite:2]{index=2}
 	entries, err := fs.ReadDir(nts.fs, p.path)
 	if err != nil {
+		nts.reportError(p.path, reasonForError(err), err)
 		return nil
 	}
 	var res []*PathObject
@@ -733,6 +798,76 @@ func (nts *NTFSFileSystem) GetSize(p *PathObject) int64 {
 	return st.Size()
 }
 
+// Metadata возвращает права и временные метки файла. Метки берутся из атрибута
+// $STANDARD_INFORMATION записи MFT; если запись прочитать не удалось — из ntfsfs.
+func (nts *NTFSFileSystem) Metadata(p *PathObject) (*FileMetadata, error) {
+	file, err := nts.fs.Open(nts.relativePath(p.path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	st, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	meta := newFileMetadata()
+	meta.Mode = st.Mode()
+	meta.ModTime = st.ModTime()
+	info, ok := st.Sys().(*parser.FileInfo)
+	if !ok {
+		return meta, nil
+	}
+	meta.AccessTime, meta.ChangeTime = info.Atime, info.Ctime
+	record, err := strconv.ParseUint(strings.SplitN(info.MFTId, "-", 2)[0], 10, 64)
+	if err != nil {
+		return meta, nil
+	}
+	si, err := nts.standardInfo(record)
+	if err != nil {
+		logger.Log(LevelDebug, fmt.Sprintf("$STANDARD_INFORMATION unavailable for %s: %v", p.path, err))
+		return meta, nil
+	}
+	meta.ModTime, meta.AccessTime = si.Modified, si.Accessed
+	meta.ChangeTime, meta.BirthTime = si.Changed, si.Created
+	return meta, nil
+}
+
+// standardInfo читает запись MFT с указанным номером из $MFT тома и разбирает её
+// атрибут $STANDARD_INFORMATION. Размер записи берётся из заголовка первой записи.
+func (nts *NTFSFileSystem) standardInfo(record uint64) (*MFTStandardInfo, error) {
+	file, err := nts.fs.Open("$MFT")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	ra, ok := file.(io.ReaderAt)
+	if !ok {
+		return nil, fmt.Errorf("$MFT does not support random access")
+	}
+	if nts.mftRecordSize == 0 {
+		head := make([]byte, 32)
+		if _, err := ra.ReadAt(head, 0); err != nil {
+			return nil, err
+		}
+		nts.mftRecordSize = MFT_RECORD_SIZE
+		if s := int(binary.LittleEndian.Uint32(head[28:])); s == 1024 || s == 4096 {
+			nts.mftRecordSize = s
+		}
+	}
+	buf := make([]byte, nts.mftRecordSize)
+	if _, err := ra.ReadAt(buf, int64(record)*int64(nts.mftRecordSize)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	entry, err := ParseMFTRecord(record, buf)
+	if err != nil {
+		return nil, err
+	}
+	if entry.StandardInfo == nil {
+		return nil, fmt.Errorf("MFT record %d has no $STANDARD_INFORMATION", record)
+	}
+	return entry.StandardInfo, nil
+}
+
 // GetPath возвращает вложенный объект внутри NTFSFileSystem.
 // Это нужно для навигации по каталогам.
 func (nts *NTFSFileSystem) GetPath(parent *PathObject, name string) *PathObject {
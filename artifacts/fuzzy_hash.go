@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"hash"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/glaslos/ssdeep"
+)
+
+// Нечёткие хеши для кластеризации похожих образцов; вычисляются только по запросу
+// (-fuzzy-hashes), так как заметно дороже криптографических.
+const (
+	FUZZY_SSDEEP = "ssdeep"
+	FUZZY_TLSH   = "tlsh"
+
+	TLSH_BUCKETS         = 256
+	TLSH_EFF_BUCKETS     = 128
+	TLSH_CODE_SIZE       = 32
+	TLSH_WINDOW          = 5
+	TLSH_MIN_DATA_LENGTH = 50
+	TLSH_MAX_DATA_LENGTH = 4*1024*1024*1024 - 1
+)
+
+// parseFuzzyHashes проверяет список нечётких хешей из конфигурации.
+func parseFuzzyHashes(kinds []string) ([]string, error) {
+	var ret []string
+	for _, kind := range kinds {
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		switch kind {
+		case "":
+			continue
+		case FUZZY_SSDEEP, FUZZY_TLSH:
+			if !containsString(ret, kind) {
+				ret = append(ret, kind)
+			}
+		default:
+			return nil, fmt.Errorf("unknown fuzzy hash %q (supported: %s, %s)", kind, FUZZY_SSDEEP, FUZZY_TLSH)
+		}
+	}
+	return ret, nil
+}
+
+// newFuzzyHashers создаёт потоковые вычислители нечётких хешей. Sum возвращает
+// текстовое представление хеша или пустую строку, если данных недостаточно.
+func newFuzzyHashers(kinds []string) map[string]hash.Hash {
+	hashers := make(map[string]hash.Hash, len(kinds))
+	for _, kind := range kinds {
+		switch kind {
+		case FUZZY_SSDEEP:
+			hashers[kind] = ssdeep.New()
+		case FUZZY_TLSH:
+			hashers[kind] = newTLSH()
+		}
+	}
+	return hashers
+}
+
+// tlshPearson — таблица перестановки Пирсона из эталонной реализации TLSH.
+var tlshPearson = [256]byte{
+	1, 87, 49, 12, 176, 178, 102, 166, 121, 193, 6, 84, 249, 230, 44, 163,
+	14, 197, 213, 181, 161, 85, 218, 80, 64, 239, 24, 226, 236, 142, 38, 200,
+	110, 177, 104, 103, 141, 253, 255, 50, 77, 101, 81, 18, 45, 96, 31, 222,
+	25, 107, 190, 70, 86, 237, 240, 34, 72, 242, 20, 214, 244, 227, 149, 235,
+	97, 234, 57, 22, 60, 250, 82, 175, 208, 5, 127, 199, 111, 62, 135, 248,
+	174, 169, 211, 58, 66, 154, 106, 195, 245, 171, 17, 187, 182, 179, 0, 243,
+	132, 56, 148, 75, 128, 133, 158, 100, 130, 126, 91, 13, 153, 246, 216, 219,
+	119, 68, 223, 78, 83, 88, 201, 99, 122, 11, 92, 32, 136, 114, 52, 10,
+	138, 30, 48, 183, 156, 35, 61, 26, 143, 74, 251, 94, 129, 162, 63, 152,
+	170, 7, 115, 167, 241, 206, 3, 150, 55, 59, 151, 220, 90, 53, 23, 131,
+	125, 173, 15, 238, 79, 95, 89, 16, 105, 137, 225, 224, 217, 160, 37, 123,
+	118, 73, 2, 157, 46, 116, 9, 145, 134, 228, 207, 212, 202, 215, 69, 229,
+	27, 188, 67, 124, 168, 252, 42, 4, 29, 108, 21, 247, 19, 205, 39, 203,
+	233, 40, 186, 147, 198, 192, 155, 33, 164, 191, 98, 204, 165, 180, 117, 76,
+	140, 36, 210, 172, 41, 54, 159, 8, 185, 232, 113, 196, 231, 47, 146, 120,
+	51, 65, 28, 144, 254, 221, 93, 189, 194, 139, 112, 43, 71, 109, 184, 209,
+}
+
+func tlshMapping(salt, i, j, k byte) byte {
+	h := tlshPearson[salt]
+	h = tlshPearson[h^i]
+	h = tlshPearson[h^j]
+	return tlshPearson[h^k]
+}
+
+// tlshState — потоковое вычисление TLSH (вариант T1: 128 корзин, 1 байт контрольной суммы).
+type tlshState struct {
+	buckets  [TLSH_BUCKETS]uint32
+	window   [TLSH_WINDOW]byte
+	checksum byte
+	length   uint64
+}
+
+var _ hash.Hash = (*tlshState)(nil)
+
+func newTLSH() *tlshState {
+	return &tlshState{}
+}
+
+// Write обновляет корзины по триплетам скользящего окна из 5 байт.
+func (t *tlshState) Write(p []byte) (int, error) {
+	for _, b := range p {
+		j := int(t.length % TLSH_WINDOW)
+		t.window[j] = b
+		if t.length >= TLSH_WINDOW-1 {
+			c0 := t.window[j]
+			c1 := t.window[(j+4)%TLSH_WINDOW]
+			c2 := t.window[(j+3)%TLSH_WINDOW]
+			c3 := t.window[(j+2)%TLSH_WINDOW]
+			c4 := t.window[(j+1)%TLSH_WINDOW]
+			t.checksum = tlshMapping(0, c0, c1, t.checksum)
+			t.buckets[tlshMapping(2, c0, c1, c2)]++
+			t.buckets[tlshMapping(3, c0, c1, c3)]++
+			t.buckets[tlshMapping(5, c0, c2, c3)]++
+			t.buckets[tlshMapping(7, c0, c2, c4)]++
+			t.buckets[tlshMapping(11, c0, c1, c4)]++
+			t.buckets[tlshMapping(13, c0, c3, c4)]++
+		}
+		t.length++
+	}
+	return len(p), nil
+}
+
+// Sum дописывает к b хеш "T1..." в шестнадцатеричном виде; для слишком коротких
+// или однородных данных хеш не определён и ничего не дописывается.
+func (t *tlshState) Sum(b []byte) []byte {
+	return append(b, t.digest()...)
+}
+
+func (t *tlshState) Reset()         { *t = tlshState{} }
+func (t *tlshState) Size() int      { return 2 + 2*(3+TLSH_CODE_SIZE) }
+func (t *tlshState) BlockSize() int { return 1 }
+
+func (t *tlshState) digest() string {
+	if t.length < TLSH_MIN_DATA_LENGTH || t.length > TLSH_MAX_DATA_LENGTH {
+		return ""
+	}
+	sorted := make([]uint32, TLSH_EFF_BUCKETS)
+	copy(sorted, t.buckets[:TLSH_EFF_BUCKETS])
+	nonzero := 0
+	for _, c := range sorted {
+		if c > 0 {
+			nonzero++
+		}
+	}
+	// Слишком мало различающихся триплетов — хеш не информативен
+	if nonzero <= 4*TLSH_CODE_SIZE/2 {
+		return ""
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	q1 := sorted[TLSH_EFF_BUCKETS/4-1]
+	q2 := sorted[TLSH_EFF_BUCKETS/2-1]
+	q3 := sorted[TLSH_EFF_BUCKETS-TLSH_EFF_BUCKETS/4-1]
+	if q3 == 0 {
+		return ""
+	}
+
+	var code [TLSH_CODE_SIZE]byte
+	for i := 0; i < TLSH_CODE_SIZE; i++ {
+		var h byte
+		for j := 0; j < 4; j++ {
+			k := t.buckets[4*i+j]
+			switch {
+			case q3 < k:
+				h += 3 << (j * 2)
+			case q2 < k:
+				h += 2 << (j * 2)
+			case q1 < k:
+				h += 1 << (j * 2)
+			}
+		}
+		code[i] = h
+	}
+
+	q1ratio := byte(uint64(q1)*100/uint64(q3)) % 16
+	q2ratio := byte(uint64(q2)*100/uint64(q3)) % 16
+	var sb strings.Builder
+	sb.WriteString("T1")
+	fmt.Fprintf(&sb, "%02X%02X%02X", swapNibbles(t.checksum), swapNibbles(tlshLength(t.length)), q1ratio<<4|q2ratio)
+	for i := TLSH_CODE_SIZE - 1; i >= 0; i-- {
+		fmt.Fprintf(&sb, "%02X", code[i])
+	}
+	return sb.String()
+}
+
+// tlshLength кодирует длину данных логарифмической шкалой в один байт.
+func tlshLength(n uint64) byte {
+	l := float64(n)
+	var v float64
+	switch {
+	case n <= 656:
+		v = math.Floor(math.Log(l) / math.Log(1.5))
+	case n <= 3199:
+		v = math.Floor(math.Log(l)/math.Log(1.3) - 8.72777)
+	default:
+		v = math.Floor(math.Log(l)/math.Log(1.1) - 62.5472)
+	}
+	return byte(int(v) & 0xff)
+}
+
+func swapNibbles(b byte) byte {
+	return b>>4 | b<<4
+}
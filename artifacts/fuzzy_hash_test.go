@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/glaslos/ssdeep"
+	"github.com/stretchr/testify/assert"
+)
+
+// fuzzyTestData возвращает детерминированный псевдотекст заданного размера.
+func fuzzyTestData(size int, seed int64) []byte {
+	words := []string{"alpha", "beta", "gamma", "delta", "kernel32", "LoadLibrary", "0x41", "\n", "payload", "config"}
+	r := rand.New(rand.NewSource(seed))
+	var sb strings.Builder
+	for sb.Len() < size {
+		sb.WriteString(words[r.Intn(len(words))])
+		sb.WriteByte(' ')
+	}
+	return []byte(sb.String()[:size])
+}
+
+func TestParseFuzzyHashes(t *testing.T) {
+	kinds, err := parseFuzzyHashes([]string{"SSDEEP", " tlsh", "ssdeep", ""})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FUZZY_SSDEEP, FUZZY_TLSH}, kinds)
+
+	_, err = parseFuzzyHashes([]string{"sdhash"})
+	assert.Error(t, err)
+}
+
+func TestTLSH(t *testing.T) {
+	tlshOf := func(data []byte) string {
+		h := newTLSH()
+		// Потоковая запись частями не должна влиять на результат
+		h.Write(data[:len(data)/3])
+		h.Write(data[len(data)/3:])
+		return string(h.Sum(nil))
+	}
+
+	assert.Empty(t, tlshOf([]byte("too short for tlsh")))
+	assert.Empty(t, tlshOf([]byte(strings.Repeat("A", 1000))), "однородные данные")
+
+	data := fuzzyTestData(20000, 1)
+	h1 := tlshOf(data)
+	assert.Len(t, h1, 72)
+	assert.True(t, strings.HasPrefix(h1, "T1"))
+	assert.Equal(t, h1, tlshOf(data))
+
+	// Небольшое изменение данных меняет лишь несколько позиций тела хеша
+	changed := append([]byte{}, data...)
+	copy(changed[5000:], "MODIFIED SECTION")
+	h2 := tlshOf(changed)
+	diff := 0
+	for i := 8; i < len(h1); i++ {
+		if h1[i] != h2[i] {
+			diff++
+		}
+	}
+	assert.Less(t, diff, 16, "%s\n%s", h1, h2)
+
+	other := tlshOf(fuzzyTestData(20000, 2))
+	assert.NotEqual(t, h1, other)
+}
+
+// TestFuzzyHashKnownAnswers сверяет хеши с результатами эталонных реализаций: TLSH — с выводом
+// утилиты tlsh Trend Micro (в версиях до 4.0 без префикса T1), ssdeep — с выводом утилиты ssdeep
+// для 4097 байт math/rand с начальным значением 1.
+func TestFuzzyHashKnownAnswers(t *testing.T) {
+	h := newTLSH()
+	h.Write([]byte(strings.Repeat("MIT License is so cool license that I can't imagine a better one!!\n", 4)))
+	assert.Equal(t, "T18ED02202FC30802303A002B03B33300FC30A82F83008C2FA000A0080B8BA0E02CCA0C3", string(h.Sum(nil)))
+
+	blob := make([]byte, 4097)
+	rand.New(rand.NewSource(1)).Read(blob)
+	s := newFuzzyHashers([]string{FUZZY_SSDEEP})[FUZZY_SSDEEP]
+	s.Write(blob)
+	assert.Equal(t, "96:yNDH/iNQaSXRLmOSxu1aQP4iWgC8JbkiA5Ix:yNLaNQhSxEgVYkiA5Ix", string(s.Sum(nil)))
+}
+
+func TestCollectFileInfoFuzzyHashes(t *testing.T) {
+	tempDir := t.TempDir()
+	large := filepath.Join(tempDir, "large.txt")
+	small := filepath.Join(tempDir, "small.txt")
+	data := fuzzyTestData(16384, 3)
+	assert.NoError(t, os.WriteFile(large, data, 0644))
+	assert.NoError(t, os.WriteFile(small, []byte("tiny"), 0644))
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Error(t, out.SetFuzzyHashes([]string{"unknown"}))
+	assert.NoError(t, out.SetFuzzyHashes([]string{"ssdeep", "tlsh"}))
+	fs := NewOSFileSystem("/")
+	for _, path := range []string{large, small} {
+		assert.NoError(t, out.AddCollectedFileInfo("TestArtifact", &FilePathObjectAdapter{fs.GetFullPath(path)}))
+	}
+	assert.NoError(t, out.Close())
+
+	hashes := map[string]map[string]string{}
+	assert.NoError(t, readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-file_info.jsonl", out.hostname)), func(line []byte) error {
+		var rec struct {
+			File struct {
+				Path string            `json:"path"`
+				Hash map[string]string `json:"hash"`
+			} `json:"file"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		hashes[rec.File.Path] = rec.File.Hash
+		return nil
+	}))
+
+	expected, err := ssdeep.FuzzyBytes(data)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, hashes[large]["ssdeep"])
+	assert.True(t, strings.HasPrefix(hashes[large]["tlsh"], "T1"))
+	assert.NotEmpty(t, hashes[large]["sha256"])
+
+	// Для коротких файлов нечёткие хеши не определены
+	assert.NotContains(t, hashes[small], "ssdeep")
+	assert.NotContains(t, hashes[small], "tlsh")
+	assert.NotEmpty(t, hashes[small]["md5"])
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Вердикты сопоставления с локальными наборами хешей.
+const (
+	HASHSET_KNOWN_GOOD = "known-good"
+	HASHSET_KNOWN_BAD  = "known-bad"
+	HASHSET_UNKNOWN    = "unknown"
+)
+
+// hashSetEntry — найденный в наборе хеш: индекс набора и необязательная метка (семейство, имя файла).
+type hashSetEntry struct {
+	set   int
+	label string
+}
+
+// hashSetSource — загруженный файл набора хешей.
+type hashSetSource struct {
+	name    string
+	verdict string
+}
+
+// HashSet хранит известные хорошие и плохие хеши из локальных файлов: NSRL RDS (NSRLFile.txt),
+// простых списков md5/sha1/sha256 и CSV с метками. Ключ — двоичное значение хеша,
+// длина которого (16, 20 или 32 байта) однозначно задаёт алгоритм.
+type HashSet struct {
+	mu      sync.RWMutex
+	sources []hashSetSource
+	good    map[string]hashSetEntry
+	bad     map[string]hashSetEntry
+	lengths map[int]bool
+}
+
+// NewHashSet создаёт пустой набор хешей.
+func NewHashSet() *HashSet {
+	return &HashSet{
+		good:    make(map[string]hashSetEntry),
+		bad:     make(map[string]hashSetEntry),
+		lengths: make(map[int]bool),
+	}
+}
+
+// HashSetMatch — результат сопоставления файла с наборами хешей.
+type HashSetMatch struct {
+	Verdict string `json:"verdict"`
+	Set     string `json:"set,omitempty"`
+	Label   string `json:"label,omitempty"`
+	Hash    string `json:"hash,omitempty"`
+}
+
+// AsDict возвращает результат в виде поля "hashset" записи file_info.
+func (m *HashSetMatch) AsDict() map[string]interface{} {
+	res := map[string]interface{}{"verdict": m.Verdict}
+	if m.Set != "" {
+		res["set"] = m.Set
+	}
+	if m.Label != "" {
+		res["label"] = m.Label
+	}
+	if m.Hash != "" {
+		res["hash"] = m.Hash
+	}
+	return res
+}
+
+// Len возвращает число загруженных хешей.
+func (hs *HashSet) Len() int {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	return len(hs.good) + len(hs.bad)
+}
+
+// Kinds возвращает алгоритмы хешей, встречающиеся в наборе.
+func (hs *HashSet) Kinds() []string {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	var kinds []string
+	for _, kind := range []string{"md5", "sha1", "sha256"} {
+		if hs.lengths[hashLength(kind)] {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// hashLength возвращает длину двоичного значения хеша указанного алгоритма.
+func hashLength(kind string) int {
+	switch kind {
+	case "md5":
+		return md5.Size
+	case "sha1":
+		return sha1.Size
+	case "sha256":
+		return sha256.Size
+	}
+	return 0
+}
+
+// decodeHash переводит шестнадцатеричный хеш MD5/SHA-1/SHA-256 в двоичный ключ.
+func decodeHash(s string) (string, bool) {
+	s = strings.TrimSpace(strings.Trim(strings.TrimSpace(s), `"`))
+	switch len(s) {
+	case 32, 40, 64:
+	default:
+		return "", false
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+// add добавляет хеш в набор с указанным вердиктом.
+func (hs *HashSet) add(set int, verdict, hexHash, label string) bool {
+	key, ok := decodeHash(hexHash)
+	if !ok {
+		return false
+	}
+	hs.lengths[len(key)] = true
+	// Метки хранятся только для плохих хешей: в NSRL это имена файлов для десятков
+	// миллионов записей, а для известных хороших файлов они не нужны при разборе.
+	if verdict == HASHSET_KNOWN_BAD {
+		hs.bad[key] = hashSetEntry{set: set, label: label}
+	} else {
+		hs.good[key] = hashSetEntry{set: set}
+	}
+	return true
+}
+
+// Lookup сопоставляет хеши файла (ключи md5, sha1, sha256) с набором.
+// Совпадение с известным плохим хешем имеет приоритет над известным хорошим.
+func (hs *HashSet) Lookup(hashes map[string]string) *HashSetMatch {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+	for _, m := range []struct {
+		entries map[string]hashSetEntry
+		verdict string
+	}{{hs.bad, HASHSET_KNOWN_BAD}, {hs.good, HASHSET_KNOWN_GOOD}} {
+		for _, kind := range []string{"sha256", "sha1", "md5"} {
+			key, ok := decodeHash(hashes[kind])
+			if !ok {
+				continue
+			}
+			if e, found := m.entries[key]; found {
+				return &HashSetMatch{
+					Verdict: m.verdict,
+					Set:     hs.sources[e.set].name,
+					Label:   e.label,
+					Hash:    kind,
+				}
+			}
+		}
+	}
+	return &HashSetMatch{Verdict: HASHSET_UNKNOWN}
+}
+
+// LoadFile загружает набор хешей из файла. verdict задаёт вердикт для записей без собственного
+// (HASHSET_KNOWN_GOOD или HASHSET_KNOWN_BAD). Формат определяется по содержимому:
+// NSRL RDS (заголовок "SHA-1","MD5",...), CSV с заголовком (колонки md5/sha1/sha256/hash,
+// label/name/family/description, verdict) или простой список хешей по одному на строку
+// (допускаются комментарии # и вывод md5sum/sha256sum).
+func (hs *HashSet) LoadFile(path, verdict string) (int, error) {
+	if verdict != HASHSET_KNOWN_GOOD && verdict != HASHSET_KNOWN_BAD {
+		return 0, fmt.Errorf("unsupported hash set verdict: %s", verdict)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	set := len(hs.sources)
+	hs.sources = append(hs.sources, hashSetSource{name: filepath.Base(path), verdict: verdict})
+
+	r := bufio.NewReaderSize(f, 1<<20)
+	first, err := r.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return 0, err
+	}
+	header := strings.ToLower(strings.SplitN(string(first), "\n", 2)[0])
+	if strings.Contains(header, ",") && csvHashColumn(splitCSVHeader(header)) >= 0 {
+		return hs.loadCSV(r, set, verdict)
+	}
+	return hs.loadList(r, set, verdict)
+}
+
+// loadList читает простой список: первый столбец строки — хеш, остаток — метка.
+func (hs *HashSet) loadList(r io.Reader, set int, verdict string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	count := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		fields := strings.Fields(strings.Replace(line, ",", " ", 1))
+		label := ""
+		if len(fields) > 1 {
+			label = strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		}
+		if hs.add(set, verdict, fields[0], label) {
+			count++
+		}
+	}
+	return count, scanner.Err()
+}
+
+func splitCSVHeader(header string) []string {
+	cols, err := csv.NewReader(strings.NewReader(header)).Read()
+	if err != nil {
+		return nil
+	}
+	for i := range cols {
+		cols[i] = strings.ToLower(strings.TrimSpace(cols[i]))
+	}
+	return cols
+}
+
+// csvHashColumn возвращает индекс первой колонки с хешем в заголовке CSV или -1.
+func csvHashColumn(cols []string) int {
+	for i, c := range cols {
+		switch c {
+		case "md5", "sha1", "sha-1", "sha256", "sha-256", "hash":
+			return i
+		}
+	}
+	return -1
+}
+
+// hashSetVerdict приводит значение колонки verdict CSV к вердикту набора.
+func hashSetVerdict(value, def string) string {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "bad", HASHSET_KNOWN_BAD, "malicious", "malware", "suspicious":
+		return HASHSET_KNOWN_BAD
+	case "good", HASHSET_KNOWN_GOOD, "clean", "benign", "trusted":
+		return HASHSET_KNOWN_GOOD
+	}
+	return def
+}
+
+// loadCSV читает CSV с заголовком, в том числе NSRLFile.txt (колонки "SHA-1","MD5",...,"FileName").
+func (hs *HashSet) loadCSV(r io.Reader, set int, verdict string) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	cr.ReuseRecord = true
+	cr.Comment = '#'
+
+	header, err := cr.Read()
+	if err != nil {
+		return 0, err
+	}
+	var hashCols []int
+	labelCol, verdictCol := -1, -1
+	for i, c := range header {
+		switch strings.ToLower(strings.TrimSpace(c)) {
+		case "md5", "sha1", "sha-1", "sha256", "sha-256", "hash":
+			hashCols = append(hashCols, i)
+		case "label", "family", "name", "description", "comment", "filename":
+			if labelCol < 0 {
+				labelCol = i
+			}
+		case "verdict", "status", "type":
+			verdictCol = i
+		}
+	}
+
+	count := 0
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				continue
+			}
+			return count, err
+		}
+		label := ""
+		if labelCol >= 0 && labelCol < len(rec) {
+			label = rec[labelCol]
+		}
+		v := verdict
+		if verdictCol >= 0 && verdictCol < len(rec) {
+			v = hashSetVerdict(rec[verdictCol], verdict)
+		}
+		added := false
+		for _, col := range hashCols {
+			if col < len(rec) && hs.add(set, v, rec[col], label) {
+				added = true
+			}
+		}
+		if added {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// hashChunks вычисляет хеши содержимого файла для указанных алгоритмов.
+func hashChunks(chunks [][]byte, kinds []string) map[string]string {
+	hashers := make(map[string]hash.Hash, len(kinds))
+	for _, kind := range kinds {
+		switch kind {
+		case "md5":
+			hashers[kind] = md5.New()
+		case "sha1":
+			hashers[kind] = sha1.New()
+		case "sha256":
+			hashers[kind] = sha256.New()
+		}
+	}
+	for _, c := range chunks {
+		for _, h := range hashers {
+			h.Write(c)
+		}
+	}
+	res := make(map[string]string, len(hashers))
+	for kind, h := range hashers {
+		res[kind] = hex.EncodeToString(h.Sum(nil))
+	}
+	return res
+}
+
+// hashSetProvider представляет локальные наборы хешей как источник анализа: известные плохие
+// файлы получают вердикт malicious с меткой набора, известные хорошие — clean.
+type hashSetProvider struct {
+	hs *HashSet
+}
+
+// NewHashSetProvider создаёт источник анализа по локальным наборам хешей.
+func NewHashSetProvider(hs *HashSet) Provider {
+	return &hashSetProvider{hs: hs}
+}
+
+func (p *hashSetProvider) Name() string {
+	return "hashset"
+}
+
+func (p *hashSetProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	match := p.hs.Lookup(hashes)
+	res := &ProviderResult{Verdict: VERDICT_UNKNOWN}
+	switch match.Verdict {
+	case HASHSET_KNOWN_BAD:
+		res.Verdict = VERDICT_MALICIOUS
+		res.DetectionName = match.Label
+		if res.DetectionName == "" {
+			res.DetectionName = match.Set
+		}
+	case HASHSET_KNOWN_GOOD:
+		res.Verdict = VERDICT_CLEAN
+	}
+	return res, nil
+}
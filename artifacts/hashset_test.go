@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeHashSetFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestHashSetNSRL(t *testing.T) {
+	path := writeHashSetFile(t, "NSRLFile.txt",
+		`"SHA-1","MD5","CRC32","FileName","FileSize","ProductCode","OpSystemCode","SpecialCode"`+"\r\n"+
+			`"000000206738748EDD92C4E3D2E823896700F849","392126E756571EBF112CB1C1CDEDF926","EBD105A0","I05002T2.PFB",98865,3095,"WIN",""`+"\r\n")
+
+	hs := NewHashSet()
+	n, err := hs.LoadFile(path, HASHSET_KNOWN_GOOD)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, 2, hs.Len())
+	assert.Equal(t, []string{"md5", "sha1"}, hs.Kinds())
+
+	match := hs.Lookup(map[string]string{"md5": "392126e756571ebf112cb1c1cdedf926"})
+	assert.Equal(t, HASHSET_KNOWN_GOOD, match.Verdict)
+	assert.Equal(t, "NSRLFile.txt", match.Set)
+	assert.Equal(t, "md5", match.Hash)
+
+	assert.Equal(t, HASHSET_UNKNOWN, hs.Lookup(map[string]string{"md5": "00000000000000000000000000000000"}).Verdict)
+}
+
+func TestHashSetListAndCSV(t *testing.T) {
+	list := writeHashSetFile(t, "bad.txt", "# IOC list\n"+
+		"275a021bbfb6489e54d471899f7db9d1663fc695ec2fe2a2c4538aabf651fd0f  eicar.com\n"+
+		"not-a-hash\n")
+	labeled := writeHashSetFile(t, "labels.csv", "md5,family,verdict\n"+
+		"44d88612fea8a8f36de82e1278abb02f,EICAR,malicious\n"+
+		"d41d8cd98f00b204e9800998ecf8427e,empty file,benign\n")
+
+	hs := NewHashSet()
+	n, err := hs.LoadFile(list, HASHSET_KNOWN_BAD)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = hs.LoadFile(labeled, HASHSET_KNOWN_GOOD)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	match := hs.Lookup(map[string]string{"sha256": "275A021BBFB6489E54D471899F7DB9D1663FC695EC2FE2A2C4538AABF651FD0F"})
+	assert.Equal(t, &HashSetMatch{Verdict: HASHSET_KNOWN_BAD, Set: "bad.txt", Label: "eicar.com", Hash: "sha256"}, match)
+
+	match = hs.Lookup(map[string]string{"md5": "44d88612fea8a8f36de82e1278abb02f"})
+	assert.Equal(t, HASHSET_KNOWN_BAD, match.Verdict)
+	assert.Equal(t, "EICAR", match.Label)
+
+	assert.Equal(t, HASHSET_KNOWN_GOOD, hs.Lookup(map[string]string{"md5": "d41d8cd98f00b204e9800998ecf8427e"}).Verdict)
+
+	_, err = hs.LoadFile(list, "trusted")
+	assert.Error(t, err)
+}
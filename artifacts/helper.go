@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math"
 	"runtime"
 )
 
@@ -17,3 +18,25 @@ func getOperatingSystem() (string, error) {
 		return "", fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 }
+
+// shannonEntropy вычисляет энтропию Шеннона данных в битах на байт (от 0 до 8),
+// округлённую до сотых; высокие значения характерны для сжатых и зашифрованных секций.
+func shannonEntropy(data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+	entropy := 0.0
+	n := float64(len(data))
+	for _, c := range counts {
+		if c == 0 {
+			continue
+		}
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return math.Round(entropy*100) / 100
+}
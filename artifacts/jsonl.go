@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// jsonlWriter построчно дописывает JSON-записи в файл. Файл создаётся при первой записи,
+// каждая запись сразу передаётся ОС, чтобы аварийное завершение не приводило к потере данных.
+type jsonlWriter struct {
+	path  string
+	mu    sync.Mutex
+	file  *os.File
+	count int
+}
+
+func newJSONLWriter(path string) *jsonlWriter {
+	return &jsonlWriter{path: path}
+}
+
+// Write сериализует запись и дописывает её в файл отдельной строкой.
+func (w *jsonlWriter) Write(record interface{}) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w.file = f
+	}
+	if _, err := w.file.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	w.count++
+	return nil
+}
+
+// Count возвращает число записанных записей.
+func (w *jsonlWriter) Count() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.count
+}
+
+// Close закрывает файл, если он был создан.
+func (w *jsonlWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// readJSONL последовательно передаёт каждую строку JSONL-файла в callback.
+// Отсутствующий файл не считается ошибкой.
+func readJSONL(path string, callback func(line []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 256*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		if err := callback(line); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// streamRecord — запись потоковых результатов (команды, WMI, реестр):
+// артефакт, источник (команда, запрос или ключ), время получения и полезная нагрузка.
+type streamRecord struct {
+	Timestamp string          `json:"@timestamp"`
+	Artifact  string          `json:"artifact"`
+	Source    string          `json:"source"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// newStreamRecord формирует запись с текущим временем.
+func newStreamRecord(artifact, source string, payload interface{}) (*streamRecord, error) {
+	raw, ok := payload.(json.RawMessage)
+	if !ok {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		raw = b
+	}
+	return &streamRecord{
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Artifact:  artifact,
+		Source:    source,
+		Payload:   raw,
+	}, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Списки переходов Windows 7+ в %APPDATA%\Microsoft\Windows\Recent:
+//   - *.automaticDestinations-ms — составной файл OLE: поток DestList со сведениями
+//     об элементах и потоки с шестнадцатеричными номерами элементов, содержащие ярлыки;
+//   - *.customDestinations-ms — последовательность ярлыков в категориях, заданных программой.
+//
+// Имя файла до точки — AppID программы.
+const (
+	JUMPLIST_MAX_SIZE            = 64 << 20
+	JUMPLIST_DESTLIST_HEADER     = 32
+	JUMPLIST_AUTOMATIC_SUFFIX    = ".automaticdestinations-ms"
+	JUMPLIST_CUSTOM_SUFFIX       = ".customdestinations-ms"
+	JUMPLIST_TYPE_AUTOMATIC      = "automatic"
+	JUMPLIST_TYPE_CUSTOM         = "custom"
+	JUMPLIST_DESTLIST_WIN7       = 1
+	JUMPLIST_DESTLIST_NOT_PINNED = -1
+)
+
+// DestListEntry — элемент потока DestList.
+type DestListEntry struct {
+	EntryID          uint32
+	Path             string
+	Hostname         string
+	LastAccess       time.Time
+	AccessCount      uint32
+	Pinned           bool
+	VolumeDroid      string
+	FileDroid        string
+	BirthVolumeDroid string
+	BirthFileDroid   string
+	MACAddress       string
+}
+
+// ParseDestList разбирает поток DestList: версия 1 (Windows 7/8) или 3 и 4 (Windows 10/11).
+func ParseDestList(data []byte) ([]DestListEntry, error) {
+	if len(data) < JUMPLIST_DESTLIST_HEADER {
+		return nil, fmt.Errorf("DestList header truncated")
+	}
+	le := binary.LittleEndian
+	version := le.Uint32(data)
+	count := int(le.Uint32(data[4:]))
+	var entries []DestListEntry
+	pos := JUMPLIST_DESTLIST_HEADER
+	for i := 0; i < count; i++ {
+		pathOff := 124
+		if version == JUMPLIST_DESTLIST_WIN7 {
+			pathOff = 108
+		}
+		if pos+pathOff+2 > len(data) {
+			return entries, fmt.Errorf("DestList entry %d truncated", i)
+		}
+		e := data[pos:]
+		n := int(le.Uint16(e[pathOff:]))
+		if pos+pathOff+2+n*2 > len(data) {
+			return entries, fmt.Errorf("DestList entry %d path truncated", i)
+		}
+		entry := DestListEntry{
+			VolumeDroid:      formatGUID(e[8:24]),
+			FileDroid:        formatGUID(e[24:40]),
+			BirthVolumeDroid: formatGUID(e[40:56]),
+			BirthFileDroid:   formatGUID(e[56:72]),
+			MACAddress:       droidMACAddress(e[24:40]),
+			Hostname:         cString(e[72:88]),
+			EntryID:          le.Uint32(e[88:]),
+			LastAccess:       filetimeToTime(le.Uint64(e[96:])),
+			Pinned:           int32(le.Uint32(e[104:])) != JUMPLIST_DESTLIST_NOT_PINNED,
+			Path:             decodeUTF16Full(e[pathOff+2 : pathOff+2+n*2]),
+		}
+		if version == JUMPLIST_DESTLIST_WIN7 {
+			// В Windows 7 счётчик обращений хранится числом с плавающей точкой
+			entry.AccessCount = uint32(math.Float32frombits(le.Uint32(e[92:])))
+			pos += pathOff + 2 + n*2
+		} else {
+			entry.AccessCount = le.Uint32(e[112:])
+			pos += pathOff + 2 + n*2 + 4
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// jumpListAppID возвращает AppID программы из имени файла списка переходов.
+func jumpListAppID(path string) string {
+	name := filepath.Base(strings.ReplaceAll(path, `\`, "/"))
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// parseAutomaticDestinations разбирает составной файл: для каждого элемента DestList
+// к записи добавляется ярлык из потока с его номером. Без DestList записываются ярлыки
+// всех потоков.
+func parseAutomaticDestinations(path string, data []byte, emit func(record map[string]interface{}) error) error {
+	cf, err := ParseCompoundFile(data)
+	if err != nil {
+		return err
+	}
+	appID := jumpListAppID(path)
+	record := func(jumplist map[string]interface{}, stream string) map[string]interface{} {
+		jumplist["type"] = JUMPLIST_TYPE_AUTOMATIC
+		jumplist["app_id"] = appID
+		rec := map[string]interface{}{
+			"file":     map[string]interface{}{"path": path},
+			"jumplist": jumplist,
+		}
+		if raw, err := cf.Stream(stream); err == nil {
+			if link, _ := ParseShellLink(raw); link != nil {
+				rec["lnk"] = link.AsDict()
+			}
+		}
+		return rec
+	}
+
+	destList, err := cf.Stream("DestList")
+	if err != nil {
+		for _, name := range cf.Streams() {
+			if _, perr := strconv.ParseUint(name, 16, 32); perr != nil {
+				continue
+			}
+			if e := emit(record(map[string]interface{}{"stream": name}, name)); e != nil {
+				return e
+			}
+		}
+		return err
+	}
+	entries, err := ParseDestList(destList)
+	for _, e := range entries {
+		stream := strconv.FormatUint(uint64(e.EntryID), 16)
+		jumplist := map[string]interface{}{
+			"stream":       stream,
+			"entry_id":     e.EntryID,
+			"path":         e.Path,
+			"hostname":     e.Hostname,
+			"last_access":  formatTime(e.LastAccess),
+			"access_count": e.AccessCount,
+			"pinned":       e.Pinned,
+			"volume_droid": e.VolumeDroid,
+			"file_droid":   e.FileDroid,
+		}
+		for key, value := range map[string]string{
+			"birth_volume_droid": e.BirthVolumeDroid,
+			"birth_file_droid":   e.BirthFileDroid,
+			"mac_address":        e.MACAddress,
+		} {
+			if value != "" {
+				jumplist[key] = value
+			}
+		}
+		rec := record(jumplist, stream)
+		if !e.LastAccess.IsZero() {
+			rec["@timestamp"] = formatTime(e.LastAccess)
+		}
+		if err := emit(rec); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// parseCustomDestinations находит ярлыки в файле customDestinations-ms по сигнатуре
+// заголовка ShellLink и записывает каждый отдельной записью.
+func parseCustomDestinations(path string, data []byte, emit func(record map[string]interface{}) error) error {
+	appID := jumpListAppID(path)
+	index := 0
+	for pos := 0; pos < len(data); {
+		next := bytes.Index(data[pos:], lnkHeader)
+		if next < 0 {
+			break
+		}
+		pos += next
+		link, err := ParseShellLink(data[pos:])
+		if link == nil || err != nil {
+			pos += len(lnkHeader)
+			continue
+		}
+		index++
+		rec := map[string]interface{}{
+			"file":     map[string]interface{}{"path": path},
+			"jumplist": map[string]interface{}{"type": JUMPLIST_TYPE_CUSTOM, "app_id": appID, "entry_id": index},
+			"lnk":      link.AsDict(),
+		}
+		if err := emit(rec); err != nil {
+			return err
+		}
+		pos += link.Size
+	}
+	if index == 0 && len(data) > 0 {
+		return fmt.Errorf("no shell links found in custom destinations")
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// jumpListParser – разбор списков переходов как ArtifactParser
+// ----------------------------------------------------------------------
+
+type jumpListParser struct{}
+
+func newJumpListParser(opts parserOptions) (ArtifactParser, error) {
+	return &jumpListParser{}, nil
+}
+
+func (p *jumpListParser) Name() string { return PARSER_JUMPLIST }
+
+func (p *jumpListParser) Match(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasSuffix(lower, JUMPLIST_AUTOMATIC_SUFFIX) || strings.HasSuffix(lower, JUMPLIST_CUSTOM_SUFFIX)
+}
+
+func (p *jumpListParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, JUMPLIST_MAX_SIZE+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > JUMPLIST_MAX_SIZE {
+		return fmt.Errorf("jump list larger than %d bytes", JUMPLIST_MAX_SIZE)
+	}
+	if strings.HasSuffix(strings.ToLower(path), JUMPLIST_CUSTOM_SUFFIX) {
+		return parseCustomDestinations(path, data, emit)
+	}
+	return parseAutomaticDestinations(path, data, emit)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testStream struct {
+	name string
+	data []byte
+}
+
+// buildTestCompoundFile собирает составной файл версии 3 (сектора по 512 байт): потоки
+// меньше 4096 байт размещаются в мини-потоке, остальные — в обычных секторах.
+func buildTestCompoundFile(streams []testStream) []byte {
+	const sectorSize, miniSize, endOfChain, free = 512, 64, 0xFFFFFFFE, 0xFFFFFFFF
+	le := binary.LittleEndian
+	pad := func(b []byte, n int) []byte {
+		if r := len(b) % n; r != 0 {
+			b = append(b, make([]byte, n-r)...)
+		}
+		return b
+	}
+
+	var mini, big []byte
+	var miniFAT []uint32
+	starts := make([]uint32, len(streams))
+	bigStarts := make([]int, len(streams))
+	for i, s := range streams {
+		if len(s.data) < 4096 {
+			starts[i] = uint32(len(mini) / miniSize)
+			n := (len(s.data) + miniSize - 1) / miniSize
+			for j := 0; j < n; j++ {
+				next := uint32(len(miniFAT) + 1)
+				if j == n-1 {
+					next = endOfChain
+				}
+				miniFAT = append(miniFAT, next)
+			}
+			mini = append(mini, pad(append([]byte(nil), s.data...), miniSize)...)
+		} else {
+			bigStarts[i] = len(big) / sectorSize
+			big = append(big, pad(append([]byte(nil), s.data...), sectorSize)...)
+		}
+	}
+
+	entry := func(name string, typ byte, start uint32, size int) []byte {
+		e := make([]byte, CFB_DIR_ENTRY_SIZE)
+		copy(e, testUTF16(name))
+		le.PutUint16(e[64:], uint16((len([]rune(name))+1)*2))
+		e[66], e[67] = typ, 1
+		le.PutUint32(e[68:], free)
+		le.PutUint32(e[72:], free)
+		le.PutUint32(e[76:], free)
+		le.PutUint32(e[116:], start)
+		le.PutUint64(e[120:], uint64(size))
+		return e
+	}
+
+	// Сектора: 0 — FAT, далее каталог, MiniFAT, мини-поток и большие потоки
+	dirSectors := (len(streams) + 1 + 3) / 4
+	miniFATBytes := pad(make([]byte, len(miniFAT)*4), sectorSize)
+	for i, v := range miniFAT {
+		le.PutUint32(miniFATBytes[i*4:], v)
+	}
+	miniFATSectors := len(miniFATBytes) / sectorSize
+	mini = pad(mini, sectorSize)
+	miniSectors := len(mini) / sectorSize
+	firstMiniFAT := 1 + dirSectors
+	firstMini := firstMiniFAT + miniFATSectors
+	firstBig := firstMini + miniSectors
+
+	var dir []byte
+	rootStart := uint32(endOfChain)
+	if miniSectors > 0 {
+		rootStart = uint32(firstMini)
+	}
+	dir = append(dir, entry("Root Entry", CFB_TYPE_ROOT, rootStart, len(mini))...)
+	for i, s := range streams {
+		start := starts[i]
+		if len(s.data) >= 4096 {
+			start = uint32(firstBig + bigStarts[i])
+		}
+		dir = append(dir, entry(s.name, CFB_TYPE_STREAM, start, len(s.data))...)
+	}
+	dir = pad(dir, sectorSize)
+
+	fat := make([]uint32, sectorSize/4)
+	for i := range fat {
+		fat[i] = free
+	}
+	fat[0] = 0xFFFFFFFD
+	chain := func(first, n int) {
+		for j := 0; j < n; j++ {
+			fat[first+j] = uint32(first + j + 1)
+		}
+		if n > 0 {
+			fat[first+n-1] = endOfChain
+		}
+	}
+	chain(1, dirSectors)
+	chain(firstMiniFAT, miniFATSectors)
+	chain(firstMini, miniSectors)
+	for i, s := range streams {
+		if len(s.data) >= 4096 {
+			chain(firstBig+bigStarts[i], (len(s.data)+sectorSize-1)/sectorSize)
+		}
+	}
+
+	header := make([]byte, CFB_HEADER_SIZE)
+	copy(header, cfbSignature)
+	le.PutUint16(header[24:], 0x3E)
+	le.PutUint16(header[26:], 3)
+	le.PutUint16(header[28:], 0xFFFE)
+	le.PutUint16(header[30:], 9)
+	le.PutUint16(header[32:], 6)
+	le.PutUint32(header[44:], 1)
+	le.PutUint32(header[48:], 1)
+	le.PutUint32(header[56:], 4096)
+	if miniFATSectors > 0 {
+		le.PutUint32(header[60:], uint32(firstMiniFAT))
+	} else {
+		le.PutUint32(header[60:], endOfChain)
+	}
+	le.PutUint32(header[64:], uint32(miniFATSectors))
+	le.PutUint32(header[68:], endOfChain)
+	for i := 0; i < CFB_HEADER_DIFAT; i++ {
+		le.PutUint32(header[76+i*4:], free)
+	}
+	le.PutUint32(header[76:], 0)
+
+	fatBytes := make([]byte, sectorSize)
+	for i, v := range fat {
+		le.PutUint32(fatBytes[i*4:], v)
+	}
+	return bytes.Join([][]byte{header, fatBytes, dir, miniFATBytes, mini, big}, nil)
+}
+
+type testDestEntry struct {
+	id       uint32
+	path     string
+	access   time.Time
+	count    uint32
+	pinned   bool
+	hostname string
+}
+
+// buildTestDestList собирает поток DestList версии 1 (Windows 7) или 4 (Windows 10).
+func buildTestDestList(version uint32, entries []testDestEntry) []byte {
+	le := binary.LittleEndian
+	data := make([]byte, JUMPLIST_DESTLIST_HEADER)
+	le.PutUint32(data, version)
+	le.PutUint32(data[4:], uint32(len(entries)))
+	for _, e := range entries {
+		pathOff := 124
+		if version == JUMPLIST_DESTLIST_WIN7 {
+			pathOff = 108
+		}
+		entry := make([]byte, pathOff)
+		copy(entry[8:], bytes.Repeat([]byte{0x22}, 16))
+		copy(entry[24:], testFileDroid)
+		copy(entry[40:], bytes.Repeat([]byte{0x22}, 16))
+		copy(entry[56:], testFileDroid)
+		copy(entry[72:], e.hostname)
+		le.PutUint32(entry[88:], e.id)
+		le.PutUint64(entry[96:], timeToFiletime(e.access))
+		pin := uint32(0xFFFFFFFF)
+		if e.pinned {
+			pin = 0
+		}
+		le.PutUint32(entry[104:], pin)
+		if version == JUMPLIST_DESTLIST_WIN7 {
+			le.PutUint32(entry[92:], math.Float32bits(float32(e.count)))
+		} else {
+			le.PutUint32(entry[108:], 0xFFFFFFFF)
+			le.PutUint32(entry[112:], e.count)
+		}
+		entry = le.AppendUint16(entry, uint16(len([]rune(e.path))))
+		entry = append(entry, testUTF16(e.path)...)
+		if version != JUMPLIST_DESTLIST_WIN7 {
+			entry = append(entry, 0, 0, 0, 0)
+		}
+		data = append(data, entry...)
+	}
+	return data
+}
+
+func collectJumpList(t *testing.T, path string, data []byte) ([]map[string]interface{}, error) {
+	p, err := newJumpListParser(parserOptions{})
+	assert.NoError(t, err)
+	assert.True(t, p.Match(path))
+	var records []map[string]interface{}
+	err = p.Parse(path, bytes.NewReader(data), func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func TestCompoundFile(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 400)
+	data := buildTestCompoundFile([]testStream{{"small", []byte("hello")}, {"large", large}, {"empty", nil}})
+	cf, err := ParseCompoundFile(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"small", "large", "empty"}, cf.Streams())
+	got, err := cf.Stream("small")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hello"), got)
+	got, err = cf.Stream("large")
+	assert.NoError(t, err)
+	assert.Equal(t, large, got)
+	got, err = cf.Stream("empty")
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+	_, err = cf.Stream("missing")
+	assert.Error(t, err)
+
+	_, err = ParseCompoundFile([]byte("PK\x03\x04 not a compound file"))
+	assert.Error(t, err)
+
+	// Сектор DIFAT, ссылающийся на себя, с огромным числом секторов DIFAT в заголовке
+	le := binary.LittleEndian
+	last := uint32(len(data)/512 - 2)
+	sector := data[(last+1)*512 : (last+2)*512]
+	for i := 0; i < 127; i++ {
+		le.PutUint32(sector[i*4:], 0)
+	}
+	le.PutUint32(sector[127*4:], last)
+	le.PutUint32(data[68:], last)
+	le.PutUint32(data[72:], 0xFFFFFFF0)
+	cf, err = ParseCompoundFile(data)
+	if assert.NoError(t, err) {
+		assert.Len(t, cf.fat, 128)
+	}
+}
+
+func TestAutomaticDestinations(t *testing.T) {
+	t0 := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	lnk := buildTestLNK(`C:\Users\alice\Documents\`, "", "plan.docx", t0)
+	entries := []testDestEntry{
+		{id: 1, path: `C:\Users\alice\Documents\plan.docx`, access: t0, count: 7, pinned: true, hostname: "ws01"},
+		{id: 0x1a, path: `\\fileserver\finance\q1.xlsx`, access: t0.Add(time.Hour), count: 2, hostname: "ws01"},
+	}
+	path := `C:\Users\alice\AppData\Roaming\Microsoft\Windows\Recent\AutomaticDestinations\5f7b5f1e01b83767.automaticDestinations-ms`
+
+	for _, version := range []uint32{JUMPLIST_DESTLIST_WIN7, 4} {
+		data := buildTestCompoundFile([]testStream{
+			{"1", lnk},
+			{"1a", buildTestLNK("", `\\fileserver\finance`, "q1.xlsx", t0)},
+			{"DestList", buildTestDestList(version, entries)},
+		})
+		records, err := collectJumpList(t, path, data)
+		assert.NoError(t, err)
+		if !assert.Len(t, records, 2) {
+			continue
+		}
+		jl := records[0]["jumplist"].(map[string]interface{})
+		assert.Equal(t, "automatic", jl["type"])
+		assert.Equal(t, "5f7b5f1e01b83767", jl["app_id"])
+		assert.Equal(t, uint32(1), jl["entry_id"])
+		assert.Equal(t, entries[0].path, jl["path"])
+		assert.Equal(t, "ws01", jl["hostname"])
+		assert.Equal(t, uint32(7), jl["access_count"])
+		assert.Equal(t, true, jl["pinned"])
+		assert.Equal(t, "00:0c:29:aa:bb:cc", jl["mac_address"])
+		assert.Equal(t, "2024-03-04T05:06:07Z", records[0]["@timestamp"])
+		assert.Equal(t, `C:\Users\alice\Documents\plan.docx`, records[0]["lnk"].(map[string]interface{})["target_path"])
+
+		jl = records[1]["jumplist"].(map[string]interface{})
+		assert.Equal(t, "1a", jl["stream"])
+		assert.Equal(t, false, jl["pinned"])
+		assert.Equal(t, `\\fileserver\finance\q1.xlsx`, records[1]["lnk"].(map[string]interface{})["target_path"])
+	}
+
+	// Без DestList записываются ярлыки нумерованных потоков
+	records, err := collectJumpList(t, path, buildTestCompoundFile([]testStream{{"1", lnk}}))
+	assert.Error(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Contains(t, records[0], "lnk")
+	}
+}
+
+func TestCustomDestinations(t *testing.T) {
+	t0 := time.Date(2024, 3, 4, 5, 6, 7, 0, time.UTC)
+	le := binary.LittleEndian
+	data := le.AppendUint32(nil, 2)
+	data = le.AppendUint32(data, 1)
+	data = le.AppendUint32(data, 0)
+	data = le.AppendUint32(data, 2)
+	data = le.AppendUint32(data, 2)
+	for _, target := range []string{"first.txt", "second.txt"} {
+		data = append(data, lnkHeader[4:]...)
+		data = append(data, buildTestLNK(`C:\Temp\`, "", target, t0)...)
+	}
+	data = le.AppendUint32(data, 0xBABFFBAB)
+
+	path := `C:\Users\alice\AppData\Roaming\Microsoft\Windows\Recent\CustomDestinations\9b9cdc69c1c24e2b.customDestinations-ms`
+	records, err := collectJumpList(t, path, data)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, map[string]interface{}{"type": "custom", "app_id": "9b9cdc69c1c24e2b", "entry_id": 1}, records[0]["jumplist"])
+		assert.Equal(t, `C:\Temp\second.txt`, records[1]["lnk"].(map[string]interface{})["target_path"])
+	}
+
+	_, err = collectJumpList(t, path, []byte("garbage"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Файлы ярлыков Windows ([MS-SHLLINK]): заголовок ShellLinkHeader, необязательный список
+// идентификаторов цели, LinkInfo с томом и путём, строки StringData и блоки ExtraData
+// (из них используется TrackerDataBlock с идентификатором компьютера и droid-идентификаторами).
+const (
+	LNK_HEADER_SIZE = 0x4C
+	LNK_MAX_SIZE    = 16 << 20
+
+	LNK_HAS_TARGET_ID_LIST = 0x00000001
+	LNK_HAS_LINK_INFO      = 0x00000002
+	LNK_HAS_NAME           = 0x00000004
+	LNK_HAS_RELATIVE_PATH  = 0x00000008
+	LNK_HAS_WORKING_DIR    = 0x00000010
+	LNK_HAS_ARGUMENTS      = 0x00000020
+	LNK_HAS_ICON_LOCATION  = 0x00000040
+	LNK_IS_UNICODE         = 0x00000080
+
+	LNK_VOLUME_ID_AND_LOCAL_BASE_PATH = 0x1
+	LNK_COMMON_NETWORK_RELATIVE_LINK  = 0x2
+
+	LNK_TRACKER_SIGNATURE = 0xA0000003
+	LNK_TRACKER_SIZE      = 0x60
+)
+
+// lnkHeader — сигнатура ярлыка: размер заголовка и CLSID {00021401-0000-0000-C000-000000000046}.
+var lnkHeader = []byte{0x4C, 0, 0, 0, 0x01, 0x14, 0x02, 0, 0, 0, 0, 0, 0xC0, 0, 0, 0, 0, 0, 0, 0x46}
+
+var lnkDriveTypes = map[uint32]string{
+	0: "unknown", 1: "no_root_dir", 2: "removable", 3: "fixed", 4: "remote", 5: "cdrom", 6: "ramdisk",
+}
+
+// ShellLink — разобранный ярлык.
+type ShellLink struct {
+	Flags      uint32
+	Attributes uint32
+	Created    time.Time
+	Accessed   time.Time
+	Modified   time.Time
+	FileSize   uint32
+
+	TargetPath   string
+	LocalPath    string
+	NetworkShare string
+	DriveType    string
+	VolumeSerial string
+	VolumeLabel  string
+
+	Name         string
+	RelativePath string
+	WorkingDir   string
+	Arguments    string
+	IconLocation string
+
+	MachineID        string
+	VolumeDroid      string
+	FileDroid        string
+	BirthVolumeDroid string
+	BirthFileDroid   string
+	MACAddress       string
+
+	// Size — число байт, занятых ярлыком (вместе с завершающим блоком ExtraData).
+	Size int
+}
+
+// ParseShellLink разбирает ярлык в начале data.
+func ParseShellLink(data []byte) (*ShellLink, error) {
+	if len(data) < LNK_HEADER_SIZE || !bytes.Equal(data[:len(lnkHeader)], lnkHeader) {
+		return nil, fmt.Errorf("not a shell link: bad header")
+	}
+	le := binary.LittleEndian
+	l := &ShellLink{
+		Flags:      le.Uint32(data[20:]),
+		Attributes: le.Uint32(data[24:]),
+		Created:    filetimeToTime(le.Uint64(data[28:])),
+		Accessed:   filetimeToTime(le.Uint64(data[36:])),
+		Modified:   filetimeToTime(le.Uint64(data[44:])),
+		FileSize:   le.Uint32(data[52:]),
+	}
+	pos := LNK_HEADER_SIZE
+	if l.Flags&LNK_HAS_TARGET_ID_LIST != 0 {
+		if pos+2 > len(data) {
+			return l, fmt.Errorf("shell link truncated in target ID list")
+		}
+		pos += 2 + int(le.Uint16(data[pos:]))
+	}
+	if l.Flags&LNK_HAS_LINK_INFO != 0 {
+		if pos+4 > len(data) {
+			return l, fmt.Errorf("shell link truncated in link info")
+		}
+		size := int(le.Uint32(data[pos:]))
+		if size < 0x1C || pos+size > len(data) {
+			return l, fmt.Errorf("shell link info size %d out of file", size)
+		}
+		l.parseLinkInfo(data[pos : pos+size])
+		pos += size
+	}
+
+	unicode := l.Flags&LNK_IS_UNICODE != 0
+	for _, field := range []struct {
+		flag uint32
+		dst  *string
+	}{
+		{LNK_HAS_NAME, &l.Name},
+		{LNK_HAS_RELATIVE_PATH, &l.RelativePath},
+		{LNK_HAS_WORKING_DIR, &l.WorkingDir},
+		{LNK_HAS_ARGUMENTS, &l.Arguments},
+		{LNK_HAS_ICON_LOCATION, &l.IconLocation},
+	} {
+		if l.Flags&field.flag == 0 {
+			continue
+		}
+		if pos+2 > len(data) {
+			return l, fmt.Errorf("shell link truncated in string data")
+		}
+		n := int(le.Uint16(data[pos:]))
+		pos += 2
+		if unicode {
+			n *= 2
+		}
+		if pos+n > len(data) {
+			return l, fmt.Errorf("shell link truncated in string data")
+		}
+		if unicode {
+			*field.dst = decodeUTF16Full(data[pos : pos+n])
+		} else {
+			*field.dst = string(data[pos : pos+n])
+		}
+		pos += n
+	}
+
+	// Блоки ExtraData до завершающего блока размером меньше 4 байт
+	for pos+4 <= len(data) {
+		size := int(le.Uint32(data[pos:]))
+		if size < 4 {
+			pos += 4
+			break
+		}
+		if size < 8 || pos+size > len(data) {
+			break
+		}
+		if le.Uint32(data[pos+4:]) == LNK_TRACKER_SIGNATURE && size >= LNK_TRACKER_SIZE {
+			l.parseTracker(data[pos : pos+size])
+		}
+		pos += size
+	}
+	l.Size = pos
+
+	switch {
+	case l.LocalPath != "":
+		l.TargetPath = l.LocalPath
+	case l.NetworkShare != "":
+		l.TargetPath = l.NetworkShare
+	default:
+		l.TargetPath = l.RelativePath
+	}
+	return l, nil
+}
+
+// parseLinkInfo разбирает структуру LinkInfo: том (тип, серийный номер, метка) и путь
+// к цели на локальном томе или в сетевой папке.
+func (l *ShellLink) parseLinkInfo(info []byte) {
+	le := binary.LittleEndian
+	headerSize := le.Uint32(info[4:])
+	flags := le.Uint32(info[8:])
+	str := func(off uint32, unicode bool) string {
+		if off == 0 || int(off) >= len(info) {
+			return ""
+		}
+		if unicode {
+			return decodeUTF16(info[off:])
+		}
+		return cString(info[off:])
+	}
+	suffix := str(le.Uint32(info[24:]), false)
+	if headerSize >= 0x24 && len(info) >= 0x24 {
+		if s := str(le.Uint32(info[32:]), true); s != "" {
+			suffix = s
+		}
+	}
+
+	if flags&LNK_VOLUME_ID_AND_LOCAL_BASE_PATH != 0 {
+		if vol := le.Uint32(info[12:]); int(vol)+16 <= len(info) {
+			v := info[vol:]
+			l.DriveType = lnkDriveTypes[le.Uint32(v[4:])]
+			l.VolumeSerial = fmt.Sprintf("%08X", le.Uint32(v[8:]))
+			labelOff := le.Uint32(v[12:])
+			if labelOff == 0x14 && len(v) >= 20 {
+				if off := le.Uint32(v[16:]); int(off) < len(v) {
+					l.VolumeLabel = decodeUTF16(v[off:])
+				}
+			} else if int(labelOff) < len(v) {
+				l.VolumeLabel = cString(v[labelOff:])
+			}
+		}
+		l.LocalPath = str(le.Uint32(info[16:]), false)
+		if headerSize >= 0x24 && len(info) >= 0x24 {
+			if s := str(le.Uint32(info[28:]), true); s != "" {
+				l.LocalPath = s
+			}
+		}
+		l.LocalPath = joinLinkPath(l.LocalPath, suffix)
+	}
+	if flags&LNK_COMMON_NETWORK_RELATIVE_LINK != 0 {
+		if net := le.Uint32(info[20:]); int(net)+20 <= len(info) {
+			n := info[net:]
+			share := ""
+			if off := le.Uint32(n[8:]); int(off) < len(n) {
+				share = cString(n[off:])
+			}
+			// Unicode-имя присутствует, если NetNameOffset больше 0x14
+			if le.Uint32(n[8:]) > 0x14 && len(n) >= 28 {
+				if off := le.Uint32(n[20:]); off != 0 && int(off) < len(n) {
+					share = decodeUTF16(n[off:])
+				}
+			}
+			l.NetworkShare = joinLinkPath(share, suffix)
+		}
+	}
+}
+
+// parseTracker разбирает TrackerDataBlock: NetBIOS-имя компьютера и droid-идентификаторы
+// тома и файла (текущие и при создании). droid файла — UUID версии 1, последние 6 байт
+// которого содержат MAC-адрес компьютера, где файл был создан.
+func (l *ShellLink) parseTracker(block []byte) {
+	l.MachineID = cString(block[16:32])
+	l.VolumeDroid = formatGUID(block[32:48])
+	l.FileDroid = formatGUID(block[48:64])
+	l.BirthVolumeDroid = formatGUID(block[64:80])
+	l.BirthFileDroid = formatGUID(block[80:96])
+	l.MACAddress = droidMACAddress(block[48:64])
+}
+
+// droidMACAddress извлекает MAC-адрес из UUID версии 1; для других версий — пустая строка.
+func droidMACAddress(guid []byte) string {
+	if len(guid) < 16 || binary.LittleEndian.Uint16(guid[6:])>>12 != 1 {
+		return ""
+	}
+	node := guid[10:16]
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x", node[0], node[1], node[2], node[3], node[4], node[5])
+}
+
+// joinLinkPath соединяет базовый путь и общий суффикс пути из LinkInfo.
+func joinLinkPath(base, suffix string) string {
+	if base == "" || suffix == "" {
+		return base
+	}
+	if strings.HasSuffix(base, `\`) {
+		return base + suffix
+	}
+	return base + `\` + suffix
+}
+
+// cString возвращает строку ANSI до первого нулевого байта.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// AsDict возвращает сведения ярлыка для записи JSONL; пустые поля опускаются.
+func (l *ShellLink) AsDict() map[string]interface{} {
+	m := map[string]interface{}{
+		"target_created":    formatTime(l.Created),
+		"target_modified":   formatTime(l.Modified),
+		"target_accessed":   formatTime(l.Accessed),
+		"target_size":       l.FileSize,
+		"target_attributes": fmt.Sprintf("0x%08x", l.Attributes),
+	}
+	for key, value := range map[string]string{
+		"target_path":        l.TargetPath,
+		"local_path":         l.LocalPath,
+		"network_share":      l.NetworkShare,
+		"drive_type":         l.DriveType,
+		"volume_serial":      l.VolumeSerial,
+		"volume_label":       l.VolumeLabel,
+		"name":               l.Name,
+		"relative_path":      l.RelativePath,
+		"working_dir":        l.WorkingDir,
+		"arguments":          l.Arguments,
+		"icon_location":      l.IconLocation,
+		"machine_id":         l.MachineID,
+		"volume_droid":       l.VolumeDroid,
+		"file_droid":         l.FileDroid,
+		"birth_volume_droid": l.BirthVolumeDroid,
+		"birth_file_droid":   l.BirthFileDroid,
+		"mac_address":        l.MACAddress,
+	} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// ----------------------------------------------------------------------
+// lnkParser – разбор ярлыков как ArtifactParser
+// ----------------------------------------------------------------------
+
+type lnkParser struct{}
+
+func newLNKParser(opts parserOptions) (ArtifactParser, error) {
+	return &lnkParser{}, nil
+}
+
+func (p *lnkParser) Name() string { return PARSER_LNK }
+
+func (p *lnkParser) Match(path string) bool {
+	return strings.EqualFold(filepath.Ext(strings.ReplaceAll(path, `\`, "/")), ".lnk")
+}
+
+func (p *lnkParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, LNK_MAX_SIZE))
+	if err != nil {
+		return err
+	}
+	link, err := ParseShellLink(data)
+	if link == nil {
+		return err
+	}
+	record := map[string]interface{}{
+		"file": map[string]interface{}{"path": path},
+		"lnk":  link.AsDict(),
+	}
+	if e := emit(record); e != nil {
+		return e
+	}
+	return err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testFileDroid — droid файла: UUID версии 1 с MAC-адресом 00:0c:29:aa:bb:cc.
+var testFileDroid = []byte{1, 2, 3, 4, 5, 6, 0x00, 0x11, 0x80, 0x01, 0x00, 0x0c, 0x29, 0xaa, 0xbb, 0xcc}
+
+// buildTestLNK собирает ярлык на локальный файл base или, если задан share, на файл в
+// сетевой папке; suffix — общий суффикс пути.
+func buildTestLNK(base, share, suffix string, created time.Time) []byte {
+	le := binary.LittleEndian
+	flags := uint32(LNK_HAS_LINK_INFO | LNK_HAS_NAME | LNK_HAS_WORKING_DIR | LNK_HAS_ARGUMENTS | LNK_IS_UNICODE | LNK_HAS_TARGET_ID_LIST)
+	data := make([]byte, LNK_HEADER_SIZE)
+	copy(data, lnkHeader)
+	le.PutUint32(data[20:], flags)
+	le.PutUint32(data[24:], 0x20)
+	le.PutUint64(data[28:], timeToFiletime(created))
+	le.PutUint64(data[36:], timeToFiletime(created.Add(2*time.Hour)))
+	le.PutUint64(data[44:], timeToFiletime(created.Add(time.Hour)))
+	le.PutUint32(data[52:], 123456)
+
+	// Список идентификаторов цели пропускается при разборе
+	data = le.AppendUint16(data, 4)
+	data = append(data, 0xAA, 0xBB, 0, 0)
+
+	info := make([]byte, 0x1C)
+	le.PutUint32(info[4:], 0x1C)
+	if share == "" {
+		le.PutUint32(info[8:], LNK_VOLUME_ID_AND_LOCAL_BASE_PATH)
+		le.PutUint32(info[12:], uint32(len(info)))
+		vol := make([]byte, 16)
+		le.PutUint32(vol[4:], 3)
+		le.PutUint32(vol[8:], 0x5A5A1234)
+		le.PutUint32(vol[12:], 16)
+		info = append(append(info, vol...), "OS\x00"...)
+		le.PutUint32(info[16:], uint32(len(info)))
+		info = append(info, base+"\x00"...)
+	} else {
+		le.PutUint32(info[8:], LNK_COMMON_NETWORK_RELATIVE_LINK)
+		le.PutUint32(info[20:], uint32(len(info)))
+		netLink := make([]byte, 20)
+		le.PutUint32(netLink[8:], 20)
+		info = append(append(info, netLink...), share+"\x00"...)
+	}
+	le.PutUint32(info[24:], uint32(len(info)))
+	info = append(info, suffix+"\x00"...)
+	le.PutUint32(info[0:], uint32(len(info)))
+	data = append(data, info...)
+
+	for _, s := range []string{"Quarterly report", `C:\Users\alice`, "-enc AAAA"} {
+		data = le.AppendUint16(data, uint16(len([]rune(s))))
+		data = append(data, testUTF16(s)...)
+	}
+
+	// Блок неизвестного типа и TrackerDataBlock
+	data = le.AppendUint32(data, 12)
+	data = le.AppendUint32(data, 0xA0000009)
+	data = append(data, 0, 0, 0, 0)
+	tracker := make([]byte, LNK_TRACKER_SIZE)
+	le.PutUint32(tracker, LNK_TRACKER_SIZE)
+	le.PutUint32(tracker[4:], LNK_TRACKER_SIGNATURE)
+	le.PutUint32(tracker[8:], 0x58)
+	copy(tracker[16:], "ws01")
+	copy(tracker[32:], bytes.Repeat([]byte{0x11}, 16))
+	copy(tracker[48:], testFileDroid)
+	copy(tracker[64:], bytes.Repeat([]byte{0x11}, 16))
+	copy(tracker[80:], testFileDroid)
+	data = append(data, tracker...)
+	return append(data, 0, 0, 0, 0)
+}
+
+func TestParseShellLink(t *testing.T) {
+	created := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	data := buildTestLNK(`C:\Users\alice\Downloads\`, "", "invoice.exe", created)
+	link, err := ParseShellLink(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, len(data), link.Size)
+	assert.Equal(t, `C:\Users\alice\Downloads\invoice.exe`, link.TargetPath)
+	assert.Equal(t, created, link.Created)
+	assert.Equal(t, created.Add(time.Hour), link.Modified)
+	assert.Equal(t, created.Add(2*time.Hour), link.Accessed)
+	assert.Equal(t, uint32(123456), link.FileSize)
+	assert.Equal(t, "fixed", link.DriveType)
+	assert.Equal(t, "5A5A1234", link.VolumeSerial)
+	assert.Equal(t, "OS", link.VolumeLabel)
+	assert.Equal(t, "Quarterly report", link.Name)
+	assert.Equal(t, `C:\Users\alice`, link.WorkingDir)
+	assert.Equal(t, "-enc AAAA", link.Arguments)
+	assert.Equal(t, "ws01", link.MachineID)
+	assert.Equal(t, "00:0c:29:aa:bb:cc", link.MACAddress)
+	assert.Equal(t, "{04030201-0605-1100-8001-000C29AABBCC}", link.FileDroid)
+
+	m := link.AsDict()
+	assert.Equal(t, "2024-02-03T04:05:06Z", m["target_created"])
+	assert.Equal(t, "0x00000020", m["target_attributes"])
+	assert.NotContains(t, m, "network_share")
+
+	link, err = ParseShellLink(buildTestLNK("", `\\fileserver\finance`, `q1\budget.xlsx`, created))
+	assert.NoError(t, err)
+	assert.Equal(t, `\\fileserver\finance\q1\budget.xlsx`, link.TargetPath)
+	assert.Empty(t, link.VolumeSerial)
+
+	_, err = ParseShellLink([]byte("MZ not a link"))
+	assert.Error(t, err)
+	link, err = ParseShellLink(data[:LNK_HEADER_SIZE+20])
+	assert.Error(t, err)
+	assert.NotNil(t, link)
+}
+
+func TestLNKParser(t *testing.T) {
+	p, err := newLNKParser(parserOptions{})
+	assert.NoError(t, err)
+	path := `C:\Users\alice\AppData\Roaming\Microsoft\Windows\Recent\invoice.lnk`
+	assert.True(t, p.Match(path))
+	assert.False(t, p.Match(`C:\Users\alice\invoice.exe`))
+
+	var records []map[string]interface{}
+	data := buildTestLNK(`C:\Users\alice\Downloads\`, "", "invoice.exe", time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC))
+	assert.NoError(t, p.Parse(path, bytes.NewReader(data), func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	}))
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, map[string]interface{}{"path": path}, records[0]["file"])
+		assert.Equal(t, `C:\Users\alice\Downloads\invoice.exe`, records[0]["lnk"].(map[string]interface{})["target_path"])
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"gopkg.in/ini.v1"
 )
@@ -23,6 +25,77 @@ type Config struct {
 	ApiKey    string
 	SHA256    bool
 	Analysis  bool
+	Format    string
+	Aggregate bool
+	Report    bool
+	Providers []ProviderConfig
+	Cache     string
+	CacheTTL  time.Duration
+
+	AnalysisQueue   string
+	AnalysisTimeout time.Duration
+
+	KnownGood     []string
+	KnownBad      []string
+	SkipKnownGood bool
+
+	Rules        []string
+	TrustedRoots []string
+	FuzzyHashes  []string
+
+	RegistryHives []string
+
+	Parsers    []string
+	EVTXFilter string
+}
+
+// AsDict возвращает параметры запуска для отчёта о сборе; ключ API не раскрывается.
+func (c *Config) AsDict() map[string]string {
+	apiKey := ""
+	if c.ApiKey != "" && c.ApiKey != "." {
+		apiKey = "<redacted>"
+	}
+	return map[string]string{
+		"include":   c.Include,
+		"exclude":   c.Exclude,
+		"directory": strings.Join(c.Directory, ","),
+		"registry":  strconv.FormatBool(c.Registry),
+		"maxsize":   c.MaxSize,
+		"output":    c.Output,
+		"apikey":    apiKey,
+		"sha256":    strconv.FormatBool(c.SHA256),
+		"analysis":  strconv.FormatBool(c.Analysis),
+		"format":    c.Format,
+		"aggregate": strconv.FormatBool(c.Aggregate),
+		"report":    strconv.FormatBool(c.Report),
+		"providers": strings.Join(c.providerNames(), ","),
+		"cache":     c.Cache,
+		"cache-ttl": c.CacheTTL.String(),
+
+		"analysis-queue":   c.AnalysisQueue,
+		"analysis-timeout": c.AnalysisTimeout.String(),
+
+		"known-good":      strings.Join(c.KnownGood, ","),
+		"known-bad":       strings.Join(c.KnownBad, ","),
+		"skip-known-good": strconv.FormatBool(c.SkipKnownGood),
+
+		"rules":         strings.Join(c.Rules, ","),
+		"trusted-roots": strings.Join(c.TrustedRoots, ","),
+		"fuzzy-hashes":  strings.Join(c.FuzzyHashes, ","),
+
+		"registry-hives": strings.Join(c.RegistryHives, ","),
+
+		"parsers":     strings.Join(c.Parsers, ","),
+		"evtx-filter": c.EVTXFilter,
+	}
+}
+
+func (c *Config) providerNames() []string {
+	names := make([]string, 0, len(c.Providers))
+	for _, pc := range c.Providers {
+		names = append(names, pc.Name)
+	}
+	return names
 }
 
 func parseArgs() *Config {
@@ -49,6 +122,28 @@ func parseArgs() *Config {
 		ApiKey:    *flags.apikey,
 		SHA256:    *flags.sha256,
 		Analysis:  *flags.analysis,
+		Format:    *flags.format,
+		Aggregate: *flags.aggregate,
+		Report:    *flags.report,
+		Providers: loadProviderConfigs(cfg, splitArgs(*flags.providers), *flags.apikey),
+		Cache:     *flags.cache,
+		CacheTTL:  *flags.cacheTTL,
+
+		AnalysisQueue:   *flags.analysisQueue,
+		AnalysisTimeout: *flags.analysisTimeout,
+
+		KnownGood:     splitArgs(*flags.knownGood),
+		KnownBad:      splitArgs(*flags.knownBad),
+		SkipKnownGood: *flags.skipKnownGood,
+
+		Rules:        splitArgs(*flags.rules),
+		TrustedRoots: splitArgs(*flags.trustedRoots),
+		FuzzyHashes:  splitArgs(*flags.fuzzyHashes),
+
+		RegistryHives: splitArgs(*flags.registryHives),
+
+		Parsers:    splitArgs(*flags.parsers),
+		EVTXFilter: *flags.evtxFilter,
 	}
 }
 
@@ -79,6 +174,28 @@ type appFlags struct {
 	output    *string
 	sha256    *bool
 	analysis  *bool
+	format    *string
+	aggregate *bool
+	report    *bool
+	providers *string
+	cache     *string
+	cacheTTL  *time.Duration
+
+	analysisQueue   *string
+	analysisTimeout *time.Duration
+
+	knownGood     *string
+	knownBad      *string
+	skipKnownGood *bool
+
+	rules        *string
+	trustedRoots *string
+	fuzzyHashes  *string
+
+	registryHives *string
+
+	parsers    *string
+	evtxFilter *string
 }
 
 func initFlags(cfg *ini.File) *appFlags {
@@ -121,9 +238,172 @@ func initFlags(cfg *ini.File) *appFlags {
 		section.Key("analysis").MustBool(false),
 		"Флаг, управляющий первичным аналзим артефактов")
 
+	flags.format = flag.String("format",
+		section.Key("format").MustString(ARCHIVE_FORMAT_ZIP),
+		"Формат хранения собранных файлов: zip, tar или dir")
+
+	flags.aggregate = flag.Bool("aggregate",
+		section.Key("aggregate").MustBool(true),
+		"Формировать сводные commands.json, wmi.json и registry.json по завершении сбора")
+
+	flags.report = flag.Bool("report",
+		section.Key("report").MustBool(true),
+		"Формировать отчёт о сборе report.html и report.md")
+
+	flags.providers = flag.String("providers",
+		section.Key("providers").MustString(""),
+		"Источники анализа через запятую (секции [provider.<имя>] в artifacts.ini); по умолчанию opentip")
+
+	flags.cache = flag.String("cache",
+		section.Key("cache").MustString(""),
+		"Файл кэша вердиктов анализа, общего для запусков (по умолчанию в пользовательском каталоге кэша)")
+
+	flags.cacheTTL = flag.Duration("cache-ttl",
+		section.Key("cache-ttl").MustDuration(24*time.Hour),
+		"Срок хранения вердиктов в кэше; 0 отключает кэш")
+
+	flags.analysisQueue = flag.String("analysis-queue",
+		section.Key("analysis-queue").MustString(QUEUE_MODE_SPILL),
+		"Поведение очереди анализа при переполнении: spill (выгрузка на диск) или block (ожидание)")
+
+	flags.analysisTimeout = flag.Duration("analysis-timeout",
+		section.Key("analysis-timeout").MustDuration(DEFAULT_DRAIN_TIMEOUT),
+		"Время ожидания незавершённых проверок анализа по окончании сбора")
+
+	flags.knownGood = flag.String("known-good",
+		section.Key("known-good").MustString(""),
+		"Файлы наборов известных хороших хешей (NSRL RDS, списки md5/sha1/sha256, CSV) через запятую")
+
+	flags.knownBad = flag.String("known-bad",
+		section.Key("known-bad").MustString(""),
+		"Файлы наборов известных плохих хешей (списки md5/sha1/sha256, CSV с метками) через запятую")
+
+	flags.skipKnownGood = flag.Bool("skip-known-good",
+		section.Key("skip-known-good").MustBool(false),
+		"Не архивировать файлы из наборов известных хороших хешей")
+
+	flags.rules = flag.String("rules",
+		section.Key("rules").MustString(""),
+		"Файлы или каталоги правил проверки содержимого в синтаксисе YARA (через запятую)")
+
+	flags.trustedRoots = flag.String("trusted-roots",
+		section.Key("trusted-roots").MustString(""),
+		"Доверенные корневые сертификаты (PEM/DER, файлы или каталоги через запятую) для проверки подписей Authenticode")
+
+	flags.fuzzyHashes = flag.String("fuzzy-hashes",
+		section.Key("fuzzy-hashes").MustString(""),
+		"Нечёткие хеши файлов через запятую: ssdeep, tlsh (по умолчанию не вычисляются)")
+
+	flags.registryHives = flag.String("registry-hives",
+		section.Key("registry-hives").MustString(""),
+		"Смонтированные тома Windows или каталоги результатов сбора с кустами реестра (через запятую) для разбора без API Windows")
+
+	flags.parsers = flag.String("parsers",
+		section.Key("parsers").MustString(""),
+		"Разборщики собранных файлов через запятую (evtx, prefetch, lnk, jumplist, mft, usn, recyclebin, tasks, srum, browser_history или all); записи пишутся в <hostname>-<parser>.jsonl")
+
+	flags.evtxFilter = flag.String("evtx-filter",
+		section.Key("evtx-filter").MustString(""),
+		"Фильтр событий EVTX: channel=Security,System;id=4624,4688-4690;since=2024-01-01;until=2024-02-01")
+
 	return flags
 }
 
+// loadHashSet загружает локальные наборы хешей; nil, если наборы не заданы.
+func loadHashSet(knownGood, knownBad []string) (*HashSet, error) {
+	if len(knownGood) == 0 && len(knownBad) == 0 {
+		return nil, nil
+	}
+	hs := NewHashSet()
+	for _, set := range []struct {
+		files   []string
+		verdict string
+	}{{knownGood, HASHSET_KNOWN_GOOD}, {knownBad, HASHSET_KNOWN_BAD}} {
+		for _, path := range set.files {
+			n, err := hs.LoadFile(path, set.verdict)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			logger.Log(LevelInfo, fmt.Sprintf("Загружено %d хешей (%s) из %s", n, set.verdict, path))
+		}
+	}
+	return hs, nil
+}
+
+// newAnalysisProviders создаёт источники анализа и при ttl > 0 подключает к ним кэш вердиктов,
+// который вызывающий должен закрыть. Недоступный кэш не мешает анализу.
+func newAnalysisProviders(configs []ProviderConfig, cachePath string, ttl time.Duration) ([]Provider, *VerdictCache, error) {
+	providers, err := NewProviders(configs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ttl <= 0 {
+		return providers, nil, nil
+	}
+	cache, err := OpenVerdictCache(cachePath, ttl)
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Кэш вердиктов недоступен: %v", err))
+		return providers, nil, nil
+	}
+	logger.Log(LevelInfo, fmt.Sprintf("Загружено %d вердиктов из кэша", cache.Len()))
+	for i, p := range providers {
+		providers[i] = NewCachedProvider(p, cache)
+	}
+	return providers, cache, nil
+}
+
+// loadProviderConfigs читает настройки источников анализа из секций [provider.<имя>].
+// Ключи type, url, apikey, timeout, insecure и header.<Заголовок> общие для всех типов,
+// остальные передаются источнику как параметры. Без списка источников используется OpenTIP
+// с ключом из -apikey.
+func loadProviderConfigs(cfg *ini.File, names []string, apiKey string) []ProviderConfig {
+	if len(names) == 0 {
+		if apiKey == "" {
+			return nil
+		}
+		names = []string{PROVIDER_OPENTIP}
+	}
+	configs := make([]ProviderConfig, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(name)
+		pc := ProviderConfig{
+			Name:    name,
+			Type:    name,
+			Headers: make(map[string]string),
+			Options: make(map[string]string),
+		}
+		if cfg.HasSection("provider." + name) {
+			for _, key := range cfg.Section("provider." + name).Keys() {
+				switch k := key.Name(); {
+				case k == "type":
+					pc.Type = strings.ToLower(key.String())
+				case k == "url":
+					pc.URL = key.String()
+				case k == "apikey":
+					pc.APIKey = key.String()
+				case k == "timeout":
+					pc.Timeout = key.MustDuration(0)
+				case k == "insecure":
+					pc.Insecure = key.MustBool(false)
+				case k == "rate":
+					pc.Rate = key.String()
+				case k == "burst":
+					pc.Burst = key.MustInt(1)
+				case strings.HasPrefix(k, "header."):
+					pc.Headers[strings.TrimPrefix(k, "header.")] = key.String()
+				default:
+					pc.Options[k] = key.String()
+				}
+			}
+		}
+		if pc.Type == PROVIDER_OPENTIP && pc.APIKey == "" {
+			pc.APIKey = apiKey
+		}
+		configs = append(configs, pc)
+	}
+	return configs
+}
+
 func splitArgs(input string) []string {
 	if input == "" {
 		return nil
@@ -269,6 +549,20 @@ func contains(slice []string, item string) bool {
 // ─── Основная функция ─────────────────────────────────────────────────────────
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case ANALYZE_COMMAND:
+			os.Exit(runAnalyze(os.Args[2:]))
+		case SCAN_COMMAND:
+			os.Exit(runScan(os.Args[2:]))
+		case SWEEP_COMMAND:
+			os.Exit(runSweep(os.Args[2:]))
+		case TIMELINE_COMMAND:
+			os.Exit(runTimeline(os.Args[2:]))
+		case PARSE_COMMAND:
+			os.Exit(runParse(os.Args[2:]))
+		}
+	}
 	config := parseArgs()
 
 	platform, err := getOperatingSystem()
@@ -277,13 +571,84 @@ func main() {
 		os.Exit(1)
 	}
 
-	output, err := NewOutputs(config.Output, config.MaxSize, config.SHA256, config.Analysis, config.ApiKey)
+	output, err := NewOutputs(config.Output, config.MaxSize, config.SHA256, config.Analysis)
 	if err != nil {
 		logger.Log(LevelCritical, fmt.Sprintf("Не удалось инициализировать вывод: %v", err))
 		os.Exit(1)
 	}
 
-	logger.Log(LevelInfo, fmt.Sprintf("Config: %#v\n", config))
+	if err := output.SetArchiveFormat(config.Format); err != nil {
+		logger.Log(LevelCritical, err.Error())
+		os.Exit(1)
+	}
+	output.SetAggregate(config.Aggregate)
+	output.SetReport(config.Report)
+	output.SetReportConfig(config.AsDict())
+
+	hashSet, err := loadHashSet(config.KnownGood, config.KnownBad)
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить наборы хешей: %v", err))
+		os.Exit(1)
+	}
+	if hashSet != nil {
+		output.SetHashSet(hashSet)
+		output.SetSkipKnownGood(config.SkipKnownGood)
+	}
+
+	if len(config.Rules) > 0 {
+		rules, err := LoadRules(config.Rules)
+		if err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить правила: %v", err))
+			os.Exit(1)
+		}
+		logger.Log(LevelInfo, fmt.Sprintf("Загружено %d правил проверки содержимого", rules.Len()))
+		output.SetRules(rules)
+	}
+
+	if err := output.SetFuzzyHashes(config.FuzzyHashes); err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Неверный параметр -fuzzy-hashes: %v", err))
+		os.Exit(1)
+	}
+
+	if len(config.Parsers) > 0 {
+		parsers, err := newArtifactParsers(config.Parsers, parserOptions{EVTXFilter: config.EVTXFilter})
+		if err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Неверный параметр -parsers: %v", err))
+			os.Exit(1)
+		}
+		output.SetParsers(parsers)
+	}
+
+	if len(config.TrustedRoots) > 0 {
+		roots, n, err := LoadTrustedRoots(config.TrustedRoots)
+		if err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить доверенные сертификаты: %v", err))
+			os.Exit(1)
+		}
+		logger.Log(LevelInfo, fmt.Sprintf("Загружено %d доверенных корневых сертификатов", n))
+		output.SetTrustedRoots(roots)
+	}
+
+	if config.Analysis {
+		providers, cache, err := newAnalysisProviders(config.Providers, config.Cache, config.CacheTTL)
+		if err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось инициализировать источники анализа: %v", err))
+			os.Exit(1)
+		}
+		if cache != nil {
+			defer cache.Close()
+		}
+		if err := output.SetProviders(providers); err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось запустить очередь анализа: %v", err))
+			os.Exit(1)
+		}
+		if err := output.SetAnalysisQueue(config.AnalysisQueue, config.AnalysisTimeout); err != nil {
+			logger.Log(LevelCritical, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	logger.Log(LevelInfo, fmt.Sprintf("Config: %v", config.AsDict()))
 
 	// Создаём коллектор. В конструктор передаётся платформа.
 	collector := NewCollector(platform, nil)
@@ -297,17 +662,37 @@ func main() {
 	excludeArtifacts := resolveArtifactGroups(registry, config.Exclude)
 
 	// Флаг, управляющий сбором реестровых источников
-	if (platform == "Windows") && (config.Registry) {
+	collectRegistry := config.Registry
+	if len(config.RegistryHives) > 0 {
+		// Реестровые источники берутся из файлов кустов, а не из реестра текущей системы
+		collectRegistry = false
+		logger.Log(LevelInfo, "Реестровые источники разбираются из файлов кустов: "+strings.Join(config.RegistryHives, ", "))
+	} else if (platform == "Windows") && (config.Registry) {
 		logger.Log(LevelInfo, "Сбор реестровых источников активирован")
 	} else {
 		logger.Log(LevelInfo, "Сбор реестровых источников отключен: флаг Registry не задан")
 	}
 
 	// Фильтруем артефакты и регистрируем источники в коллекторе.
-	for _, pair := range getArtifactsToCollect(registry, includeArtifacts, excludeArtifacts, platform, config.Registry) {
+	for _, pair := range getArtifactsToCollect(registry, includeArtifacts, excludeArtifacts, platform, collectRegistry) {
 		collector.RegisterSource(pair.definition, pair.source)
 	}
 
+	if len(config.RegistryHives) > 0 {
+		offline, err := NewOfflineRegistryCollector(config.RegistryHives)
+		if err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить кусты реестра: %v", err))
+			os.Exit(1)
+		}
+		var pairs []ArtifactSourcePair
+		for _, pair := range getArtifactsToCollect(registry, includeArtifacts, excludeArtifacts, "Windows", true) {
+			if pair.source.TypeIndicator == TYPE_INDICATOR_WINDOWS_REGISTRY_KEY || pair.source.TypeIndicator == TYPE_INDICATOR_WINDOWS_REGISTRY_VALUE {
+				pairs = append(pairs, pair)
+			}
+		}
+		collector.AddOfflineCollector(offline, pairs)
+	}
+
 	// Запускаем сбор артефактов и закрываем вывод.
 	logger.Log(LevelProgress, fmt.Sprintf("Collecting artifacts from %d sources ...", collector.sources))
 	collector.Collect(output)
@@ -0,0 +1,528 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Главная таблица файлов NTFS ($MFT): записи по 1024 (реже 4096) байт с сигнатурой
+// "FILE", защищённые массивом исправлений (fixup) в конце каждого 512-байтового сектора.
+// Запись состоит из атрибутов: $STANDARD_INFORMATION ($SI) с временными метками, которые
+// меняются через API Windows, $FILE_NAME ($FN) с именем, ссылкой на родительский каталог
+// и собственными метками, и $DATA — основным и именованными (ADS) потоками данных.
+const (
+	MFT_RECORD_SIZE    = 1024
+	MFT_SECTOR_SIZE    = 512
+	MFT_ROOT_RECORD    = 5
+	MFT_MAX_PATH_DEPTH = 256
+	MFT_ORPHAN_DIR     = "$OrphanFiles"
+
+	MFT_FLAG_IN_USE    = 0x0001
+	MFT_FLAG_DIRECTORY = 0x0002
+
+	MFT_ATTR_STANDARD_INFORMATION = 0x10
+	MFT_ATTR_FILE_NAME            = 0x30
+	MFT_ATTR_DATA                 = 0x80
+	MFT_ATTR_END                  = 0xFFFFFFFF
+
+	MFT_NAMESPACE_DOS = 2
+)
+
+var mftSignature = []byte("FILE")
+
+// ntfsFileAttributes — флаги атрибутов файла ($SI, $FN, записи USN).
+var ntfsFileAttributes = []struct {
+	bit  uint32
+	name string
+}{
+	{0x00000001, "readonly"},
+	{0x00000002, "hidden"},
+	{0x00000004, "system"},
+	{0x00000010, "directory"},
+	{0x00000020, "archive"},
+	{0x00000040, "device"},
+	{0x00000080, "normal"},
+	{0x00000100, "temporary"},
+	{0x00000200, "sparse"},
+	{0x00000400, "reparse_point"},
+	{0x00000800, "compressed"},
+	{0x00001000, "offline"},
+	{0x00002000, "not_content_indexed"},
+	{0x00004000, "encrypted"},
+	{0x10000000, "directory"},
+	{0x20000000, "index_view"},
+}
+
+// ntfsAttributeNames возвращает имена установленных флагов атрибутов файла.
+func ntfsAttributeNames(attrs uint32) []string {
+	names := []string{}
+	for _, f := range ntfsFileAttributes {
+		if attrs&f.bit != 0 && !containsString(names, f.name) {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+// MFTTimes — временные метки атрибута $SI или $FN.
+type MFTTimes struct {
+	Created  time.Time
+	Modified time.Time
+	Changed  time.Time // изменение записи MFT
+	Accessed time.Time
+}
+
+func parseMFTTimes(b []byte) MFTTimes {
+	le := binary.LittleEndian
+	return MFTTimes{
+		Created:  filetimeToTime(le.Uint64(b)),
+		Modified: filetimeToTime(le.Uint64(b[8:])),
+		Changed:  filetimeToTime(le.Uint64(b[16:])),
+		Accessed: filetimeToTime(le.Uint64(b[24:])),
+	}
+}
+
+func (t MFTTimes) asDict() map[string]interface{} {
+	return map[string]interface{}{
+		"created":  formatTime(t.Created),
+		"modified": formatTime(t.Modified),
+		"changed":  formatTime(t.Changed),
+		"accessed": formatTime(t.Accessed),
+	}
+}
+
+// MFTStandardInfo — атрибут $STANDARD_INFORMATION.
+type MFTStandardInfo struct {
+	MFTTimes
+	Attributes uint32
+	SecurityID uint32
+	USN        uint64
+}
+
+// MFTFileName — атрибут $FILE_NAME.
+type MFTFileName struct {
+	MFTTimes
+	ParentRecord   uint64
+	ParentSequence uint16
+	Size           uint64
+	Attributes     uint32
+	Namespace      uint8
+	Name           string
+}
+
+// MFTStream — атрибут $DATA; Name пусто у основного потока. Data заполняется только
+// для резидентных потоков, хранящихся в самой записи.
+type MFTStream struct {
+	Name     string
+	Size     uint64
+	Resident bool
+	Data     []byte
+}
+
+// MFTEntry — запись MFT. Атрибуты записей-расширений (при большом числе атрибутов)
+// объединяются с базовой записью.
+type MFTEntry struct {
+	Record     uint64
+	Sequence   uint16
+	InUse      bool
+	Directory  bool
+	LinkCount  uint16
+	LSN        uint64
+	BaseRecord uint64
+	Corrupted  bool
+
+	StandardInfo *MFTStandardInfo
+	FileNames    []MFTFileName
+	Streams      []MFTStream
+
+	Path   string
+	Orphan bool
+}
+
+// ParseMFTRecord разбирает запись MFT с номером record. Несовпадение значений массива
+// исправлений (запись, недописанная при снятии образа) отмечается в Corrupted; разбор
+// при этом продолжается.
+func ParseMFTRecord(record uint64, data []byte) (*MFTEntry, error) {
+	if len(data) < 48 || !bytes.Equal(data[:4], mftSignature) {
+		return nil, fmt.Errorf("MFT record %d: bad signature", record)
+	}
+	le := binary.LittleEndian
+	data = append([]byte(nil), data...)
+	e := &MFTEntry{
+		Record:     record,
+		LSN:        le.Uint64(data[8:]),
+		Sequence:   le.Uint16(data[16:]),
+		LinkCount:  le.Uint16(data[18:]),
+		BaseRecord: le.Uint64(data[32:]) & 0xFFFFFFFFFFFF,
+	}
+	flags := le.Uint16(data[22:])
+	e.InUse = flags&MFT_FLAG_IN_USE != 0
+	e.Directory = flags&MFT_FLAG_DIRECTORY != 0
+
+	usaOff, usaCount := int(le.Uint16(data[4:])), int(le.Uint16(data[6:]))
+	if usaCount > 0 && usaOff+usaCount*2 <= len(data) {
+		usn := le.Uint16(data[usaOff:])
+		for i := 1; i < usaCount; i++ {
+			end := i*MFT_SECTOR_SIZE - 2
+			if end+2 > len(data) {
+				break
+			}
+			if le.Uint16(data[end:]) != usn {
+				e.Corrupted = true
+			}
+			copy(data[end:end+2], data[usaOff+i*2:usaOff+i*2+2])
+		}
+	}
+
+	used := int(le.Uint32(data[24:]))
+	if used > len(data) || used < 48 {
+		used = len(data)
+	}
+	for off := int(le.Uint16(data[20:])); off+16 <= used; {
+		typ, length := le.Uint32(data[off:]), int(le.Uint32(data[off+4:]))
+		if typ == MFT_ATTR_END || length < 16 || off+length > used {
+			break
+		}
+		e.parseAttribute(data[off : off+length])
+		off += length
+	}
+	return e, nil
+}
+
+// parseAttribute добавляет в запись сведения атрибутов $SI, $FN и $DATA.
+func (e *MFTEntry) parseAttribute(attr []byte) {
+	le := binary.LittleEndian
+	typ, nonResident := le.Uint32(attr), attr[8] != 0
+	var name string
+	if n, off := int(attr[9]), int(le.Uint16(attr[10:])); n > 0 && off+n*2 <= len(attr) {
+		name = decodeUTF16Full(attr[off : off+n*2])
+	}
+	var content []byte
+	if !nonResident && len(attr) >= 24 {
+		size, off := int(le.Uint32(attr[16:])), int(le.Uint16(attr[20:]))
+		if off+size <= len(attr) {
+			content = attr[off : off+size]
+		}
+	}
+
+	switch typ {
+	case MFT_ATTR_STANDARD_INFORMATION:
+		if len(content) < 48 {
+			return
+		}
+		si := &MFTStandardInfo{MFTTimes: parseMFTTimes(content), Attributes: le.Uint32(content[32:])}
+		if len(content) >= 72 {
+			si.SecurityID = le.Uint32(content[52:])
+			si.USN = le.Uint64(content[64:])
+		}
+		e.StandardInfo = si
+	case MFT_ATTR_FILE_NAME:
+		if len(content) < 66 || 66+int(content[64])*2 > len(content) {
+			return
+		}
+		parent := le.Uint64(content)
+		e.FileNames = append(e.FileNames, MFTFileName{
+			MFTTimes:       parseMFTTimes(content[8:]),
+			ParentRecord:   parent & 0xFFFFFFFFFFFF,
+			ParentSequence: uint16(parent >> 48),
+			Size:           le.Uint64(content[48:]),
+			Attributes:     le.Uint32(content[56:]),
+			Namespace:      content[65],
+			Name:           decodeUTF16Full(content[66 : 66+int(content[64])*2]),
+		})
+	case MFT_ATTR_DATA:
+		stream := MFTStream{Name: name, Resident: !nonResident}
+		if nonResident {
+			if len(attr) < 56 || le.Uint64(attr[16:]) != 0 {
+				// Продолжение потока (начальный VCN не 0) — размер указан в первом фрагменте
+				return
+			}
+			stream.Size = le.Uint64(attr[48:])
+		} else {
+			stream.Size = uint64(len(content))
+			stream.Data = append([]byte(nil), content...)
+		}
+		e.Streams = append(e.Streams, stream)
+	}
+}
+
+// FileName возвращает основное имя файла: длинное (Win32 или POSIX), а при его
+// отсутствии — короткое имя DOS.
+func (e *MFTEntry) FileName() *MFTFileName {
+	for i := range e.FileNames {
+		if e.FileNames[i].Namespace != MFT_NAMESPACE_DOS {
+			return &e.FileNames[i]
+		}
+	}
+	if len(e.FileNames) > 0 {
+		return &e.FileNames[0]
+	}
+	return nil
+}
+
+// Timestomped сообщает, что время создания или изменения в $SI раньше, чем в $FN:
+// $FN задаётся при создании и переименовании, поэтому такая разница характерна
+// для подделки меток через SetFileTime.
+func (e *MFTEntry) Timestomped() bool {
+	fn := e.FileName()
+	if e.StandardInfo == nil || fn == nil {
+		return false
+	}
+	si := e.StandardInfo
+	return !si.Created.IsZero() && si.Created.Before(fn.Created) ||
+		!si.Modified.IsZero() && si.Modified.Before(fn.Created)
+}
+
+// stream возвращает основной поток данных записи.
+func (e *MFTEntry) stream() *MFTStream {
+	for i := range e.Streams {
+		if e.Streams[i].Name == "" {
+			return &e.Streams[i]
+		}
+	}
+	return nil
+}
+
+// AsDict возвращает запись для JSONL: метки $SI в полях file (как в file_info),
+// обе группы меток, потоки и признаки удаления и подделки меток — в mft.
+func (e *MFTEntry) AsDict(source string) map[string]interface{} {
+	file := map[string]interface{}{
+		"path":  e.Path,
+		"inode": fmt.Sprintf("%d-%d", e.Record, e.Sequence),
+		"type":  "file",
+	}
+	if e.Directory {
+		file["type"] = "dir"
+	}
+	mft := map[string]interface{}{
+		"record":      e.Record,
+		"sequence":    e.Sequence,
+		"in_use":      e.InUse,
+		"directory":   e.Directory,
+		"link_count":  e.LinkCount,
+		"lsn":         e.LSN,
+		"timestomped": e.Timestomped(),
+	}
+	if e.Orphan {
+		mft["orphan"] = true
+	}
+	if e.Corrupted {
+		mft["corrupted"] = true
+	}
+	record := map[string]interface{}{
+		"event": map[string]interface{}{"kind": "event", "category": "file", "action": "mft-entry"},
+		"file":  file,
+		"log":   map[string]interface{}{"file": map[string]interface{}{"path": source}},
+		"mft":   mft,
+	}
+
+	if si := e.StandardInfo; si != nil {
+		file["mtime"] = formatTime(si.Modified)
+		file["accessed"] = formatTime(si.Accessed)
+		file["ctime"] = formatTime(si.Changed)
+		file["created"] = formatTime(si.Created)
+		file["attributes"] = ntfsAttributeNames(si.Attributes)
+		sid := si.asDict()
+		if si.USN != 0 {
+			sid["usn"] = si.USN
+		}
+		if si.SecurityID != 0 {
+			sid["security_id"] = si.SecurityID
+		}
+		mft["standard_information"] = sid
+		if !si.Modified.IsZero() {
+			record["@timestamp"] = formatTime(si.Modified)
+		}
+	}
+	if fn := e.FileName(); fn != nil {
+		file["name"] = fn.Name
+		file["size"] = fn.Size
+		fnd := fn.asDict()
+		fnd["name"] = fn.Name
+		fnd["namespace"] = fn.Namespace
+		mft["file_name"] = fnd
+		mft["parent_record"] = fn.ParentRecord
+		mft["parent_sequence"] = fn.ParentSequence
+		if _, ok := record["@timestamp"]; !ok && !fn.Modified.IsZero() {
+			record["@timestamp"] = formatTime(fn.Modified)
+		}
+	}
+	if len(e.FileNames) > 1 {
+		names := make([]string, 0, len(e.FileNames))
+		for _, fn := range e.FileNames {
+			names = append(names, fn.Name)
+		}
+		mft["names"] = names
+	}
+	if s := e.stream(); s != nil {
+		file["size"] = s.Size
+		if s.Resident {
+			mft["resident_data"] = s.Data
+		}
+	}
+	var ads []map[string]interface{}
+	for _, s := range e.Streams {
+		if s.Name == "" {
+			continue
+		}
+		m := map[string]interface{}{"name": s.Name, "size": s.Size, "resident": s.Resident}
+		if s.Resident {
+			m["data"] = s.Data
+		}
+		ads = append(ads, m)
+	}
+	if len(ads) > 0 {
+		mft["streams"] = ads
+	}
+	return record
+}
+
+// MFTTable — разобранная таблица $MFT.
+type MFTTable struct {
+	Entries []*MFTEntry
+	byID    map[uint64]*MFTEntry
+	volume  string
+}
+
+// ReadMFT последовательно читает записи $MFT. Размер записи берётся из заголовка первой
+// записи; пустые записи и записи с сигнатурой BAAD пропускаются. volume (например, "C:")
+// добавляется в начало восстановленных путей.
+func ReadMFT(r io.Reader, volume string) (*MFTTable, error) {
+	br := bufio.NewReaderSize(r, 1<<20)
+	t := &MFTTable{byID: make(map[uint64]*MFTEntry), volume: volume}
+	size := MFT_RECORD_SIZE
+	if head, _ := br.Peek(32); len(head) == 32 && bytes.Equal(head[:4], mftSignature) {
+		if s := int(binary.LittleEndian.Uint32(head[28:])); s == 1024 || s == 4096 {
+			size = s
+		}
+	}
+	buf := make([]byte, size)
+	var extensions []*MFTEntry
+	var readErr error
+	for record := uint64(0); ; record++ {
+		n, err := io.ReadFull(br, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if n > 0 {
+				readErr = fmt.Errorf("MFT record %d truncated", record)
+			}
+			break
+		}
+		e, err := ParseMFTRecord(record, buf)
+		if err != nil {
+			// Пустая (никогда не использованная) запись или BAAD
+			continue
+		}
+		if e.BaseRecord != 0 {
+			extensions = append(extensions, e)
+			continue
+		}
+		t.Entries = append(t.Entries, e)
+		t.byID[record] = e
+	}
+	for _, ext := range extensions {
+		if base := t.byID[ext.BaseRecord]; base != nil {
+			base.FileNames = append(base.FileNames, ext.FileNames...)
+			base.Streams = append(base.Streams, ext.Streams...)
+		}
+	}
+	for _, e := range t.Entries {
+		e.Path, e.Orphan = t.resolvePath(e)
+	}
+	return t, readErr
+}
+
+// parentOf возвращает родительский каталог записи или nil, если ссылка на родителя
+// устарела: запись каталога освобождена и использована повторно. У освобождённой
+// записи номер последовательности уже увеличен на единицу.
+func (t *MFTTable) parentOf(fn *MFTFileName) *MFTEntry {
+	p := t.byID[fn.ParentRecord]
+	if p == nil {
+		return nil
+	}
+	if p.Sequence == fn.ParentSequence || !p.InUse && p.Sequence == fn.ParentSequence+1 {
+		return p
+	}
+	return nil
+}
+
+// resolvePath восстанавливает полный путь записи по ссылкам на родительские каталоги,
+// в том числе для удалённых файлов. Если цепочка прерывается, путь строится от
+// каталога $OrphanFiles и orphan = true.
+func (t *MFTTable) resolvePath(e *MFTEntry) (path string, orphan bool) {
+	root := t.volume + `\`
+	if e.Record == MFT_ROOT_RECORD {
+		return root, false
+	}
+	fn := e.FileName()
+	if fn == nil {
+		return root + MFT_ORPHAN_DIR + `\` + strconv.FormatUint(e.Record, 10), true
+	}
+	parts := []string{fn.Name}
+	visited := map[uint64]bool{e.Record: true}
+	for cur := fn; ; {
+		p := t.parentOf(cur)
+		if p == nil || visited[p.Record] || len(parts) > MFT_MAX_PATH_DEPTH {
+			parts = append(parts, MFT_ORPHAN_DIR)
+			orphan = true
+			break
+		}
+		if p.Record == MFT_ROOT_RECORD {
+			break
+		}
+		visited[p.Record] = true
+		if cur = p.FileName(); cur == nil {
+			parts = append(parts, MFT_ORPHAN_DIR)
+			orphan = true
+			break
+		}
+		parts = append(parts, cur.Name)
+	}
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+	return root + strings.Join(parts, `\`), orphan
+}
+
+// ntfsVolume возвращает букву тома ("C:") из пути собранного файла Windows.
+func ntfsVolume(path string) string {
+	if len(path) >= 2 && path[1] == ':' {
+		return strings.ToUpper(path[:2])
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------------
+// mftParser – разбор $MFT как ArtifactParser
+// ----------------------------------------------------------------------
+
+type mftParser struct{}
+
+func newMFTParser(opts parserOptions) (ArtifactParser, error) {
+	return &mftParser{}, nil
+}
+
+func (p *mftParser) Name() string { return PARSER_MFT }
+
+func (p *mftParser) Match(path string) bool {
+	return strings.EqualFold(filepath.Base(strings.ReplaceAll(path, `\`, "/")), "$MFT")
+}
+
+func (p *mftParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	table, err := ReadMFT(r, ntfsVolume(path))
+	for _, e := range table.Entries {
+		if e := emit(e.AsDict(path)); e != nil {
+			return e
+		}
+	}
+	return err
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testMFTFile struct {
+	record, parent  uint64
+	seq, parentSeq  uint16
+	name            string
+	inUse, dir      bool
+	si, fn          time.Time
+	data            []byte
+	ads             map[string][]byte
+	nonResidentSize uint64
+}
+
+// testMFTAttribute собирает резидентный атрибут с необязательным именем.
+func testMFTAttribute(typ uint32, name string, content []byte) []byte {
+	le := binary.LittleEndian
+	nameBytes := testUTF16(name)
+	if name == "" {
+		nameBytes = nil
+	}
+	contentOff := 24 + len(nameBytes)
+	contentOff = (contentOff + 7) &^ 7
+	attr := make([]byte, (contentOff+len(content)+7)&^7)
+	le.PutUint32(attr, typ)
+	le.PutUint32(attr[4:], uint32(len(attr)))
+	attr[9] = byte(len([]rune(name)))
+	le.PutUint16(attr[10:], 24)
+	copy(attr[24:], nameBytes)
+	le.PutUint32(attr[16:], uint32(len(content)))
+	le.PutUint16(attr[20:], uint16(contentOff))
+	copy(attr[contentOff:], content)
+	return attr
+}
+
+func testMFTTimes(t time.Time) []byte {
+	b := make([]byte, 32)
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint64(b[i*8:], timeToFiletime(t))
+	}
+	return b
+}
+
+// buildTestMFTRecord собирает запись MFT размером 1024 байта с массивом исправлений.
+func buildTestMFTRecord(f testMFTFile) []byte {
+	le := binary.LittleEndian
+	rec := make([]byte, MFT_RECORD_SIZE)
+	copy(rec, mftSignature)
+	le.PutUint16(rec[4:], 48)
+	le.PutUint16(rec[6:], 3)
+	le.PutUint16(rec[16:], f.seq)
+	le.PutUint16(rec[18:], 1)
+	le.PutUint16(rec[20:], 56)
+	var flags uint16
+	if f.inUse {
+		flags |= MFT_FLAG_IN_USE
+	}
+	if f.dir {
+		flags |= MFT_FLAG_DIRECTORY
+	}
+	le.PutUint16(rec[22:], flags)
+	le.PutUint32(rec[28:], MFT_RECORD_SIZE)
+	le.PutUint32(rec[44:], uint32(f.record))
+
+	si := make([]byte, 72)
+	copy(si, testMFTTimes(f.si))
+	le.PutUint32(si[32:], 0x20)
+	le.PutUint64(si[64:], 4096)
+	fn := make([]byte, 66)
+	le.PutUint64(fn, f.parent|uint64(f.parentSeq)<<48)
+	copy(fn[8:], testMFTTimes(f.fn))
+	le.PutUint64(fn[48:], uint64(len(f.data)))
+	fn[64], fn[65] = byte(len([]rune(f.name))), 1
+	fn = append(fn, testUTF16(f.name)...)
+
+	attrs := [][]byte{testMFTAttribute(MFT_ATTR_STANDARD_INFORMATION, "", si), testMFTAttribute(MFT_ATTR_FILE_NAME, "", fn)}
+	if f.nonResidentSize > 0 {
+		attr := make([]byte, 72)
+		le.PutUint32(attr, MFT_ATTR_DATA)
+		le.PutUint32(attr[4:], 72)
+		attr[8] = 1
+		le.PutUint64(attr[48:], f.nonResidentSize)
+		attrs = append(attrs, attr)
+	} else if !f.dir {
+		attrs = append(attrs, testMFTAttribute(MFT_ATTR_DATA, "", f.data))
+	}
+	for name, data := range f.ads {
+		attrs = append(attrs, testMFTAttribute(MFT_ATTR_DATA, name, data))
+	}
+	off := 56
+	for _, a := range attrs {
+		copy(rec[off:], a)
+		off += len(a)
+	}
+	le.PutUint32(rec[off:], MFT_ATTR_END)
+	le.PutUint32(rec[24:], uint32(off+8))
+
+	// Массив исправлений: последние два байта каждого сектора заменяются на USN
+	le.PutUint16(rec[48:], 0x0007)
+	for i := 1; i <= 2; i++ {
+		end := i*MFT_SECTOR_SIZE - 2
+		copy(rec[48+i*2:], rec[end:end+2])
+		le.PutUint16(rec[end:], 0x0007)
+	}
+	return rec
+}
+
+func buildTestMFT(files []testMFTFile) []byte {
+	var records uint64
+	for _, f := range files {
+		records = max(records, f.record+1)
+	}
+	data := make([]byte, records*MFT_RECORD_SIZE)
+	for _, f := range files {
+		copy(data[f.record*MFT_RECORD_SIZE:], buildTestMFTRecord(f))
+	}
+	return data
+}
+
+func TestReadMFT(t *testing.T) {
+	t0 := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	files := []testMFTFile{
+		{record: 5, parent: 5, seq: 5, parentSeq: 5, name: ".", inUse: true, dir: true, si: t0, fn: t0},
+		{record: 30, parent: 5, seq: 1, parentSeq: 5, name: "Users", inUse: true, dir: true, si: t0, fn: t0},
+		{record: 31, parent: 30, seq: 2, parentSeq: 1, name: "notes.txt", inUse: true, si: t0, fn: t0,
+			data: []byte("secret"), ads: map[string][]byte{"Zone.Identifier": []byte("[ZoneTransfer]\r\nZoneId=3\r\n")}},
+		// Удалённый каталог (номер последовательности увеличен) и удалённый файл в нём
+		{record: 32, parent: 30, seq: 4, parentSeq: 1, name: "tools", dir: true, si: t0, fn: t0},
+		{record: 33, parent: 32, seq: 2, parentSeq: 3, name: "mimikatz.exe", si: t0, fn: t0, nonResidentSize: 1250000},
+		// Подделанные метки: $SI раньше $FN
+		{record: 34, parent: 30, seq: 1, parentSeq: 1, name: "backdoor.dll", inUse: true, si: t0.AddDate(-3, 0, 0), fn: t0, nonResidentSize: 4096},
+		// Родительский каталог использован повторно
+		{record: 35, parent: 30, seq: 1, parentSeq: 9, name: "lost.txt", si: t0, fn: t0},
+	}
+	table, err := ReadMFT(bytes.NewReader(buildTestMFT(files)), "C:")
+	if !assert.NoError(t, err) {
+		return
+	}
+	byRecord := make(map[uint64]*MFTEntry)
+	for _, e := range table.Entries {
+		byRecord[e.Record] = e
+		assert.False(t, e.Corrupted, e.Path)
+	}
+	assert.Len(t, table.Entries, len(files))
+	assert.Equal(t, `C:\`, byRecord[5].Path)
+	assert.Equal(t, `C:\Users\notes.txt`, byRecord[31].Path)
+	assert.Equal(t, `C:\Users\tools\mimikatz.exe`, byRecord[33].Path)
+	assert.False(t, byRecord[33].InUse)
+	assert.False(t, byRecord[33].Orphan)
+	assert.Equal(t, `C:\$OrphanFiles\lost.txt`, byRecord[35].Path)
+	assert.True(t, byRecord[35].Orphan)
+	assert.True(t, byRecord[34].Timestomped())
+	assert.False(t, byRecord[31].Timestomped())
+
+	m := byRecord[31].AsDict(`C:\$MFT`)
+	file := m["file"].(map[string]interface{})
+	assert.Equal(t, `C:\Users\notes.txt`, file["path"])
+	assert.Equal(t, "notes.txt", file["name"])
+	assert.Equal(t, "31-2", file["inode"])
+	assert.Equal(t, uint64(6), file["size"])
+	assert.Equal(t, "2024-05-06T07:08:09Z", file["created"])
+	assert.Equal(t, []string{"archive"}, file["attributes"])
+	mft := m["mft"].(map[string]interface{})
+	assert.Equal(t, []byte("secret"), mft["resident_data"])
+	assert.Equal(t, []map[string]interface{}{{"name": "Zone.Identifier", "size": uint64(26), "resident": true,
+		"data": []byte("[ZoneTransfer]\r\nZoneId=3\r\n")}}, mft["streams"])
+	assert.Equal(t, uint64(4096), mft["standard_information"].(map[string]interface{})["usn"])
+
+	m = byRecord[33].AsDict(`C:\$MFT`)
+	assert.Equal(t, uint64(1250000), m["file"].(map[string]interface{})["size"])
+	assert.Equal(t, false, m["mft"].(map[string]interface{})["in_use"])
+	assert.NotContains(t, m["mft"], "resident_data")
+	assert.Equal(t, true, byRecord[34].AsDict(`C:\$MFT`)["mft"].(map[string]interface{})["timestomped"])
+}
+
+func TestParseMFTRecordFixup(t *testing.T) {
+	t0 := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	rec := buildTestMFTRecord(testMFTFile{record: 40, parent: 5, seq: 1, parentSeq: 5, name: "a.txt", inUse: true, si: t0, fn: t0})
+	e, err := ParseMFTRecord(40, rec)
+	assert.NoError(t, err)
+	assert.False(t, e.Corrupted)
+	assert.Equal(t, "a.txt", e.FileName().Name)
+
+	// Сектор, не совпадающий с массивом исправлений, — запись недописана
+	binary.LittleEndian.PutUint16(rec[2*MFT_SECTOR_SIZE-2:], 0x1234)
+	e, err = ParseMFTRecord(40, rec)
+	assert.NoError(t, err)
+	assert.True(t, e.Corrupted)
+
+	_, err = ParseMFTRecord(41, make([]byte, MFT_RECORD_SIZE))
+	assert.Error(t, err)
+}
+
+func TestMFTParser(t *testing.T) {
+	p, err := newMFTParser(parserOptions{})
+	assert.NoError(t, err)
+	assert.True(t, p.Match(`C:\$MFT`))
+	assert.False(t, p.Match(`C:\$MFTMirr`))
+
+	t0 := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	data := buildTestMFT([]testMFTFile{
+		{record: 5, parent: 5, seq: 5, parentSeq: 5, name: ".", inUse: true, dir: true, si: t0, fn: t0},
+		{record: 30, parent: 5, seq: 1, parentSeq: 5, name: "evil.exe", si: t0.AddDate(-1, 0, 0), fn: t0, nonResidentSize: 10},
+	})
+	var lines []byte
+	assert.NoError(t, p.Parse(`C:\$MFT`, bytes.NewReader(data), func(record map[string]interface{}) error {
+		record["labels"] = map[string]string{"artifact": "NTFSMFTFiles"}
+		b, err := json.Marshal(record)
+		lines = append(append(lines, b...), '\n')
+		return err
+	}))
+
+	// Записи разборщика попадают во временную шкалу: метки $SI и $FN удалённого файла
+	var events []*TimelineEvent
+	convert := mftTimelineEvents(&events)
+	for _, line := range bytes.Split(bytes.TrimSpace(lines), []byte("\n")) {
+		assert.NoError(t, convert(line))
+	}
+	var paths []string
+	for _, ev := range events {
+		paths = append(paths, ev.Path)
+		assert.Equal(t, "NTFSMFTFiles", ev.Artifact)
+	}
+	assert.Equal(t, []string{`C:\`, `C:\ ($FILE_NAME)`, `C:\evil.exe (deleted)`, `C:\evil.exe (deleted) ($FILE_NAME)`}, paths)
+	assert.Equal(t, t0.AddDate(-1, 0, 0), events[2].Time)
+	assert.Equal(t, "macb", events[2].MACB)
+}
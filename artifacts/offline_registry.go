@@ -0,0 +1,560 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Расположение файлов кустов на томе Windows (пути без учёта регистра). Журналы транзакций
+// лежат рядом с кустом с суффиксами .LOG, .LOG1 и .LOG2.
+var (
+	systemHivePattern  = regexp.MustCompile(`(?i)(?:^|/)windows/system32/config/(system|software|sam|security|default)(\.log[12]?)?$`)
+	ntuserHivePattern  = regexp.MustCompile(`(?i)(?:^|/)(?:users|documents and settings)/([^/]+)/ntuser\.dat(\.log[12]?)?$`)
+	classesHivePattern = regexp.MustCompile(`(?i)(?:^|/)(?:users|documents and settings)/([^/]+)/(?:appdata/local|local settings/application data)/microsoft/windows/usrclass\.dat(\.log[12]?)?$`)
+)
+
+// hiveDirTemplates — каталоги, в которых ищутся кусты при обходе смонтированного тома.
+var hiveDirTemplates = [][]string{
+	{"windows", "system32", "config"},
+	{"users", "*", "appdata", "local", "microsoft", "windows"},
+	{"documents and settings", "*", "local settings", "application data", "microsoft", "windows"},
+}
+
+// offlineUser — кусты одного пользователя. sid берётся из ProfileList куста SOFTWARE,
+// а если профиль не найден, вместо него используется имя каталога пользователя.
+type offlineUser struct {
+	name    string
+	sid     string
+	ntuser  *Hive
+	classes *Hive
+}
+
+// offlineHiveSet — кусты одной системы, найденные на томе или в хранилище сбора.
+type offlineHiveSet struct {
+	machine    map[string]*Hive // SYSTEM, SOFTWARE, SAM, SECURITY
+	defaultHKU *Hive
+	users      []*offlineUser
+	controlSet string
+}
+
+// offlineHiveFile — содержимое файла куста и его журналов транзакций.
+type offlineHiveFile struct {
+	path string
+	data []byte
+	logs [][]byte
+}
+
+// classifyHiveFile определяет по пути, является ли файл кустом или его журналом.
+// Возвращает идентификатор куста (system, ntuser:<пользователь> и т.п.) и признак журнала.
+func classifyHiveFile(name string) (string, bool, bool) {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if m := systemHivePattern.FindStringSubmatch(name); m != nil {
+		return strings.ToLower(m[1]), m[2] != "", true
+	}
+	if m := ntuserHivePattern.FindStringSubmatch(name); m != nil {
+		return "ntuser:" + m[1], m[2] != "", true
+	}
+	if m := classesHivePattern.FindStringSubmatch(name); m != nil {
+		return "usrclass:" + m[1], m[2] != "", true
+	}
+	return "", false, false
+}
+
+// hiveDirAllowed сообщает, может ли каталог rel (относительно корня тома) содержать кусты.
+// Допускается один дополнительный ведущий компонент (например, каталог буквы диска в хранилище dir).
+func hiveDirAllowed(rel string) bool {
+	if rel == "." || rel == "" {
+		return true
+	}
+	parts := strings.Split(strings.ToLower(filepath.ToSlash(rel)), "/")
+	for skip := 0; skip <= 1 && skip < len(parts); skip++ {
+		rest := parts[skip:]
+	next:
+		for _, tmpl := range hiveDirTemplates {
+			if len(rest) > len(tmpl) {
+				continue
+			}
+			for i, p := range rest {
+				if tmpl[i] != "*" && tmpl[i] != p {
+					continue next
+				}
+			}
+			return true
+		}
+	}
+	return len(parts) == 1
+}
+
+// loadOfflineHives находит и разбирает кусты в каталоге root: смонтированном томе Windows
+// или каталоге результатов предыдущего сбора (хранилище zip, tar или dir).
+func loadOfflineHives(root string) (*offlineHiveSet, error) {
+	st, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	files := make(map[string]*offlineHiveFile)
+	add := func(name string, r io.Reader) error {
+		id, isLog, ok := classifyHiveFile(name)
+		if !ok {
+			return nil
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		hf := files[strings.ToLower(id)]
+		if hf == nil {
+			hf = &offlineHiveFile{}
+			files[strings.ToLower(id)] = hf
+		}
+		if isLog {
+			hf.logs = append(hf.logs, data)
+		} else if hf.data == nil {
+			hf.path, hf.data = name, data
+		} else {
+			logger.Log(LevelWarning, fmt.Sprintf("Skipping duplicate registry hive %s (using %s)", name, hf.path))
+		}
+		return nil
+	}
+
+	if hostname, err := findOutputHostname(root); err == nil {
+		archive, err := openArchiveReader(root, hostname)
+		if err != nil {
+			return nil, err
+		}
+		if archive != nil {
+			err = archive.Walk(func(name string, size int64, r io.Reader) error { return add(name, r) })
+			archive.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				// Недоступные каталоги тома не мешают поиску остальных кустов
+				return nil
+			}
+			rel, _ := filepath.Rel(root, path)
+			if entry.IsDir() {
+				if !hiveDirAllowed(rel) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Failed to open registry hive %s: %v", path, err))
+				return nil
+			}
+			defer f.Close()
+			return add(filepath.ToSlash(rel), f)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newOfflineHiveSet(files), nil
+}
+
+// newOfflineHiveSet разбирает найденные файлы кустов, применяя журналы транзакций.
+func newOfflineHiveSet(files map[string]*offlineHiveFile) *offlineHiveSet {
+	set := &offlineHiveSet{machine: make(map[string]*Hive), controlSet: "ControlSet001"}
+	users := make(map[string]*offlineUser)
+	ids := make([]string, 0, len(files))
+	for id := range files {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		hf := files[id]
+		if hf.data == nil {
+			continue
+		}
+		hive, err := ParseHive(hf.data, hf.logs...)
+		if err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Failed to parse registry hive %s: %v", hf.path, err))
+			continue
+		}
+		if hive.Replayed > 0 {
+			logger.Log(LevelInfo, fmt.Sprintf("Applied %d transaction log entries to %s", hive.Replayed, hf.path))
+		}
+		kind, user, _ := strings.Cut(id, ":")
+		switch kind {
+		case "ntuser", "usrclass":
+			// Имя каталога берём из исходного пути, чтобы сохранить регистр
+			if m := ntuserHivePattern.FindStringSubmatch(hf.path); m != nil {
+				user = m[1]
+			} else if m := classesHivePattern.FindStringSubmatch(hf.path); m != nil {
+				user = m[1]
+			}
+			u := users[strings.ToLower(user)]
+			if u == nil {
+				u = &offlineUser{name: user, sid: user}
+				users[strings.ToLower(user)] = u
+				set.users = append(set.users, u)
+			}
+			if kind == "ntuser" {
+				u.ntuser = hive
+			} else {
+				u.classes = hive
+			}
+		case "default":
+			set.defaultHKU = hive
+		default:
+			set.machine[strings.ToUpper(kind)] = hive
+		}
+	}
+	set.resolveProfiles(users)
+	set.resolveControlSet()
+	return set
+}
+
+// resolveProfiles сопоставляет каталоги пользователей с SID по ProfileList куста SOFTWARE.
+func (s *offlineHiveSet) resolveProfiles(users map[string]*offlineUser) {
+	software := s.machine["SOFTWARE"]
+	if software == nil {
+		return
+	}
+	root, err := software.Root()
+	if err != nil {
+		return
+	}
+	list, _ := root.Open(`Microsoft\Windows NT\CurrentVersion\ProfileList`)
+	if list == nil {
+		return
+	}
+	profiles, _ := list.Subkeys()
+	for _, profile := range profiles {
+		v, _ := profile.Value("ProfileImagePath")
+		if v == nil {
+			continue
+		}
+		path, ok := v.Decoded().(string)
+		if !ok {
+			continue
+		}
+		name := path[strings.LastIndexAny(path, `\/`)+1:]
+		if u := users[strings.ToLower(name)]; u != nil {
+			u.sid = profile.Name
+		}
+	}
+}
+
+// resolveControlSet определяет набор управления, на который указывает CurrentControlSet.
+func (s *offlineHiveSet) resolveControlSet() {
+	system := s.machine["SYSTEM"]
+	if system == nil {
+		return
+	}
+	root, err := system.Root()
+	if err != nil {
+		return
+	}
+	sel, _ := root.Open("Select")
+	if sel == nil {
+		return
+	}
+	if v, _ := sel.Value("Current"); v != nil {
+		if n, ok := v.Decoded().(uint64); ok && n > 0 {
+			s.controlSet = fmt.Sprintf("ControlSet%03d", n)
+		}
+	}
+}
+
+// Len возвращает число разобранных кустов.
+func (s *offlineHiveSet) Len() int {
+	n := len(s.machine)
+	if s.defaultHKU != nil {
+		n++
+	}
+	for _, u := range s.users {
+		if u.ntuser != nil {
+			n++
+		}
+		if u.classes != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// offlineRoot — ключ куста, соответствующий началу пути ключа источника.
+type offlineRoot struct {
+	path string
+	key  *RegKey
+	rest []string
+}
+
+// resolve сопоставляет полный путь ключа (HKEY_...\...) с ключами кустов. Переменные %%...%%
+// в имени пользователя HKEY_USERS соответствуют всем пользователям, найденным на томе.
+func (s *offlineHiveSet) resolve(fullKey string) []offlineRoot {
+	var parts []string
+	for _, p := range strings.Split(fullKey, `\`) {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	hive := strings.ToUpper(parts[0])
+	if full, ok := registryHiveAliases[hive]; ok {
+		hive = full
+	}
+	var roots []offlineRoot
+	addRoot := func(path string, h *Hive, rest []string) {
+		if h == nil {
+			return
+		}
+		if key, err := h.Root(); err == nil {
+			roots = append(roots, offlineRoot{path: path, key: key, rest: rest})
+		}
+	}
+
+	switch hive {
+	case "HKEY_LOCAL_MACHINE":
+		if len(parts) < 2 {
+			return nil
+		}
+		names := make([]string, 0, len(s.machine))
+		for name := range s.machine {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !matchName(parts[1], name) {
+				continue
+			}
+			display := hive + `\` + parts[1]
+			if strings.ContainsAny(parts[1], "*?[") {
+				display = hive + `\` + name
+			}
+			rest := parts[2:]
+			if name == "SYSTEM" && len(rest) > 0 && strings.EqualFold(rest[0], "CurrentControlSet") {
+				// Путь сохраняется в том виде, в каком он задан в определении
+				if root, err := s.machine[name].Root(); err == nil {
+					if cs, _ := root.Subkey(s.controlSet); cs != nil {
+						roots = append(roots, offlineRoot{path: display + `\` + rest[0], key: cs, rest: rest[1:]})
+					}
+				}
+				continue
+			}
+			addRoot(display, s.machine[name], rest)
+		}
+	case "HKEY_USERS":
+		if len(parts) < 2 {
+			return nil
+		}
+		pattern := parts[1]
+		variable := strings.Contains(pattern, "%%")
+		for _, u := range s.users {
+			if variable || matchName(pattern, u.sid) || matchName(pattern, u.name) {
+				addRoot(hive+`\`+u.sid, u.ntuser, parts[2:])
+			}
+			if !variable && (matchName(pattern, u.sid+"_Classes") || matchName(pattern, u.name+"_Classes")) {
+				addRoot(hive+`\`+u.sid+"_Classes", u.classes, parts[2:])
+			}
+		}
+		if !variable && matchName(pattern, ".DEFAULT") {
+			addRoot(hive+`\.DEFAULT`, s.defaultHKU, parts[2:])
+		}
+	case "HKEY_CURRENT_USER":
+		for _, u := range s.users {
+			addRoot(`HKEY_USERS\`+u.sid, u.ntuser, parts[1:])
+		}
+	case "HKEY_CLASSES_ROOT":
+		// Общие классы хранятся в SOFTWARE\Classes
+		if software := s.machine["SOFTWARE"]; software != nil {
+			if root, err := software.Root(); err == nil {
+				if classes, _ := root.Subkey("Classes"); classes != nil {
+					roots = append(roots, offlineRoot{path: hive, key: classes, rest: parts[1:]})
+				}
+			}
+		}
+	}
+	return roots
+}
+
+// offlineVisit — ключ куста (смещение ячейки nk) вместе с числом оставшихся компонентов пути.
+type offlineVisit struct {
+	offset uint32
+	rest   int
+}
+
+// walkOfflineKey обходит ключи, соответствующие оставшимся компонентам пути: "**" —
+// все вложенные ключи, компоненты с * ? [ — сопоставление имён без учёта регистра.
+// В исправном кусте у каждого ключа один родитель, поэтому повторная встреча ключа с тем же
+// остатком пути означает петлю или повтор в списке подключей и пропускается: иначе "**"
+// обходит такие ключи экспоненциально.
+func walkOfflineKey(key *RegKey, path string, rest []string, depth int, seen map[offlineVisit]bool, fn func(path string, key *RegKey)) {
+	visit := offlineVisit{offset: key.Offset, rest: len(rest)}
+	if seen[visit] {
+		return
+	}
+	seen[visit] = true
+	if len(rest) == 0 {
+		fn(path, key)
+		return
+	}
+	if depth > REGF_MAX_DEPTH {
+		return
+	}
+	part := rest[0]
+	switch {
+	case part == "**":
+		subs, err := key.Subkeys()
+		if err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Failed to read subkeys of %s: %v", path, err))
+		}
+		for _, sub := range subs {
+			subPath := path + `\` + sub.Name
+			walkOfflineKey(sub, subPath, rest[1:], depth+1, seen, fn)
+			walkOfflineKey(sub, subPath, rest, depth+1, seen, fn)
+		}
+	case strings.ContainsAny(part, "*?["):
+		subs, err := key.Subkeys()
+		if err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Failed to read subkeys of %s: %v", path, err))
+		}
+		for _, sub := range subs {
+			if matchName(part, sub.Name) {
+				walkOfflineKey(sub, path+`\`+sub.Name, rest[1:], depth+1, seen, fn)
+			}
+		}
+	default:
+		sub, err := key.Subkey(part)
+		if err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Failed to read subkeys of %s: %v", path, err))
+		}
+		if sub != nil {
+			walkOfflineKey(sub, path+`\`+part, rest[1:], depth+1, seen, fn)
+		}
+	}
+}
+
+// lookup вызывает fn для каждого ключа кустов, соответствующего шаблону fullKey.
+func (s *offlineHiveSet) lookup(fullKey string, fn func(path string, key *RegKey)) {
+	for _, root := range s.resolve(fullKey) {
+		walkOfflineKey(root.key, root.path, root.rest, 0, make(map[offlineVisit]bool), fn)
+	}
+}
+
+// ----------------------------------------------------------------------
+// OfflineRegistryCollector – сбор данных реестра из файлов кустов
+// ----------------------------------------------------------------------
+
+// OfflineRegistryCollector отвечает на источники REGISTRY_KEY и REGISTRY_VALUE по файлам
+// кустов смонтированного образа или предыдущего сбора, не обращаясь к API Windows.
+type OfflineRegistryCollector struct {
+	sets   []*offlineHiveSet
+	keys   []map[string]string
+	values []map[string]string
+}
+
+// NewOfflineRegistryCollector находит кусты в каждом из каталогов paths.
+func NewOfflineRegistryCollector(paths []string) (*OfflineRegistryCollector, error) {
+	rc := &OfflineRegistryCollector{keys: make([]map[string]string, 0), values: make([]map[string]string, 0)}
+	for _, p := range paths {
+		set, err := loadOfflineHives(p)
+		if err != nil {
+			return nil, err
+		}
+		if set.Len() == 0 {
+			logger.Log(LevelWarning, fmt.Sprintf("No registry hives found in %s", p))
+			continue
+		}
+		logger.Log(LevelInfo, fmt.Sprintf("Found %d registry hives in %s", set.Len(), p))
+		rc.sets = append(rc.sets, set)
+	}
+	if len(rc.sets) == 0 {
+		return nil, fmt.Errorf("no registry hives found in %s", strings.Join(paths, ", "))
+	}
+	return rc, nil
+}
+
+// RegisterSource регистрирует ключи и значения источника. Переменные хоста не подставляются:
+// они описывают систему, на которой выполняется сбор, а не исследуемый образ.
+func (rc *OfflineRegistryCollector) RegisterSource(def *ArtifactDefinition, src *Source, vars *HostVariables) bool {
+	switch src.TypeIndicator {
+	case TYPE_INDICATOR_WINDOWS_REGISTRY_KEY:
+		if raw, ok := src.Attributes["keys"].([]interface{}); ok {
+			for _, item := range raw {
+				if ks, ok := item.(string); ok {
+					rc.keys = append(rc.keys, map[string]string{"artifact": def.Name, "key": ks})
+				}
+			}
+		}
+		return true
+	case TYPE_INDICATOR_WINDOWS_REGISTRY_VALUE:
+		if raw, ok := src.Attributes["key_value_pairs"].([]interface{}); ok {
+			for _, item := range raw {
+				if kv, ok := item.(map[string]interface{}); ok {
+					if keyStr, ok := kv["key"].(string); ok {
+						valStr, _ := kv["value"].(string)
+						rc.values = append(rc.values, map[string]string{"artifact": def.Name, "key": keyStr, "value": valStr})
+					}
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (rc *OfflineRegistryCollector) Collect(output *Outputs) {
+	// ключи
+	for _, e := range rc.keys {
+		found := false
+		for _, set := range rc.sets {
+			set.lookup(e["key"], func(path string, key *RegKey) {
+				found = true
+				values, err := key.Values()
+				if err != nil {
+					logger.Log(LevelWarning, fmt.Sprintf("Failed to read values of %s: %v", path, err))
+				}
+				for _, v := range values {
+					output.AddCollectedRegistryValue(e["artifact"], path, v.Name, v.Decoded(), v.TypeName(), key.LastWrite)
+				}
+			})
+		}
+		if !found {
+			output.AddCollectionError(e["artifact"], TYPE_INDICATOR_WINDOWS_REGISTRY_KEY, e["key"], REASON_NOT_FOUND, nil)
+		}
+	}
+	// значения
+	for _, e := range rc.values {
+		found := false
+		for _, set := range rc.sets {
+			set.lookup(e["key"], func(path string, key *RegKey) {
+				v, err := key.Value(e["value"])
+				if err != nil {
+					logger.Log(LevelWarning, fmt.Sprintf("Failed to read values of %s: %v", path, err))
+				}
+				if v != nil {
+					found = true
+					output.AddCollectedRegistryValue(e["artifact"], path, e["value"], v.Decoded(), v.TypeName(), key.LastWrite)
+				}
+			})
+		}
+		if !found {
+			output.AddCollectionError(e["artifact"], TYPE_INDICATOR_WINDOWS_REGISTRY_VALUE,
+				e["key"]+`\`+e["value"], REASON_NOT_FOUND, nil)
+		}
+	}
+}
+
+// ensure OfflineRegistryCollector implements AbstractCollector
+var _ AbstractCollector = (*OfflineRegistryCollector)(nil)
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestVolume раскладывает по root синтетические кусты SYSTEM, SOFTWARE и NTUSER.DAT.
+func writeTestVolume(t *testing.T, root string) {
+	lw := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	b := newTestHive()
+	evil := b.key("Evil", lw, nil, []uint32{b.value("ImagePath", REG_EXPAND_SZ, utf16z(`C:\ProgramData\evil.exe`))}, false)
+	services := b.key("Services", lw, []uint32{evil}, nil, false)
+	cs1 := b.key("ControlSet001", lw, nil, nil, false)
+	cs2 := b.key("ControlSet002", lw, []uint32{services}, nil, false)
+	sel := b.key("Select", lw, nil, []uint32{b.value("Current", REG_DWORD, []byte{2, 0, 0, 0})}, false)
+	system := b.file(b.key("ROOT", lw, []uint32{cs1, cs2, sel}, nil, false), 1, 1)
+
+	b = newTestHive()
+	profile := b.key("S-1-5-21-1-1001", lw, nil, []uint32{b.value("ProfileImagePath", REG_EXPAND_SZ, utf16z(`C:\Users\alice`))}, false)
+	key := b.key("ProfileList", lw, []uint32{profile}, nil, false)
+	for _, name := range []string{"CurrentVersion", "Windows NT", "Microsoft"} {
+		key = b.key(name, lw, []uint32{key}, nil, false)
+	}
+	exe := b.key(".exe", lw, nil, []uint32{b.value("", REG_SZ, utf16z("exefile"))}, false)
+	classes := b.key("Classes", lw, []uint32{exe}, nil, false)
+	software := b.file(b.key("ROOT", lw, []uint32{key, classes}, nil, false), 1, 1)
+
+	files := map[string][]byte{
+		"Windows/System32/config/SYSTEM":   system,
+		"Windows/System32/config/SOFTWARE": software,
+		"Users/alice/NTUSER.DAT":           buildRunHive(`C:\Users\alice\updater.exe`),
+		"Users/bob/ntuser.dat":             buildRunHive(`C:\Users\bob\updater.exe`),
+		"Users/bob/Documents/NTUSER.DAT":   []byte("not in a hive location"),
+	}
+	for name, data := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+	}
+}
+
+func TestHiveDirAllowed(t *testing.T) {
+	for _, dir := range []string{"Windows", "Windows/System32/config", "Users/alice", "C", "C/Users/alice/AppData/Local", "Documents and Settings/bob"} {
+		assert.True(t, hiveDirAllowed(dir), dir)
+	}
+	for _, dir := range []string{"Windows/WinSxS", "Users/alice/Documents", "Program Files/App", "C/Windows/Temp"} {
+		assert.False(t, hiveDirAllowed(dir), dir)
+	}
+	id, isLog, ok := classifyHiveFile(`C/Users/Alice/AppData/Local/Microsoft/Windows/UsrClass.dat.LOG1`)
+	assert.True(t, ok)
+	assert.True(t, isLog)
+	assert.Equal(t, "usrclass:Alice", id)
+}
+
+func TestOfflineRegistryCollector(t *testing.T) {
+	volume := t.TempDir()
+	writeTestVolume(t, volume)
+
+	rc, err := NewOfflineRegistryCollector([]string{volume})
+	assert.NoError(t, err)
+	if !assert.Len(t, rc.sets, 1) {
+		return
+	}
+	assert.Equal(t, 4, rc.sets[0].Len())
+	assert.Equal(t, "ControlSet002", rc.sets[0].controlSet)
+
+	def := &ArtifactDefinition{Name: "Offline"}
+	assert.True(t, rc.RegisterSource(def, &Source{TypeIndicator: TYPE_INDICATOR_WINDOWS_REGISTRY_KEY, Attributes: map[string]interface{}{
+		"keys": []interface{}{`HKEY_LOCAL_MACHINE\System\CurrentControlSet\Services\*`, `HKEY_CLASSES_ROOT\.exe`, `HKEY_LOCAL_MACHINE\Software\Absent`},
+	}}, nil))
+	assert.True(t, rc.RegisterSource(def, &Source{TypeIndicator: TYPE_INDICATOR_WINDOWS_REGISTRY_VALUE, Attributes: map[string]interface{}{
+		"key_value_pairs": []interface{}{map[string]interface{}{"key": `HKEY_USERS\%%users.sid%%\Software\Run`, "value": "updater"}},
+	}}, nil))
+	assert.False(t, rc.RegisterSource(def, &Source{TypeIndicator: TYPE_INDICATOR_FILE}, nil))
+
+	out, err := NewOutputs(t.TempDir(), "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Collect(out)
+	assert.NoError(t, out.Close())
+
+	got := map[string]interface{}{}
+	lastWrite := map[string]interface{}{}
+	err = readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-registry.jsonl", out.hostname)), func(line []byte) error {
+		var rec streamRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		var payload map[string]interface{}
+		if err := json.Unmarshal(rec.Payload, &payload); err != nil {
+			return err
+		}
+		got[rec.Source+"|"+payload["name"].(string)] = payload["value"]
+		lastWrite[rec.Source] = payload["last_write"]
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		`HKEY_LOCAL_MACHINE\System\CurrentControlSet\Services\Evil|ImagePath`: `C:\ProgramData\evil.exe`,
+		`HKEY_CLASSES_ROOT\.exe|`:                         "exefile",
+		`HKEY_USERS\S-1-5-21-1-1001\Software\Run|updater`: `C:\Users\alice\updater.exe`,
+		`HKEY_USERS\bob\Software\Run|updater`:             `C:\Users\bob\updater.exe`,
+	}, got)
+	assert.Equal(t, "2024-03-02T10:00:00Z", lastWrite[`HKEY_USERS\bob\Software\Run`])
+
+	var missing []string
+	assert.NoError(t, readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-errors.jsonl", out.hostname)), func(line []byte) error {
+		var rec CollectionErrorRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		missing = append(missing, rec.Path)
+		return nil
+	}))
+	assert.Equal(t, []string{`HKEY_LOCAL_MACHINE\Software\Absent`}, missing)
+}
+
+func TestWalkOfflineKeyLoops(t *testing.T) {
+	// Каждый ключ цепочки дважды указан в списке родителя, а Target ссылается обратно на корень
+	b := newTestHive()
+	lw := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	target := b.key("Target", lw, nil, nil, false)
+	next := target
+	path := `\Target`
+	for i := 39; i >= 0; i-- {
+		name := fmt.Sprintf("K%d", i)
+		next = b.key(name, lw, []uint32{next, next}, nil, false)
+		path = `\` + name + path
+	}
+	rootOff := b.key("ROOT", lw, []uint32{next}, nil, false)
+	file := b.file(rootOff, 1, 1)
+	setTestSubkeyList(file, target, 1, binary.LittleEndian.Uint32(file[REGF_BASE_BLOCK_SIZE+rootOff+4+28:]))
+
+	h, err := ParseHive(file)
+	assert.NoError(t, err)
+	root, err := h.Root()
+	assert.NoError(t, err)
+
+	done := make(chan []string, 1)
+	go func() {
+		var found []string
+		walkOfflineKey(root, "ROOT", []string{"**", "Target"}, 0, make(map[offlineVisit]bool), func(p string, _ *RegKey) {
+			found = append(found, p)
+		})
+		done <- found
+	}()
+	select {
+	case found := <-done:
+		assert.Equal(t, []string{"ROOT" + path}, found)
+	case <-time.After(5 * time.Second):
+		t.Fatal("повторяющиеся ключи обходятся экспоненциально")
+	}
+}
+
+func TestLoadOfflineHivesFromOutput(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "20240310120000-host")
+	writeTestVolume(t, filepath.Join(dir, "host-files", "C"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "host-file_info.jsonl"), nil, 0644))
+
+	set, err := loadOfflineHives(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, set.Len())
+	var found []string
+	set.lookup(`HKU\*\Software\Run`, func(path string, key *RegKey) { found = append(found, path) })
+	assert.ElementsMatch(t, []string{`HKEY_USERS\S-1-5-21-1-1001\Software\Run`, `HKEY_USERS\bob\Software\Run`}, found)
+
+	_, err = NewOfflineRegistryCollector([]string{t.TempDir()})
+	assert.Error(t, err)
+}
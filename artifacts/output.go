@@ -1,8 +1,8 @@
 package main
 
 import (
-	"archive/zip"
 	"crypto/sha256"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"hash"
@@ -49,32 +49,63 @@ func normalizeFilepath(pathStr string) string {
 
 // Outputs собирает результаты артефактов, такие как файлы, команды, WMI и реестр.
 type Outputs struct {
-	dirpath    string
-	hostname   string
-	zipFile    *os.File
-	zipWriter  *zip.Writer
-	addedFiles map[string]bool
+	dirpath       string
+	hostname      string
+	archiveFormat string
+	archive       archiveWriter
+	addedFiles    map[string]bool
+	skippedFiles  map[string]bool // известные хорошие файлы, не попавшие в архив
 
 	maxsize int64
 	sha256  bool
 
-	commands map[string]map[string]string
-	wmi      map[string]map[string]json.RawMessage
-	registry map[string]map[string]map[string]interface{}
-
-	fileInfoFile *os.File
-	logFile      *os.File
-
-	analysis      bool
-	apiKey        string
-	analysisQueue *AnalysisQueue
+	// Результаты команд, WMI и реестра пишутся потоково в JSONL по мере получения;
+	// при aggregate в Close из них дополнительно собираются прежние сводные JSON.
+	commands  *jsonlWriter
+	wmi       *jsonlWriter
+	registry  *jsonlWriter
+	aggregate bool
+
+	fileInfo *jsonlWriter
+	errors   *jsonlWriter
+	matches  *jsonlWriter
+	logFile  *os.File
+
+	analysis        bool
+	analysisQueue   *AnalysisQueue
+	analysisResults string
+	analysisMode    string
+	analysisTimeout time.Duration
+
+	// Локальные наборы хешей; при skipKnownGood известные хорошие файлы не архивируются.
+	hashSet       *HashSet
+	skipKnownGood bool
+
+	// Правила проверки содержимого файлов (подмножество YARA).
+	rules *RuleSet
+
+	// Доверенные корни для проверки подписей Authenticode; nil — только проверка целостности.
+	trustedRoots *x509.CertPool
+
+	// Нечёткие хеши, добавляемые в file.hash записей file_info.
+	fuzzyHashes []string
+
+	// Разборщики собранных файлов и их файлы записей <hostname>-<parser>.jsonl.
+	parsers []ArtifactParser
+	parsed  *parserWriters
+
+	// Статистика сбора и параметры итогового отчёта report.html / report.md.
+	stats        *collectionStats
+	report       bool
+	reportConfig map[string]string
 }
 
 // NewOutputs создаёт новый экземпляр Outputs.
 // dirpath – путь к каталогу для результатов,
 // maxsizeStr – максимально допустимый размер файла (например, "50M"),
-// sha256 – вычислять ли SHA-256 для собираемых файлов.
-func NewOutputs(dirpath, maxsizeStr string, sha256 bool, analysis bool, apiKey string) (*Outputs, error) {
+// sha256 – вычислять ли SHA-256 для собираемых файлов,
+// analysis – включён ли анализ; источники анализа и очередь задаются через SetProviders.
+func NewOutputs(dirpath, maxsizeStr string, sha256 bool, analysis bool) (*Outputs, error) {
 	maxsize, err := parseHumanSize(maxsizeStr)
 	if err != nil {
 		return nil, err
@@ -93,31 +124,28 @@ func NewOutputs(dirpath, maxsizeStr string, sha256 bool, analysis bool, apiKey s
 	// Устанавливаем переменную окружения для COMMAND артефактов.
 	os.Setenv("FAOUTPUTDIR", finalDir)
 
-	var aq *AnalysisQueue
-	if analysis && apiKey != "" {
-		client, err := NewClient(apiKey)
-		if err != nil {
-			return nil, err
-		}
-		resultsPath := filepath.Join(finalDir, fmt.Sprintf("%s-analyse.jsonl", hostname))
-		aq, err = NewQueue(client, 100, 5, resultsPath)
-		if err != nil {
-			return nil, err
-		}
-	}
+	resultsPath := filepath.Join(finalDir, fmt.Sprintf("%s-analyse.jsonl", hostname))
 
 	o := &Outputs{
-		dirpath:       finalDir,
-		hostname:      hostname,
-		maxsize:       maxsize,
-		sha256:        sha256,
-		addedFiles:    make(map[string]bool),
-		commands:      make(map[string]map[string]string),
-		wmi:           make(map[string]map[string]json.RawMessage),
-		registry:      make(map[string]map[string]map[string]interface{}),
-		analysis:      analysis,
-		apiKey:        apiKey,
-		analysisQueue: aq,
+		dirpath:         finalDir,
+		hostname:        hostname,
+		maxsize:         maxsize,
+		sha256:          sha256,
+		addedFiles:      make(map[string]bool),
+		skippedFiles:    make(map[string]bool),
+		commands:        newJSONLWriter(filepath.Join(finalDir, fmt.Sprintf("%s-commands.jsonl", hostname))),
+		wmi:             newJSONLWriter(filepath.Join(finalDir, fmt.Sprintf("%s-wmi.jsonl", hostname))),
+		registry:        newJSONLWriter(filepath.Join(finalDir, fmt.Sprintf("%s-registry.jsonl", hostname))),
+		aggregate:       true,
+		fileInfo:        newJSONLWriter(filepath.Join(finalDir, fmt.Sprintf("%s-file_info.jsonl", hostname))),
+		errors:          newJSONLWriter(filepath.Join(finalDir, fmt.Sprintf("%s-errors.jsonl", hostname))),
+		matches:         newJSONLWriter(filepath.Join(finalDir, fmt.Sprintf("%s-matches.jsonl", hostname))),
+		analysis:        analysis,
+		analysisResults: resultsPath,
+		analysisMode:    QUEUE_MODE_SPILL,
+		analysisTimeout: DEFAULT_DRAIN_TIMEOUT,
+		stats:           newCollectionStats(),
+		report:          true,
 	}
 
 	if err := o.setupLogging(); err != nil {
@@ -126,6 +154,100 @@ func NewOutputs(dirpath, maxsizeStr string, sha256 bool, analysis bool, apiKey s
 	return o, nil
 }
 
+// SetArchiveFormat задаёт формат хранения собранных файлов: zip (по умолчанию), tar или dir.
+// Должен вызываться до добавления первого файла.
+func (o *Outputs) SetArchiveFormat(format string) error {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if !validArchiveFormat(format) {
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+	o.archiveFormat = format
+	return nil
+}
+
+// SetProviders задаёт набор источников анализа (OpenTIP, VirusTotal, MISP, JSON-сервисы),
+// результаты которых объединяются по каждому файлу в analysis.jsonl.
+// Действует только при включённом анализе; должен вызываться до начала сбора.
+func (o *Outputs) SetProviders(providers []Provider) error {
+	if !o.analysis {
+		return nil
+	}
+	if o.analysisQueue != nil {
+		if err := o.analysisQueue.Close(); err != nil {
+			return err
+		}
+		o.analysisQueue = nil
+	}
+	if len(providers) == 0 {
+		return nil
+	}
+	aq, err := NewQueue(providers, 100, 5, o.analysisResults)
+	if err != nil {
+		return err
+	}
+	o.analysisQueue = aq
+	return o.configureAnalysisQueue()
+}
+
+// SetAnalysisQueue задаёт поведение очереди анализа при переполнении (spill — выгрузка на диск,
+// block — ожидание свободного места) и время ожидания завершения проверок при закрытии Outputs.
+func (o *Outputs) SetAnalysisQueue(mode string, timeout time.Duration) error {
+	o.analysisMode = mode
+	o.analysisTimeout = timeout
+	return o.configureAnalysisQueue()
+}
+
+func (o *Outputs) configureAnalysisQueue() error {
+	if o.analysisQueue == nil {
+		return nil
+	}
+	if err := o.analysisQueue.SetMode(o.analysisMode); err != nil {
+		return err
+	}
+	o.analysisQueue.SetDrainTimeout(o.analysisTimeout)
+	return nil
+}
+
+// SetHashSet задаёт локальные наборы известных хороших и плохих хешей,
+// с которыми сопоставляется каждая запись file_info.
+func (o *Outputs) SetHashSet(hs *HashSet) {
+	o.hashSet = hs
+}
+
+// SetSkipKnownGood управляет архивированием файлов, найденных в наборах известных хороших хешей.
+func (o *Outputs) SetSkipKnownGood(skip bool) {
+	o.skipKnownGood = skip
+}
+
+// SetRules задаёт правила, которыми проверяется содержимое каждого файла при вычислении file_info;
+// сработавшие правила записываются в поле rules записи и в matches.jsonl.
+func (o *Outputs) SetRules(rules *RuleSet) {
+	o.rules = rules
+}
+
+// SetTrustedRoots задаёт доверенные корневые сертификаты, до которых строится цепочка
+// подписи Authenticode PE-файлов.
+func (o *Outputs) SetTrustedRoots(roots *x509.CertPool) {
+	o.trustedRoots = roots
+}
+
+// SetFuzzyHashes задаёт нечёткие хеши (ssdeep, tlsh) для file_info; они передаются
+// источникам анализа вместе с md5/sha1/sha256.
+func (o *Outputs) SetFuzzyHashes(kinds []string) error {
+	parsed, err := parseFuzzyHashes(kinds)
+	if err != nil {
+		return err
+	}
+	o.fuzzyHashes = parsed
+	return nil
+}
+
+// SetAggregate управляет формированием сводных commands.json, wmi.json и registry.json
+// из потоковых JSONL-файлов при закрытии Outputs.
+func (o *Outputs) SetAggregate(aggregate bool) {
+	o.aggregate = aggregate
+}
+
 // setupLogging настраивает логирование в файл и на консоль.
 func (o *Outputs) setupLogging() error {
 	logfile := filepath.Join(o.dirpath, fmt.Sprintf("%s-logs.txt", o.hostname))
@@ -144,58 +266,92 @@ func (o *Outputs) setupLogging() error {
 // FileInfo берётся из модуля file_info.go.
 func (o *Outputs) AddCollectedFileInfo(artifact string, pathObject FilePathObject) error {
 	fi := NewFileInfo(pathObject)
-	if o.maxsize == 0 || pathObject.GetSize() <= o.maxsize {
-		if o.fileInfoFile == nil {
-			fileInfoPath := filepath.Join(o.dirpath, fmt.Sprintf("%s-file_info.jsonl", o.hostname))
-			f, err := os.OpenFile(fileInfoPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-			if err != nil {
-				return err
-			}
-			o.fileInfoFile = f
-		}
+	fi.SetRules(o.rules)
+	fi.SetTrustedRoots(o.trustedRoots)
+	fi.SetFuzzyHashes(o.fuzzyHashes)
+	if o.maxsize > 0 && pathObject.GetSize() > o.maxsize {
+		o.AddCollectionError(artifact, FILE_INFO_TYPE, pathObject.GetPath(), REASON_TOO_LARGE,
+			fmt.Errorf("file size %d exceeds maxsize %d", pathObject.GetSize(), o.maxsize))
+	} else {
 		fileInfo := fi.Compute()
 		if fileInfo == nil {
-			return fmt.Errorf("failed to compute file info")
+			err := fi.Err()
+			if err == nil {
+				err = fmt.Errorf("failed to compute file info")
+			}
+			o.AddCollectionError(artifact, FILE_INFO_TYPE, pathObject.GetPath(), reasonForError(err), err)
+			return err
 		}
 		fileInfo["labels"] = map[string]string{"artifact": artifact}
-
-		b, err := json.Marshal(fileInfo)
-		if err != nil {
-			return err
+		if o.hashSet != nil {
+			if hashes, ok := fileInfo["file"].(map[string]interface{})["hash"].(map[string]string); ok {
+				match := o.hashSet.Lookup(hashes)
+				fileInfo["hashset"] = match.AsDict()
+				if match.Verdict == HASHSET_KNOWN_BAD {
+					logger.Log(LevelWarning, fmt.Sprintf("Known-bad file %s (%s, %s)", pathObject.GetPath(), match.Set, match.Label))
+				}
+			}
 		}
-		if _, err := o.fileInfoFile.Write(append(b, '\n')); err != nil {
+
+		if err := o.fileInfo.Write(fileInfo); err != nil {
+			o.AddCollectionError(artifact, FILE_INFO_TYPE, pathObject.GetPath(), REASON_WRITE_ERROR, err)
 			return err
 		}
+		o.stats.track(artifact, func(s *artifactStats) { s.FileInfos++ })
+		o.addRuleMatches(artifact, fileInfo, fi.RuleMatches())
 
 		// Фильтрация на анализ
-		if o.analysisQueue != nil {
-			// MIME-тип
-			mt, _ := fileInfo["file"].(map[string]interface{})["mime_type"].(string)
-			// Расширение файла
-			path := fileInfo["file"].(map[string]interface{})["path"].(string)
-
-			ext := strings.ToLower(filepath.Ext(path))
-
-			// Логируем их для отладки
-			logger.Log(LevelDebug, fmt.Sprintf("Analyzing candidate: path=%s, mime=%s, ext=%s", path, mt, ext))
-
-			if mt == "application/x-msdownload" || mt == "application/vnd.microsoft.portable-executable" ||
-				ext == ".exe" || ext == ".dll" || ext == ".sys" || ext == ".bin" || ext == ".sh" {
-				o.analysisQueue.Enqueue(fileInfo)
-			}
+		if o.analysisQueue != nil && isAnalysisCandidate(fileInfo) {
+			o.analysisQueue.Enqueue(fileInfo)
 		}
 	}
 	return nil
 }
 
+// addRuleMatches записывает сработавшие правила файла в matches.jsonl.
+func (o *Outputs) addRuleMatches(artifact string, fileInfo map[string]interface{}, matches []*RuleMatch) {
+	if len(matches) == 0 {
+		return
+	}
+	fileMap := fileInfo["file"].(map[string]interface{})
+	path, _ := fileMap["path"].(string)
+	for _, m := range matches {
+		logger.Log(LevelWarning, fmt.Sprintf("Rule %s matched %s", m.Rule, path))
+		if err := o.matches.Write(newRuleMatchRecord(path, fileMap["hash"], artifact, m)); err != nil {
+			logger.Log(LevelError, fmt.Sprintf("Failed to write rule match for '%s': %v", path, err))
+		}
+	}
+}
+
+// isAnalysisCandidate отбирает для анализа исполняемые файлы (PE и ELF) и скрипты по MIME-типу и расширению.
+func isAnalysisCandidate(fileInfo map[string]interface{}) bool {
+	fileMap, ok := fileInfo["file"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	// MIME-тип
+	mt, _ := fileMap["mime_type"].(string)
+	// Расширение файла
+	path, _ := fileMap["path"].(string)
+	ext := strings.ToLower(filepath.Ext(path))
+
+	// Логируем их для отладки
+	logger.Log(LevelDebug, fmt.Sprintf("Analyzing candidate: path=%s, mime=%s, ext=%s", path, mt, ext))
+
+	return mt == "application/x-msdownload" || mt == "application/vnd.microsoft.portable-executable" ||
+		mt == ELF_MIME_TYPE || ext == ".exe" || ext == ".dll" || ext == ".sys" || ext == ".bin" ||
+		ext == ".sh" || ext == ".so" || ext == ".elf"
+}
+
 // AddCollectedFile собирает содержимое файла для указанного артефакта.
-// Если файл не превышает максимально допустимый размер, он добавляется в zip-архив.
+// Если файл не превышает максимально допустимый размер, он добавляется в архив.
 func (o *Outputs) AddCollectedFile(artifact string, pathObject FilePathObject) error {
 	filePath := pathObject.GetPath()
 
 	// Проверка существования файла
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
 		logger.Log(LevelWarning, fmt.Sprintf("File not found: %s", filePath))
+		o.AddCollectionError(artifact, TYPE_INDICATOR_FILE, filePath, REASON_NOT_FOUND, err)
 		return nil
 	}
 
@@ -204,152 +360,253 @@ func (o *Outputs) AddCollectedFile(artifact string, pathObject FilePathObject) e
 		logger.Log(LevelWarning,
 			fmt.Sprintf("Skipping large file: %s (%d bytes)",
 				filePath, pathObject.GetSize()))
+		o.AddCollectionError(artifact, TYPE_INDICATOR_FILE, filePath, REASON_TOO_LARGE,
+			fmt.Errorf("file size %d exceeds maxsize %d", pathObject.GetSize(), o.maxsize))
 		return nil
 	}
 
 	// Создание архива при первом использовании
-	if o.zipWriter == nil {
-		zipPath := filepath.Join(o.dirpath, fmt.Sprintf("%s-files.zip", o.hostname))
-		f, err := os.Create(zipPath)
+	if o.archive == nil {
+		archive, err := newArchiveWriter(o.archiveFormat, o.dirpath, o.hostname)
 		if err != nil {
-			return fmt.Errorf("failed to create zip: %v", err)
+			o.AddCollectionError(artifact, TYPE_INDICATOR_FILE, filePath, REASON_WRITE_ERROR, err)
+			return err
 		}
-		o.zipFile = f
-		o.zipWriter = zip.NewWriter(f)
+		o.archive = archive
 	}
 
 	// Нормализация пути
 	filename := normalizeFilepath(filePath)
-	if _, exists := o.addedFiles[filename]; exists {
+	if o.addedFiles[filename] || o.skippedFiles[filename] {
 		return nil
 	}
 
-	// Добавление в архив
-	header := &zip.FileHeader{
-		Name:   filename,
-		Method: zip.Deflate,
-	}
-	writer, err := o.zipWriter.CreateHeader(header)
-	if err != nil {
-		return err
-	}
+	// Метаданные снимаются до чтения содержимого, иначе время доступа будет временем сбора
+	meta := getFileMetadata(pathObject)
 
-	// Чтение и запись содержимого
+	// Чтение содержимого
 	chunks, err := pathObject.ReadChunks()
 	if err != nil {
+		o.AddCollectionError(artifact, TYPE_INDICATOR_FILE, filePath, reasonForError(err), err)
 		return err
 	}
 
+	// Известные хорошие файлы (например, из NSRL) не архивируются
+	if o.skipKnownGood && o.hashSet != nil {
+		match := o.hashSet.Lookup(hashChunks(chunks, o.hashSet.Kinds()))
+		if match.Verdict == HASHSET_KNOWN_GOOD {
+			logger.Log(LevelDebug, fmt.Sprintf("Skipping known-good file %s (%s)", filePath, match.Set))
+			o.skippedFiles[filename] = true
+			o.AddCollectionError(artifact, TYPE_INDICATOR_FILE, filePath, REASON_KNOWN_GOOD,
+				fmt.Errorf("%s matched in %s", match.Hash, match.Set))
+			return nil
+		}
+	}
+
 	var hash256 hash.Hash
 	if o.sha256 {
 		hash256 = sha256.New()
-	}
-
-	for _, chunk := range chunks {
-		if _, err := writer.Write(chunk); err != nil {
-			return err
-		}
-		if o.sha256 {
+		for _, chunk := range chunks {
 			hash256.Write(chunk)
 		}
 	}
 
+	// Добавление в архив вместе с исходными временными метками, правами и владельцем
+	if err := o.archive.WriteFile(filename, meta, chunks); err != nil {
+		o.AddCollectionError(artifact, TYPE_INDICATOR_FILE, filePath, REASON_WRITE_ERROR, err)
+		return err
+	}
+
 	o.addedFiles[filename] = true
+	o.stats.addFile(artifact, filePath, pathObject.GetSize(), o.archiveLink(filename))
 	logger.Log(LevelInfo,
 		fmt.Sprintf("Added %s (%d bytes) to archive",
 			filename, pathObject.GetSize()))
 
+	o.parseCollectedFile(artifact, filePath, chunks)
+
 	return nil
 }
 
-// AddCollectedCommand собирает результат выполнения команды для указанного артефакта.
+// AddCollectionError записывает в errors.jsonl сведения о пропущенном, недоступном или
+// не собранном элементе: артефакт, тип источника, путь (команду, запрос, ключ), код причины и текст ошибки.
+func (o *Outputs) AddCollectionError(artifact, source, path, reason string, err error) {
+	o.addCollectionErrorRecord(newCollectionErrorRecord(artifact, source, path, reason, err))
+}
+
+// addCollectionErrorRecord записывает заранее сформированную запись об ошибке сбора.
+func (o *Outputs) addCollectionErrorRecord(rec *CollectionErrorRecord) {
+	o.stats.track(rec.Artifact, func(s *artifactStats) { s.Errors++ })
+	if err := o.errors.Write(rec); err != nil {
+		logger.Log(LevelError, fmt.Sprintf("Failed to write error record for '%s': %v", rec.Path, err))
+	}
+}
+
+// AddCollectedCommand записывает результат выполнения команды для указанного артефакта.
 func (o *Outputs) AddCollectedCommand(artifact, command string, output []byte) {
 	logger.Log(LevelInfo, fmt.Sprintf("Collecting command '%s' for artifact '%s'", command, artifact))
-	if o.commands[artifact] == nil {
-		o.commands[artifact] = make(map[string]string)
-	}
-	o.commands[artifact][command] = string(output)
+	o.writeStreamRecord(o.commands, artifact, command, string(output))
 }
 
-// AddCollectedWMI собирает результат WMI-запроса для указанного артефакта.
+// AddCollectedWMI записывает результат WMI-запроса для указанного артефакта.
 func (o *Outputs) AddCollectedWMI(artifact, query string, output json.RawMessage) {
 	logger.Log(LevelInfo, fmt.Sprintf("Collecting WMI query '%s' for artifact '%s'", query, artifact))
-	if o.wmi[artifact] == nil {
-		o.wmi[artifact] = make(map[string]json.RawMessage)
-	}
-	o.wmi[artifact][query] = output
+	o.writeStreamRecord(o.wmi, artifact, query, output)
 }
 
-// AddCollectedRegistryValue собирает значение реестра для указанного артефакта.
-func (o *Outputs) AddCollectedRegistryValue(artifact, key, name string, value interface{}, type_ string) {
+// AddCollectedRegistryValue записывает значение реестра для указанного артефакта.
+// lastWrite — время последней записи ключа; нулевое значение не записывается.
+func (o *Outputs) AddCollectedRegistryValue(artifact, key, name string, value interface{}, type_ string, lastWrite time.Time) {
 	logger.Log(LevelInfo, fmt.Sprintf("Collecting Reg value '%s' from '%s' for artifact '%s'", name, key, artifact))
-	if o.registry[artifact] == nil {
-		o.registry[artifact] = make(map[string]map[string]interface{})
-	}
-	if o.registry[artifact][key] == nil {
-		o.registry[artifact][key] = make(map[string]interface{})
-	}
-	o.registry[artifact][key][name] = map[string]interface{}{
+	payload := map[string]interface{}{
+		"name":  name,
 		"value": value,
 		"type":  type_,
 	}
+	if s := formatTime(lastWrite); s != "" {
+		payload["last_write"] = s
+	}
+	o.writeStreamRecord(o.registry, artifact, key, payload)
+}
+
+// writeStreamRecord сразу записывает результат в соответствующий JSONL-файл.
+func (o *Outputs) writeStreamRecord(w *jsonlWriter, artifact, source string, payload interface{}) {
+	record, err := newStreamRecord(artifact, source, payload)
+	if err == nil {
+		err = w.Write(record)
+	}
+	if err != nil {
+		logger.Log(LevelError, fmt.Sprintf("Failed to write result of '%s' for artifact '%s': %v", source, artifact, err))
+		return
+	}
+	o.stats.track(artifact, func(s *artifactStats) {
+		switch w {
+		case o.commands:
+			s.Commands++
+		case o.wmi:
+			s.WMI++
+		case o.registry:
+			s.Registry++
+		}
+	})
 }
 
-// Close завершает работу Outputs: закрывает zip-архив, записывает файлы JSON и закрывает открытые дескрипторы.
+// aggregateStream собирает сводный JSON вида {artifact: {source: value}} из потокового JSONL-файла.
+// value строит из записи функция convert.
+func aggregateStream(w *jsonlWriter, jsonPath string, convert func(rec *streamRecord, sources map[string]interface{}) error) error {
+	if w.Count() == 0 {
+		return nil
+	}
+	result := make(map[string]map[string]interface{})
+	err := readJSONL(w.path, func(line []byte) error {
+		var rec streamRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if result[rec.Artifact] == nil {
+			result[rec.Artifact] = make(map[string]interface{})
+		}
+		return convert(&rec, result[rec.Artifact])
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(jsonPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// writeAggregates формирует прежние сводные commands.json, wmi.json и registry.json.
+func (o *Outputs) writeAggregates() error {
+	var err error
+	if e := aggregateStream(o.commands, filepath.Join(o.dirpath, fmt.Sprintf("%s-commands.json", o.hostname)),
+		func(rec *streamRecord, sources map[string]interface{}) error {
+			var output string
+			if err := json.Unmarshal(rec.Payload, &output); err != nil {
+				return err
+			}
+			sources[rec.Source] = output
+			return nil
+		}); e != nil {
+		err = e
+	}
+	if e := aggregateStream(o.wmi, filepath.Join(o.dirpath, fmt.Sprintf("%s-wmi.json", o.hostname)),
+		func(rec *streamRecord, sources map[string]interface{}) error {
+			sources[rec.Source] = rec.Payload
+			return nil
+		}); e != nil {
+		err = e
+	}
+	if e := aggregateStream(o.registry, filepath.Join(o.dirpath, fmt.Sprintf("%s-registry.json", o.hostname)),
+		func(rec *streamRecord, sources map[string]interface{}) error {
+			// Значение остаётся исходным JSON: через interface{} 64-битные целые теряли бы точность
+			var value struct {
+				Name      string          `json:"name"`
+				Value     json.RawMessage `json:"value"`
+				Type      string          `json:"type"`
+				LastWrite string          `json:"last_write"`
+			}
+			if err := json.Unmarshal(rec.Payload, &value); err != nil {
+				return err
+			}
+			key, _ := sources[rec.Source].(map[string]interface{})
+			if key == nil {
+				key = make(map[string]interface{})
+				sources[rec.Source] = key
+			}
+			entry := map[string]interface{}{
+				"value": value.Value,
+				"type":  value.Type,
+			}
+			if value.LastWrite != "" {
+				entry["last_write"] = value.LastWrite
+			}
+			key[value.Name] = entry
+			return nil
+		}); e != nil {
+		err = e
+	}
+	return err
+}
+
+// Close завершает работу Outputs: закрывает архив, дожидается очереди анализа, закрывает потоковые JSONL-файлы,
+// при необходимости формирует сводные JSON и отчёт о сборе и закрывает открытые дескрипторы.
 func (o *Outputs) Close() error {
 	var err error
-	if o.zipWriter != nil {
-		if e := o.zipWriter.Close(); e != nil {
+	if o.archive != nil {
+		if e := o.archive.Close(); e != nil {
 			err = e
 		}
-		if e := o.zipFile.Close(); e != nil {
+	}
+	// Очередь анализа дожидается завершения проверок до формирования отчёта
+	if o.analysisQueue != nil {
+		if e := o.analysisQueue.Close(); e != nil {
 			err = e
 		}
 	}
-	if len(o.commands) > 0 {
-		path := filepath.Join(o.dirpath, fmt.Sprintf("%s-commands.json", o.hostname))
-		f, e := os.Create(path)
-		if e != nil {
+	for _, w := range []*jsonlWriter{o.commands, o.wmi, o.registry, o.fileInfo, o.errors, o.matches} {
+		if e := w.Close(); e != nil {
 			err = e
-		} else {
-			enc := json.NewEncoder(f)
-			enc.SetIndent("", "  ")
-			if e := enc.Encode(o.commands); e != nil {
-				err = e
-			}
-			f.Close()
 		}
 	}
-	if len(o.wmi) > 0 {
-		path := filepath.Join(o.dirpath, fmt.Sprintf("%s-wmi.json", o.hostname))
-		f, e := os.Create(path)
-		if e != nil {
+	if o.parsed != nil {
+		if e := o.parsed.Close(); e != nil {
 			err = e
-		} else {
-			enc := json.NewEncoder(f)
-			enc.SetIndent("", "  ")
-			if e := enc.Encode(o.wmi); e != nil {
-				err = e
-			}
-			f.Close()
 		}
 	}
-	if len(o.registry) > 0 {
-		path := filepath.Join(o.dirpath, fmt.Sprintf("%s-registry.json", o.hostname))
-		f, e := os.Create(path)
-		if e != nil {
+	if o.aggregate {
+		if e := o.writeAggregates(); e != nil {
 			err = e
-		} else {
-			enc := json.NewEncoder(f)
-			enc.SetIndent("", "  ")
-			if e := enc.Encode(o.registry); e != nil {
-				err = e
-			}
-			f.Close()
 		}
 	}
-	if o.fileInfoFile != nil {
-		if e := o.fileInfoFile.Close(); e != nil {
+	if o.report {
+		if e := o.writeReport(); e != nil {
+			logger.Log(LevelError, fmt.Sprintf("Failed to write collection report: %v", e))
 			err = e
 		}
 	}
@@ -1,13 +1,19 @@
 package main
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 )
 
 // FilePathObjectAdapter — адаптер для *PathObject, реализующий интерфейс FilePathObject.
@@ -79,7 +85,7 @@ func TestNormalizeFilepath(t *testing.T) {
 // TestLogging проверяет, что лог-сообщение записывается в файл.
 func TestLogging(t *testing.T) {
 	tempDir := t.TempDir()
-	out, err := NewOutputs(tempDir, "", false, false, "")
+	out, err := NewOutputs(tempDir, "", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -109,7 +115,7 @@ func TestCollectFileInfo(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	out, err := NewOutputs(tempDir, "", false, false, "")
+	out, err := NewOutputs(tempDir, "", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -197,7 +203,7 @@ func TestCollectPEFileInfo(t *testing.T) {
 		t.Skip("Файл MSVCR71.dll не найден – пропускаем тест")
 	}
 
-	out, err := NewOutputs(tempDir, "", false, false, "")
+	out, err := NewOutputs(tempDir, "", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -306,6 +312,136 @@ func TestCollectPEFileInfo(t *testing.T) {
 	}
 }
 
+// TestCollectELFFileInfo проверяет разбор ELF на примере исполняемого файла самого теста.
+func TestCollectELFFileInfo(t *testing.T) {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	head := make([]byte, 4)
+	if f, err := os.Open(exe); err == nil {
+		f.Read(head)
+		f.Close()
+	}
+	if !isELF(head) {
+		t.Skip("Исполняемый файл теста не в формате ELF – пропускаем тест")
+	}
+
+	tempDir := t.TempDir()
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewOSFileSystem("/")
+	if err := out.AddCollectedFileInfo("TestArtifact", &FilePathObjectAdapter{fs.GetFullPath(exe)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(out.dirpath, fmt.Sprintf("%s-file_info.jsonl", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record map[string]interface{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if !isAnalysisCandidate(record) {
+		t.Error("ELF-файл должен отбираться для анализа")
+	}
+	fileInfo := record["file"].(map[string]interface{})
+	if fileInfo["mime_type"] != ELF_MIME_TYPE {
+		t.Errorf("file.mime_type = %v, ожидалось %q", fileInfo["mime_type"], ELF_MIME_TYPE)
+	}
+	elfInfo, ok := fileInfo["elf"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Поле 'file.elf' отсутствует или имеет неверный формат")
+	}
+	if elfInfo["architecture"] == "" || elfInfo["class"] == "" {
+		t.Errorf("file.elf: не заполнены architecture/class: %v", elfInfo)
+	}
+	if elfInfo["type"] != "exec" && elfInfo["type"] != "dyn" {
+		t.Errorf("file.elf.type = %v, ожидалось 'exec' или 'dyn'", elfInfo["type"])
+	}
+	if _, ok := elfInfo["interpreter"]; ok == elfInfo["static"].(bool) {
+		t.Errorf("file.elf.static = %v не согласуется с interpreter = %v", elfInfo["static"], elfInfo["interpreter"])
+	}
+	sections, ok := elfInfo["sections"].([]interface{})
+	if !ok || len(sections) == 0 {
+		t.Fatal("Поле 'file.elf.sections' пустое")
+	}
+	found := false
+	for _, s := range sections {
+		sec := s.(map[string]interface{})
+		if sec["name"] == ".text" {
+			found = true
+			if e, _ := sec["entropy"].(float64); e <= 0 || e > 8 {
+				t.Errorf(".text entropy = %v, ожидалось значение в (0, 8]", sec["entropy"])
+			}
+		}
+	}
+	if !found {
+		t.Error("Секция .text не найдена")
+	}
+}
+
+func TestELFHelpers(t *testing.T) {
+	// Заметка GNU build-id: namesz=4, descsz=4, type=3, "GNU\0", описание
+	note := []byte{4, 0, 0, 0, 4, 0, 0, 0, 3, 0, 0, 0, 'G', 'N', 'U', 0, 0xde, 0xad, 0xbe, 0xef}
+	if id := findBuildIDNote(note, binary.LittleEndian); id != "deadbeef" {
+		t.Errorf("build-id = %q, ожидалось 'deadbeef'", id)
+	}
+	if id := findBuildIDNote(note[:15], binary.LittleEndian); id != "" {
+		t.Errorf("build-id усечённой заметки = %q, ожидалась пустая строка", id)
+	}
+	if elfImpHash([]string{"puts@GLIBC_2.2.5", "Exit"}) != elfImpHash([]string{"exit", "puts"}) {
+		t.Error("imphash должен не зависеть от порядка, регистра и версий символов")
+	}
+	if e := shannonEntropy([]byte("aaaa")); e != 0 {
+		t.Errorf("entropy = %v, ожидалось 0", e)
+	}
+	if e := shannonEntropy([]byte{0, 1, 2, 3}); e != 2 {
+		t.Errorf("entropy = %v, ожидалось 2", e)
+	}
+}
+
+// TestELFOversizedSegments проверяет, что размеры PT_INTERP и PT_NOTE из заголовков программы
+// не приводят к выделению памяти сверх размера файла.
+func TestELFOversizedSegments(t *testing.T) {
+	le := binary.LittleEndian
+	data := make([]byte, 64+2*56+16)
+	copy(data, "\x7fELF\x02\x01\x01")
+	le.PutUint16(data[16:], uint16(elf.ET_EXEC))
+	le.PutUint16(data[18:], uint16(elf.EM_X86_64))
+	le.PutUint32(data[20:], 1)
+	le.PutUint64(data[32:], 64) // phoff
+	le.PutUint16(data[52:], 64)
+	le.PutUint16(data[54:], 56)
+	le.PutUint16(data[56:], 2)
+	for i, typ := range []elf.ProgType{elf.PT_INTERP, elf.PT_NOTE} {
+		ph := data[64+i*56:]
+		le.PutUint32(ph, uint32(typ))
+		le.PutUint64(ph[8:], uint64(64+2*56)) // offset
+		le.PutUint64(ph[32:], 1<<40)          // filesz
+		le.PutUint64(ph[40:], 1<<40)          // memsz
+	}
+	copy(data[64+2*56:], "/lib/ld.so\x00")
+
+	ef, err := elf.NewFile(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := elfInfo(ef)
+	if info["interpreter"] != "/lib/ld.so" {
+		t.Errorf("interpreter = %v, ожидалось '/lib/ld.so'", info["interpreter"])
+	}
+	if _, ok := info["build_id"]; ok {
+		t.Errorf("build_id = %v, ожидалось отсутствие", info["build_id"])
+	}
+}
+
 // TestCollectFile проверяет, что файл добавляется в zip-архив.
 func TestCollectFile(t *testing.T) {
 	tempDir := t.TempDir()
@@ -314,7 +450,7 @@ func TestCollectFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	out, err := NewOutputs(tempDir, "", false, false, "")
+	out, err := NewOutputs(tempDir, "", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -356,7 +492,7 @@ func TestCollectFileSizeFilter(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	out, err := NewOutputs(tempDir, "15", false, false, "")
+	out, err := NewOutputs(tempDir, "15", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -402,7 +538,7 @@ func TestCollectFileSizeFilter(t *testing.T) {
 // TestCollectCommand проверяет сбор результата команды.
 func TestCollectCommand(t *testing.T) {
 	tempDir := t.TempDir()
-	out, err := NewOutputs(tempDir, "", false, false, "")
+	out, err := NewOutputs(tempDir, "", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -432,11 +568,12 @@ func TestCollectCommand(t *testing.T) {
 // TestCollectRegistry проверяет сбор значений реестра.
 func TestCollectRegistry(t *testing.T) {
 	tempDir := t.TempDir()
-	out, err := NewOutputs(tempDir, "", false, false, "")
+	out, err := NewOutputs(tempDir, "", false, false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	out.AddCollectedRegistryValue("TestArtifact", "key", "name", "value", "type")
+	out.AddCollectedRegistryValue("TestArtifact", "key", "name", "value", "type", time.Time{})
+	out.AddCollectedRegistryValue("TestArtifact", "key", "qword", uint64(18446744073709551615), "REG_QWORD", time.Time{})
 	if err := out.Close(); err != nil {
 		t.Fatal(err)
 	}
@@ -465,4 +602,424 @@ func TestCollectRegistry(t *testing.T) {
 	if entry["value"] != "value" || entry["type"] != "type" {
 		t.Errorf("Получено %v, ожидалось {value: %q, type: %q}", entry, "value", "type")
 	}
+	// 64-битные значения сохраняются без потери точности
+	if !strings.Contains(string(data), "18446744073709551615") {
+		t.Errorf("Значение REG_QWORD потеряло точность: %s", data)
+	}
+}
+
+// TestCollectFilePreservesMetadata проверяет, что в zip-архиве сохраняются время изменения и права файла.
+func TestCollectFilePreservesMetadata(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_file.txt")
+	if err := os.WriteFile(testFile, []byte("MZtest content"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 5, 17, 10, 30, 0, 0, time.UTC)
+	if err := os.Chtimes(testFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewOSFileSystem("/")
+	fpObj := &FilePathObjectAdapter{fs.GetFullPath(testFile)}
+	if err := out.AddCollectedFile("TestArtifact", fpObj); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zipPath := filepath.Join(out.dirpath, fmt.Sprintf("%s-files.zip", out.hostname))
+	zr, err := zip.OpenReader(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	if len(zr.File) != 1 {
+		t.Fatalf("Ожидался 1 файл в zip, получено %d", len(zr.File))
+	}
+	if !zr.File[0].Modified.Equal(mtime) {
+		t.Errorf("Modified = %v, ожидалось %v", zr.File[0].Modified, mtime)
+	}
+	if runtime.GOOS != "windows" && zr.File[0].Mode().Perm() != 0640 {
+		t.Errorf("Mode = %v, ожидалось -rw-r-----", zr.File[0].Mode())
+	}
+}
+
+// TestCollectFileTar проверяет запись файла с метаданными в tar-архив.
+func TestCollectFileTar(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_file.txt")
+	if err := os.WriteFile(testFile, []byte("MZtest content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(testFile, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.SetArchiveFormat("tar"); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewOSFileSystem("/")
+	fpObj := &FilePathObjectAdapter{fs.GetFullPath(testFile)}
+	if err := out.AddCollectedFile("TestArtifact", fpObj); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(out.dirpath, fmt.Sprintf("%s-files.tar", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	hdr, err := tar.NewReader(f).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(hdr.Name, "test_file.txt") {
+		t.Errorf("Имя файла в архиве = %q, ожидалось окончание на 'test_file.txt'", hdr.Name)
+	}
+	if !hdr.ModTime.Equal(mtime) {
+		t.Errorf("ModTime = %v, ожидалось %v", hdr.ModTime, mtime)
+	}
+	if hdr.Size != 14 {
+		t.Errorf("Size = %d, ожидалось 14", hdr.Size)
+	}
+}
+
+// TestCollectCommandStreamed проверяет, что результат команды записывается в JSONL сразу, до закрытия Outputs.
+func TestCollectCommandStreamed(t *testing.T) {
+	tempDir := t.TempDir()
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.SetAggregate(false)
+	out.AddCollectedCommand("TestArtifact", "command", []byte("output"))
+
+	streamPath := filepath.Join(out.dirpath, fmt.Sprintf("%s-commands.jsonl", out.hostname))
+	data, err := os.ReadFile(streamPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rec streamRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Artifact != "TestArtifact" || rec.Source != "command" || string(rec.Payload) != `"output"` {
+		t.Errorf("Получена запись %+v, ожидалось artifact=TestArtifact, source=command, payload=\"output\"", rec)
+	}
+	if rec.Timestamp == "" {
+		t.Error("Отсутствует поле '@timestamp'")
+	}
+
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cmdPath := filepath.Join(out.dirpath, fmt.Sprintf("%s-commands.json", out.hostname))
+	if _, err := os.Stat(cmdPath); !os.IsNotExist(err) {
+		t.Errorf("Сводный %s не должен создаваться при отключённой агрегации", cmdPath)
+	}
+}
+
+// TestCollectionErrors проверяет запись пропущенных файлов в errors.jsonl с кодом причины.
+func TestCollectionErrors(t *testing.T) {
+	tempDir := t.TempDir()
+	bigFile := filepath.Join(tempDir, "test_big_file.txt")
+	if err := os.WriteFile(bigFile, []byte("some bigger content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := NewOutputs(tempDir, "15", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := NewOSFileSystem("/")
+	if err := out.AddCollectedFile("TestArtifact", &FilePathObjectAdapter{fs.GetFullPath(bigFile)}); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tempDir, "missing.txt")
+	if err := out.AddCollectedFile("TestArtifact", &FilePathObjectAdapter{fs.GetFullPath(missing)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	errorsPath := filepath.Join(out.dirpath, fmt.Sprintf("%s-errors.jsonl", out.hostname))
+	reasons := make(map[string]string)
+	if err := readJSONL(errorsPath, func(line []byte) error {
+		var rec CollectionErrorRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if rec.Artifact != "TestArtifact" || rec.Source != TYPE_INDICATOR_FILE {
+			t.Errorf("Неверная запись об ошибке: %+v", rec)
+		}
+		reasons[filepath.Base(rec.Path)] = rec.Reason
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if reasons["test_big_file.txt"] != REASON_TOO_LARGE {
+		t.Errorf("reason для большого файла = %q, ожидалось %q", reasons["test_big_file.txt"], REASON_TOO_LARGE)
+	}
+	if reasons["missing.txt"] != REASON_NOT_FOUND {
+		t.Errorf("reason для отсутствующего файла = %q, ожидалось %q", reasons["missing.txt"], REASON_NOT_FOUND)
+	}
+}
+
+// TestCollectDeniedNotFound проверяет, что шаблон, обход которого завершился ошибкой доступа,
+// не отмечается ещё и как отсутствующий.
+func TestCollectDeniedNotFound(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("права каталога не ограничивают доступ")
+	}
+	tempDir := t.TempDir()
+	locked := filepath.Join(tempDir, "locked")
+	if err := os.Mkdir(locked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0755)
+
+	fs := NewOSFileSystem(tempDir)
+	fs.AddPattern("TestArtifact", filepath.Join(locked, "*.txt"), "")
+	fs.AddPattern("TestArtifact", filepath.Join(tempDir, "absent", "*.txt"), "")
+	out, err := NewOutputs(t.TempDir(), "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.Collect(out)
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reasons := make(map[string][]string)
+	if err := readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-errors.jsonl", out.hostname)), func(line []byte) error {
+		var rec CollectionErrorRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		reasons[rec.Path] = append(reasons[rec.Path], rec.Reason)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := reasons[locked]; len(got) != 1 || got[0] != REASON_ACCESS_DENIED {
+		t.Errorf("reason для закрытого каталога = %v, ожидалось [%s]", got, REASON_ACCESS_DENIED)
+	}
+	if got := reasons[filepath.Join(locked, "*.txt")]; len(got) != 0 {
+		t.Errorf("шаблон в закрытом каталоге отмечен как %v", got)
+	}
+	if got := reasons[filepath.Join(tempDir, "absent", "*.txt")]; len(got) != 1 || got[0] != REASON_NOT_FOUND {
+		t.Errorf("reason для отсутствующего шаблона = %v, ожидалось [%s]", got, REASON_NOT_FOUND)
+	}
+}
+
+func TestCollectionReport(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_file.txt")
+	if err := os.WriteFile(testFile, []byte("some content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.SetReportConfig(map[string]string{"format": "zip", "apikey": "<redacted>"})
+	fs := NewOSFileSystem("/")
+	if err := out.AddCollectedFile("TestArtifact", &FilePathObjectAdapter{fs.GetFullPath(testFile)}); err != nil {
+		t.Fatal(err)
+	}
+	out.AddCollectedCommand("TestArtifact", "command", []byte("output"))
+	out.AddCollectionError("OtherArtifact", TYPE_INDICATOR_FILE, "/missing|file", REASON_NOT_FOUND, nil)
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	html, err := os.ReadFile(filepath.Join(out.dirpath, fmt.Sprintf("%s-report.html", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"TestArtifact", "OtherArtifact", REASON_NOT_FOUND, out.hostname + "-files.zip", "&lt;redacted&gt;"} {
+		if !strings.Contains(string(html), want) {
+			t.Errorf("report.html не содержит %q", want)
+		}
+	}
+
+	md, err := os.ReadFile(filepath.Join(out.dirpath, fmt.Sprintf("%s-report.md", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"| TestArtifact | 1 | 12 B |", `/missing\|file`, "| **Total** | 1 | 12 B |"} {
+		if !strings.Contains(string(md), want) {
+			t.Errorf("report.md не содержит %q", want)
+		}
+	}
+}
+
+func TestCollectFileSkipKnownGood(t *testing.T) {
+	tempDir := t.TempDir()
+	goodFile := filepath.Join(tempDir, "good.txt")
+	otherFile := filepath.Join(tempDir, "other.txt")
+	if err := os.WriteFile(goodFile, []byte("known good"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(otherFile, []byte("something else"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hashes := hashChunks([][]byte{[]byte("known good")}, []string{"sha1"})
+	setPath := filepath.Join(tempDir, "good.lst")
+	if err := os.WriteFile(setPath, []byte(hashes["sha1"]+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hs := NewHashSet()
+	if _, err := hs.LoadFile(setPath, HASHSET_KNOWN_GOOD); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.SetHashSet(hs)
+	out.SetSkipKnownGood(true)
+	fs := NewOSFileSystem("/")
+	// good.txt совпадает с двумя шаблонами, но пропускается и отмечается один раз
+	for _, path := range []string{goodFile, goodFile, otherFile} {
+		po := &FilePathObjectAdapter{fs.GetFullPath(path)}
+		if err := out.AddCollectedFile("TestArtifact", po); err != nil {
+			t.Fatal(err)
+		}
+		if err := out.AddCollectedFileInfo("TestArtifact", po); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(filepath.Join(out.dirpath, fmt.Sprintf("%s-files.zip", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	if len(zr.File) != 1 || !strings.HasSuffix(zr.File[0].Name, "other.txt") {
+		t.Errorf("В архиве должен быть только other.txt, получено %d файлов", len(zr.File))
+	}
+
+	verdicts := make(map[string]string)
+	if err := readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-file_info.jsonl", out.hostname)), func(line []byte) error {
+		var rec struct {
+			File    struct{ Path string }    `json:"file"`
+			HashSet struct{ Verdict string } `json:"hashset"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		verdicts[filepath.Base(rec.File.Path)] = rec.HashSet.Verdict
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if verdicts["good.txt"] != HASHSET_KNOWN_GOOD || verdicts["other.txt"] != HASHSET_UNKNOWN {
+		t.Errorf("Неверные вердикты наборов хешей: %v", verdicts)
+	}
+	skipped := 0
+	if err := readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-errors.jsonl", out.hostname)), func(line []byte) error {
+		var rec CollectionErrorRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if rec.Reason == REASON_KNOWN_GOOD {
+			skipped++
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if skipped != 1 {
+		t.Errorf("Записей known_good = %d, ожидалась 1", skipped)
+	}
+}
+
+func TestCollectFileInfoRules(t *testing.T) {
+	tempDir := t.TempDir()
+	scriptFile := filepath.Join(tempDir, "run.ps1")
+	if err := os.WriteFile(scriptFile, []byte("powershell -nop -enc SQBFAFgA"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := ParseRules(`rule EncodedPowerShell : script { strings: $a = "-enc" nocase fullword condition: $a }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.SetRules(rules)
+	fs := NewOSFileSystem("/")
+	if err := out.AddCollectedFileInfo("TestArtifact", &FilePathObjectAdapter{fs.GetFullPath(scriptFile)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var record map[string]interface{}
+	data, err := os.ReadFile(filepath.Join(out.dirpath, fmt.Sprintf("%s-file_info.jsonl", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	hits, ok := record["rules"].([]interface{})
+	if !ok || len(hits) != 1 || hits[0].(map[string]interface{})["name"] != "EncodedPowerShell" {
+		t.Errorf("rules = %v, ожидалось срабатывание EncodedPowerShell", record["rules"])
+	}
+
+	var match struct {
+		File   map[string]interface{} `json:"file"`
+		Labels map[string]string      `json:"labels"`
+		Rule   RuleMatch              `json:"rule"`
+	}
+	data, err = os.ReadFile(filepath.Join(out.dirpath, fmt.Sprintf("%s-matches.jsonl", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &match); err != nil {
+		t.Fatal(err)
+	}
+	if match.Rule.Rule != "EncodedPowerShell" || match.Labels["artifact"] != "TestArtifact" || match.File["path"] != scriptFile {
+		t.Errorf("unexpected match record: %+v", match)
+	}
+	if len(match.Rule.Strings) != 1 || match.Rule.Strings[0].Offset != 16 || match.Rule.Strings[0].Data != "-enc" {
+		t.Errorf("unexpected string matches: %+v", match.Rule.Strings)
+	}
+
+	report, err := os.ReadFile(filepath.Join(out.dirpath, fmt.Sprintf("%s-report.md", out.hostname)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(report), "EncodedPowerShell") {
+		t.Error("Отчёт не содержит сработавшее правило")
+	}
 }
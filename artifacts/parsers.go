@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+const PARSE_COMMAND = "parse"
+
+const (
+	PARSER_ALL             = "all"
+	PARSER_EVTX            = "evtx"
+	PARSER_PREFETCH        = "prefetch"
+	PARSER_LNK             = "lnk"
+	PARSER_JUMPLIST        = "jumplist"
+	PARSER_MFT             = "mft"
+	PARSER_USN             = "usn"
+	PARSER_RECYCLE_BIN     = "recyclebin"
+	PARSER_TASKS           = "tasks"
+	PARSER_SRUM            = "srum"
+	PARSER_BROWSER_HISTORY = "browser_history"
+
+	PARSER_TYPE = "PARSER"
+)
+
+// ArtifactParser разбирает собранный файл артефакта (журнал событий, Prefetch и т. п.)
+// в структурированные записи. Один и тот же разборщик работает как при сборе (над
+// содержимым файла, уже прочитанным для архива), так и в подкоманде parse над хранилищем
+// предыдущего сбора.
+type ArtifactParser interface {
+	// Name возвращает имя разборщика; оно же задаёт имя файла <hostname>-<name>.jsonl.
+	Name() string
+	// Match сообщает, подходит ли разборщик для файла с указанным исходным путём.
+	Match(path string) bool
+	// Parse читает содержимое файла и передаёт каждую запись в emit.
+	Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error
+}
+
+// parserOptions — параметры разборщиков из флагов командной строки.
+type parserOptions struct {
+	EVTXFilter string
+}
+
+// parserFactories — поддерживаемые разборщики по именам.
+var parserFactories = map[string]func(opts parserOptions) (ArtifactParser, error){
+	PARSER_EVTX:            newEVTXParser,
+	PARSER_PREFETCH:        newPrefetchParser,
+	PARSER_LNK:             newLNKParser,
+	PARSER_JUMPLIST:        newJumpListParser,
+	PARSER_MFT:             newMFTParser,
+	PARSER_USN:             newUSNParser,
+	PARSER_RECYCLE_BIN:     newRecycleBinParser,
+	PARSER_TASKS:           newScheduledTaskParser,
+	PARSER_SRUM:            newSRUMParser,
+	PARSER_BROWSER_HISTORY: newBrowserHistoryParser,
+}
+
+// parserNames возвращает имена всех поддерживаемых разборщиков по алфавиту.
+func parserNames() []string {
+	names := make([]string, 0, len(parserFactories))
+	for name := range parserFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newArtifactParsers создаёт разборщики по списку имён; "all" включает все.
+func newArtifactParsers(names []string, opts parserOptions) ([]ArtifactParser, error) {
+	if containsString(names, PARSER_ALL) {
+		names = parserNames()
+	}
+	var parsers []ArtifactParser
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(name)
+		factory := parserFactories[name]
+		if factory == nil {
+			return nil, fmt.Errorf("unknown parser %q (supported: %s)", name, strings.Join(parserNames(), ", "))
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		p, err := factory(opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		parsers = append(parsers, p)
+	}
+	return parsers, nil
+}
+
+// matchParser возвращает первый разборщик, подходящий для файла, или nil.
+func matchParser(parsers []ArtifactParser, path string) ArtifactParser {
+	for _, p := range parsers {
+		if p.Match(path) {
+			return p
+		}
+	}
+	return nil
+}
+
+// parseArtifactFile разбирает файл подходящим разборщиком и дополняет записи меткой
+// артефакта и именем разборщика. Возвращает число записанных записей; ok=false, если
+// разборщик для файла не найден. Паника разборщика на повреждённом файле возвращается как
+// ошибка, чтобы не прерывать сбор и разбор остальных файлов.
+func parseArtifactFile(parsers []ArtifactParser, artifact, path string, r io.Reader,
+	write func(parser string, record map[string]interface{}) error) (count int, ok bool, err error) {
+	p := matchParser(parsers, path)
+	if p == nil {
+		return 0, false, nil
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			logger.Log(LevelDebug, fmt.Sprintf("Parser %s panicked on %s: %v\n%s", p.Name(), path, v, debug.Stack()))
+			ok, err = true, fmt.Errorf("parser %s panicked: %v", p.Name(), v)
+		}
+	}()
+	err = p.Parse(path, r, func(record map[string]interface{}) error {
+		labels := map[string]string{"parser": p.Name()}
+		if artifact != "" {
+			labels["artifact"] = artifact
+		}
+		record["labels"] = labels
+		count++
+		return write(p.Name(), record)
+	})
+	return count, true, err
+}
+
+// parserWriters — файлы <hostname>-<parser>.jsonl, создаваемые при первой записи.
+type parserWriters struct {
+	dir      string
+	hostname string
+	writers  map[string]*jsonlWriter
+}
+
+func newParserWriters(dir, hostname string) *parserWriters {
+	return &parserWriters{dir: dir, hostname: hostname, writers: make(map[string]*jsonlWriter)}
+}
+
+func (pw *parserWriters) path(parser string) string {
+	return filepath.Join(pw.dir, fmt.Sprintf("%s-%s.jsonl", pw.hostname, parser))
+}
+
+func (pw *parserWriters) Write(parser string, record map[string]interface{}) error {
+	w := pw.writers[parser]
+	if w == nil {
+		w = newJSONLWriter(pw.path(parser))
+		pw.writers[parser] = w
+	}
+	return w.Write(record)
+}
+
+// Counts возвращает число записей по разборщикам.
+func (pw *parserWriters) Counts() map[string]int {
+	counts := make(map[string]int, len(pw.writers))
+	for name, w := range pw.writers {
+		counts[name] = w.Count()
+	}
+	return counts
+}
+
+func (pw *parserWriters) Close() error {
+	var err error
+	for _, w := range pw.writers {
+		if e := w.Close(); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// ----------------------------------------------------------------------
+// Разбор при сборе
+// ----------------------------------------------------------------------
+
+// SetParsers задаёт разборщики, которыми файлы разбираются сразу после архивирования;
+// записи пишутся в <hostname>-<parser>.jsonl каталога результатов.
+func (o *Outputs) SetParsers(parsers []ArtifactParser) {
+	o.parsers = parsers
+	if len(parsers) > 0 && o.parsed == nil {
+		o.parsed = newParserWriters(o.dirpath, o.hostname)
+	}
+}
+
+// parseCollectedFile разбирает содержимое собранного файла; ошибки разбора записываются
+// в errors.jsonl, а записи, полученные до ошибки, сохраняются.
+func (o *Outputs) parseCollectedFile(artifact, path string, chunks [][]byte) {
+	if len(o.parsers) == 0 {
+		return
+	}
+	readers := make([]io.Reader, len(chunks))
+	for i, chunk := range chunks {
+		readers[i] = bytes.NewReader(chunk)
+	}
+	count, ok, err := parseArtifactFile(o.parsers, artifact, path, io.MultiReader(readers...), o.parsed.Write)
+	if !ok {
+		return
+	}
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Failed to parse %s: %v", path, err))
+		o.AddCollectionError(artifact, PARSER_TYPE, path, REASON_PARSE_ERROR, err)
+	}
+	logger.Log(LevelDebug, fmt.Sprintf("Parsed %d records from %s", count, path))
+}
+
+// ----------------------------------------------------------------------
+// Подкоманда parse
+// ----------------------------------------------------------------------
+
+// runParse разбирает файлы артефактов после сбора: либо хранилище каталога результатов
+// (записи пишутся в <hostname>-<parser>.jsonl), либо отдельные файлы и каталоги (записи
+// пишутся в один JSONL-файл или на стандартный вывод).
+func runParse(args []string) int {
+	fset := flag.NewFlagSet(PARSE_COMMAND, flag.ContinueOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Использование: %s %s [флаги] <каталог результатов | файлы и каталоги>\n", filepath.Base(os.Args[0]), PARSE_COMMAND)
+		fset.PrintDefaults()
+	}
+	names := fset.String("parsers", PARSER_ALL, fmt.Sprintf("Разборщики через запятую: %s или all", strings.Join(parserNames(), ", ")))
+	evtxFilter := fset.String("evtx-filter", "", "Фильтр событий EVTX, например channel=Security;id=4624,4688-4690;since=2024-01-01")
+	output := fset.String("output", "", "Каталог (для каталога результатов) или файл JSONL (для отдельных файлов) для записей")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return 2
+	}
+	parsers, err := newArtifactParsers(splitArgs(*names), parserOptions{EVTXFilter: *evtxFilter})
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Неверный параметр -parsers: %v", err))
+		return 2
+	}
+
+	if fset.NArg() == 1 {
+		if hostname, err := findOutputHostname(fset.Arg(0)); err == nil {
+			dir := fset.Arg(0)
+			if *output == "" {
+				*output = dir
+			}
+			counts, err := parseOutput(dir, hostname, *output, parsers)
+			if err != nil {
+				logger.Log(LevelCritical, fmt.Sprintf("Не удалось разобрать %s: %v", dir, err))
+				return 1
+			}
+			for _, p := range parsers {
+				logger.Log(LevelInfo, fmt.Sprintf("%s: записей %d", p.Name(), counts[p.Name()]))
+			}
+			return 0
+		}
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось создать %s: %v", *output, err))
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	total, err := parsePaths(fset.Args(), parsers, func(parser string, record map[string]interface{}) error {
+		return enc.Encode(record)
+	})
+	if err != nil {
+		logger.Log(LevelCritical, err.Error())
+		return 1
+	}
+	logger.Log(LevelInfo, fmt.Sprintf("Записей: %d", total))
+	return 0
+}
+
+// parseOutput разбирает файлы хранилища каталога результатов. Исходные пути и артефакты
+// файлов берутся из file_info.jsonl; прежние файлы записей выбранных разборщиков заменяются.
+func parseOutput(dir, hostname, outDir string, parsers []ArtifactParser) (map[string]int, error) {
+	type collectedFile struct{ path, artifact string }
+	files := make(map[string]collectedFile)
+	err := readJSONL(filepath.Join(dir, fmt.Sprintf("%s-file_info.jsonl", hostname)), func(line []byte) error {
+		var rec struct {
+			File   map[string]interface{} `json:"file"`
+			Labels map[string]string      `json:"labels"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if path := stringField(rec.File, "path"); path != "" {
+			files[archiveKey(path)] = collectedFile{path: path, artifact: rec.Labels["artifact"]}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	writers := newParserWriters(outDir, hostname)
+	for _, p := range parsers {
+		if err := os.Remove(writers.path(p.Name())); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	archive, err := openArchiveReader(dir, hostname)
+	if err != nil {
+		return nil, err
+	}
+	if archive != nil {
+		err = archive.Walk(func(name string, size int64, r io.Reader) error {
+			file, ok := files[archiveKey(name)]
+			if !ok {
+				// Файл без записи file_info — используем путь хранилища
+				file = collectedFile{path: name}
+			}
+			_, _, parseErr := parseArtifactFile(parsers, file.artifact, file.path, r, writers.Write)
+			if parseErr != nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Failed to parse %s: %v", file.path, parseErr))
+			}
+			return nil
+		})
+		archive.Close()
+	}
+	if e := writers.Close(); e != nil && err == nil {
+		err = e
+	}
+	return writers.Counts(), err
+}
+
+// parsePaths разбирает указанные файлы и все подходящие файлы в указанных каталогах.
+func parsePaths(paths []string, parsers []ArtifactParser, write func(parser string, record map[string]interface{}) error) (int, error) {
+	total := 0
+	parseFile := func(path string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Skipping %s: %v", path, err))
+			return nil
+		}
+		defer f.Close()
+		count, _, err := parseArtifactFile(parsers, "", path, f, write)
+		total += count
+		if err != nil {
+			logger.Log(LevelWarning, fmt.Sprintf("Failed to parse %s: %v", path, err))
+		}
+		return nil
+	}
+	for _, root := range paths {
+		st, err := os.Stat(root)
+		if err != nil {
+			return total, err
+		}
+		if !st.IsDir() {
+			if matchParser(parsers, root) == nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Skipping %s: no parser matches the file", root))
+				continue
+			}
+			parseFile(root)
+			continue
+		}
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Skipping %s: %v", path, err))
+				return nil
+			}
+			if d.IsDir() || matchParser(parsers, path) == nil {
+				return nil
+			}
+			return parseFile(path)
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
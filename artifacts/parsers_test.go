@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTestEVTX записывает журнал с событиями 4624 и 4688 канала Security.
+func writeTestEVTX(t *testing.T, path string) {
+	t0 := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	c := newTestChunk()
+	c.record(1, t0, func() { c.securityEvent("Security", 4624, t0, "alice", 3) })
+	c.record(2, t0.Add(time.Hour), func() { c.securityEvent("Security", 4688, t0.Add(time.Hour), "alice", 0) })
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, testEVTXFile(c.seal(c.pos)), 0644))
+}
+
+// readParsed читает записи JSONL-файла разборщика.
+func readParsed(t *testing.T, path string) []map[string]interface{} {
+	var records []map[string]interface{}
+	assert.NoError(t, readJSONL(path, func(line []byte) error {
+		var rec map[string]interface{}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		records = append(records, rec)
+		return nil
+	}))
+	return records
+}
+
+func eventCodes(records []map[string]interface{}) []string {
+	var codes []string
+	for _, rec := range records {
+		codes = append(codes, rec["event"].(map[string]interface{})["code"].(string))
+	}
+	return codes
+}
+
+func TestNewArtifactParsers(t *testing.T) {
+	parsers, err := newArtifactParsers([]string{PARSER_ALL}, parserOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, parsers, len(parserFactories))
+
+	_, err = newArtifactParsers([]string{"unknown"}, parserOptions{})
+	assert.Error(t, err)
+	_, err = newArtifactParsers([]string{PARSER_EVTX}, parserOptions{EVTXFilter: "id=x"})
+	assert.Error(t, err)
+
+	assert.NotNil(t, matchParser(parsers, `C:\Windows\System32\winevt\Logs\Security.EVTX`))
+	assert.Nil(t, matchParser(parsers, `/var/log/syslog`))
+}
+
+func TestParseCollectedFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	logPath := filepath.Join(tempDir, "Security.evtx")
+	writeTestEVTX(t, logPath)
+	assert.NoError(t, os.WriteFile(filepath.Join(tempDir, "broken.evtx"), []byte("ElfFile"), 0644))
+
+	out, err := NewOutputs(tempDir, "", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsers, err := newArtifactParsers([]string{PARSER_EVTX}, parserOptions{EVTXFilter: "id=4688"})
+	assert.NoError(t, err)
+	out.SetParsers(parsers)
+	fs := NewOSFileSystem("/")
+	for _, name := range []string{"Security.evtx", "broken.evtx"} {
+		assert.NoError(t, out.AddCollectedFile("WindowsEventLogs", &FilePathObjectAdapter{fs.GetFullPath(filepath.Join(tempDir, name))}))
+	}
+	assert.NoError(t, out.Close())
+
+	records := readParsed(t, filepath.Join(out.dirpath, fmt.Sprintf("%s-evtx.jsonl", out.hostname)))
+	assert.Equal(t, []string{"4688"}, eventCodes(records))
+	assert.Equal(t, map[string]interface{}{"artifact": "WindowsEventLogs", "parser": PARSER_EVTX}, records[0]["labels"])
+
+	var reasons []string
+	assert.NoError(t, readJSONL(filepath.Join(out.dirpath, fmt.Sprintf("%s-errors.jsonl", out.hostname)), func(line []byte) error {
+		var rec CollectionErrorRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		reasons = append(reasons, rec.Source+":"+rec.Reason)
+		return nil
+	}))
+	assert.Equal(t, []string{PARSER_TYPE + ":" + REASON_PARSE_ERROR}, reasons)
+}
+
+// panicParser — разборщик, падающий на любом файле.
+type panicParser struct{}
+
+func (panicParser) Name() string           { return "faulty" }
+func (panicParser) Match(path string) bool { return true }
+func (panicParser) Parse(path string, r io.Reader, emit func(map[string]interface{}) error) error {
+	emit(map[string]interface{}{"n": 1})
+	panic("index out of range")
+}
+
+func TestParseArtifactFilePanic(t *testing.T) {
+	var written int
+	count, ok, err := parseArtifactFile([]ArtifactParser{panicParser{}}, "", "file.bin", strings.NewReader(""),
+		func(string, map[string]interface{}) error {
+			written++
+			return nil
+		})
+	assert.True(t, ok)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1, written)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "parser faulty panicked")
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "20240501120000-host")
+	writeTestEVTX(t, filepath.Join(dir, "host-files", "C", "Windows", "System32", "winevt", "Logs", "Security.evtx"))
+	info := `{"file":{"path":"C:\\Windows\\System32\\winevt\\Logs\\Security.evtx"},"labels":{"artifact":"WindowsEventLogs"}}` + "\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "host-file_info.jsonl"), []byte(info), 0644))
+	out := filepath.Join(dir, "host-evtx.jsonl")
+	assert.NoError(t, os.WriteFile(out, []byte("{\"stale\":true}\n"), 0644))
+
+	assert.Equal(t, 0, runParse([]string{"-parsers", "evtx", dir}))
+	records := readParsed(t, out)
+	assert.Equal(t, []string{"4624", "4688"}, eventCodes(records))
+	assert.Equal(t, `C:\Windows\System32\winevt\Logs\Security.evtx`,
+		records[0]["log"].(map[string]interface{})["file"].(map[string]interface{})["path"])
+	assert.Equal(t, "WindowsEventLogs", records[0]["labels"].(map[string]interface{})["artifact"])
+
+	// Отдельные файлы и каталоги разбираются в один файл JSONL
+	single := filepath.Join(t.TempDir(), "events.jsonl")
+	assert.Equal(t, 0, runParse([]string{"-evtx-filter", "id=4624", "-output", single, filepath.Join(dir, "host-files")}))
+	assert.Equal(t, []string{"4624"}, eventCodes(readParsed(t, single)))
+
+	assert.Equal(t, 2, runParse([]string{"-parsers", "nope", dir}))
+	assert.Equal(t, 2, runParse(nil))
+}
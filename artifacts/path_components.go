@@ -10,6 +10,12 @@ type PathObject struct {
 	name       string
 	path       string
 	obj        interface{}
+
+	// Метаданные снимаются один раз: повторный stat после чтения вернул бы время
+	// доступа, равное времени сбора
+	meta     *FileMetadata
+	metaErr  error
+	metaRead bool
 }
 
 func (p *PathObject) IsDirectory() bool {
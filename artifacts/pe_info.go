@@ -0,0 +1,451 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"debug/pe"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	// MAX_PE_EXPORTS ограничивает число имён экспортируемых функций в file.pe.exports.
+	MAX_PE_EXPORTS = 1000
+	// MAX_RESOURCE_DEPTH ограничивает вложенность каталога ресурсов (тип/имя/язык).
+	MAX_RESOURCE_DEPTH = 3
+	// MAX_RESOURCE_ENTRIES ограничивает число просматриваемых записей каталога ресурсов в файле.
+	MAX_RESOURCE_ENTRIES = 4096
+
+	RT_VERSION               = 16
+	VS_FIXEDFILEINFO_MAGIC   = 0xFEEF04BD
+	RICH_MARKER              = 0x68636952 // "Rich"
+	DANS_MARKER              = 0x536E6144 // "DanS"
+	IMAGE_SCN_MEM_EXECUTE    = 0x20000000
+	IMAGE_SCN_MEM_READ       = 0x40000000
+	IMAGE_SCN_MEM_WRITE      = 0x80000000
+	IMAGE_DIRECTORY_EXPORT   = 0
+	IMAGE_DIRECTORY_RESOURCE = 2
+)
+
+// versionKeys сопоставляет строки StringFileInfo полям file.pe.
+var versionKeys = map[string]string{
+	"CompanyName":      "company",
+	"FileDescription":  "description",
+	"FileVersion":      "file_version",
+	"InternalName":     "internal_name",
+	"LegalCopyright":   "copyright",
+	"OriginalFilename": "original_file_name",
+	"ProductName":      "product",
+	"ProductVersion":   "product_version",
+}
+
+// peImage — разобранный debug/pe файл вместе с исходными байтами, из которых
+// читаются структуры по RVA.
+type peImage struct {
+	pf   *pe.File
+	data []byte
+}
+
+// peInfo разбирает ресурс версии, rich-заголовок, секции, импорт, экспорт и
+// оверлей PE-файла без обращения к API Windows.
+func peInfo(pf *pe.File, data []byte) map[string]interface{} {
+	img := &peImage{pf: pf, data: data}
+	info := map[string]interface{}{}
+
+	for k, v := range img.versionInfo() {
+		info[k] = v
+	}
+	if rich := peRichHeader(data); rich != nil {
+		info["rich_header"] = rich
+	}
+	info["sections"] = img.sections()
+	if imports := img.imports(); len(imports) > 0 {
+		info["imports"] = imports
+	}
+	if exports := img.exports(); exports != nil {
+		info["exports"] = exports
+	}
+	if offset, size := img.overlay(); size > 0 {
+		info["overlay"] = map[string]interface{}{"offset": offset, "size": size}
+	}
+	return info
+}
+
+// dataDirectory возвращает RVA и размер записи каталога данных опционального заголовка.
+func (img *peImage) dataDirectory(idx int) (uint32, uint32) {
+	var dirs []pe.DataDirectory
+	switch oh := img.pf.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+	case *pe.OptionalHeader64:
+		dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+	}
+	if idx >= len(dirs) {
+		return 0, 0
+	}
+	return dirs[idx].VirtualAddress, dirs[idx].Size
+}
+
+// rvaOffset переводит RVA в смещение в файле по таблице секций.
+func (img *peImage) rvaOffset(rva uint32) (int, bool) {
+	for _, s := range img.pf.Sections {
+		size := s.VirtualSize
+		if size < s.Size {
+			size = s.Size
+		}
+		if rva >= s.VirtualAddress && rva < s.VirtualAddress+size {
+			off := int(s.Offset) + int(rva-s.VirtualAddress)
+			return off, off < len(img.data)
+		}
+	}
+	return 0, false
+}
+
+// read возвращает n байт по RVA или nil, если они выходят за пределы файла.
+func (img *peImage) read(rva, n uint32) []byte {
+	off, ok := img.rvaOffset(rva)
+	if !ok || off+int(n) > len(img.data) {
+		return nil
+	}
+	return img.data[off : off+int(n)]
+}
+
+// cstring читает строку, завершённую нулём, по RVA.
+func (img *peImage) cstring(rva uint32) string {
+	off, ok := img.rvaOffset(rva)
+	if !ok {
+		return ""
+	}
+	end := bytes.IndexByte(img.data[off:], 0)
+	if end < 0 {
+		return ""
+	}
+	return string(img.data[off : off+end])
+}
+
+// versionInfo находит ресурс RT_VERSION и возвращает строки первой таблицы
+// StringFileInfo; если FileVersion отсутствует, версия берётся из VS_FIXEDFILEINFO.
+func (img *peImage) versionInfo() map[string]string {
+	rva, size := img.dataDirectory(IMAGE_DIRECTORY_RESOURCE)
+	if rva == 0 || size == 0 {
+		return nil
+	}
+	data := img.findResource(rva, RT_VERSION)
+	if data == nil {
+		return nil
+	}
+	ret := map[string]string{}
+	key, value, children := versionBlock(data)
+	if key != "VS_VERSION_INFO" {
+		return nil
+	}
+	if len(value) >= 16 && binary.LittleEndian.Uint32(value) == VS_FIXEDFILEINFO_MAGIC {
+		ms := binary.LittleEndian.Uint32(value[8:])
+		ls := binary.LittleEndian.Uint32(value[12:])
+		ret["file_version"] = fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff)
+	}
+	eachVersionBlock(children, func(key string, _ []byte, children []byte) bool {
+		if key != "StringFileInfo" {
+			return true
+		}
+		// Берётся первая таблица строк (язык и кодовая страница)
+		eachVersionBlock(children, func(_ string, _ []byte, strs []byte) bool {
+			eachVersionBlock(strs, func(name string, value []byte, _ []byte) bool {
+				if field, ok := versionKeys[name]; ok {
+					if s := strings.TrimSpace(decodeUTF16(value)); s != "" {
+						ret[field] = s
+					}
+				}
+				return true
+			})
+			return false
+		})
+		return false
+	})
+	if _, ok := ret["original_file_name"]; !ok && ret["internal_name"] != "" {
+		ret["original_file_name"] = ret["internal_name"]
+	}
+	return ret
+}
+
+// findResource спускается по каталогу ресурсов: на первом уровне выбирается
+// запись с идентификатором typ, на следующих — первая запись. Возвращает данные ресурса.
+// Каждый подкаталог просматривается один раз, общее число записей ограничено
+// MAX_RESOURCE_ENTRIES: ссылки подкаталогов друг на друга иначе дают экспоненциальный перебор.
+func (img *peImage) findResource(base, typ uint32) []byte {
+	visited := make(map[uint32]bool)
+	entries := 0
+	var walk func(dirOff uint32, depth int) []byte
+	walk = func(dirOff uint32, depth int) []byte {
+		if depth >= MAX_RESOURCE_DEPTH || visited[dirOff] {
+			return nil
+		}
+		visited[dirOff] = true
+		hdr := img.read(base+dirOff, 16)
+		if hdr == nil {
+			return nil
+		}
+		count := uint32(binary.LittleEndian.Uint16(hdr[12:])) + uint32(binary.LittleEndian.Uint16(hdr[14:]))
+		for i := uint32(0); i < count; i++ {
+			if entries++; entries > MAX_RESOURCE_ENTRIES {
+				return nil
+			}
+			entry := img.read(base+dirOff+16+i*8, 8)
+			if entry == nil {
+				return nil
+			}
+			name := binary.LittleEndian.Uint32(entry)
+			target := binary.LittleEndian.Uint32(entry[4:])
+			if depth == 0 && name != typ {
+				continue
+			}
+			if target&0x80000000 != 0 {
+				if data := walk(target&0x7fffffff, depth+1); data != nil {
+					return data
+				}
+				continue
+			}
+			de := img.read(base+target, 16)
+			if de == nil {
+				return nil
+			}
+			return img.read(binary.LittleEndian.Uint32(de), binary.LittleEndian.Uint32(de[4:]))
+		}
+		return nil
+	}
+	return walk(0, 0)
+}
+
+// versionBlock разбирает блок VS_VERSIONINFO/StringFileInfo/String:
+// wLength, wValueLength, wType, ключ UTF-16, значение и дочерние блоки
+// с выравниванием на 4 байта.
+func versionBlock(data []byte) (string, []byte, []byte) {
+	if len(data) < 6 {
+		return "", nil, nil
+	}
+	length := int(binary.LittleEndian.Uint16(data))
+	valueLen := int(binary.LittleEndian.Uint16(data[2:]))
+	if binary.LittleEndian.Uint16(data[4:]) == 1 {
+		// текстовое значение: длина указана в символах UTF-16
+		valueLen *= 2
+	}
+	if length < 6 || length > len(data) {
+		return "", nil, nil
+	}
+	data = data[:length]
+	off := 6
+	var key []uint16
+	for off+1 < len(data) {
+		c := binary.LittleEndian.Uint16(data[off:])
+		off += 2
+		if c == 0 {
+			break
+		}
+		key = append(key, c)
+	}
+	off = align4(off)
+	if off > len(data) {
+		return string(utf16.Decode(key)), nil, nil
+	}
+	end := off + valueLen
+	if end > len(data) {
+		end = len(data)
+	}
+	value := data[off:end]
+	childOff := align4(end)
+	if childOff > len(data) {
+		childOff = len(data)
+	}
+	return string(utf16.Decode(key)), value, data[childOff:]
+}
+
+// eachVersionBlock перебирает соседние блоки версии, пока fn возвращает true.
+func eachVersionBlock(data []byte, fn func(key string, value, children []byte) bool) {
+	for len(data) >= 6 {
+		length := int(binary.LittleEndian.Uint16(data))
+		if length == 0 {
+			return
+		}
+		key, value, children := versionBlock(data)
+		if !fn(key, value, children) {
+			return
+		}
+		next := align4(length)
+		if next >= len(data) {
+			return
+		}
+		data = data[next:]
+	}
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// decodeUTF16 декодирует строку UTF-16LE до первого нулевого символа.
+func decodeUTF16(b []byte) string {
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		u = append(u, c)
+	}
+	return string(utf16.Decode(u))
+}
+
+// peRichHeader декодирует rich-заголовок компоновщика Microsoft из DOS-заглушки:
+// ключ XOR, записи (идентификатор продукта, сборка, число объектов) и MD5
+// расшифрованного заголовка, используемый для поиска родственных образцов.
+func peRichHeader(data []byte) map[string]interface{} {
+	if len(data) < 0x40 {
+		return nil
+	}
+	lfanew := int(binary.LittleEndian.Uint32(data[0x3c:]))
+	if lfanew <= 0x80 || lfanew > len(data) {
+		return nil
+	}
+	stub := data[:lfanew]
+	richAt := -1
+	for i := 0x80; i+8 <= len(stub); i += 4 {
+		if binary.LittleEndian.Uint32(stub[i:]) == RICH_MARKER {
+			richAt = i
+			break
+		}
+	}
+	if richAt < 0 {
+		return nil
+	}
+	key := binary.LittleEndian.Uint32(stub[richAt+4:])
+	start := -1
+	for i := richAt - 4; i >= 0x40; i -= 4 {
+		if binary.LittleEndian.Uint32(stub[i:])^key == DANS_MARKER {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+
+	clear := make([]byte, richAt-start)
+	for i := 0; i < len(clear); i += 4 {
+		binary.LittleEndian.PutUint32(clear[i:], binary.LittleEndian.Uint32(stub[start+i:])^key)
+	}
+	// За "DanS" следуют три нулевых слова заполнения, затем пары (compid, count)
+	entries := []map[string]interface{}{}
+	for i := 16; i+8 <= len(clear); i += 8 {
+		compid := binary.LittleEndian.Uint32(clear[i:])
+		entries = append(entries, map[string]interface{}{
+			"product_id": compid >> 16,
+			"build":      compid & 0xffff,
+			"count":      binary.LittleEndian.Uint32(clear[i+4:]),
+		})
+	}
+	sum := md5.Sum(clear)
+	return map[string]interface{}{
+		"key":     fmt.Sprintf("%08x", key),
+		"entries": entries,
+		"hash":    hex.EncodeToString(sum[:]),
+	}
+}
+
+// sections описывает секции: имя, адреса, размеры, права доступа и энтропию.
+func (img *peImage) sections() []map[string]interface{} {
+	ret := make([]map[string]interface{}, 0, len(img.pf.Sections))
+	for _, s := range img.pf.Sections {
+		perm := []byte("---")
+		if s.Characteristics&IMAGE_SCN_MEM_READ != 0 {
+			perm[0] = 'r'
+		}
+		if s.Characteristics&IMAGE_SCN_MEM_WRITE != 0 {
+			perm[1] = 'w'
+		}
+		if s.Characteristics&IMAGE_SCN_MEM_EXECUTE != 0 {
+			perm[2] = 'x'
+		}
+		sec := map[string]interface{}{
+			"name":            s.Name,
+			"virtual_address": fmt.Sprintf("0x%x", s.VirtualAddress),
+			"virtual_size":    s.VirtualSize,
+			"raw_size":        s.Size,
+			"permissions":     string(perm),
+		}
+		if end := int(s.Offset) + int(s.Size); s.Size > 0 && end <= len(img.data) {
+			sec["entropy"] = shannonEntropy(img.data[s.Offset:end])
+		}
+		ret = append(ret, sec)
+	}
+	return ret
+}
+
+// imports группирует импортируемые функции по библиотекам (имена библиотек в нижнем регистре).
+func (img *peImage) imports() map[string][]string {
+	syms, err := img.pf.ImportedSymbols()
+	if err != nil {
+		logger.Log(LevelDebug, fmt.Sprintf("PE imports: %v", err))
+		return nil
+	}
+	ret := make(map[string][]string)
+	for _, sym := range syms {
+		fn, lib, ok := strings.Cut(sym, ":")
+		if !ok {
+			continue
+		}
+		lib = strings.ToLower(lib)
+		ret[lib] = append(ret[lib], fn)
+	}
+	return ret
+}
+
+// exports читает каталог экспорта: имя библиотеки, число функций и их имена.
+func (img *peImage) exports() map[string]interface{} {
+	rva, size := img.dataDirectory(IMAGE_DIRECTORY_EXPORT)
+	if rva == 0 || size == 0 {
+		return nil
+	}
+	dir := img.read(rva, 40)
+	if dir == nil {
+		return nil
+	}
+	numFuncs := binary.LittleEndian.Uint32(dir[20:])
+	numNames := binary.LittleEndian.Uint32(dir[24:])
+	namesRVA := binary.LittleEndian.Uint32(dir[32:])
+	if numNames > MAX_PE_EXPORTS {
+		numNames = MAX_PE_EXPORTS
+	}
+	names := []string{}
+	if table := img.read(namesRVA, numNames*4); table != nil {
+		for i := uint32(0); i < numNames; i++ {
+			if name := img.cstring(binary.LittleEndian.Uint32(table[i*4:])); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return map[string]interface{}{
+		"name":      img.cstring(binary.LittleEndian.Uint32(dir[12:])),
+		"count":     numFuncs,
+		"functions": names,
+	}
+}
+
+// overlay возвращает смещение и размер данных за концом последней секции
+// (подпись Authenticode, встроенные архивы инсталляторов и т.п.).
+func (img *peImage) overlay() (int64, int64) {
+	end := int64(0)
+	for _, s := range img.pf.Sections {
+		if e := int64(s.Offset) + int64(s.Size); s.Size > 0 && e > end {
+			end = e
+		}
+	}
+	if end == 0 || end >= int64(len(img.data)) {
+		return 0, 0
+	}
+	return end, int64(len(img.data)) - end
+}
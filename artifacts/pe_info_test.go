@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// utf16z кодирует строку в UTF-16LE с завершающим нулём.
+func utf16z(s string) []byte {
+	var b bytes.Buffer
+	for _, c := range utf16.Encode([]rune(s)) {
+		binary.Write(&b, binary.LittleEndian, c)
+	}
+	b.Write([]byte{0, 0})
+	return b.Bytes()
+}
+
+// testVersionBlock собирает блок ресурса версии с выравниванием на 4 байта.
+func testVersionBlock(key string, typ uint16, value []byte, valueLen uint16, children ...[]byte) []byte {
+	var b bytes.Buffer
+	b.Write(make([]byte, 6))
+	b.Write(utf16z(key))
+	for b.Len()%4 != 0 {
+		b.WriteByte(0)
+	}
+	b.Write(value)
+	for _, c := range children {
+		for b.Len()%4 != 0 {
+			b.WriteByte(0)
+		}
+		b.Write(c)
+	}
+	out := b.Bytes()
+	binary.LittleEndian.PutUint16(out, uint16(len(out)))
+	binary.LittleEndian.PutUint16(out[2:], valueLen)
+	binary.LittleEndian.PutUint16(out[4:], typ)
+	return out
+}
+
+func testVersionString(key, value string) []byte {
+	v := utf16z(value)
+	return testVersionBlock(key, 1, v, uint16(len(v)/2))
+}
+
+// buildTestPE собирает минимальный PE32 с rich-заголовком, секциями .text и .rdata
+// (каталог экспорта и ресурс версии) и оверлеем.
+func buildTestPE(t *testing.T) []byte {
+	const (
+		richKey  = 0x1234abcd
+		lfanew   = 0xa8
+		rdataRVA = 0x2000
+		rsrcRVA  = 0x2200
+	)
+	img := make([]byte, 0xa00)
+	copy(img, "MZ")
+	binary.LittleEndian.PutUint32(img[0x3c:], lfanew)
+	rich := []uint32{DANS_MARKER, 0, 0, 0, 0x00ff7809, 3, 0x01045bd2, 12}
+	for i, v := range rich {
+		binary.LittleEndian.PutUint32(img[0x80+i*4:], v^richKey)
+	}
+	binary.LittleEndian.PutUint32(img[0xa0:], RICH_MARKER)
+	binary.LittleEndian.PutUint32(img[0xa4:], richKey)
+
+	var hdr bytes.Buffer
+	hdr.WriteString("PE\x00\x00")
+	binary.Write(&hdr, binary.LittleEndian, pe.FileHeader{
+		Machine: pe.IMAGE_FILE_MACHINE_I386, NumberOfSections: 2, TimeDateStamp: 0x3e561e4c,
+		SizeOfOptionalHeader: 224, Characteristics: 0x2102,
+	})
+	oh := pe.OptionalHeader32{
+		Magic: 0x10b, AddressOfEntryPoint: 0x1000, ImageBase: 0x10000000,
+		SectionAlignment: 0x1000, FileAlignment: 0x200, SizeOfImage: 0x3000, SizeOfHeaders: 0x200,
+		Subsystem: 2, NumberOfRvaAndSizes: 16,
+	}
+	oh.DataDirectory[IMAGE_DIRECTORY_EXPORT] = pe.DataDirectory{VirtualAddress: rdataRVA, Size: 0x100}
+	oh.DataDirectory[IMAGE_DIRECTORY_RESOURCE] = pe.DataDirectory{VirtualAddress: rsrcRVA, Size: 0x400}
+	binary.Write(&hdr, binary.LittleEndian, oh)
+	sec := func(name string, va, size, off, chars uint32) {
+		sh := pe.SectionHeader32{VirtualAddress: va, VirtualSize: size, SizeOfRawData: size, PointerToRawData: off, Characteristics: chars}
+		copy(sh.Name[:], name)
+		binary.Write(&hdr, binary.LittleEndian, sh)
+	}
+	sec(".text", 0x1000, 0x200, 0x200, 0x60000020)
+	sec(".rdata", rdataRVA, 0x600, 0x400, 0x40000040)
+	copy(img[lfanew:], hdr.Bytes())
+
+	for i := 0x200; i < 0x400; i++ {
+		img[i] = byte(i * 7)
+	}
+
+	// Каталог экспорта: test.dll с функциями Beta и Alpha
+	rdata := img[0x400:0xa00]
+	exp := []uint32{0, 0, 0, rdataRVA + 0x40, 1, 2, 2, rdataRVA + 0x60, rdataRVA + 0x70, rdataRVA + 0x78}
+	for i, v := range exp {
+		binary.LittleEndian.PutUint32(rdata[i*4:], v)
+	}
+	copy(rdata[0x40:], "test.dll\x00")
+	binary.LittleEndian.PutUint32(rdata[0x60:], 0x1000)
+	binary.LittleEndian.PutUint32(rdata[0x64:], 0x1010)
+	binary.LittleEndian.PutUint32(rdata[0x70:], rdataRVA+0x80)
+	binary.LittleEndian.PutUint32(rdata[0x74:], rdataRVA+0x88)
+	binary.LittleEndian.PutUint16(rdata[0x7a:], 1)
+	copy(rdata[0x80:], "Beta\x00")
+	copy(rdata[0x88:], "Alpha\x00")
+
+	// Ресурсы: RT_VERSION -> 1 -> 0x409 -> данные VS_VERSIONINFO
+	rsrc := rdata[0x200:]
+	dirEntry := func(off int, name, target uint32) {
+		binary.LittleEndian.PutUint16(rsrc[off+14:], 1)
+		binary.LittleEndian.PutUint32(rsrc[off+16:], name)
+		binary.LittleEndian.PutUint32(rsrc[off+20:], target)
+	}
+	dirEntry(0x00, RT_VERSION, 0x80000018)
+	dirEntry(0x18, 1, 0x80000030)
+	dirEntry(0x30, 0x409, 0x48)
+
+	fixed := make([]byte, 52)
+	binary.LittleEndian.PutUint32(fixed, VS_FIXEDFILEINFO_MAGIC)
+	binary.LittleEndian.PutUint32(fixed[8:], 7<<16|10)
+	binary.LittleEndian.PutUint32(fixed[12:], 3052<<16|4)
+	vi := testVersionBlock("VS_VERSION_INFO", 0, fixed, uint16(len(fixed)),
+		testVersionBlock("StringFileInfo", 1, nil, 0,
+			testVersionBlock("040904b0", 1, nil, 0,
+				testVersionString("CompanyName", "Example Corp"),
+				testVersionString("FileDescription", "Test Library"),
+				testVersionString("InternalName", "TEST.DLL"),
+				testVersionString("ProductName", "Test Product"),
+			)),
+		testVersionBlock("VarFileInfo", 1, nil, 0,
+			testVersionBlock("Translation", 0, []byte{0x09, 0x04, 0xb0, 0x04}, 4)),
+	)
+	binary.LittleEndian.PutUint32(rsrc[0x48:], rsrcRVA+0x58)
+	binary.LittleEndian.PutUint32(rsrc[0x4c:], uint32(len(vi)))
+	if 0x58+len(vi) > len(rsrc) {
+		t.Fatal("ресурс версии не помещается в секцию")
+	}
+	copy(rsrc[0x58:], vi)
+
+	// Оверлей за концом последней секции
+	return append(img, []byte("OVERLAY-DATA-123")...)
+}
+
+func TestPEInfo(t *testing.T) {
+	data := buildTestPE(t)
+	pf, err := pe.NewFile(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pf.Close()
+	info := peInfo(pf, data)
+
+	assert.Equal(t, "Example Corp", info["company"])
+	assert.Equal(t, "Test Library", info["description"])
+	assert.Equal(t, "Test Product", info["product"])
+	// Строки FileVersion нет — версия из VS_FIXEDFILEINFO; OriginalFilename — из InternalName
+	assert.Equal(t, "7.10.3052.4", info["file_version"])
+	assert.Equal(t, "TEST.DLL", info["original_file_name"])
+
+	rich := info["rich_header"].(map[string]interface{})
+	assert.Equal(t, "1234abcd", rich["key"])
+	entries := rich["entries"].([]map[string]interface{})
+	assert.Len(t, entries, 2)
+	assert.Equal(t, uint32(0x104), entries[1]["product_id"])
+	assert.Equal(t, uint32(0x5bd2), entries[1]["build"])
+	assert.Equal(t, uint32(12), entries[1]["count"])
+	assert.Len(t, rich["hash"], 32)
+
+	sections := info["sections"].([]map[string]interface{})
+	assert.Len(t, sections, 2)
+	assert.Equal(t, ".text", sections[0]["name"])
+	assert.Equal(t, "r-x", sections[0]["permissions"])
+	assert.Greater(t, sections[0]["entropy"].(float64), 7.0)
+	assert.Equal(t, "r--", sections[1]["permissions"])
+
+	exports := info["exports"].(map[string]interface{})
+	assert.Equal(t, "test.dll", exports["name"])
+	assert.Equal(t, uint32(2), exports["count"])
+	assert.Equal(t, []string{"Alpha", "Beta"}, exports["functions"])
+	assert.Nil(t, info["imports"])
+
+	assert.Equal(t, map[string]interface{}{"offset": int64(0xa00), "size": int64(16)}, info["overlay"])
+}
+
+func TestPEInfoMalformed(t *testing.T) {
+	data := buildTestPE(t)
+	// Обрезанный rich-заголовок и циклическая ссылка в каталоге ресурсов не должны
+	// приводить к панике
+	binary.LittleEndian.PutUint32(data[0x80:], 0)
+	binary.LittleEndian.PutUint32(data[0x600+0x2c:], 0x80000000)
+	pf, err := pe.NewFile(bytes.NewReader(data))
+	if !assert.NoError(t, err) {
+		return
+	}
+	info := peInfo(pf, data)
+	assert.Nil(t, info["rich_header"])
+	assert.Nil(t, info["company"])
+	assert.NotNil(t, info["sections"])
+}
+
+func TestPEInfoDataDirectoryCount(t *testing.T) {
+	// NumberOfRvaAndSizes больше числа записей каталога данных в заголовке
+	for _, oh := range []interface{}{
+		&pe.OptionalHeader32{NumberOfRvaAndSizes: 0x100},
+		&pe.OptionalHeader64{NumberOfRvaAndSizes: 0xFFFFFFFF},
+	} {
+		img := &peImage{pf: &pe.File{OptionalHeader: oh}}
+		rva, size := img.dataDirectory(IMAGE_DIRECTORY_RESOURCE)
+		assert.Zero(t, rva)
+		assert.Zero(t, size)
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Файлы Prefetch (*.pf) Windows XP–11: заголовок "SCCA" с версией формата, сведения о
+// запусках, таблица имён файлов, открытых в первые секунды работы программы, и сведения
+// о томах. Начиная с Windows 10 файл сжат алгоритмом Xpress Huffman (заголовок "MAM").
+const (
+	PREFETCH_HEADER_SIZE = 84
+	PREFETCH_MAX_SIZE    = 64 << 20
+
+	PREFETCH_VERSION_XP    = 17
+	PREFETCH_VERSION_VISTA = 23
+	PREFETCH_VERSION_WIN8  = 26
+	PREFETCH_VERSION_WIN10 = 30
+	PREFETCH_VERSION_WIN11 = 31
+
+	PREFETCH_MAM_SIGNATURE     = "MAM"
+	PREFETCH_MAM_XPRESS_HUFF   = 4
+	PREFETCH_MAM_HAS_CHECKSUM  = 0x80
+	XPRESS_HUFFMAN_BLOCK_SIZE  = 65536
+	XPRESS_HUFFMAN_TABLE_SIZE  = 256
+	XPRESS_HUFFMAN_SYMBOLS     = 512
+	XPRESS_HUFFMAN_MAX_CODELEN = 15
+)
+
+// prefetchLayout — смещения полей раздела сведений о файле и размеры записей томов
+// для версии формата.
+type prefetchLayout struct {
+	runTimes   int
+	runTimeCnt int
+	runCount   int
+	volumeSize int
+}
+
+var prefetchLayouts = map[uint32]prefetchLayout{
+	PREFETCH_VERSION_XP:    {runTimes: 120, runTimeCnt: 1, runCount: 144, volumeSize: 40},
+	PREFETCH_VERSION_VISTA: {runTimes: 128, runTimeCnt: 1, runCount: 152, volumeSize: 104},
+	PREFETCH_VERSION_WIN8:  {runTimes: 128, runTimeCnt: 8, runCount: 208, volumeSize: 104},
+	PREFETCH_VERSION_WIN10: {runTimes: 128, runTimeCnt: 8, runCount: 208, volumeSize: 96},
+	PREFETCH_VERSION_WIN11: {runTimes: 128, runTimeCnt: 8, runCount: 208, volumeSize: 96},
+}
+
+var errXpressHuffman = errors.New("invalid Xpress Huffman data")
+
+// PrefetchVolume — том, с которого программа обращалась к файлам.
+type PrefetchVolume struct {
+	DevicePath  string
+	Serial      string
+	Created     time.Time
+	Directories []string
+}
+
+// PrefetchFile — разобранный файл Prefetch.
+type PrefetchFile struct {
+	Version    uint32
+	Executable string
+	Hash       string
+	RunCount   uint32
+	RunTimes   []time.Time
+	Files      []string
+	Volumes    []PrefetchVolume
+	Compressed bool
+}
+
+// ParsePrefetch разбирает содержимое файла Prefetch, при необходимости распаковывая его.
+func ParsePrefetch(data []byte) (*PrefetchFile, error) {
+	compressed := false
+	if len(data) >= 8 && string(data[:3]) == PREFETCH_MAM_SIGNATURE {
+		var err error
+		if data, err = decompressMAM(data); err != nil {
+			return nil, err
+		}
+		compressed = true
+	}
+	if len(data) < PREFETCH_HEADER_SIZE || string(data[4:8]) != "SCCA" {
+		return nil, fmt.Errorf("not a prefetch file: missing SCCA signature")
+	}
+	le := binary.LittleEndian
+	pf := &PrefetchFile{
+		Version:    le.Uint32(data),
+		Executable: decodeUTF16(data[16:76]),
+		Hash:       fmt.Sprintf("%08X", le.Uint32(data[76:])),
+		Compressed: compressed,
+	}
+	layout, ok := prefetchLayouts[pf.Version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported prefetch version %d", pf.Version)
+	}
+	runCount := layout.runCount
+	// Windows 10 использует два варианта раздела сведений, различимые по смещению массива метрик
+	if pf.Version >= PREFETCH_VERSION_WIN10 && len(data) >= PREFETCH_HEADER_SIZE+4 && le.Uint32(data[PREFETCH_HEADER_SIZE:]) == 0x128 {
+		runCount = 200
+	}
+	if len(data) < runCount+4 {
+		return nil, fmt.Errorf("prefetch file information truncated")
+	}
+	pf.RunCount = le.Uint32(data[runCount:])
+	for i := 0; i < layout.runTimeCnt; i++ {
+		if t := filetimeToTime(le.Uint64(data[layout.runTimes+i*8:])); !t.IsZero() {
+			pf.RunTimes = append(pf.RunTimes, t)
+		}
+	}
+
+	// Таблица имён файлов: строки UTF-16, разделённые нулевым символом
+	if names, err := prefetchSection(data, le.Uint32(data[100:]), le.Uint32(data[104:])); err == nil {
+		for _, name := range strings.Split(decodeUTF16Full(names), "\x00") {
+			if name != "" {
+				pf.Files = append(pf.Files, name)
+			}
+		}
+	} else {
+		return pf, err
+	}
+
+	volOff, volCount := le.Uint32(data[108:]), le.Uint32(data[112:])
+	volumes, err := prefetchSection(data, volOff, le.Uint32(data[116:]))
+	if err != nil {
+		return pf, err
+	}
+	for i := 0; i < int(volCount); i++ {
+		entry := i * layout.volumeSize
+		if entry+36 > len(volumes) {
+			return pf, fmt.Errorf("prefetch volume %d truncated", i)
+		}
+		v := PrefetchVolume{
+			Created: filetimeToTime(le.Uint64(volumes[entry+8:])),
+			Serial:  fmt.Sprintf("%08X", le.Uint32(volumes[entry+16:])),
+		}
+		pathOff, pathLen := int(le.Uint32(volumes[entry:])), int(le.Uint32(volumes[entry+4:]))
+		if pathOff+pathLen*2 <= len(volumes) {
+			v.DevicePath = decodeUTF16Full(volumes[pathOff : pathOff+pathLen*2])
+		}
+		// Строки каталогов: длина в символах, строка и завершающий нулевой символ
+		dirOff, dirCount := int(le.Uint32(volumes[entry+28:])), int(le.Uint32(volumes[entry+32:]))
+		for j := 0; j < dirCount && dirOff+2 <= len(volumes); j++ {
+			n := int(le.Uint16(volumes[dirOff:]))
+			if dirOff+2+n*2 > len(volumes) {
+				break
+			}
+			v.Directories = append(v.Directories, decodeUTF16Full(volumes[dirOff+2:dirOff+2+n*2]))
+			dirOff += 2 + n*2 + 2
+		}
+		pf.Volumes = append(pf.Volumes, v)
+	}
+	return pf, nil
+}
+
+// prefetchSection возвращает раздел файла по смещению и размеру из заголовка.
+func prefetchSection(data []byte, off, size uint32) ([]byte, error) {
+	if uint64(off)+uint64(size) > uint64(len(data)) {
+		return nil, fmt.Errorf("prefetch section at 0x%x (%d bytes) out of file", off, size)
+	}
+	return data[off : off+size], nil
+}
+
+// decompressMAM распаковывает файл Prefetch Windows 10: "MAM" + тип сжатия, размер
+// распакованных данных, необязательная контрольная сумма и поток Xpress Huffman.
+func decompressMAM(data []byte) ([]byte, error) {
+	format := data[3]
+	if format&^PREFETCH_MAM_HAS_CHECKSUM != PREFETCH_MAM_XPRESS_HUFF {
+		return nil, fmt.Errorf("unsupported prefetch compression format 0x%02x", format)
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size > PREFETCH_MAX_SIZE {
+		return nil, fmt.Errorf("prefetch decompressed size %d too large", size)
+	}
+	payload := data[8:]
+	if format&PREFETCH_MAM_HAS_CHECKSUM != 0 {
+		if len(payload) < 4 {
+			return nil, fmt.Errorf("prefetch compressed header truncated")
+		}
+		payload = payload[4:]
+	}
+	return decompressXpressHuffman(payload, size)
+}
+
+// decompressXpressHuffman распаковывает данные LZ77 + Huffman ([MS-XCA] 2.1). Каждые
+// 65536 байт результата кодируются отдельным блоком со своей таблицей из 512 длин кодов.
+func decompressXpressHuffman(in []byte, size int) ([]byte, error) {
+	out := make([]byte, 0, size)
+	pos := 0
+	read16 := func() uint32 {
+		var v uint32
+		if pos+2 <= len(in) {
+			v = uint32(binary.LittleEndian.Uint16(in[pos:]))
+		}
+		pos += 2
+		return v
+	}
+	for len(out) < size {
+		if pos+XPRESS_HUFFMAN_TABLE_SIZE > len(in) {
+			return out, fmt.Errorf("%w: truncated Huffman table", errXpressHuffman)
+		}
+		table, err := buildXpressHuffmanTable(in[pos : pos+XPRESS_HUFFMAN_TABLE_SIZE])
+		if err != nil {
+			return out, err
+		}
+		pos += XPRESS_HUFFMAN_TABLE_SIZE
+		bits := read16()<<16 | read16()
+		extra := 16
+		consume := func(n int) {
+			bits <<= uint(n)
+			extra -= n
+			if extra < 0 {
+				bits |= read16() << uint(-extra)
+				extra += 16
+			}
+		}
+
+		blockEnd := len(out) + XPRESS_HUFFMAN_BLOCK_SIZE
+		for len(out) < blockEnd && len(out) < size {
+			if pos > len(in)+4 {
+				return out, fmt.Errorf("%w: unexpected end of input", errXpressHuffman)
+			}
+			entry := table[bits>>(32-XPRESS_HUFFMAN_MAX_CODELEN)]
+			if entry.length == 0 {
+				return out, fmt.Errorf("%w: undefined code", errXpressHuffman)
+			}
+			consume(int(entry.length))
+			symbol := int(entry.symbol)
+			if symbol < 256 {
+				out = append(out, byte(symbol))
+				continue
+			}
+			symbol -= 256
+			length, offsetBits := symbol&15, symbol>>4
+			if length == 15 {
+				if pos >= len(in) {
+					return out, fmt.Errorf("%w: truncated match length", errXpressHuffman)
+				}
+				length = int(in[pos]) + 15
+				pos++
+				if length == 255+15 {
+					if pos+2 > len(in) {
+						return out, fmt.Errorf("%w: truncated match length", errXpressHuffman)
+					}
+					length = int(binary.LittleEndian.Uint16(in[pos:]))
+					pos += 2
+					if length < 15 {
+						return out, fmt.Errorf("%w: invalid match length", errXpressHuffman)
+					}
+				}
+			}
+			length += 3
+			// При нулевой длине сдвиг на 32 бита даёт 0, и смещение равно 1
+			offset := int(bits>>(32-uint(offsetBits))) | 1<<offsetBits
+			consume(offsetBits)
+			if offset > len(out) {
+				return out, fmt.Errorf("%w: match offset %d before start of output", errXpressHuffman, offset)
+			}
+			// Копирование побайтно: источник может перекрываться с копируемой областью
+			for i := 0; i < length; i++ {
+				out = append(out, out[len(out)-offset])
+			}
+		}
+	}
+	return out[:size], nil
+}
+
+type xpressHuffmanEntry struct {
+	symbol uint16
+	length uint8
+}
+
+// buildXpressHuffmanTable строит таблицу декодирования канонического кода Хаффмана по
+// 4-битным длинам кодов 512 символов (младший полубайт — чётный символ).
+func buildXpressHuffmanTable(lengths []byte) ([]xpressHuffmanEntry, error) {
+	table := make([]xpressHuffmanEntry, 1<<XPRESS_HUFFMAN_MAX_CODELEN)
+	code := 0
+	for length := 1; length <= XPRESS_HUFFMAN_MAX_CODELEN; length++ {
+		for symbol := 0; symbol < XPRESS_HUFFMAN_SYMBOLS; symbol++ {
+			l := int(lengths[symbol/2] >> (4 * uint(symbol%2)) & 0x0f)
+			if l != length {
+				continue
+			}
+			span := 1 << (XPRESS_HUFFMAN_MAX_CODELEN - length)
+			start := code * span
+			if start+span > len(table) {
+				return nil, fmt.Errorf("%w: oversubscribed Huffman table", errXpressHuffman)
+			}
+			for i := start; i < start+span; i++ {
+				table[i] = xpressHuffmanEntry{symbol: uint16(symbol), length: uint8(length)}
+			}
+			code++
+		}
+		code <<= 1
+	}
+	if code == 0 {
+		return nil, fmt.Errorf("%w: empty Huffman table", errXpressHuffman)
+	}
+	return table, nil
+}
+
+// AsDict возвращает запись Prefetch для JSONL: время последнего запуска в @timestamp,
+// имя программы в process.name и сведения о запусках, файлах и томах в prefetch.
+func (pf *PrefetchFile) AsDict(path string) map[string]interface{} {
+	runTimes := make([]string, 0, len(pf.RunTimes))
+	for _, t := range pf.RunTimes {
+		runTimes = append(runTimes, formatTime(t))
+	}
+	volumes := make([]map[string]interface{}, 0, len(pf.Volumes))
+	for _, v := range pf.Volumes {
+		vol := map[string]interface{}{
+			"device_path": v.DevicePath,
+			"serial":      v.Serial,
+			"created":     formatTime(v.Created),
+		}
+		if len(v.Directories) > 0 {
+			vol["directories"] = v.Directories
+		}
+		volumes = append(volumes, vol)
+	}
+	files := pf.Files
+	if files == nil {
+		files = []string{}
+	}
+	record := map[string]interface{}{
+		"event":   map[string]interface{}{"kind": "event", "category": "process", "action": "prefetch"},
+		"process": map[string]interface{}{"name": pf.Executable},
+		"file":    map[string]interface{}{"path": path},
+		"prefetch": map[string]interface{}{
+			"version":        pf.Version,
+			"executable":     pf.Executable,
+			"hash":           pf.Hash,
+			"run_count":      pf.RunCount,
+			"last_run_times": runTimes,
+			"files":          files,
+			"volumes":        volumes,
+			"compressed":     pf.Compressed,
+		},
+	}
+	if len(pf.RunTimes) > 0 {
+		record["@timestamp"] = runTimes[0]
+	}
+	return record
+}
+
+// ----------------------------------------------------------------------
+// prefetchParser – разбор файлов Prefetch как ArtifactParser
+// ----------------------------------------------------------------------
+
+type prefetchParser struct{}
+
+func newPrefetchParser(opts parserOptions) (ArtifactParser, error) {
+	return &prefetchParser{}, nil
+}
+
+func (p *prefetchParser) Name() string { return PARSER_PREFETCH }
+
+func (p *prefetchParser) Match(path string) bool {
+	return strings.EqualFold(filepath.Ext(strings.ReplaceAll(path, `\`, "/")), ".pf")
+}
+
+func (p *prefetchParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, PREFETCH_MAX_SIZE+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > PREFETCH_MAX_SIZE {
+		return fmt.Errorf("prefetch file larger than %d bytes", PREFETCH_MAX_SIZE)
+	}
+	pf, err := ParsePrefetch(data)
+	if pf == nil {
+		return err
+	}
+	// Частично разобранный файл (повреждённые таблицы имён или томов) всё же записывается
+	if e := emit(pf.AsDict(path)); e != nil {
+		return e
+	}
+	return err
+}
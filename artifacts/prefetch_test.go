@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/bits"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testXpressHuffman сжимает данные в формате Xpress Huffman с одинаковой длиной кода
+// 9 бит для всех 512 символов; совпадения ищутся в пределах блока длиной до 17 байт.
+func testXpressHuffman(data []byte) []byte {
+	var out []byte
+	for start := 0; start < len(data); start += XPRESS_HUFFMAN_BLOCK_SIZE {
+		end := start + XPRESS_HUFFMAN_BLOCK_SIZE
+		if end > len(data) {
+			end = len(data)
+		}
+		out = append(out, bytes.Repeat([]byte{0x99}, XPRESS_HUFFMAN_TABLE_SIZE)...)
+		var words []uint16
+		var acc uint32
+		n := 0
+		put := func(v uint32, width int) {
+			for i := width - 1; i >= 0; i-- {
+				acc = acc<<1 | (v>>uint(i))&1
+				if n++; n == 16 {
+					words = append(words, uint16(acc))
+					acc, n = 0, 0
+				}
+			}
+		}
+		for i := start; i < end; {
+			bestLen, bestOff := 0, 0
+			for off := 1; off <= i-start && off <= 4096; off++ {
+				l := 0
+				for l < 17 && i+l < end && data[i+l] == data[i+l-off] {
+					l++
+				}
+				if l > bestLen {
+					bestLen, bestOff = l, off
+				}
+			}
+			if bestLen >= 3 {
+				offBits := bits.Len(uint(bestOff)) - 1
+				put(uint32(256+offBits<<4+bestLen-3), 9)
+				put(uint32(bestOff-1<<offBits), offBits)
+				i += bestLen
+			} else {
+				put(uint32(data[i]), 9)
+				i++
+			}
+		}
+		if n > 0 {
+			put(0, 16-n)
+		}
+		// Декодер заранее читает на одно 16-битное слово больше, чем занимают коды блока
+		words = append(words, 0)
+		for _, w := range words {
+			out = binary.LittleEndian.AppendUint16(out, w)
+		}
+	}
+	return out
+}
+
+type testPrefetchVolume struct {
+	path   string
+	serial uint32
+	dirs   []string
+}
+
+// buildTestPrefetch собирает файл Prefetch указанной версии.
+func buildTestPrefetch(version uint32, exe string, runCount uint32, runs []time.Time, files []string, volumes []testPrefetchVolume) []byte {
+	layout := prefetchLayouts[version]
+	infoEnd := 0x130
+	if version == PREFETCH_VERSION_XP {
+		infoEnd = 0x98
+	}
+	data := make([]byte, infoEnd)
+	le := binary.LittleEndian
+	le.PutUint32(data, version)
+	copy(data[4:], "SCCA")
+	copy(data[16:], testUTF16(exe))
+	le.PutUint32(data[76:], 0xDEADBEEF)
+	le.PutUint32(data[84:], uint32(infoEnd))
+	le.PutUint32(data[layout.runCount:], runCount)
+	for i, t := range runs {
+		le.PutUint64(data[layout.runTimes+i*8:], timeToFiletime(t))
+	}
+
+	var names []byte
+	for _, f := range files {
+		names = append(append(names, testUTF16(f)...), 0, 0)
+	}
+	le.PutUint32(data[100:], uint32(len(data)))
+	le.PutUint32(data[104:], uint32(len(names)))
+	data = append(data, names...)
+
+	section := make([]byte, len(volumes)*layout.volumeSize)
+	for i, v := range volumes {
+		entry := section[i*layout.volumeSize:]
+		le.PutUint32(entry[0:], uint32(len(section)))
+		le.PutUint32(entry[4:], uint32(len([]rune(v.path))))
+		le.PutUint64(entry[8:], timeToFiletime(time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)))
+		le.PutUint32(entry[16:], v.serial)
+		section = append(append(section, testUTF16(v.path)...), 0, 0)
+		le.PutUint32(section[i*layout.volumeSize+28:], uint32(len(section)))
+		le.PutUint32(section[i*layout.volumeSize+32:], uint32(len(v.dirs)))
+		for _, d := range v.dirs {
+			section = le.AppendUint16(section, uint16(len([]rune(d))))
+			section = append(append(section, testUTF16(d)...), 0, 0)
+		}
+	}
+	le.PutUint32(data[108:], uint32(len(data)))
+	le.PutUint32(data[112:], uint32(len(volumes)))
+	le.PutUint32(data[116:], uint32(len(section)))
+	data = append(data, section...)
+	le.PutUint32(data[12:], uint32(len(data)))
+	return data
+}
+
+func compressTestPrefetch(data []byte, checksum bool) []byte {
+	header := []byte("MAM\x04")
+	if checksum {
+		header[3] |= PREFETCH_MAM_HAS_CHECKSUM
+	}
+	header = binary.LittleEndian.AppendUint32(header, uint32(len(data)))
+	if checksum {
+		header = append(header, 0, 0, 0, 0)
+	}
+	return append(header, testXpressHuffman(data)...)
+}
+
+func TestDecompressXpressHuffman(t *testing.T) {
+	// Два блока: повторяющийся текст и псевдослучайные байты
+	var data []byte
+	for len(data) < 70000 {
+		data = append(data, "\\VOLUME{01d9a2b3c4d5e6f7-1234abcd}\\WINDOWS\\SYSTEM32\\NTDLL.DLL"...)
+		data = append(data, byte(len(data)*7919), byte(len(data)>>3))
+	}
+	got, err := decompressXpressHuffman(testXpressHuffman(data), len(data))
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	_, err = decompressXpressHuffman(make([]byte, 300), 10)
+	assert.ErrorIs(t, err, errXpressHuffman)
+	_, err = decompressXpressHuffman([]byte{1, 2, 3}, 10)
+	assert.ErrorIs(t, err, errXpressHuffman)
+}
+
+func TestParsePrefetch(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC)
+	volumes := []testPrefetchVolume{{
+		path:   `\VOLUME{01d9a2b3c4d5e6f7-1234abcd}`,
+		serial: 0x1234ABCD,
+		dirs:   []string{`\VOLUME{01d9a2b3c4d5e6f7-1234abcd}\WINDOWS`, `\VOLUME{01d9a2b3c4d5e6f7-1234abcd}\USERS\ALICE\DOWNLOADS`},
+	}}
+	files := []string{`\VOLUME{01d9a2b3c4d5e6f7-1234abcd}\WINDOWS\SYSTEM32\NTDLL.DLL`, `\VOLUME{01d9a2b3c4d5e6f7-1234abcd}\USERS\ALICE\DOWNLOADS\MIMIKATZ.EXE`}
+
+	runs := []time.Time{t0, t0.Add(-time.Hour), t0.Add(-24 * time.Hour)}
+	raw := buildTestPrefetch(PREFETCH_VERSION_WIN10, "MIMIKATZ.EXE", 3, runs, files, volumes)
+	for _, checksum := range []bool{false, true} {
+		pf, err := ParsePrefetch(compressTestPrefetch(raw, checksum))
+		if !assert.NoError(t, err) {
+			continue
+		}
+		assert.True(t, pf.Compressed)
+		assert.Equal(t, uint32(30), pf.Version)
+		assert.Equal(t, "MIMIKATZ.EXE", pf.Executable)
+		assert.Equal(t, "DEADBEEF", pf.Hash)
+		assert.Equal(t, uint32(3), pf.RunCount)
+		assert.Equal(t, runs, pf.RunTimes)
+		assert.Equal(t, files, pf.Files)
+		if assert.Len(t, pf.Volumes, 1) {
+			assert.Equal(t, volumes[0].path, pf.Volumes[0].DevicePath)
+			assert.Equal(t, "1234ABCD", pf.Volumes[0].Serial)
+			assert.Equal(t, volumes[0].dirs, pf.Volumes[0].Directories)
+		}
+	}
+
+	for _, version := range []uint32{PREFETCH_VERSION_XP, PREFETCH_VERSION_VISTA, PREFETCH_VERSION_WIN8} {
+		pf, err := ParsePrefetch(buildTestPrefetch(version, "CMD.EXE", 42, runs[:1], files[:1], volumes))
+		if assert.NoError(t, err, version) {
+			assert.False(t, pf.Compressed)
+			assert.Equal(t, uint32(42), pf.RunCount)
+			assert.Equal(t, runs[:1], pf.RunTimes)
+			assert.Equal(t, files[:1], pf.Files)
+			assert.Equal(t, volumes[0].dirs, pf.Volumes[0].Directories)
+		}
+	}
+
+	_, err := ParsePrefetch([]byte("not a prefetch file at all, definitely not one, padding padding padding padding"))
+	assert.Error(t, err)
+	unknown := buildTestPrefetch(PREFETCH_VERSION_WIN10, "A.EXE", 1, nil, nil, nil)
+	binary.LittleEndian.PutUint32(unknown, 99)
+	_, err = ParsePrefetch(unknown)
+	assert.Error(t, err)
+	// Файл, обрезанный сразу за заголовком
+	_, err = ParsePrefetch(buildTestPrefetch(PREFETCH_VERSION_WIN10, "A.EXE", 1, nil, nil, nil)[:PREFETCH_HEADER_SIZE])
+	assert.Error(t, err)
+}
+
+func TestPrefetchParser(t *testing.T) {
+	t0 := time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC)
+	data := compressTestPrefetch(buildTestPrefetch(PREFETCH_VERSION_WIN10, "EVIL.EXE", 2, []time.Time{t0}, []string{`\VOLUME{1}\EVIL.EXE`}, nil), false)
+
+	p, err := newPrefetchParser(parserOptions{})
+	assert.NoError(t, err)
+	assert.True(t, p.Match(`C:\Windows\Prefetch\EVIL.EXE-DEADBEEF.pf`))
+	assert.False(t, p.Match(`C:\Windows\Prefetch\Layout.ini`))
+
+	var records []map[string]interface{}
+	path := `C:\Windows\Prefetch\EVIL.EXE-DEADBEEF.pf`
+	assert.NoError(t, p.Parse(path, bytes.NewReader(data), func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	}))
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "2024-06-01T09:30:00Z", records[0]["@timestamp"])
+		assert.Equal(t, map[string]interface{}{"name": "EVIL.EXE"}, records[0]["process"])
+		pf := records[0]["prefetch"].(map[string]interface{})
+		assert.Equal(t, uint32(2), pf["run_count"])
+		assert.Equal(t, []string{"2024-06-01T09:30:00Z"}, pf["last_run_times"])
+		assert.Equal(t, []string{`\VOLUME{1}\EVIL.EXE`}, pf["files"])
+		assert.Equal(t, true, pf["compressed"])
+	}
+}
@@ -0,0 +1,232 @@
+// File: provider_json.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// JSONProvider queries an arbitrary JSON hash-lookup endpoint (for example an internal hash service).
+//
+// The URL and the optional POST body are templates where {md5}, {sha1}, {sha256} and {hash}
+// (the strongest hash out of the "hash" option, default "sha256,sha1,md5") are substituted.
+// The verdict is read from the response by dot-separated field paths:
+//
+//	verdict_field     — field compared against malicious_values / suspicious_values / clean_values
+//	score_field       — numeric field compared against malicious_score / suspicious_score
+//	detection_field, date_field, link_field — optional details
+//
+// The API key is sent in the apikey_header header (default "Authorization").
+type JSONProvider struct {
+	name         string
+	url          string
+	method       string
+	body         string
+	apiKey       string
+	apiKeyHeader string
+	headers      map[string]string
+	hashKinds    []string
+	client       *Client
+
+	verdictField     string
+	maliciousValues  []string
+	suspiciousValues []string
+	cleanValues      []string
+	scoreField       string
+	maliciousScore   float64
+	suspiciousScore  float64
+	detectionField   string
+	dateField        string
+	linkField        string
+}
+
+// NewJSONProvider creates a generic JSON provider; url and either verdict_field or score_field are required.
+func NewJSONProvider(client *Client, pc ProviderConfig) (*JSONProvider, error) {
+	if pc.URL == "" {
+		return nil, fmt.Errorf("%s: url not set", pc.Name)
+	}
+	p := &JSONProvider{
+		name:             pc.Name,
+		url:              pc.URL,
+		method:           strings.ToUpper(pc.option("method", "GET")),
+		body:             pc.option("body", ""),
+		apiKey:           pc.APIKey,
+		apiKeyHeader:     pc.option("apikey_header", "Authorization"),
+		headers:          pc.Headers,
+		hashKinds:        pc.listOption("hash"),
+		client:           client,
+		verdictField:     pc.option("verdict_field", ""),
+		maliciousValues:  pc.listOption("malicious_values"),
+		suspiciousValues: pc.listOption("suspicious_values"),
+		cleanValues:      pc.listOption("clean_values"),
+		scoreField:       pc.option("score_field", ""),
+		detectionField:   pc.option("detection_field", ""),
+		dateField:        pc.option("date_field", ""),
+		linkField:        pc.option("link_field", ""),
+	}
+	if p.verdictField == "" && p.scoreField == "" {
+		return nil, fmt.Errorf("%s: verdict_field or score_field must be set", pc.Name)
+	}
+	if len(p.hashKinds) == 0 {
+		p.hashKinds = []string{"sha256", "sha1", "md5"}
+	}
+	if len(p.maliciousValues) == 0 {
+		p.maliciousValues = []string{VERDICT_MALICIOUS}
+	}
+	if len(p.suspiciousValues) == 0 {
+		p.suspiciousValues = []string{VERDICT_SUSPICIOUS}
+	}
+	if len(p.cleanValues) == 0 {
+		p.cleanValues = []string{VERDICT_CLEAN}
+	}
+	var err error
+	if p.maliciousScore, err = strconv.ParseFloat(pc.option("malicious_score", "1"), 64); err != nil {
+		return nil, fmt.Errorf("%s: invalid malicious_score: %w", pc.Name, err)
+	}
+	if p.suspiciousScore, err = strconv.ParseFloat(pc.option("suspicious_score", strconv.FormatFloat(p.maliciousScore, 'f', -1, 64)), 64); err != nil {
+		return nil, fmt.Errorf("%s: invalid suspicious_score: %w", pc.Name, err)
+	}
+	return p, nil
+}
+
+func (p *JSONProvider) Name() string { return p.name }
+
+// expand substitutes hash placeholders into a template.
+func (p *JSONProvider) expand(tmpl string, hashes map[string]string, escape func(string) string) string {
+	_, hash := preferredHash(hashes, p.hashKinds...)
+	pairs := []string{"{hash}", escape(hash)}
+	for _, kind := range []string{"md5", "sha1", "sha256", FUZZY_SSDEEP, FUZZY_TLSH} {
+		pairs = append(pairs, "{"+kind+"}", escape(hashes[kind]))
+	}
+	return strings.NewReplacer(pairs...).Replace(tmpl)
+}
+
+// Lookup sends the configured request and maps the response fields to a verdict.
+func (p *JSONProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	if _, hash := preferredHash(hashes, p.hashKinds...); hash == "" {
+		return nil, fmt.Errorf("no supported hash")
+	}
+	var body io.Reader
+	if p.body != "" {
+		body = bytes.NewReader([]byte(p.expand(p.body, hashes, func(s string) string { return s })))
+	}
+	req, err := http.NewRequest(p.method, p.expand(p.url, hashes, url.PathEscape), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if p.apiKey != "" {
+		req.Header.Set(p.apiKeyHeader, p.apiKey)
+	}
+	setHeaders(req.Header.Set, p.headers)
+
+	status, respBody, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return &ProviderResult{Verdict: VERDICT_UNKNOWN}, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("status %d", status)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(respBody, &doc); err != nil {
+		return nil, err
+	}
+	res := &ProviderResult{
+		Verdict:        VERDICT_UNKNOWN,
+		DetectionName:  jsonFieldString(doc, p.detectionField),
+		LastDetectDate: jsonFieldString(doc, p.dateField),
+		Link:           jsonFieldString(doc, p.linkField),
+	}
+	if p.verdictField != "" {
+		value := jsonFieldString(doc, p.verdictField)
+		switch {
+		case matchesAny(value, p.maliciousValues):
+			res.Verdict = VERDICT_MALICIOUS
+		case matchesAny(value, p.suspiciousValues):
+			res.Verdict = VERDICT_SUSPICIOUS
+		case matchesAny(value, p.cleanValues):
+			res.Verdict = VERDICT_CLEAN
+		}
+	}
+	if p.scoreField != "" && res.Verdict == VERDICT_UNKNOWN {
+		if score, err := strconv.ParseFloat(jsonFieldString(doc, p.scoreField), 64); err == nil {
+			switch {
+			case score >= p.maliciousScore:
+				res.Verdict = VERDICT_MALICIOUS
+			case score >= p.suspiciousScore:
+				res.Verdict = VERDICT_SUSPICIOUS
+			default:
+				res.Verdict = VERDICT_CLEAN
+			}
+		}
+	}
+	return res, nil
+}
+
+// jsonField follows a dot-separated path ("data.attributes.verdict", "results.0.name") through decoded JSON.
+func jsonField(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+	cur := doc
+	for _, part := range strings.Split(path, ".") {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, cur != nil
+}
+
+// jsonFieldString returns the field at path formatted as a string, or "" if it is missing.
+func jsonFieldString(doc interface{}, path string) string {
+	v, ok := jsonField(doc, path)
+	if !ok {
+		return ""
+	}
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// matchesAny reports whether value equals one of values, ignoring case.
+func matchesAny(value string, values []string) bool {
+	for _, v := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,150 @@
+// File: provider_misp.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// mispAttribute is the part of a MISP attribute returned by attributes/restSearch.
+type mispAttribute struct {
+	Type      string `json:"type"`
+	Value     string `json:"value"`
+	ToIDS     bool   `json:"to_ids"`
+	EventID   string `json:"event_id"`
+	Timestamp string `json:"timestamp"`
+	Event     struct {
+		Info string `json:"info"`
+	} `json:"Event"`
+	Tag []struct {
+		Name string `json:"name"`
+	} `json:"Tag"`
+}
+
+// MISPProvider searches hash attributes in a MISP instance through the REST API.
+// Attributes marked for IDS give a malicious verdict, other matches a suspicious one.
+// Options: "tags" restricts the search to comma-separated tags, "published" ("true") to published events.
+type MISPProvider struct {
+	name      string
+	url       string
+	apiKey    string
+	headers   map[string]string
+	tags      []string
+	published bool
+	client    *Client
+}
+
+// mispAttributeTypes lists the attribute types searched for file hashes.
+var mispAttributeTypes = []string{
+	"md5", "sha1", "sha256", "ssdeep", "tlsh",
+	"filename|md5", "filename|sha1", "filename|sha256", "filename|ssdeep", "filename|tlsh",
+}
+
+// NewMISPProvider creates a MISP provider; both url and apikey are required.
+func NewMISPProvider(client *Client, pc ProviderConfig) (*MISPProvider, error) {
+	if pc.URL == "" {
+		return nil, fmt.Errorf("%s: url not set", pc.Name)
+	}
+	if pc.APIKey == "" {
+		return nil, fmt.Errorf("%s: apikey not set", pc.Name)
+	}
+	return &MISPProvider{
+		name:      pc.Name,
+		url:       strings.TrimRight(pc.URL, "/"),
+		apiKey:    pc.APIKey,
+		headers:   pc.Headers,
+		tags:      pc.listOption("tags"),
+		published: pc.option("published", "") == "true",
+		client:    client,
+	}, nil
+}
+
+func (p *MISPProvider) Name() string { return p.name }
+
+// Lookup searches every available hash at once; no matching attribute yields an unknown verdict.
+func (p *MISPProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	var values []string
+	// Fuzzy hashes are matched by exact attribute value, like the cryptographic ones.
+	for _, kind := range []string{"md5", "sha1", "sha256", FUZZY_SSDEEP, FUZZY_TLSH} {
+		if h := hashes[kind]; h != "" {
+			values = append(values, h)
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no supported hash")
+	}
+	search := map[string]interface{}{
+		"returnFormat":     "json",
+		"value":            values,
+		"type":             mispAttributeTypes,
+		"includeEventTags": true,
+	}
+	if len(p.tags) > 0 {
+		search["tags"] = p.tags
+	}
+	if p.published {
+		search["published"] = true
+	}
+	payload, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", p.url+"/attributes/restSearch", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", p.apiKey)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	setHeaders(req.Header.Set, p.headers)
+
+	status, body, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("status %d", status)
+	}
+
+	var resp struct {
+		Response struct {
+			Attribute []mispAttribute `json:"Attribute"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	attrs := resp.Response.Attribute
+	if len(attrs) == 0 {
+		return &ProviderResult{Verdict: VERDICT_UNKNOWN}, nil
+	}
+
+	res := &ProviderResult{Verdict: VERDICT_SUSPICIOUS, Detections: len(attrs)}
+	var names []string
+	var latest int64
+	for _, a := range attrs {
+		if a.ToIDS && res.Verdict != VERDICT_MALICIOUS {
+			res.Verdict = VERDICT_MALICIOUS
+			res.Link = p.url + "/events/view/" + a.EventID
+		}
+		if res.Link == "" {
+			res.Link = p.url + "/events/view/" + a.EventID
+		}
+		if a.Event.Info != "" && !containsString(names, a.Event.Info) {
+			names = append(names, a.Event.Info)
+		}
+		var ts int64
+		if _, err := fmt.Sscan(a.Timestamp, &ts); err == nil && ts > latest {
+			latest = ts
+		}
+	}
+	res.DetectionName = strings.Join(names, "; ")
+	if latest > 0 {
+		res.LastDetectDate = time.Unix(latest, 0).UTC().Format(time.RFC3339)
+	}
+	return res, nil
+}
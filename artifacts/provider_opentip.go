@@ -0,0 +1,89 @@
+// File: provider_opentip.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const OPENTIP_URL = "https://opentip.kaspersky.com/api/v1/search/hash"
+
+// HashResponse represents the fields returned by OpenTIP for a hash lookup.
+type HashResponse struct {
+	Zone           string `json:"Zone"`
+	DetectionName  string `json:"DetectionName"`
+	LastDetectDate string `json:"LastDetectDate"`
+}
+
+// OpenTIPProvider looks hashes up in Kaspersky OpenTIP.
+type OpenTIPProvider struct {
+	name   string
+	url    string
+	apiKey string
+	client *Client
+}
+
+// NewOpenTIPProvider creates an OpenTIP provider; the URL defaults to the public OpenTIP API.
+func NewOpenTIPProvider(client *Client, pc ProviderConfig) (*OpenTIPProvider, error) {
+	if pc.APIKey == "" {
+		return nil, fmt.Errorf("KASPERSKY_API_KEY not set")
+	}
+	u := pc.URL
+	if u == "" {
+		u = OPENTIP_URL
+	}
+	return &OpenTIPProvider{name: pc.Name, url: u, apiKey: pc.APIKey, client: client}, nil
+}
+
+func (p *OpenTIPProvider) Name() string { return p.name }
+
+// Lookup queries OpenTIP for the strongest available hash and maps the zone to a verdict.
+func (p *OpenTIPProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	_, hash := preferredHash(hashes)
+	if hash == "" {
+		return nil, fmt.Errorf("no supported hash")
+	}
+	req, err := http.NewRequest("GET", p.url+"?request="+url.QueryEscape(hash), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-API-KEY", p.apiKey)
+
+	status, body, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return &ProviderResult{Verdict: VERDICT_UNKNOWN}, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("status %d", status)
+	}
+
+	var hr HashResponse
+	if err := json.Unmarshal(body, &hr); err != nil {
+		return nil, err
+	}
+	return &ProviderResult{
+		Verdict:        openTIPVerdict(hr.Zone),
+		Zone:           hr.Zone,
+		DetectionName:  hr.DetectionName,
+		LastDetectDate: hr.LastDetectDate,
+	}, nil
+}
+
+// openTIPVerdict maps an OpenTIP zone (Red, Orange, Yellow, Green, Grey) to a verdict.
+func openTIPVerdict(zone string) string {
+	switch strings.ToLower(zone) {
+	case "red":
+		return VERDICT_MALICIOUS
+	case "orange", "yellow":
+		return VERDICT_SUSPICIOUS
+	case "green":
+		return VERDICT_CLEAN
+	}
+	return VERDICT_UNKNOWN
+}
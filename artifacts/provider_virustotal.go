@@ -0,0 +1,116 @@
+// File: provider_virustotal.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const VIRUSTOTAL_URL = "https://www.virustotal.com/api/v3"
+
+// virusTotalFile is the part of the VirusTotal v3 file object used for verdicts.
+type virusTotalFile struct {
+	Data struct {
+		ID         string `json:"id"`
+		Attributes struct {
+			LastAnalysisDate  int64 `json:"last_analysis_date"`
+			LastAnalysisStats struct {
+				Malicious  int `json:"malicious"`
+				Suspicious int `json:"suspicious"`
+				Undetected int `json:"undetected"`
+				Harmless   int `json:"harmless"`
+			} `json:"last_analysis_stats"`
+			PopularThreatClassification struct {
+				SuggestedThreatLabel string `json:"suggested_threat_label"`
+			} `json:"popular_threat_classification"`
+			MeaningfulName string `json:"meaningful_name"`
+		} `json:"attributes"`
+	} `json:"data"`
+}
+
+// VirusTotalProvider looks hashes up through the VirusTotal v3 API.
+// The "min_detections" option sets how many engines must flag a file for a malicious verdict (default 1).
+type VirusTotalProvider struct {
+	name          string
+	url           string
+	apiKey        string
+	minDetections int
+	client        *Client
+}
+
+// NewVirusTotalProvider creates a VirusTotal provider; the URL defaults to the public v3 API.
+func NewVirusTotalProvider(client *Client, pc ProviderConfig) (*VirusTotalProvider, error) {
+	if pc.APIKey == "" {
+		return nil, fmt.Errorf("%s: apikey not set", pc.Name)
+	}
+	u := pc.URL
+	if u == "" {
+		u = VIRUSTOTAL_URL
+	}
+	return &VirusTotalProvider{
+		name:          pc.Name,
+		url:           strings.TrimRight(u, "/"),
+		apiKey:        pc.APIKey,
+		minDetections: pc.intOption("min_detections", 1),
+		client:        client,
+	}, nil
+}
+
+func (p *VirusTotalProvider) Name() string { return p.name }
+
+// Lookup fetches the file report by hash; an unknown file (404) yields an unknown verdict.
+func (p *VirusTotalProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	_, hash := preferredHash(hashes)
+	if hash == "" {
+		return nil, fmt.Errorf("no supported hash")
+	}
+	req, err := http.NewRequest("GET", p.url+"/files/"+hash, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("x-apikey", p.apiKey)
+	req.Header.Add("Accept", "application/json")
+
+	status, body, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return &ProviderResult{Verdict: VERDICT_UNKNOWN}, nil
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("status %d", status)
+	}
+
+	var vf virusTotalFile
+	if err := json.Unmarshal(body, &vf); err != nil {
+		return nil, err
+	}
+	attrs := vf.Data.Attributes
+	stats := attrs.LastAnalysisStats
+	res := &ProviderResult{
+		DetectionName: attrs.PopularThreatClassification.SuggestedThreatLabel,
+		Detections:    stats.Malicious,
+		Engines:       stats.Malicious + stats.Suspicious + stats.Undetected + stats.Harmless,
+	}
+	if vf.Data.ID != "" {
+		res.Link = "https://www.virustotal.com/gui/file/" + vf.Data.ID
+	}
+	if attrs.LastAnalysisDate > 0 {
+		res.LastDetectDate = time.Unix(attrs.LastAnalysisDate, 0).UTC().Format(time.RFC3339)
+	}
+	switch {
+	case stats.Malicious >= p.minDetections:
+		res.Verdict = VERDICT_MALICIOUS
+	case stats.Malicious > 0 || stats.Suspicious > 0:
+		res.Verdict = VERDICT_SUSPICIOUS
+	case res.Engines > 0:
+		res.Verdict = VERDICT_CLEAN
+	default:
+		res.Verdict = VERDICT_UNKNOWN
+	}
+	return res, nil
+}
@@ -0,0 +1,118 @@
+// File: providers.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider types accepted in the "type" key of a provider configuration.
+const (
+	PROVIDER_OPENTIP    = "opentip"
+	PROVIDER_VIRUSTOTAL = "virustotal"
+	PROVIDER_MISP       = "misp"
+	PROVIDER_JSON       = "json"
+)
+
+// defaultRates holds the quotas of public API tiers applied when a provider has no "rate" key.
+var defaultRates = map[string]string{
+	PROVIDER_OPENTIP:    "2000/d",
+	PROVIDER_VIRUSTOTAL: "4/m",
+}
+
+// ProviderConfig describes one configured threat-intelligence provider.
+// Rate is the API quota ("4/m", "2000/d"; a plain number means requests per minute) and Burst the
+// number of requests allowed at once.
+// Options holds provider-specific settings (field paths of the generic JSON endpoint, MISP filters, ...).
+type ProviderConfig struct {
+	Name     string
+	Type     string
+	URL      string
+	APIKey   string
+	Timeout  time.Duration
+	Insecure bool
+	Rate     string
+	Burst    int
+	Headers  map[string]string
+	Options  map[string]string
+}
+
+// option returns a provider-specific option or def when it is not set.
+func (pc *ProviderConfig) option(key, def string) string {
+	if v, ok := pc.Options[key]; ok && v != "" {
+		return v
+	}
+	return def
+}
+
+// intOption returns a numeric provider-specific option or def when it is not set or invalid.
+func (pc *ProviderConfig) intOption(key string, def int) int {
+	v, err := strconv.Atoi(pc.option(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// listOption splits a comma-separated provider-specific option.
+func (pc *ProviderConfig) listOption(key string) []string {
+	var res []string
+	for _, v := range strings.Split(pc.option(key, ""), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// NewProvider creates a provider from its configuration.
+func NewProvider(pc ProviderConfig) (Provider, error) {
+	if pc.Type == "" {
+		pc.Type = pc.Name
+	}
+	if pc.Name == "" {
+		pc.Name = pc.Type
+	}
+	client := NewClient(pc.Timeout, pc.Insecure)
+	if pc.Rate == "" {
+		pc.Rate = defaultRates[strings.ToLower(pc.Type)]
+	}
+	rate, err := parseRate(pc.Rate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pc.Name, err)
+	}
+	client.SetRateLimit(rate, pc.Burst)
+	switch strings.ToLower(pc.Type) {
+	case PROVIDER_OPENTIP:
+		return NewOpenTIPProvider(client, pc)
+	case PROVIDER_VIRUSTOTAL:
+		return NewVirusTotalProvider(client, pc)
+	case PROVIDER_MISP:
+		return NewMISPProvider(client, pc)
+	case PROVIDER_JSON:
+		return NewJSONProvider(client, pc)
+	}
+	return nil, fmt.Errorf("unknown analysis provider type %q for %q", pc.Type, pc.Name)
+}
+
+// NewProviders creates all configured providers, failing on the first invalid configuration.
+func NewProviders(configs []ProviderConfig) ([]Provider, error) {
+	providers := make([]Provider, 0, len(configs))
+	for _, pc := range configs {
+		p, err := NewProvider(pc)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// setHeaders adds the configured extra headers to a request header set.
+func setHeaders(set func(key, value string), headers map[string]string) {
+	for k, v := range headers {
+		set(k, v)
+	}
+}
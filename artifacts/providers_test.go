@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testHashes = map[string]string{
+	"md5":    "44d88612fea8a8f36de82e1278abb02f",
+	"sha1":   "3395856ce81f2b7382dee72602f798b642f14140",
+	"sha256": "275a021bbfb6489e54d471899f7db9d1663fc695ec2fe2a2c4538aabf651fd0f",
+}
+
+func TestOpenTIPProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "key", r.Header.Get("X-API-KEY"))
+		assert.Equal(t, testHashes["sha256"], r.URL.Query().Get("request"))
+		w.Write([]byte(`{"Zone":"Red","DetectionName":"EICAR-Test-File","LastDetectDate":"2024-01-01T00:00:00Z"}`))
+	}))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderConfig{Type: PROVIDER_OPENTIP, URL: srv.URL, APIKey: "key"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// Без ключа rate действует дневная квота OpenTIP
+	if limiter := p.(*OpenTIPProvider).client.limiter; assert.NotNil(t, limiter) {
+		assert.InDelta(t, 2000.0/86400, limiter.rate, 1e-9)
+	}
+	res, err := p.Lookup(testHashes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, VERDICT_MALICIOUS, res.Verdict)
+		assert.Equal(t, "Red", res.Zone)
+		assert.Equal(t, "EICAR-Test-File", res.DetectionName)
+	}
+}
+
+func TestVirusTotalProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "key", r.Header.Get("x-apikey"))
+		if r.URL.Path != "/files/"+testHashes["sha256"] {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"id":"` + testHashes["sha256"] + `","attributes":{
+			"last_analysis_date":1700000000,
+			"last_analysis_stats":{"malicious":2,"suspicious":0,"undetected":60,"harmless":0},
+			"popular_threat_classification":{"suggested_threat_label":"virus.eicar/test"}}}}`))
+	}))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderConfig{Type: PROVIDER_VIRUSTOTAL, URL: srv.URL, APIKey: "key", Rate: "0",
+		Options: map[string]string{"min_detections": "3"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := p.Lookup(testHashes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, VERDICT_SUSPICIOUS, res.Verdict)
+		assert.Equal(t, 2, res.Detections)
+		assert.Equal(t, 62, res.Engines)
+		assert.Equal(t, "virus.eicar/test", res.DetectionName)
+		assert.Equal(t, "2023-11-14T22:13:20Z", res.LastDetectDate)
+	}
+
+	res, err = p.Lookup(map[string]string{"md5": "00000000000000000000000000000000"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, VERDICT_UNKNOWN, res.Verdict)
+	}
+}
+
+func TestMISPProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/attributes/restSearch", r.URL.Path)
+		assert.Equal(t, "key", r.Header.Get("Authorization"))
+		var search struct {
+			Value []string `json:"value"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&search))
+		assert.Equal(t, []string{testHashes["md5"], testHashes["sha1"], testHashes["sha256"]}, search.Value)
+		w.Write([]byte(`{"response":{"Attribute":[
+			{"type":"md5","value":"` + testHashes["md5"] + `","to_ids":false,"event_id":"7","timestamp":"1700000000","Event":{"info":"Phishing wave"}},
+			{"type":"sha256","value":"` + testHashes["sha256"] + `","to_ids":true,"event_id":"9","timestamp":"1600000000","Event":{"info":"EICAR campaign"}}]}}`))
+	}))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderConfig{Name: "misp", URL: srv.URL, APIKey: "key"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	res, err := p.Lookup(testHashes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, VERDICT_MALICIOUS, res.Verdict)
+		assert.Equal(t, 2, res.Detections)
+		assert.Equal(t, "Phishing wave; EICAR campaign", res.DetectionName)
+		assert.Equal(t, srv.URL+"/events/view/9", res.Link)
+		assert.Equal(t, "2023-11-14T22:13:20Z", res.LastDetectDate)
+	}
+}
+
+func TestJSONProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "token", r.Header.Get("X-Token"))
+		assert.Equal(t, "/lookup/"+testHashes["md5"], r.URL.Path)
+		w.Write([]byte(`{"result":{"status":"BAD","families":[{"name":"Eicar"}],"score":90}}`))
+	}))
+	defer srv.Close()
+
+	p, err := NewProvider(ProviderConfig{
+		Name:   "internal",
+		Type:   PROVIDER_JSON,
+		URL:    srv.URL + "/lookup/{md5}",
+		APIKey: "token",
+		Options: map[string]string{
+			"apikey_header":    "X-Token",
+			"verdict_field":    "result.status",
+			"malicious_values": "bad,evil",
+			"detection_field":  "result.families.0.name",
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "internal", p.Name())
+	res, err := p.Lookup(testHashes)
+	if assert.NoError(t, err) {
+		assert.Equal(t, VERDICT_MALICIOUS, res.Verdict)
+		assert.Equal(t, "Eicar", res.DetectionName)
+	}
+
+	_, err = NewProvider(ProviderConfig{Type: PROVIDER_JSON, URL: srv.URL})
+	assert.Error(t, err, "JSON provider without verdict_field/score_field must be rejected")
+}
+
+func TestMergeResults(t *testing.T) {
+	res := &outStruct{Path: "/bin/sample", Md5: testHashes["md5"]}
+	mergeResults(res, []*ProviderResult{
+		{Provider: "opentip", Verdict: VERDICT_CLEAN, Zone: "Green"},
+		{Provider: "virustotal", Error: "status 429"},
+		{Provider: "misp", Verdict: VERDICT_MALICIOUS, DetectionName: "EICAR campaign"},
+	})
+	assert.Equal(t, VERDICT_MALICIOUS, res.Verdict)
+	assert.Equal(t, "Green", res.Zone)
+	assert.Equal(t, "EICAR campaign", res.DetectionName)
+	assert.Empty(t, res.Error)
+	assert.Len(t, res.Providers, 3)
+	assert.True(t, res.flagged())
+
+	failed := &outStruct{}
+	mergeResults(failed, []*ProviderResult{{Provider: "virustotal", Error: "status 429"}})
+	assert.Equal(t, "virustotal: status 429", failed.Error)
+	assert.False(t, failed.flagged())
+}
+
+func TestClientRetryAfter(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		switch attempts {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(0, false)
+	var delays []time.Duration
+	c.sleep = func(d time.Duration) { delays = append(delays, d) }
+	req, _ := http.NewRequest("GET", srv.URL, nil)
+	status, _, err := c.Do(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []time.Duration{7 * time.Second, 2 * time.Second}, delays)
+
+	// Квота исчерпана на всех попытках
+	exhausted := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer exhausted.Close()
+	c.maxRetries = 2
+	req, _ = http.NewRequest("GET", exhausted.URL, nil)
+	status, _, err = c.Do(req)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, status)
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newTokenBucket(0.5, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+	var slept time.Duration
+	b.sleep = func(d time.Duration) {
+		slept += d
+		now = now.Add(d)
+	}
+	for i := 0; i < 4; i++ {
+		b.Wait()
+	}
+	// Два запроса из запаса, ещё два — по одному раз в две секунды
+	assert.Equal(t, 4*time.Second, slept)
+
+	rate, err := parseRate("4/m")
+	assert.NoError(t, err)
+	assert.InDelta(t, 4.0/60, rate, 1e-9)
+	rate, err = parseRate("2000/d")
+	assert.NoError(t, err)
+	assert.InDelta(t, 2000.0/86400, rate, 1e-9)
+	_, err = parseRate("10/week")
+	assert.Error(t, err)
+}
+
+// countingProvider считает обращения и отвечает фиксированным вердиктом.
+type countingProvider struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *countingProvider) Name() string { return "counting" }
+
+func (p *countingProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	return &ProviderResult{Verdict: VERDICT_CLEAN}, nil
+}
+
+func TestCachedProviderDedup(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "cache", VERDICT_CACHE_FILE)
+	cache, err := OpenVerdictCache(cachePath, time.Hour)
+	if !assert.NoError(t, err) {
+		return
+	}
+	inner := &countingProvider{}
+	p := NewCachedProvider(inner, cache)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := p.Lookup(testHashes)
+			assert.NoError(t, err)
+			assert.Equal(t, VERDICT_CLEAN, res.Verdict)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, inner.calls)
+	assert.NoError(t, cache.Close())
+
+	// Следующий запуск берёт вердикт из кэша
+	cache, err = OpenVerdictCache(cachePath, time.Hour)
+	if !assert.NoError(t, err) {
+		return
+	}
+	inner = &countingProvider{}
+	res, err := NewCachedProvider(inner, cache).Lookup(testHashes)
+	assert.NoError(t, err)
+	assert.True(t, res.Cached)
+	assert.Equal(t, 0, inner.calls)
+
+	// Просроченные записи не используются
+	cache.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, ok := cache.Get("counting:sha256:" + testHashes["sha256"])
+	assert.False(t, ok)
+	assert.NoError(t, cache.Close())
+}
+
+// blockingProvider отвечает только после закрытия release.
+type blockingProvider struct {
+	release chan struct{}
+}
+
+func (p *blockingProvider) Name() string { return "blocking" }
+
+func (p *blockingProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	<-p.release
+	return &ProviderResult{Verdict: VERDICT_CLEAN}, nil
+}
+
+func queueItem(i int) map[string]interface{} {
+	return map[string]interface{}{
+		"file": map[string]interface{}{
+			"path": fmt.Sprintf("/bin/file%d", i),
+			"hash": map[string]string{"md5": fmt.Sprintf("%032x", i)},
+		},
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	n := 0
+	assert.NoError(t, readJSONL(path, func(line []byte) error {
+		n++
+		return nil
+	}))
+	return n
+}
+
+func TestAnalysisQueueSpill(t *testing.T) {
+	resultsPath := filepath.Join(t.TempDir(), "host-analysis.jsonl")
+	p := &blockingProvider{release: make(chan struct{})}
+	q, err := NewQueue([]Provider{p}, 1, 1, resultsPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for i := 0; i < 20; i++ {
+		q.Enqueue(queueItem(i))
+	}
+	assert.FileExists(t, q.spoolPath)
+	assert.Equal(t, int64(20), q.Pending())
+
+	close(p.release)
+	assert.NoError(t, q.Close())
+	assert.Equal(t, 20, countLines(t, resultsPath))
+	assert.Equal(t, int64(0), q.Pending())
+	assert.Equal(t, int64(0), q.Unfinished())
+	assert.NoFileExists(t, q.spoolPath)
+}
+
+func TestAnalysisQueueDeadline(t *testing.T) {
+	resultsPath := filepath.Join(t.TempDir(), "host-analysis.jsonl")
+	p := &blockingProvider{release: make(chan struct{})}
+	q, err := NewQueue([]Provider{p}, 2, 1, resultsPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	q.SetDrainTimeout(50 * time.Millisecond)
+	q.grace = 50 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		q.Enqueue(queueItem(i))
+	}
+
+	assert.NoError(t, q.Close())
+	// Одна проверка зависла у источника; она, как и остальные, сохранена для повторного анализа
+	assert.Equal(t, int64(10), q.Unfinished())
+	assert.Equal(t, 10, countLines(t, q.pendingPath))
+	close(p.release)
+}
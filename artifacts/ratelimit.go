@@ -0,0 +1,85 @@
+// File: ratelimit.go
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBucket limits the request rate of a provider to its API quota.
+// Tokens are refilled continuously at rate per second up to burst; Wait blocks until a token is available.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+// newTokenBucket creates a bucket allowing rate requests per second with the given burst.
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Wait takes one token, sleeping until one is refilled if the bucket is empty.
+func (b *tokenBucket) Wait() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		now := b.now()
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+		if b.tokens >= 1 {
+			b.tokens--
+			return
+		}
+		// Holding the lock while sleeping keeps waiting workers in line.
+		b.sleep(time.Duration((1 - b.tokens) / b.rate * float64(time.Second)))
+	}
+}
+
+// parseRate parses a quota such as "4/m", "500/d", "10/s" or a plain number of requests per minute
+// and returns it in requests per second. An empty value or 0 means no limit.
+func parseRate(s string) (float64, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" {
+		return 0, nil
+	}
+	count, unit := s, "m"
+	if i := strings.Index(s, "/"); i >= 0 {
+		count, unit = strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:])
+	}
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	switch unit {
+	case "s", "sec", "second":
+		return n, nil
+	case "m", "min", "minute":
+		return n / 60, nil
+	case "h", "hour":
+		return n / 3600, nil
+	case "d", "day":
+		return n / 86400, nil
+	}
+	return 0, fmt.Errorf("invalid rate unit %q", unit)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Корзина Windows. В Vista и новее для каждого удалённого файла в каталоге
+// $Recycle.Bin\<SID> создаются пара $I<id> (метаданные) и $R<id> (содержимое):
+//   - версия 1 (Vista–8.1): размер, время удаления и путь фиксированной длины (260 символов);
+//   - версия 2 (Windows 10/11): путь переменной длины с префиксом числа символов.
+//
+// В XP метаданные всех файлов хранятся в Recycler\<SID>\INFO2 записями по 800 байт.
+const (
+	RECYCLE_V1_PATH_SIZE   = 520
+	RECYCLE_HEADER_SIZE    = 24
+	RECYCLE_INFO2_HEADER   = 20
+	RECYCLE_INFO2_RECORD   = 800
+	RECYCLE_INFO2_ANSI     = 260
+	RECYCLE_MAX_INDEX_SIZE = 16 << 20
+)
+
+var (
+	recycleIndexName = regexp.MustCompile(`(?i)^\$I[0-9A-Z]{6}(\..*)?$`)
+	recycleSIDName   = regexp.MustCompile(`(?i)^S-1-[0-9-]+$`)
+)
+
+// RecycleBinEntry — сведения об удалённом файле.
+type RecycleBinEntry struct {
+	Version      uint64
+	OriginalPath string
+	Size         uint64
+	Deleted      time.Time
+	Index        uint32 // номер записи INFO2 (Dc<index>)
+	Drive        uint32
+}
+
+// ParseRecycleBinIndex разбирает файл $I версии 1 или 2.
+func ParseRecycleBinIndex(data []byte) (*RecycleBinEntry, error) {
+	if len(data) < RECYCLE_HEADER_SIZE {
+		return nil, fmt.Errorf("$I file truncated")
+	}
+	le := binary.LittleEndian
+	e := &RecycleBinEntry{
+		Version: le.Uint64(data),
+		Size:    le.Uint64(data[8:]),
+		Deleted: filetimeToTime(le.Uint64(data[16:])),
+	}
+	switch e.Version {
+	case 1:
+		end := min(len(data), RECYCLE_HEADER_SIZE+RECYCLE_V1_PATH_SIZE)
+		e.OriginalPath = decodeUTF16(data[RECYCLE_HEADER_SIZE:end])
+	case 2:
+		if len(data) < RECYCLE_HEADER_SIZE+4 {
+			return nil, fmt.Errorf("$I file truncated")
+		}
+		n := int(le.Uint32(data[RECYCLE_HEADER_SIZE:]))
+		start := RECYCLE_HEADER_SIZE + 4
+		if start+n*2 > len(data) {
+			return nil, fmt.Errorf("$I path truncated")
+		}
+		e.OriginalPath = decodeUTF16(data[start : start+n*2])
+	default:
+		return nil, fmt.Errorf("unsupported $I version %d", e.Version)
+	}
+	return e, nil
+}
+
+// ParseRecycleBinInfo2 разбирает файл INFO2 корзины Windows XP. Путь берётся в Unicode,
+// а при его отсутствии (Windows 95/98) — в кодировке ANSI.
+func ParseRecycleBinInfo2(data []byte) ([]*RecycleBinEntry, error) {
+	if len(data) < RECYCLE_INFO2_HEADER {
+		return nil, fmt.Errorf("INFO2 header truncated")
+	}
+	le := binary.LittleEndian
+	version := uint64(le.Uint32(data))
+	size := int(le.Uint32(data[12:]))
+	if size < RECYCLE_INFO2_ANSI+20 {
+		return nil, fmt.Errorf("INFO2: bad record size %d", size)
+	}
+	var entries []*RecycleBinEntry
+	for off := RECYCLE_INFO2_HEADER; off+size <= len(data); off += size {
+		rec := data[off : off+size]
+		e := &RecycleBinEntry{
+			Version:      version,
+			OriginalPath: cString(rec[:RECYCLE_INFO2_ANSI]),
+			Index:        le.Uint32(rec[260:]),
+			Drive:        le.Uint32(rec[264:]),
+			Deleted:      filetimeToTime(le.Uint64(rec[268:])),
+			Size:         uint64(le.Uint32(rec[276:])),
+		}
+		if size >= RECYCLE_INFO2_RECORD {
+			if p := decodeUTF16(rec[280:RECYCLE_INFO2_RECORD]); p != "" {
+				e.OriginalPath = p
+			}
+		}
+		entries = append(entries, e)
+	}
+	if (len(data)-RECYCLE_INFO2_HEADER)%size != 0 {
+		return entries, fmt.Errorf("INFO2 truncated")
+	}
+	return entries, nil
+}
+
+// AsDict возвращает запись для JSONL: время удаления в @timestamp, исходный путь и размер
+// в file, сведения о файлах корзины и SID владельца в recycle_bin.
+func (e *RecycleBinEntry) AsDict(path string) map[string]interface{} {
+	p := strings.ReplaceAll(path, `\`, "/")
+	name := filepath.Base(p)
+	bin := map[string]interface{}{
+		"version":       e.Version,
+		"original_path": e.OriginalPath,
+		"size":          e.Size,
+		"deleted":       formatTime(e.Deleted),
+		"index_file":    path,
+	}
+	if recycleIndexName.MatchString(name) {
+		bin["restore_file"] = "$R" + name[2:]
+	} else {
+		bin["restore_file"] = fmt.Sprintf("D%c%d%s", 'a'+rune(e.Drive%26), e.Index, filepath.Ext(e.OriginalPath))
+	}
+	if sid := filepath.Base(filepath.Dir(p)); recycleSIDName.MatchString(sid) {
+		bin["sid"] = sid
+	}
+	record := map[string]interface{}{
+		"event":       map[string]interface{}{"kind": "event", "category": "file", "type": "deletion", "action": "recycle-bin"},
+		"file":        map[string]interface{}{"path": e.OriginalPath, "size": e.Size},
+		"recycle_bin": bin,
+	}
+	if !e.Deleted.IsZero() {
+		record["@timestamp"] = formatTime(e.Deleted)
+	}
+	return record
+}
+
+// ----------------------------------------------------------------------
+// recycleBinParser – разбор метаданных корзины как ArtifactParser
+// ----------------------------------------------------------------------
+
+type recycleBinParser struct{}
+
+func newRecycleBinParser(opts parserOptions) (ArtifactParser, error) {
+	return &recycleBinParser{}, nil
+}
+
+func (p *recycleBinParser) Name() string { return PARSER_RECYCLE_BIN }
+
+func (p *recycleBinParser) Match(path string) bool {
+	name := filepath.Base(strings.ReplaceAll(path, `\`, "/"))
+	return recycleIndexName.MatchString(name) || strings.EqualFold(name, "INFO2")
+}
+
+func (p *recycleBinParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, RECYCLE_MAX_INDEX_SIZE+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > RECYCLE_MAX_INDEX_SIZE {
+		return fmt.Errorf("recycle bin index larger than %d bytes", RECYCLE_MAX_INDEX_SIZE)
+	}
+	if strings.EqualFold(filepath.Base(strings.ReplaceAll(path, `\`, "/")), "INFO2") {
+		entries, err := ParseRecycleBinInfo2(data)
+		for _, e := range entries {
+			if e := emit(e.AsDict(path)); e != nil {
+				return e
+			}
+		}
+		return err
+	}
+	e, err := ParseRecycleBinIndex(data)
+	if err != nil {
+		return err
+	}
+	return emit(e.AsDict(path))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildTestRecycleIndex собирает файл $I версии 1 или 2.
+func buildTestRecycleIndex(version uint64, size uint64, deleted time.Time, path string) []byte {
+	le := binary.LittleEndian
+	data := le.AppendUint64(nil, version)
+	data = le.AppendUint64(data, size)
+	data = le.AppendUint64(data, timeToFiletime(deleted))
+	name := utf16z(path)
+	if version == 1 {
+		return append(data, append(name, make([]byte, RECYCLE_V1_PATH_SIZE-len(name))...)...)
+	}
+	data = le.AppendUint32(data, uint32(len(name)/2))
+	return append(data, name...)
+}
+
+func TestParseRecycleBinIndex(t *testing.T) {
+	deleted := time.Date(2024, 7, 8, 9, 10, 11, 0, time.UTC)
+	for _, version := range []uint64{1, 2} {
+		e, err := ParseRecycleBinIndex(buildTestRecycleIndex(version, 48213, deleted, `C:\Users\alice\Desktop\passwords.xlsx`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, version, e.Version)
+			assert.Equal(t, `C:\Users\alice\Desktop\passwords.xlsx`, e.OriginalPath)
+			assert.Equal(t, uint64(48213), e.Size)
+			assert.Equal(t, deleted, e.Deleted)
+		}
+	}
+	_, err := ParseRecycleBinIndex(buildTestRecycleIndex(3, 1, deleted, "x"))
+	assert.Error(t, err)
+	_, err = ParseRecycleBinIndex(buildTestRecycleIndex(2, 1, deleted, `C:\long\path.txt`)[:40])
+	assert.Error(t, err)
+}
+
+func TestParseRecycleBinInfo2(t *testing.T) {
+	le := binary.LittleEndian
+	deleted := time.Date(2005, 1, 2, 3, 4, 5, 0, time.UTC)
+	data := le.AppendUint32(nil, 5)
+	data = append(data, make([]byte, 8)...)
+	data = le.AppendUint32(data, RECYCLE_INFO2_RECORD)
+	data = append(data, make([]byte, 4)...)
+	rec := make([]byte, RECYCLE_INFO2_RECORD)
+	copy(rec, `C:\Documents and Settings\bob\report.doc`)
+	le.PutUint32(rec[260:], 7)
+	le.PutUint32(rec[264:], 2)
+	le.PutUint64(rec[268:], timeToFiletime(deleted))
+	le.PutUint32(rec[276:], 4096)
+	copy(rec[280:], utf16z(`C:\Documents and Settings\bob\отчёт.doc`))
+	data = append(data, rec...)
+
+	entries, err := ParseRecycleBinInfo2(data)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, `C:\Documents and Settings\bob\отчёт.doc`, entries[0].OriginalPath)
+		assert.Equal(t, deleted, entries[0].Deleted)
+		m := entries[0].AsDict(`C:\RECYCLER\S-1-5-21-1-2-3-1004\INFO2`)
+		bin := m["recycle_bin"].(map[string]interface{})
+		assert.Equal(t, "Dc7.doc", bin["restore_file"])
+		assert.Equal(t, "S-1-5-21-1-2-3-1004", bin["sid"])
+	}
+	_, err = ParseRecycleBinInfo2(data[:len(data)-10])
+	assert.Error(t, err)
+}
+
+func TestRecycleBinParser(t *testing.T) {
+	p, err := newRecycleBinParser(parserOptions{})
+	assert.NoError(t, err)
+	path := `C:\$Recycle.Bin\S-1-5-21-1111-2222-3333-1001\$IAB12CD.xlsx`
+	assert.True(t, p.Match(path))
+	assert.False(t, p.Match(`C:\$Recycle.Bin\S-1-5-21-1111-2222-3333-1001\$RAB12CD.xlsx`))
+	assert.False(t, p.Match(`C:\$Recycle.Bin\S-1-5-21-1111-2222-3333-1001\desktop.ini`))
+
+	deleted := time.Date(2024, 7, 8, 9, 10, 11, 0, time.UTC)
+	var records []map[string]interface{}
+	assert.NoError(t, p.Parse(path, bytes.NewReader(buildTestRecycleIndex(2, 100, deleted, `D:\share\budget.xlsx`)),
+		func(record map[string]interface{}) error {
+			records = append(records, record)
+			return nil
+		}))
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "2024-07-08T09:10:11Z", records[0]["@timestamp"])
+		assert.Equal(t, map[string]interface{}{"path": `D:\share\budget.xlsx`, "size": uint64(100)}, records[0]["file"])
+		bin := records[0]["recycle_bin"].(map[string]interface{})
+		assert.Equal(t, "$RAB12CD.xlsx", bin["restore_file"])
+		assert.Equal(t, "S-1-5-21-1111-2222-3333-1001", bin["sid"])
+		assert.Equal(t, path, bin["index_file"])
+	}
+}
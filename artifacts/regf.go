@@ -0,0 +1,697 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Формат файла куста реестра Windows (regf): базовый блок 4096 байт, за ним ячейки в
+// блоках hbin. Смещения ячеек отсчитываются от начала данных hbin (REGF_BASE_BLOCK_SIZE).
+const (
+	REGF_BASE_BLOCK_SIZE = 4096
+	REGF_LOG_HEADER_SIZE = 512
+	REGF_SECTOR_SIZE     = 512
+	REGF_BIG_DATA_LIMIT  = 16344
+	REGF_NO_OFFSET       = 0xffffffff
+	// REGF_MAX_DEPTH ограничивает вложенность списков подключей и обход "**".
+	REGF_MAX_DEPTH = 64
+	// REGF_MAX_SUBKEYS ограничивает число подключей, собираемых из списков одного ключа.
+	REGF_MAX_SUBKEYS = 1 << 20
+	// REGF_MAX_HIVE_SIZE — наибольший размер куста, до которого его может увеличить журнал.
+	REGF_MAX_HIVE_SIZE = 2 << 30
+
+	REGF_FILE_PRIMARY       = 0
+	REGF_FILE_LOG_LEGACY    = 1
+	REGF_FILE_LOG_NEW       = 6
+	REGF_KEY_COMP_NAME      = 0x0020
+	REGF_VALUE_COMP_NAME    = 0x0001
+	REGF_DATA_INLINE        = 0x80000000
+	REGF_MARVIN32_SEED      = 0x82EF4D887A4E55C5
+	REGF_LOG_ENTRY_HDR_SIZE = 40
+)
+
+// Типы значений реестра.
+const (
+	REG_NONE                       = 0
+	REG_SZ                         = 1
+	REG_EXPAND_SZ                  = 2
+	REG_BINARY                     = 3
+	REG_DWORD                      = 4
+	REG_DWORD_BIG_ENDIAN           = 5
+	REG_LINK                       = 6
+	REG_MULTI_SZ                   = 7
+	REG_RESOURCE_LIST              = 8
+	REG_FULL_RESOURCE_DESCRIPTOR   = 9
+	REG_RESOURCE_REQUIREMENTS_LIST = 10
+	REG_QWORD                      = 11
+)
+
+var registryTypeNames = map[uint32]string{
+	REG_NONE:                       "REG_NONE",
+	REG_SZ:                         "REG_SZ",
+	REG_EXPAND_SZ:                  "REG_EXPAND_SZ",
+	REG_BINARY:                     "REG_BINARY",
+	REG_DWORD:                      "REG_DWORD",
+	REG_DWORD_BIG_ENDIAN:           "REG_DWORD_BIG_ENDIAN",
+	REG_LINK:                       "REG_LINK",
+	REG_MULTI_SZ:                   "REG_MULTI_SZ",
+	REG_RESOURCE_LIST:              "REG_RESOURCE_LIST",
+	REG_FULL_RESOURCE_DESCRIPTOR:   "REG_FULL_RESOURCE_DESCRIPTOR",
+	REG_RESOURCE_REQUIREMENTS_LIST: "REG_RESOURCE_REQUIREMENTS_LIST",
+	REG_QWORD:                      "REG_QWORD",
+}
+
+// registryTypeName возвращает имя типа значения реестра.
+func registryTypeName(t uint32) string {
+	if name, ok := registryTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%x", t)
+}
+
+var errRegfCorrupt = errors.New("corrupt registry hive")
+
+// Hive — куст реестра, прочитанный в память целиком.
+type Hive struct {
+	data  []byte
+	root  uint32
+	minor uint32
+	// Replayed — число применённых записей журналов транзакций.
+	Replayed int
+}
+
+// regfBaseBlock — поля базового блока, нужные для чтения и восстановления куста.
+type regfBaseBlock struct {
+	primarySeq   uint32
+	secondarySeq uint32
+	major, minor uint32
+	fileType     uint32
+	root         uint32
+	binsSize     uint32
+	valid        bool
+}
+
+// parseRegfBaseBlock разбирает базовый блок и проверяет его контрольную сумму.
+func parseRegfBaseBlock(b []byte) (*regfBaseBlock, error) {
+	if len(b) < REGF_LOG_HEADER_SIZE || string(b[:4]) != "regf" {
+		return nil, fmt.Errorf("not a registry hive: missing regf signature")
+	}
+	le := binary.LittleEndian
+	bb := &regfBaseBlock{
+		primarySeq:   le.Uint32(b[4:]),
+		secondarySeq: le.Uint32(b[8:]),
+		major:        le.Uint32(b[20:]),
+		minor:        le.Uint32(b[24:]),
+		fileType:     le.Uint32(b[28:]),
+		root:         le.Uint32(b[36:]),
+		binsSize:     le.Uint32(b[40:]),
+	}
+	bb.valid = regfChecksum(b) == le.Uint32(b[508:])
+	return bb, nil
+}
+
+// regfChecksum вычисляет контрольную сумму базового блока: XOR первых 127 слов.
+func regfChecksum(b []byte) uint32 {
+	var sum uint32
+	for i := 0; i < 508; i += 4 {
+		sum ^= binary.LittleEndian.Uint32(b[i:])
+	}
+	switch sum {
+	case 0:
+		return 1
+	case 0xffffffff:
+		return 0xfffffffe
+	}
+	return sum
+}
+
+// ParseHive разбирает файл куста. Если куст не был корректно записан на диск (номера
+// последовательности базового блока различаются), применяются журналы транзакций
+// (.LOG1/.LOG2 нового формата или .LOG старого), переданные в logs.
+func ParseHive(data []byte, logs ...[]byte) (*Hive, error) {
+	bb, err := parseRegfBaseBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	if bb.fileType != REGF_FILE_PRIMARY {
+		return nil, fmt.Errorf("not a primary hive file (type %d)", bb.fileType)
+	}
+	h := &Hive{data: data, root: bb.root, minor: bb.minor}
+	if (bb.primarySeq != bb.secondarySeq || !bb.valid) && len(logs) > 0 {
+		if err := h.replayLogs(bb, logs); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := h.cell(h.root); err != nil {
+		return nil, fmt.Errorf("root key: %w", err)
+	}
+	return h, nil
+}
+
+// replayLogs применяет журналы транзакций к копии куста. Журналы упорядочиваются по номеру
+// последовательности, записи применяются, пока номера идут подряд.
+func (h *Hive) replayLogs(primary *regfBaseBlock, logs [][]byte) error {
+	type logFile struct {
+		data []byte
+		bb   *regfBaseBlock
+	}
+	var parsed []logFile
+	for _, l := range logs {
+		bb, err := parseRegfBaseBlock(l)
+		if err != nil || !bb.valid {
+			continue
+		}
+		parsed = append(parsed, logFile{l, bb})
+	}
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].bb.primarySeq < parsed[j].bb.primarySeq })
+
+	data := append([]byte{}, h.data...)
+	expected := primary.secondarySeq
+	for _, lf := range parsed {
+		switch lf.bb.fileType {
+		case REGF_FILE_LOG_NEW:
+			if lf.bb.primarySeq < expected && expected != 0 {
+				// Журнал целиком старше куста
+				if last := lastRegfLogSeq(lf.data); last < expected {
+					continue
+				}
+			}
+			var n int
+			data, expected, n = replayNewLog(data, lf.data, lf.bb.primarySeq, expected)
+			h.Replayed += n
+		case REGF_FILE_LOG_LEGACY:
+			var n int
+			data, n = replayLegacyLog(data, lf.data, lf.bb.binsSize)
+			h.Replayed += n
+			h.root = lf.bb.root
+		}
+	}
+	h.data = data
+	return nil
+}
+
+// lastRegfLogSeq возвращает номер последовательности последней корректной записи журнала.
+func lastRegfLogSeq(log []byte) uint32 {
+	var last uint32
+	for off := REGF_LOG_HEADER_SIZE; ; {
+		entry, seq, ok := regfLogEntry(log, off)
+		if !ok {
+			return last
+		}
+		last = seq
+		off += len(entry)
+	}
+}
+
+// regfLogEntry проверяет запись HvLE журнала нового формата по смещению off.
+func regfLogEntry(log []byte, off int) ([]byte, uint32, bool) {
+	le := binary.LittleEndian
+	if off+REGF_LOG_ENTRY_HDR_SIZE > len(log) || string(log[off:off+4]) != "HvLE" {
+		return nil, 0, false
+	}
+	size := int(le.Uint32(log[off+4:]))
+	if size < REGF_LOG_ENTRY_HDR_SIZE || size%REGF_SECTOR_SIZE != 0 || off+size > len(log) {
+		return nil, 0, false
+	}
+	entry := log[off : off+size]
+	if marvin32(entry[REGF_LOG_ENTRY_HDR_SIZE:]) != le.Uint64(entry[24:]) || marvin32(entry[:32]) != le.Uint64(entry[32:]) {
+		return nil, 0, false
+	}
+	return entry, le.Uint32(entry[12:]), true
+}
+
+// replayNewLog применяет записи HvLE журнала с номерами от expected подряд.
+// Возвращает обновлённый образ куста, следующий ожидаемый номер и число применённых записей.
+// Запись, увеличивающая куст сверх REGF_MAX_HIVE_SIZE или больше, чем на объём своих страниц,
+// считается повреждённой: новые блоки hbin целиком входят в изменённые страницы.
+func replayNewLog(data, log []byte, logSeq, expected uint32) ([]byte, uint32, int) {
+	le := binary.LittleEndian
+	applied := 0
+	for off := REGF_LOG_HEADER_SIZE; ; {
+		entry, seq, ok := regfLogEntry(log, off)
+		if !ok {
+			break
+		}
+		off += len(entry)
+		if seq < expected {
+			continue
+		}
+		if seq != expected && !(applied == 0 && expected == 0) {
+			break
+		}
+		binsSize := int(le.Uint32(entry[16:]))
+		count := int(le.Uint32(entry[20:]))
+		refs := entry[REGF_LOG_ENTRY_HDR_SIZE:]
+		if count*8 > len(refs) {
+			break
+		}
+		dirty := 0
+		for i := 0; i < count; i++ {
+			dirty += int(le.Uint32(refs[i*8+4:]))
+		}
+		if need := REGF_BASE_BLOCK_SIZE + binsSize; need > len(data) {
+			if need > REGF_MAX_HIVE_SIZE || need-len(data) > dirty || dirty > len(refs) {
+				break
+			}
+			data = append(data, make([]byte, need-len(data))...)
+		}
+		pages := refs[count*8:]
+		for i := 0; i < count; i++ {
+			pageOff := int(le.Uint32(refs[i*8:]))
+			pageSize := int(le.Uint32(refs[i*8+4:]))
+			if pageSize > len(pages) || REGF_BASE_BLOCK_SIZE+pageOff+pageSize > len(data) {
+				break
+			}
+			copy(data[REGF_BASE_BLOCK_SIZE+pageOff:], pages[:pageSize])
+			pages = pages[pageSize:]
+		}
+		expected = seq + 1
+		applied++
+	}
+	return data, expected, applied
+}
+
+// replayLegacyLog применяет журнал старого формата: за базовым блоком следует сигнатура
+// DIRT и битовая карта изменённых секторов (по биту на 512 байт данных hbin), затем сами сектора.
+// Как и в replayNewLog, куст не увеличивается сверх REGF_MAX_HIVE_SIZE и больше, чем на объём
+// изменённых секторов, иначе журнал не применяется.
+func replayLegacyLog(data, log []byte, binsSize uint32) ([]byte, int) {
+	off := REGF_LOG_HEADER_SIZE
+	if off+4 > len(log) || string(log[off:off+4]) != "DIRT" {
+		return data, 0
+	}
+	sectors := int(binsSize) / REGF_SECTOR_SIZE
+	bitmap := log[off+4:]
+	if (sectors+7)/8 > len(bitmap) {
+		return data, 0
+	}
+	bitmap = bitmap[:(sectors+7)/8]
+	pos := off + 4 + len(bitmap)
+	pos = (pos + REGF_SECTOR_SIZE - 1) / REGF_SECTOR_SIZE * REGF_SECTOR_SIZE
+	if need := REGF_BASE_BLOCK_SIZE + int(binsSize); need > len(data) {
+		dirty := 0
+		for _, b := range bitmap {
+			dirty += bits.OnesCount8(b) * REGF_SECTOR_SIZE
+		}
+		if need > REGF_MAX_HIVE_SIZE || need-len(data) > dirty || dirty > len(log)-pos {
+			return data, 0
+		}
+		data = append(data, make([]byte, need-len(data))...)
+	}
+	applied := 0
+	for i := 0; i < sectors; i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		if pos+REGF_SECTOR_SIZE > len(log) {
+			break
+		}
+		copy(data[REGF_BASE_BLOCK_SIZE+i*REGF_SECTOR_SIZE:], log[pos:pos+REGF_SECTOR_SIZE])
+		pos += REGF_SECTOR_SIZE
+		applied++
+	}
+	return data, applied
+}
+
+// marvin32 вычисляет 64-битный хеш Marvin32, которым защищены записи журналов транзакций.
+func marvin32(data []byte) uint64 {
+	seed := uint64(REGF_MARVIN32_SEED)
+	lo, hi := uint32(seed), uint32(seed>>32)
+	block := func() {
+		hi ^= lo
+		lo = bits.RotateLeft32(lo, 20)
+		lo += hi
+		hi = bits.RotateLeft32(hi, 9)
+		hi ^= lo
+		lo = bits.RotateLeft32(lo, 27)
+		lo += hi
+		hi = bits.RotateLeft32(hi, 19)
+	}
+	for len(data) >= 4 {
+		lo += binary.LittleEndian.Uint32(data)
+		block()
+		data = data[4:]
+	}
+	final := uint32(0x80)
+	for i := len(data) - 1; i >= 0; i-- {
+		final = final<<8 | uint32(data[i])
+	}
+	lo += final
+	block()
+	block()
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+// cell возвращает содержимое выделенной ячейки по её смещению.
+func (h *Hive) cell(off uint32) ([]byte, error) {
+	pos := REGF_BASE_BLOCK_SIZE + int64(off)
+	if off == REGF_NO_OFFSET || pos+4 > int64(len(h.data)) {
+		return nil, fmt.Errorf("%w: cell offset 0x%x out of range", errRegfCorrupt, off)
+	}
+	size := int32(binary.LittleEndian.Uint32(h.data[pos:]))
+	if size < 0 {
+		size = -size
+	}
+	if size < 8 || pos+int64(size) > int64(len(h.data)) {
+		return nil, fmt.Errorf("%w: bad cell size at 0x%x", errRegfCorrupt, off)
+	}
+	return h.data[pos+4 : pos+int64(size)], nil
+}
+
+// RegKey — ключ куста (ячейка nk).
+type RegKey struct {
+	h         *Hive
+	Name      string
+	LastWrite time.Time
+	Offset    uint32 // смещение ячейки nk в кусте
+
+	subkeyCount uint32
+	subkeyList  uint32
+	valueCount  uint32
+	valueList   uint32
+}
+
+// Root возвращает корневой ключ куста.
+func (h *Hive) Root() (*RegKey, error) {
+	return h.key(h.root)
+}
+
+func (h *Hive) key(off uint32) (*RegKey, error) {
+	c, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) < 76 || string(c[:2]) != "nk" {
+		return nil, fmt.Errorf("%w: expected nk cell at 0x%x", errRegfCorrupt, off)
+	}
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(c[72:]))
+	if 76+nameLen > len(c) {
+		return nil, fmt.Errorf("%w: key name out of cell at 0x%x", errRegfCorrupt, off)
+	}
+	return &RegKey{
+		h:           h,
+		Offset:      off,
+		Name:        decodeRegName(c[76:76+nameLen], le.Uint16(c[2:])&REGF_KEY_COMP_NAME != 0),
+		LastWrite:   filetimeToTime(le.Uint64(c[4:])),
+		subkeyCount: le.Uint32(c[20:]),
+		subkeyList:  le.Uint32(c[28:]),
+		valueCount:  le.Uint32(c[36:]),
+		valueList:   le.Uint32(c[40:]),
+	}, nil
+}
+
+// decodeRegName декодирует имя ключа или значения: сжатое (Latin-1) или UTF-16LE.
+func decodeRegName(b []byte, compressed bool) string {
+	if compressed {
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return string(runes)
+	}
+	return decodeUTF16(b)
+}
+
+// filetimeToTime переводит FILETIME (интервалы по 100 нс с 1601 года) во время UTC.
+func filetimeToTime(ft uint64) time.Time {
+	if ft == 0 {
+		return time.Time{}
+	}
+	const epochDiff = 116444736000000000
+	if ft < epochDiff {
+		return time.Time{}
+	}
+	ft -= epochDiff
+	return time.Unix(int64(ft/10000000), int64(ft%10000000)*100).UTC()
+}
+
+// Subkeys возвращает подключи в порядке списка (lf, lh, li и ri).
+func (k *RegKey) Subkeys() ([]*RegKey, error) {
+	if k.subkeyCount == 0 || k.subkeyList == REGF_NO_OFFSET {
+		return nil, nil
+	}
+	limit := REGF_MAX_SUBKEYS
+	if k.subkeyCount < REGF_MAX_SUBKEYS {
+		limit = int(k.subkeyCount)
+	}
+	offsets, err := k.h.subkeyOffsets(k.subkeyList, limit)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]*RegKey, 0, len(offsets))
+	for _, off := range offsets {
+		sub, err := k.h.key(off)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, sub)
+	}
+	return keys, nil
+}
+
+// subkeyOffsets разворачивает список подключей; ri ссылается на вложенные списки.
+// Каждый список читается не больше одного раза, а смещений собирается не больше limit:
+// ri, ссылающиеся на один и тот же список, иначе размножают его экспоненциально.
+func (h *Hive) subkeyOffsets(root uint32, limit int) ([]uint32, error) {
+	var out []uint32
+	visited := make(map[uint32]bool)
+	var walk func(off uint32, depth int) error
+	walk = func(off uint32, depth int) error {
+		if depth > REGF_MAX_DEPTH {
+			return fmt.Errorf("%w: subkey index too deep", errRegfCorrupt)
+		}
+		if visited[off] {
+			return fmt.Errorf("%w: subkey list loop at 0x%x", errRegfCorrupt, off)
+		}
+		visited[off] = true
+		c, err := h.cell(off)
+		if err != nil {
+			return err
+		}
+		if len(c) < 4 {
+			return fmt.Errorf("%w: short subkey list", errRegfCorrupt)
+		}
+		le := binary.LittleEndian
+		count := int(le.Uint16(c[2:]))
+		stride := 8
+		switch string(c[:2]) {
+		case "lf", "lh":
+		case "li", "ri":
+			stride = 4
+		default:
+			return fmt.Errorf("%w: unknown subkey list %q at 0x%x", errRegfCorrupt, c[:2], off)
+		}
+		if 4+count*stride > len(c) {
+			return fmt.Errorf("%w: subkey list out of cell", errRegfCorrupt)
+		}
+		for i := 0; i < count; i++ {
+			ref := le.Uint32(c[4+i*stride:])
+			if string(c[:2]) == "ri" {
+				if err := walk(ref, depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(out) >= limit {
+				return fmt.Errorf("%w: subkey list exceeds %d entries", errRegfCorrupt, limit)
+			}
+			out = append(out, ref)
+		}
+		return nil
+	}
+	err := walk(root, 0)
+	return out, err
+}
+
+// Subkey возвращает подключ по имени без учёта регистра или nil, если его нет.
+func (k *RegKey) Subkey(name string) (*RegKey, error) {
+	subs, err := k.Subkeys()
+	for _, s := range subs {
+		if strings.EqualFold(s.Name, name) {
+			return s, nil
+		}
+	}
+	return nil, err
+}
+
+// Open возвращает вложенный ключ по пути через "\" или nil, если его нет.
+func (k *RegKey) Open(path string) (*RegKey, error) {
+	cur := k
+	for _, part := range strings.Split(path, `\`) {
+		if part == "" {
+			continue
+		}
+		next, err := cur.Subkey(part)
+		if next == nil || err != nil {
+			return nil, err
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// RegValue — значение ключа (ячейка vk).
+type RegValue struct {
+	Name string
+	Type uint32
+	Data []byte
+}
+
+// Values возвращает значения ключа.
+func (k *RegKey) Values() ([]*RegValue, error) {
+	if k.valueCount == 0 || k.valueList == REGF_NO_OFFSET {
+		return nil, nil
+	}
+	list, err := k.h.cell(k.valueList)
+	if err != nil {
+		return nil, err
+	}
+	if int(k.valueCount)*4 > len(list) {
+		return nil, fmt.Errorf("%w: value list out of cell", errRegfCorrupt)
+	}
+	values := make([]*RegValue, 0, k.valueCount)
+	for i := 0; i < int(k.valueCount); i++ {
+		v, err := k.h.value(binary.LittleEndian.Uint32(list[i*4:]))
+		if err != nil {
+			return values, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// Value возвращает значение по имени без учёта регистра ("" — значение по умолчанию) или nil.
+func (k *RegKey) Value(name string) (*RegValue, error) {
+	values, err := k.Values()
+	for _, v := range values {
+		if strings.EqualFold(v.Name, name) {
+			return v, nil
+		}
+	}
+	return nil, err
+}
+
+func (h *Hive) value(off uint32) (*RegValue, error) {
+	c, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if len(c) < 20 || string(c[:2]) != "vk" {
+		return nil, fmt.Errorf("%w: expected vk cell at 0x%x", errRegfCorrupt, off)
+	}
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(c[2:]))
+	if 20+nameLen > len(c) {
+		return nil, fmt.Errorf("%w: value name out of cell at 0x%x", errRegfCorrupt, off)
+	}
+	v := &RegValue{
+		Name: decodeRegName(c[20:20+nameLen], le.Uint16(c[16:])&REGF_VALUE_COMP_NAME != 0),
+		Type: le.Uint32(c[12:]),
+	}
+	size := le.Uint32(c[4:])
+	if size&REGF_DATA_INLINE != 0 {
+		size &^= REGF_DATA_INLINE
+		if size > 4 {
+			size = 4
+		}
+		v.Data = append([]byte{}, c[8:8+size]...)
+		return v, nil
+	}
+	if size == 0 {
+		return v, nil
+	}
+	v.Data, err = h.valueData(le.Uint32(c[8:]), size)
+	return v, err
+}
+
+// valueData читает данные значения; большие значения (> 16344 байт, куст версии 1.4+)
+// хранятся сегментами через ячейку db.
+func (h *Hive) valueData(off, size uint32) ([]byte, error) {
+	c, err := h.cell(off)
+	if err != nil {
+		return nil, err
+	}
+	if size > REGF_BIG_DATA_LIMIT && h.minor >= 4 && len(c) >= 8 && string(c[:2]) == "db" {
+		le := binary.LittleEndian
+		count := int(le.Uint16(c[2:]))
+		list, err := h.cell(le.Uint32(c[4:]))
+		if err != nil {
+			return nil, err
+		}
+		if count*4 > len(list) {
+			return nil, fmt.Errorf("%w: big data list out of cell", errRegfCorrupt)
+		}
+		data := make([]byte, 0, size)
+		for i := 0; i < count && uint32(len(data)) < size; i++ {
+			seg, err := h.cell(le.Uint32(list[i*4:]))
+			if err != nil {
+				return nil, err
+			}
+			n := uint32(len(seg))
+			if n > REGF_BIG_DATA_LIMIT {
+				n = REGF_BIG_DATA_LIMIT
+			}
+			if rest := size - uint32(len(data)); n > rest {
+				n = rest
+			}
+			data = append(data, seg[:n]...)
+		}
+		return data, nil
+	}
+	if int(size) > len(c) {
+		return nil, fmt.Errorf("%w: value data out of cell at 0x%x", errRegfCorrupt, off)
+	}
+	return append([]byte{}, c[:size]...), nil
+}
+
+// TypeName возвращает имя типа значения.
+func (v *RegValue) TypeName() string {
+	return registryTypeName(v.Type)
+}
+
+// Decoded возвращает данные значения в виде, совместимом с записями живого реестра:
+// строки — string, REG_MULTI_SZ — []string, целые — uint64, остальное — []byte.
+func (v *RegValue) Decoded() interface{} {
+	le := binary.LittleEndian
+	switch v.Type {
+	case REG_SZ, REG_EXPAND_SZ, REG_LINK:
+		if len(v.Data)%2 == 0 {
+			return strings.TrimRight(decodeUTF16(v.Data), "\x00")
+		}
+	case REG_MULTI_SZ:
+		if len(v.Data)%2 == 0 {
+			u := make([]uint16, len(v.Data)/2)
+			for i := range u {
+				u[i] = le.Uint16(v.Data[i*2:])
+			}
+			var res []string
+			for _, s := range strings.Split(string(utf16.Decode(u)), "\x00") {
+				if s != "" {
+					res = append(res, s)
+				}
+			}
+			return res
+		}
+	case REG_DWORD:
+		if len(v.Data) >= 4 {
+			return uint64(le.Uint32(v.Data))
+		}
+	case REG_DWORD_BIG_ENDIAN:
+		if len(v.Data) >= 4 {
+			return uint64(binary.BigEndian.Uint32(v.Data))
+		}
+	case REG_QWORD:
+		if len(v.Data) >= 8 {
+			return le.Uint64(v.Data)
+		}
+	}
+	return bytes.Clone(v.Data)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testHive собирает синтетический куст: ячейки добавляются в один блок hbin снизу вверх.
+type testHive struct {
+	bins []byte
+}
+
+func newTestHive() *testHive {
+	b := &testHive{bins: make([]byte, 32)}
+	copy(b.bins, "hbin")
+	return b
+}
+
+// cell добавляет выделенную ячейку и возвращает её смещение.
+func (b *testHive) cell(data []byte) uint32 {
+	off := uint32(len(b.bins))
+	size := (len(data) + 4 + 7) &^ 7
+	c := make([]byte, size)
+	binary.LittleEndian.PutUint32(c, uint32(-int32(size)))
+	copy(c[4:], data)
+	b.bins = append(b.bins, c...)
+	return off
+}
+
+func (b *testHive) value(name string, typ uint32, data []byte) uint32 {
+	vk := make([]byte, 20+len(name))
+	copy(vk, "vk")
+	binary.LittleEndian.PutUint16(vk[2:], uint16(len(name)))
+	binary.LittleEndian.PutUint32(vk[12:], typ)
+	binary.LittleEndian.PutUint16(vk[16:], REGF_VALUE_COMP_NAME)
+	copy(vk[20:], name)
+	switch {
+	case len(data) <= 4:
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(data))|REGF_DATA_INLINE)
+		copy(vk[8:12], data)
+	case len(data) > REGF_BIG_DATA_LIMIT:
+		var list []byte
+		for rest := data; len(rest) > 0; {
+			n := len(rest)
+			if n > REGF_BIG_DATA_LIMIT {
+				n = REGF_BIG_DATA_LIMIT
+			}
+			list = binary.LittleEndian.AppendUint32(list, b.cell(rest[:n]))
+			rest = rest[n:]
+		}
+		db := []byte("db")
+		db = binary.LittleEndian.AppendUint16(db, uint16(len(list)/4))
+		db = binary.LittleEndian.AppendUint32(db, b.cell(list))
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(vk[8:], b.cell(db))
+	default:
+		binary.LittleEndian.PutUint32(vk[4:], uint32(len(data)))
+		binary.LittleEndian.PutUint32(vk[8:], b.cell(data))
+	}
+	return b.cell(vk)
+}
+
+// key добавляет ключ; при useRI подключи раскладываются по двум спискам li под индексом ri.
+func (b *testHive) key(name string, lastWrite time.Time, subkeys, values []uint32, useRI bool) uint32 {
+	nk := make([]byte, 76+len(name))
+	copy(nk, "nk")
+	binary.LittleEndian.PutUint16(nk[2:], REGF_KEY_COMP_NAME)
+	binary.LittleEndian.PutUint64(nk[4:], uint64(lastWrite.UnixNano()/100+116444736000000000))
+	binary.LittleEndian.PutUint32(nk[20:], uint32(len(subkeys)))
+	binary.LittleEndian.PutUint32(nk[28:], REGF_NO_OFFSET)
+	binary.LittleEndian.PutUint32(nk[36:], uint32(len(values)))
+	binary.LittleEndian.PutUint32(nk[40:], REGF_NO_OFFSET)
+	binary.LittleEndian.PutUint16(nk[72:], uint16(len(name)))
+	copy(nk[76:], name)
+	if len(subkeys) > 0 {
+		list := func(sig string, offs []uint32) uint32 {
+			l := binary.LittleEndian.AppendUint16([]byte(sig), uint16(len(offs)))
+			for _, off := range offs {
+				l = binary.LittleEndian.AppendUint32(l, off)
+				if sig == "lh" {
+					l = binary.LittleEndian.AppendUint32(l, 0)
+				}
+			}
+			return b.cell(l)
+		}
+		off := uint32(0)
+		if useRI && len(subkeys) > 1 {
+			half := len(subkeys) / 2
+			off = list("ri", []uint32{list("li", subkeys[:half]), list("li", subkeys[half:])})
+		} else {
+			off = list("lh", subkeys)
+		}
+		binary.LittleEndian.PutUint32(nk[28:], off)
+	}
+	if len(values) > 0 {
+		var l []byte
+		for _, v := range values {
+			l = binary.LittleEndian.AppendUint32(l, v)
+		}
+		binary.LittleEndian.PutUint32(nk[40:], b.cell(l))
+	}
+	return b.cell(nk)
+}
+
+// file возвращает образ файла куста с корнем root и номерами последовательности seq.
+func (b *testHive) file(root, primarySeq, secondarySeq uint32) []byte {
+	bins := append([]byte{}, b.bins...)
+	if pad := len(bins) % 4096; pad != 0 {
+		free := make([]byte, 4096-pad)
+		binary.LittleEndian.PutUint32(free, uint32(len(free)))
+		bins = append(bins, free...)
+	}
+	binary.LittleEndian.PutUint32(bins[8:], uint32(len(bins)))
+	base := testBaseBlock(REGF_FILE_PRIMARY, root, uint32(len(bins)), primarySeq, secondarySeq)
+	return append(base, bins...)
+}
+
+func testBaseBlock(fileType, root, binsSize, primarySeq, secondarySeq uint32) []byte {
+	base := make([]byte, REGF_BASE_BLOCK_SIZE)
+	copy(base, "regf")
+	le := binary.LittleEndian
+	le.PutUint32(base[4:], primarySeq)
+	le.PutUint32(base[8:], secondarySeq)
+	le.PutUint32(base[20:], 1)
+	le.PutUint32(base[24:], 5)
+	le.PutUint32(base[28:], fileType)
+	le.PutUint32(base[32:], 1)
+	le.PutUint32(base[36:], root)
+	le.PutUint32(base[40:], binsSize)
+	le.PutUint32(base[508:], regfChecksum(base))
+	return base
+}
+
+// buildRunHive строит куст с ключом Software\Run и значением updater = path.
+func buildRunHive(path string) []byte {
+	b := newTestHive()
+	lw := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	big := bytes.Repeat([]byte{0xAB}, REGF_BIG_DATA_LIMIT+100)
+	run := b.key("Run", lw, nil, []uint32{
+		b.value("updater", REG_SZ, utf16z(path)),
+		b.value("", REG_EXPAND_SZ, utf16z(`%SystemRoot%\x.exe`)),
+		b.value("Count", REG_DWORD, []byte{7, 0, 0, 0}),
+		b.value("Big", REG_BINARY, big),
+		b.value("Multi", REG_MULTI_SZ, append(utf16z("a"), utf16z("b\x00")...)),
+		b.value("Q", REG_QWORD, []byte{1, 0, 0, 0, 0, 0, 0, 1}),
+	}, false)
+	other := b.key("Other", lw, nil, nil, false)
+	third := b.key("Third", lw, nil, nil, false)
+	software := b.key("Software", lw.Add(-time.Hour), []uint32{other, run, third}, nil, true)
+	root := b.key("ROOT", lw, []uint32{software}, nil, false)
+	return b.file(root, 1, 1)
+}
+
+func TestParseHive(t *testing.T) {
+	data := buildRunHive(`C:\evil.exe`)
+	h, err := ParseHive(data)
+	assert.NoError(t, err)
+	root, err := h.Root()
+	assert.NoError(t, err)
+
+	run, err := root.Open(`SOFTWARE\run`)
+	assert.NoError(t, err)
+	if !assert.NotNil(t, run) {
+		return
+	}
+	assert.Equal(t, "Run", run.Name)
+	assert.Equal(t, time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC), run.LastWrite)
+
+	values, err := run.Values()
+	assert.NoError(t, err)
+	decoded := map[string]interface{}{}
+	types := map[string]string{}
+	for _, v := range values {
+		decoded[v.Name] = v.Decoded()
+		types[v.Name] = v.TypeName()
+	}
+	assert.Equal(t, `C:\evil.exe`, decoded["updater"])
+	assert.Equal(t, `%SystemRoot%\x.exe`, decoded[""])
+	assert.Equal(t, uint64(7), decoded["Count"])
+	assert.Equal(t, []string{"a", "b"}, decoded["Multi"])
+	assert.Equal(t, uint64(1)<<56|1, decoded["Q"])
+	assert.Equal(t, bytes.Repeat([]byte{0xAB}, REGF_BIG_DATA_LIMIT+100), decoded["Big"])
+	assert.Equal(t, "REG_EXPAND_SZ", types[""])
+	assert.Equal(t, "REG_DWORD", types["Count"])
+
+	software, _ := root.Subkey("software")
+	subs, err := software.Subkeys()
+	assert.NoError(t, err)
+	var names []string
+	for _, s := range subs {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"Other", "Run", "Third"}, names, "подключи из индекса ri")
+
+	missing, err := root.Open(`Software\Absent`)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+
+	_, err = ParseHive([]byte("not a hive"))
+	assert.Error(t, err)
+	corrupt := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(corrupt[36:], 0x7ffffff0)
+	_, err = ParseHive(corrupt)
+	assert.Error(t, err)
+}
+
+// testNewLog строит журнал нового формата с одной записью, заменяющей все данные hbin.
+func testNewLog(newFile []byte, seq uint32) []byte {
+	bins := newFile[REGF_BASE_BLOCK_SIZE:]
+	le := binary.LittleEndian
+	log := testBaseBlock(REGF_FILE_LOG_NEW, le.Uint32(newFile[36:]), uint32(len(bins)), seq, seq)[:REGF_LOG_HEADER_SIZE]
+	size := (REGF_LOG_ENTRY_HDR_SIZE + 8 + len(bins) + REGF_SECTOR_SIZE - 1) / REGF_SECTOR_SIZE * REGF_SECTOR_SIZE
+	entry := make([]byte, size)
+	copy(entry, "HvLE")
+	le.PutUint32(entry[4:], uint32(size))
+	le.PutUint32(entry[12:], seq)
+	le.PutUint32(entry[16:], uint32(len(bins)))
+	le.PutUint32(entry[20:], 1)
+	le.PutUint32(entry[40:], 0)
+	le.PutUint32(entry[44:], uint32(len(bins)))
+	copy(entry[48:], bins)
+	le.PutUint64(entry[24:], marvin32(entry[REGF_LOG_ENTRY_HDR_SIZE:]))
+	le.PutUint64(entry[32:], marvin32(entry[:32]))
+	return append(log, entry...)
+}
+
+func TestParseHiveTransactionLogs(t *testing.T) {
+	// Старый и новый образы отличаются только данными значения одинаковой длины
+	oldFile := buildRunHive(`C:\good.exe`)
+	newFile := buildRunHive(`C:\evil.exe`)
+	dirty := append([]byte{}, oldFile...)
+	binary.LittleEndian.PutUint32(dirty[4:], 5)
+	binary.LittleEndian.PutUint32(dirty[8:], 4)
+	binary.LittleEndian.PutUint32(dirty[508:], regfChecksum(dirty))
+
+	updater := func(h *Hive) interface{} {
+		root, err := h.Root()
+		if err != nil {
+			return nil
+		}
+		run, _ := root.Open(`Software\Run`)
+		if run == nil {
+			return nil
+		}
+		v, _ := run.Value("updater")
+		if v == nil {
+			return nil
+		}
+		return v.Decoded()
+	}
+
+	h, err := ParseHive(dirty)
+	assert.NoError(t, err)
+	assert.Equal(t, `C:\good.exe`, updater(h), "без журналов читается основной файл")
+
+	h, err = ParseHive(dirty, testNewLog(newFile, 4))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, h.Replayed)
+	assert.Equal(t, `C:\evil.exe`, updater(h))
+	assert.Equal(t, `C:\good.exe`, updater(&Hive{data: oldFile, root: h.root}), "исходный образ не изменяется")
+
+	// Запись с неверным хешем и запись с пропущенным номером не применяются
+	broken := testNewLog(newFile, 4)
+	broken[REGF_LOG_HEADER_SIZE+100] ^= 0xff
+	h, err = ParseHive(dirty, broken)
+	assert.NoError(t, err)
+	assert.Zero(t, h.Replayed)
+	h, err = ParseHive(dirty, testNewLog(newFile, 9))
+	assert.NoError(t, err)
+	assert.Zero(t, h.Replayed)
+
+	// Запись, увеличивающая куст больше, чем на объём своих страниц, не применяется
+	grown := testNewLog(newFile, 4)
+	entry := grown[REGF_LOG_HEADER_SIZE:]
+	binary.LittleEndian.PutUint32(entry[16:], 0x7ffff000)
+	binary.LittleEndian.PutUint64(entry[32:], marvin32(entry[:32]))
+	h, err = ParseHive(dirty, grown)
+	assert.NoError(t, err)
+	assert.Zero(t, h.Replayed)
+	assert.Len(t, h.data, len(dirty))
+
+	// Журнал старого формата: битовая карта DIRT и изменённые сектора
+	bins := newFile[REGF_BASE_BLOCK_SIZE:]
+	legacy := testBaseBlock(REGF_FILE_LOG_LEGACY, binary.LittleEndian.Uint32(newFile[36:]), uint32(len(bins)), 5, 5)[:REGF_LOG_HEADER_SIZE]
+	legacy = append(legacy, "DIRT"...)
+	legacy = append(legacy, bytes.Repeat([]byte{0xff}, len(bins)/REGF_SECTOR_SIZE/8)...)
+	legacy = append(legacy, make([]byte, REGF_SECTOR_SIZE-len(legacy)%REGF_SECTOR_SIZE)...)
+	legacy = append(legacy, bins...)
+	h, err = ParseHive(dirty, legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, len(bins)/REGF_SECTOR_SIZE, h.Replayed)
+	assert.Equal(t, `C:\evil.exe`, updater(h))
+}
+
+// setTestSubkeyList подменяет в образе куста число подключей и список подключей ключа nk.
+func setTestSubkeyList(file []byte, nk, count, list uint32) {
+	c := file[REGF_BASE_BLOCK_SIZE+nk+4:]
+	binary.LittleEndian.PutUint32(c[20:], count)
+	binary.LittleEndian.PutUint32(c[28:], list)
+}
+
+func TestParseHiveSubkeyListLoops(t *testing.T) {
+	b := newTestHive()
+	lw := time.Date(2024, 3, 2, 10, 0, 0, 0, time.UTC)
+	leaf := b.key("Leaf", lw, nil, nil, false)
+	// Цепочка ri, каждый из которых дважды ссылается на следующий
+	chain := b.cell(binary.LittleEndian.AppendUint32([]byte("li\x01\x00"), leaf))
+	for i := 0; i < 60; i++ {
+		ri := binary.LittleEndian.AppendUint32([]byte("ri\x02\x00"), chain)
+		chain = b.cell(binary.LittleEndian.AppendUint32(ri, chain))
+	}
+	// ri, ссылающийся сам на себя: ячейка добавляется по текущему концу hbin
+	loop := b.cell(binary.LittleEndian.AppendUint32([]byte("ri\x01\x00"), uint32(len(b.bins))))
+	// li с тремя подключами при двух, указанных в nk
+	many := []byte("li\x03\x00")
+	for i := 0; i < 3; i++ {
+		many = binary.LittleEndian.AppendUint32(many, leaf)
+	}
+	manyList := b.cell(many)
+	keys := map[string]uint32{}
+	var subkeys []uint32
+	for _, name := range []string{"Chained", "Loop", "Many"} {
+		keys[name] = b.key(name, lw, nil, nil, false)
+		subkeys = append(subkeys, keys[name])
+	}
+	file := b.file(b.key("ROOT", lw, subkeys, nil, false), 1, 1)
+	setTestSubkeyList(file, keys["Chained"], 1, chain)
+	setTestSubkeyList(file, keys["Loop"], 1, loop)
+	setTestSubkeyList(file, keys["Many"], 2, manyList)
+
+	h, err := ParseHive(file)
+	assert.NoError(t, err)
+	root, err := h.Root()
+	assert.NoError(t, err)
+	for _, name := range []string{"Chained", "Loop", "Many"} {
+		k, _ := root.Subkey(name)
+		if !assert.NotNil(t, k, name) {
+			continue
+		}
+		done := make(chan error, 1)
+		go func() {
+			_, err := k.Subkeys()
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			assert.ErrorIs(t, err, errRegfCorrupt, name)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: список подключей обходится неограниченно", name)
+		}
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// REPORT_TOP_FILES — число крупнейших файлов в отчёте.
+	REPORT_TOP_FILES = 20
+	// REPORT_MAX_FAILURES — число записей об ошибках, выводимых в отчёте построчно.
+	REPORT_MAX_FAILURES = 200
+)
+
+// artifactStats накапливает статистику сбора по одному артефакту.
+type artifactStats struct {
+	Name      string
+	Files     int
+	Bytes     int64
+	FileInfos int
+	Commands  int
+	WMI       int
+	Registry  int
+	Errors    int
+	First     time.Time
+	Last      time.Time
+}
+
+// Duration возвращает время между первым и последним результатом артефакта.
+func (s *artifactStats) Duration() time.Duration {
+	return s.Last.Sub(s.First).Round(time.Millisecond)
+}
+
+// reportFile — собранный файл в списке крупнейших.
+type reportFile struct {
+	Artifact string
+	Path     string
+	Size     int64
+	Link     string
+}
+
+// collectionStats — статистика сбора, которую Outputs ведёт для итогового отчёта.
+type collectionStats struct {
+	mu        sync.Mutex
+	started   time.Time
+	artifacts map[string]*artifactStats
+	largest   []reportFile
+}
+
+func newCollectionStats() *collectionStats {
+	return &collectionStats{
+		started:   time.Now(),
+		artifacts: make(map[string]*artifactStats),
+	}
+}
+
+// track обновляет статистику артефакта под блокировкой.
+func (cs *collectionStats) track(artifact string, update func(s *artifactStats)) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	s, ok := cs.artifacts[artifact]
+	now := time.Now()
+	if !ok {
+		s = &artifactStats{Name: artifact, First: now}
+		cs.artifacts[artifact] = s
+	}
+	s.Last = now
+	update(s)
+}
+
+// addFile учитывает собранный файл и поддерживает список крупнейших файлов.
+func (cs *collectionStats) addFile(artifact, path string, size int64, link string) {
+	cs.track(artifact, func(s *artifactStats) {
+		s.Files++
+		s.Bytes += size
+	})
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if len(cs.largest) == REPORT_TOP_FILES && cs.largest[len(cs.largest)-1].Size >= size {
+		return
+	}
+	cs.largest = append(cs.largest, reportFile{Artifact: artifact, Path: path, Size: size, Link: link})
+	sort.SliceStable(cs.largest, func(i, j int) bool { return cs.largest[i].Size > cs.largest[j].Size })
+	if len(cs.largest) > REPORT_TOP_FILES {
+		cs.largest = cs.largest[:REPORT_TOP_FILES]
+	}
+}
+
+// reportFailureGroup — число ошибок сбора с одним кодом причины.
+type reportFailureGroup struct {
+	Reason string
+	Count  int
+}
+
+// reportFlagged — файл, получивший вердикт в результатах анализа или совпавший с правилами.
+type reportFlagged struct {
+	Path          string
+	Md5           string
+	Zone          string
+	Verdict       string
+	DetectionName string
+	Link          string
+}
+
+// collectionReport — данные итогового отчёта о сборе.
+type collectionReport struct {
+	Hostname      string
+	Platform      string
+	Arch          string
+	Started       string
+	Finished      string
+	Duration      string
+	OutputDir     string
+	Archive       string
+	Config        [][2]string
+	Artifacts     []*artifactStats
+	TotalFiles    int
+	TotalBytes    int64
+	TotalErrors   int
+	FailureGroups []reportFailureGroup
+	Failures      []CollectionErrorRecord
+	MoreFailures  int
+	Largest       []reportFile
+	Flagged       []reportFlagged
+
+	AnalysisUnfinished int64
+	AnalysisPending    string
+}
+
+// SetReportConfig задаёт параметры запуска, выводимые в отчёте.
+func (o *Outputs) SetReportConfig(config map[string]string) {
+	o.reportConfig = config
+}
+
+// SetReport управляет формированием отчёта report.html / report.md при закрытии Outputs.
+func (o *Outputs) SetReport(report bool) {
+	o.report = report
+}
+
+// archiveName возвращает имя хранилища собранных файлов относительно каталога результатов.
+func (o *Outputs) archiveName() string {
+	switch o.archiveFormat {
+	case ARCHIVE_FORMAT_TAR:
+		return fmt.Sprintf("%s-files.tar", o.hostname)
+	case ARCHIVE_FORMAT_DIR:
+		return fmt.Sprintf("%s-files", o.hostname)
+	}
+	return fmt.Sprintf("%s-files.zip", o.hostname)
+}
+
+// archiveLink возвращает относительную ссылку на собранный файл: для формата dir — на сам файл,
+// для zip/tar — на архив (ссылки внутрь архива браузеры не поддерживают).
+func (o *Outputs) archiveLink(name string) string {
+	if o.archiveFormat == ARCHIVE_FORMAT_DIR {
+		return filepath.ToSlash(filepath.Join(o.archiveName(), strings.TrimLeft(name, `\/`)))
+	}
+	return o.archiveName()
+}
+
+// buildReport собирает данные отчёта из накопленной статистики и файлов результатов.
+func (o *Outputs) buildReport() (*collectionReport, error) {
+	finished := time.Now()
+	o.stats.mu.Lock()
+	report := &collectionReport{
+		Hostname:  o.hostname,
+		Platform:  runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Started:   o.stats.started.Format(time.RFC3339),
+		Finished:  finished.Format(time.RFC3339),
+		Duration:  finished.Sub(o.stats.started).Round(time.Second).String(),
+		OutputDir: o.dirpath,
+		Largest:   append([]reportFile(nil), o.stats.largest...),
+	}
+	for _, s := range o.stats.artifacts {
+		copied := *s
+		report.Artifacts = append(report.Artifacts, &copied)
+		report.TotalFiles += s.Files
+		report.TotalBytes += s.Bytes
+	}
+	o.stats.mu.Unlock()
+
+	if o.archive != nil {
+		report.Archive = o.archiveName()
+	}
+	sort.Slice(report.Artifacts, func(i, j int) bool { return report.Artifacts[i].Name < report.Artifacts[j].Name })
+
+	keys := make([]string, 0, len(o.reportConfig))
+	for k := range o.reportConfig {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		report.Config = append(report.Config, [2]string{k, o.reportConfig[k]})
+	}
+
+	// Ошибки сбора
+	groups := make(map[string]int)
+	err := readJSONL(o.errors.path, func(line []byte) error {
+		var rec CollectionErrorRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		report.TotalErrors++
+		groups[rec.Reason]++
+		if len(report.Failures) < REPORT_MAX_FAILURES {
+			report.Failures = append(report.Failures, rec)
+		} else {
+			report.MoreFailures++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for reason, count := range groups {
+		report.FailureGroups = append(report.FailureGroups, reportFailureGroup{Reason: reason, Count: count})
+	}
+	sort.Slice(report.FailureGroups, func(i, j int) bool {
+		return report.FailureGroups[i].Count > report.FailureGroups[j].Count
+	})
+
+	if o.analysisQueue != nil {
+		report.AnalysisUnfinished = o.analysisQueue.Unfinished()
+		report.AnalysisPending = filepath.Base(o.analysisQueue.PendingPath())
+	}
+
+	// Файлы, отмеченные анализом
+	if o.analysisResults != "" {
+		err := readJSONL(o.analysisResults, func(line []byte) error {
+			var res outStruct
+			if err := json.Unmarshal(line, &res); err != nil {
+				return err
+			}
+			if !res.flagged() {
+				return nil
+			}
+			report.Flagged = append(report.Flagged, reportFlagged{
+				Path:          res.Path,
+				Md5:           res.Md5,
+				Zone:          res.Zone,
+				Verdict:       res.Verdict,
+				DetectionName: res.DetectionName,
+				Link:          o.archiveLink(normalizeFilepath(res.Path)),
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Файлы, на которых сработали правила проверки содержимого
+	var ruleHits []*reportFlagged
+	ruleHitsByPath := make(map[string]*reportFlagged)
+	err = readJSONL(o.matches.path, func(line []byte) error {
+		var rec struct {
+			File struct {
+				Path string            `json:"path"`
+				Hash map[string]string `json:"hash"`
+			} `json:"file"`
+			Rule RuleMatch `json:"rule"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		hit, ok := ruleHitsByPath[rec.File.Path]
+		if !ok {
+			hit = &reportFlagged{
+				Path:    rec.File.Path,
+				Md5:     rec.File.Hash["md5"],
+				Verdict: "rule",
+				Link:    o.archiveLink(normalizeFilepath(rec.File.Path)),
+			}
+			ruleHitsByPath[rec.File.Path] = hit
+			ruleHits = append(ruleHits, hit)
+		}
+		if hit.DetectionName != "" {
+			hit.DetectionName += ", "
+		}
+		hit.DetectionName += rec.Rule.Rule
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, hit := range ruleHits {
+		report.Flagged = append(report.Flagged, *hit)
+	}
+	return report, nil
+}
+
+// writeReport формирует отчёт о сборе в виде автономного HTML и Markdown.
+func (o *Outputs) writeReport() error {
+	report, err := o.buildReport()
+	if err != nil {
+		return err
+	}
+	htmlPath := filepath.Join(o.dirpath, fmt.Sprintf("%s-report.html", o.hostname))
+	if err := renderReport(htmlPath, func(f *os.File) error { return reportHTMLTemplate.Execute(f, report) }); err != nil {
+		return err
+	}
+	mdPath := filepath.Join(o.dirpath, fmt.Sprintf("%s-report.md", o.hostname))
+	return renderReport(mdPath, func(f *os.File) error { return reportMarkdownTemplate.Execute(f, report) })
+}
+
+func renderReport(path string, render func(f *os.File) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := render(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// humanSize форматирует размер в байтах в виде "12.3 MiB".
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// mdEscape экранирует символы, ломающие таблицы Markdown.
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "\n", " ", "\r", "").Replace(s)
+}
+
+var reportFuncs = map[string]interface{}{
+	"humanSize": humanSize,
+	"md":        mdEscape,
+}
+
+var reportHTMLTemplate = htmltemplate.Must(htmltemplate.New("report").Funcs(reportFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>fast_dfar report — {{.Hostname}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; font-size: 0.9em; }
+th { background: #f0f0f0; }
+td.num { text-align: right; }
+.zone-Red, .verdict-malicious { background: #f8d0d0; }
+.zone-Orange, .zone-Yellow, .verdict-suspicious { background: #fbe9c8; }
+.zone-Grey { background: #e8e8e8; }
+</style>
+</head>
+<body>
+<h1>Collection report: {{.Hostname}}</h1>
+
+<h2>Host</h2>
+<table>
+<tr><th>Hostname</th><td>{{.Hostname}}</td></tr>
+<tr><th>Platform</th><td>{{.Platform}}/{{.Arch}}</td></tr>
+<tr><th>Started</th><td>{{.Started}}</td></tr>
+<tr><th>Finished</th><td>{{.Finished}}</td></tr>
+<tr><th>Duration</th><td>{{.Duration}}</td></tr>
+<tr><th>Output</th><td>{{.OutputDir}}</td></tr>
+{{if .Archive}}<tr><th>Archive</th><td><a href="{{.Archive}}">{{.Archive}}</a></td></tr>{{end}}
+{{if .AnalysisUnfinished}}<tr><th>Unfinished analysis</th><td>{{.AnalysisUnfinished}} files, see {{.AnalysisPending}}</td></tr>{{end}}
+</table>
+
+{{if .Config}}<h2>Configuration</h2>
+<table>
+{{range .Config}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>{{end}}
+
+<h2>Artifacts</h2>
+<table>
+<tr><th>Artifact</th><th>Files</th><th>Bytes</th><th>File info</th><th>Commands</th><th>WMI</th><th>Registry</th><th>Errors</th><th>Duration</th></tr>
+{{range .Artifacts}}<tr><td>{{.Name}}</td><td class="num">{{.Files}}</td><td class="num">{{humanSize .Bytes}}</td><td class="num">{{.FileInfos}}</td><td class="num">{{.Commands}}</td><td class="num">{{.WMI}}</td><td class="num">{{.Registry}}</td><td class="num">{{.Errors}}</td><td>{{.Duration}}</td></tr>
+{{end}}<tr><th>Total</th><th class="num">{{.TotalFiles}}</th><th class="num">{{humanSize .TotalBytes}}</th><th colspan="4"></th><th class="num">{{.TotalErrors}}</th><th></th></tr>
+</table>
+
+{{if .Flagged}}<h2>Files flagged by analysis and rules</h2>
+<table>
+<tr><th>Verdict</th><th>Zone</th><th>Path</th><th>MD5</th><th>Detection</th></tr>
+{{range .Flagged}}<tr class="zone-{{.Zone}} verdict-{{.Verdict}}"><td>{{.Verdict}}</td><td>{{.Zone}}</td><td><a href="{{.Link}}">{{.Path}}</a></td><td>{{.Md5}}</td><td>{{.DetectionName}}</td></tr>
+{{end}}</table>{{end}}
+
+{{if .Largest}}<h2>Largest files</h2>
+<table>
+<tr><th>Size</th><th>Path</th><th>Artifact</th></tr>
+{{range .Largest}}<tr><td class="num">{{humanSize .Size}}</td><td><a href="{{.Link}}">{{.Path}}</a></td><td>{{.Artifact}}</td></tr>
+{{end}}</table>{{end}}
+
+{{if .FailureGroups}}<h2>Failures</h2>
+<table>
+<tr><th>Reason</th><th>Count</th></tr>
+{{range .FailureGroups}}<tr><td>{{.Reason}}</td><td class="num">{{.Count}}</td></tr>
+{{end}}</table>
+<table>
+<tr><th>Artifact</th><th>Source</th><th>Path</th><th>Reason</th><th>Error</th></tr>
+{{range .Failures}}<tr><td>{{.Artifact}}</td><td>{{.Source}}</td><td>{{.Path}}</td><td>{{.Reason}}</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+{{if .MoreFailures}}<p>… and {{.MoreFailures}} more, see errors.jsonl</p>{{end}}{{end}}
+</body>
+</html>
+`))
+
+var reportMarkdownTemplate = template.Must(template.New("report").Funcs(reportFuncs).Parse(`# Collection report: {{.Hostname}}
+
+| Host | |
+|---|---|
+| Hostname | {{md .Hostname}} |
+| Platform | {{.Platform}}/{{.Arch}} |
+| Started | {{.Started}} |
+| Finished | {{.Finished}} |
+| Duration | {{.Duration}} |
+| Output | {{md .OutputDir}} |
+{{- if .Archive}}
+| Archive | {{md .Archive}} |
+{{- end}}
+{{- if .AnalysisUnfinished}}
+| Unfinished analysis | {{.AnalysisUnfinished}} files, see {{.AnalysisPending}} |
+{{- end}}
+{{if .Config}}
+## Configuration
+
+| Option | Value |
+|---|---|
+{{- range .Config}}
+| {{md (index . 0)}} | {{md (index . 1)}} |
+{{- end}}
+{{end}}
+## Artifacts
+
+| Artifact | Files | Bytes | File info | Commands | WMI | Registry | Errors | Duration |
+|---|---:|---:|---:|---:|---:|---:|---:|---|
+{{- range .Artifacts}}
+| {{md .Name}} | {{.Files}} | {{humanSize .Bytes}} | {{.FileInfos}} | {{.Commands}} | {{.WMI}} | {{.Registry}} | {{.Errors}} | {{.Duration}} |
+{{- end}}
+| **Total** | {{.TotalFiles}} | {{humanSize .TotalBytes}} | | | | | {{.TotalErrors}} | |
+{{if .Flagged}}
+## Files flagged by analysis and rules
+
+| Verdict | Zone | Path | MD5 | Detection |
+|---|---|---|---|---|
+{{- range .Flagged}}
+| {{.Verdict}} | {{md .Zone}} | {{md .Path}} | {{.Md5}} | {{md .DetectionName}} |
+{{- end}}
+{{end}}{{if .Largest}}
+## Largest files
+
+| Size | Path | Artifact |
+|---:|---|---|
+{{- range .Largest}}
+| {{humanSize .Size}} | {{md .Path}} | {{md .Artifact}} |
+{{- end}}
+{{end}}{{if .FailureGroups}}
+## Failures
+
+| Reason | Count |
+|---|---:|
+{{- range .FailureGroups}}
+| {{.Reason}} | {{.Count}} |
+{{- end}}
+
+| Artifact | Source | Path | Reason | Error |
+|---|---|---|---|---|
+{{- range .Failures}}
+| {{md .Artifact}} | {{.Source}} | {{md .Path}} | {{.Reason}} | {{md .Error}} |
+{{- end}}
+{{if .MoreFailures}}
+… and {{.MoreFailures}} more, see errors.jsonl
+{{end}}{{end}}`))
@@ -0,0 +1,674 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MAX_RULES_SCAN_SIZE ограничивает размер файлов, проверяемых правилами.
+const MAX_RULES_SCAN_SIZE = 50 * 1024 * 1024
+
+// MAX_STRING_MATCHES ограничивает число совпадений одной строки правила в файле.
+const MAX_STRING_MATCHES = 1000
+
+// MAX_HEX_STEPS ограничивает перебор переходов при поиске одной hex-строки в файле;
+// после его исчерпания поиск строки прекращается с найденными к этому моменту совпадениями.
+const MAX_HEX_STEPS = 1 << 26
+
+// MAX_REPORTED_MATCH_DATA — сколько байт совпадения сохраняется в matches.jsonl.
+const MAX_REPORTED_MATCH_DATA = 64
+
+// yaraRule — разобранное правило.
+type yaraRule struct {
+	Name      string
+	Tags      []string
+	Meta      map[string]interface{}
+	private   bool
+	strings   []*ruleString
+	condition ruleExpr
+}
+
+// ruleString — строка правила: текст, hex-последовательности или регулярное выражение.
+type ruleString struct {
+	ID        string
+	text      []byte
+	hex       [][]hexToken
+	pattern   string
+	re        *regexp.Regexp
+	nocase    bool
+	wide      bool
+	ascii     bool
+	fullword  bool
+	private   bool
+	anonymous bool
+}
+
+// ruleStringMatch — одно совпадение строки.
+type ruleStringMatch struct {
+	offset int
+	length int
+}
+
+// RuleMatch — сработавшее правило и совпадения его строк; строка matches.jsonl.
+type RuleMatch struct {
+	Rule    string                 `json:"rule"`
+	Tags    []string               `json:"tags,omitempty"`
+	Meta    map[string]interface{} `json:"meta,omitempty"`
+	Strings []RuleMatchString      `json:"strings,omitempty"`
+}
+
+// RuleMatchString — совпадение строки правила: идентификатор, смещение и данные
+// (печатаемые символы как есть, остальные — \xHH).
+type RuleMatchString struct {
+	Identifier string `json:"identifier"`
+	Offset     int    `json:"offset"`
+	Length     int    `json:"length"`
+	Data       string `json:"data"`
+}
+
+// RuleSet — набор правил для проверки содержимого файлов.
+type RuleSet struct {
+	rules []*yaraRule
+}
+
+// LoadRules загружает правила из файлов и каталогов (файлы *.yar и *.yara).
+// Правила могут ссылаться на правила, объявленные ранее, в том числе в предыдущих файлах.
+func LoadRules(paths []string) (*RuleSet, error) {
+	rs := &RuleSet{}
+	known := make(map[string]bool)
+	for _, path := range paths {
+		files := []string{path}
+		if st, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if st.IsDir() {
+			files = nil
+			for _, pattern := range []string{"*.yar", "*.yara"} {
+				m, err := filepath.Glob(filepath.Join(path, pattern))
+				if err != nil {
+					return nil, err
+				}
+				files = append(files, m...)
+			}
+			sort.Strings(files)
+		}
+		for _, file := range files {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			rules, err := parseRules(string(src), known)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			rs.rules = append(rs.rules, rules...)
+		}
+	}
+	return rs, nil
+}
+
+// ParseRules создаёт набор правил из текста.
+func ParseRules(src string) (*RuleSet, error) {
+	rules, err := parseRules(src, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	return &RuleSet{rules: rules}, nil
+}
+
+// Len возвращает число правил в наборе.
+func (rs *RuleSet) Len() int {
+	return len(rs.rules)
+}
+
+// Scan проверяет данные всеми правилами и возвращает сработавшие (кроме private).
+func (rs *RuleSet) Scan(data []byte) []*RuleMatch {
+	ctx := &scanContext{data: data, results: make(map[string]bool)}
+	var res []*RuleMatch
+	for _, r := range rs.rules {
+		ctx.matches = make(map[string][]ruleStringMatch, len(r.strings))
+		for _, s := range r.strings {
+			ctx.matches[s.ID] = ctx.find(s)
+		}
+		v, ok := r.condition.eval(ctx)
+		matched := ok && v != 0
+		ctx.results[r.Name] = matched
+		if !matched || r.private {
+			continue
+		}
+		m := &RuleMatch{Rule: r.Name, Tags: r.Tags}
+		if len(r.Meta) > 0 {
+			m.Meta = r.Meta
+		}
+		for _, s := range r.strings {
+			if s.private {
+				continue
+			}
+			for _, sm := range ctx.matches[s.ID] {
+				m.Strings = append(m.Strings, RuleMatchString{
+					Identifier: s.ID,
+					Offset:     sm.offset,
+					Length:     sm.length,
+					Data:       escapeMatchData(data[sm.offset : sm.offset+min(sm.length, MAX_REPORTED_MATCH_DATA)]),
+				})
+			}
+		}
+		res = append(res, m)
+	}
+	return res
+}
+
+// newRuleMatchRecord формирует строку matches.jsonl: файл, артефакт и сработавшее правило.
+func newRuleMatchRecord(path string, hashes interface{}, artifact string, m *RuleMatch) map[string]interface{} {
+	file := map[string]interface{}{"path": path}
+	if hashes != nil {
+		file["hash"] = hashes
+	}
+	record := map[string]interface{}{
+		"@timestamp": time.Now().UTC().Format(time.RFC3339),
+		"file":       file,
+		"rule":       m,
+	}
+	if artifact != "" {
+		record["labels"] = map[string]string{"artifact": artifact}
+	}
+	return record
+}
+
+// escapeMatchData представляет совпадение в читаемом виде.
+func escapeMatchData(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c >= 0x20 && c < 0x7f && c != '\\' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "\\x%02x", c)
+		}
+	}
+	return sb.String()
+}
+
+// ─── поиск строк ──────────────────────────────────────────────────────────────
+
+// scanContext — состояние проверки одного файла.
+type scanContext struct {
+	data    []byte
+	lower   []byte
+	latin   []byte
+	matches map[string][]ruleStringMatch
+	results map[string]bool
+	current string // строка, подставляемая вместо $ в for..of
+}
+
+// lowerData возвращает данные с латинскими буквами в нижнем регистре (длина не меняется).
+func (c *scanContext) lowerData() []byte {
+	if c.lower == nil {
+		c.lower = make([]byte, len(c.data))
+		for i, b := range c.data {
+			if b >= 'A' && b <= 'Z' {
+				b += 'a' - 'A'
+			}
+			c.lower[i] = b
+		}
+	}
+	return c.lower
+}
+
+// latin1Bytes перекодирует байты в UTF-8 так, что каждый байт становится символом U+0000–U+00FF.
+// Регулярные выражения RE2 работают с символами UTF-8; после перекодирования данных и выражения
+// \xE8, [\x80-\xff] и . совпадают ровно с одним исходным байтом, как в YARA.
+func latin1Bytes(b []byte) []byte {
+	out := make([]byte, 0, len(b))
+	for _, c := range b {
+		out = utf8.AppendRune(out, rune(c))
+	}
+	return out
+}
+
+// latinData возвращает данные, перекодированные latin1Bytes.
+func (c *scanContext) latinData() []byte {
+	if c.latin == nil {
+		c.latin = latin1Bytes(c.data)
+	}
+	return c.latin
+}
+
+func (c *scanContext) find(s *ruleString) []ruleStringMatch {
+	switch {
+	case s.hex != nil:
+		return findHex(c.data, s.hex)
+	case s.re != nil:
+		// Смещения в перекодированных данных переводятся в исходные; совпадения идут по возрастанию,
+		// поэтому перевод выполняется одним проходом.
+		latin := c.latinData()
+		pos, off := 0, 0
+		offset := func(to int) int {
+			for ; pos < to; off++ {
+				if latin[pos] < 0x80 {
+					pos++
+				} else {
+					pos += 2
+				}
+			}
+			return off
+		}
+		var res []ruleStringMatch
+		for _, loc := range s.re.FindAllIndex(latin, MAX_STRING_MATCHES) {
+			if loc[1] > loc[0] {
+				start := offset(loc[0])
+				res = append(res, ruleStringMatch{offset: start, length: offset(loc[1]) - start})
+			}
+		}
+		return res
+	}
+	data := c.data
+	text := s.text
+	if s.nocase {
+		data = c.lowerData()
+		text = bytes.ToLower(text)
+	}
+	var variants [][]byte
+	if s.ascii || !s.wide {
+		variants = append(variants, text)
+	}
+	if s.wide {
+		w := make([]byte, 0, 2*len(text))
+		for _, b := range text {
+			w = append(w, b, 0)
+		}
+		variants = append(variants, w)
+	}
+	var res []ruleStringMatch
+	for i, v := range variants {
+		step := 1
+		if s.wide && (i == 1 || !s.ascii) {
+			step = 2
+		}
+		for off := 0; len(res) < MAX_STRING_MATCHES; off++ {
+			idx := bytes.Index(data[off:], v)
+			if idx < 0 {
+				break
+			}
+			off += idx
+			if !s.fullword || isFullword(c.data, off, len(v), step) {
+				res = append(res, ruleStringMatch{offset: off, length: len(v)})
+			}
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].offset < res[j].offset })
+	return res
+}
+
+// isFullword проверяет, что совпадение не окружено буквами или цифрами.
+func isFullword(data []byte, off, length, step int) bool {
+	isWord := func(b byte) bool {
+		return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+	}
+	if off-step >= 0 && isWord(data[off-step]) {
+		return false
+	}
+	if end := off + length; end < len(data) && isWord(data[end]) {
+		return false
+	}
+	return true
+}
+
+// findHex ищет все варианты hex-строки; совпадения в одной позиции учитываются один раз.
+func findHex(data []byte, seqs [][]hexToken) []ruleStringMatch {
+	found := make(map[int]int)
+	budget := MAX_HEX_STEPS
+	for _, seq := range seqs {
+		// Начальные байты без маски ищутся через bytes.Index.
+		var prefix []byte
+		for _, t := range seq {
+			if t.jump || t.mask != 0xFF {
+				break
+			}
+			prefix = append(prefix, t.value)
+		}
+		for off := 0; off < len(data) && len(found) < MAX_STRING_MATCHES; off++ {
+			if len(prefix) > 0 {
+				idx := bytes.Index(data[off:], prefix)
+				if idx < 0 {
+					break
+				}
+				off += idx
+			}
+			if _, ok := found[off]; ok {
+				continue
+			}
+			if end := matchHexAt(data, seq, off, &budget); end >= 0 {
+				found[off] = end - off
+			}
+			if budget <= 0 {
+				break
+			}
+		}
+	}
+	res := make([]ruleStringMatch, 0, len(found))
+	for off, length := range found {
+		res = append(res, ruleStringMatch{offset: off, length: length})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].offset < res[j].offset })
+	return res
+}
+
+// matchHexAt сопоставляет последовательность с данными с позиции pos и возвращает конец
+// совпадения или -1; переходы перебираются от кратчайшего, каждый шаг расходует budget.
+func matchHexAt(data []byte, seq []hexToken, pos int, budget *int) int {
+	for i, t := range seq {
+		if t.jump {
+			max := t.max
+			if max < 0 {
+				max = MAX_HEX_JUMP
+			}
+			if pos+max > len(data) {
+				max = len(data) - pos
+			}
+			for n := t.min; n <= max && *budget > 0; n++ {
+				*budget--
+				if end := matchHexAt(data, seq[i+1:], pos+n, budget); end >= 0 {
+					return end
+				}
+			}
+			return -1
+		}
+		if pos >= len(data) || data[pos]&t.mask != t.value {
+			return -1
+		}
+		pos++
+	}
+	return pos
+}
+
+// ─── условия ──────────────────────────────────────────────────────────────────
+
+// ruleExpr — узел условия. Значения — целые числа (логические — 0 и 1);
+// ok == false означает неопределённое значение (например, смещение несуществующего совпадения).
+type ruleExpr interface {
+	eval(ctx *scanContext) (int64, bool)
+}
+
+func boolValue(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+type constExpr struct{ v int64 }
+
+func (e *constExpr) eval(*scanContext) (int64, bool) { return e.v, true }
+
+type filesizeExpr struct{}
+
+func (e *filesizeExpr) eval(ctx *scanContext) (int64, bool) { return int64(len(ctx.data)), true }
+
+type logicExpr struct {
+	or          bool
+	left, right ruleExpr
+}
+
+func (e *logicExpr) eval(ctx *scanContext) (int64, bool) {
+	l, ok := e.left.eval(ctx)
+	lb := ok && l != 0
+	if e.or && lb {
+		return 1, true
+	}
+	if !e.or && !lb {
+		return 0, true
+	}
+	r, ok := e.right.eval(ctx)
+	return boolValue(ok && r != 0), true
+}
+
+type notExpr struct{ expr ruleExpr }
+
+func (e *notExpr) eval(ctx *scanContext) (int64, bool) {
+	v, ok := e.expr.eval(ctx)
+	if !ok {
+		return 0, false
+	}
+	return boolValue(v == 0), true
+}
+
+type unaryExpr struct {
+	op   string
+	expr ruleExpr
+}
+
+func (e *unaryExpr) eval(ctx *scanContext) (int64, bool) {
+	v, ok := e.expr.eval(ctx)
+	if e.op == "~" {
+		return ^v, ok
+	}
+	return -v, ok
+}
+
+type binaryExpr struct {
+	op          string
+	left, right ruleExpr
+}
+
+func (e *binaryExpr) eval(ctx *scanContext) (int64, bool) {
+	l, lok := e.left.eval(ctx)
+	r, rok := e.right.eval(ctx)
+	if !lok || !rok {
+		return 0, false
+	}
+	switch e.op {
+	case "==":
+		return boolValue(l == r), true
+	case "!=":
+		return boolValue(l != r), true
+	case "<":
+		return boolValue(l < r), true
+	case "<=":
+		return boolValue(l <= r), true
+	case ">":
+		return boolValue(l > r), true
+	case ">=":
+		return boolValue(l >= r), true
+	case "+":
+		return l + r, true
+	case "-":
+		return l - r, true
+	case "*":
+		return l * r, true
+	case "\\":
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	case "%":
+		if r == 0 {
+			return 0, false
+		}
+		return l % r, true
+	case "&":
+		return l & r, true
+	case "|":
+		return l | r, true
+	case "^":
+		return l ^ r, true
+	case "<<":
+		return l << uint64(r), true
+	case ">>":
+		return l >> uint64(r), true
+	}
+	return 0, false
+}
+
+// stringMatches возвращает совпадения строки; $ внутри for..of обозначает текущую строку набора.
+func (ctx *scanContext) stringMatches(id string) []ruleStringMatch {
+	if id == "$" {
+		id = ctx.current
+	}
+	return ctx.matches[id]
+}
+
+// stringExpr — $a, $a at <смещение>, $a in (<начало>..<конец>).
+type stringExpr struct {
+	id     string
+	at     ruleExpr
+	lo, hi ruleExpr
+}
+
+func (e *stringExpr) eval(ctx *scanContext) (int64, bool) {
+	matches := ctx.stringMatches(e.id)
+	if e.at != nil {
+		at, ok := e.at.eval(ctx)
+		if !ok {
+			return 0, true
+		}
+		for _, m := range matches {
+			if int64(m.offset) == at {
+				return 1, true
+			}
+		}
+		return 0, true
+	}
+	if e.lo != nil {
+		return boolValue(countInRange(ctx, matches, e.lo, e.hi) > 0), true
+	}
+	return boolValue(len(matches) > 0), true
+}
+
+// countExpr — #a и #a in (<начало>..<конец>).
+type countExpr struct {
+	id     string
+	lo, hi ruleExpr
+}
+
+func (e *countExpr) eval(ctx *scanContext) (int64, bool) {
+	matches := ctx.stringMatches(e.id)
+	if e.lo != nil {
+		return int64(countInRange(ctx, matches, e.lo, e.hi)), true
+	}
+	return int64(len(matches)), true
+}
+
+func countInRange(ctx *scanContext, matches []ruleStringMatch, loExpr, hiExpr ruleExpr) int {
+	lo, lok := loExpr.eval(ctx)
+	hi, hok := hiExpr.eval(ctx)
+	if !lok || !hok {
+		return 0
+	}
+	n := 0
+	for _, m := range matches {
+		if int64(m.offset) >= lo && int64(m.offset) <= hi {
+			n++
+		}
+	}
+	return n
+}
+
+// matchAttrExpr — @a[i] (смещение) и !a[i] (длина) i-го совпадения, начиная с 1.
+type matchAttrExpr struct {
+	id     string
+	length bool
+	index  ruleExpr
+}
+
+func (e *matchAttrExpr) eval(ctx *scanContext) (int64, bool) {
+	matches := ctx.stringMatches(e.id)
+	i, ok := e.index.eval(ctx)
+	if !ok || i < 1 || i > int64(len(matches)) {
+		return 0, false
+	}
+	if e.length {
+		return int64(matches[i-1].length), true
+	}
+	return int64(matches[i-1].offset), true
+}
+
+// quantifier — all, any или none в выражениях of.
+type quantifier struct{ name string }
+
+func (q *quantifier) eval(*scanContext) (int64, bool) { return 0, false }
+
+// ofExpr — <квантор> of <набор> и for <квантор> of <набор> : (<условие>).
+type ofExpr struct {
+	quant ruleExpr
+	ids   []string
+	body  ruleExpr
+}
+
+func (e *ofExpr) eval(ctx *scanContext) (int64, bool) {
+	n := 0
+	for _, id := range e.ids {
+		if e.body == nil {
+			if len(ctx.matches[id]) > 0 {
+				n++
+			}
+			continue
+		}
+		saved := ctx.current
+		ctx.current = id
+		v, ok := e.body.eval(ctx)
+		ctx.current = saved
+		if ok && v != 0 {
+			n++
+		}
+	}
+	if q, ok := e.quant.(*quantifier); ok {
+		switch q.name {
+		case "all":
+			return boolValue(n == len(e.ids)), true
+		case "any":
+			return boolValue(n > 0), true
+		default:
+			return boolValue(n == 0), true
+		}
+	}
+	want, ok := e.quant.eval(ctx)
+	if !ok {
+		return 0, false
+	}
+	return boolValue(int64(n) >= want), true
+}
+
+// intReaders — функции чтения целых из данных файла: uint8..int32, с суффиксом be — big-endian.
+var intReaders = map[string]struct {
+	size int
+	read func(b []byte) int64
+}{
+	"uint8":    {1, func(b []byte) int64 { return int64(b[0]) }},
+	"int8":     {1, func(b []byte) int64 { return int64(int8(b[0])) }},
+	"uint16":   {2, func(b []byte) int64 { return int64(binary.LittleEndian.Uint16(b)) }},
+	"int16":    {2, func(b []byte) int64 { return int64(int16(binary.LittleEndian.Uint16(b))) }},
+	"uint32":   {4, func(b []byte) int64 { return int64(binary.LittleEndian.Uint32(b)) }},
+	"int32":    {4, func(b []byte) int64 { return int64(int32(binary.LittleEndian.Uint32(b))) }},
+	"uint16be": {2, func(b []byte) int64 { return int64(binary.BigEndian.Uint16(b)) }},
+	"int16be":  {2, func(b []byte) int64 { return int64(int16(binary.BigEndian.Uint16(b))) }},
+	"uint32be": {4, func(b []byte) int64 { return int64(binary.BigEndian.Uint32(b)) }},
+	"int32be":  {4, func(b []byte) int64 { return int64(int32(binary.BigEndian.Uint32(b))) }},
+}
+
+type intReadExpr struct {
+	fn     string
+	offset ruleExpr
+}
+
+func (e *intReadExpr) eval(ctx *scanContext) (int64, bool) {
+	reader := intReaders[e.fn]
+	off, ok := e.offset.eval(ctx)
+	if !ok || off < 0 || off+int64(reader.size) > int64(len(ctx.data)) {
+		return 0, false
+	}
+	return reader.read(ctx.data[off:]), true
+}
+
+// ruleRefExpr — ссылка на результат ранее объявленного правила.
+type ruleRefExpr struct{ name string }
+
+func (e *ruleRefExpr) eval(ctx *scanContext) (int64, bool) {
+	return boolValue(ctx.results[e.name]), true
+}
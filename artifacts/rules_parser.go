@@ -0,0 +1,1048 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Разбор правил в синтаксисе YARA (подмножество): секции meta, strings и condition,
+// текстовые строки с модификаторами nocase/wide/ascii/fullword/private, hex-строки с масками,
+// переходами и альтернативами, регулярные выражения и условия со счётчиками, смещениями,
+// filesize, uintXX(), кванторами of/for..of и ссылками на ранее объявленные правила.
+
+// Типы лексем.
+const (
+	tokEOF = iota
+	tokIdent
+	tokString
+	tokRegex
+	tokNumber
+	tokVar    // $a
+	tokCount  // #a
+	tokOffset // @a
+	tokLength // !a
+	tokOp
+)
+
+type ruleToken struct {
+	kind int
+	text string
+	num  int64
+	line int
+}
+
+// ruleLexer выдаёт лексемы по требованию: hex-строки читаются парсером напрямую,
+// так как фигурные скобки в них имеют другой смысл.
+type ruleLexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *ruleLexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", l.line, fmt.Sprintf(format, args...))
+}
+
+// skipSpace пропускает пробелы и комментарии.
+func (l *ruleLexer) skipSpace() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				return l.errorf("unterminated comment")
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func (l *ruleLexer) next() (ruleToken, error) {
+	if err := l.skipSpace(); err != nil {
+		return ruleToken{}, err
+	}
+	tok := ruleToken{line: l.line}
+	if l.pos >= len(l.src) {
+		tok.kind = tokEOF
+		return tok, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '"':
+		s, err := l.readString()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind, tok.text = tokString, s
+	case c == '/':
+		re, err := l.readRegex()
+		if err != nil {
+			return tok, err
+		}
+		tok.kind, tok.text = tokRegex, re
+	case c >= '0' && c <= '9':
+		start := l.pos
+		for l.pos < len(l.src) && (isIdentByte(l.src[l.pos]) && l.src[l.pos] != '.') {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		mult := int64(1)
+		if strings.HasSuffix(text, "KB") {
+			text, mult = strings.TrimSuffix(text, "KB"), 1024
+		} else if strings.HasSuffix(text, "MB") {
+			text, mult = strings.TrimSuffix(text, "MB"), 1024*1024
+		}
+		n, err := strconv.ParseInt(text, 0, 64)
+		if err != nil {
+			return tok, l.errorf("invalid number %q", l.src[start:l.pos])
+		}
+		tok.kind, tok.num, tok.text = tokNumber, n*mult, l.src[start:l.pos]
+	case (c == '$' || c == '#' || c == '@' || c == '!') && !(c == '!' && strings.HasPrefix(l.src[l.pos:], "!=")):
+		start := l.pos
+		l.pos++
+		for l.pos < len(l.src) && isIdentByte(l.src[l.pos]) && l.src[l.pos] != '.' {
+			l.pos++
+		}
+		if c == '$' && l.pos < len(l.src) && l.src[l.pos] == '*' {
+			l.pos++
+		}
+		tok.text = l.src[start:l.pos]
+		tok.kind = map[byte]int{'$': tokVar, '#': tokCount, '@': tokOffset, '!': tokLength}[c]
+		if c != '$' {
+			// #a, @a и !a ссылаются на строку $a
+			tok.text = "$" + tok.text[1:]
+		}
+	case isIdentByte(c) && c != '.':
+		start := l.pos
+		// Точка допустима внутри имени (pe.number_of_sections), но не как начало диапазона "..".
+		for l.pos < len(l.src) && isIdentByte(l.src[l.pos]) && !strings.HasPrefix(l.src[l.pos:], "..") {
+			l.pos++
+		}
+		tok.kind, tok.text = tokIdent, l.src[start:l.pos]
+	default:
+		for _, op := range []string{"..", "==", "!=", "<=", ">=", "<<", ">>"} {
+			if strings.HasPrefix(l.src[l.pos:], op) {
+				l.pos += len(op)
+				tok.kind, tok.text = tokOp, op
+				return tok, nil
+			}
+		}
+		if !strings.ContainsRune("{}()[],:=<>+-*\\%&|^~", rune(c)) {
+			return tok, l.errorf("unexpected character %q", c)
+		}
+		l.pos++
+		tok.kind, tok.text = tokOp, string(c)
+	}
+	return tok, nil
+}
+
+// readString читает строку в кавычках с экранированием \" \\ \n \r \t \xHH.
+func (l *ruleLexer) readString() (string, error) {
+	var b []byte
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return string(b), nil
+		case '\n':
+			return "", l.errorf("unterminated string")
+		case '\\':
+			l.pos++
+			if l.pos >= len(l.src) {
+				return "", l.errorf("unterminated string")
+			}
+			switch e := l.src[l.pos]; e {
+			case 'n':
+				b = append(b, '\n')
+			case 'r':
+				b = append(b, '\r')
+			case 't':
+				b = append(b, '\t')
+			case '"', '\\':
+				b = append(b, e)
+			case 'x':
+				if l.pos+2 >= len(l.src) {
+					return "", l.errorf("invalid escape sequence")
+				}
+				v, err := strconv.ParseUint(l.src[l.pos+1:l.pos+3], 16, 8)
+				if err != nil {
+					return "", l.errorf("invalid escape sequence \\x%s", l.src[l.pos+1:l.pos+3])
+				}
+				b = append(b, byte(v))
+				l.pos += 2
+			default:
+				return "", l.errorf("invalid escape sequence \\%c", e)
+			}
+		default:
+			b = append(b, c)
+		}
+	}
+	return "", l.errorf("unterminated string")
+}
+
+// readRegex читает /выражение/флаги; флаги i и s переводятся в синтаксис RE2.
+func (l *ruleLexer) readRegex() (string, error) {
+	start := l.pos + 1
+	for l.pos++; l.pos < len(l.src); l.pos++ {
+		switch l.src[l.pos] {
+		case '\\':
+			l.pos++
+		case '\n':
+			return "", l.errorf("unterminated regular expression")
+		case '/':
+			expr := l.src[start:l.pos]
+			l.pos++
+			flags := ""
+			for l.pos < len(l.src) && (l.src[l.pos] == 'i' || l.src[l.pos] == 's') {
+				flags += string(l.src[l.pos])
+				l.pos++
+			}
+			if flags != "" {
+				expr = "(?" + flags + ")" + expr
+			}
+			return expr, nil
+		}
+	}
+	return "", l.errorf("unterminated regular expression")
+}
+
+// readHex читает содержимое hex-строки до закрывающей скобки; текущая позиция — на '{'.
+func (l *ruleLexer) readHex() (string, error) {
+	end := strings.IndexByte(l.src[l.pos:], '}')
+	if end < 0 {
+		return "", l.errorf("unterminated hex string")
+	}
+	body := l.src[l.pos+1 : l.pos+end]
+	l.line += strings.Count(body, "\n")
+	l.pos += end + 1
+	return body, nil
+}
+
+// ruleParser строит правила из лексем.
+type ruleParser struct {
+	lex   *ruleLexer
+	tok   ruleToken
+	known map[string]bool // ранее объявленные правила
+	rule  *yaraRule       // разбираемое правило
+}
+
+func (p *ruleParser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *ruleParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *ruleParser) isOp(op string) bool {
+	return p.tok.kind == tokOp && p.tok.text == op
+}
+
+func (p *ruleParser) isKeyword(kw string) bool {
+	return p.tok.kind == tokIdent && p.tok.text == kw
+}
+
+func (p *ruleParser) expectOp(op string) error {
+	if !p.isOp(op) {
+		return p.errorf("expected %q, got %q", op, p.tok.text)
+	}
+	return p.advance()
+}
+
+func (p *ruleParser) expectKeyword(kw string) error {
+	if !p.isKeyword(kw) {
+		return p.errorf("expected %q, got %q", kw, p.tok.text)
+	}
+	return p.advance()
+}
+
+// parseRules разбирает текст файла правил; known — имена правил из ранее загруженных файлов.
+func parseRules(src string, known map[string]bool) ([]*yaraRule, error) {
+	p := &ruleParser{lex: &ruleLexer{src: src, line: 1}, known: known}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var rules []*yaraRule
+	for p.tok.kind != tokEOF {
+		if p.isKeyword("import") || p.isKeyword("include") {
+			return nil, p.errorf("%s is not supported", p.tok.text)
+		}
+		r, err := p.parseRule()
+		if err != nil {
+			return nil, err
+		}
+		if p.known[r.Name] {
+			return nil, fmt.Errorf("duplicate rule %q", r.Name)
+		}
+		p.known[r.Name] = true
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func (p *ruleParser) parseRule() (*yaraRule, error) {
+	r := &yaraRule{Meta: make(map[string]interface{})}
+	for p.isKeyword("private") || p.isKeyword("global") {
+		if p.tok.text == "global" {
+			return nil, p.errorf("global rules are not supported")
+		}
+		r.private = true
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("rule"); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokIdent {
+		return nil, p.errorf("expected rule name")
+	}
+	r.Name = p.tok.text
+	p.rule = r
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.isOp(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for p.tok.kind == tokIdent {
+			r.Tags = append(r.Tags, p.tok.text)
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := p.expectOp("{"); err != nil {
+		return nil, err
+	}
+	for !p.isOp("}") {
+		if p.tok.kind != tokIdent {
+			return nil, p.errorf("expected section, got %q", p.tok.text)
+		}
+		section := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if err := p.expectOp(":"); err != nil {
+			return nil, err
+		}
+		var err error
+		switch section {
+		case "meta":
+			err = p.parseMeta(r)
+		case "strings":
+			err = p.parseStrings(r)
+		case "condition":
+			r.condition, err = p.parseExpr()
+		default:
+			err = p.errorf("unknown section %q", section)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.condition == nil {
+		return nil, p.errorf("rule %s has no condition", r.Name)
+	}
+	return r, p.advance()
+}
+
+func (p *ruleParser) parseMeta(r *yaraRule) error {
+	for p.tok.kind == tokIdent && !p.isKeyword("strings") && !p.isKeyword("condition") {
+		key := p.tok.text
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if err := p.expectOp("="); err != nil {
+			return err
+		}
+		switch {
+		case p.tok.kind == tokString:
+			r.Meta[key] = p.tok.text
+		case p.tok.kind == tokNumber:
+			r.Meta[key] = p.tok.num
+		case p.isOp("-"):
+			if err := p.advance(); err != nil {
+				return err
+			}
+			if p.tok.kind != tokNumber {
+				return p.errorf("expected number")
+			}
+			r.Meta[key] = -p.tok.num
+		case p.isKeyword("true") || p.isKeyword("false"):
+			r.Meta[key] = p.tok.text == "true"
+		default:
+			return p.errorf("invalid meta value for %s", key)
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ruleParser) parseStrings(r *yaraRule) error {
+	for p.tok.kind == tokVar {
+		s := &ruleString{ID: p.tok.text}
+		if s.ID == "$" {
+			s.ID = fmt.Sprintf("$%d", len(r.strings))
+			s.anonymous = true
+		}
+		for _, other := range r.strings {
+			if other.ID == s.ID {
+				return p.errorf("duplicate string identifier %s", s.ID)
+			}
+		}
+		if !p.isOp("=") {
+			// Лексема "=" ещё не прочитана: проверяем её до чтения значения,
+			// чтобы hex-строку в фигурных скобках разобрать напрямую.
+			if err := p.lex.skipSpace(); err != nil {
+				return err
+			}
+			if p.lex.pos >= len(p.lex.src) || p.lex.src[p.lex.pos] != '=' {
+				return p.errorf("expected '=' after %s", s.ID)
+			}
+			p.lex.pos++
+			if err := p.lex.skipSpace(); err != nil {
+				return err
+			}
+		}
+		var err error
+		if p.lex.pos < len(p.lex.src) && p.lex.src[p.lex.pos] == '{' {
+			var body string
+			if body, err = p.lex.readHex(); err != nil {
+				return err
+			}
+			if s.hex, err = compileHex(body); err != nil {
+				return p.errorf("%s: %v", s.ID, err)
+			}
+			if err = p.advance(); err != nil {
+				return err
+			}
+		} else {
+			if err = p.advance(); err != nil {
+				return err
+			}
+			switch p.tok.kind {
+			case tokString:
+				s.text = []byte(p.tok.text)
+				if len(s.text) == 0 {
+					return p.errorf("%s: empty string", s.ID)
+				}
+			case tokRegex:
+				s.pattern = p.tok.text
+			default:
+				return p.errorf("%s: expected string, hex string or regular expression", s.ID)
+			}
+			if err = p.advance(); err != nil {
+				return err
+			}
+		}
+		if err := p.parseModifiers(s); err != nil {
+			return err
+		}
+		if s.pattern != "" {
+			if s.re, err = regexp.Compile(string(latin1Bytes([]byte(s.pattern)))); err != nil {
+				return p.errorf("%s: %v", s.ID, err)
+			}
+		}
+		r.strings = append(r.strings, s)
+	}
+	return nil
+}
+
+func (p *ruleParser) parseModifiers(s *ruleString) error {
+	for p.tok.kind == tokIdent {
+		switch p.tok.text {
+		case "nocase":
+			s.nocase = true
+		case "wide":
+			s.wide = true
+		case "ascii":
+			s.ascii = true
+		case "fullword":
+			s.fullword = true
+		case "private":
+			s.private = true
+		default:
+			if p.tok.text == "strings" || p.tok.text == "condition" || p.tok.text == "meta" {
+				return nil
+			}
+			return p.errorf("%s: unsupported modifier %q", s.ID, p.tok.text)
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	if s.hex != nil && (s.nocase || s.wide || s.fullword) {
+		return p.errorf("%s: modifiers are not allowed for hex strings", s.ID)
+	}
+	if s.pattern != "" {
+		if s.wide {
+			return p.errorf("%s: wide regular expressions are not supported", s.ID)
+		}
+		if s.nocase && !strings.HasPrefix(s.pattern, "(?i") {
+			s.pattern = "(?i)" + s.pattern
+		}
+	}
+	return nil
+}
+
+// ─── условия ──────────────────────────────────────────────────────────────────
+
+func (p *ruleParser) parseExpr() (ruleExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicExpr{or: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseNot() (ruleExpr, error) {
+	if p.isKeyword("not") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *ruleParser) parseCompare() (ruleExpr, error) {
+	left, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.isOp(op) {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			right, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			return &binaryExpr{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+// binaryLevels — арифметические и битовые операции в порядке возрастания приоритета.
+var binaryLevels = [][]string{
+	{"|"},
+	{"^"},
+	{"&"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "\\", "%"},
+}
+
+func (p *ruleParser) parseBinary(level int) (ruleExpr, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		matched := ""
+		for _, op := range binaryLevels[level] {
+			if p.isOp(op) {
+				matched = op
+			}
+		}
+		if matched == "" {
+			return left, nil
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: matched, left: left, right: right}
+	}
+}
+
+func (p *ruleParser) parseUnary() (ruleExpr, error) {
+	if p.isOp("-") || p.isOp("~") {
+		op := p.tok.text
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryExpr{op: op, expr: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *ruleParser) parsePrimary() (ruleExpr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNumber:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.isKeyword("of") {
+			return p.parseOf(&constExpr{tok.num})
+		}
+		return &constExpr{tok.num}, nil
+	case tokVar:
+		if err := p.checkString(tok.text); err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e := &stringExpr{id: tok.text}
+		if p.isKeyword("at") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			at, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			e.at = at
+		} else if p.isKeyword("in") {
+			lo, hi, err := p.parseRange()
+			if err != nil {
+				return nil, err
+			}
+			e.lo, e.hi = lo, hi
+		}
+		return e, nil
+	case tokCount:
+		if err := p.checkString(tok.text); err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e := &countExpr{id: tok.text}
+		if p.isKeyword("in") {
+			lo, hi, err := p.parseRange()
+			if err != nil {
+				return nil, err
+			}
+			e.lo, e.hi = lo, hi
+		}
+		return e, nil
+	case tokOffset, tokLength:
+		if err := p.checkString(tok.text); err != nil {
+			return nil, err
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		e := &matchAttrExpr{id: tok.text, length: tok.kind == tokLength, index: &constExpr{1}}
+		if p.isOp("[") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			idx, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("]"); err != nil {
+				return nil, err
+			}
+			e.index = idx
+		}
+		return e, nil
+	case tokOp:
+		if tok.text == "(" {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expectOp(")")
+		}
+	case tokIdent:
+		switch tok.text {
+		case "true", "false":
+			return &constExpr{boolValue(tok.text == "true")}, p.advance()
+		case "filesize":
+			return &filesizeExpr{}, p.advance()
+		case "all", "any", "none":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			return p.parseOf(&quantifier{name: tok.text})
+		case "for":
+			return p.parseFor()
+		}
+		if _, ok := intReaders[tok.text]; ok {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if err := p.expectOp("("); err != nil {
+				return nil, err
+			}
+			off, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			return &intReadExpr{fn: tok.text, offset: off}, p.expectOp(")")
+		}
+		if p.known[tok.text] {
+			return &ruleRefExpr{name: tok.text}, p.advance()
+		}
+		return nil, p.errorf("undefined identifier %q", tok.text)
+	}
+	return nil, p.errorf("unexpected %q in condition", tok.text)
+}
+
+// checkString проверяет, что строка объявлена в секции strings; "$" допустим внутри for..of.
+func (p *ruleParser) checkString(id string) error {
+	if id == "$" {
+		return nil
+	}
+	for _, s := range p.rule.strings {
+		if s.ID == id {
+			return nil
+		}
+	}
+	return p.errorf("undefined string identifier %s", id)
+}
+
+// parseRange разбирает "in (нижняя..верхняя)"; текущая лексема — in.
+func (p *ruleParser) parseRange() (ruleExpr, ruleExpr, error) {
+	if err := p.advance(); err != nil {
+		return nil, nil, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, nil, err
+	}
+	lo, err := p.parseBinary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.expectOp(".."); err != nil {
+		return nil, nil, err
+	}
+	hi, err := p.parseBinary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return lo, hi, p.expectOp(")")
+}
+
+// parseOf разбирает "<квантор> of <набор строк>"; текущая лексема — of.
+func (p *ruleParser) parseOf(q ruleExpr) (ruleExpr, error) {
+	if err := p.expectKeyword("of"); err != nil {
+		return nil, err
+	}
+	ids, err := p.parseStringSet()
+	if err != nil {
+		return nil, err
+	}
+	return &ofExpr{quant: q, ids: ids}, nil
+}
+
+// parseFor разбирает "for <квантор> of <набор строк> : ( <условие с $> )".
+func (p *ruleParser) parseFor() (ruleExpr, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var q ruleExpr
+	if p.isKeyword("all") || p.isKeyword("any") || p.isKeyword("none") {
+		q = &quantifier{name: p.tok.text}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if q, err = p.parseBinary(0); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("of"); err != nil {
+		return nil, err
+	}
+	ids, err := p.parseStringSet()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	body, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(")"); err != nil {
+		return nil, err
+	}
+	return &ofExpr{quant: q, ids: ids, body: body}, nil
+}
+
+// parseStringSet разбирает them или список ($a, $b*) и разворачивает шаблоны в идентификаторы.
+func (p *ruleParser) parseStringSet() ([]string, error) {
+	var ids []string
+	if p.isKeyword("them") {
+		for _, s := range p.rule.strings {
+			ids = append(ids, s.ID)
+		}
+		return ids, p.advance()
+	}
+	if err := p.expectOp("("); err != nil {
+		return nil, err
+	}
+	for {
+		if p.tok.kind != tokVar {
+			return nil, p.errorf("expected string identifier in set")
+		}
+		pattern := p.tok.text
+		found := false
+		for _, s := range p.rule.strings {
+			if s.ID == pattern || strings.HasSuffix(pattern, "*") && strings.HasPrefix(s.ID, strings.TrimSuffix(pattern, "*")) {
+				ids = append(ids, s.ID)
+				found = true
+			}
+		}
+		if !found {
+			return nil, p.errorf("undefined string identifier %s", pattern)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if p.isOp(")") {
+			return ids, p.advance()
+		}
+		if err := p.expectOp(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ─── hex-строки ───────────────────────────────────────────────────────────────
+
+// Максимальное число вариантов hex-строки после раскрытия альтернатив.
+const MAX_HEX_ALTERNATIVES = 256
+
+// MAX_HEX_JUMP — наибольшая длина перехода; переход [n-] без верхней границы ограничивается ею.
+const MAX_HEX_JUMP = 32 * 1024
+
+// hexToken — байт с маской или переход [min-max] (max < 0 — до MAX_HEX_JUMP).
+type hexToken struct {
+	jump  bool
+	value byte
+	mask  byte
+	min   int
+	max   int
+}
+
+// compileHex разбирает тело hex-строки и раскрывает альтернативы (a|b) в отдельные
+// последовательности без ветвлений.
+func compileHex(body string) ([][]hexToken, error) {
+	var fields []rune
+	for _, r := range body {
+		if !unicode.IsSpace(r) {
+			fields = append(fields, r)
+		}
+	}
+	pos := 0
+	seqs, err := parseHexSeq(fields, &pos)
+	if err != nil {
+		return nil, err
+	}
+	if pos != len(fields) {
+		return nil, fmt.Errorf("unexpected %q in hex string", fields[pos])
+	}
+	for _, seq := range seqs {
+		if len(seq) == 0 || seq[0].jump || seq[len(seq)-1].jump {
+			return nil, fmt.Errorf("hex string must start and end with a byte")
+		}
+	}
+	return seqs, nil
+}
+
+// parseHexSeq разбирает последовательность до ')' или '|' и возвращает все её варианты.
+func parseHexSeq(src []rune, pos *int) ([][]hexToken, error) {
+	seqs := [][]hexToken{nil}
+	appendAll := func(suffixes [][]hexToken) error {
+		if len(seqs)*len(suffixes) > MAX_HEX_ALTERNATIVES {
+			return fmt.Errorf("too many alternatives in hex string")
+		}
+		var res [][]hexToken
+		for _, s := range seqs {
+			for _, suffix := range suffixes {
+				res = append(res, append(append([]hexToken(nil), s...), suffix...))
+			}
+		}
+		seqs = res
+		return nil
+	}
+	for *pos < len(src) {
+		c := src[*pos]
+		switch {
+		case c == ')' || c == '|':
+			return seqs, nil
+		case c == '(':
+			*pos++
+			var alts [][]hexToken
+			for {
+				alt, err := parseHexSeq(src, pos)
+				if err != nil {
+					return nil, err
+				}
+				alts = append(alts, alt...)
+				if *pos >= len(src) {
+					return nil, fmt.Errorf("unterminated alternative in hex string")
+				}
+				if src[*pos] == ')' {
+					*pos++
+					break
+				}
+				*pos++ // '|'
+			}
+			if err := appendAll(alts); err != nil {
+				return nil, err
+			}
+		case c == '[':
+			end := *pos
+			for end < len(src) && src[end] != ']' {
+				end++
+			}
+			if end == len(src) {
+				return nil, fmt.Errorf("unterminated jump in hex string")
+			}
+			tok, err := parseHexJump(string(src[*pos+1 : end]))
+			if err != nil {
+				return nil, err
+			}
+			*pos = end + 1
+			if err := appendAll([][]hexToken{{tok}}); err != nil {
+				return nil, err
+			}
+		default:
+			if *pos+1 >= len(src) {
+				return nil, fmt.Errorf("incomplete byte in hex string")
+			}
+			tok, err := parseHexByte(src[*pos], src[*pos+1])
+			if err != nil {
+				return nil, err
+			}
+			*pos += 2
+			for i := range seqs {
+				seqs[i] = append(seqs[i], tok)
+			}
+		}
+	}
+	return seqs, nil
+}
+
+func parseHexByte(hi, lo rune) (hexToken, error) {
+	tok := hexToken{}
+	for i, c := range []rune{hi, lo} {
+		shift := uint(4 * (1 - i))
+		if c == '?' {
+			continue
+		}
+		v, err := strconv.ParseUint(string(c), 16, 8)
+		if err != nil {
+			return tok, fmt.Errorf("invalid hex byte %c%c", hi, lo)
+		}
+		tok.value |= byte(v) << shift
+		tok.mask |= 0xF << shift
+	}
+	return tok, nil
+}
+
+func parseHexJump(spec string) (hexToken, error) {
+	tok := hexToken{jump: true}
+	lo, hi, isRange := strings.Cut(spec, "-")
+	var err error
+	if lo == "" {
+		tok.min = 0
+	} else if tok.min, err = strconv.Atoi(lo); err != nil {
+		return tok, fmt.Errorf("invalid jump [%s]", spec)
+	}
+	switch {
+	case !isRange:
+		tok.max = tok.min
+	case hi == "":
+		tok.max = -1
+	default:
+		if tok.max, err = strconv.Atoi(hi); err != nil || tok.max < tok.min {
+			return tok, fmt.Errorf("invalid jump [%s]", spec)
+		}
+	}
+	if tok.min < 0 || tok.min > MAX_HEX_JUMP || tok.max > MAX_HEX_JUMP {
+		return tok, fmt.Errorf("jump [%s] exceeds %d bytes", spec, MAX_HEX_JUMP)
+	}
+	return tok, nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func scanRuleNames(t *testing.T, src string, data []byte) []string {
+	rs, err := ParseRules(src)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	var names []string
+	for _, m := range rs.Scan(data) {
+		names = append(names, m.Rule)
+	}
+	return names
+}
+
+func TestRulesStrings(t *testing.T) {
+	data := []byte("MZ\x90\x00 This program cannot be run in DOS mode. " +
+		"p\x00o\x00w\x00e\x00r\x00s\x00h\x00e\x00l\x00l\x00 Invoke-Mimikatz evil.example.com:4444 EVIL")
+
+	src := `
+/* текстовые строки и модификаторы */
+rule Text : tag1 tag2 {
+    meta:
+        author = "analyst"
+        score = 75
+        active = true
+    strings:
+        $a = "cannot be run"
+        $b = "powershell" wide
+        $c = "evil" nocase fullword
+        $d = "Mimi" fullword
+    condition:
+        $a and $b and #c == 2 and not $d
+}
+
+rule Hex {
+    strings:
+        $mz = { 4D 5A [2] 20 54 }
+        $alt = { 49 6E ( 76 6F | 78 78 ) 6B 65 }
+        $mask = { 3? 34 [1-3] 34 }
+    condition:
+        $mz at 0 and $alt and $mask
+}
+
+rule Regex {
+    strings:
+        $re = /[a-z]+\.example\.com:\d{2,5}/
+        $ci = /invoke-MIMIKATZ/i
+    condition:
+        all of them and @re[1] > 50 and !ci[1] == 15
+}
+
+rule Header {
+    condition:
+        uint16(0) == 0x5A4D and filesize < 1KB and uint8(2) & 0xF0 == 0x90
+}
+
+rule Refs {
+    condition:
+        Header and Hex
+}
+
+private rule Hidden {
+    strings:
+        $a = "DOS"
+    condition:
+        $a
+}
+
+rule UsesPrivate {
+    strings:
+        $x = "nothing here"
+        $y = "EVIL"
+    condition:
+        Hidden and 1 of ($x, $y) and none of ($x)
+}
+
+rule ForOf {
+    strings:
+        $s1 = "program"
+        $s2 = "mode"
+    condition:
+        for all of ($s*) : ( $ in (0..60) ) and #s1 in (0..5) == 0
+}
+
+rule NoMatch {
+    strings:
+        $a = "absent"
+    condition:
+        $a or uint32(1000) == 0
+}
+`
+	assert.Equal(t, []string{"Text", "Hex", "Regex", "Header", "Refs", "UsesPrivate", "ForOf"}, scanRuleNames(t, src, data))
+
+	rs, err := ParseRules(src)
+	assert.NoError(t, err)
+	matches := rs.Scan(data)
+	assert.Equal(t, []string{"tag1", "tag2"}, matches[0].Tags)
+	assert.Equal(t, "analyst", matches[0].Meta["author"])
+	assert.Equal(t, int64(75), matches[0].Meta["score"])
+	assert.Equal(t, "$a", matches[0].Strings[0].Identifier)
+	assert.Equal(t, "cannot be run", matches[0].Strings[0].Data)
+	assert.Equal(t, "MZ\\x90\\x00 T", matches[1].Strings[0].Data)
+	assert.Equal(t, 0, matches[1].Strings[0].Offset)
+}
+
+func TestRulesBytes(t *testing.T) {
+	data := []byte("ab\xE8\x00\x00\x00\x00 \xD1\x8F\xFF\xFEcall")
+	rs, err := ParseRules(`
+rule Bytes {
+    strings:
+        $call = /\xE8\x00{4}/
+        $high = /[\x80-\xff]{2}call/
+        $utf8 = /я/
+    condition:
+        @call[1] == 2 and !call[1] == 5 and @high[1] == 10 and !high[1] == 6 and @utf8[1] == 8
+}
+
+rule Jump {
+    strings:
+        $a = { 61 [-] 63 61 6C 6C }
+    condition:
+        $a
+}
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var names []string
+	for _, m := range rs.Scan(data) {
+		names = append(names, m.Rule)
+	}
+	assert.Equal(t, []string{"Bytes", "Jump"}, names)
+
+	// Неограниченный переход не дальше MAX_HEX_JUMP, поиск укладывается в бюджет
+	far := append(append([]byte{0x61}, make([]byte, MAX_HEX_JUMP+1)...), 0x62)
+	assert.Empty(t, findHex(far, [][]hexToken{{{value: 0x61, mask: 0xFF}, {jump: true, max: -1}, {value: 0x62, mask: 0xFF}}}))
+	zeros := make([]byte, 1<<20)
+	seq := [][]hexToken{{{mask: 0xFF}, {jump: true, max: -1}, {mask: 0xFF}, {jump: true, max: -1}, {value: 1, mask: 0xFF}}}
+	assert.Empty(t, findHex(zeros, seq))
+}
+
+func TestRulesErrors(t *testing.T) {
+	for name, src := range map[string]string{
+		"import":        `import "pe" rule A { condition: true }`,
+		"no condition":  `rule A { strings: $a = "x" }`,
+		"undefined str": `rule A { condition: $a }`,
+		"undefined id":  `rule A { condition: B }`,
+		"duplicate":     `rule A { condition: true } rule A { condition: false }`,
+		"bad hex":       `rule A { strings: $a = { 4D 5 } condition: $a }`,
+		"jump at start": `rule A { strings: $a = { [2] 4D } condition: $a }`,
+		"bad regex":     `rule A { strings: $a = /(/ condition: $a }`,
+		"bad modifier":  `rule A { strings: $a = "x" xor condition: $a }`,
+		"long jump":     `rule A { strings: $a = { 4D [0-100000] 5A } condition: $a }`,
+	} {
+		_, err := ParseRules(src)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadRulesDirectory(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.yar"), []byte(`rule Base { strings: $a = "base" condition: $a }`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.yara"), []byte(`rule Derived { condition: Base and filesize > 4 }`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "readme.txt"), []byte(`not a rule`), 0644))
+
+	rs, err := LoadRules([]string{dir})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rs.Len())
+	assert.Len(t, rs.Scan([]byte("database")), 2)
+	assert.Len(t, rs.Scan([]byte("base")), 1)
+}
+
+func TestScanPaths(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "clean.txt"), []byte("nothing"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "dropper.bin"), []byte("xx EICAR-like marker xx"), 0644))
+
+	rs, err := ParseRules(`rule Marker { strings: $m = "marker" condition: $m }`)
+	assert.NoError(t, err)
+	var out bytes.Buffer
+	scanned, matched, err := scanPaths(rs, []string{dir}, &out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, scanned)
+	assert.Equal(t, 1, matched)
+
+	var rec struct {
+		File struct {
+			Path string            `json:"path"`
+			Hash map[string]string `json:"hash"`
+		} `json:"file"`
+		Rule RuleMatch `json:"rule"`
+	}
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &rec))
+	assert.Equal(t, filepath.Join(dir, "sub", "dropper.bin"), rec.File.Path)
+	assert.Equal(t, "Marker", rec.Rule.Rule)
+	assert.NotEmpty(t, rec.File.Hash["sha256"])
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// SCAN_COMMAND — подкоманда проверки файлов правилами без сбора артефактов.
+const SCAN_COMMAND = "scan"
+
+// runScan выполняет подкоманду scan: fast_dfar scan -rules <правила> [-output файл] <путь>...
+// Каждый файл (каталоги обходятся рекурсивно) проверяется правилами, сработавшие правила
+// выводятся в формате matches.jsonl в файл или на стандартный вывод. Возвращает код завершения.
+func runScan(args []string) int {
+	fset := flag.NewFlagSet(SCAN_COMMAND, flag.ContinueOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Использование: %s %s -rules <правила> [флаги] <путь>...\n", filepath.Base(os.Args[0]), SCAN_COMMAND)
+		fset.PrintDefaults()
+	}
+	rulePaths := fset.String("rules", "", "Файлы или каталоги правил в синтаксисе YARA (через запятую)")
+	outputPath := fset.String("output", "", "Файл для результатов в формате matches.jsonl (по умолчанию стандартный вывод)")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if *rulePaths == "" || fset.NArg() == 0 {
+		fset.Usage()
+		return 2
+	}
+
+	// Стандартный вывод может быть занят результатами — журнал пишется в stderr.
+	logger.SetOutput(os.Stderr)
+	rules, err := LoadRules(splitArgs(*rulePaths))
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить правила: %v", err))
+		return 1
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			logger.Log(LevelCritical, err.Error())
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	scanned, matched, err := scanPaths(rules, fset.Args(), out)
+	if err != nil {
+		logger.Log(LevelCritical, err.Error())
+		return 1
+	}
+	logger.Log(LevelInfo, fmt.Sprintf("Проверено %d файлов правилами (%d), срабатываний: %d", scanned, rules.Len(), matched))
+	return 0
+}
+
+// scanPaths проверяет правилами файлы по указанным путям и пишет срабатывания в out.
+// Недоступные файлы пропускаются с записью в журнал.
+func scanPaths(rules *RuleSet, paths []string, out io.Writer) (int, int, error) {
+	enc := json.NewEncoder(out)
+	scanned, matched := 0, 0
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Skipping %s: %v", path, err))
+				return nil
+			}
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || info.Size() > MAX_RULES_SCAN_SIZE {
+				logger.Log(LevelDebug, fmt.Sprintf("Skipping %s: too large or unavailable", path))
+				return nil
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.Log(LevelWarning, fmt.Sprintf("Skipping %s: %v", path, err))
+				return nil
+			}
+			scanned++
+			ms := rules.Scan(data)
+			if len(ms) == 0 {
+				return nil
+			}
+			hashes, err := hashReader(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			for _, m := range ms {
+				if err := enc.Encode(newRuleMatchRecord(path, hashes, "", m)); err != nil {
+					return err
+				}
+				matched++
+			}
+			return nil
+		})
+		if err != nil {
+			return scanned, matched, err
+		}
+	}
+	return scanned, matched, nil
+}
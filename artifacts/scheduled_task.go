@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Задания планировщика Windows Vista+ хранятся в %SystemRoot%\System32\Tasks в виде XML
+// (схема Task Scheduler 1.2+), как правило в UTF-16 с BOM. Имя файла совпадает с именем
+// задания, а путь относительно каталога Tasks — с его URI.
+const TASK_MAX_SIZE = 4 << 20
+
+// taskDirectories — каталоги заданий планировщика (в нижнем регистре, с прямыми
+// косыми чертами); SysWOW64\Tasks встречается в образах 64-битных систем.
+var taskDirectories = []string{"/windows/system32/tasks/", "/windows/syswow64/tasks/"}
+
+// taskSkipExtensions — расширения файлов каталогов Tasks, не являющихся заданиями XML.
+var taskSkipExtensions = []string{".job", ".ini", ".dat", ".log"}
+
+// scheduledTaskXML — элементы XML задания, используемые при разборе.
+type scheduledTaskXML struct {
+	XMLName          xml.Name `xml:"Task"`
+	Version          string   `xml:"version,attr"`
+	RegistrationInfo struct {
+		Date        string `xml:"Date"`
+		Author      string `xml:"Author"`
+		Description string `xml:"Description"`
+		URI         string `xml:"URI"`
+		Source      string `xml:"Source"`
+	} `xml:"RegistrationInfo"`
+	Triggers struct {
+		Items []scheduledTaskTriggerXML `xml:",any"`
+	} `xml:"Triggers"`
+	Principals struct {
+		Items []struct {
+			ID        string `xml:"id,attr"`
+			UserID    string `xml:"UserId"`
+			GroupID   string `xml:"GroupId"`
+			RunLevel  string `xml:"RunLevel"`
+			LogonType string `xml:"LogonType"`
+		} `xml:"Principal"`
+	} `xml:"Principals"`
+	Settings struct {
+		Hidden  string `xml:"Hidden"`
+		Enabled string `xml:"Enabled"`
+	} `xml:"Settings"`
+	Actions struct {
+		Context string                   `xml:"Context,attr"`
+		Items   []scheduledTaskActionXML `xml:",any"`
+	} `xml:"Actions"`
+}
+
+type scheduledTaskTriggerXML struct {
+	XMLName       xml.Name
+	Enabled       string `xml:"Enabled"`
+	StartBoundary string `xml:"StartBoundary"`
+	EndBoundary   string `xml:"EndBoundary"`
+	UserID        string `xml:"UserId"`
+	Delay         string `xml:"Delay"`
+	Subscription  string `xml:"Subscription"`
+	StateChange   string `xml:"StateChange"`
+	Repetition    struct {
+		Interval string `xml:"Interval"`
+		Duration string `xml:"Duration"`
+	} `xml:"Repetition"`
+}
+
+type scheduledTaskActionXML struct {
+	XMLName          xml.Name
+	Command          string `xml:"Command"`
+	Arguments        string `xml:"Arguments"`
+	WorkingDirectory string `xml:"WorkingDirectory"`
+	ClassID          string `xml:"ClassId"`
+	Data             string `xml:"Data"`
+	To               string `xml:"To"`
+	Subject          string `xml:"Subject"`
+	Title            string `xml:"Title"`
+	Body             string `xml:"Body"`
+}
+
+// ScheduledTask — задание планировщика.
+type ScheduledTask struct {
+	Name        string
+	URI         string
+	Version     string
+	Author      string
+	Description string
+	Source      string
+	Date        string
+	Registered  time.Time
+	Hidden      bool
+	Enabled     bool
+	Context     string
+	Principal   map[string]interface{}
+	Triggers    []map[string]interface{}
+	Actions     []map[string]interface{}
+}
+
+// taskXMLText приводит содержимое файла задания к UTF-8: UTF-16 с BOM или без него
+// перекодируется, BOM UTF-8 отбрасывается.
+func taskXMLText(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		return []byte(decodeUTF16Full(data[2:]))
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return data[3:]
+	case len(data) >= 2 && data[0] == '<' && data[1] == 0:
+		return []byte(decodeUTF16Full(data))
+	}
+	return data
+}
+
+// nonEmpty возвращает словарь только с непустыми строковыми значениями.
+func nonEmpty(fields map[string]string) map[string]interface{} {
+	m := make(map[string]interface{})
+	for key, value := range fields {
+		if value = strings.TrimSpace(value); value != "" {
+			m[key] = value
+		}
+	}
+	return m
+}
+
+// ParseScheduledTask разбирает XML задания. name — имя задания из пути файла; оно
+// используется, если в задании не указан URI.
+func ParseScheduledTask(name string, data []byte) (*ScheduledTask, error) {
+	var x scheduledTaskXML
+	dec := xml.NewDecoder(bytes.NewReader(taskXMLText(data)))
+	// Содержимое уже в UTF-8, объявленная кодировка (обычно UTF-16) не учитывается
+	dec.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := dec.Decode(&x); err != nil {
+		return nil, fmt.Errorf("task XML: %w", err)
+	}
+	info := x.RegistrationInfo
+	t := &ScheduledTask{
+		Name:        name,
+		URI:         strings.TrimSpace(info.URI),
+		Version:     x.Version,
+		Author:      strings.TrimSpace(info.Author),
+		Description: strings.TrimSpace(info.Description),
+		Source:      strings.TrimSpace(info.Source),
+		Date:        strings.TrimSpace(info.Date),
+		Hidden:      strings.EqualFold(strings.TrimSpace(x.Settings.Hidden), "true"),
+		Enabled:     !strings.EqualFold(strings.TrimSpace(x.Settings.Enabled), "false"),
+		Context:     x.Actions.Context,
+	}
+	if t.URI != "" {
+		t.Name = t.URI
+	}
+	// Дата регистрации без смещения записана в местном времени системы и в @timestamp не попадает
+	if ts, err := time.Parse(time.RFC3339Nano, t.Date); err == nil {
+		t.Registered = ts
+	}
+
+	for i, p := range x.Principals.Items {
+		if i == 0 || p.ID == x.Actions.Context {
+			t.Principal = nonEmpty(map[string]string{
+				"id":         p.ID,
+				"user_id":    p.UserID,
+				"group_id":   p.GroupID,
+				"run_level":  p.RunLevel,
+				"logon_type": p.LogonType,
+			})
+		}
+	}
+	for _, tr := range x.Triggers.Items {
+		m := nonEmpty(map[string]string{
+			"start_boundary":      tr.StartBoundary,
+			"end_boundary":        tr.EndBoundary,
+			"user_id":             tr.UserID,
+			"delay":               tr.Delay,
+			"subscription":        tr.Subscription,
+			"state_change":        tr.StateChange,
+			"repetition_interval": tr.Repetition.Interval,
+			"repetition_duration": tr.Repetition.Duration,
+		})
+		m["type"] = tr.XMLName.Local
+		m["enabled"] = !strings.EqualFold(strings.TrimSpace(tr.Enabled), "false")
+		t.Triggers = append(t.Triggers, m)
+	}
+	for _, a := range x.Actions.Items {
+		m := nonEmpty(map[string]string{
+			"command":           a.Command,
+			"arguments":         a.Arguments,
+			"working_directory": a.WorkingDirectory,
+			"class_id":          a.ClassID,
+			"data":              a.Data,
+			"to":                a.To,
+			"subject":           a.Subject,
+			"title":             a.Title,
+			"body":              a.Body,
+		})
+		m["type"] = a.XMLName.Local
+		t.Actions = append(t.Actions, m)
+	}
+	return t, nil
+}
+
+// AsDict возвращает запись задания для JSONL; дата регистрации со смещением попадает
+// в @timestamp.
+func (t *ScheduledTask) AsDict(path string) map[string]interface{} {
+	triggers, actions := t.Triggers, t.Actions
+	if triggers == nil {
+		triggers = []map[string]interface{}{}
+	}
+	if actions == nil {
+		actions = []map[string]interface{}{}
+	}
+	task := map[string]interface{}{
+		"name":     t.Name,
+		"hidden":   t.Hidden,
+		"enabled":  t.Enabled,
+		"triggers": triggers,
+		"actions":  actions,
+	}
+	for key, value := range nonEmpty(map[string]string{
+		"uri":         t.URI,
+		"version":     t.Version,
+		"author":      t.Author,
+		"description": t.Description,
+		"source":      t.Source,
+		"date":        t.Date,
+		"context":     t.Context,
+	}) {
+		task[key] = value
+	}
+	if t.Principal != nil {
+		task["principal"] = t.Principal
+	}
+	record := map[string]interface{}{
+		"event":          map[string]interface{}{"kind": "event", "category": "configuration", "action": "scheduled-task"},
+		"file":           map[string]interface{}{"path": path},
+		"scheduled_task": task,
+	}
+	if !t.Registered.IsZero() {
+		record["@timestamp"] = formatTime(t.Registered)
+	}
+	return record
+}
+
+// scheduledTaskName возвращает имя задания из пути файла: путь относительно каталога
+// Tasks с ведущей обратной косой чертой, как в URI.
+func scheduledTaskName(path string) string {
+	p := strings.ReplaceAll(path, `\`, "/")
+	if i := taskDirectoryEnd(strings.ToLower(p)); i >= 0 {
+		return `\` + strings.ReplaceAll(p[i:], "/", `\`)
+	}
+	return `\` + filepath.Base(p)
+}
+
+// taskDirectoryEnd возвращает смещение в пути (в нижнем регистре, с прямыми косыми
+// чертами) сразу за каталогом заданий или -1, если путь лежит вне него.
+func taskDirectoryEnd(lower string) int {
+	for _, dir := range taskDirectories {
+		if i := strings.Index(lower, dir); i >= 0 {
+			return i + len(dir)
+		}
+	}
+	return -1
+}
+
+// ----------------------------------------------------------------------
+// scheduledTaskParser – разбор заданий планировщика как ArtifactParser
+// ----------------------------------------------------------------------
+
+type scheduledTaskParser struct{}
+
+func newScheduledTaskParser(opts parserOptions) (ArtifactParser, error) {
+	return &scheduledTaskParser{}, nil
+}
+
+func (p *scheduledTaskParser) Name() string { return PARSER_TASKS }
+
+// Match принимает файлы в каталогах System32\Tasks и SysWOW64\Tasks, кроме заданий
+// формата .job (Windows XP) и служебных файлов каталога.
+func (p *scheduledTaskParser) Match(path string) bool {
+	lower := strings.ToLower(strings.ReplaceAll(path, `\`, "/"))
+	if taskDirectoryEnd(lower) < 0 {
+		return false
+	}
+	return !containsString(taskSkipExtensions, filepath.Ext(lower))
+}
+
+func (p *scheduledTaskParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, TASK_MAX_SIZE+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > TASK_MAX_SIZE {
+		return fmt.Errorf("task file larger than %d bytes", TASK_MAX_SIZE)
+	}
+	task, err := ParseScheduledTask(scheduledTaskName(path), data)
+	if err != nil {
+		return err
+	}
+	return emit(task.AsDict(path))
+}
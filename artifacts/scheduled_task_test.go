@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTaskXML = `<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo>
+    <Date>2024-08-01T10:00:00+03:00</Date>
+    <Author>CORP\mallory</Author>
+    <Description>Keeps things updated</Description>
+    <URI>\Microsoft\Windows\Updater</URI>
+  </RegistrationInfo>
+  <Triggers>
+    <LogonTrigger>
+      <Enabled>true</Enabled>
+      <UserId>CORP\alice</UserId>
+    </LogonTrigger>
+    <TimeTrigger>
+      <Repetition>
+        <Interval>PT5M</Interval>
+      </Repetition>
+      <StartBoundary>2024-08-01T10:05:00</StartBoundary>
+      <Enabled>false</Enabled>
+    </TimeTrigger>
+  </Triggers>
+  <Principals>
+    <Principal id="Author">
+      <UserId>S-1-5-18</UserId>
+      <RunLevel>HighestAvailable</RunLevel>
+    </Principal>
+  </Principals>
+  <Settings>
+    <Hidden>true</Hidden>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>powershell.exe</Command>
+      <Arguments>-w hidden -enc SQBFAFgA</Arguments>
+    </Exec>
+    <ComHandler>
+      <ClassId>{AAAA0000-1111-2222-3333-444455556666}</ClassId>
+    </ComHandler>
+  </Actions>
+</Task>`
+
+func TestParseScheduledTask(t *testing.T) {
+	// Файлы заданий обычно в UTF-16 с BOM
+	data := append([]byte{0xFF, 0xFE}, testUTF16(testTaskXML)...)
+	task, err := ParseScheduledTask(`\Updater`, data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `\Microsoft\Windows\Updater`, task.Name)
+	assert.Equal(t, `CORP\mallory`, task.Author)
+	assert.True(t, task.Hidden)
+	assert.True(t, task.Enabled)
+	assert.Equal(t, map[string]interface{}{"id": "Author", "user_id": "S-1-5-18", "run_level": "HighestAvailable"}, task.Principal)
+	assert.Equal(t, []map[string]interface{}{
+		{"type": "LogonTrigger", "enabled": true, "user_id": `CORP\alice`},
+		{"type": "TimeTrigger", "enabled": false, "start_boundary": "2024-08-01T10:05:00", "repetition_interval": "PT5M"},
+	}, task.Triggers)
+	assert.Equal(t, []map[string]interface{}{
+		{"type": "Exec", "command": "powershell.exe", "arguments": "-w hidden -enc SQBFAFgA"},
+		{"type": "ComHandler", "class_id": "{AAAA0000-1111-2222-3333-444455556666}"},
+	}, task.Actions)
+
+	m := task.AsDict(`C:\Windows\System32\Tasks\Microsoft\Windows\Updater`)
+	assert.Equal(t, "2024-08-01T07:00:00Z", m["@timestamp"])
+	assert.Equal(t, "Author", m["scheduled_task"].(map[string]interface{})["context"])
+
+	_, err = ParseScheduledTask(`\Broken`, []byte("<Task><Actions>"))
+	assert.Error(t, err)
+	_, err = ParseScheduledTask(`\Other`, []byte("<Job/>"))
+	assert.Error(t, err)
+}
+
+func TestScheduledTaskParser(t *testing.T) {
+	p, err := newScheduledTaskParser(parserOptions{})
+	assert.NoError(t, err)
+	path := `C:\Windows\System32\Tasks\Evil Task`
+	assert.True(t, p.Match(path))
+	assert.False(t, p.Match(`C:\Windows\Tasks\At1.job`))
+	assert.False(t, p.Match(`C:\Windows\System32\cmd.exe`))
+	assert.True(t, p.Match(`C:\Windows\SysWOW64\Tasks\Updater`))
+	assert.False(t, p.Match(`C:\Users\alice\Documents\tasks\notes.xml`))
+	assert.False(t, p.Match(`D:\Projects\app\src\tasks\build.xml`))
+	assert.Equal(t, `\Microsoft\Windows\Defrag\ScheduledDefrag`, scheduledTaskName(`C:\Windows\System32\Tasks\Microsoft\Windows\Defrag\ScheduledDefrag`))
+
+	// Задание без URI получает имя из пути файла, дата без смещения не попадает в @timestamp
+	xml := `<Task><RegistrationInfo><Date>2024-08-01T10:00:00</Date></RegistrationInfo><Settings><Enabled>false</Enabled></Settings></Task>`
+	var records []map[string]interface{}
+	assert.NoError(t, p.Parse(path, bytes.NewReader([]byte(xml)), func(record map[string]interface{}) error {
+		records = append(records, record)
+		return nil
+	}))
+	if assert.Len(t, records, 1) {
+		assert.NotContains(t, records[0], "@timestamp")
+		task := records[0]["scheduled_task"].(map[string]interface{})
+		assert.Equal(t, `\Evil Task`, task["name"])
+		assert.Equal(t, false, task["enabled"])
+		assert.Equal(t, false, task["hidden"])
+		assert.Equal(t, []map[string]interface{}{}, task["actions"])
+	}
+}
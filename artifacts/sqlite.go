@@ -0,0 +1,484 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// Файл базы SQLite 3 состоит из страниц одинакового размера; первая страница начинается
+// с заголовка файла (100 байт) и содержит корень схемы sqlite_master. Таблицы хранятся в
+// B-деревьях по rowid: внутренние страницы ссылаются на дочерние, листовые содержат
+// записи. Запись, не помещающаяся на странице, продолжается цепочкой страниц переполнения.
+//
+// База в режиме WAL (версии чтения и записи в заголовке равны 2) хранит последние
+// изменения в журнале <база>-wal: кадры с номером страницы и её новым содержимым.
+// ApplySQLiteWAL накладывает на образ базы кадры зафиксированных транзакций; журнал
+// отката -journal содержит прежние версии страниц и не применяется.
+const (
+	SQLITE_HEADER        = "SQLite format 3\x00"
+	SQLITE_HEADER_SIZE   = 100
+	SQLITE_SCHEMA_PAGE   = 1
+	SQLITE_MAX_DEPTH     = 64
+	SQLITE_INTERIOR_PAGE = 0x05
+	SQLITE_LEAF_PAGE     = 0x0D
+	SQLITE_WAL_MODE      = 2
+
+	SQLITE_UTF8    = 1
+	SQLITE_UTF16LE = 2
+	SQLITE_UTF16BE = 3
+
+	SQLITE_WAL_MAGIC             = 0x377F0682
+	SQLITE_WAL_HEADER_SIZE       = 32
+	SQLITE_WAL_FRAME_HEADER_SIZE = 24
+)
+
+var (
+	sqliteCreateTable = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:TEMP\w*\s+)?TABLE\s`)
+	sqliteRowidAlias  = regexp.MustCompile(`(?i)^\S+\s+INTEGER\s+PRIMARY\s+KEY\b`)
+	sqliteWithoutRow  = regexp.MustCompile(`(?i)\)\s*WITHOUT\s+ROWID\s*;?\s*$`)
+)
+
+// sqliteConstraints — ключевые слова, с которых начинаются ограничения таблицы, а не столбцы.
+var sqliteConstraints = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN"}
+
+// SQLiteTable — таблица базы: корневая страница и столбцы из CREATE TABLE.
+type SQLiteTable struct {
+	db      *SQLiteDatabase
+	Name    string
+	Columns []string
+	root    uint32
+	rowid   int // номер столбца-псевдонима rowid (INTEGER PRIMARY KEY) или -1
+	noRowid bool
+}
+
+// SQLiteDatabase — база SQLite, прочитанная в память.
+type SQLiteDatabase struct {
+	data     []byte
+	pageSize int
+	usable   int
+	encoding uint32
+	tables   map[string]*SQLiteTable
+}
+
+// OpenSQLite разбирает заголовок базы и схему sqlite_master.
+func OpenSQLite(data []byte) (*SQLiteDatabase, error) {
+	if len(data) < SQLITE_HEADER_SIZE || string(data[:len(SQLITE_HEADER)]) != SQLITE_HEADER {
+		return nil, fmt.Errorf("not an SQLite database: bad header")
+	}
+	be := binary.BigEndian
+	db := &SQLiteDatabase{
+		pageSize: int(be.Uint16(data[16:])),
+		encoding: be.Uint32(data[56:]),
+		data:     data,
+		tables:   make(map[string]*SQLiteTable),
+	}
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	if db.pageSize < 512 || db.pageSize&(db.pageSize-1) != 0 {
+		return nil, fmt.Errorf("SQLite: bad page size %d", db.pageSize)
+	}
+	db.usable = db.pageSize - int(data[20])
+	if db.usable < 480 {
+		return nil, fmt.Errorf("SQLite: bad reserved size %d", data[20])
+	}
+	schema := &SQLiteTable{
+		db: db, Name: "sqlite_master", root: SQLITE_SCHEMA_PAGE, rowid: -1,
+		Columns: []string{"type", "name", "tbl_name", "rootpage", "sql"},
+	}
+	err := schema.Rows(func(rowid int64, row map[string]interface{}) error {
+		typ, _ := row["type"].(string)
+		name, _ := row["name"].(string)
+		root, _ := row["rootpage"].(int64)
+		sql, _ := row["sql"].(string)
+		if typ != "table" || root <= 0 || !sqliteCreateTable.MatchString(sql) {
+			return nil
+		}
+		t := &SQLiteTable{db: db, Name: name, root: uint32(root), rowid: -1}
+		t.Columns, t.rowid = sqliteColumns(sql)
+		t.noRowid = sqliteWithoutRow.MatchString(sql)
+		db.tables[strings.ToLower(name)] = t
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("SQLite schema: %w", err)
+	}
+	return db, nil
+}
+
+// SQLiteWALMode сообщает, записана ли база в режиме WAL.
+func SQLiteWALMode(data []byte) bool {
+	return len(data) >= SQLITE_HEADER_SIZE && string(data[:len(SQLITE_HEADER)]) == SQLITE_HEADER &&
+		data[18] == SQLITE_WAL_MODE && data[19] == SQLITE_WAL_MODE
+}
+
+// ApplySQLiteWAL возвращает образ базы с наложенными кадрами журнала -wal и число
+// применённых кадров. Кадры проверяются по соли заголовка журнала и нарастающей
+// контрольной сумме; применяются только кадры до последнего кадра фиксации (с ненулевым
+// размером базы), остальные относятся к незавершённой транзакции. Исходный образ не
+// изменяется.
+func ApplySQLiteWAL(data, wal []byte) ([]byte, int, error) {
+	if len(wal) < SQLITE_WAL_HEADER_SIZE || binary.BigEndian.Uint32(wal)&^1 != SQLITE_WAL_MAGIC {
+		return nil, 0, fmt.Errorf("not an SQLite WAL: bad header")
+	}
+	be := binary.BigEndian
+	pageSize := int(be.Uint32(wal[8:]))
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return nil, 0, fmt.Errorf("SQLite WAL: bad page size %d", pageSize)
+	}
+	if len(data) >= SQLITE_HEADER_SIZE {
+		dbPage := int(be.Uint16(data[16:]))
+		if dbPage == 1 {
+			dbPage = 65536
+		}
+		if dbPage != pageSize {
+			return nil, 0, fmt.Errorf("SQLite WAL: page size %d differs from database page size %d", pageSize, dbPage)
+		}
+	}
+	// Порядок байтов контрольной суммы задаёт младший бит сигнатуры
+	var order binary.ByteOrder = binary.LittleEndian
+	if be.Uint32(wal)&1 != 0 {
+		order = binary.BigEndian
+	}
+	checksum := func(s1, s2 uint32, b []byte) (uint32, uint32) {
+		for i := 0; i+8 <= len(b); i += 8 {
+			s1 += order.Uint32(b[i:]) + s2
+			s2 += order.Uint32(b[i+4:]) + s1
+		}
+		return s1, s2
+	}
+	s1, s2 := checksum(0, 0, wal[:24])
+	if s1 != be.Uint32(wal[24:]) || s2 != be.Uint32(wal[28:]) {
+		return nil, 0, fmt.Errorf("SQLite WAL: header checksum mismatch")
+	}
+
+	pending := make(map[uint32]int)
+	committed := make(map[uint32]int)
+	frames, pendingFrames, dbPages := 0, 0, 0
+	frameSize := SQLITE_WAL_FRAME_HEADER_SIZE + pageSize
+	for off := SQLITE_WAL_HEADER_SIZE; off+frameSize <= len(wal); off += frameSize {
+		frame := wal[off : off+frameSize]
+		if !bytes.Equal(frame[8:16], wal[16:24]) {
+			break
+		}
+		s1, s2 = checksum(s1, s2, frame[:8])
+		s1, s2 = checksum(s1, s2, frame[SQLITE_WAL_FRAME_HEADER_SIZE:])
+		if s1 != be.Uint32(frame[16:]) || s2 != be.Uint32(frame[20:]) {
+			break
+		}
+		page := be.Uint32(frame)
+		if page == 0 {
+			break
+		}
+		pending[page] = off + SQLITE_WAL_FRAME_HEADER_SIZE
+		pendingFrames++
+		if size := int(be.Uint32(frame[4:])); size > 0 {
+			for p, at := range pending {
+				committed[p] = at
+			}
+			clear(pending)
+			frames += pendingFrames
+			pendingFrames, dbPages = 0, size
+		}
+	}
+	if frames == 0 {
+		return data, 0, nil
+	}
+	// Размер базы после фиксации не может превышать исходный файл и все страницы журнала
+	if dbPages > len(data)/pageSize+len(committed) {
+		return nil, 0, fmt.Errorf("SQLite WAL: database size %d pages out of range", dbPages)
+	}
+	out := make([]byte, dbPages*pageSize)
+	copy(out, data)
+	for page, at := range committed {
+		if int(page) <= dbPages {
+			copy(out[int(page-1)*pageSize:], wal[at:at+pageSize])
+		}
+	}
+	return out, frames, nil
+}
+
+// sqliteColumns возвращает имена столбцов из CREATE TABLE и номер столбца INTEGER
+// PRIMARY KEY, значение которого хранится как rowid.
+func sqliteColumns(sql string) ([]string, int) {
+	start, end := strings.Index(sql, "("), strings.LastIndex(sql, ")")
+	if start < 0 || end <= start {
+		return nil, -1
+	}
+	var defs []string
+	depth, quote, from := 0, rune(0), start+1
+	for i, c := range sql[start+1 : end] {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			defs = append(defs, sql[from:start+1+i])
+			from = start + 2 + i
+		}
+	}
+	defs = append(defs, sql[from:end])
+
+	var columns []string
+	rowid := -1
+	for _, def := range defs {
+		def = strings.TrimSpace(def)
+		fields := strings.Fields(def)
+		if len(fields) == 0 || containsString(sqliteConstraints, strings.ToUpper(fields[0])) {
+			continue
+		}
+		name := fields[0]
+		if q := name[0]; q == '"' || q == '`' || q == '[' {
+			// Имя в кавычках может содержать пробелы
+			closing := map[byte]byte{'"': '"', '`': '`', '[': ']'}[q]
+			if i := strings.IndexByte(def[1:], closing); i >= 0 {
+				name = def[1 : i+1]
+			}
+		}
+		if sqliteRowidAlias.MatchString(def) {
+			rowid = len(columns)
+		}
+		columns = append(columns, name)
+	}
+	return columns, rowid
+}
+
+// Table возвращает таблицу по имени без учёта регистра или nil.
+func (db *SQLiteDatabase) Table(name string) *SQLiteTable {
+	return db.tables[strings.ToLower(name)]
+}
+
+// page возвращает содержимое страницы по номеру (с 1).
+func (db *SQLiteDatabase) page(n uint32) ([]byte, error) {
+	off := int64(n-1) * int64(db.pageSize)
+	if n == 0 || off+int64(db.pageSize) > int64(len(db.data)) {
+		return nil, fmt.Errorf("SQLite page %d out of file", n)
+	}
+	return db.data[off : off+int64(db.pageSize)], nil
+}
+
+// sqliteVarint читает целое переменной длины (1–9 байт, big-endian).
+func sqliteVarint(b []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(b); i++ {
+		if i == 8 {
+			return v<<8 | uint64(b[i]), 9
+		}
+		v = v<<7 | uint64(b[i]&0x7F)
+		if b[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return v, 0
+}
+
+// Rows передаёт в fn rowid и значения столбцов каждой строки таблицы в порядке rowid.
+// Значения — int64, float64, string, []byte или nil.
+func (t *SQLiteTable) Rows(fn func(rowid int64, row map[string]interface{}) error) error {
+	if t.noRowid {
+		return fmt.Errorf("SQLite table %s: WITHOUT ROWID tables are not supported", t.Name)
+	}
+	visited := make(map[uint32]bool)
+	var walk func(n uint32, depth int) error
+	walk = func(n uint32, depth int) error {
+		if depth > SQLITE_MAX_DEPTH || visited[n] {
+			return fmt.Errorf("SQLite: page loop at %d", n)
+		}
+		visited[n] = true
+		page, err := t.db.page(n)
+		if err != nil {
+			return err
+		}
+		header := 0
+		if n == SQLITE_SCHEMA_PAGE {
+			header = SQLITE_HEADER_SIZE
+		}
+		be := binary.BigEndian
+		kind := page[header]
+		count := int(be.Uint16(page[header+3:]))
+		cells := header + 8
+		if kind == SQLITE_INTERIOR_PAGE {
+			cells = header + 12
+		} else if kind != SQLITE_LEAF_PAGE {
+			return fmt.Errorf("SQLite page %d: unexpected page type 0x%02x", n, kind)
+		}
+		if cells+count*2 > len(page) {
+			return fmt.Errorf("SQLite page %d: cell array truncated", n)
+		}
+		for i := 0; i < count; i++ {
+			off := int(be.Uint16(page[cells+i*2:]))
+			if off+4 > t.db.usable {
+				continue
+			}
+			if kind == SQLITE_INTERIOR_PAGE {
+				if err := walk(be.Uint32(page[off:]), depth+1); err != nil {
+					return err
+				}
+				continue
+			}
+			rowid, payload, err := t.db.leafCell(page[:t.db.usable], off)
+			if err != nil {
+				return fmt.Errorf("SQLite page %d: %w", n, err)
+			}
+			if err := fn(rowid, t.record(rowid, payload)); err != nil {
+				return err
+			}
+		}
+		if kind == SQLITE_INTERIOR_PAGE {
+			return walk(be.Uint32(page[header+8:]), depth+1)
+		}
+		return nil
+	}
+	return walk(t.root, 0)
+}
+
+// leafCell читает ячейку листа таблицы: размер данных, rowid и данные, при необходимости
+// дочитывая их со страниц переполнения.
+func (db *SQLiteDatabase) leafCell(page []byte, off int) (int64, []byte, error) {
+	size, n := sqliteVarint(page[off:])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("bad cell size")
+	}
+	off += n
+	rowid, n := sqliteVarint(page[off:])
+	if n == 0 {
+		return 0, nil, fmt.Errorf("bad rowid")
+	}
+	off += n
+	if size > uint64(len(db.data)) {
+		return 0, nil, fmt.Errorf("cell payload %d larger than file", size)
+	}
+	// Часть данных, хранимая на самой странице ([SQLite file format] 1.6)
+	u, p := db.usable, int(size)
+	local := p
+	if maxLocal := u - 35; p > maxLocal {
+		minLocal := (u-12)*32/255 - 23
+		local = minLocal + (p-minLocal)%(u-4)
+		if local > maxLocal {
+			local = minLocal
+		}
+	}
+	if off+local > len(page) {
+		return 0, nil, fmt.Errorf("cell payload truncated")
+	}
+	payload := append([]byte(nil), page[off:off+local]...)
+	if local == p {
+		return int64(rowid), payload, nil
+	}
+	if off+local+4 > len(page) {
+		return 0, nil, fmt.Errorf("overflow pointer truncated")
+	}
+	next := binary.BigEndian.Uint32(page[off+local:])
+	visited := make(map[uint32]bool)
+	for len(payload) < p {
+		if next == 0 || visited[next] {
+			return 0, nil, fmt.Errorf("overflow chain broken")
+		}
+		visited[next] = true
+		overflow, err := db.page(next)
+		if err != nil {
+			return 0, nil, err
+		}
+		next = binary.BigEndian.Uint32(overflow)
+		payload = append(payload, overflow[4:min(u, 4+p-len(payload))]...)
+	}
+	return int64(rowid), payload, nil
+}
+
+// record разбирает запись: заголовок с типами значений и сами значения. Столбцы,
+// добавленные ALTER TABLE после записи строки, отсутствуют в ней и получают nil.
+func (t *SQLiteTable) record(rowid int64, payload []byte) map[string]interface{} {
+	row := make(map[string]interface{}, len(t.Columns))
+	for _, name := range t.Columns {
+		row[name] = nil
+	}
+	headerSize, n := sqliteVarint(payload)
+	if n == 0 || headerSize > uint64(len(payload)) {
+		return row
+	}
+	be := binary.BigEndian
+	pos, body := n, int(headerSize)
+	for col := 0; pos < int(headerSize); col++ {
+		typ, n := sqliteVarint(payload[pos:int(headerSize)])
+		if n == 0 {
+			break
+		}
+		pos += n
+		var value interface{}
+		size := 0
+		switch {
+		case typ >= 1 && typ <= 6:
+			size = []int{1, 2, 3, 4, 6, 8}[typ-1]
+		case typ == 7:
+			size = 8
+		case typ >= 12:
+			size = int((typ - 12) / 2)
+		}
+		// Длина из 64-битного типа может переполнить сумму со смещением
+		if size < 0 || size > len(payload)-body {
+			break
+		}
+		raw := payload[body : body+size]
+		body += size
+		switch {
+		case typ >= 1 && typ <= 6:
+			// Целое со знаком big-endian длиной 1–8 байт
+			var v int64
+			if raw[0]&0x80 != 0 {
+				v = -1
+			}
+			for _, c := range raw {
+				v = v<<8 | int64(c)
+			}
+			value = v
+		case typ == 7:
+			value = math.Float64frombits(be.Uint64(raw))
+		case typ == 8:
+			value = int64(0)
+		case typ == 9:
+			value = int64(1)
+		case typ >= 12 && typ%2 == 0:
+			value = append([]byte(nil), raw...)
+		case typ >= 13:
+			value = t.db.text(raw)
+		}
+		if col < len(t.Columns) {
+			row[t.Columns[col]] = value
+		}
+	}
+	// Значение INTEGER PRIMARY KEY хранится только как rowid
+	if t.rowid >= 0 && row[t.Columns[t.rowid]] == nil {
+		row[t.Columns[t.rowid]] = rowid
+	}
+	return row
+}
+
+// text декодирует строку в кодировке базы.
+func (db *SQLiteDatabase) text(raw []byte) string {
+	if db.encoding != SQLITE_UTF16LE && db.encoding != SQLITE_UTF16BE {
+		return string(raw)
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if db.encoding == SQLITE_UTF16BE {
+		order = binary.BigEndian
+	}
+	u := make([]uint16, len(raw)/2)
+	for i := range u {
+		u[i] = order.Uint16(raw[i*2:])
+	}
+	return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+}
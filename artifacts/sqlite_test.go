@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSQLite собирает файл базы SQLite: страницы таблиц добавляются по порядку, первая
+// страница со схемой заполняется в bytes.
+type testSQLite struct {
+	pageSize int
+	pages    [][]byte
+}
+
+// testSQLiteTable — таблица схемы и её строки; rowid строк — номера с 1, значение
+// INTEGER PRIMARY KEY передаётся как nil, как его хранит SQLite.
+type testSQLiteTable struct {
+	name string
+	sql  string
+	rows [][]interface{}
+}
+
+func newTestSQLite(pageSize int) *testSQLite {
+	return &testSQLite{pageSize: pageSize, pages: [][]byte{nil}}
+}
+
+func testSQLiteVarint(v uint64) []byte {
+	out := []byte{byte(v & 0x7F)}
+	for v >>= 7; v > 0; v >>= 7 {
+		out = append([]byte{byte(v&0x7F) | 0x80}, out...)
+	}
+	return out
+}
+
+// testSQLiteRecord кодирует запись с наименьшим подходящим типом целых.
+func testSQLiteRecord(values ...interface{}) []byte {
+	var types, body []byte
+	for _, value := range values {
+		var typ uint64
+		switch v := value.(type) {
+		case nil:
+		case int:
+			n := int64(v)
+			switch {
+			case n == int64(int8(n)):
+				typ, body = 1, append(body, byte(n))
+			case n == int64(int16(n)):
+				typ, body = 2, binary.BigEndian.AppendUint16(body, uint16(n))
+			case n == int64(int32(n)):
+				typ, body = 4, binary.BigEndian.AppendUint32(body, uint32(n))
+			default:
+				typ, body = 6, binary.BigEndian.AppendUint64(body, uint64(n))
+			}
+		case float64:
+			typ, body = 7, binary.BigEndian.AppendUint64(body, math.Float64bits(v))
+		case string:
+			typ, body = uint64(len(v))*2+13, append(body, v...)
+		case []byte:
+			typ, body = uint64(len(v))*2+12, append(body, v...)
+		}
+		types = append(types, testSQLiteVarint(typ)...)
+	}
+	header := append(testSQLiteVarint(uint64(len(types)+1)), types...)
+	return append(header, body...)
+}
+
+func (b *testSQLite) add(page []byte) uint32 {
+	b.pages = append(b.pages, page)
+	return uint32(len(b.pages))
+}
+
+// page собирает страницу с ячейками; header — смещение заголовка страницы (100 на первой).
+func (b *testSQLite) page(kind byte, header int, cells [][]byte, right uint32) []byte {
+	page := make([]byte, b.pageSize)
+	page[header] = kind
+	binary.BigEndian.PutUint16(page[header+3:], uint16(len(cells)))
+	pointers := header + 8
+	if kind == SQLITE_INTERIOR_PAGE {
+		binary.BigEndian.PutUint32(page[header+8:], right)
+		pointers = header + 12
+	}
+	end := b.pageSize
+	for i, cell := range cells {
+		end -= len(cell)
+		copy(page[end:], cell)
+		binary.BigEndian.PutUint16(page[pointers+i*2:], uint16(end))
+	}
+	binary.BigEndian.PutUint16(page[header+5:], uint16(end))
+	return page
+}
+
+// cell собирает ячейку листа; данные, не помещающиеся на странице, уходят в страницы
+// переполнения.
+func (b *testSQLite) cell(rowid int64, payload []byte) []byte {
+	cell := append(testSQLiteVarint(uint64(len(payload))), testSQLiteVarint(uint64(rowid))...)
+	u, p := b.pageSize, len(payload)
+	if p <= u-35 {
+		return append(cell, payload...)
+	}
+	minLocal := (u-12)*32/255 - 23
+	local := minLocal + (p-minLocal)%(u-4)
+	if local > u-35 {
+		local = minLocal
+	}
+	cell = append(cell, payload[:local]...)
+	rest := payload[local:]
+	first := uint32(len(b.pages) + 1)
+	for len(rest) > 0 {
+		page := make([]byte, b.pageSize)
+		n := copy(page[4:], rest)
+		if rest = rest[n:]; len(rest) > 0 {
+			binary.BigEndian.PutUint32(page, uint32(len(b.pages)+2))
+		}
+		b.add(page)
+	}
+	return binary.BigEndian.AppendUint32(cell, first)
+}
+
+// leaf добавляет листовую страницу с записями и возвращает её номер.
+func (b *testSQLite) leaf(firstRowid int64, rows [][]interface{}) uint32 {
+	var cells [][]byte
+	for i, row := range rows {
+		cells = append(cells, b.cell(firstRowid+int64(i), testSQLiteRecord(row...)))
+	}
+	return b.add(b.page(SQLITE_LEAF_PAGE, 0, cells, 0))
+}
+
+// bytes собирает файл: таблицы без корня в roots получают по одной листовой странице.
+func (b *testSQLite) bytes(tables []testSQLiteTable, roots map[string]uint32) []byte {
+	var schema [][]byte
+	for i, t := range tables {
+		root, ok := roots[t.name]
+		if !ok {
+			root = b.leaf(1, t.rows)
+		}
+		schema = append(schema, b.cell(int64(i+1), testSQLiteRecord("table", t.name, t.name, int(root), t.sql)))
+	}
+	b.pages[0] = b.page(SQLITE_LEAF_PAGE, SQLITE_HEADER_SIZE, schema, 0)
+	copy(b.pages[0], SQLITE_HEADER)
+	binary.BigEndian.PutUint16(b.pages[0][16:], uint16(b.pageSize))
+	binary.BigEndian.PutUint32(b.pages[0][56:], SQLITE_UTF8)
+	var data []byte
+	for _, page := range b.pages {
+		data = append(data, page...)
+	}
+	return data
+}
+
+// buildTestSQLiteDB собирает базу из таблиц с одной листовой страницей каждая.
+func buildTestSQLiteDB(tables ...testSQLiteTable) []byte {
+	return newTestSQLite(4096).bytes(tables, nil)
+}
+
+// testSQLiteWALFrame — кадр журнала: номер страницы, размер базы в страницах для
+// кадра фиксации (0 для остальных) и содержимое страницы.
+type testSQLiteWALFrame struct {
+	page   uint32
+	commit uint32
+	data   []byte
+}
+
+// testSQLiteWAL собирает журнал -wal с контрольными суммами в порядке little-endian.
+func testSQLiteWAL(pageSize int, frames ...testSQLiteWALFrame) []byte {
+	be, le := binary.BigEndian, binary.LittleEndian
+	var s1, s2 uint32
+	sum := func(b []byte) {
+		for i := 0; i < len(b); i += 8 {
+			s1 += le.Uint32(b[i:]) + s2
+			s2 += le.Uint32(b[i+4:]) + s1
+		}
+	}
+	wal := make([]byte, SQLITE_WAL_HEADER_SIZE)
+	be.PutUint32(wal, SQLITE_WAL_MAGIC)
+	be.PutUint32(wal[4:], 3007000)
+	be.PutUint32(wal[8:], uint32(pageSize))
+	be.PutUint32(wal[16:], 0x1234)
+	be.PutUint32(wal[20:], 0x5678)
+	sum(wal[:24])
+	be.PutUint32(wal[24:], s1)
+	be.PutUint32(wal[28:], s2)
+	for _, f := range frames {
+		header := make([]byte, SQLITE_WAL_FRAME_HEADER_SIZE)
+		be.PutUint32(header, f.page)
+		be.PutUint32(header[4:], f.commit)
+		copy(header[8:], wal[16:24])
+		sum(header[:8])
+		sum(f.data)
+		be.PutUint32(header[16:], s1)
+		be.PutUint32(header[20:], s2)
+		wal = append(append(wal, header...), f.data...)
+	}
+	return wal
+}
+
+func TestSQLiteColumns(t *testing.T) {
+	columns, rowid := sqliteColumns(`CREATE TABLE "t" (a TEXT, "b c" DECIMAL(10, 2), id INTEGER PRIMARY KEY,` +
+		` [d] BLOB DEFAULT (x'00'), CONSTRAINT u UNIQUE (a, id), CHECK (a != ','))`)
+	assert.Equal(t, []string{"a", "b c", "id", "d"}, columns)
+	assert.Equal(t, 2, rowid)
+	columns, rowid = sqliteColumns(`CREATE TABLE x(id INTEGER NOT NULL, PRIMARY KEY (id))`)
+	assert.Equal(t, []string{"id"}, columns)
+	assert.Equal(t, -1, rowid)
+}
+
+func TestOpenSQLite(t *testing.T) {
+	b := newTestSQLite(1024)
+	// Таблица из двух листьев под внутренней страницей и строкой со страницами переполнения
+	long := strings.Repeat("переполнение ", 100)
+	left := b.leaf(1, [][]interface{}{{nil, "first", 1, 0.5, []byte{1, 2}}, {nil, long, -2, nil, nil}})
+	right := b.leaf(3, [][]interface{}{{nil, "third", 70000, nil, nil}, {nil, "fourth", int(-1 << 40)}})
+	var interior []byte
+	interior = binary.BigEndian.AppendUint32(interior, left)
+	interior = append(interior, testSQLiteVarint(2)...)
+	root := b.add(b.page(SQLITE_INTERIOR_PAGE, 0, [][]byte{interior}, right))
+	data := b.bytes([]testSQLiteTable{
+		{name: "items", sql: "CREATE TABLE items(id INTEGER PRIMARY KEY, name TEXT, n INTEGER, f REAL, b BLOB, added TEXT)"},
+		{name: "empty", sql: "CREATE TABLE empty(k TEXT)"},
+		{name: "nr", sql: "CREATE TABLE nr(k TEXT PRIMARY KEY) WITHOUT ROWID"},
+	}, map[string]uint32{"items": root})
+
+	db, err := OpenSQLite(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	items := db.Table("ITEMS")
+	if !assert.NotNil(t, items) {
+		return
+	}
+	var rows []map[string]interface{}
+	assert.NoError(t, items.Rows(func(rowid int64, row map[string]interface{}) error {
+		assert.Equal(t, rowid, row["id"])
+		rows = append(rows, row)
+		return nil
+	}))
+	if assert.Len(t, rows, 4) {
+		// Столбец added добавлен после записи строк и отсутствует в них
+		assert.Equal(t, map[string]interface{}{
+			"id": int64(1), "name": "first", "n": int64(1), "f": 0.5, "b": []byte{1, 2}, "added": nil,
+		}, rows[0])
+		assert.Equal(t, long, rows[1]["name"])
+		assert.Equal(t, int64(-2), rows[1]["n"])
+		assert.Equal(t, int64(70000), rows[2]["n"])
+		assert.Equal(t, int64(-1<<40), rows[3]["n"])
+	}
+	assert.NoError(t, db.Table("empty").Rows(func(int64, map[string]interface{}) error {
+		t.Error("unexpected row")
+		return nil
+	}))
+	assert.Error(t, db.Table("nr").Rows(func(int64, map[string]interface{}) error { return nil }))
+
+	_, err = OpenSQLite([]byte("SQLite format 2"))
+	assert.Error(t, err)
+
+	// Внутренняя страница, ссылающаяся сама на себя
+	loop := b.page(SQLITE_INTERIOR_PAGE, 0, nil, root)
+	copy(data[int(root-1)*1024:], loop)
+	db, err = OpenSQLite(data)
+	if assert.NoError(t, err) {
+		assert.Error(t, db.Table("items").Rows(func(int64, map[string]interface{}) error { return nil }))
+	}
+
+	// Тип значения с длиной около 2^63 байт не должен переполнять смещение
+	payload := []byte("\x0A" + strings.Repeat("\xFF", 9) + "abc")
+	row := (&SQLiteTable{Columns: []string{"k"}, rowid: -1}).record(1, payload)
+	assert.Equal(t, map[string]interface{}{"k": nil}, row)
+}
+
+func TestApplySQLiteWAL(t *testing.T) {
+	table := func(rows ...[]interface{}) []byte {
+		return buildTestSQLiteDB(testSQLiteTable{name: "t", sql: "CREATE TABLE t(id INTEGER PRIMARY KEY, v TEXT)", rows: rows})
+	}
+	old := table([]interface{}{nil, "a"})
+	updated := table([]interface{}{nil, "a"}, []interface{}{nil, "b"})
+	page2 := updated[4096:8192]
+
+	// Кадр незавершённой транзакции после кадра фиксации не применяется
+	wal := testSQLiteWAL(4096,
+		testSQLiteWALFrame{page: 2, commit: 2, data: page2},
+		testSQLiteWALFrame{page: 2, data: make([]byte, 4096)})
+	applied, frames, err := ApplySQLiteWAL(old, wal)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, frames)
+	assert.Equal(t, updated, applied)
+	assert.Equal(t, table([]interface{}{nil, "a"}), old)
+
+	// Кадр с неверной контрольной суммой и все следующие за ним отбрасываются
+	wal[SQLITE_WAL_HEADER_SIZE+SQLITE_WAL_FRAME_HEADER_SIZE+100] ^= 0xFF
+	applied, frames, err = ApplySQLiteWAL(old, wal)
+	assert.NoError(t, err)
+	assert.Zero(t, frames)
+	assert.Equal(t, old, applied)
+
+	// Размер базы после фиксации больше исходного файла и страниц журнала
+	_, _, err = ApplySQLiteWAL(old, testSQLiteWAL(4096, testSQLiteWALFrame{page: 2, commit: 1000, data: page2}))
+	assert.Error(t, err)
+	_, _, err = ApplySQLiteWAL(old, testSQLiteWAL(1024, testSQLiteWALFrame{page: 2, commit: 2, data: page2[:1024]}))
+	assert.Error(t, err)
+	_, _, err = ApplySQLiteWAL(old, []byte("not a journal, definitely not a journal"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SRUM (System Resource Usage Monitor, Windows 8+) ведёт почасовую статистику
+// использования сети и ресурсов приложениями в базе ESE
+// %SystemRoot%\System32\sru\SRUDB.dat. Приложения и пользователи в таблицах заданы
+// числовыми идентификаторами, которые раскрываются по таблице SruDbIdMapTable.
+const (
+	SRUM_MAX_SIZE  = 1 << 30
+	SRUM_DATABASE  = "SRUDB.dat"
+	SRUM_ID_MAP    = "SruDbIdMapTable"
+	SRUM_ID_SID    = 3
+	SRUM_TIMESTAMP = "TimeStamp"
+)
+
+// srumTable — таблица SRUM, выгружаемая разборщиком.
+type srumTable struct {
+	guid     string
+	name     string
+	category string
+	// filetimes — столбцы LongLong, содержащие FILETIME
+	filetimes []string
+}
+
+var srumTables = []srumTable{
+	{guid: "{973F5D5C-1D90-4944-BE8E-24B94231A174}", name: "network_usage", category: "network"},
+	{guid: "{D10CA2FE-6FCF-4F6D-848E-B2E99266FA89}", name: "application_resource_usage", category: "process"},
+	{guid: "{DD6636C4-8929-4683-974E-22C046A43763}", name: "network_connectivity", category: "network",
+		filetimes: []string{"ConnectStartTime"}},
+}
+
+// snakeCase переводит имя столбца из CamelCase в snake_case: L2ProfileId → l2_profile_id.
+func snakeCase(name string) string {
+	r := []rune(name)
+	var b strings.Builder
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) {
+			prev := r[i-1]
+			nextLower := i+1 < len(r) && unicode.IsLower(r[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
+
+// ReadSRUMIdMap читает SruDbIdMapTable: SID для записей типа 3, строку UTF-16 (путь
+// приложения, имя службы) для остальных.
+func ReadSRUMIdMap(db *ESEDatabase) (map[int32]string, error) {
+	ids := make(map[int32]string)
+	table := db.Table(SRUM_ID_MAP)
+	if table == nil {
+		return ids, fmt.Errorf("SRUM: %s not found", SRUM_ID_MAP)
+	}
+	err := table.Records(func(rec map[string]interface{}) error {
+		index, ok := rec["IdIndex"].(int32)
+		blob, _ := rec["IdBlob"].([]byte)
+		if !ok || len(blob) == 0 {
+			return nil
+		}
+		if typ, _ := rec["IdType"].(uint8); typ == SRUM_ID_SID {
+			if sid := formatSID(blob); sid != "" {
+				ids[index] = sid
+				return nil
+			}
+		}
+		ids[index] = decodeUTF16(blob)
+		return nil
+	})
+	return ids, err
+}
+
+// srumRecord формирует запись JSONL из строки таблицы SRUM: время в @timestamp,
+// раскрытые приложение и пользователь, значения столбцов в snake_case.
+func srumRecord(t srumTable, rec map[string]interface{}, ids map[int32]string, path string) map[string]interface{} {
+	srum := map[string]interface{}{"table": t.name, "table_guid": t.guid}
+	record := map[string]interface{}{
+		"event": map[string]interface{}{"kind": "event", "category": t.category, "action": "srum-" + strings.ReplaceAll(t.name, "_", "-")},
+		"srum":  srum,
+		"log":   map[string]interface{}{"file": map[string]interface{}{"path": path}},
+	}
+	for name, value := range rec {
+		switch v := value.(type) {
+		case time.Time:
+			value = formatTime(v)
+			if name == SRUM_TIMESTAMP {
+				record["@timestamp"] = value
+			}
+		case int64:
+			if containsString(t.filetimes, name) {
+				value = formatTime(filetimeToTime(uint64(v)))
+			}
+		case []byte:
+			value = hex.EncodeToString(v)
+		}
+		srum[snakeCase(name)] = value
+	}
+	if id, ok := rec["AppId"].(int32); ok {
+		if app, ok := ids[id]; ok {
+			srum["app"] = app
+		}
+	}
+	if id, ok := rec["UserId"].(int32); ok {
+		if user, ok := ids[id]; ok {
+			srum["user"] = user
+		}
+	}
+	return record
+}
+
+// ReadSRUM читает таблицы SRUM из базы и передаёт записи в emit. Базы, снятые с
+// работающей системы без завершения транзакций (dirty shutdown), читаются как есть:
+// данные, не перенесённые из журналов транзакций, в выгрузку не попадают.
+func ReadSRUM(data []byte, path string, emit func(record map[string]interface{}) error) error {
+	db, err := OpenESE(data)
+	if err != nil {
+		return err
+	}
+	ids, err := ReadSRUMIdMap(db)
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Failed to read SRUM id map of %s: %v", path, err))
+	}
+	for _, t := range srumTables {
+		table := db.Table(t.guid)
+		if table == nil {
+			continue
+		}
+		err := table.Records(func(rec map[string]interface{}) error {
+			return emit(srumRecord(t, rec, ids, path))
+		})
+		if err != nil {
+			return fmt.Errorf("SRUM %s: %w", t.name, err)
+		}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------
+// srumParser – разбор базы SRUM как ArtifactParser
+// ----------------------------------------------------------------------
+
+type srumParser struct{}
+
+func newSRUMParser(opts parserOptions) (ArtifactParser, error) {
+	return &srumParser{}, nil
+}
+
+func (p *srumParser) Name() string { return PARSER_SRUM }
+
+func (p *srumParser) Match(path string) bool {
+	return strings.EqualFold(filepath.Base(strings.ReplaceAll(path, `\`, "/")), SRUM_DATABASE)
+}
+
+func (p *srumParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	data, err := io.ReadAll(io.LimitReader(r, SRUM_MAX_SIZE+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > SRUM_MAX_SIZE {
+		return fmt.Errorf("SRUM database larger than %d bytes", SRUM_MAX_SIZE)
+	}
+	return ReadSRUM(data, path, emit)
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// SWEEP_COMMAND — подкоманда поиска индикаторов компрометации на хосте без сбора артефактов.
+const SWEEP_COMMAND = "sweep"
+
+// Коды завершения подкоманды sweep: по ним оркестрация отличает чистые хосты от заражённых.
+const (
+	SWEEP_EXIT_NOT_FOUND = 0
+	SWEEP_EXIT_FOUND     = 1
+	SWEEP_EXIT_ERROR     = 2
+)
+
+// Типы индикаторов в файле индикаторов.
+const (
+	IOC_HASH     = "hash"
+	IOC_FILENAME = "filename"
+	IOC_PATH     = "path"
+	IOC_CONTENT  = "content"
+	IOC_REGISTRY = "registry"
+	IOC_PROCESS  = "process"
+)
+
+// registryHiveAliases — сокращённые имена кустов реестра, допустимые в индикаторах.
+var registryHiveAliases = map[string]string{
+	"HKLM": "HKEY_LOCAL_MACHINE",
+	"HKCU": "HKEY_CURRENT_USER",
+	"HKCR": "HKEY_CLASSES_ROOT",
+	"HKU":  "HKEY_USERS",
+	"HKCC": "HKEY_CURRENT_CONFIG",
+}
+
+// Indicator — один индикатор компрометации из файла индикаторов.
+type Indicator struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	Label string `json:"label,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// IndicatorSet — индикаторы, сгруппированные по способу поиска.
+type IndicatorSet struct {
+	hashes    map[string]*Indicator
+	filenames []*Indicator
+	paths     []*Indicator
+	contents  []*Indicator
+	registry  []*Indicator
+	processes []*Indicator
+}
+
+// Len возвращает общее число индикаторов.
+func (s *IndicatorSet) Len() int {
+	return len(s.hashes) + len(s.filenames) + len(s.paths) + len(s.contents) + len(s.registry) + len(s.processes)
+}
+
+// hasFileIndicators сообщает, требуется ли обход файловой системы.
+func (s *IndicatorSet) hasFileIndicators() bool {
+	return len(s.hashes) > 0 || len(s.filenames) > 0 || len(s.paths) > 0 || len(s.contents) > 0
+}
+
+// LoadIndicators читает файл индикаторов.
+func LoadIndicators(path string) (*IndicatorSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	set, err := ParseIndicators(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return set, nil
+}
+
+// ParseIndicators разбирает индикаторы: по одному на строку в виде "тип:значение",
+// необязательная метка отделяется табуляцией, строки с # — комментарии. Типы:
+// md5, sha1, sha256 и hash — хеш содержимого; filename — имя файла (шаблон, без учёта регистра);
+// path — шаблон пути относительно корня поиска или абсолютный (с поддержкой **);
+// content — регулярное выражение по содержимому; registry — ключ реестра или
+// значение в виде "ключ|имя значения"; process — имя запущенного процесса (шаблон).
+func ParseIndicators(r io.Reader) (*IndicatorSet, error) {
+	set := &IndicatorSet{hashes: make(map[string]*Indicator)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		label := ""
+		if idx := strings.Index(line, "\t"); idx >= 0 {
+			line, label = line[:idx], strings.TrimSpace(line[idx+1:])
+		}
+		kind, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		kind = strings.ToLower(strings.TrimSpace(kind))
+		value = strings.TrimSpace(value)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("line %d: expected \"type:value\"", lineNo)
+		}
+		ind := &Indicator{Type: kind, Value: value, Label: label}
+		switch kind {
+		case "md5", "sha1", "sha256", IOC_HASH:
+			value = strings.ToLower(value)
+			if _, err := hex.DecodeString(value); err != nil || !validIndicatorHash(kind, len(value)) {
+				return nil, fmt.Errorf("line %d: invalid %s hash %q", lineNo, kind, ind.Value)
+			}
+			ind.Type, ind.Value = IOC_HASH, value
+			set.hashes[value] = ind
+		case IOC_FILENAME:
+			if _, err := filepath.Match(strings.ToLower(value), ""); err != nil {
+				return nil, fmt.Errorf("line %d: invalid filename pattern %q: %v", lineNo, value, err)
+			}
+			set.filenames = append(set.filenames, ind)
+		case IOC_PATH:
+			ind.Value = filepath.ToSlash(value)
+			set.paths = append(set.paths, ind)
+		case IOC_CONTENT:
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid content regex: %v", lineNo, err)
+			}
+			ind.re = re
+			set.contents = append(set.contents, ind)
+		case IOC_REGISTRY:
+			ind.Value = normalizeRegistryIndicator(value)
+			set.registry = append(set.registry, ind)
+		case IOC_PROCESS:
+			if _, err := filepath.Match(strings.ToLower(value), ""); err != nil {
+				return nil, fmt.Errorf("line %d: invalid process pattern %q: %v", lineNo, value, err)
+			}
+			set.processes = append(set.processes, ind)
+		default:
+			return nil, fmt.Errorf("line %d: unknown indicator type %q", lineNo, kind)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// validIndicatorHash проверяет длину шестнадцатеричного хеша для указанного типа индикатора.
+func validIndicatorHash(kind string, length int) bool {
+	if kind == IOC_HASH {
+		return length == 32 || length == 40 || length == 64
+	}
+	return length == 2*hashLength(kind)
+}
+
+// normalizeRegistryIndicator приводит путь к ключу реестра к виду "HKEY_...\подключ":
+// разворачивает сокращённые имена кустов и заменяет прямые слэши обратными.
+func normalizeRegistryIndicator(value string) string {
+	key, name, hasValue := strings.Cut(value, "|")
+	key = strings.Trim(strings.ReplaceAll(key, "/", `\`), `\`)
+	hive, sub, _ := strings.Cut(key, `\`)
+	if full, ok := registryHiveAliases[strings.ToUpper(hive)]; ok {
+		hive = full
+	} else {
+		hive = strings.ToUpper(hive)
+	}
+	key = hive
+	if sub != "" {
+		key += `\` + sub
+	}
+	if hasValue {
+		return key + "|" + name
+	}
+	return key
+}
+
+// matchName сравнивает имя с шаблоном индикатора без учёта регистра.
+func matchName(pattern, name string) bool {
+	ok, _ := filepath.Match(strings.ToLower(pattern), strings.ToLower(name))
+	return ok
+}
+
+// sweeper ищет индикаторы на хосте и пишет найденные совпадения в out.
+type sweeper struct {
+	ioc      *IndicatorSet
+	maxSize  int64
+	hostname string
+	enc      *json.Encoder
+	hits     int
+}
+
+// runSweep выполняет подкоманду sweep: fast_dfar sweep -indicators <файл> [-output файл] [<корень>...]
+// Файловые индикаторы ищутся в указанных корнях, индикаторы реестра и процессов — на текущем хосте.
+// В вывод попадают только совпадения с хешами и метаданными; код завершения показывает,
+// найдено ли что-либо (SWEEP_EXIT_FOUND) или нет (SWEEP_EXIT_NOT_FOUND).
+func runSweep(args []string) int {
+	fset := flag.NewFlagSet(SWEEP_COMMAND, flag.ContinueOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Использование: %s %s -indicators <файл> [флаги] [<корень поиска>...]\n", filepath.Base(os.Args[0]), SWEEP_COMMAND)
+		fmt.Fprintf(fset.Output(), "Коды завершения: %d — совпадений нет, %d — найдены совпадения, %d — ошибка\n",
+			SWEEP_EXIT_NOT_FOUND, SWEEP_EXIT_FOUND, SWEEP_EXIT_ERROR)
+		fset.PrintDefaults()
+	}
+	indicatorsPath := fset.String("indicators", "", "Файл индикаторов (хеши, имена файлов, шаблоны путей, регулярные выражения, ключи реестра, имена процессов)")
+	outputPath := fset.String("output", "", "Файл для найденных совпадений в формате JSONL (по умолчанию стандартный вывод)")
+	maxSizeStr := fset.String("maxsize", "", "Максимальный размер файла для вычисления хешей и поиска по содержимому")
+	if err := fset.Parse(args); err != nil {
+		return SWEEP_EXIT_ERROR
+	}
+	if *indicatorsPath == "" {
+		fset.Usage()
+		return SWEEP_EXIT_ERROR
+	}
+
+	// Стандартный вывод может быть занят результатами — журнал пишется в stderr.
+	logger.SetOutput(os.Stderr)
+	ioc, err := LoadIndicators(*indicatorsPath)
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Не удалось загрузить индикаторы: %v", err))
+		return SWEEP_EXIT_ERROR
+	}
+	if ioc.hasFileIndicators() && fset.NArg() == 0 {
+		logger.Log(LevelCritical, "Для файловых индикаторов необходимо указать корни поиска")
+		return SWEEP_EXIT_ERROR
+	}
+	maxSize, err := parseHumanSize(*maxSizeStr)
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Неверный размер -maxsize: %v", err))
+		return SWEEP_EXIT_ERROR
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			logger.Log(LevelCritical, err.Error())
+			return SWEEP_EXIT_ERROR
+		}
+		defer f.Close()
+		out = f
+	}
+	s := newSweeper(ioc, maxSize, out)
+	if err := s.Run(fset.Args()); err != nil {
+		logger.Log(LevelCritical, err.Error())
+		return SWEEP_EXIT_ERROR
+	}
+	logger.Log(LevelInfo, fmt.Sprintf("Поиск по %d индикаторам завершён, совпадений: %d", ioc.Len(), s.hits))
+	if s.hits > 0 {
+		return SWEEP_EXIT_FOUND
+	}
+	return SWEEP_EXIT_NOT_FOUND
+}
+
+func newSweeper(ioc *IndicatorSet, maxSize int64, out io.Writer) *sweeper {
+	hostname, _ := os.Hostname()
+	return &sweeper{ioc: ioc, maxSize: maxSize, hostname: hostname, enc: json.NewEncoder(out)}
+}
+
+// Run ищет индикаторы в корнях поиска, реестре и списке процессов.
+func (s *sweeper) Run(roots []string) error {
+	for _, root := range roots {
+		if err := s.sweepRoot(root); err != nil {
+			return err
+		}
+	}
+	for _, ind := range s.ioc.registry {
+		if err := s.sweepRegistry(ind); err != nil {
+			return err
+		}
+	}
+	if len(s.ioc.processes) > 0 {
+		return s.sweepProcesses()
+	}
+	return nil
+}
+
+// sweepRoot обходит корень поиска генераторами путей той же файловой системы, что и при сборе:
+// "**-1" для проверки каждого файла и шаблоны индикаторов path.
+func (s *sweeper) sweepRoot(root string) error {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Skipping sweep root %s: %v", root, err))
+		return nil
+	}
+	if !info.IsDir() {
+		return s.checkFile(NewOSFileSystem(filepath.Dir(abs)).GetFullPath(abs))
+	}
+	fs := NewOSFileSystem(abs)
+	if len(s.ioc.hashes) > 0 || len(s.ioc.filenames) > 0 || len(s.ioc.contents) > 0 {
+		for po := range walkPattern(fs, "**-1") {
+			if err := s.checkFile(po); err != nil {
+				return err
+			}
+		}
+	}
+	for _, ind := range s.ioc.paths {
+		pattern := fs.relativePath(ind.Value)
+		// Абсолютный шаблон вне текущего корня проверяется только в своём корне.
+		if pattern == ind.Value && (filepath.IsAbs(filepath.FromSlash(pattern)) || strings.HasPrefix(pattern, "/")) {
+			continue
+		}
+		for po := range walkPattern(fs, pattern) {
+			hashes, _ := s.fileHashes(po)
+			if err := s.emitFile(po, ind, hashes, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkPattern разворачивает шаблон пути в последовательность файлов через цепочку GeneratorFunc.
+func walkPattern(fs FileSystem, pattern string) <-chan *PathObject {
+	gen := fs.baseGenerator()
+	for _, gf := range fs.parse(pattern) {
+		gen = gf(gen)
+	}
+	return gen
+}
+
+// checkFile проверяет файл индикаторами имени, хеша и содержимого.
+func (s *sweeper) checkFile(po *PathObject) error {
+	var hashes map[string]string
+	hashed := false
+	for _, ind := range s.ioc.filenames {
+		if !matchName(ind.Value, po.name) {
+			continue
+		}
+		if !hashed {
+			hashes, _ = s.fileHashes(po)
+			hashed = true
+		}
+		if err := s.emitFile(po, ind, hashes, nil); err != nil {
+			return err
+		}
+	}
+	if len(s.ioc.hashes) == 0 && len(s.ioc.contents) == 0 {
+		return nil
+	}
+	if !hashed {
+		var err error
+		if hashes, err = s.fileHashes(po); err != nil {
+			logger.Log(LevelDebug, fmt.Sprintf("Skipping %s: %v", po.GetPath(), err))
+			return nil
+		}
+	}
+	for _, kind := range []string{"sha256", "sha1", "md5"} {
+		if ind, ok := s.ioc.hashes[hashes[kind]]; ok {
+			if err := s.emitFile(po, ind, hashes, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if len(s.ioc.contents) == 0 || hashes == nil || po.GetSize() > MAX_RULES_SCAN_SIZE {
+		return nil
+	}
+	data, err := os.ReadFile(po.GetPath())
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Skipping content of %s: %v", po.GetPath(), err))
+		return nil
+	}
+	for _, ind := range s.ioc.contents {
+		loc := ind.re.FindIndex(data)
+		if loc == nil {
+			continue
+		}
+		end := loc[1]
+		if end-loc[0] > 256 {
+			end = loc[0] + 256
+		}
+		match := map[string]interface{}{"offset": loc[0], "data": escapeMatchData(data[loc[0]:end])}
+		if err := s.emitFile(po, ind, hashes, match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileHashes вычисляет хеши файла, если его размер не превышает ограничения.
+func (s *sweeper) fileHashes(po *PathObject) (map[string]string, error) {
+	if s.maxSize > 0 && po.GetSize() > s.maxSize {
+		return nil, fmt.Errorf("file exceeds maxsize (%d bytes)", s.maxSize)
+	}
+	f, err := os.Open(po.GetPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+// emitFile записывает совпадение файлового индикатора с хешами и метаданными файла.
+func (s *sweeper) emitFile(po *PathObject, ind *Indicator, hashes map[string]string, match map[string]interface{}) error {
+	file := map[string]interface{}{
+		"path": po.GetPath(),
+		"name": po.name,
+		"size": po.GetSize(),
+	}
+	if hashes != nil {
+		file["hash"] = hashes
+	}
+	if meta, err := po.GetMetadata(); err == nil && meta != nil {
+		for k, v := range meta.AsDict() {
+			file[k] = v
+		}
+	}
+	record := map[string]interface{}{"file": file}
+	if match != nil {
+		record["match"] = match
+	}
+	return s.emit(ind, record)
+}
+
+// sweepRegistry проверяет наличие ключей или значения реестра; ключ может содержать шаблоны.
+func (s *sweeper) sweepRegistry(ind *Indicator) error {
+	key, name, _ := strings.Cut(ind.Value, "|")
+	found, err := lookupRegistryIndicator(key, name)
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Registry indicator %s not checked: %v", ind.Value, err))
+		return nil
+	}
+	for _, entry := range found {
+		if err := s.emit(ind, map[string]interface{}{"registry": entry}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepProcesses сопоставляет имена запущенных процессов и их исполняемых файлов с индикаторами.
+func (s *sweeper) sweepProcesses() error {
+	procs, err := process.Processes()
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Process list unavailable: %v", err))
+		return nil
+	}
+	for _, p := range procs {
+		name, _ := p.Name()
+		exe, _ := p.Exe()
+		for _, ind := range s.ioc.processes {
+			if !matchName(ind.Value, name) && (exe == "" || !matchName(ind.Value, filepath.Base(exe))) {
+				continue
+			}
+			proc := map[string]interface{}{"pid": p.Pid, "name": name}
+			record := map[string]interface{}{"process": proc}
+			if cmdline, err := p.Cmdline(); err == nil && cmdline != "" {
+				proc["command_line"] = cmdline
+			}
+			if exe != "" {
+				proc["executable"] = exe
+				fs := NewOSFileSystem(filepath.Dir(exe))
+				po := fs.GetFullPath(exe)
+				file := map[string]interface{}{"path": exe}
+				if hashes, err := s.fileHashes(po); err == nil {
+					file["hash"] = hashes
+				}
+				record["file"] = file
+			}
+			if err := s.emit(ind, record); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// emit дополняет запись совпадения временем, хостом и индикатором и записывает её.
+func (s *sweeper) emit(ind *Indicator, record map[string]interface{}) error {
+	record["@timestamp"] = time.Now().UTC().Format(time.RFC3339)
+	record["host"] = map[string]string{"hostname": s.hostname}
+	record["indicator"] = ind
+	s.hits++
+	return s.enc.Encode(record)
+}
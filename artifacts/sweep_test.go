@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIndicators(t *testing.T) {
+	set, err := ParseIndicators(strings.NewReader(strings.Join([]string{
+		"# индикаторы вспышки",
+		"MD5:D41D8CD98F00B204E9800998ECF8427E\tempty file",
+		"filename:*.locked",
+		`path:Users/*/AppData/Local/Temp/**/svc*.exe`,
+		"content:(?i)ransom note",
+		`registry:HKLM\Software\Microsoft\Windows\CurrentVersion\Run|updater`,
+		"process:evil*.exe",
+		"",
+	}, "\n")))
+	assert.NoError(t, err)
+	assert.Equal(t, 6, set.Len())
+	ind := set.hashes["d41d8cd98f00b204e9800998ecf8427e"]
+	if assert.NotNil(t, ind) {
+		assert.Equal(t, IOC_HASH, ind.Type)
+		assert.Equal(t, "empty file", ind.Label)
+	}
+	assert.Equal(t, `HKEY_LOCAL_MACHINE\Software\Microsoft\Windows\CurrentVersion\Run|updater`, set.registry[0].Value)
+	assert.True(t, set.hasFileIndicators())
+
+	for _, bad := range []string{"sha1:abcd", "hash:zz", "content:(", "mutex:Global\\x", "filename:[", "no separator"} {
+		_, err := ParseIndicators(strings.NewReader(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	payload := []byte("MZ dropper with Ransom Note inside")
+	sum := md5.Sum(payload)
+	files := map[string][]byte{
+		"clean.txt":                  []byte("nothing here"),
+		"docs/report.pdf.locked":     []byte("encrypted"),
+		"tmp/cache/svchost.bin":      payload,
+		"tmp/cache/deep/updater.exe": []byte("second stage"),
+		"tmp/cache/deep/readme.txt":  []byte("harmless"),
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := ParseIndicators(strings.NewReader(strings.Join([]string{
+		"md5:" + hex.EncodeToString(sum[:]) + "\tdropper",
+		"filename:*.LOCKED",
+		"path:tmp/**/*.exe",
+		"path:" + filepath.ToSlash(filepath.Join(dir, "tmp", "cache", "deep", "readme.txt")),
+		"path:/nonexistent/elsewhere/*.exe",
+		"content:(?i)ransom note",
+		"process:" + filepath.Base(exe),
+	}, "\n")))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	s := newSweeper(set, 0, &out)
+	assert.NoError(t, s.Run([]string{dir}))
+
+	type hit struct {
+		Indicator Indicator              `json:"indicator"`
+		File      map[string]interface{} `json:"file"`
+		Match     map[string]interface{} `json:"match"`
+		Process   map[string]interface{} `json:"process"`
+	}
+	byType := map[string][]hit{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var h hit
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &h))
+		byType[h.Indicator.Type] = append(byType[h.Indicator.Type], h)
+	}
+	assert.Equal(t, s.hits, len(byType[IOC_HASH])+len(byType[IOC_FILENAME])+len(byType[IOC_PATH])+
+		len(byType[IOC_CONTENT])+len(byType[IOC_PROCESS]))
+
+	if assert.Len(t, byType[IOC_HASH], 1) {
+		h := byType[IOC_HASH][0]
+		assert.Equal(t, "dropper", h.Indicator.Label)
+		assert.Equal(t, filepath.Join(dir, "tmp", "cache", "svchost.bin"), h.File["path"])
+		assert.Equal(t, hex.EncodeToString(sum[:]), h.File["hash"].(map[string]interface{})["md5"])
+		assert.NotEmpty(t, h.File["mtime"])
+	}
+	if assert.Len(t, byType[IOC_FILENAME], 1) {
+		assert.Equal(t, "report.pdf.locked", byType[IOC_FILENAME][0].File["name"])
+	}
+	var paths []string
+	for _, h := range byType[IOC_PATH] {
+		paths = append(paths, h.File["path"].(string))
+		assert.NotEmpty(t, h.File["hash"])
+	}
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "tmp", "cache", "deep", "updater.exe"),
+		filepath.Join(dir, "tmp", "cache", "deep", "readme.txt"),
+	}, paths)
+	if assert.Len(t, byType[IOC_CONTENT], 1) {
+		assert.Equal(t, "Ransom Note", byType[IOC_CONTENT][0].Match["data"])
+		assert.Equal(t, float64(bytes.Index(payload, []byte("Ransom"))), byType[IOC_CONTENT][0].Match["offset"])
+	}
+	if assert.NotEmpty(t, byType[IOC_PROCESS]) {
+		assert.Equal(t, float64(os.Getpid()), byType[IOC_PROCESS][0].Process["pid"])
+	}
+
+	// Без совпадений ничего не выводится
+	clean, err := ParseIndicators(strings.NewReader("filename:absent.dll\nmd5:00000000000000000000000000000000"))
+	assert.NoError(t, err)
+	out.Reset()
+	s = newSweeper(clean, 0, &out)
+	assert.NoError(t, s.Run([]string{dir}))
+	assert.Zero(t, s.hits)
+	assert.Zero(t, out.Len())
+}
+
+func TestRunSweepExitCodes(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "evil.dll"), []byte("x"), 0644))
+	found := filepath.Join(dir, "found.txt")
+	absent := filepath.Join(dir, "absent.txt")
+	assert.NoError(t, os.WriteFile(found, []byte("filename:evil.dll\n"), 0644))
+	assert.NoError(t, os.WriteFile(absent, []byte("filename:good.dll\n"), 0644))
+	defer logger.SetOutput(os.Stdout)
+
+	results := filepath.Join(dir, "hits.jsonl")
+	assert.Equal(t, SWEEP_EXIT_FOUND, runSweep([]string{"-indicators", found, "-output", results, dir}))
+	data, err := os.ReadFile(results)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")))
+
+	assert.Equal(t, SWEEP_EXIT_NOT_FOUND, runSweep([]string{"-indicators", absent, "-output", results, dir}))
+	assert.Equal(t, SWEEP_EXIT_ERROR, runSweep([]string{"-indicators", found}))
+	assert.Equal(t, SWEEP_EXIT_ERROR, runSweep([]string{"-indicators", filepath.Join(dir, "missing.txt"), dir}))
+}
@@ -0,0 +1,702 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TIMELINE_COMMAND — подкоманда построения сводной временной шкалы по результатам сбора.
+const TIMELINE_COMMAND = "timeline"
+
+// Форматы выгрузки временной шкалы.
+const (
+	TIMELINE_FORMAT_BODY  = "body"
+	TIMELINE_FORMAT_JSONL = "jsonl"
+	TIMELINE_FORMAT_CSV   = "csv"
+)
+
+// Источники событий временной шкалы.
+const (
+	TIMELINE_SOURCE_FILE     = "FILE"
+	TIMELINE_SOURCE_LOG      = "LOG"
+	TIMELINE_SOURCE_REGISTRY = "REG"
+	TIMELINE_SOURCE_COMMAND  = "CMD"
+	TIMELINE_SOURCE_WMI      = "WMI"
+	TIMELINE_SOURCE_USN      = "USN"
+)
+
+// MAX_TIMELINE_MESSAGE ограничивает длину сообщения события (строки журнала, вывода команды).
+const MAX_TIMELINE_MESSAGE = 1024
+
+// timelineMACB — поля временных меток file_info в порядке MACB и их описания.
+var timelineMACB = []struct {
+	field string
+	flag  byte
+	desc  string
+}{
+	{"mtime", 'm', "Modification Time"},
+	{"accessed", 'a', "Last Access Time"},
+	{"ctime", 'c', "Metadata Change Time"},
+	{"created", 'b', "Creation Time"},
+}
+
+// TimelineEvent — событие сводной временной шкалы.
+type TimelineEvent struct {
+	Time     time.Time
+	Source   string
+	MACB     string
+	Desc     string
+	Artifact string
+	Path     string
+	Message  string
+
+	// Поля bodyfile для файловых событий.
+	MD5  string
+	Mode string
+	UID  int
+	GID  int
+	Size int64
+}
+
+// timelineOptions задаёт параметры построения шкалы.
+type timelineOptions struct {
+	// Location — часовой пояс меток без смещения (syslog, "2006-01-02 15:04:05").
+	Location *time.Location
+	// Output — часовой пояс дат в JSONL и CSV.
+	Output *time.Location
+	// Logs — разбирать ли журналы из хранилища собранных файлов.
+	Logs bool
+}
+
+// runTimeline выполняет подкоманду timeline: fast_dfar timeline [флаги] <каталог результатов>.
+// Временные метки файлов (MACB), строк журналов, последней записи ключей реестра и выполнения
+// команд объединяются в один упорядоченный поток и выгружаются в форматах mactime bodyfile,
+// JSONL для Timesketch и CSV. Возвращает код завершения.
+func runTimeline(args []string) int {
+	fset := flag.NewFlagSet(TIMELINE_COMMAND, flag.ContinueOnError)
+	fset.Usage = func() {
+		fmt.Fprintf(fset.Output(), "Использование: %s %s [флаги] <каталог результатов>\n", filepath.Base(os.Args[0]), TIMELINE_COMMAND)
+		fset.PrintDefaults()
+	}
+	formats := fset.String("format", strings.Join([]string{TIMELINE_FORMAT_BODY, TIMELINE_FORMAT_JSONL, TIMELINE_FORMAT_CSV}, ","),
+		"Форматы выгрузки через запятую: body (mactime), jsonl (Timesketch), csv")
+	outDir := fset.String("output", "", "Каталог для файлов временной шкалы (по умолчанию каталог результатов)")
+	tz := fset.String("timezone", "UTC", "Часовой пояс меток без смещения в журналах (например, Europe/Moscow)")
+	outTZ := fset.String("output-timezone", "UTC", "Часовой пояс дат в JSONL и CSV")
+	noLogs := fset.Bool("no-logs", false, "Не разбирать журналы из хранилища собранных файлов")
+	if err := fset.Parse(args); err != nil {
+		return 2
+	}
+	if fset.NArg() != 1 {
+		fset.Usage()
+		return 2
+	}
+	dir := fset.Arg(0)
+
+	opts := timelineOptions{Logs: !*noLogs}
+	var err error
+	if opts.Location, err = time.LoadLocation(*tz); err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Неверный часовой пояс -timezone: %v", err))
+		return 2
+	}
+	if opts.Output, err = time.LoadLocation(*outTZ); err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Неверный часовой пояс -output-timezone: %v", err))
+		return 2
+	}
+	writers := map[string]func(io.Writer, []*TimelineEvent, *time.Location) error{
+		TIMELINE_FORMAT_BODY:  writeBodyfile,
+		TIMELINE_FORMAT_JSONL: writeTimesketchJSONL,
+		TIMELINE_FORMAT_CSV:   writeTimelineCSV,
+	}
+	selected := splitArgs(*formats)
+	for _, f := range selected {
+		if writers[f] == nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Неизвестный формат временной шкалы: %s", f))
+			return 2
+		}
+	}
+
+	hostname, err := findOutputHostname(dir)
+	if err != nil {
+		logger.Log(LevelCritical, err.Error())
+		return 1
+	}
+	events, err := buildTimeline(dir, hostname, opts)
+	if err != nil {
+		logger.Log(LevelCritical, fmt.Sprintf("Не удалось построить временную шкалу: %v", err))
+		return 1
+	}
+	if *outDir == "" {
+		*outDir = dir
+	}
+	for _, format := range selected {
+		path := filepath.Join(*outDir, fmt.Sprintf("%s-timeline.%s", hostname, format))
+		if err := writeTimelineFile(path, events, opts.Output, writers[format]); err != nil {
+			logger.Log(LevelCritical, fmt.Sprintf("Не удалось записать %s: %v", path, err))
+			return 1
+		}
+		logger.Log(LevelInfo, fmt.Sprintf("Временная шкала записана в %s", path))
+	}
+	logger.Log(LevelInfo, fmt.Sprintf("Событий во временной шкале: %d", len(events)))
+	return 0
+}
+
+func writeTimelineFile(path string, events []*TimelineEvent, loc *time.Location, write func(io.Writer, []*TimelineEvent, *time.Location) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	if err := write(w, events, loc); err != nil {
+		f.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// buildTimeline собирает события из file_info, registry, commands, wmi и записей разборщиков
+// mft и usn каталога результатов и, если включено, из журналов в хранилище собранных файлов.
+// События упорядочены по времени.
+func buildTimeline(dir, hostname string, opts timelineOptions) ([]*TimelineEvent, error) {
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	var events []*TimelineEvent
+	logFiles := make(map[string]*timelineLogFile)
+
+	err := readJSONL(filepath.Join(dir, fmt.Sprintf("%s-file_info.jsonl", hostname)), func(line []byte) error {
+		var rec struct {
+			Timestamp string                 `json:"@timestamp"`
+			File      map[string]interface{} `json:"file"`
+			Labels    map[string]string      `json:"labels"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		events = append(events, fileTimelineEvents(rec.File, rec.Labels["artifact"])...)
+		if path := stringField(rec.File, "path"); opts.Logs && isTimelineLog(path) {
+			lf := &timelineLogFile{path: path, artifact: rec.Labels["artifact"]}
+			// Опорное время для меток без года — время изменения файла или время сбора
+			if t, err := time.Parse(time.RFC3339Nano, stringField(rec.File, "mtime")); err == nil {
+				lf.reference = t
+			} else if t, err := time.Parse(time.RFC3339, rec.Timestamp); err == nil {
+				lf.reference = t
+			}
+			logFiles[archiveKey(path)] = lf
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := streamTimelineEvents(filepath.Join(dir, fmt.Sprintf("%s-registry.jsonl", hostname)), &events, registryTimelineEvent()); err != nil {
+		return nil, err
+	}
+	if err := streamTimelineEvents(filepath.Join(dir, fmt.Sprintf("%s-commands.jsonl", hostname)), &events, commandTimelineEvent(TIMELINE_SOURCE_COMMAND)); err != nil {
+		return nil, err
+	}
+	if err := streamTimelineEvents(filepath.Join(dir, fmt.Sprintf("%s-wmi.jsonl", hostname)), &events, commandTimelineEvent(TIMELINE_SOURCE_WMI)); err != nil {
+		return nil, err
+	}
+	if err := readJSONL(filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", hostname, PARSER_MFT)), mftTimelineEvents(&events)); err != nil {
+		return nil, err
+	}
+	if err := readJSONL(filepath.Join(dir, fmt.Sprintf("%s-%s.jsonl", hostname, PARSER_USN)), usnTimelineEvents(&events)); err != nil {
+		return nil, err
+	}
+
+	if opts.Logs {
+		archive, err := openArchiveReader(dir, hostname)
+		if err != nil {
+			return nil, err
+		}
+		if archive != nil {
+			collected := collectionTime(dir)
+			err = archive.Walk(func(name string, size int64, r io.Reader) error {
+				if !isTimelineLog(name) {
+					return nil
+				}
+				lf := logFiles[archiveKey(name)]
+				if lf == nil {
+					// Файл собран без записи file_info — используем путь хранилища и время сбора
+					lf = &timelineLogFile{path: name, reference: collected}
+				}
+				logEvents, err := parseTimelineLog(lf, r, opts.Location)
+				if err != nil {
+					logger.Log(LevelWarning, fmt.Sprintf("Failed to parse log %s: %v", lf.path, err))
+				}
+				events = append(events, logEvents...)
+				return nil
+			})
+			archive.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Time.Equal(events[j].Time) {
+			return events[i].Time.Before(events[j].Time)
+		}
+		if events[i].Source != events[j].Source {
+			return events[i].Source < events[j].Source
+		}
+		return events[i].Path < events[j].Path
+	})
+	return events, nil
+}
+
+// stringField возвращает строковое поле записи JSON или пустую строку.
+func stringField(m map[string]interface{}, key string) string {
+	s, _ := m[key].(string)
+	return s
+}
+
+// fileTimelineEvents строит события по временным меткам MACB записи file_info.
+// Совпадающие метки одного файла объединяются в одно событие, как в mactime.
+func fileTimelineEvents(file map[string]interface{}, artifact string) []*TimelineEvent {
+	path := stringField(file, "path")
+	if path == "" {
+		return nil
+	}
+	proto := TimelineEvent{Source: TIMELINE_SOURCE_FILE, Artifact: artifact, Path: path, Mode: stringField(file, "mode"), UID: -1, GID: -1}
+	if hashes, ok := file["hash"].(map[string]interface{}); ok {
+		proto.MD5, _ = hashes["md5"].(string)
+	}
+	if v, ok := file["size"].(float64); ok {
+		proto.Size = int64(v)
+	}
+	if v, ok := file["uid"].(float64); ok {
+		proto.UID = int(v)
+	}
+	if v, ok := file["gid"].(float64); ok {
+		proto.GID = int(v)
+	}
+
+	var events []*TimelineEvent
+	byTime := make(map[int64]*TimelineEvent)
+	for i, m := range timelineMACB {
+		t, err := time.Parse(time.RFC3339Nano, stringField(file, m.field))
+		if err != nil {
+			continue
+		}
+		ev := byTime[t.UnixNano()]
+		if ev == nil {
+			ev = &TimelineEvent{}
+			*ev = proto
+			ev.Time = t.UTC()
+			ev.MACB = "...."
+			byTime[t.UnixNano()] = ev
+			events = append(events, ev)
+		}
+		macb := []byte(ev.MACB)
+		macb[i] = m.flag
+		ev.MACB = string(macb)
+		if ev.Desc != "" {
+			ev.Desc += "; "
+		}
+		ev.Desc += m.desc
+	}
+	for _, ev := range events {
+		ev.Message = fmt.Sprintf("%s [%s]", path, ev.MACB)
+	}
+	return events
+}
+
+// streamTimelineEvents читает потоковый JSONL (registry, commands, wmi) и добавляет события,
+// построенные convert. Отсутствующий файл пропускается.
+func streamTimelineEvents(path string, events *[]*TimelineEvent, convert func(rec *streamRecord) *TimelineEvent) error {
+	return readJSONL(path, func(line []byte) error {
+		var rec streamRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if ev := convert(&rec); ev != nil {
+			*events = append(*events, ev)
+		}
+		return nil
+	})
+}
+
+// registryTimelineEvent строит событие последней записи ключа реестра. Значения одного ключа
+// имеют общее время последней записи, поэтому событие строится для первого из них.
+func registryTimelineEvent() func(rec *streamRecord) *TimelineEvent {
+	seen := make(map[string]bool)
+	return func(rec *streamRecord) *TimelineEvent {
+		var value struct {
+			Name      string `json:"name"`
+			LastWrite string `json:"last_write"`
+		}
+		if err := json.Unmarshal(rec.Payload, &value); err != nil || value.LastWrite == "" {
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339Nano, value.LastWrite)
+		if err != nil || seen[rec.Source+"|"+value.LastWrite] {
+			return nil
+		}
+		seen[rec.Source+"|"+value.LastWrite] = true
+		return &TimelineEvent{
+			Time:     t.UTC(),
+			Source:   TIMELINE_SOURCE_REGISTRY,
+			MACB:     "m...",
+			Desc:     "Key Last Write Time",
+			Artifact: rec.Artifact,
+			Path:     rec.Source,
+			Message:  fmt.Sprintf("Registry key %s last written", rec.Source),
+			UID:      -1,
+			GID:      -1,
+		}
+	}
+}
+
+// mftTimelineEvents добавляет события по меткам $SI (как для file_info) и $FN записей
+// разборщика mft. Как в mactime, события $FN отмечаются в пути суффиксом ($FILE_NAME),
+// а записи удалённых файлов — суффиксом (deleted).
+func mftTimelineEvents(events *[]*TimelineEvent) func(line []byte) error {
+	return func(line []byte) error {
+		var rec struct {
+			File map[string]interface{} `json:"file"`
+			MFT  struct {
+				InUse    bool                   `json:"in_use"`
+				FileName map[string]interface{} `json:"file_name"`
+			} `json:"mft"`
+			Labels map[string]string `json:"labels"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		if rec.File == nil {
+			return nil
+		}
+		path := stringField(rec.File, "path")
+		if !rec.MFT.InUse {
+			path += " (deleted)"
+		}
+		rec.File["path"] = path
+		*events = append(*events, fileTimelineEvents(rec.File, rec.Labels["artifact"])...)
+		if fn := rec.MFT.FileName; fn != nil {
+			*events = append(*events, fileTimelineEvents(map[string]interface{}{
+				"path":     path + " ($FILE_NAME)",
+				"size":     rec.File["size"],
+				"mtime":    fn["modified"],
+				"accessed": fn["accessed"],
+				"ctime":    fn["changed"],
+				"created":  fn["created"],
+			}, rec.Labels["artifact"])...)
+		}
+		return nil
+	}
+}
+
+// usnTimelineEvents добавляет события записей журнала изменений NTFS; сообщение — имя
+// файла и причины изменения.
+func usnTimelineEvents(events *[]*TimelineEvent) func(line []byte) error {
+	return func(line []byte) error {
+		var rec struct {
+			Timestamp string `json:"@timestamp"`
+			File      struct {
+				Name string `json:"name"`
+			} `json:"file"`
+			USN struct {
+				Reason []string `json:"reason"`
+			} `json:"usn"`
+			Labels map[string]string `json:"labels"`
+		}
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return err
+		}
+		t, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+		if err != nil {
+			return nil
+		}
+		*events = append(*events, &TimelineEvent{
+			Time:     t.UTC(),
+			Source:   TIMELINE_SOURCE_USN,
+			MACB:     "....",
+			Desc:     "USN Journal Entry",
+			Artifact: rec.Labels["artifact"],
+			Path:     rec.File.Name,
+			Message:  fmt.Sprintf("%s: %s", rec.File.Name, strings.Join(rec.USN.Reason, "|")),
+			UID:      -1,
+			GID:      -1,
+		})
+		return nil
+	}
+}
+
+// commandTimelineEvent строит событие выполнения команды или WMI-запроса по времени получения
+// результата; сообщение — первая непустая строка вывода.
+func commandTimelineEvent(source string) func(rec *streamRecord) *TimelineEvent {
+	return func(rec *streamRecord) *TimelineEvent {
+		t, err := time.Parse(time.RFC3339Nano, rec.Timestamp)
+		if err != nil {
+			return nil
+		}
+		var output string
+		if json.Unmarshal(rec.Payload, &output) != nil {
+			output = string(rec.Payload)
+		}
+		message := rec.Source
+		for _, line := range strings.Split(output, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				message += ": " + line
+				break
+			}
+		}
+		return &TimelineEvent{
+			Time:     t.UTC(),
+			Source:   source,
+			MACB:     "....",
+			Desc:     "Execution Time",
+			Artifact: rec.Artifact,
+			Path:     rec.Source,
+			Message:  truncateTimelineMessage(message),
+			UID:      -1,
+			GID:      -1,
+		}
+	}
+}
+
+func truncateTimelineMessage(s string) string {
+	if len(s) > MAX_TIMELINE_MESSAGE {
+		return s[:MAX_TIMELINE_MESSAGE] + "..."
+	}
+	return s
+}
+
+// collectionTime определяет время сбора по имени каталога результатов "<время>-<хост>".
+func collectionTime(dir string) time.Time {
+	base := filepath.Base(filepath.Clean(dir))
+	if len(base) >= 14 {
+		if t, err := time.ParseInLocation("20060102150405", base[:14], time.Local); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// timelineLogFile — журнал из хранилища собранных файлов.
+type timelineLogFile struct {
+	path     string
+	artifact string
+	// reference — опорное время для меток syslog без года.
+	reference time.Time
+}
+
+// isTimelineLog определяет по пути, похож ли файл на текстовый журнал.
+func isTimelineLog(path string) bool {
+	p := strings.ToLower(strings.ReplaceAll(path, `\`, "/"))
+	base := p[strings.LastIndex(p, "/")+1:]
+	if strings.HasSuffix(base, ".journal") || strings.HasSuffix(base, ".evtx") {
+		return false
+	}
+	return strings.Contains(base, ".log") || strings.Contains("/"+p, "/var/log/")
+}
+
+var (
+	// 2024-01-02T03:04:05.123+03:00, 2024-01-02 03:04:05,123, 2024/01/02 03:04:05
+	logISOTimeRegex = regexp.MustCompile(`^\[?(\d{4})[-/](\d{2})[-/](\d{2})[T ](\d{2}:\d{2}:\d{2})([.,]\d{1,9})?\s?(Z|[+-]\d{2}:?\d{2}\b)?`)
+	// Jan  2 03:04:05 (syslog, без года и часового пояса)
+	logSyslogTimeRegex = regexp.MustCompile(`^([A-Z][a-z]{2}) +(\d{1,2}) (\d{2}:\d{2}:\d{2})`)
+	// [02/Jan/2024:03:04:05 +0300] (Common Log Format, Apache и nginx)
+	logCLFTimeRegex = regexp.MustCompile(`\[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`)
+)
+
+// parseLogTimestamp извлекает временную метку строки журнала. Метки без смещения
+// интерпретируются в loc; для меток syslog год берётся из reference.
+func parseLogTimestamp(line string, loc *time.Location, reference time.Time) (time.Time, bool) {
+	if m := logISOTimeRegex.FindStringSubmatch(line); m != nil {
+		value := fmt.Sprintf("%s-%s-%sT%s%s", m[1], m[2], m[3], m[4], strings.Replace(m[5], ",", ".", 1))
+		layout := "2006-01-02T15:04:05"
+		var t time.Time
+		var err error
+		switch zone := strings.Replace(m[6], ":", "", 1); zone {
+		case "":
+			t, err = time.ParseInLocation(layout, value, loc)
+		case "Z":
+			t, err = time.ParseInLocation(layout, value, time.UTC)
+		default:
+			t, err = time.Parse(layout+"-0700", value+zone)
+		}
+		return t, err == nil
+	}
+	if m := logSyslogTimeRegex.FindStringSubmatch(line); m != nil {
+		if reference.IsZero() {
+			reference = time.Now()
+		}
+		year := reference.In(loc).Year()
+		t, err := time.ParseInLocation("Jan 2 15:04:05 2006", fmt.Sprintf("%s %s %s %d", m[1], m[2], m[3], year), loc)
+		if err != nil {
+			return time.Time{}, false
+		}
+		// Запись не может быть позже опорного времени — значит, она из прошлого года
+		if t.After(reference.Add(24 * time.Hour)) {
+			t = t.AddDate(-1, 0, 0)
+		}
+		return t, true
+	}
+	if m := logCLFTimeRegex.FindStringSubmatch(line); m != nil {
+		t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+		return t, err == nil
+	}
+	return time.Time{}, false
+}
+
+// parseTimelineLog строит события по строкам журнала с распознанными временными метками.
+// Сжатые gzip журналы распаковываются, двоичные файлы пропускаются.
+func parseTimelineLog(lf *timelineLogFile, r io.Reader, loc *time.Location) ([]*TimelineEvent, error) {
+	br := bufio.NewReaderSize(r, 64*1024)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReaderSize(gz, 64*1024)
+	}
+	if head, _ := br.Peek(4096); bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var events []*TimelineEvent
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		t, ok := parseLogTimestamp(line, loc, lf.reference)
+		if !ok {
+			continue
+		}
+		events = append(events, &TimelineEvent{
+			Time:     t.UTC(),
+			Source:   TIMELINE_SOURCE_LOG,
+			MACB:     "....",
+			Desc:     "Log Entry Time",
+			Artifact: lf.artifact,
+			Path:     lf.path,
+			Message:  truncateTimelineMessage(line),
+			UID:      -1,
+			GID:      -1,
+		})
+	}
+	return events, scanner.Err()
+}
+
+// writeBodyfile выгружает события в формате bodyfile TSK 3.x
+// (MD5|name|inode|mode|UID|GID|size|atime|mtime|ctime|crtime) для mactime.
+// Файловые события заполняют метки по флагам MACB, остальные — только mtime;
+// символ "|" в именах заменяется на "¦".
+func writeBodyfile(w io.Writer, events []*TimelineEvent, _ *time.Location) error {
+	for _, ev := range events {
+		var times [4]int64
+		epoch := ev.Time.Unix()
+		if ev.Source == TIMELINE_SOURCE_FILE {
+			for i := range timelineMACB {
+				if ev.MACB[i] != '.' {
+					times[i] = epoch
+				}
+			}
+		} else {
+			times[0] = epoch
+		}
+		name := ev.Path
+		if ev.Source != TIMELINE_SOURCE_FILE {
+			name = fmt.Sprintf("[%s] %s", ev.Source, ev.Message)
+		}
+		name = strings.NewReplacer("|", "¦", "\n", " ", "\r", " ").Replace(name)
+		md5 := ev.MD5
+		if md5 == "" {
+			md5 = "0"
+		}
+		mode := ev.Mode
+		if mode == "" {
+			mode = "0"
+		}
+		// Порядок полей bodyfile: atime, mtime, ctime, crtime
+		if _, err := fmt.Fprintf(w, "%s|%s|0|%s|%d|%d|%d|%d|%d|%d|%d\n", md5, name, mode,
+			max(ev.UID, 0), max(ev.GID, 0), ev.Size, times[1], times[0], times[2], times[3]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timelineDataTypes — значения data_type Timesketch по источнику события.
+var timelineDataTypes = map[string]string{
+	TIMELINE_SOURCE_FILE:     "fs:stat",
+	TIMELINE_SOURCE_LOG:      "text:log:line",
+	TIMELINE_SOURCE_REGISTRY: "windows:registry:key_value",
+	TIMELINE_SOURCE_COMMAND:  "fast_dfar:command",
+	TIMELINE_SOURCE_WMI:      "fast_dfar:wmi",
+	TIMELINE_SOURCE_USN:      "fs:ntfs:usn_change",
+}
+
+// writeTimesketchJSONL выгружает события в JSONL для импорта в Timesketch
+// (обязательные поля message, datetime, timestamp_desc; timestamp — в микросекундах).
+func writeTimesketchJSONL(w io.Writer, events []*TimelineEvent, loc *time.Location) error {
+	enc := json.NewEncoder(w)
+	for _, ev := range events {
+		rec := map[string]interface{}{
+			"message":        ev.Message,
+			"datetime":       ev.Time.In(loc).Format("2006-01-02T15:04:05.000000Z07:00"),
+			"timestamp":      ev.Time.UnixMicro(),
+			"timestamp_desc": ev.Desc,
+			"data_type":      timelineDataTypes[ev.Source],
+			"source_short":   ev.Source,
+			"macb":           ev.MACB,
+			"path":           ev.Path,
+		}
+		if ev.Artifact != "" {
+			rec["artifact"] = ev.Artifact
+		}
+		if ev.Source == TIMELINE_SOURCE_FILE {
+			rec["size"] = ev.Size
+			if ev.MD5 != "" {
+				rec["md5"] = ev.MD5
+			}
+			if ev.Mode != "" {
+				rec["mode"] = ev.Mode
+			}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTimelineCSV выгружает события в CSV с заголовком.
+func writeTimelineCSV(w io.Writer, events []*TimelineEvent, loc *time.Location) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"datetime", "macb", "source", "timestamp_desc", "artifact", "path", "message"}); err != nil {
+		return err
+	}
+	for _, ev := range events {
+		if err := cw.Write([]string{
+			ev.Time.In(loc).Format("2006-01-02T15:04:05.000000Z07:00"),
+			ev.MACB, ev.Source, ev.Desc, ev.Artifact, ev.Path, ev.Message,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
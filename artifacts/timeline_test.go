@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogTimestamp(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*3600)
+	ref := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		line string
+		want time.Time
+	}{
+		{"2024-03-01T10:00:00.250Z service started", time.Date(2024, 3, 1, 10, 0, 0, 250e6, time.UTC)},
+		{"2024-03-01 10:00:00,5 +0100 INFO x", time.Date(2024, 3, 1, 9, 0, 0, 500e6, time.UTC)},
+		{"[2024/03/01 13:00:00] naive", time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+		{"Mar  9 13:00:00 host sshd[1]: Accepted", time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)},
+		// Декабрьская запись syslog в мартовском журнале относится к прошлому году
+		{"Dec 31 23:00:00 host cron[2]: job", time.Date(2023, 12, 31, 20, 0, 0, 0, time.UTC)},
+		{`10.0.0.1 - - [01/Mar/2024:10:00:00 +0000] "GET / HTTP/1.1" 200`, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, ok := parseLogTimestamp(c.line, msk, ref)
+		if assert.True(t, ok, c.line) {
+			assert.True(t, c.want.Equal(got), "%s: %s", c.line, got)
+		}
+	}
+	_, ok := parseLogTimestamp("    at java.lang.Thread.run", msk, ref)
+	assert.False(t, ok)
+}
+
+func TestBuildTimeline(t *testing.T) {
+	const host = "host"
+	dir := filepath.Join(t.TempDir(), "20240310120000-"+host)
+	files := filepath.Join(dir, host+"-files")
+	assert.NoError(t, os.MkdirAll(filepath.Join(files, "var", "log"), 0755))
+
+	var fileInfo bytes.Buffer
+	for _, rec := range []map[string]interface{}{
+		{"@timestamp": "2024-03-10T12:00:00Z", "labels": map[string]string{"artifact": "Bin"}, "file": map[string]interface{}{
+			"path": "/usr/bin/evil", "size": 42, "mode": "-rwxr-xr-x", "uid": 0, "gid": 0,
+			"hash":  map[string]string{"md5": "0123456789abcdef0123456789abcdef"},
+			"mtime": "2024-03-01T10:00:00Z", "ctime": "2024-03-01T10:00:00Z", "accessed": "2024-03-05T08:00:00Z",
+		}},
+		{"@timestamp": "2024-03-10T12:00:00Z", "labels": map[string]string{"artifact": "Logs"}, "file": map[string]interface{}{
+			"path": "/var/log/auth.log", "mtime": "2024-03-09T11:00:00Z",
+		}},
+	} {
+		b, _ := json.Marshal(rec)
+		fileInfo.Write(append(b, '\n'))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, host+"-file_info.jsonl"), fileInfo.Bytes(), 0644))
+
+	stream := func(name string, records ...*streamRecord) {
+		var buf bytes.Buffer
+		for _, rec := range records {
+			b, _ := json.Marshal(rec)
+			buf.Write(append(b, '\n'))
+		}
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, host+"-"+name+".jsonl"), buf.Bytes(), 0644))
+	}
+	reg := func(name string) *streamRecord {
+		return &streamRecord{Timestamp: "2024-03-10T12:00:01Z", Artifact: "Run", Source: `HKEY_LOCAL_MACHINE\Run`,
+			Payload: json.RawMessage(fmt.Sprintf(`{"name":%q,"value":"x","type":"REG_SZ","last_write":"2024-03-02T00:00:00Z"}`, name))}
+	}
+	stream("registry", reg("a"), reg("b"))
+	stream("commands", &streamRecord{Timestamp: "2024-03-10T12:00:02Z", Artifact: "Ps", Source: "ps aux",
+		Payload: json.RawMessage(`"\nUSER PID\nroot 1"`)})
+
+	assert.NoError(t, os.WriteFile(filepath.Join(files, "var", "log", "auth.log"),
+		[]byte("Mar  9 13:00:00 host sshd[1]: Accepted password for root\ncontinuation line\n"), 0644))
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte("2024-02-01T00:00:00Z rotated entry\n"))
+	zw.Close()
+	assert.NoError(t, os.WriteFile(filepath.Join(files, "var", "log", "syslog.2.gz"), gz.Bytes(), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(files, "var", "log", "wtmp"), []byte("2024-01-01T00:00:00Z\x00\x00"), 0644))
+
+	events, err := buildTimeline(dir, host, timelineOptions{Location: time.FixedZone("MSK", 3*3600), Logs: true})
+	assert.NoError(t, err)
+
+	var summary []string
+	for i, ev := range events {
+		if i > 0 {
+			assert.False(t, ev.Time.Before(events[i-1].Time), "события упорядочены по времени")
+		}
+		summary = append(summary, fmt.Sprintf("%s %s %s %s", ev.Time.Format(time.RFC3339), ev.Source, ev.MACB, ev.Path))
+	}
+	assert.Equal(t, []string{
+		"2024-02-01T00:00:00Z LOG .... var/log/syslog.2.gz",
+		"2024-03-01T10:00:00Z FILE m.c. /usr/bin/evil",
+		`2024-03-02T00:00:00Z REG m... HKEY_LOCAL_MACHINE\Run`,
+		"2024-03-05T08:00:00Z FILE .a.. /usr/bin/evil",
+		"2024-03-09T10:00:00Z LOG .... /var/log/auth.log",
+		"2024-03-09T11:00:00Z FILE m... /var/log/auth.log",
+		"2024-03-10T12:00:02Z CMD .... ps aux",
+	}, summary)
+	assert.Equal(t, "ps aux: USER PID", events[6].Message)
+	assert.Equal(t, "Logs", events[4].Artifact)
+
+	var body bytes.Buffer
+	assert.NoError(t, writeBodyfile(&body, events, time.UTC))
+	lines := strings.Split(strings.TrimSpace(body.String()), "\n")
+	assert.Len(t, lines, len(events))
+	assert.Equal(t, fmt.Sprintf("0123456789abcdef0123456789abcdef|/usr/bin/evil|0|-rwxr-xr-x|0|0|42|0|%d|%d|0",
+		events[1].Time.Unix(), events[1].Time.Unix()), lines[1])
+	assert.Contains(t, lines[2], `[REG] Registry key HKEY_LOCAL_MACHINE\Run last written`)
+
+	msk := time.FixedZone("MSK", 3*3600)
+	var js bytes.Buffer
+	assert.NoError(t, writeTimesketchJSONL(&js, events, msk))
+	var first map[string]interface{}
+	assert.NoError(t, json.Unmarshal(bytes.SplitN(js.Bytes(), []byte("\n"), 2)[0], &first))
+	assert.Equal(t, "2024-02-01T03:00:00.000000+03:00", first["datetime"])
+	assert.Equal(t, float64(events[0].Time.UnixMicro()), first["timestamp"])
+	assert.Equal(t, "Log Entry Time", first["timestamp_desc"])
+	assert.Equal(t, "2024-02-01T00:00:00Z rotated entry", first["message"])
+
+	var cs bytes.Buffer
+	assert.NoError(t, writeTimelineCSV(&cs, events, time.UTC))
+	rows, err := csv.NewReader(&cs).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, len(events)+1)
+	assert.Equal(t, []string{"2024-03-01T10:00:00.000000Z", "m.c.", "FILE", "Modification Time; Metadata Change Time", "Bin", "/usr/bin/evil", "/usr/bin/evil [m.c.]"}, rows[2])
+}
+
+func TestRunTimeline(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "20240310120000-host")
+	assert.NoError(t, os.MkdirAll(dir, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "host-file_info.jsonl"),
+		[]byte(`{"file":{"path":"/etc/passwd","mtime":"2024-03-01T10:00:00Z"}}`+"\n"), 0644))
+
+	assert.Equal(t, 2, runTimeline([]string{"-format", "xml", dir}))
+	assert.Equal(t, 2, runTimeline([]string{"-timezone", "Mars/Olympus", dir}))
+	assert.Equal(t, 0, runTimeline([]string{"-format", "body,csv", dir}))
+	assert.FileExists(t, filepath.Join(dir, "host-timeline.body"))
+	assert.FileExists(t, filepath.Join(dir, "host-timeline.csv"))
+	assert.NoFileExists(t, filepath.Join(dir, "host-timeline.jsonl"))
+}
@@ -3,6 +3,11 @@
 
 package main
 
+import (
+	"fmt"
+	"runtime"
+)
+
 // Заглушка для windowsInitFunc для Unix: принимает *HostVariables и возвращает nil.
 func windowsInitFunc(*HostVariables) {
 	// Ничего не делаем.
@@ -25,7 +30,7 @@ func (d dummyCollector) RegisterSource(artifactDefinition *ArtifactDefinition, a
 	return false
 }
 
-// readVersionInfo-заглушка для non-Windows
-func readVersionInfo(path string) (map[string]string, error) {
-	return map[string]string{}, nil
+// Заглушка для lookupRegistryIndicator: реестр доступен только в Windows.
+func lookupRegistryIndicator(fullKey, valueName string) ([]map[string]interface{}, error) {
+	return nil, fmt.Errorf("registry is not available on %s", runtime.GOOS)
 }
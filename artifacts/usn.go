@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Журнал изменений NTFS ($Extend\$UsnJrnl, поток $J): последовательность записей
+// USN_RECORD версий 2 и 3, выровненных по 8 байт. Начало потока обычно разрежено —
+// старые страницы журнала освобождены и читаются нулями; нули пропускаются.
+const (
+	USN_MIN_RECORD  = 60
+	USN_MAX_RECORD  = 64 << 10
+	USN_ZERO_WINDOW = 64 << 10
+)
+
+// usnReasons — флаги причины изменения USN_REASON_*.
+var usnReasons = []struct {
+	bit  uint32
+	name string
+}{
+	{0x00000001, "DATA_OVERWRITE"},
+	{0x00000002, "DATA_EXTEND"},
+	{0x00000004, "DATA_TRUNCATION"},
+	{0x00000010, "NAMED_DATA_OVERWRITE"},
+	{0x00000020, "NAMED_DATA_EXTEND"},
+	{0x00000040, "NAMED_DATA_TRUNCATION"},
+	{0x00000100, "FILE_CREATE"},
+	{0x00000200, "FILE_DELETE"},
+	{0x00000400, "EA_CHANGE"},
+	{0x00000800, "SECURITY_CHANGE"},
+	{0x00001000, "RENAME_OLD_NAME"},
+	{0x00002000, "RENAME_NEW_NAME"},
+	{0x00004000, "INDEXABLE_CHANGE"},
+	{0x00008000, "BASIC_INFO_CHANGE"},
+	{0x00010000, "HARD_LINK_CHANGE"},
+	{0x00020000, "COMPRESSION_CHANGE"},
+	{0x00040000, "ENCRYPTION_CHANGE"},
+	{0x00080000, "OBJECT_ID_CHANGE"},
+	{0x00100000, "REPARSE_POINT_CHANGE"},
+	{0x00200000, "STREAM_CHANGE"},
+	{0x00400000, "TRANSACTED_CHANGE"},
+	{0x00800000, "INTEGRITY_CHANGE"},
+	{0x80000000, "CLOSE"},
+}
+
+// usnSources — флаги источника изменения USN_SOURCE_*.
+var usnSources = []struct {
+	bit  uint32
+	name string
+}{
+	{0x00000001, "DATA_MANAGEMENT"},
+	{0x00000002, "AUXILIARY_DATA"},
+	{0x00000004, "REPLICATION_MANAGEMENT"},
+	{0x00000008, "CLIENT_REPLICATION_MANAGEMENT"},
+}
+
+// USNRecord — запись журнала изменений.
+type USNRecord struct {
+	Offset         int64
+	MajorVersion   uint16
+	USN            int64
+	Time           time.Time
+	FileRecord     uint64
+	FileSequence   uint16
+	ParentRecord   uint64
+	ParentSequence uint16
+	Reason         uint32
+	SourceInfo     uint32
+	SecurityID     uint32
+	Attributes     uint32
+	Name           string
+}
+
+// Reasons возвращает имена флагов причины изменения.
+func (u *USNRecord) Reasons() []string {
+	names := []string{}
+	for _, r := range usnReasons {
+		if u.Reason&r.bit != 0 {
+			names = append(names, r.name)
+		}
+	}
+	return names
+}
+
+// ParseUSNRecord разбирает запись USN_RECORD_V2 или USN_RECORD_V3. В версии 3 ссылки
+// на файлы 128-битные; для NTFS значимы младшие 64 бита.
+func ParseUSNRecord(data []byte) (*USNRecord, error) {
+	if len(data) < USN_MIN_RECORD {
+		return nil, fmt.Errorf("USN record truncated")
+	}
+	le := binary.LittleEndian
+	u := &USNRecord{MajorVersion: le.Uint16(data[4:])}
+	var fileRef, parentRef uint64
+	var off int
+	switch u.MajorVersion {
+	case 2:
+		fileRef, parentRef, off = le.Uint64(data[8:]), le.Uint64(data[16:]), 24
+	case 3:
+		if len(data) < 76 {
+			return nil, fmt.Errorf("USN record truncated")
+		}
+		fileRef, parentRef, off = le.Uint64(data[8:]), le.Uint64(data[24:]), 40
+	default:
+		return nil, fmt.Errorf("unsupported USN record version %d", u.MajorVersion)
+	}
+	u.FileRecord, u.FileSequence = fileRef&0xFFFFFFFFFFFF, uint16(fileRef>>48)
+	u.ParentRecord, u.ParentSequence = parentRef&0xFFFFFFFFFFFF, uint16(parentRef>>48)
+	u.USN = int64(le.Uint64(data[off:]))
+	u.Time = filetimeToTime(le.Uint64(data[off+8:]))
+	u.Reason = le.Uint32(data[off+16:])
+	u.SourceInfo = le.Uint32(data[off+20:])
+	u.SecurityID = le.Uint32(data[off+24:])
+	u.Attributes = le.Uint32(data[off+28:])
+	nameLen, nameOff := int(le.Uint16(data[off+32:])), int(le.Uint16(data[off+34:]))
+	if nameOff+nameLen > len(data) {
+		return u, fmt.Errorf("USN record name out of record")
+	}
+	u.Name = decodeUTF16Full(data[nameOff : nameOff+nameLen])
+	return u, nil
+}
+
+// ReadUSNJournal последовательно читает записи потока $J, не загружая его в память.
+// Нулевые области пропускаются, а после повреждённой записи разбор продолжается со
+// следующей позиции, выровненной по 8 байт. Записи версии 4 (диапазоны изменений
+// без имени файла) пропускаются.
+func ReadUSNJournal(r io.Reader, emit func(rec *USNRecord) error) error {
+	br := bufio.NewReaderSize(r, 2*USN_MAX_RECORD)
+	le := binary.LittleEndian
+	var offset int64
+	skip := func(n int) error {
+		d, err := br.Discard(n)
+		offset += int64(d)
+		return err
+	}
+	for {
+		head, _ := br.Peek(8)
+		if len(head) < 8 {
+			return nil
+		}
+		length := int(le.Uint32(head))
+		if length == 0 {
+			// Разреженная область: пропускаем нули до первого ненулевого 8-байтового слова
+			window, _ := br.Peek(USN_ZERO_WINDOW)
+			n := len(window) &^ 7
+			for i := 0; i < n; i += 8 {
+				if le.Uint64(window[i:]) != 0 {
+					n = i
+					break
+				}
+			}
+			if n == 0 {
+				n = 8
+			}
+			if err := skip(n); err != nil {
+				return nil
+			}
+			continue
+		}
+		major := le.Uint16(head[4:])
+		if length < USN_MIN_RECORD || length > USN_MAX_RECORD || length%8 != 0 || major < 2 || major > 4 {
+			if err := skip(8); err != nil {
+				return nil
+			}
+			continue
+		}
+		data, err := br.Peek(length)
+		if err != nil {
+			return fmt.Errorf("USN record at offset %d truncated", offset)
+		}
+		if major != 4 {
+			if rec, err := ParseUSNRecord(data); err == nil {
+				rec.Offset = offset
+				if err := emit(rec); err != nil {
+					return err
+				}
+			}
+		}
+		if err := skip(length); err != nil {
+			return nil
+		}
+	}
+}
+
+// AsDict возвращает запись для JSONL: имя и ссылка на запись MFT изменённого файла
+// в file, причины и источник изменения в usn.
+func (u *USNRecord) AsDict(source string) map[string]interface{} {
+	sources := []string{}
+	for _, s := range usnSources {
+		if u.SourceInfo&s.bit != 0 {
+			sources = append(sources, s.name)
+		}
+	}
+	record := map[string]interface{}{
+		"event": map[string]interface{}{"kind": "event", "category": "file", "action": "usn-journal"},
+		"file": map[string]interface{}{
+			"name":       u.Name,
+			"inode":      fmt.Sprintf("%d-%d", u.FileRecord, u.FileSequence),
+			"attributes": ntfsAttributeNames(u.Attributes),
+		},
+		"log": map[string]interface{}{"file": map[string]interface{}{"path": source}},
+		"usn": map[string]interface{}{
+			"usn":             u.USN,
+			"offset":          u.Offset,
+			"version":         u.MajorVersion,
+			"reason":          u.Reasons(),
+			"source_info":     sources,
+			"file_record":     u.FileRecord,
+			"file_sequence":   u.FileSequence,
+			"parent_record":   u.ParentRecord,
+			"parent_sequence": u.ParentSequence,
+			"security_id":     u.SecurityID,
+		},
+	}
+	if !u.Time.IsZero() {
+		record["@timestamp"] = formatTime(u.Time)
+	}
+	return record
+}
+
+// ----------------------------------------------------------------------
+// usnParser – разбор журнала изменений как ArtifactParser
+// ----------------------------------------------------------------------
+
+type usnParser struct{}
+
+func newUSNParser(opts parserOptions) (ArtifactParser, error) {
+	return &usnParser{}, nil
+}
+
+func (p *usnParser) Name() string { return PARSER_USN }
+
+// Match принимает $UsnJrnl (при сборе через NTFSFileSystem читается первый поток данных —
+// $J), а также поток, выгруженный под именем $J или $UsnJrnl:$J.
+func (p *usnParser) Match(path string) bool {
+	name := strings.ToUpper(filepath.Base(strings.ReplaceAll(path, `\`, "/")))
+	return name == "$USNJRNL" || name == "$J" || name == "$USNJRNL:$J"
+}
+
+func (p *usnParser) Parse(path string, r io.Reader, emit func(record map[string]interface{}) error) error {
+	return ReadUSNJournal(r, func(rec *USNRecord) error {
+		return emit(rec.AsDict(path))
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// buildTestUSNRecord собирает запись USN_RECORD_V2 или USN_RECORD_V3.
+func buildTestUSNRecord(version uint16, usn int64, ts time.Time, file, parent uint64, reason uint32, name string) []byte {
+	le := binary.LittleEndian
+	parentOff, off := 16, 24
+	if version == 3 {
+		parentOff, off = 24, 40
+	}
+	nameBytes := testUTF16(name)
+	rec := make([]byte, (off+36+len(nameBytes)+7)&^7)
+	le.PutUint32(rec, uint32(len(rec)))
+	le.PutUint16(rec[4:], version)
+	le.PutUint64(rec[8:], file)
+	le.PutUint64(rec[parentOff:], parent)
+	le.PutUint64(rec[off:], uint64(usn))
+	le.PutUint64(rec[off+8:], timeToFiletime(ts))
+	le.PutUint32(rec[off+16:], reason)
+	le.PutUint32(rec[off+28:], 0x20)
+	le.PutUint16(rec[off+32:], uint16(len(nameBytes)))
+	le.PutUint16(rec[off+34:], uint16(off+36))
+	copy(rec[off+36:], nameBytes)
+	return rec
+}
+
+func TestReadUSNJournal(t *testing.T) {
+	t0 := time.Date(2024, 6, 7, 8, 9, 10, 0, time.UTC)
+	var data []byte
+	// Разреженное начало журнала
+	data = append(data, make([]byte, 3*USN_ZERO_WINDOW+16)...)
+	data = append(data, buildTestUSNRecord(2, 1000, t0, 31|2<<48, 30|1<<48, 0x100, "payload.exe")...)
+	// Мусор, после которого разбор продолжается
+	data = append(data, bytes.Repeat([]byte{0xEE}, 16)...)
+	data = append(data, buildTestUSNRecord(3, 1100, t0.Add(time.Minute), 31|2<<48, 30|1<<48, 0x80000200, "payload.exe")...)
+	data = append(data, make([]byte, 40)...)
+
+	var records []*USNRecord
+	assert.NoError(t, ReadUSNJournal(bytes.NewReader(data), func(rec *USNRecord) error {
+		records = append(records, rec)
+		return nil
+	}))
+	if !assert.Len(t, records, 2) {
+		return
+	}
+	assert.Equal(t, int64(3*USN_ZERO_WINDOW+16), records[0].Offset)
+	assert.Equal(t, "payload.exe", records[0].Name)
+	assert.Equal(t, uint64(31), records[0].FileRecord)
+	assert.Equal(t, uint16(2), records[0].FileSequence)
+	assert.Equal(t, uint64(30), records[0].ParentRecord)
+	assert.Equal(t, t0, records[0].Time)
+	assert.Equal(t, []string{"FILE_CREATE"}, records[0].Reasons())
+	assert.Equal(t, uint16(3), records[1].MajorVersion)
+	assert.Equal(t, int64(1100), records[1].USN)
+	assert.Equal(t, []string{"FILE_DELETE", "CLOSE"}, records[1].Reasons())
+
+	m := records[1].AsDict(`C:\$Extend\$UsnJrnl`)
+	assert.Equal(t, "2024-06-07T08:10:10Z", m["@timestamp"])
+	assert.Equal(t, "31-2", m["file"].(map[string]interface{})["inode"])
+	assert.Equal(t, []string{"archive"}, m["file"].(map[string]interface{})["attributes"])
+
+	// Обрезанная запись в конце потока
+	rec := buildTestUSNRecord(2, 1200, t0, 1, 5, 0x2, "x.txt")
+	err := ReadUSNJournal(bytes.NewReader(rec[:len(rec)-8]), func(*USNRecord) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestUSNParser(t *testing.T) {
+	p, err := newUSNParser(parserOptions{})
+	assert.NoError(t, err)
+	assert.True(t, p.Match(`C:\$Extend\$UsnJrnl`))
+	assert.True(t, p.Match(`/evidence/$UsnJrnl:$J`))
+	assert.True(t, p.Match(`/evidence/$J`))
+	assert.False(t, p.Match(`C:\$Extend\$ObjId`))
+}
@@ -0,0 +1,228 @@
+// File: verdict_cache.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const VERDICT_CACHE_FILE = "verdicts.jsonl"
+
+// verdictCacheEntry is one line of the cache file.
+type verdictCacheEntry struct {
+	Key    string          `json:"key"`
+	Time   time.Time       `json:"time"`
+	Result *ProviderResult `json:"result"`
+}
+
+// VerdictCache is an on-disk cache of provider verdicts shared across runs.
+// New verdicts are appended to a JSONL file as they arrive; entries older than the TTL are ignored
+// on load and dropped when the cache is compacted on Close.
+type VerdictCache struct {
+	mu      sync.Mutex
+	path    string
+	ttl     time.Duration
+	entries map[string]verdictCacheEntry
+	file    *os.File
+	now     func() time.Time
+}
+
+// defaultVerdictCachePath returns the cache location in the user cache directory.
+func defaultVerdictCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "fast_dfar", VERDICT_CACHE_FILE)
+}
+
+// OpenVerdictCache loads the cache file at path (created if missing) keeping entries younger than ttl.
+func OpenVerdictCache(path string, ttl time.Duration) (*VerdictCache, error) {
+	if path == "" {
+		path = defaultVerdictCachePath()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	c := &VerdictCache{
+		path:    path,
+		ttl:     ttl,
+		entries: make(map[string]verdictCacheEntry),
+		now:     time.Now,
+	}
+	err := readJSONL(path, func(line []byte) error {
+		var e verdictCacheEntry
+		if err := json.Unmarshal(line, &e); err != nil || e.Result == nil {
+			// A truncated last line after a crash must not invalidate the whole cache.
+			return nil
+		}
+		if c.fresh(e) {
+			c.entries[e.Key] = e
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	c.file = f
+	return c, nil
+}
+
+func (c *VerdictCache) fresh(e verdictCacheEntry) bool {
+	return c.ttl <= 0 || c.now().Sub(e.Time) < c.ttl
+}
+
+// Len returns the number of cached verdicts.
+func (c *VerdictCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// Get returns a copy of the cached result for key if it has not expired.
+func (c *VerdictCache) Get(key string) (*ProviderResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !c.fresh(e) {
+		return nil, false
+	}
+	r := *e.Result
+	return &r, true
+}
+
+// Put stores a result and appends it to the cache file.
+func (c *VerdictCache) Put(key string, r *ProviderResult) {
+	cp := *r
+	cp.Cached = false
+	e := verdictCacheEntry{Key: key, Time: c.now().UTC(), Result: &cp}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+	if c.file == nil {
+		return
+	}
+	b, err := json.Marshal(e)
+	if err == nil {
+		_, err = c.file.Write(append(b, '\n'))
+	}
+	if err != nil {
+		logger.Log(LevelWarning, fmt.Sprintf("Failed to write verdict cache %s: %v", c.path, err))
+	}
+}
+
+// Close compacts the cache file to its unexpired entries and closes it.
+func (c *VerdictCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	if err := c.file.Close(); err != nil {
+		return err
+	}
+	c.file = nil
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), VERDICT_CACHE_FILE+".*")
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(tmp)
+	for _, e := range c.entries {
+		if !c.fresh(e) {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+// lookupCall is a lookup in progress; concurrent lookups of the same hash wait for it.
+type lookupCall struct {
+	done chan struct{}
+	res  *ProviderResult
+	err  error
+}
+
+// cachedProvider wraps a provider with deduplication and the optional persistent cache:
+// a hash seen in many paths during a run is looked up once, and verdicts from previous runs
+// are reused until they expire.
+type cachedProvider struct {
+	Provider
+	cache *VerdictCache
+	mu    sync.Mutex
+	calls map[string]*lookupCall
+}
+
+// NewCachedProvider wraps p; cache may be nil to deduplicate only within the run.
+func NewCachedProvider(p Provider, cache *VerdictCache) Provider {
+	if cp, ok := p.(*cachedProvider); ok {
+		p = cp.Provider
+	}
+	return &cachedProvider{Provider: p, cache: cache, calls: make(map[string]*lookupCall)}
+}
+
+// Lookup returns the verdict for the strongest available hash, querying the provider at most once per hash.
+func (p *cachedProvider) Lookup(hashes map[string]string) (*ProviderResult, error) {
+	kind, h := preferredHash(hashes)
+	if h == "" {
+		return p.Provider.Lookup(hashes)
+	}
+	key := p.Name() + ":" + kind + ":" + strings.ToLower(h)
+
+	p.mu.Lock()
+	if call, ok := p.calls[key]; ok {
+		p.mu.Unlock()
+		<-call.done
+		return copyResult(call.res), call.err
+	}
+	call := &lookupCall{done: make(chan struct{})}
+	p.calls[key] = call
+	p.mu.Unlock()
+
+	defer close(call.done)
+	if p.cache != nil {
+		if r, ok := p.cache.Get(key); ok {
+			r.Cached = true
+			call.res = r
+			return copyResult(r), nil
+		}
+	}
+	call.res, call.err = p.Provider.Lookup(hashes)
+	if call.err != nil {
+		// Failed lookups (quota, network) are retried for the next path with the same hash.
+		p.mu.Lock()
+		delete(p.calls, key)
+		p.mu.Unlock()
+		return nil, call.err
+	}
+	if p.cache != nil {
+		p.cache.Put(key, call.res)
+	}
+	return copyResult(call.res), nil
+}
+
+func copyResult(r *ProviderResult) *ProviderResult {
+	if r == nil {
+		return nil
+	}
+	cp := *r
+	return &cp
+}
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/windows/registry"
 )
@@ -226,6 +227,19 @@ func (r *RegistryReader) GetKeyValue(p *PathObject, valueName string) map[string
 	return nil
 }
 
+// LastWriteTime возвращает время последней записи открытого ключа.
+func (r *RegistryReader) LastWriteTime(p *PathObject) time.Time {
+	key, ok := p.obj.(registry.Key)
+	if !ok {
+		return time.Time{}
+	}
+	info, err := key.Stat()
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
 func (r *RegistryReader) normalizeValue(val interface{}) interface{} {
 	if _, err := json.Marshal(val); err != nil {
 		return fmt.Sprintf("%v", val)
@@ -249,6 +263,30 @@ func getHiveKey(hive string) (registry.Key, error) {
 	return 0, fmt.Errorf("unknown hive: %s", hive)
 }
 
+// lookupRegistryIndicator ищет ключи по шаблону fullKey (допускаются * и **) и, если задано
+// имя значения, само значение в найденных ключах.
+func lookupRegistryIndicator(fullKey, valueName string) ([]map[string]interface{}, error) {
+	hive := extractHive(fullKey)
+	if _, err := getHiveKey(hive); err != nil {
+		return nil, err
+	}
+	reader := NewRegistryReader(hive, extractSubpath(fullKey))
+	defer reader.Close()
+	var found []map[string]interface{}
+	for po := range reader.keysToCollect() {
+		if valueName == "" {
+			found = append(found, map[string]interface{}{"key": po.path})
+			continue
+		}
+		if kv := reader.GetKeyValue(po, valueName); kv != nil {
+			kv["key"] = po.path
+			kv["name"] = valueName
+			found = append(found, kv)
+		}
+	}
+	return found, nil
+}
+
 // ----------------------------------------------------------------------
 // RegistryCollector – сбор данных реестра
 // ----------------------------------------------------------------------
@@ -317,26 +355,38 @@ func (rc *RegistryCollector) Collect(output *Outputs) {
 	// ключи
 	for _, e := range rc.keys {
 		reader := NewRegistryReader(e["hive"], e["key"])
+		found := false
 		for po := range reader.keysToCollect() {
+			found = true
 			for triple := range reader.GetKeyValues(po) {
 				name := triple[0].(string)
 				val := triple[1]
 				typStr := fmt.Sprintf("%v", triple[2])
-				output.AddCollectedRegistryValue(e["artifact"], po.path, name, val, typStr)
+				output.AddCollectedRegistryValue(e["artifact"], po.path, name, val, typStr, reader.LastWriteTime(po))
 			}
 		}
+		if !found {
+			output.AddCollectionError(e["artifact"], TYPE_INDICATOR_WINDOWS_REGISTRY_KEY,
+				e["hive"]+`\`+e["key"], REASON_NOT_FOUND, nil)
+		}
 		reader.Close()
 	}
 	// значения
 	for _, e := range rc.values {
 		reader := NewRegistryReader(e["hive"], e["key"])
+		found := false
 		for po := range reader.keysToCollect() {
 			if kv := reader.GetKeyValue(po, e["value"]); kv != nil {
+				found = true
 				val := kv["value"]
 				typStr := fmt.Sprintf("%v", kv["type"])
-				output.AddCollectedRegistryValue(e["artifact"], po.path, e["value"], val, typStr)
+				output.AddCollectedRegistryValue(e["artifact"], po.path, e["value"], val, typStr, reader.LastWriteTime(po))
 			}
 		}
+		if !found {
+			output.AddCollectionError(e["artifact"], TYPE_INDICATOR_WINDOWS_REGISTRY_VALUE,
+				e["hive"]+`\`+e["key"]+`\`+e["value"], REASON_NOT_FOUND, nil)
+		}
 		reader.Close()
 	}
 }
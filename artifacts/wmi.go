@@ -98,6 +98,7 @@ func (w *WMIExecutor) Collect(output *Outputs) {
 		if query == "" || query == "{}" {
 			logger.Log(LevelWarning,
 				fmt.Sprintf("Empty or invalid WMI query for artifact '%s', skipping", q.Artifact))
+			output.AddCollectionError(q.Artifact, TYPE_INDICATOR_WMI, query, REASON_INVALID_SOURCE, nil)
 			continue
 		}
 
@@ -121,12 +122,15 @@ func (w *WMIExecutor) Collect(output *Outputs) {
 		if err != nil {
 			logger.Log(LevelWarning,
 				fmt.Sprintf("WMI PS query ultimately failed for artifact '%s': %v", q.Artifact, err))
+			output.AddCollectionError(q.Artifact, TYPE_INDICATOR_WMI, query, REASON_QUERY_FAILED, err)
 			continue
 		}
 
 		if !json.Valid([]byte(raw)) {
 			logger.Log(LevelWarning,
 				fmt.Sprintf("WMI PS output is not valid JSON for artifact '%s', skipping", q.Artifact))
+			output.AddCollectionError(q.Artifact, TYPE_INDICATOR_WMI, query, REASON_PARSE_ERROR,
+				fmt.Errorf("output is not valid JSON"))
 			continue
 		}
 		if !strings.HasPrefix(raw, "[") {
@@ -25,7 +25,6 @@ require (
 	github.com/mitchellh/mapstructure v1.4.1 // indirect
 	github.com/pelletier/go-toml v1.2.0 // indirect
 	github.com/pierrec/lz4/v4 v4.1.17 // indirect
-	github.com/pkg/xattr v0.4.9 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/secDre4mer/pkcs7 v0.0.0-20240322103146-665324a4461d // indirect
 	github.com/spf13/afero v1.1.2 // indirect
@@ -34,17 +33,19 @@ require (
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/spf13/viper v1.6.2 // indirect
 	github.com/subosito/gotenv v1.2.0 // indirect
+	github.com/tklauser/go-sysconf v0.3.15 // indirect
+	github.com/tklauser/numcpus v0.10.0 // indirect
 	github.com/ulikunitz/xz v0.5.11 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	go.mozilla.org/pkcs7 v0.0.0-20210826202110-33d05740a352 // indirect
 	gopkg.in/yaml.v2 v2.3.0 // indirect
-	www.velocidex.com/golang/go-ntfs v0.1.1 // indirect
 )
 
 require (
 	github.com/Codehardt/go-pefile v1.0.2
 	github.com/diskfs/go-diskfs v1.6.0
 	github.com/forensicanalysis/fslib v0.15.2
+	github.com/glaslos/ssdeep v0.4.0
+	github.com/pkg/xattr v0.4.9
 	github.com/rabbitstack/fibratus v1.10.0
 	github.com/saferwall/pe v1.5.6
 	github.com/saferwall/saferwall/pkg/peparser v0.1.0
@@ -52,8 +53,10 @@ require (
 	github.com/shirou/gopsutil v3.21.11+incompatible
 	github.com/sirupsen/logrus v1.9.4-0.20230606125235-dd1b4c2e81af
 	github.com/stretchr/testify v1.10.0
+	go.mozilla.org/pkcs7 v0.0.0-20210826202110-33d05740a352
 	golang.org/x/sys v0.32.0
 	golang.org/x/text v0.24.0
 	gopkg.in/ini.v1 v1.67.0
+	www.velocidex.com/golang/go-ntfs v0.1.1
 
 )